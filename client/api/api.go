@@ -0,0 +1,7 @@
+// Package api is the typed client for the Nimbus REST API, generated from the
+// server's OpenAPI spec (server/openapi/openapi.yaml). Don't edit api.gen.go;
+// change the spec and run `go generate ./api` instead. CI fails when the
+// generated code is out of date.
+package api
+
+//go:generate go tool oapi-codegen -config oapi-codegen.yaml ../../server/openapi/openapi.yaml
@@ -0,0 +1,162 @@
+// Package auth keeps the CLI's login session alive. Access tokens from the
+// API expire after 15 minutes; the HTTP client returned by NewClient notices
+// the resulting 401, swaps the session's refresh token for a new pair and
+// retries the request once, so commands never see the expiry. It also tags
+// each request with the command's trace (see NewTrace).
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nimbus/cli/cache"
+	"github.com/nimbus/cli/config"
+)
+
+// ErrSessionExpired means the refresh token was rejected (expired, revoked or
+// missing) and the user has to run "nim login" again.
+var ErrSessionExpired = errors.New("your session has expired, please login again")
+
+// lockWait is how long Refresh waits for another process that is already
+// refreshing the session.
+const lockWait = 10 * time.Second
+
+// NewClient returns an HTTP client that refreshes the session and retries
+// once when an authenticated request comes back 401. timeout is the usual
+// http.Client timeout; 0 means none.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: &Transport{}}
+}
+
+// Transport is an http.RoundTripper that handles expired access tokens. Only
+// requests carrying a session JWT in "Authorization: Bearer" are retried;
+// personal access tokens (NIM_TOKEN) can't be refreshed. Requests without a
+// traceparent header get one.
+type Transport struct {
+	// Base sends the requests; nil means http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = withTraceparent(req)
+	resp, err := t.base().RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || config.Token != "" {
+		return resp, err
+	}
+	stale, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || stale == "" {
+		return resp, nil
+	}
+	// A body that has already been read can only be sent again if the request
+	// knows how to recreate it; NewRequest sets GetBody for in-memory bodies.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	token, err := Refresh(stale)
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return t.base().RoundTrip(retry)
+}
+
+// Refresh exchanges the session's refresh token for a new access token and
+// stores both in the session. stale is the access token that was rejected: if
+// the session already holds a different one, another process has refreshed
+// in the meantime and that token is returned instead.
+func Refresh(stale string) (string, error) {
+	rdb, err := cache.NewRedisClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to create Redis client: %w", err)
+	}
+	defer func() { _ = rdb.Close() }()
+
+	deadline := time.Now().Add(lockWait)
+	for {
+		locked, err := cache.LockRefresh(rdb)
+		if err != nil {
+			return "", err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", errors.New("timed out waiting for another nim process to refresh the session")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	defer func() { _ = cache.UnlockRefresh(rdb) }()
+
+	if current, err := cache.GetAuthToken(rdb); err != nil {
+		return "", ErrSessionExpired
+	} else if current != stale {
+		return current, nil
+	}
+	refreshToken, err := cache.GetRefreshToken(rdb)
+	if err != nil || refreshToken == "" {
+		return "", ErrSessionExpired
+	}
+
+	payload, err := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.BaseURL+"/v1/api/auth/refresh", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error contacting server: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// The refresh token is dead for good; drop the session so commands
+		// report "not logged in" instead of failing with 401s.
+		_ = cache.ClearAuthToken(rdb)
+		return "", ErrSessionExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to refresh session: %s", resp.Status)
+	}
+
+	var result struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to parse refresh response: %w", err)
+	}
+	if err := cache.UpdateTokens(rdb, result.Token, result.RefreshToken); err != nil {
+		return "", fmt.Errorf("failed to save refreshed session: %w", err)
+	}
+	return result.Token, nil
+}
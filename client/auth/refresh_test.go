@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nimbus/cli/cache"
+	"github.com/nimbus/cli/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeAPI answers /v1/api/auth/refresh and /echo. /echo returns 401 unless
+// the request carries the current access token, and echoes the body.
+type fakeAPI struct {
+	access     string
+	refresh    string
+	refreshes  int
+	rejectAuth bool
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/api/auth/refresh":
+		f.refreshes++
+		var req struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if f.rejectAuth || req.RefreshToken != f.refresh {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.access, f.refresh = "access-2", "refresh-2"
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": f.access, "refresh_token": f.refresh})
+	case "/echo":
+		if r.Header.Get("Authorization") != "Bearer "+f.access {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.Copy(w, r.Body)
+	}
+}
+
+func startFakeAPI(t *testing.T, f *fakeAPI) string {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	prev := config.BaseURL
+	config.BaseURL = srv.URL
+	t.Cleanup(func() { config.BaseURL = prev })
+	return srv.URL
+}
+
+// newSession stores a session with the given tokens, skipping the test if
+// Redis is not reachable.
+func newSession(t *testing.T, access, refresh string) *redis.Client {
+	t.Helper()
+	rdb, err := cache.NewRedisClient()
+	if err != nil {
+		t.Skipf("Redis not available, skipping: %v", err)
+	}
+	t.Cleanup(func() {
+		rdb.Del(context.Background(), "user:session", "user:session:refresh-lock")
+		rdb.Close()
+	})
+	if err := cache.SetAuthToken(rdb, 1, "a@b.com", nil, access); err != nil {
+		t.Fatalf("SetAuthToken: %v", err)
+	}
+	if err := cache.SetRefreshToken(rdb, refresh); err != nil {
+		t.Fatalf("SetRefreshToken: %v", err)
+	}
+	return rdb
+}
+
+func echo(t *testing.T, url, token, body string) (*http.Response, error) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url+"/echo", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	return NewClient(0).Do(req)
+}
+
+func TestTransport_PassesThroughWithoutRefresh(t *testing.T) {
+	f := &fakeAPI{access: "access-1"}
+	url := startFakeAPI(t, f)
+
+	resp, err := echo(t, url, "access-1", "hello")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || f.refreshes != 0 {
+		t.Errorf("status %d, refreshes %d; want 200 and no refresh", resp.StatusCode, f.refreshes)
+	}
+}
+
+func TestTransport_SkipsRefreshWithNIM_TOKEN(t *testing.T) {
+	f := &fakeAPI{access: "access-1"}
+	url := startFakeAPI(t, f)
+	config.Token = "nim_pat_test"
+	t.Cleanup(func() { config.Token = "" })
+
+	resp, err := echo(t, url, "nim_pat_test", "hello")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || f.refreshes != 0 {
+		t.Errorf("status %d, refreshes %d; want the 401 untouched", resp.StatusCode, f.refreshes)
+	}
+}
+
+func TestTransport_RefreshesAndRetriesOnce(t *testing.T) {
+	f := &fakeAPI{access: "access-live", refresh: "refresh-1"}
+	url := startFakeAPI(t, f)
+	rdb := newSession(t, "access-expired", "refresh-1")
+
+	resp, err := echo(t, url, "access-expired", "hello")
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Fatalf("retry got %d %q, want 200 with the original body", resp.StatusCode, body)
+	}
+	if f.refreshes != 1 {
+		t.Errorf("expected 1 refresh, got %d", f.refreshes)
+	}
+
+	if token, _ := cache.GetAuthToken(rdb); token != "access-2" {
+		t.Errorf("session token = %q, want access-2", token)
+	}
+	if token, _ := cache.GetRefreshToken(rdb); token != "refresh-2" {
+		t.Errorf("session refresh token = %q, want refresh-2", token)
+	}
+}
+
+func TestRefresh_UsesTokenFromAnotherProcess(t *testing.T) {
+	f := &fakeAPI{refresh: "refresh-1"}
+	startFakeAPI(t, f)
+	newSession(t, "access-new", "refresh-1")
+
+	// The session already holds a newer token than the one that failed.
+	token, err := Refresh("access-old")
+	if err != nil || token != "access-new" {
+		t.Errorf("Refresh = %q, %v; want the session's token", token, err)
+	}
+	if f.refreshes != 0 {
+		t.Errorf("expected no refresh call, got %d", f.refreshes)
+	}
+}
+
+func TestRefresh_RejectedClearsSession(t *testing.T) {
+	f := &fakeAPI{refresh: "refresh-1", rejectAuth: true}
+	startFakeAPI(t, f)
+	rdb := newSession(t, "access-1", "refresh-1")
+
+	if _, err := Refresh("access-1"); err != ErrSessionExpired {
+		t.Errorf("Refresh err = %v, want ErrSessionExpired", err)
+	}
+	if ok, _ := cache.SessionExists(rdb); ok {
+		t.Error("session should be cleared after the refresh token is rejected")
+	}
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// TraceparentHeader carries the W3C trace context the API continues, so the
+// server-side spans of every request a command makes line up under one trace.
+const TraceparentHeader = "traceparent"
+
+// traceID names the trace of every request this process sends, unless its
+// context carries another (see NewTrace): one "nim post", from presign to
+// confirm, is one trace.
+var traceID = newTraceID()
+
+type traceKey struct{}
+
+// NewTrace returns a copy of ctx whose requests belong to a trace of their
+// own, for long-running commands like "nim watch" that do many unrelated
+// things.
+func NewTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceKey{}, newTraceID())
+}
+
+// withTraceparent returns req with a traceparent header naming its trace and
+// a new span for it, unless it already has one. req itself isn't modified.
+func withTraceparent(req *http.Request) *http.Request {
+	if req.Header.Get(TraceparentHeader) != "" {
+		return req
+	}
+	id, ok := req.Context().Value(traceKey{}).(string)
+	if !ok {
+		id = traceID
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(TraceparentHeader, "00-"+id+"-"+randomHex(8)+"-01")
+	return req
+}
+
+func newTraceID() string { return randomHex(16) }
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// traceparents returns a server that records the traceparent of every request.
+func traceparents(t *testing.T) (string, *[]string) {
+	t.Helper()
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get(TraceparentHeader))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &seen
+}
+
+func send(t *testing.T, ctx context.Context, url string, header string) {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if header != "" {
+		req.Header.Set(TraceparentHeader, header)
+	}
+	resp, err := NewClient(0).Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	resp.Body.Close()
+	if req.Header.Get(TraceparentHeader) != header {
+		t.Error("the caller's request was modified")
+	}
+}
+
+func TestTransport_SetsTraceparent(t *testing.T) {
+	url, seen := traceparents(t)
+	ctx := context.Background()
+	send(t, ctx, url, "")
+	send(t, ctx, url, "")
+	watched := NewTrace(ctx)
+	send(t, watched, url, "")
+	send(t, ctx, url, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	var ids, spans []string
+	for _, h := range *seen {
+		parts := strings.Split(h, "-")
+		if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || parts[3] != "01" {
+			t.Fatalf("malformed traceparent %q", h)
+		}
+		ids, spans = append(ids, parts[1]), append(spans, parts[2])
+	}
+	if ids[0] != traceID || ids[1] != traceID {
+		t.Errorf("requests are in traces %v, want the process's %s", ids[:2], traceID)
+	}
+	if spans[0] == spans[1] {
+		t.Error("each request should get its own span")
+	}
+	if ids[2] == traceID {
+		t.Error("NewTrace should start a new trace")
+	}
+	if ids[3] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("an existing traceparent was replaced: %q", (*seen)[3])
+	}
+}
@@ -1,22 +1,34 @@
 // Package cache manages the user's local session using Redis. The session
-// stores the JWT token, email, user ID, active box name, current folder path,
-// and the full list of boxes returned at login — all under the key "user:session".
+// stores the JWT and refresh token, email, user ID, active box name, current
+// folder path, and the full list of boxes returned at login — all under the
+// key "user:session".
 //
 // Redis is always local (localhost) because it's a per-developer session
 // store, not shared between machines or environments.
+//
+// When NIM_TOKEN is set (config.Token) there is no session: Redis is never
+// contacted, the token stands in for the JWT, NIM_BOX is the active box and
+// the current path is always the box root. Calls that would change the
+// session return ErrTokenMode.
 package cache
 
 import (
 	"context"
 	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/nimbus/cli/config"
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrTokenMode is returned by session setters when the CLI is running with
+// NIM_TOKEN, which has no session to update.
+var ErrTokenMode = errors.New("not available with NIM_TOKEN set: use NIM_BOX to choose the box")
+
 // NewRedisClient creates a Redis client and verifies connectivity with a PING.
-// The address is read from config.RedisAddr so it respects NIM_ENV.
+// The address is read from config.RedisAddr so it respects NIM_ENV. With
+// NIM_TOKEN set the PING is skipped, since nothing will be read from Redis.
 func NewRedisClient() (*redis.Client, error) {
 	ctx := context.Background()
 	rdb := redis.NewClient(&redis.Options{
@@ -24,6 +36,9 @@ func NewRedisClient() (*redis.Client, error) {
 		Password: "",
 		DB:       0,
 	})
+	if config.Token != "" {
+		return rdb, nil
+	}
 
 	_, err := rdb.Ping(ctx).Result()
 	if err != nil {
@@ -35,6 +50,9 @@ func NewRedisClient() (*redis.Client, error) {
 // GetAuthToken retrieves the JWT stored in the session hash.
 // Returns an error if no session exists (user is not logged in).
 func GetAuthToken(rdb *redis.Client) (string, error) {
+	if config.Token != "" {
+		return config.Token, nil
+	}
 	ctx := context.Background()
 	key := "user:session"
 	field := "JWT_Token"
@@ -51,6 +69,9 @@ func GetAuthToken(rdb *redis.Client) (string, error) {
 // It stores the JWT, email, user ID, and sets the active box to the first
 // box in the user's box list (if any).
 func SetAuthToken(rdb *redis.Client, userID uint, email string, box []map[string]any, token string) error {
+	if config.Token != "" {
+		return ErrTokenMode
+	}
 	ctx := context.Background()
 	key := "user:session"
 
@@ -72,8 +93,74 @@ func SetAuthToken(rdb *redis.Client, userID uint, email string, box []map[string
 	return rdb.HSet(ctx, key, field).Err()
 }
 
+// SetRefreshToken stores the refresh token returned by login alongside the
+// session's JWT.
+func SetRefreshToken(rdb *redis.Client, token string) error {
+	if config.Token != "" {
+		return ErrTokenMode
+	}
+	ctx := context.Background()
+	return rdb.HSet(ctx, "user:session", "RefreshToken", token).Err()
+}
+
+// GetRefreshToken returns the session's refresh token. Sessions created
+// before refresh tokens existed have none and must log in again.
+func GetRefreshToken(rdb *redis.Client) (string, error) {
+	if config.Token != "" {
+		return "", ErrTokenMode
+	}
+	ctx := context.Background()
+	token, err := rdb.HGet(ctx, "user:session", "RefreshToken").Result()
+	if err == redis.Nil {
+		return "", errors.New("refresh token not found")
+	} else if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// UpdateTokens replaces the JWT and refresh token after a refresh, leaving the
+// rest of the session (active box, path) as it was.
+func UpdateTokens(rdb *redis.Client, token, refreshToken string) error {
+	if config.Token != "" {
+		return ErrTokenMode
+	}
+	ctx := context.Background()
+	return rdb.HSet(ctx, "user:session", "JWT_Token", token, "RefreshToken", refreshToken).Err()
+}
+
+// SetEmail records a changed account email in the session.
+func SetEmail(rdb *redis.Client, email string) error {
+	if config.Token != "" {
+		return ErrTokenMode
+	}
+	ctx := context.Background()
+	return rdb.HSet(ctx, "user:session", "Email", email).Err()
+}
+
+// refreshLockTTL bounds how long a crashed process can hold the refresh lock.
+const refreshLockTTL = 15 * time.Second
+
+// LockRefresh takes the lock that serialises token refreshes between CLI
+// processes (e.g. a command and the watch daemon). Refresh tokens are single
+// use, so two processes refreshing with the same one would look like token
+// theft to the server. Returns false if another process holds the lock.
+func LockRefresh(rdb *redis.Client) (bool, error) {
+	ctx := context.Background()
+	return rdb.SetNX(ctx, "user:session:refresh-lock", 1, refreshLockTTL).Result()
+}
+
+// UnlockRefresh releases the lock taken by LockRefresh.
+func UnlockRefresh(rdb *redis.Client) error {
+	ctx := context.Background()
+	return rdb.Del(ctx, "user:session:refresh-lock").Err()
+}
+
 // ClearAuthToken deletes the entire session hash, effectively logging the user out.
 func ClearAuthToken(rdb *redis.Client) error {
+	if config.Token != "" {
+		return ErrTokenMode
+	}
 	ctx := context.Background()
 	return rdb.Del(ctx, "user:session").Err()
 }
@@ -81,6 +168,9 @@ func ClearAuthToken(rdb *redis.Client) error {
 // SetBoxName updates the active box in the session. The box must already
 // exist in the "Boxes" field (validated by BoxExists before calling this).
 func SetBoxName(rdb *redis.Client, boxName string) error {
+	if config.Token != "" {
+		return ErrTokenMode
+	}
 	ctx := context.Background()
 	key := "user:session"
 
@@ -103,6 +193,9 @@ func SetBoxName(rdb *redis.Client, boxName string) error {
 // BoxExists checks whether a given box name is present in the cached box list.
 // This is used by the "cb" command to validate the box before activating it.
 func BoxExists(rdb *redis.Client, boxName string) (bool, error) {
+	if config.Token != "" {
+		return boxName == config.TokenBox, nil
+	}
 	ctx := context.Background()
 	data, err := rdb.HGet(ctx, "user:session", "Boxes").Result()
 	if err == redis.Nil {
@@ -125,7 +218,11 @@ func BoxExists(rdb *redis.Client, boxName string) (bool, error) {
 
 // StoreBoxes serialises the box list returned by the API and saves it to the
 // session so the CLI can validate box names locally without an extra API call.
+// It is a no-op with NIM_TOKEN.
 func StoreBoxes(rdb *redis.Client, boxes []map[string]any) error {
+	if config.Token != "" {
+		return nil
+	}
 	ctx := context.Background()
 	data, err := json.Marshal(boxes)
 	if err != nil {
@@ -135,8 +232,12 @@ func StoreBoxes(rdb *redis.Client, boxes []map[string]any) error {
 }
 
 // AddBoxToCache appends a newly created box to the cached box list so that
-// "cb <new-box>" works immediately without requiring a re-login.
+// "cb <new-box>" works immediately without requiring a re-login. It is a no-op
+// with NIM_TOKEN.
 func AddBoxToCache(rdb *redis.Client, boxName string) error {
+	if config.Token != "" {
+		return nil
+	}
 	ctx := context.Background()
 	data, err := rdb.HGet(ctx, "user:session", "Boxes").Result()
 	if err == redis.Nil {
@@ -158,8 +259,60 @@ func AddBoxToCache(rdb *redis.Client, boxName string) error {
 	return rdb.HSet(ctx, "user:session", "Boxes", string(updated)).Err()
 }
 
+// RenameBoxInCache follows a box rename in the session: the cached box list,
+// and the active box if it was the one renamed, so the working path inside it
+// stays valid. It is a no-op with NIM_TOKEN.
+func RenameBoxInCache(rdb *redis.Client, oldName, newName string) error {
+	if config.Token != "" {
+		return nil
+	}
+	ctx := context.Background()
+	key := "user:session"
+
+	data, err := rdb.HGet(ctx, key, "Boxes").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if err == nil {
+		var boxes []map[string]any
+		if err := json.Unmarshal([]byte(data), &boxes); err != nil {
+			return err
+		}
+		for _, box := range boxes {
+			if box["name"] == oldName {
+				box["name"] = newName
+			}
+		}
+		updated, err := json.Marshal(boxes)
+		if err != nil {
+			return err
+		}
+		if err := rdb.HSet(ctx, key, "Boxes", string(updated)).Err(); err != nil {
+			return err
+		}
+	}
+
+	current, err := rdb.HGet(ctx, key, "CurrentBox").Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current != oldName {
+		return nil
+	}
+	return rdb.HSet(ctx, key, "CurrentBox", newName).Err()
+}
+
 // GetBoxName returns the name of the currently active box from the session.
 func GetBoxName(rdb *redis.Client) (string, error) {
+	if config.Token != "" {
+		if config.TokenBox == "" {
+			return "", errors.New("no box selected: set NIM_BOX to the box to use with NIM_TOKEN")
+		}
+		return config.TokenBox, nil
+	}
 	ctx := context.Background()
 	boxName, err := rdb.HGet(ctx, "user:session", "CurrentBox").Result()
 	if err == redis.Nil {
@@ -173,6 +326,12 @@ func GetBoxName(rdb *redis.Client) (string, error) {
 // SetCurrentPath updates the working directory path stored in the session.
 // An empty string represents the root of the active box.
 func SetCurrentPath(rdb *redis.Client, path string) error {
+	if config.Token != "" {
+		if path != "" {
+			return ErrTokenMode
+		}
+		return nil
+	}
 	ctx := context.Background()
 	return rdb.HSet(ctx, "user:session", "CurrentPath", path).Err()
 }
@@ -180,6 +339,9 @@ func SetCurrentPath(rdb *redis.Client, path string) error {
 // GetCurrentPath returns the current working directory path from the session.
 // Returns an error if the path field is missing (session may be incomplete).
 func GetCurrentPath(rdb *redis.Client) (string, error) {
+	if config.Token != "" {
+		return "", nil
+	}
 	ctx := context.Background()
 	path, err := rdb.HGet(ctx, "user:session", "CurrentPath").Result()
 	if err == redis.Nil {
@@ -193,6 +355,9 @@ func GetCurrentPath(rdb *redis.Client) (string, error) {
 // SessionExists returns true if a session hash is present in Redis.
 // Used to guard all commands that require the user to be logged in.
 func SessionExists(rdb *redis.Client) (bool, error) {
+	if config.Token != "" {
+		return true, nil
+	}
 	ctx := context.Background()
 	exists, err := rdb.Exists(ctx, "user:session").Result()
 	if err != nil {
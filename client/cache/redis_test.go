@@ -3,8 +3,10 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"testing"
 
+	"github.com/nimbus/cli/config"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -107,6 +109,22 @@ func TestClearAuthToken(t *testing.T) {
 
 // --- SessionExists ---
 
+func TestSetEmail(t *testing.T) {
+	rdb := newTestClient(t)
+
+	boxes := []map[string]any{{"name": "Home-Box"}}
+	if err := SetAuthToken(rdb, 1, "old@example.com", boxes, "test-token"); err != nil {
+		t.Fatalf("SetAuthToken: %v", err)
+	}
+	if err := SetEmail(rdb, "new@example.com"); err != nil {
+		t.Fatalf("SetEmail: %v", err)
+	}
+	email, _ := rdb.HGet(context.Background(), "user:session", "Email").Result()
+	if email != "new@example.com" {
+		t.Errorf("expected email %q, got %q", "new@example.com", email)
+	}
+}
+
 func TestSessionExists_True(t *testing.T) {
 	rdb := newTestClient(t)
 
@@ -228,6 +246,40 @@ func TestSetBoxName_NoBoxesField(t *testing.T) {
 	}
 }
 
+// --- RenameBoxInCache ---
+
+func TestRenameBoxInCache(t *testing.T) {
+	rdb := newTestClient(t)
+
+	StoreBoxes(rdb, []map[string]any{{"name": "Home-Box", "size": 5}, {"name": "Work"}})
+	SetBoxName(rdb, "Home-Box")
+	SetCurrentPath(rdb, "docs")
+
+	if err := RenameBoxInCache(rdb, "Home-Box", "Personal"); err != nil {
+		t.Fatalf("RenameBoxInCache: %v", err)
+	}
+	if ok, _ := BoxExists(rdb, "Personal"); !ok {
+		t.Error("expected the new name in the box list")
+	}
+	if ok, _ := BoxExists(rdb, "Home-Box"); ok {
+		t.Error("expected the old name gone from the box list")
+	}
+	if got, _ := GetBoxName(rdb); got != "Personal" {
+		t.Errorf("CurrentBox = %q, want Personal", got)
+	}
+	if got, _ := GetCurrentPath(rdb); got != "docs" {
+		t.Errorf("CurrentPath = %q, want it kept", got)
+	}
+
+	// Renaming another box leaves the active one alone.
+	if err := RenameBoxInCache(rdb, "Work", "Job"); err != nil {
+		t.Fatalf("RenameBoxInCache: %v", err)
+	}
+	if got, _ := GetBoxName(rdb); got != "Personal" {
+		t.Errorf("CurrentBox = %q, want Personal", got)
+	}
+}
+
 // --- GetBoxName ---
 
 func TestGetBoxName_NotFound(t *testing.T) {
@@ -294,3 +346,78 @@ func TestStoreBoxes_CorrectJSON(t *testing.T) {
 		t.Errorf("expected name %q, got %v", "test", got[0]["name"])
 	}
 }
+
+// --- NIM_TOKEN mode ---
+
+// withToken switches the package into token mode for one test. Redis is not
+// needed, so these tests never skip.
+func withToken(t *testing.T, token, box string) *redis.Client {
+	t.Helper()
+	prevToken, prevBox := config.Token, config.TokenBox
+	config.Token, config.TokenBox = token, box
+	t.Cleanup(func() { config.Token, config.TokenBox = prevToken, prevBox })
+
+	// Point at a port nothing listens on: any real Redis call would fail.
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func TestTokenMode_NewRedisClientSkipsPing(t *testing.T) {
+	withToken(t, "nim_pat_test", "CI-Box")
+	config.RedisAddr = "127.0.0.1:1"
+	t.Cleanup(func() { config.RedisAddr = "localhost:6379" })
+
+	rdb, err := NewRedisClient()
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	rdb.Close()
+}
+
+func TestTokenMode_ReadsComeFromEnvironment(t *testing.T) {
+	rdb := withToken(t, "nim_pat_test", "CI-Box")
+
+	if ok, err := SessionExists(rdb); err != nil || !ok {
+		t.Errorf("SessionExists = %v, %v; want true", ok, err)
+	}
+	if token, err := GetAuthToken(rdb); err != nil || token != "nim_pat_test" {
+		t.Errorf("GetAuthToken = %q, %v", token, err)
+	}
+	if box, err := GetBoxName(rdb); err != nil || box != "CI-Box" {
+		t.Errorf("GetBoxName = %q, %v", box, err)
+	}
+	if path, err := GetCurrentPath(rdb); err != nil || path != "" {
+		t.Errorf("GetCurrentPath = %q, %v", path, err)
+	}
+	if ok, _ := BoxExists(rdb, "Other-Box"); ok {
+		t.Error("BoxExists should only accept NIM_BOX")
+	}
+}
+
+func TestTokenMode_RequiresBox(t *testing.T) {
+	rdb := withToken(t, "nim_pat_test", "")
+	if _, err := GetBoxName(rdb); err == nil {
+		t.Error("expected an error when NIM_BOX is unset")
+	}
+}
+
+func TestTokenMode_SessionWritesAreRejected(t *testing.T) {
+	rdb := withToken(t, "nim_pat_test", "CI-Box")
+
+	if err := SetBoxName(rdb, "Other-Box"); !errors.Is(err, ErrTokenMode) {
+		t.Errorf("SetBoxName: got %v, want ErrTokenMode", err)
+	}
+	if err := SetCurrentPath(rdb, "docs"); !errors.Is(err, ErrTokenMode) {
+		t.Errorf("SetCurrentPath: got %v, want ErrTokenMode", err)
+	}
+	if err := ClearAuthToken(rdb); !errors.Is(err, ErrTokenMode) {
+		t.Errorf("ClearAuthToken: got %v, want ErrTokenMode", err)
+	}
+	if err := AddBoxToCache(rdb, "New-Box"); err != nil {
+		t.Errorf("AddBoxToCache should be a no-op, got %v", err)
+	}
+	if err := RenameBoxInCache(rdb, "CI-Box", "New-Box"); err != nil {
+		t.Errorf("RenameBoxInCache should be a no-op, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/nimbus/cli/api"
+	"github.com/nimbus/cli/cache"
+	"github.com/nimbus/cli/config"
+	"github.com/nimbus/cli/utils/helpers"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Show or change your account",
+	Long: `Show your account, or change its password or email, or delete it. Every
+change asks for your password (and a two-factor code when that is on);
+accounts that only use single sign-on must have logged in within the last
+five minutes instead.`,
+	Example: `nim account
+nim account password
+nim account email new@example.com
+nim account delete`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		acct, err := getAccount()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Email:       %s\n", acct.Email)
+		fmt.Printf("User ID:     %d\n", acct.UserID)
+		fmt.Printf("Created:     %s\n", acct.CreatedAt.Local().Format("2006-01-02"))
+		fmt.Printf("Password:    %s\n", onOff(acct.HasPassword, "set", "none (single sign-on only)"))
+		fmt.Printf("Two-factor:  %s\n", onOff(acct.TOTPEnabled, "on", "off"))
+		if len(acct.SSOIssuers) > 0 {
+			fmt.Printf("SSO:         %s\n", strings.Join(acct.SSOIssuers, ", "))
+		}
+		return nil
+	},
+}
+
+var accountPasswordCmd = &cobra.Command{
+	Use:   "password",
+	Short: "Change your password",
+	Long: `Change your password. Every other device is logged out; this one stays
+logged in.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		acct, err := getAccount()
+		if err != nil {
+			return err
+		}
+		var req api.ChangePasswordRequest
+		if acct.HasPassword {
+			if req.CurrentPassword, err = readSecret("Enter current password: "); err != nil {
+				return err
+			}
+		}
+		newPass, err := readSecret("Enter new password: ")
+		if err != nil {
+			return err
+		}
+		confirm, _ := readSecret("Confirm new password: ")
+		if newPass != confirm {
+			return fmt.Errorf("passwords do not match")
+		}
+		req.NewPassword = newPass
+		if acct.TOTPEnabled {
+			req.Code = readTOTPCode()
+		}
+
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if _, err := c.API().ChangePasswordWithResponse(ctx, req); err != nil {
+			return err
+		}
+		fmt.Println("Password changed. Other devices have been logged out.")
+		return nil
+	},
+}
+
+var accountEmailCmd = &cobra.Command{
+	Use:   "email <new-email>",
+	Short: "Change your email address",
+	Long: `Change the email address you log in with. Every device is logged out; this
+one is logged back in automatically.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		newEmail := args[0]
+		if !helpers.IsEmailValid(newEmail) {
+			return fmt.Errorf("invalid email format")
+		}
+		acct, err := getAccount()
+		if err != nil {
+			return err
+		}
+		req := api.ChangeEmailRequest{NewEmail: newEmail}
+		if acct.HasPassword {
+			if req.Password, err = readSecret("Enter password: "); err != nil {
+				return err
+			}
+		}
+		if acct.TOTPEnabled {
+			req.Code = readTOTPCode()
+		}
+
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().ChangeEmailWithResponse(ctx, req)
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		fmt.Printf("Email changed to %s.\n", result.Email)
+		if config.Token != "" {
+			return nil
+		}
+		// The old session was revoked along with every other one.
+		rdb, err := cache.NewRedisClient()
+		if err != nil {
+			return fmt.Errorf("failed to connect to cache: %w", err)
+		}
+		defer func() { _ = rdb.Close() }()
+		if result.Token == "" {
+			_ = cache.ClearAuthToken(rdb)
+			fmt.Println("Run 'nim login' with your new email.")
+			return nil
+		}
+		if err := cache.UpdateTokens(rdb, result.Token, result.RefreshToken); err != nil {
+			return fmt.Errorf("failed to cache session: %w", err)
+		}
+		return cache.SetEmail(rdb, result.Email)
+	},
+}
+
+var accountDeleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete your account and all your files",
+	Long: `Delete your account. Every box, folder and file is deleted permanently and
+every device is logged out. This cannot be undone.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		acct, err := getAccount()
+		if err != nil {
+			return err
+		}
+
+		var typed string
+		fmt.Println("This permanently deletes your account and every file in it.")
+		fmt.Printf("Type your email (%s) to confirm: ", acct.Email)
+		fmt.Scanln(&typed)
+		if typed != acct.Email {
+			fmt.Println("Account deletion cancelled.")
+			return nil
+		}
+
+		var req api.DeleteAccountRequest
+		if acct.HasPassword {
+			if req.Password, err = readSecret("Enter password: "); err != nil {
+				return err
+			}
+		}
+		if acct.TOTPEnabled {
+			req.Code = readTOTPCode()
+		}
+
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if _, err := c.API().DeleteAccountWithResponse(ctx, req); err != nil {
+			return err
+		}
+		if config.Token == "" {
+			if rdb, err := cache.NewRedisClient(); err == nil {
+				_ = cache.ClearAuthToken(rdb)
+				_ = rdb.Close()
+			}
+		}
+		fmt.Println("Your account has been deleted. Your files are being removed.")
+		return nil
+	},
+}
+
+func getAccount() (*api.Account, error) {
+	c, err := apiClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := apiContext()
+	defer cancel()
+	res, err := c.API().GetAccountWithResponse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.JSON200, nil
+}
+
+// readSecret prompts for a value without echoing it. Empty input is an error.
+func readSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+	b, _ := term.ReadPassword(syscall.Stdin)
+	fmt.Print("\n")
+	if len(b) == 0 {
+		return "", fmt.Errorf("input cannot be empty")
+	}
+	return string(b), nil
+}
+
+// readTOTPCode prompts for a two-factor code.
+func readTOTPCode() string {
+	var code string
+	fmt.Print("Enter the code from your authenticator app (or a recovery code): ")
+	fmt.Scanln(&code)
+	return code
+}
+
+func onOff(b bool, on, off string) string {
+	if b {
+		return on
+	}
+	return off
+}
+
+func init() {
+	rootCmd.AddCommand(accountCmd)
+	accountCmd.AddCommand(accountPasswordCmd)
+	accountCmd.AddCommand(accountEmailCmd)
+	accountCmd.AddCommand(accountDeleteCmd)
+}
@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nimbus/cli/api"
+	"github.com/nimbus/cli/nimbus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	adminSearch    string
+	adminSuspended bool
+	adminLimit     int
+	adminReason    string
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Manage users (admins only)",
+	Long: `Operator commands for accounts on this server: find users and what they
+store, suspend abusive accounts, force password resets, sign users out, set
+storage quotas and look inside a box without touching its files.
+
+They need an account with the admin role (the server's "nimbus users
+promote <email>" grants it); a token needs the admin scope. Every admin
+action is recorded in your audit log.
+
+A <user> is an email address or a numeric user ID.`,
+	Example: `nim admin users --search example.com
+nim admin user alice@example.com
+nim admin suspend alice@example.com --reason "spam reports"
+nim admin quota alice@example.com 10GB
+nim admin tree alice@example.com Photos`,
+}
+
+var adminUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "List users with their storage",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if adminLimit < 1 {
+			return fmt.Errorf("--limit must be at least 1")
+		}
+		pageSize := min(adminLimit, 500)
+		params := &api.AdminListUsersParams{Q: optional(adminSearch), Limit: &pageSize}
+		if adminSuspended {
+			suspended := api.True
+			params.Suspended = &suspended
+		}
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+
+		var users []nimbus.UserSummary
+		more := false
+		for u, err := range c.Users(ctx, params) {
+			if err != nil {
+				return err
+			}
+			if len(users) == adminLimit {
+				more = true
+				break
+			}
+			users = append(users, u)
+		}
+
+		if len(users) == 0 {
+			fmt.Println("No users found.")
+			return nil
+		}
+
+		fmt.Print("\n")
+		fmt.Printf("  %-10s  %-32s  %-5s  %-9s  %5s  %10s  %10s\n", "ID", "EMAIL", "ROLE", "STATUS", "BOXES", "USED", "QUOTA")
+		fmt.Printf("  %-10s  %-32s  %-5s  %-9s  %5s  %10s  %10s\n", "--", "-----", "----", "------", "-----", "----", "-----")
+		for _, u := range users {
+			fmt.Printf("  %-10d  %-32s  %-5s  %-9s  %5d  %10s  %10s\n", u.ID, u.Email, u.Role, adminStatus(u),
+				u.Boxes, formatSize(u.UsedBytes), formatQuota(u.QuotaBytes))
+		}
+		fmt.Print("\n")
+		if more {
+			fmt.Printf("Showing the first %d users; use --limit or --search to see more.\n", len(users))
+		}
+		return nil
+	},
+}
+
+var adminUserCmd = &cobra.Command{
+	Use:   "user <user>",
+	Short: "Show one user's account, boxes and sessions",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().AdminGetUserWithResponse(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		u := result.User
+		fmt.Printf("User:      %d %s\n", u.ID, u.Email)
+		fmt.Printf("Role:      %s\n", u.Role)
+		fmt.Printf("Joined:    %s\n", u.CreatedAt.Local().Format("2006-01-02"))
+		status := adminStatus(u)
+		if !u.SuspendedAt.IsZero() {
+			status = fmt.Sprintf("suspended %s", u.SuspendedAt.Local().Format("2006-01-02 15:04"))
+			if u.SuspendReason != "" {
+				status += " (" + u.SuspendReason + ")"
+			}
+		}
+		fmt.Printf("Status:    %s\n", status)
+		fmt.Printf("2FA:       %t\n", u.TOTPEnabled)
+		fmt.Printf("Storage:   %s of %s\n", formatSize(u.UsedBytes), formatQuota(u.QuotaBytes))
+		fmt.Printf("Sessions:  %d\n", result.Sessions)
+		if len(result.Boxes) > 0 {
+			fmt.Print("\n")
+			for _, b := range result.Boxes {
+				fmt.Printf("  %-30s  %10s\n", b.Name, formatSize(b.Size))
+			}
+		}
+		return nil
+	},
+}
+
+var adminSuspendCmd = &cobra.Command{
+	Use:   "suspend <user>",
+	Short: "Suspend an account and sign it out everywhere",
+	Long: `Suspending an account revokes its sessions and rejects every credential
+it has (password, app passwords, access tokens, S3 keys and SSH keys) until
+it is unsuspended. Nothing in its boxes is deleted.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().AdminSuspendUserWithResponse(ctx, args[0], api.AdminSuspendUserJSONRequestBody{Reason: adminReason})
+		if err != nil {
+			return err
+		}
+		revoked := res.JSON200.SessionsRevoked
+		fmt.Printf("Suspended %s (%d sessions revoked)\n", args[0], revoked)
+		return nil
+	},
+}
+
+var adminUnsuspendCmd = &cobra.Command{
+	Use:   "unsuspend <user>",
+	Short: "Let a suspended account log in again",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if _, err := c.API().AdminUnsuspendUserWithResponse(ctx, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Unsuspended %s\n", args[0])
+		return nil
+	},
+}
+
+var adminResetPasswordCmd = &cobra.Command{
+	Use:   "reset-password <user>",
+	Short: "Make a user choose a new password",
+	Long: `Signs the user out everywhere and refuses their current password until
+they reset it with a recovery code ("nim login", then 'r'). Use it when a
+password may have leaked. App passwords, tokens and keys keep working.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().AdminForcePasswordResetWithResponse(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		revoked := res.JSON200.SessionsRevoked
+		fmt.Printf("%s must reset their password (%d sessions revoked)\n", args[0], revoked)
+		return nil
+	},
+}
+
+var adminRevokeSessionsCmd = &cobra.Command{
+	Use:   "revoke-sessions <user>",
+	Short: "Sign a user out of every device",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().AdminRevokeSessionsWithResponse(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		revoked := res.JSON200.SessionsRevoked
+		fmt.Printf("Revoked %d sessions for %s\n", revoked, args[0])
+		return nil
+	},
+}
+
+var adminQuotaCmd = &cobra.Command{
+	Use:   "quota <user> <size|unlimited>",
+	Short: "Set how much a user may store",
+	Long: `Sets the user's storage quota across all their boxes, e.g. 500MB or 10GB
+(units are powers of 1024, like "nim bls"). "unlimited" or 0 removes the
+limit. A quota below what the user already stores blocks new uploads until
+they delete files.`,
+	Example: `nim admin quota alice@example.com 10GB
+nim admin quota 48213307 unlimited`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		quota, err := parseSize(args[1])
+		if err != nil {
+			return err
+		}
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().AdminSetQuotaWithResponse(ctx, args[0], api.AdminSetQuotaJSONRequestBody{QuotaBytes: quota})
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+		fmt.Printf("Quota for %s set to %s (%s used)\n", args[0], formatQuota(result.QuotaBytes), formatSize(result.UsedBytes))
+		return nil
+	},
+}
+
+var adminTreeCmd = &cobra.Command{
+	Use:   "tree <user> <box>",
+	Short: "List every folder and file in a user's box",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().AdminBoxTreeWithResponse(ctx, args[0], args[1])
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		fmt.Printf("%s (%s)\n", result.Box, formatSize(result.Size))
+		for _, e := range result.Entries {
+			depth := strings.Count(e.Path, "/")
+			name := e.Path[strings.LastIndex(e.Path, "/")+1:]
+			if e.IsDir {
+				fmt.Printf("%s%s/\n", strings.Repeat("  ", depth+1), name)
+				continue
+			}
+			fmt.Printf("%s%-*s  %10s  %s\n", strings.Repeat("  ", depth+1), max(40-2*depth, 1), name,
+				formatSize(e.Size), e.Modified.Local().Format("2006-01-02 15:04"))
+		}
+		return nil
+	},
+}
+
+// adminStatus is the one-word state of an account.
+func adminStatus(u nimbus.UserSummary) string {
+	switch {
+	case !u.SuspendedAt.IsZero():
+		return "suspended"
+	case u.PasswordResetRequired:
+		return "reset"
+	default:
+		return "active"
+	}
+}
+
+func formatQuota(bytes int64) string {
+	if bytes <= 0 {
+		return "unlimited"
+	}
+	return formatSize(bytes)
+}
+
+// parseSize reads a size such as 512, 500MB, 1.5G or 10GB into bytes. Units
+// are powers of 1024 to match formatSize; "unlimited" is 0.
+func parseSize(s string) (int64, error) {
+	in := strings.ToUpper(strings.TrimSpace(s))
+	if in == "UNLIMITED" {
+		return 0, nil
+	}
+	in = strings.TrimSuffix(strings.TrimSuffix(in, "B"), "I")
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40}} {
+		if n, ok := strings.CutSuffix(in, u.suffix); ok {
+			in, mult = strings.TrimSpace(n), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(in, 64)
+	if err != nil || !(n >= 0 && n*float64(mult) <= 1<<62) { // also rejects NaN
+		return 0, fmt.Errorf("%q is not a size (e.g. 500MB, 10GB or unlimited)", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+	adminCmd.AddCommand(adminUsersCmd, adminUserCmd, adminSuspendCmd, adminUnsuspendCmd,
+		adminResetPasswordCmd, adminRevokeSessionsCmd, adminQuotaCmd, adminTreeCmd)
+
+	adminUsersCmd.Flags().StringVarP(&adminSearch, "search", "s", "", "only users whose email contains this, or with this ID")
+	adminUsersCmd.Flags().BoolVar(&adminSuspended, "suspended", false, "only suspended accounts")
+	adminUsersCmd.Flags().IntVar(&adminLimit, "limit", 50, "how many users to show")
+	adminSuspendCmd.Flags().StringVarP(&adminReason, "reason", "r", "", "why the account is suspended, kept with it")
+}
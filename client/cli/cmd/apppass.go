@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/nimbus/cli/api"
+	"github.com/nimbus/cli/config"
+	"github.com/spf13/cobra"
+)
+
+var appPassCmd = &cobra.Command{
+	Use:   "apppass",
+	Short: "Manage app passwords for WebDAV and other clients",
+	Long: `App passwords let file managers, davfs2 and rclone mount your boxes over
+WebDAV without handing them your account password. Use your email as the
+username and an app password as the password against <server>/dav/.
+
+Each app password is shown once when it is created; revoke it if a device is
+lost.`,
+	Example: `nim apppass create laptop
+nim apppass list
+nim apppass revoke 3`,
+}
+
+var appPassCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a new app password",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().CreateAppPasswordWithResponse(ctx, api.NameRequest{Name: args[0]})
+		if err != nil {
+			return err
+		}
+		result := res.JSON201
+
+		fmt.Printf("App password \"%s\" created:\n\n    %s\n\n", result.Name, result.Password)
+		fmt.Println("Copy it now, it won't be shown again.")
+		fmt.Printf("WebDAV URL: %s/dav/\n", config.BaseURL)
+		return nil
+	},
+}
+
+var appPassListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your app passwords",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().ListAppPasswordsWithResponse(ctx)
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		if len(result.AppPasswords) == 0 {
+			fmt.Println("No app passwords found.")
+			return nil
+		}
+
+		fmt.Print("\n")
+		fmt.Printf("%-6s  %-24s  %-17s  %s\n", "ID", "NAME", "CREATED", "LAST USED")
+		fmt.Printf("%-6s  %-24s  %-17s  %s\n", "--", "----", "-------", "---------")
+		for _, p := range result.AppPasswords {
+			lastUsed := "never"
+			if !p.LastUsedAt.IsZero() {
+				lastUsed = p.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%-6d  %-24s  %-17s  %s\n", p.ID, p.Name, p.CreatedAt.Local().Format("2006-01-02 15:04"), lastUsed)
+		}
+		fmt.Print("\n")
+		return nil
+	},
+}
+
+var appPassRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an app password",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if _, err := c.API().RevokeAppPasswordWithResponse(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("App password %s revoked\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(appPassCmd)
+	appPassCmd.AddCommand(appPassCreateCmd)
+	appPassCmd.AddCommand(appPassListCmd)
+	appPassCmd.AddCommand(appPassRevokeCmd)
+}
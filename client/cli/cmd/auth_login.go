@@ -1,51 +1,38 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"net/http"
+	"os"
+	"runtime"
 	"strings"
 	"syscall"
-	"time"
 
+	"github.com/nimbus/cli/api"
 	"github.com/nimbus/cli/banner"
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
 	"github.com/nimbus/cli/config"
 	"github.com/nimbus/cli/utils/helpers"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
 
-// LoginRequest is the JSON body sent to /v1/api/auth/users/login.
-type LoginRequest struct {
-	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required"`
-}
-
-// LoginResponse is the JSON body received from /v1/api/auth/login on success.
-type LoginResponse struct {
-	Message string           `json:"message"`
-	Token   string           `json:"token"`
-	Email   string           `json:"email"`
-	UserID  uint             `json:"user_id"`
-	Box     []map[string]any `json:"box"`
-}
-
-// ResetPasswordRequest is the JSON body sent to /v1/api/auth/users/reset-password.
-type ResetPasswordRequest struct {
-	Email       string `json:"email"`
-	PassKey     string `json:"passkey"`
-	NewPassword string `json:"new_password"`
-}
+// loginSSO signs in through the server's identity provider instead of with
+// a password.
+var loginSSO bool
 
 var loginCmd = &cobra.Command{
 	Use:   "login",
 	Short: "Authenticate with your Nimbus account",
+	Long: `Sign in with your email and password, or with --sso through your
+organisation's identity provider. Single sign-on shows a link and a code to
+open in any browser, so it also works over SSH.`,
+	Example: `nim login
+nim login --sso`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var loginResponse LoginResponse
-		var loginRequest LoginRequest
+		var loginResponse api.LoginResponse
+		var loginRequest api.LoginRequest
 
 		// Connect to the local Redis session cache.
 		redisClient, err := cache.NewRedisClient()
@@ -59,6 +46,10 @@ var loginCmd = &cobra.Command{
 		if err != nil {
 			return fmt.Errorf("failed to check session existence: %w", err)
 		}
+		if config.Token != "" {
+			fmt.Println("NIM_TOKEN is set, commands use that token; unset it to log in.")
+			return nil
+		}
 		if sessionExists {
 			fmt.Println("You are already logged in.")
 			return nil
@@ -67,7 +58,14 @@ var loginCmd = &cobra.Command{
 		banner.ShowLoginBanner()
 		fmt.Print("\n")
 
-		fmt.Println("Forgot your password? Type 'r' at the email prompt to reset it.")
+		if loginSSO {
+			if err := runSSOLogin(&loginResponse); err != nil {
+				return err
+			}
+			return saveLogin(redisClient, &loginResponse)
+		}
+
+		fmt.Println("Forgot your password? Type 'r' at the email prompt to reset it with a recovery code.")
 		fmt.Print("\n")
 
 		// Prompt for email and validate format before sending to the server.
@@ -95,49 +93,78 @@ var loginCmd = &cobra.Command{
 			return fmt.Errorf("password cannot be empty")
 		}
 
-		body, _ := json.Marshal(loginRequest)
-		req, err := http.NewRequest(http.MethodPost, config.BaseURL+"/v1/api/auth/users/login", bytes.NewBuffer(body))
+		loginRequest.Device = deviceName()
+		c, err := publicClient()
 		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
+			return err
 		}
-		req.Header.Set("Content-Type", "application/json")
-
+		ctx, cancel := apiContext()
 		stop := animations.Spinner("Authenticating...")
-		resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+		res, err := c.API().LoginWithResponse(ctx, loginRequest)
 		stop()
-
+		cancel()
 		if err != nil {
-			return fmt.Errorf("failed to perform request: %w", err)
+			return fmt.Errorf("login failed: %w", err)
 		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("login failed: %s", resp.Status)
+		loginResponse = *res.JSON200
+
+		// Accounts with two-factor authentication need a code before any
+		// token is issued.
+		if loginResponse.MFARequired {
+			mfa := api.LoginTOTPRequest{Challenge: loginResponse.Challenge}
+			fmt.Print("Enter the code from your authenticator app (or a recovery code): ")
+			fmt.Scanln(&mfa.Code)
+			if mfa.Code == "" {
+				return fmt.Errorf("code cannot be empty")
+			}
+			ctx, cancel := apiContext()
+			stop := animations.Spinner("Verifying...")
+			res, err := c.API().LoginTOTPWithResponse(ctx, mfa)
+			stop()
+			cancel()
+			if err != nil {
+				return fmt.Errorf("login failed: %w", err)
+			}
+			loginResponse = *res.JSON200
 		}
 
-		if err := json.NewDecoder(resp.Body).Decode(&loginResponse); err != nil {
-			return fmt.Errorf("failed to parse response: %w", err)
-		}
+		return saveLogin(redisClient, &loginResponse)
+	},
+}
 
-		// Cache the session locally so subsequent commands don't need to re-authenticate.
-		if err := cache.SetAuthToken(redisClient, loginResponse.UserID, loginResponse.Email, loginResponse.Box, loginResponse.Token); err != nil {
-			return fmt.Errorf("failed to cache session: %w", err)
-		}
-		if err := cache.StoreBoxes(redisClient, loginResponse.Box); err != nil {
-			return fmt.Errorf("failed to store boxes: %w", err)
-		}
+// saveLogin caches the session locally so subsequent commands don't need to
+// re-authenticate, and greets the user.
+func saveLogin(redisClient *redis.Client, loginResponse *api.LoginResponse) error {
+	boxes := make([]map[string]any, len(loginResponse.Box))
+	for i, b := range loginResponse.Box {
+		boxes[i] = map[string]any{"name": b.Name}
+	}
+	if err := cache.SetAuthToken(redisClient, loginResponse.UserID, loginResponse.Email, boxes, loginResponse.Token); err != nil {
+		return fmt.Errorf("failed to cache session: %w", err)
+	}
+	if err := cache.SetRefreshToken(redisClient, loginResponse.RefreshToken); err != nil {
+		return fmt.Errorf("failed to cache session: %w", err)
+	}
+	if err := cache.StoreBoxes(redisClient, boxes); err != nil {
+		return fmt.Errorf("failed to store boxes: %w", err)
+	}
 
-		fmt.Printf("Login successful\nWelcome back, %s\n", loginResponse.Email)
-		return nil
-	},
+	fmt.Printf("Login successful\nWelcome back, %s\n", loginResponse.Email)
+	if len(loginResponse.RecoveryCodes) > 0 {
+		// Accounts from before recovery codes get their first set here.
+		fmt.Println("\nYour account has been issued recovery codes. Each one can reset a forgotten")
+		fmt.Println("password once. Save them somewhere safe; they won't be shown again:")
+		printRecoveryCodes(loginResponse.RecoveryCodes)
+	}
+	return nil
 }
 
 // runPasswordReset drives the interactive password-reset flow. The user proves
-// their identity with the passkey chosen at registration, then sets a new
-// password. Secrets (passkey, new password) are read without echoing to the
-// terminal. On success the user is directed to log in normally.
+// their identity with one of the recovery codes issued at registration, then
+// sets a new password. Secrets are read without echoing to the terminal. On
+// success the user is directed to log in normally.
 func runPasswordReset() error {
-	var req ResetPasswordRequest
+	var req api.ResetPasswordRequest
 
 	fmt.Print("\n--- Password Reset ---\n\n")
 
@@ -150,12 +177,12 @@ func runPasswordReset() error {
 		return fmt.Errorf("invalid email format")
 	}
 
-	fmt.Print("Enter passkey (4 characters): ")
-	passkey, _ := term.ReadPassword(syscall.Stdin)
+	fmt.Print("Enter a recovery code: ")
+	code, _ := term.ReadPassword(syscall.Stdin)
 	fmt.Print("\n")
-	req.PassKey = string(passkey)
-	if req.PassKey == "" {
-		return fmt.Errorf("passkey cannot be empty")
+	req.RecoveryCode = string(code)
+	if req.RecoveryCode == "" {
+		return fmt.Errorf("recovery code cannot be empty")
 	}
 
 	fmt.Print("Enter new password: ")
@@ -173,38 +200,38 @@ func runPasswordReset() error {
 		return fmt.Errorf("passwords do not match")
 	}
 
-	body, _ := json.Marshal(req)
-	httpReq, err := http.NewRequest(http.MethodPost, config.BaseURL+"/v1/api/auth/users/reset-password", bytes.NewBuffer(body))
+	c, err := publicClient()
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return err
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
+	ctx, cancel := apiContext()
+	defer cancel()
 	stop := animations.Spinner("Resetting password...")
-	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(httpReq)
+	res, err := c.API().ResetPasswordWithResponse(ctx, req)
 	stop()
 	if err != nil {
-		return fmt.Errorf("failed to perform request: %w", err)
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	if resp.StatusCode != http.StatusOK {
-		// Surface the server's error message when available.
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		_ = json.NewDecoder(resp.Body).Decode(&errResp)
-		if errResp.Error != "" {
-			return fmt.Errorf("password reset failed: %s", errResp.Error)
-		}
-		return fmt.Errorf("password reset failed: %s", resp.Status)
+		return fmt.Errorf("password reset failed: %w", err)
 	}
+	result := res.JSON200
 
 	fmt.Println("Password reset successful. You can now run 'nim login' with your new password.")
+	if result.RecoveryCodesRemaining <= 3 {
+		fmt.Printf("\nYou have %d recovery codes left; run 'nim recovery-codes regenerate' after logging in.\n", result.RecoveryCodesRemaining)
+	}
 	return nil
 }
 
 func init() {
 	rootCmd.AddCommand(loginCmd)
 	loginCmd.PersistentFlags().String("login", "", "A help for login")
+	loginCmd.Flags().BoolVar(&loginSSO, "sso", false, "sign in through your identity provider in a browser")
+}
+
+// deviceName labels this login in the session list: the hostname and OS.
+func deviceName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown host"
+	}
+	return fmt.Sprintf("nim CLI on %s (%s)", host, runtime.GOOS)
 }
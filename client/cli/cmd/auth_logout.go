@@ -4,16 +4,28 @@ import (
 	"fmt"
 
 	"github.com/nimbus/cli/cache"
+	"github.com/nimbus/cli/config"
 	"github.com/spf13/cobra"
 )
 
+var logoutAllFlag bool
+
 var logoutCmd = &cobra.Command{
 	Use:   "logout",
 	Short: "Log out of your Nimbus account",
-	Long:  `Log out of your Nimbus account by clearing the local session cache.`,
+	Long: `Log out of your Nimbus account. The session is revoked on the server, so its
+tokens stop working even if they were copied, and the local session cache is
+cleared. With --all every session of the account is revoked, on every device.`,
+	Example: `nim logout
+nim logout --all`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var response string
 
+		if config.Token != "" {
+			fmt.Println("NIM_TOKEN is set, there is no session to log out of; use \"nim token revoke\" to disable the token.")
+			return nil
+		}
+
 		rdb, err := cache.NewRedisClient()
 		if err != nil {
 			return fmt.Errorf("failed to connect to cache: %w", err)
@@ -30,24 +42,48 @@ var logoutCmd = &cobra.Command{
 		}
 
 		// Ask for confirmation so the user doesn't accidentally log out.
-		fmt.Printf("Are you sure you want to logout? [Y/N]: ")
+		if logoutAllFlag {
+			fmt.Printf("Log out of every session on every device? [Y/N]: ")
+		} else {
+			fmt.Printf("Are you sure you want to logout? [Y/N]: ")
+		}
 		fmt.Scanln(&response)
 		if response != "Y" && response != "y" {
 			fmt.Println("Logout cancelled.")
 			return nil
 		}
 
-		// Deleting the Redis session key is all that's needed — the JWT itself
-		// will expire on the server side when its 24-hour TTL is up.
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if logoutAllFlag {
+			if _, err := c.API().RevokeAllSessionsWithResponse(ctx); err != nil {
+				// Keep the local session so the user can retry.
+				return fmt.Errorf("failed to revoke sessions: %w", err)
+			}
+		} else if _, err := c.API().LogoutWithResponse(ctx); err != nil {
+			// Still clear the local session: the server may be unreachable or
+			// the session already gone, and the user asked to be logged out.
+			fmt.Printf("Warning: could not revoke the session on the server: %v\n", err)
+		}
+
 		err = cache.ClearAuthToken(rdb)
 		if err != nil {
 			return fmt.Errorf("failed to clear session: %w", err)
 		}
-		fmt.Println("Successfully logged out.")
+		if logoutAllFlag {
+			fmt.Println("Logged out of all sessions.")
+		} else {
+			fmt.Println("Successfully logged out.")
+		}
 		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(logoutCmd)
+	logoutCmd.Flags().BoolVar(&logoutAllFlag, "all", false, "Revoke every session of the account, on all devices")
 }
@@ -31,7 +31,7 @@ var registerCmd = &cobra.Command{
 
 		url := config.BaseURL + "/register"
 		fmt.Printf("Opening registration page: %s\n", url)
-		fmt.Println("Complete the form in your browser, then run: nim login")
+		fmt.Println("Complete the form in your browser and save the recovery codes it shows, then run: nim login")
 		return openBrowser(url)
 	},
 }
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nimbus/cli/auth"
+	"github.com/nimbus/cli/cache"
+	"github.com/nimbus/cli/config"
+	"github.com/nimbus/cli/nimbus"
+)
+
+// apiTimeout bounds the API calls of commands that don't stream.
+const apiTimeout = 30 * time.Second
+
+// apiContext is the context for one command's API calls, cancelled after
+// apiTimeout.
+func apiContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), apiTimeout)
+}
+
+// apiClient returns an SDK client for the logged-in session. Each request
+// carries the session's current token and goes through auth.NewClient, so an
+// expired access token is refreshed and the request retried.
+//
+// A response that isn't 2xx comes back as a *nimbus.Error with the server's
+// message, so callers only handle success.
+func apiClient() (*nimbus.Client, error) {
+	if _, err := sessionToken(); err != nil {
+		return nil, err
+	}
+	return newClient(nimbus.WithTokenSource(nimbus.TokenFunc(func(context.Context) (string, error) {
+		return sessionToken()
+	})))
+}
+
+// publicClient is apiClient for the routes that need no session:
+// registration, login, password resets and single sign-on.
+func publicClient() (*nimbus.Client, error) {
+	return newClient()
+}
+
+func newClient(opts ...nimbus.Option) (*nimbus.Client, error) {
+	opts = append([]nimbus.Option{nimbus.WithHTTPClient(auth.NewClient(0))}, opts...)
+	return nimbus.New(config.BaseURL, opts...)
+}
+
+// sessionToken returns the logged-in session's access token, or NIM_TOKEN.
+func sessionToken() (string, error) {
+	RDB, err := cache.NewRedisClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to create Redis client: %w", err)
+	}
+	defer func() { _ = RDB.Close() }()
+
+	isLoggedIn, err := cache.SessionExists(RDB)
+	if err != nil {
+		return "", fmt.Errorf("failed to check login status: %w", err)
+	}
+	if !isLoggedIn {
+		return "", errNotLoggedIn
+	}
+
+	jwtToken, err := cache.GetAuthToken(RDB)
+	if err != nil || jwtToken == "" {
+		return "", fmt.Errorf("no auth token found, please login first")
+	}
+	return jwtToken, nil
+}
+
+// parseID reads the numeric ID of a token, key or app password argument.
+func parseID(arg string) (uint, error) {
+	id, err := strconv.ParseUint(arg, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an ID; see the list command for IDs", arg)
+	}
+	return uint(id), nil
+}
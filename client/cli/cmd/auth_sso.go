@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nimbus/cli/api"
+	"github.com/nimbus/cli/cli/animations"
+	"github.com/nimbus/cli/nimbus"
+)
+
+// runSSOLogin signs in through the server's identity provider. The user
+// finishes in a browser, on this machine or any other, while the CLI polls
+// for the tokens.
+func runSSOLogin(out *api.LoginResponse) error {
+	c, err := publicClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := apiContext()
+	res, err := c.API().StartDeviceAuthorizationWithResponse(ctx, api.DeviceAuthorizationRequest{Device: deviceName()})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("single sign-on failed: %w", err)
+	}
+	start := res.JSON200
+
+	fmt.Printf("Open this link in a browser to sign in:\n\n  %s\n\n", start.VerificationURIComplete)
+	fmt.Printf("or go to %s and enter the code %s\n\n", start.VerificationURI, start.UserCode)
+
+	interval := time.Duration(start.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(start.ExpiresIn) * time.Second)
+
+	stop := animations.Spinner("Waiting for you to sign in...")
+	defer stop()
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		ctx, cancel := apiContext()
+		res, err := c.API().DeviceTokenWithResponse(ctx, api.DeviceTokenRequest{DeviceCode: start.DeviceCode})
+		cancel()
+		if err == nil {
+			*out = *res.JSON200
+			return nil
+		}
+		// Until the user is done, polls are answered with an error code
+		// saying why.
+		var apiErr *nimbus.Error
+		if !errors.As(err, &apiErr) {
+			return fmt.Errorf("login failed: %w", err)
+		}
+		switch apiErr.Code {
+		case nimbus.CodeAuthorizationPending:
+		case nimbus.CodeSlowDown:
+			interval += 5 * time.Second
+		case nimbus.CodeAccessDenied:
+			return fmt.Errorf("sign-in was cancelled in the browser")
+		case nimbus.CodeExpiredToken:
+			return fmt.Errorf("sign-in timed out; run 'nim login --sso' again")
+		default:
+			return fmt.Errorf("login failed: %w", err)
+		}
+	}
+	return fmt.Errorf("sign-in timed out; run 'nim login --sso' again")
+}
@@ -30,7 +30,7 @@ var setCurrentBoxCmd = &cobra.Command{
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !IsLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		// Validate the box name against the locally cached list so we don't
@@ -1,16 +1,10 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
 	"github.com/spf13/cobra"
 )
 
@@ -29,46 +23,19 @@ var createBoxCmd = &cobra.Command{
 		}
 		defer func() { _ = RDB.Close() }()
 
-		isLoggedIn, err := cache.SessionExists(RDB)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("failed to check login status: %w", err)
+			return err
 		}
-		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
-		}
-
-		jwtToken, err := cache.GetAuthToken(RDB)
-		if err != nil || jwtToken == "" {
-			return fmt.Errorf("no auth token found, please login first")
-		}
-
-		// box_name is passed as a query parameter; the server sanitizes it.
-		endpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/boxes?box_name=%s",
-			url.QueryEscape(boxName),
-		)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ctx, cancel := apiContext()
 		defer cancel()
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
-		if err != nil {
-			return fmt.Errorf("failed to build request: %w", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
 		stop := animations.Spinner("Creating box...")
-		resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+		err = c.CreateBox(ctx, boxName)
 		stop()
 
 		if err != nil {
-			return fmt.Errorf("error creating box: %w", err)
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("failed to create box: %s — %s", resp.Status, string(errBody))
+			return fmt.Errorf("failed to create box: %w", err)
 		}
 
 		// Add the new box to the local cache so "nim cb <box>" works immediately.
@@ -1,78 +1,29 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 
-	"github.com/nimbus/cli/cache"
-	"github.com/nimbus/cli/config"
 	"github.com/spf13/cobra"
 )
 
-// BoxEntry is one item in the list-boxes response from the server.
-type BoxEntry struct {
-	Name string `json:"name"`
-	Size int64  `json:"size"`
-}
-
-// ListBoxesResponse is the full JSON response from GET /v1/api/boxes.
-type ListBoxesResponse struct {
-	Boxes []BoxEntry `json:"boxes"`
-}
-
 var ListBoxesCmd = &cobra.Command{
 	Use:     "bls",
 	Short:   "List all your boxes",
 	Long:    "List all boxes in your Nimbus account.",
 	Example: `nim bls`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		RDB, err := cache.NewRedisClient()
-		if err != nil {
-			return fmt.Errorf("failed to create Redis client: %w", err)
-		}
-		defer func() { _ = RDB.Close() }()
-
-		IsLoggedIn, err := cache.SessionExists(RDB)
-		if err != nil {
-			return fmt.Errorf("failed to check login status: %w", err)
-		}
-		if !IsLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
-		}
-
-		jwtToken, err := cache.GetAuthToken(RDB)
-		if err != nil {
-			return fmt.Errorf("failed to get auth token: %w", err)
-		}
-		if jwtToken == "" {
-			return fmt.Errorf("no auth token found, please login first")
-		}
-
-		req, err := http.NewRequest(http.MethodGet, config.BaseURL+"/v1/api/boxes", nil)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("failed to build request: %w", err)
+			return err
 		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
-		resp, err := (&http.Client{}).Do(req)
+		ctx, cancel := apiContext()
+		defer cancel()
+		boxes, err := c.ListBoxes(ctx)
 		if err != nil {
-			return fmt.Errorf("error listing boxes: %w", err)
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("failed to list boxes: %s — %s", resp.Status, string(errBody))
-		}
-
-		var result ListBoxesResponse
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			return fmt.Errorf("failed to parse response: %w", err)
+			return fmt.Errorf("failed to list boxes: %w", err)
 		}
 
-		if len(result.Boxes) == 0 {
+		if len(boxes) == 0 {
 			fmt.Println("No boxes found.")
 			return nil
 		}
@@ -81,7 +32,7 @@ var ListBoxesCmd = &cobra.Command{
 		fmt.Print("\n")
 		fmt.Printf("%-30s  %s\n", "NAME", "SIZE")
 		fmt.Printf("%-30s  %s\n", "----", "----")
-		for _, b := range result.Boxes {
+		for _, b := range boxes {
 			fmt.Printf("%-30s  %s\n", b.Name, formatSize(b.Size))
 		}
 		fmt.Print("\n")
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/nimbus/cli/cache"
+	"github.com/nimbus/cli/cli/animations"
+	"github.com/nimbus/cli/config"
+	"github.com/spf13/cobra"
+)
+
+var renameBoxCmd = &cobra.Command{
+	Use:   "mvbox <box-name> <new-name>",
+	Short: "Rename a box",
+	Long: "Rename a box. Nothing is copied, so this is instant however much the box holds. " +
+		"If it is the active box it stays active, at the same path, under the new name.",
+	Args:    cobra.ExactArgs(2),
+	Example: `nim mvbox my-box archive-2024`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		boxName := args[0]
+		newName := args[1]
+
+		RDB, err := cache.NewRedisClient()
+		if err != nil {
+			return fmt.Errorf("failed to create Redis client: %w", err)
+		}
+		defer func() { _ = RDB.Close() }()
+
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+
+		stop := animations.Spinner("Renaming box...")
+		renamed, err := c.RenameBox(ctx, boxName, newName)
+		stop()
+
+		if err != nil {
+			return fmt.Errorf("failed to rename box: %w", err)
+		}
+
+		// Keep "nim cb" and the active box in step with the server.
+		if err := cache.RenameBoxInCache(RDB, boxName, renamed); err != nil {
+			return fmt.Errorf("box renamed but failed to update local cache: %w", err)
+		}
+
+		fmt.Printf("Box \"%s\" renamed to \"%s\"\n", boxName, renamed)
+		if config.Token != "" && config.TokenBox == boxName {
+			fmt.Printf("Set NIM_BOX=%s to keep using it with this token.\n", renamed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renameBoxCmd)
+}
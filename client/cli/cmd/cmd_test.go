@@ -1,11 +1,19 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/nimbus/cli/api"
+	"github.com/nimbus/cli/config"
+	"github.com/nimbus/cli/nimbus"
 )
 
 // --- formatSize (box_list.go) ---
@@ -126,79 +134,82 @@ func TestCdPathResolution(t *testing.T) {
 
 // --- HTTP response parsing ---
 
+// serveJSON points config.BaseURL at a test server that answers every request
+// with status and the JSON encoding of body, as the API does.
+func serveJSON(t *testing.T, status int, body any) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(srv.Close)
+	oldURL := config.BaseURL
+	config.BaseURL = srv.URL
+	t.Cleanup(func() { config.BaseURL = oldURL })
+}
+
 func TestListBoxesResponse_Parsing(t *testing.T) {
-	payload := ListBoxesResponse{
-		Boxes: []BoxEntry{
+	serveJSON(t, http.StatusOK, map[string][]api.Box{
+		"boxes": {
 			{Name: "alpha", Size: 1024},
 			{Name: "beta", Size: 2048 * 1024},
 		},
-	}
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(payload)
-	}))
-	defer srv.Close()
+	})
 
-	resp, err := http.Get(srv.URL)
+	c, err := publicClient()
 	if err != nil {
-		t.Fatalf("GET: %v", err)
+		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-
-	var result ListBoxesResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		t.Fatalf("decode: %v", err)
+	boxes, err := c.ListBoxes(context.Background())
+	if err != nil {
+		t.Fatalf("ListBoxes: %v", err)
 	}
-	if len(result.Boxes) != 2 {
-		t.Fatalf("expected 2 boxes, got %d", len(result.Boxes))
+	if len(boxes) != 2 {
+		t.Fatalf("expected 2 boxes, got %d", len(boxes))
 	}
-	if result.Boxes[0].Name != "alpha" {
-		t.Errorf("expected Boxes[0].Name %q, got %q", "alpha", result.Boxes[0].Name)
+	if boxes[0].Name != "alpha" {
+		t.Errorf("expected Boxes[0].Name %q, got %q", "alpha", boxes[0].Name)
 	}
-	if result.Boxes[1].Size != 2048*1024 {
-		t.Errorf("expected Boxes[1].Size %d, got %d", 2048*1024, result.Boxes[1].Size)
+	if boxes[1].Size != 2048*1024 {
+		t.Errorf("expected Boxes[1].Size %d, got %d", 2048*1024, boxes[1].Size)
 	}
 }
 
 func TestListBoxesResponse_Empty(t *testing.T) {
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(ListBoxesResponse{Boxes: []BoxEntry{}})
-	}))
-	defer srv.Close()
+	serveJSON(t, http.StatusOK, map[string][]api.Box{"boxes": {}})
 
-	resp, err := http.Get(srv.URL)
+	c, err := publicClient()
 	if err != nil {
-		t.Fatalf("GET: %v", err)
+		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-
-	var result ListBoxesResponse
-	json.NewDecoder(resp.Body).Decode(&result)
-	if len(result.Boxes) != 0 {
-		t.Errorf("expected 0 boxes, got %d", len(result.Boxes))
+	boxes, err := c.ListBoxes(context.Background())
+	if err != nil {
+		t.Fatalf("ListBoxes: %v", err)
+	}
+	if len(boxes) != 0 {
+		t.Errorf("expected 0 boxes, got %d", len(boxes))
 	}
 }
 
 func TestListBoxesResponse_ErrorStatus(t *testing.T) {
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
-	}))
-	defer srv.Close()
+	serveJSON(t, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 
-	resp, err := http.Get(srv.URL)
+	c, err := publicClient()
 	if err != nil {
-		t.Fatalf("GET: %v", err)
+		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusUnauthorized {
-		t.Errorf("expected 401, got %d", resp.StatusCode)
+	_, err = c.ListBoxes(context.Background())
+	if !errors.Is(err, nimbus.ErrUnauthorized) {
+		t.Errorf("expected a 401 error, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("expected the server's message, got %v", err)
 	}
 }
 
 func TestLoginResponse_Parsing(t *testing.T) {
-	payload := map[string]any{
+	serveJSON(t, http.StatusOK, map[string]any{
 		"message": "Login successful",
 		"token":   "eyJhbGciOiJIUzI1NiJ9.test",
 		"email":   "user@example.com",
@@ -206,23 +217,17 @@ func TestLoginResponse_Parsing(t *testing.T) {
 		"box": []map[string]any{
 			{"name": "Home-Box"},
 		},
-	}
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(payload)
-	}))
-	defer srv.Close()
+	})
 
-	resp, err := http.Get(srv.URL)
+	c, err := publicClient()
 	if err != nil {
-		t.Fatalf("GET: %v", err)
+		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-
-	var result LoginResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		t.Fatalf("decode: %v", err)
+	res, err := c.API().LoginWithResponse(context.Background(), api.LoginRequest{Email: "user@example.com", Password: "pw"})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
 	}
+	result := res.JSON200
 	if result.Token != "eyJhbGciOiJIUzI1NiJ9.test" {
 		t.Errorf("unexpected token: %q", result.Token)
 	}
@@ -232,37 +237,30 @@ func TestLoginResponse_Parsing(t *testing.T) {
 	if len(result.Box) != 1 {
 		t.Fatalf("expected 1 box, got %d", len(result.Box))
 	}
-	if result.Box[0]["name"] != "Home-Box" {
-		t.Errorf("unexpected box name: %v", result.Box[0]["name"])
+	if result.Box[0].Name != "Home-Box" {
+		t.Errorf("unexpected box name: %v", result.Box[0].Name)
 	}
 }
 
 func TestListFolderResponse_Parsing(t *testing.T) {
-	payload := ListResponse{
+	serveJSON(t, http.StatusOK, api.FolderListing{
 		Path:       "/docs",
 		FolderName: "docs",
-		Files: []FileEntry{
+		Files: []api.FileEntry{
 			{ID: 1, Name: "readme.md", Size: 512, S3Key: "box/docs/readme.md"},
 		},
-		Folders: []FolderEntry{
+		Folders: []api.FolderEntry{
 			{ID: 2, Name: "images"},
 		},
-	}
-	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(payload)
-	}))
-	defer srv.Close()
+	})
 
-	resp, err := http.Get(srv.URL)
+	c, err := publicClient()
 	if err != nil {
-		t.Fatalf("GET: %v", err)
+		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-
-	var result ListResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		t.Fatalf("decode: %v", err)
+	result, err := c.ListFolder(context.Background(), "box", "")
+	if err != nil {
+		t.Fatalf("ListFolder: %v", err)
 	}
 	if len(result.Files) != 1 || result.Files[0].Name != "readme.md" {
 		t.Errorf("unexpected files: %+v", result.Files)
@@ -276,16 +274,180 @@ func TestBearerTokenAttached(t *testing.T) {
 	var gotAuth string
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		gotAuth = r.Header.Get("Authorization")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(ListBoxesResponse{})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]api.Box{"boxes": {}})
 	}))
 	defer srv.Close()
+	oldURL := config.BaseURL
+	config.BaseURL = srv.URL
+	defer func() { config.BaseURL = oldURL }()
 
-	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
-	req.Header.Set("Authorization", "Bearer my-jwt-token")
-	http.DefaultClient.Do(req)
+	c, err := newClient(nimbus.WithTokenSource(nimbus.StaticToken("my-jwt-token")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ListBoxes(context.Background()); err != nil {
+		t.Fatalf("ListBoxes: %v", err)
+	}
 
 	if gotAuth != "Bearer my-jwt-token" {
 		t.Errorf("expected Authorization header %q, got %q", "Bearer my-jwt-token", gotAuth)
 	}
 }
+
+func TestParseID(t *testing.T) {
+	if id, err := parseID("42"); err != nil || id != 42 {
+		t.Errorf("parseID(42) = %d, %v", id, err)
+	}
+	if _, err := parseID("ci-token"); err == nil {
+		t.Error("expected an error for a name")
+	}
+}
+
+// --- exit statuses and hints (errors.go) ---
+
+func TestExitCodeAndHint(t *testing.T) {
+	tests := []struct {
+		err      error
+		wantExit int
+		wantHint string
+	}{
+		{errors.New("boom"), exitFailure, ""},
+		{errNotLoggedIn, exitAuth, ""},
+		{fmt.Errorf("failed to delete box: %w", &nimbus.Error{StatusCode: 404, Code: nimbus.CodeBoxNotFound}), exitNotFound, "nim bls"},
+		{&nimbus.Error{StatusCode: 401, Code: nimbus.CodeUnauthenticated}, exitAuth, "nim login"},
+		{&nimbus.Error{StatusCode: 409, Code: nimbus.CodeLimitReached, Details: map[string]any{"limit": float64(10)}}, exitConflict, "at most 10"},
+		{&nimbus.Error{StatusCode: 507, Code: nimbus.CodeQuotaExceeded, Details: map[string]any{"quota_bytes": float64(1 << 20), "used_bytes": float64(512 << 10)}}, exitStorageFull, "512.0 KB of your 1.0 MB quota"},
+		{&nimbus.Error{StatusCode: 413, Code: nimbus.CodeFileTooLarge, Details: map[string]any{"max_bytes": float64(50 << 20)}}, exitStorageFull, "at most 50.0 MB"},
+		// Responses without a code fall back on their status.
+		{&nimbus.Error{StatusCode: 403}, exitForbidden, ""},
+		{&nimbus.Error{StatusCode: 502}, exitServer, ""},
+		{&nimbus.Error{StatusCode: 418, Code: "SOMETHING_NEW"}, exitFailure, ""},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.wantExit {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.wantExit)
+		}
+		hint := errorHint(tt.err)
+		if (tt.wantHint == "" && hint != "") || !strings.Contains(hint, tt.wantHint) {
+			t.Errorf("errorHint(%v) = %q, want it to contain %q", tt.err, hint, tt.wantHint)
+		}
+	}
+}
+
+// --- single sign-on polling (auth_sso.go) ---
+
+func TestRunSSOLogin_PollsUntilApproved(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/api/auth/oidc/device":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(api.DeviceAuthorization{DeviceCode: "nim_dc_x", UserCode: "BCDF-GHJK", ExpiresIn: 60, Interval: 1})
+		case "/v1/api/auth/oidc/device/token":
+			var req map[string]string
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["device_code"] != "nim_dc_x" {
+				t.Errorf("polled with %q", req["device_code"])
+			}
+			polls++
+			w.Header().Set("Content-Type", "application/json")
+			if polls == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"code": "AUTHORIZATION_PENDING", "error": "authorization_pending"})
+				return
+			}
+			json.NewEncoder(w).Encode(api.LoginResponse{Token: "jwt", RefreshToken: "nim_rt_x", Email: "sso@example.com"})
+		}
+	}))
+	defer srv.Close()
+	oldURL := config.BaseURL
+	config.BaseURL = srv.URL
+	defer func() { config.BaseURL = oldURL }()
+
+	var resp api.LoginResponse
+	if err := runSSOLogin(&resp); err != nil {
+		t.Fatalf("runSSOLogin: %v", err)
+	}
+	if polls != 2 || resp.Token != "jwt" || resp.Email != "sso@example.com" {
+		t.Errorf("polls = %d, response = %+v", polls, resp)
+	}
+}
+
+func TestRunSSOLogin_Denied(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/api/auth/oidc/device" {
+			json.NewEncoder(w).Encode(api.DeviceAuthorization{DeviceCode: "nim_dc_x", ExpiresIn: 60, Interval: 1})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"code": "ACCESS_DENIED", "error": "access_denied"})
+	}))
+	defer srv.Close()
+	oldURL := config.BaseURL
+	config.BaseURL = srv.URL
+	defer func() { config.BaseURL = oldURL }()
+
+	var resp api.LoginResponse
+	if err := runSSOLogin(&resp); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("expected a cancelled error, got %v", err)
+	}
+}
+
+// --- audit time filters (audit.go) ---
+
+func TestParseAuditTime(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"24h", now.Add(-24 * time.Hour)},
+		{"30m", now.Add(-30 * time.Minute)},
+		{"7d", now.AddDate(0, 0, -7)},
+		{"2026-01-02T15:04:05Z", time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2026-01-02", time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tc := range tests {
+		got, err := parseAuditTime(tc.in, now)
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("parseAuditTime(%q) = %v, %v; want %v", tc.in, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"yesterday", "-3d", "7w"} {
+		if _, err := parseAuditTime(bad, now); err == nil {
+			t.Errorf("parseAuditTime(%q) should fail", bad)
+		}
+	}
+}
+
+// --- quota sizes (admin.go) ---
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"1024", 1024},
+		{"0", 0},
+		{"unlimited", 0},
+		{"500MB", 500 << 20},
+		{"10GB", 10 << 30},
+		{"10g", 10 << 30},
+		{"1.5G", 3 << 29},
+		{"2 TiB", 2 << 40},
+		{"64k", 64 << 10},
+	}
+	for _, tc := range tests {
+		got, err := parseSize(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", tc.in, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "lots", "-1GB", "10PB", "NaN", "Inf"} {
+		if _, err := parseSize(bad); err == nil {
+			t.Errorf("parseSize(%q) should fail", bad)
+		}
+	}
+}
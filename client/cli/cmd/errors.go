@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nimbus/cli/nimbus"
+	"github.com/nimbus/cli/utils/helpers"
+)
+
+// Exit statuses, so scripts can tell failures apart without parsing
+// messages. Anything not listed exits 1.
+const (
+	exitFailure     = 1 // any other error
+	exitInvalid     = 2 // the server rejected the request as malformed
+	exitAuth        = 3 // not logged in, or the login or a credential was rejected
+	exitForbidden   = 4 // logged in, but not allowed to do this
+	exitNotFound    = 5 // no such box, folder, file, key or user
+	exitConflict    = 6 // the name is taken, or a limit was reached
+	exitStorageFull = 7 // over the storage quota or the file size limit
+	exitRateLimited = 8 // too many requests, try again later
+	exitServer      = 9 // the server failed
+)
+
+// errNotLoggedIn is returned before any request when there is no session.
+var errNotLoggedIn = errors.New("you are not logged in, please login first")
+
+// apiErrorInfo is how the CLI reports an API error code: its exit status,
+// and a hint shown under the server's message. hint may read the error's
+// details; it returns "" when there is nothing to add.
+type apiErrorInfo struct {
+	exit int
+	hint func(e *nimbus.Error) string
+}
+
+func staticHint(s string) func(*nimbus.Error) string {
+	return func(*nimbus.Error) string { return s }
+}
+
+var apiErrors = map[string]apiErrorInfo{
+	nimbus.CodeInvalidRequest:        {exitInvalid, nil},
+	nimbus.CodeUnauthenticated:       {exitAuth, staticHint(`Your session has ended. Run "nim login" to sign in again.`)},
+	nimbus.CodeInvalidCredentials:    {exitAuth, nil},
+	nimbus.CodeTwoFactorRequired:     {exitAuth, staticHint("This needs a current code from your authenticator app.")},
+	nimbus.CodeForbidden:             {exitForbidden, staticHint("Your credentials don't allow this; an access token may lack the scope or be restricted to another box.")},
+	nimbus.CodeAccountSuspended:      {exitForbidden, staticHint("Your account is suspended. Contact your administrator.")},
+	nimbus.CodePasswordResetRequired: {exitForbidden, staticHint(`Reset your password with a recovery code: run "nim login", then choose 'r'.`)},
+	nimbus.CodeNotFound:              {exitNotFound, nil},
+	nimbus.CodeBoxNotFound:           {exitNotFound, staticHint(`Run "nim bls" to see your boxes.`)},
+	nimbus.CodeFolderNotFound:        {exitNotFound, staticHint(`Run "nim ls" to see what is in the current folder.`)},
+	nimbus.CodeFileNotFound:          {exitNotFound, staticHint(`Run "nim ls" to see what is in the current folder.`)},
+	nimbus.CodeNameConflict:          {exitConflict, staticHint("Choose another name, or remove the existing one first.")},
+	nimbus.CodeAlreadyExists:         {exitConflict, nil},
+	nimbus.CodeConflict:              {exitConflict, nil},
+	nimbus.CodeLimitReached:          {exitConflict, limitHint},
+	nimbus.CodeQuotaExceeded:         {exitStorageFull, quotaHint},
+	nimbus.CodeFileTooLarge:          {exitStorageFull, fileSizeHint},
+	nimbus.CodeRateLimited:           {exitRateLimited, staticHint("Too many requests. Wait a minute, then try again.")},
+	nimbus.CodeInternal:              {exitServer, staticHint("The server failed. If it keeps happening, report it with the request ID.")},
+	nimbus.CodeNotImplemented:        {exitFailure, nil},
+}
+
+// statusExits is the exit status for responses without a known code, such
+// as ones from an older server or a proxy.
+var statusExits = map[int]int{
+	http.StatusBadRequest:          exitInvalid,
+	http.StatusUnauthorized:        exitAuth,
+	http.StatusForbidden:           exitForbidden,
+	http.StatusNotFound:            exitNotFound,
+	http.StatusConflict:            exitConflict,
+	http.StatusTooManyRequests:     exitRateLimited,
+	http.StatusInsufficientStorage: exitStorageFull,
+}
+
+// exitCode is the status nim exits with after err.
+func exitCode(err error) int {
+	if errors.Is(err, errNotLoggedIn) {
+		return exitAuth
+	}
+	var apiErr *nimbus.Error
+	if !errors.As(err, &apiErr) {
+		return exitFailure
+	}
+	if info, ok := apiErrors[apiErr.Code]; ok {
+		return info.exit
+	}
+	if exit, ok := statusExits[apiErr.StatusCode]; ok {
+		return exit
+	}
+	if apiErr.StatusCode >= 500 {
+		return exitServer
+	}
+	return exitFailure
+}
+
+// errorHint is advice on what to do about err, or "".
+func errorHint(err error) string {
+	var apiErr *nimbus.Error
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	if info, ok := apiErrors[apiErr.Code]; ok && info.hint != nil {
+		return info.hint(apiErr)
+	}
+	return ""
+}
+
+func limitHint(e *nimbus.Error) string {
+	if limit, ok := e.Details["limit"].(float64); ok {
+		return fmt.Sprintf("You can have at most %.0f. Remove one you no longer use, then try again.", limit)
+	}
+	return "Remove one you no longer use, then try again."
+}
+
+func quotaHint(e *nimbus.Error) string {
+	quota, okQuota := e.Details["quota_bytes"].(float64)
+	used, okUsed := e.Details["used_bytes"].(float64)
+	if !okQuota || !okUsed {
+		return "Free up space, or ask your administrator for a larger quota."
+	}
+	return fmt.Sprintf("You are using %s of your %s quota. Free up space, or ask your administrator for more.",
+		helpers.FormatSize(int64(used)), helpers.FormatSize(int64(quota)))
+}
+
+func fileSizeHint(e *nimbus.Error) string {
+	if maxBytes, ok := e.Details["max_bytes"].(float64); ok {
+		return fmt.Sprintf("Files can be at most %s.", helpers.FormatSize(int64(maxBytes)))
+	}
+	return ""
+}
@@ -1,15 +1,10 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
 	"github.com/spf13/cobra"
 )
 
@@ -30,39 +25,20 @@ var deleteFileCmd = &cobra.Command{
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
-		jwtToken, err := cache.GetAuthToken(RDB)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("failed to get auth token: %w", err)
+			return err
 		}
-
-		// The S3 key is appended directly to the URL path; the server reads it
-		// via c.Param("name").
-		endpoint := config.BaseURL + "/v1/api/files/" + deleteFilePathFlag
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ctx, cancel := apiContext()
 		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
-		if err != nil {
-			return fmt.Errorf("build request: %w", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
 		stop := animations.Spinner("Deleting " + deleteFilePathFlag + "...")
-		resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+		err = c.DeleteFile(ctx, deleteFilePathFlag)
 		stop()
-
 		if err != nil {
-			return fmt.Errorf("error deleting file: %w", err)
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("failed to delete file: %s — %s", resp.Status, string(errBody))
+			return fmt.Errorf("failed to delete file: %w", err)
 		}
 
 		fmt.Printf("Deleted %s\n", deleteFilePathFlag)
@@ -2,31 +2,20 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
 	"github.com/spf13/cobra"
 )
 
 var keyFlag string
 var outputFileFlag string
 
-// presignDownloadResponse is the JSON returned by GET /v1/api/files/presign-download.
-// DownloadURL is a short-lived S3 presigned GET URL the CLI uses to stream
-// the file directly from S3.
-type presignDownloadResponse struct {
-	DownloadURL string `json:"download_url"`
-}
-
 var GetFileCmd = &cobra.Command{
 	Use:   "get",
 	Short: "Download a file from Nimbus storage",
@@ -42,7 +31,7 @@ var GetFileCmd = &cobra.Command{
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		// Default the output filename to the last segment of the S3 key.
@@ -55,66 +44,22 @@ var GetFileCmd = &cobra.Command{
 			return fmt.Errorf("no current box set, please set it using 'nim cb [box-name]'")
 		}
 
-		jwtToken, err := cache.GetAuthToken(RDB)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("failed to get auth token: %w", err)
+			return err
 		}
-
-		// Step 1: Ask the server for a short-lived presigned GET URL.
-		presignEndpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/files/presign-download?box_name=%s&key=%s",
-			url.QueryEscape(currentBox),
-			url.QueryEscape(keyFlag),
-		)
-
-		presignCtx, presignCancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer presignCancel()
-
-		presignReq, err := http.NewRequestWithContext(presignCtx, http.MethodGet, presignEndpoint, nil)
-		if err != nil {
-			return fmt.Errorf("build presign request: %w", err)
-		}
-		presignReq.Header.Set("Authorization", "Bearer "+jwtToken)
+		// The presigned download URL is short-lived, and the transfer gets
+		// ten minutes.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
 
 		stop := animations.Spinner("Requesting download URL...")
-		presignResp, err := (&http.Client{Timeout: 15 * time.Second}).Do(presignReq)
+		download, err := c.Download(ctx, currentBox, keyFlag)
 		stop()
-
 		if err != nil {
-			return fmt.Errorf("error requesting download URL: %w", err)
-		}
-		defer func() { _ = presignResp.Body.Close() }()
-
-		if presignResp.StatusCode < 200 || presignResp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(presignResp.Body)
-			return fmt.Errorf("failed to get download URL: %s — %s", presignResp.Status, string(errBody))
-		}
-
-		var presignData presignDownloadResponse
-		if err := json.NewDecoder(presignResp.Body).Decode(&presignData); err != nil {
-			return fmt.Errorf("failed to parse presign response: %w", err)
-		}
-
-		// Step 2: GET the file directly from S3 and write it to disk.
-		// A ProgressWriter wraps the output file so we can show a live byte counter.
-		downloadCtx, downloadCancel := context.WithTimeout(context.Background(), 10*time.Minute)
-		defer downloadCancel()
-
-		getReq, err := http.NewRequestWithContext(downloadCtx, http.MethodGet, presignData.DownloadURL, nil)
-		if err != nil {
-			return fmt.Errorf("build download request: %w", err)
-		}
-
-		getResp, err := (&http.Client{Timeout: 10 * time.Minute}).Do(getReq)
-		if err != nil {
-			return fmt.Errorf("error downloading file from S3: %w", err)
-		}
-		defer func() { _ = getResp.Body.Close() }()
-
-		if getResp.StatusCode < 200 || getResp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(getResp.Body)
-			return fmt.Errorf("S3 download failed: %s — %s", getResp.Status, string(errBody))
+			return fmt.Errorf("failed to download file: %w", err)
 		}
+		defer func() { _ = download.Close() }()
 
 		outFile, err := os.Create(outputFileFlag)
 		if err != nil {
@@ -122,10 +67,10 @@ var GetFileCmd = &cobra.Command{
 		}
 		defer func() { _ = outFile.Close() }()
 
-		bar := animations.BytesBar(getResp.ContentLength, "Downloading "+filepath.Base(keyFlag))
+		bar := animations.BytesBar(download.Size, "Downloading "+filepath.Base(keyFlag))
 		progressWriter := &animations.ProgressWriter{Writer: outFile, Bar: bar}
 
-		if _, err = io.Copy(progressWriter, getResp.Body); err != nil {
+		if _, err = io.Copy(progressWriter, download); err != nil {
 			return fmt.Errorf("error saving file: %w", err)
 		}
 
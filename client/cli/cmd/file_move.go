@@ -1,16 +1,10 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
 	"github.com/spf13/cobra"
 )
 
@@ -30,7 +24,7 @@ var fileMoveCmd = &cobra.Command{
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		currentBox, err := cache.GetBoxName(RDB)
@@ -41,41 +35,19 @@ var fileMoveCmd = &cobra.Command{
 		s3Key, _ := cmd.Flags().GetString("key")
 		targetPath, _ := cmd.Flags().GetString("to")
 
-		jwtToken, err := cache.GetAuthToken(RDB)
-		if err != nil {
-			return fmt.Errorf("failed to get auth token: %w", err)
-		}
-
 		// Move updates the FolderID foreign key in the database.
 		// The S3 key (and therefore the actual object location) does not change.
-		endpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/files/move?box_name=%s&key=%s&target_path=%s",
-			url.QueryEscape(currentBox),
-			url.QueryEscape(s3Key),
-			url.QueryEscape(targetPath),
-		)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, nil)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("build request: %w", err)
+			return err
 		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
+		ctx, cancel := apiContext()
+		defer cancel()
 		stop := animations.Spinner("Moving file...")
-		resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+		err = c.MoveFile(ctx, currentBox, s3Key, targetPath)
 		stop()
-
 		if err != nil {
-			return fmt.Errorf("error moving file: %w", err)
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("move failed: %s — %s", resp.Status, string(errBody))
+			return fmt.Errorf("move failed: %w", err)
 		}
 
 		dest := targetPath
@@ -1,20 +1,15 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
+	"github.com/nimbus/cli/nimbus"
 	"github.com/spf13/cobra"
 )
 
@@ -23,15 +18,6 @@ var (
 	filePathFlag    string
 )
 
-// presignUploadResponse is the JSON returned by POST /v1/api/files/presign-upload.
-// UploadURL is a short-lived S3 presigned PUT URL the CLI uses to stream the
-// file directly to S3 — the bytes never pass through this server.
-type presignUploadResponse struct {
-	UploadURL string `json:"upload_url"`
-	S3Key     string `json:"s3_key"`
-	FileID    uint   `json:"file_id"`
-}
-
 var filePostCmd = &cobra.Command{
 	Use:   "post",
 	Short: "Upload a file to Nimbus storage",
@@ -51,7 +37,7 @@ nim post -f myfile.txt -d uploads/myfile.txt`,
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		currentBox, err := cache.GetBoxName(RDB)
@@ -59,11 +45,6 @@ nim post -f myfile.txt -d uploads/myfile.txt`,
 			return fmt.Errorf("no current box set, please set it using 'nim cb [box-name]'")
 		}
 
-		jwtToken, err := cache.GetAuthToken(RDB)
-		if err != nil || jwtToken == "" {
-			return fmt.Errorf("no auth token found, please login first")
-		}
-
 		f, err := os.Open(filePathFlag)
 		if err != nil {
 			return fmt.Errorf("error opening file: %w", err)
@@ -76,98 +57,26 @@ nim post -f myfile.txt -d uploads/myfile.txt`,
 		}
 		filename := filepath.Base(filePathFlag)
 
-		// Step 1: Ask the server for a short-lived presigned PUT URL.
-		// The server creates the file metadata record in the DB at this point.
-		presignEndpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/files/presign-upload?box_name=%s&filePath=%s&filename=%s&content_type=application/octet-stream&size=%d",
-			url.QueryEscape(currentBox),
-			url.QueryEscape(destinationFlag),
-			url.QueryEscape(filename),
-			fileInfo.Size(),
-		)
-
-		presignCtx, presignCancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer presignCancel()
-
-		presignReq, err := http.NewRequestWithContext(presignCtx, http.MethodPost, presignEndpoint, nil)
-		if err != nil {
-			return fmt.Errorf("build presign request: %w", err)
-		}
-		presignReq.Header.Set("Authorization", "Bearer "+jwtToken)
-
-		stop := animations.Spinner("Requesting upload URL...")
-		presignResp, err := (&http.Client{Timeout: 15 * time.Second}).Do(presignReq)
-		stop()
-
-		if err != nil {
-			return fmt.Errorf("error requesting upload URL: %w", err)
-		}
-		defer func() { _ = presignResp.Body.Close() }()
-
-		if presignResp.StatusCode < 200 || presignResp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(presignResp.Body)
-			return fmt.Errorf("failed to get upload URL: %s — %s", presignResp.Status, string(errBody))
-		}
-
-		var presignData presignUploadResponse
-		if err := json.NewDecoder(presignResp.Body).Decode(&presignData); err != nil {
-			return fmt.Errorf("failed to parse presign response: %w", err)
-		}
-
-		// Step 2: PUT the file directly to S3 using the presigned URL.
-		// A ProgressReader wraps the file data so we can show a live byte counter.
-		fileData, err := io.ReadAll(f)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("error reading file: %w", err)
+			return err
 		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
 
+		// The SDK asks the server for a presigned PUT URL, streams the file
+		// straight to S3 through it and confirms the upload, which makes the
+		// file show up in listings. A ProgressReader shows a live byte counter.
 		bar := animations.BytesBar(fileInfo.Size(), "Uploading "+filename)
-		progressReader := &animations.ProgressReader{
-			Reader: bytes.NewReader(fileData),
-			Bar:    bar,
-		}
-
-		uploadCtx, uploadCancel := context.WithTimeout(context.Background(), 10*time.Minute)
-		defer uploadCancel()
-
-		putReq, err := http.NewRequestWithContext(uploadCtx, http.MethodPut, presignData.UploadURL, progressReader)
+		_, err = c.Upload(ctx, nimbus.UploadInput{
+			Box:  currentBox,
+			Path: destinationFlag,
+			Name: filename,
+			Body: &animations.ProgressReader{Reader: f, Bar: bar},
+			Size: fileInfo.Size(),
+		})
 		if err != nil {
-			return fmt.Errorf("build PUT request: %w", err)
-		}
-		putReq.ContentLength = fileInfo.Size()
-		putReq.Header.Set("Content-Type", "application/octet-stream")
-
-		putResp, err := (&http.Client{Timeout: 10 * time.Minute}).Do(putReq)
-		if err != nil {
-			return fmt.Errorf("error uploading file to S3: %w", err)
-		}
-		defer func() { _ = putResp.Body.Close() }()
-
-		if putResp.StatusCode < 200 || putResp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(putResp.Body)
-			return fmt.Errorf("S3 upload failed: %s — %s", putResp.Status, string(errBody))
-		}
-
-		// Step 3: Tell the server the upload succeeded so it marks the file confirmed.
-		// Without this, the file is invisible in listings (treated as an incomplete upload).
-		confirmURL := fmt.Sprintf("%s/v1/api/files/%d/confirm", config.BaseURL, presignData.FileID)
-		confirmCtx, confirmCancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer confirmCancel()
-
-		confirmReq, err := http.NewRequestWithContext(confirmCtx, http.MethodPost, confirmURL, nil)
-		if err != nil {
-			return fmt.Errorf("build confirm request: %w", err)
-		}
-		confirmReq.Header.Set("Authorization", "Bearer "+jwtToken)
-
-		confirmResp, err := (&http.Client{Timeout: 10 * time.Second}).Do(confirmReq)
-		if err != nil {
-			return fmt.Errorf("upload succeeded but failed to confirm with server: %w", err)
-		}
-		defer func() { _ = confirmResp.Body.Close() }()
-
-		if confirmResp.StatusCode < 200 || confirmResp.StatusCode >= 300 {
-			return fmt.Errorf("upload succeeded but server confirmation failed: %s", confirmResp.Status)
+			return fmt.Errorf("failed to upload file: %w", err)
 		}
 
 		fmt.Printf("Uploaded %s (%d bytes)\n", filename, fileInfo.Size())
@@ -1,17 +1,10 @@
 package cmd
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
 	"github.com/spf13/cobra"
 )
 
@@ -31,7 +24,7 @@ var fileRenameCmd = &cobra.Command{
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		currentBox, err := cache.GetBoxName(RDB)
@@ -42,45 +35,21 @@ var fileRenameCmd = &cobra.Command{
 		s3Key, _ := cmd.Flags().GetString("key")
 		newName, _ := cmd.Flags().GetString("name")
 
-		jwtToken, err := cache.GetAuthToken(RDB)
-		if err != nil {
-			return fmt.Errorf("failed to get auth token: %w", err)
-		}
-
 		// Rename only updates the display name in the database; the S3 key stays
 		// the same so we don't need to copy/delete objects in S3.
-		endpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/files/rename?box_name=%s&key=%s&new_name=%s",
-			url.QueryEscape(currentBox),
-			url.QueryEscape(s3Key),
-			url.QueryEscape(newName),
-		)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, nil)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("build request: %w", err)
+			return err
 		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
+		ctx, cancel := apiContext()
+		defer cancel()
 		stop := animations.Spinner("Renaming file...")
-		resp, err := (&http.Client{Timeout: 15 * time.Second}).Do(req)
+		err = c.RenameFile(ctx, currentBox, s3Key, newName)
 		stop()
-
 		if err != nil {
-			return fmt.Errorf("error renaming file: %w", err)
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			errBody, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("rename failed: %s — %s", resp.Status, string(errBody))
+			return fmt.Errorf("rename failed: %w", err)
 		}
 
-		var result map[string]string
-		_ = json.NewDecoder(resp.Body).Decode(&result)
 		fmt.Printf("Renamed to %s\n", newName)
 		return nil
 	},
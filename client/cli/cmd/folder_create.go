@@ -1,17 +1,10 @@
 package cmd
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
 	"github.com/spf13/cobra"
 )
 
@@ -40,7 +33,7 @@ nim cdir my-folder path/to/parent`,
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		currentBox, err := cache.GetBoxName(RDB)
@@ -52,54 +45,22 @@ nim cdir my-folder path/to/parent`,
 		// an explicit destination was given as a second argument.
 		currentPath, _ := cache.GetCurrentPath(RDB)
 
-		jwtToken, err := cache.GetAuthToken(RDB)
-		if err != nil || jwtToken == "" {
-			return fmt.Errorf("no auth token found, please login first")
-		}
-
-		endpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/folders?box_name=%s&path=%s&folder_name=%s",
-			url.QueryEscape(currentBox),
-			url.QueryEscape(currentPath),
-			url.QueryEscape(folderNameFlag),
-		)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("failed to build request: %w", err)
+			return err
 		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
+		ctx, cancel := apiContext()
+		defer cancel()
 		stop := animations.Spinner("Creating folder...")
-		resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+		folder, err := c.CreateFolder(ctx, currentBox, currentPath, folderNameFlag)
 		stop()
-
 		if err != nil {
-			return fmt.Errorf("error creating folder: %w", err)
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		body, _ := io.ReadAll(resp.Body)
-
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			var errResp map[string]interface{}
-			if json.Unmarshal(body, &errResp) == nil {
-				if msg, ok := errResp["error"].(string); ok {
-					return fmt.Errorf("failed to create folder: %s", msg)
-				}
-			}
-			return fmt.Errorf("failed to create folder: %s", resp.Status)
+			return fmt.Errorf("failed to create folder: %w", err)
 		}
 
-		var result map[string]interface{}
-		if json.Unmarshal(body, &result) == nil {
-			if folder, ok := result["folder"].(string); ok {
-				fmt.Printf("Folder created: %s\n", folder)
-				return nil
-			}
+		if folder != "" {
+			fmt.Printf("Folder created: %s\n", folder)
+			return nil
 		}
 		fmt.Println("Folder created successfully")
 		return nil
@@ -1,15 +1,12 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
+	"github.com/nimbus/cli/nimbus"
 	"github.com/spf13/cobra"
 )
 
@@ -32,7 +29,7 @@ var deleteFolderCmd = &cobra.Command{
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		currentBox, err := cache.GetBoxName(RDB)
@@ -43,45 +40,25 @@ var deleteFolderCmd = &cobra.Command{
 		// Delete targets the folder at the current working path in the session.
 		currentPath, _ := cache.GetCurrentPath(RDB)
 
-		jwtToken, err := cache.GetAuthToken(RDB)
-		if err != nil || jwtToken == "" {
-			return fmt.Errorf("no auth token found, please login first")
-		}
-
 		// The server deletes all S3 objects under the folder prefix and then
 		// recursively removes the folder and its contents from the database.
-		endpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/folders?box_name=%s&path=%s&folder_name=%s",
-			url.QueryEscape(currentBox),
-			url.QueryEscape(currentPath),
-			url.QueryEscape(folderName),
-		)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("failed to build request: %w", err)
+			return err
 		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
+		ctx, cancel := apiContext()
+		defer cancel()
 		stop := animations.Spinner("Deleting folder...")
-		resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+		err = c.DeleteFolder(ctx, currentBox, currentPath, folderName)
 		stop()
 
-		if err != nil {
-			return fmt.Errorf("error deleting folder: %w", err)
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		switch resp.StatusCode {
-		case http.StatusOK:
+		switch {
+		case err == nil:
 			fmt.Printf("Folder '%s' deleted successfully\n", folderName)
-		case http.StatusNotFound:
+		case errors.Is(err, nimbus.ErrNotFound):
 			return fmt.Errorf("folder '%s' not found", folderName)
 		default:
-			return fmt.Errorf("failed to delete folder: %s", resp.Status)
+			return fmt.Errorf("failed to delete folder: %w", err)
 		}
 
 		return nil
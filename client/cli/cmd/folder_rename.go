@@ -1,15 +1,12 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/nimbus/cli/cache"
 	"github.com/nimbus/cli/cli/animations"
-	"github.com/nimbus/cli/config"
+	"github.com/nimbus/cli/nimbus"
 	"github.com/spf13/cobra"
 )
 
@@ -33,7 +30,7 @@ var renameFolderCmd = &cobra.Command{
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !isLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		currentBox, err := cache.GetBoxName(RDB)
@@ -44,48 +41,27 @@ var renameFolderCmd = &cobra.Command{
 		// The rename happens relative to the current working path in the session.
 		currentPath, _ := cache.GetCurrentPath(RDB)
 
-		jwtToken, err := cache.GetAuthToken(RDB)
-		if err != nil || jwtToken == "" {
-			return fmt.Errorf("no auth token found, please login first")
-		}
-
 		// The server copies all S3 objects to the new prefix, then deletes the
 		// old prefix, and finally updates the folder name in the database.
-		endpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/folders/rename?box_name=%s&path=%s&folder_name=%s&new_name=%s",
-			url.QueryEscape(currentBox),
-			url.QueryEscape(currentPath),
-			url.QueryEscape(folderName),
-			url.QueryEscape(newName),
-		)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, nil)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("failed to build request: %w", err)
+			return err
 		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
+		ctx, cancel := apiContext()
+		defer cancel()
 		stop := animations.Spinner("Renaming folder...")
-		resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+		err = c.RenameFolder(ctx, currentBox, currentPath, folderName, newName)
 		stop()
 
-		if err != nil {
-			return fmt.Errorf("error renaming folder: %w", err)
-		}
-		defer func() { _ = resp.Body.Close() }()
-
-		switch resp.StatusCode {
-		case http.StatusOK:
+		switch {
+		case err == nil:
 			fmt.Printf("Folder '%s' renamed to '%s'\n", folderName, newName)
-		case http.StatusNotFound:
+		case errors.Is(err, nimbus.ErrNotFound):
 			return fmt.Errorf("folder '%s' not found", folderName)
-		case http.StatusConflict:
+		case errors.Is(err, nimbus.ErrConflict):
 			return fmt.Errorf("a folder named '%s' already exists", newName)
 		default:
-			return fmt.Errorf("failed to rename folder: %s", resp.Status)
+			return fmt.Errorf("failed to rename folder: %w", err)
 		}
 
 		return nil
@@ -1,47 +1,15 @@
 package cmd
 
 import (
-	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
-	"net/url"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/nimbus/cli/cache"
-	"github.com/nimbus/cli/config"
 	"github.com/nimbus/cli/utils/helpers"
 	"github.com/spf13/cobra"
 )
 
-// ListResponse is the JSON returned by GET /v1/api/folders.
-// It describes the contents (files + sub-folders) of a single directory.
-type ListResponse struct {
-	FolderID   *uint         `json:"folder_id"`
-	FolderName string        `json:"folder_name"`
-	Path       string        `json:"path"`
-	Files      []FileEntry   `json:"files"`
-	Folders    []FolderEntry `json:"folders"`
-}
-
-// FileEntry is a single file item in a ListResponse.
-type FileEntry struct {
-	ID        uint   `json:"id"`
-	Name      string `json:"name"`
-	Size      int64  `json:"size"`
-	S3Key     string `json:"s3_key"`
-	CreatedAt string `json:"created_at"`
-}
-
-// FolderEntry is a single folder item in a ListResponse.
-type FolderEntry struct {
-	ID        uint   `json:"id"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"created_at"`
-}
-
 // cdCmd changes the working directory within the active box. The new path is
 // saved in Redis so every subsequent command uses it without re-specifying it.
 // Supports "cd .." (go up one level), absolute paths ("/some/path"), and
@@ -210,7 +178,7 @@ nim ls /some/path   # List contents of absolute path`,
 			return fmt.Errorf("failed to check login status: %w", err)
 		}
 		if !IsLoggedIn {
-			return fmt.Errorf("you are not logged in, please login first")
+			return errNotLoggedIn
 		}
 
 		CurrentBox, err := cache.GetBoxName(RDB)
@@ -240,45 +208,16 @@ nim ls /some/path   # List contents of absolute path`,
 		}
 		targetPath = strings.Trim(targetPath, "/")
 
-		endpoint := fmt.Sprintf(
-			config.BaseURL+"/v1/api/folders?box_name=%s&path=%s",
-			url.QueryEscape(CurrentBox),
-			url.QueryEscape(targetPath),
-		)
-
-		jwtToken, err := cache.GetAuthToken(RDB)
+		c, err := apiClient()
 		if err != nil {
-			return fmt.Errorf("failed to get auth token: %w", err)
+			return err
 		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ctx, cancel := apiContext()
 		defer cancel()
-
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
-		if err != nil {
-			return fmt.Errorf("failed to build request: %w", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+jwtToken)
-
-		resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+		listing, err := c.ListFolder(ctx, CurrentBox, targetPath)
 		if err != nil {
 			return fmt.Errorf("error fetching directory listing: %w", err)
 		}
-		defer func() { _ = resp.Body.Close() }()
-
-		if resp.StatusCode != http.StatusOK {
-			var errBody map[string]string
-			_ = json.NewDecoder(resp.Body).Decode(&errBody)
-			if msg, ok := errBody["error"]; ok {
-				return fmt.Errorf("%s", msg)
-			}
-			return fmt.Errorf("failed to list directory: %s", resp.Status)
-		}
-
-		var listing ListResponse
-		if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
-			return fmt.Errorf("failed to parse response: %w", err)
-		}
 
 		// Print the path header then folders before files (matching Unix ls convention).
 		displayPath := CurrentBox + listing.Path
@@ -290,7 +229,7 @@ nim ls /some/path   # List contents of absolute path`,
 		}
 
 		for _, f := range listing.Folders {
-			fmt.Printf("  [dir]  %s/\n", f.Name)
+			fmt.Printf("  [dir]  %-30s %s\n", f.Name+"/", helpers.FormatSize(f.Size))
 		}
 		for _, f := range listing.Files {
 			fmt.Printf("  [file] %-30s %s\n", f.Name, helpers.FormatSize(f.Size))
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/nimbus/cli/api"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var recoveryOutput string
+
+var recoveryCmd = &cobra.Command{
+	Use:   "recovery-codes",
+	Short: "Show how many recovery codes you have left",
+	Long: `Recovery codes are issued when you register. Each one can be used once to
+reset a forgotten password ("nim login", then 'r') or in place of a
+two-factor code. Regenerate them when you run low or think they've been
+seen; the old set stops working.`,
+	Example: `nim recovery-codes
+nim recovery-codes regenerate -o ~/nimbus-recovery-codes.txt`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, err := getRecoveryStatus()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d recovery codes left.\n", status.Remaining)
+		return nil
+	},
+}
+
+var recoveryRegenerateCmd = &cobra.Command{
+	Use:   "regenerate",
+	Short: "Replace your recovery codes with a new set",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status, err := getRecoveryStatus()
+		if err != nil {
+			return err
+		}
+
+		var req api.ReauthRequest
+		fmt.Print("Enter your password: ")
+		password, _ := term.ReadPassword(syscall.Stdin)
+		fmt.Print("\n")
+		if len(password) == 0 {
+			return fmt.Errorf("password cannot be empty")
+		}
+		req.Password = string(password)
+		if status.TOTPEnabled {
+			fmt.Print("Enter the code from your authenticator app: ")
+			fmt.Scanln(&req.Code)
+		}
+
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().RegenerateRecoveryCodesWithResponse(ctx, req)
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		if recoveryOutput != "" {
+			data := strings.Join(result.RecoveryCodes, "\n") + "\n"
+			if err := os.WriteFile(recoveryOutput, []byte(data), 0o600); err != nil {
+				return fmt.Errorf("failed to save recovery codes: %w", err)
+			}
+			fmt.Printf("New recovery codes saved to %s. Your old codes no longer work.\n", recoveryOutput)
+			return nil
+		}
+		fmt.Println("New recovery codes (each works once, they won't be shown again):")
+		printRecoveryCodes(result.RecoveryCodes)
+		fmt.Println("Your old codes no longer work.")
+		return nil
+	},
+}
+
+func getRecoveryStatus() (*api.RecoveryStatus, error) {
+	c, err := apiClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := apiContext()
+	defer cancel()
+	res, err := c.API().GetRecoveryCodeStatusWithResponse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.JSON200, nil
+}
+
+// printRecoveryCodes lists codes indented, with a blank line either side.
+func printRecoveryCodes(codes []string) {
+	fmt.Print("\n")
+	for _, c := range codes {
+		fmt.Printf("  %s\n", c)
+	}
+	fmt.Print("\n")
+}
+
+func init() {
+	rootCmd.AddCommand(recoveryCmd)
+	recoveryCmd.AddCommand(recoveryRegenerateCmd)
+	recoveryRegenerateCmd.Flags().StringVarP(&recoveryOutput, "output", "o", "", "write the codes to this file instead of the terminal")
+}
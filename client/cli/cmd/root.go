@@ -4,6 +4,7 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -25,14 +26,23 @@ Current MVP commands:
   nimbus get --file <s3-key> -o ./doc  Download a file from S3
 
 Visit https://github.com/your-org/nim-cli for more information.`,
+	// Usage is printed for mistakes on the command line, which are caught
+	// before this runs; once a command starts, its errors aren't about usage.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		cmd.SilenceUsage = true
+	},
 }
 
-// Execute is called by main.go. It runs the appropriate sub-command and exits
-// with a non-zero status code if anything goes wrong.
+// Execute is called by main.go. It runs the appropriate sub-command and, if
+// it fails, prints a hint for API errors and exits with a status that says
+// what kind of failure it was (see exitCode).
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		if hint := errorHint(err); hint != "" {
+			fmt.Fprintln(os.Stderr, hint)
+		}
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/nimbus/cli/api"
+	"github.com/spf13/cobra"
+)
+
+var s3KeyCmd = &cobra.Command{
+	Use:   "s3key",
+	Short: "Manage access keys for the S3-compatible gateway",
+	Long: `Access keys let S3 tools (aws-cli, boto3, restic, rclone) use your boxes
+through the Nimbus S3 gateway. Each box is a bucket; point the tool at the
+gateway with path-style addressing and sign requests with an access key.
+
+The secret is shown once when the key is created; revoke the key if it leaks.`,
+	Example: `nim s3key create restic
+nim s3key list
+nim s3key revoke 2`,
+}
+
+var s3KeyCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a new access key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().CreateS3KeyWithResponse(ctx, api.NameRequest{Name: args[0]})
+		if err != nil {
+			return err
+		}
+		result := res.JSON201
+
+		fmt.Printf("Access key \"%s\" created:\n\n", result.Name)
+		fmt.Printf("    Access key ID:     %s\n", result.AccessKeyID)
+		fmt.Printf("    Secret access key: %s\n\n", result.SecretAccessKey)
+		fmt.Println("Copy the secret now, it won't be shown again.")
+		return nil
+	},
+}
+
+var s3KeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your access keys",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().ListS3KeysWithResponse(ctx)
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		if len(result.S3Keys) == 0 {
+			fmt.Println("No access keys found.")
+			return nil
+		}
+
+		fmt.Print("\n")
+		fmt.Printf("%-6s  %-20s  %-20s  %-17s  %s\n", "ID", "NAME", "ACCESS KEY ID", "CREATED", "LAST USED")
+		fmt.Printf("%-6s  %-20s  %-20s  %-17s  %s\n", "--", "----", "-------------", "-------", "---------")
+		for _, k := range result.S3Keys {
+			lastUsed := "never"
+			if !k.LastUsedAt.IsZero() {
+				lastUsed = k.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%-6d  %-20s  %-20s  %-17s  %s\n", k.ID, k.Name, k.AccessKeyID, k.CreatedAt.Local().Format("2006-01-02 15:04"), lastUsed)
+		}
+		fmt.Print("\n")
+		return nil
+	},
+}
+
+var s3KeyRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an access key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if _, err := c.API().RevokeS3KeyWithResponse(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("Access key %s revoked\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(s3KeyCmd)
+	s3KeyCmd.AddCommand(s3KeyCreateCmd)
+	s3KeyCmd.AddCommand(s3KeyListCmd)
+	s3KeyCmd.AddCommand(s3KeyRevokeCmd)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List the devices logged in to your account",
+	Long: `Every "nim login" starts a session. This lists the active ones with the
+device, IP address and when each was last used; the one you are using is
+marked with *. Revoke a session you don't recognise, or run
+"nim logout --all" to sign out everywhere.`,
+	Example: `nim sessions
+nim sessions revoke 3f2a9c...`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().ListSessionsWithResponse(ctx)
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		if len(result.Sessions) == 0 {
+			fmt.Println("No active sessions found.")
+			return nil
+		}
+
+		fmt.Print("\n")
+		fmt.Printf("  %-32s  %-36s  %-15s  %-17s  %s\n", "ID", "DEVICE", "IP", "LOGGED IN", "LAST SEEN")
+		fmt.Printf("  %-32s  %-36s  %-15s  %-17s  %s\n", "--", "------", "--", "---------", "---------")
+		for _, s := range result.Sessions {
+			marker := " "
+			if s.Current {
+				marker = "*"
+			}
+			fmt.Printf("%s %-32s  %-36s  %-15s  %-17s  %s\n", marker, s.ID, s.Device, s.IP,
+				s.CreatedAt.Local().Format("2006-01-02 15:04"), s.LastSeenAt.Local().Format("2006-01-02 15:04"))
+		}
+		fmt.Print("\n")
+		return nil
+	},
+}
+
+var sessionsRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Sign a session out",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if _, err := c.API().RevokeSessionWithResponse(ctx, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Session %s revoked\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(sessionsRevokeCmd)
+}
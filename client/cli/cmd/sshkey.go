@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nimbus/cli/api"
+	"github.com/spf13/cobra"
+)
+
+var sshKeyCmd = &cobra.Command{
+	Use:   "sshkey",
+	Short: "Manage SSH public keys for SFTP access",
+	Long: `SSH keys let SFTP clients log in without a password. Use your email as the
+SFTP username; each of your boxes appears as a top-level directory.
+
+Only the public key is uploaded; the private key never leaves your machine.`,
+	Example: `nim sshkey add partner ~/.ssh/id_ed25519.pub
+nim sshkey list
+nim sshkey remove 4`,
+}
+
+var sshKeyAddCmd = &cobra.Command{
+	Use:   "add <name> <public-key-file>",
+	Short: "Upload an SSH public key",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pub, err := os.ReadFile(args[1])
+		if err != nil {
+			return fmt.Errorf("failed to read public key: %w", err)
+		}
+		if strings.Contains(string(pub), "PRIVATE KEY") {
+			return fmt.Errorf("%s is a private key, pass the .pub file instead", args[1])
+		}
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().AddSSHKeyWithResponse(ctx, api.AddSSHKeyRequest{Name: args[0], PublicKey: strings.TrimSpace(string(pub))})
+		if err != nil {
+			return err
+		}
+		result := res.JSON201
+		fmt.Printf("SSH key \"%s\" added (%s)\n", result.Name, result.Fingerprint)
+		return nil
+	},
+}
+
+var sshKeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your SSH keys",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().ListSSHKeysWithResponse(ctx)
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		if len(result.SSHKeys) == 0 {
+			fmt.Println("No SSH keys found.")
+			return nil
+		}
+
+		fmt.Print("\n")
+		fmt.Printf("%-6s  %-20s  %-12s  %-50s  %s\n", "ID", "NAME", "TYPE", "FINGERPRINT", "LAST USED")
+		fmt.Printf("%-6s  %-20s  %-12s  %-50s  %s\n", "--", "----", "----", "-----------", "---------")
+		for _, k := range result.SSHKeys {
+			lastUsed := "never"
+			if !k.LastUsedAt.IsZero() {
+				lastUsed = k.LastUsedAt.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%-6d  %-20s  %-12s  %-50s  %s\n", k.ID, k.Name, k.Type, k.Fingerprint, lastUsed)
+		}
+		fmt.Print("\n")
+		return nil
+	},
+}
+
+var sshKeyRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Remove an SSH key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if _, err := c.API().RemoveSSHKeyWithResponse(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("SSH key %s removed\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sshKeyCmd)
+	sshKeyCmd.AddCommand(sshKeyAddCmd)
+	sshKeyCmd.AddCommand(sshKeyListCmd)
+	sshKeyCmd.AddCommand(sshKeyRemoveCmd)
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nimbus/cli/api"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenScopes  string
+	tokenExpires int
+	tokenBox     string
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage personal access tokens for CI and scripts",
+	Long: `Personal access tokens authenticate the CLI and API without logging in,
+which is what CI jobs and scripts need. Each token carries scopes (read,
+write, delete, admin), expires, and can be restricted to a single box.
+
+Set NIM_TOKEN to a token (and NIM_BOX to the box to work in) and every nim
+command uses it instead of the saved login session.
+
+The token is shown once when it is created; revoke it if it leaks.`,
+	Example: `nim token create deploy --scopes read,write --box Site-Box
+nim token list
+nim token revoke 3`,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a new access token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := api.CreateAccessTokenRequest{Name: args[0], ExpiresInDays: tokenExpires, BoxName: tokenBox}
+		for _, scope := range strings.Split(tokenScopes, ",") {
+			req.Scopes = append(req.Scopes, api.CreateAccessTokenRequestScopes(scope))
+		}
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().CreateAccessTokenWithResponse(ctx, req)
+		if err != nil {
+			return err
+		}
+		result := res.JSON201
+
+		fmt.Printf("Token \"%s\" created:\n\n", result.Name)
+		fmt.Printf("    %s\n\n", result.Token)
+		fmt.Printf("Scopes:  %s\n", strings.Join(result.Scopes, ", "))
+		if result.BoxName != "" {
+			fmt.Printf("Box:     %s\n", result.BoxName)
+		}
+		fmt.Printf("Expires: %s\n\n", result.ExpiresAt.Local().Format("2006-01-02"))
+		fmt.Println("Copy the token now, it won't be shown again.")
+		return nil
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List your access tokens",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		res, err := c.API().ListAccessTokensWithResponse(ctx)
+		if err != nil {
+			return err
+		}
+		result := res.JSON200
+
+		if len(result.Tokens) == 0 {
+			fmt.Println("No access tokens found.")
+			return nil
+		}
+
+		fmt.Print("\n")
+		fmt.Printf("%-6s  %-20s  %-8s  %-20s  %-12s  %-11s  %s\n", "ID", "NAME", "TOKEN", "SCOPES", "BOX", "EXPIRES", "LAST USED")
+		fmt.Printf("%-6s  %-20s  %-8s  %-20s  %-12s  %-11s  %s\n", "--", "----", "-----", "------", "---", "-------", "---------")
+		for _, t := range result.Tokens {
+			box := t.BoxName
+			if box == "" {
+				box = "all"
+			}
+			expires := t.ExpiresAt.Local().Format("2006-01-02")
+			if time.Now().After(t.ExpiresAt) {
+				expires = "expired"
+			}
+			lastUsed := "never"
+			if !t.LastUsedAt.IsZero() {
+				lastUsed = fmt.Sprintf("%s from %s", t.LastUsedAt.Local().Format("2006-01-02 15:04"), t.LastUsedIP)
+			}
+			fmt.Printf("%-6d  %-20s  %-8s  %-20s  %-12s  %-11s  %s\n", t.ID, t.Name, "…"+t.Hint, strings.Join(t.Scopes, ","), box, expires, lastUsed)
+		}
+		fmt.Print("\n")
+		return nil
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an access token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := apiContext()
+		defer cancel()
+		if _, err := c.API().RevokeAccessTokenWithResponse(ctx, id); err != nil {
+			return err
+		}
+		fmt.Printf("Token %s revoked\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
+
+	tokenCreateCmd.Flags().StringVarP(&tokenScopes, "scopes", "s", "read", "Comma-separated scopes: read, write, delete, admin")
+	tokenCreateCmd.Flags().IntVarP(&tokenExpires, "expires", "e", 30, "Days until the token expires (max 365)")
+	tokenCreateCmd.Flags().StringVarP(&tokenBox, "box", "b", "", "Restrict the token to one box")
+}
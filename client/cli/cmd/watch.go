@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/nimbus/cli/cache"
+	"github.com/nimbus/cli/watch"
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchBoxFlag         string
+	watchDestFlag        string
+	watchDebounceFlag    time.Duration
+	watchConcurrencyFlag int
+	watchRetriesFlag     int
+	watchDaemonFlag      bool
+)
+
+// watchCmd mirrors a local directory into a box: every file created or
+// modified under <dir> is uploaded automatically. It runs in the foreground
+// until Ctrl-C, or in the background with --daemon (managed with
+// "nim watch status" and "nim watch stop").
+var watchCmd = &cobra.Command{
+	Use:   "watch <dir>",
+	Short: "Continuously upload new and modified files from a directory",
+	Long: `Watch a local directory and upload every new or modified file to a box.
+
+Bursts of changes are debounced into batches, uploads run with bounded
+concurrency, and failed uploads are retried with exponential backoff. All
+activity is appended to ~/.nimbus/watch.log.
+
+The watcher uses your current login session; if you log in again while it is
+running, it picks up the new token automatically.`,
+	Args: cobra.ExactArgs(1),
+	Example: `nim watch ./build                       # foreground, into the active box
+nim watch ./logs -d ci/logs --daemon     # background, into folder ci/logs
+nim watch status
+nim watch stop`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid directory: %w", err)
+		}
+
+		RDB, err := cache.NewRedisClient()
+		if err != nil {
+			return fmt.Errorf("failed to create Redis client: %w", err)
+		}
+		defer func() { _ = RDB.Close() }()
+
+		isLoggedIn, err := cache.SessionExists(RDB)
+		if err != nil {
+			return fmt.Errorf("failed to check login status: %w", err)
+		}
+		if !isLoggedIn {
+			return errNotLoggedIn
+		}
+
+		// Pin the box at start-up so a later "nim cb" in another terminal doesn't
+		// silently redirect a long-running watcher.
+		box := watchBoxFlag
+		if box == "" {
+			box, err = cache.GetBoxName(RDB)
+			if err != nil || box == "" {
+				return fmt.Errorf("no current box set, please set it using 'nim cb [box-name]' or pass --box")
+			}
+		}
+
+		isDaemonChild := os.Getenv(watch.DaemonEnv) != ""
+
+		if watchDaemonFlag && !isDaemonChild {
+			pid, err := watch.StartDaemon([]string{
+				"watch", dir,
+				"--box", box,
+				"--dest", watchDestFlag,
+				"--debounce", watchDebounceFlag.String(),
+				"--concurrency", strconv.Itoa(watchConcurrencyFlag),
+				"--retries", strconv.Itoa(watchRetriesFlag),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to start watcher: %w", err)
+			}
+			logPath, _ := watch.LogPath()
+			fmt.Printf("Watching %s in the background (pid %d)\n", dir, pid)
+			fmt.Printf("Log: %s\n", logPath)
+			fmt.Println("Check on it with 'nim watch status', stop it with 'nim watch stop'.")
+			return nil
+		}
+
+		logFile, err := watch.OpenLog()
+		if err != nil {
+			return fmt.Errorf("failed to open watch log: %w", err)
+		}
+		defer func() { _ = logFile.Close() }()
+
+		// In the daemon, stdout already points at the log file; in the foreground
+		// we echo to the terminal as well.
+		var out io.Writer = logFile
+		if !isDaemonChild {
+			out = io.MultiWriter(os.Stdout, logFile)
+		}
+		logger := log.New(out, "", log.LstdFlags)
+
+		c, err := apiClient()
+		if err != nil {
+			return err
+		}
+		uploader := &watch.BoxUploader{Client: c, Box: box}
+
+		w, err := watch.New(watch.Config{
+			Dir:         dir,
+			Dest:        watchDestFlag,
+			Debounce:    watchDebounceFlag,
+			Concurrency: watchConcurrencyFlag,
+			MaxRetries:  watchRetriesFlag,
+			Uploader:    uploader,
+			Logger:      logger,
+		})
+		if err != nil {
+			return err
+		}
+
+		if isDaemonChild {
+			if err := watch.WriteState(watch.State{
+				PID:       os.Getpid(),
+				Dir:       dir,
+				Box:       box,
+				Dest:      watchDestFlag,
+				StartedAt: time.Now(),
+			}); err != nil {
+				return fmt.Errorf("failed to record watcher state: %w", err)
+			}
+			defer func() { _ = watch.ClearState() }()
+		} else {
+			fmt.Println("Press Ctrl-C to stop.")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return w.Run(ctx)
+	},
+}
+
+// watchStatusCmd reports whether a background watcher is running and shows
+// the tail of its log.
+var watchStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the background watcher's status",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st, running, err := watch.Status()
+		if err != nil {
+			return fmt.Errorf("failed to read watcher state: %w", err)
+		}
+		if !running {
+			fmt.Println("No watcher is running.")
+			return nil
+		}
+
+		dest := "/" + st.Dest
+		fmt.Printf("Watching %s -> %s%s\n", st.Dir, st.Box, dest)
+		fmt.Printf("PID:     %d\n", st.PID)
+		fmt.Printf("Started: %s (%s ago)\n", st.StartedAt.Format(time.RFC3339), time.Since(st.StartedAt).Round(time.Second))
+
+		if lines, err := watch.TailLog(5); err == nil && len(lines) > 0 {
+			fmt.Println("\nRecent activity:")
+			for _, l := range lines {
+				fmt.Printf("  %s\n", l)
+			}
+		}
+		return nil
+	},
+}
+
+// watchStopCmd stops the background watcher, giving it time to finish the
+// batch it is uploading.
+var watchStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the background watcher",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		st, err := watch.Stop(30 * time.Second)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Stopped watcher for %s (pid %d)\n", st.Dir, st.PID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.AddCommand(watchStatusCmd)
+	watchCmd.AddCommand(watchStopCmd)
+
+	watchCmd.Flags().StringVarP(&watchBoxFlag, "box", "b", "", "Box to upload into (default: the active box)")
+	watchCmd.Flags().StringVarP(&watchDestFlag, "dest", "d", "", "Folder path inside the box that mirrors <dir>")
+	watchCmd.Flags().DurationVar(&watchDebounceFlag, "debounce", watch.DefaultDebounce, "Quiet period before a batch of changes is uploaded")
+	watchCmd.Flags().IntVarP(&watchConcurrencyFlag, "concurrency", "c", watch.DefaultConcurrency, "Maximum uploads in flight")
+	watchCmd.Flags().IntVar(&watchRetriesFlag, "retries", watch.DefaultMaxRetries, "Retries per file before giving up")
+	watchCmd.Flags().BoolVar(&watchDaemonFlag, "daemon", false, "Run in the background")
+}
@@ -7,6 +7,10 @@
 //	NIM_ENV=prod  NIM_API_URL=https://… nim login  → hits the ALB
 //
 // Defaults to "local" when NIM_ENV is not set.
+//
+// For CI and scripts, set NIM_TOKEN to a personal access token (see
+// "nim token create") and NIM_BOX to the box to work in. The CLI then
+// authenticates with the token and needs neither a login nor Redis.
 package config
 
 import (
@@ -24,6 +28,13 @@ var BaseURL string
 // local — it's a per-developer session store, not shared infrastructure.
 var RedisAddr string
 
+// Token is the personal access token from NIM_TOKEN. When set it replaces the
+// login session entirely (see the cache package).
+var Token string
+
+// TokenBox is the active box in token mode, from NIM_BOX.
+var TokenBox string
+
 // init runs once when the package is first imported (i.e. at CLI startup).
 // It reads NIM_ENV, sets BaseURL and RedisAddr, and exits with a clear error
 // if the configuration is invalid so the user knows exactly what to fix.
@@ -31,6 +42,9 @@ func init() {
 	// Best-effort .env load so developers don't have to export variables manually.
 	godotenv.Load()
 
+	Token = os.Getenv("NIM_TOKEN")
+	TokenBox = os.Getenv("NIM_BOX")
+
 	env := os.Getenv("NIM_ENV")
 	if env == "" {
 		env = "local"
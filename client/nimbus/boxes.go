@@ -0,0 +1,41 @@
+package nimbus
+
+import (
+	"context"
+
+	"github.com/nimbus/cli/api"
+)
+
+// Box is one of the user's storage boxes.
+type Box = api.Box
+
+// ListBoxes returns the user's boxes with their sizes.
+func (c *Client) ListBoxes(ctx context.Context) ([]Box, error) {
+	res, err := c.api.ListBoxesWithResponse(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return res.JSON200.Boxes, nil
+}
+
+// CreateBox creates an empty box called name.
+func (c *Client) CreateBox(ctx context.Context, name string) error {
+	_, err := c.api.CreateBoxWithResponse(ctx, &api.CreateBoxParams{BoxName: name})
+	return err
+}
+
+// RenameBox renames the box called name to newName, and returns the name it
+// was given: spaces become underscores, as when creating a box.
+func (c *Client) RenameBox(ctx context.Context, name, newName string) (string, error) {
+	res, err := c.api.RenameBoxWithResponse(ctx, &api.RenameBoxParams{BoxName: name, NewName: newName})
+	if err != nil {
+		return "", err
+	}
+	return res.JSON200.Box, nil
+}
+
+// DeleteBox deletes the box called name and everything in it.
+func (c *Client) DeleteBox(ctx context.Context, name string) error {
+	_, err := c.api.DeleteBoxWithResponse(ctx, &api.DeleteBoxParams{BoxName: name})
+	return err
+}
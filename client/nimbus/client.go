@@ -0,0 +1,118 @@
+// Package nimbus is a Go client for the Nimbus API. It wraps the generated
+// client in package api with what every caller needs: a pluggable token
+// source, typed errors, retries, streaming uploads and downloads through
+// presigned URLs, and iterators over paginated lists.
+//
+//	c, err := nimbus.New("https://nimbus.example.com", nimbus.WithTokenSource(nimbus.StaticToken(os.Getenv("NIM_TOKEN"))))
+//	boxes, err := c.ListBoxes(ctx)
+//
+// Methods take a context for cancellation and deadlines; the client itself has
+// no timeout so long transfers aren't cut short. The nim CLI is built on this
+// package; it adds nothing but its Redis session as the token source.
+package nimbus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/nimbus/cli/api"
+)
+
+// Client talks to one Nimbus server. It is safe for concurrent use.
+type Client struct {
+	api      *api.ClientWithResponses
+	transfer *http.Client
+}
+
+// TokenSource supplies the bearer token for each request: a session JWT or a
+// personal access token. It is asked before every request, so a source can
+// pick up a new login or refreshed token without a new Client.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource that always returns the same token, such as a
+// personal access token.
+type StaticToken string
+
+// Token implements TokenSource.
+func (t StaticToken) Token(context.Context) (string, error) { return string(t), nil }
+
+// TokenFunc adapts a function to a TokenSource.
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Token implements TokenSource.
+func (f TokenFunc) Token(ctx context.Context) (string, error) { return f(ctx) }
+
+type options struct {
+	httpClient *http.Client
+	transfer   *http.Client
+	tokens     TokenSource
+	retries    int
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithHTTPClient sends API requests with hc instead of http.DefaultClient,
+// e.g. for a custom transport or proxy. Requests to presigned storage URLs
+// use WithTransferClient's client instead.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(o *options) { o.httpClient = hc }
+}
+
+// WithTransferClient sends uploads and downloads through presigned storage
+// URLs with hc instead of http.DefaultClient. These requests never carry the
+// bearer token.
+func WithTransferClient(hc *http.Client) Option {
+	return func(o *options) { o.transfer = hc }
+}
+
+// WithTokenSource authenticates requests with tokens from ts. Without one,
+// only the public routes (registration, login, password reset and single
+// sign-on) can be used.
+func WithTokenSource(ts TokenSource) Option {
+	return func(o *options) { o.tokens = ts }
+}
+
+// WithRetries sets how many times a request is retried after a network error
+// or a 429, 502, 503 or 504 response; the default is 2 and 0 disables
+// retries. Only requests that are safe to repeat are retried: those with an
+// idempotent method, and any request the server turned away with 429.
+func WithRetries(n int) Option {
+	return func(o *options) { o.retries = max(n, 0) }
+}
+
+// New returns a Client for the server at baseURL, e.g. "http://localhost:8080".
+func New(baseURL string, opts ...Option) (*Client, error) {
+	o := options{httpClient: http.DefaultClient, transfer: http.DefaultClient, retries: 2}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	clientOpts := []api.ClientOption{api.WithHTTPClient(&doer{client: o.httpClient, retries: o.retries})}
+	if o.tokens != nil {
+		clientOpts = append(clientOpts, api.WithRequestEditorFn(func(ctx context.Context, req *http.Request) error {
+			token, err := o.tokens.Token(ctx)
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}))
+	}
+	c, err := api.NewClientWithResponses(baseURL, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API URL %q: %w", baseURL, err)
+	}
+	return &Client{api: c, transfer: o.transfer}, nil
+}
+
+// API returns the generated client for the endpoints this package has no
+// method for, such as the account, credentials and admin routes. Its requests
+// share the Client's token source, retries and errors: a response that isn't
+// 2xx comes back as an *Error, so callers only handle success.
+func (c *Client) API() *api.ClientWithResponses {
+	return c.api
+}
@@ -0,0 +1,138 @@
+package nimbus
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader is the header the API returns each request's ID in.
+const RequestIDHeader = "X-Request-ID"
+
+// Error is a response from the API that wasn't 2xx. Its message is the
+// server's, so it can be shown to users as is, e.g.
+//
+//	file not found in database (request ID 6f1c2a...)
+//
+// Match the common cases with errors.Is and the sentinel errors below, or
+// switch on Code for the exact failure:
+//
+//	if errors.Is(err, nimbus.ErrNotFound) { ... }
+//
+//	var apiErr *nimbus.Error
+//	if errors.As(err, &apiErr) && apiErr.Code == nimbus.CodeBoxNotFound { ... }
+type Error struct {
+	// StatusCode is the response's HTTP status.
+	StatusCode int
+	// Code is the stable error code, one of the Code constants or a newer
+	// one; "" when the response didn't come from the API itself, e.g. a
+	// proxy's 502.
+	Code string
+	// Message is the JSON "error" field, or the status and raw body when the
+	// response had none.
+	Message string
+	// Details holds extra data for some codes, e.g. "limit" for
+	// CodeLimitReached.
+	Details map[string]any
+	// RequestID is the ID the server logged the request under, if any.
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	if e.RequestID == "" {
+		return e.Message
+	}
+	return e.Message + " (request ID " + e.RequestID + ")"
+}
+
+// Is reports whether target is the sentinel error for e's status.
+func (e *Error) Is(target error) bool {
+	return statusErrors[e.StatusCode] == target
+}
+
+// Sentinel errors for statuses callers commonly handle. An *Error matches
+// the one for its status with errors.Is.
+var (
+	ErrUnauthorized  = &sentinel{"unauthorized"}
+	ErrForbidden     = &sentinel{"forbidden"}
+	ErrNotFound      = &sentinel{"not found"}
+	ErrConflict      = &sentinel{"conflict"}
+	ErrRateLimited   = &sentinel{"rate limited"}
+	ErrQuotaExceeded = &sentinel{"storage quota exceeded"}
+)
+
+var statusErrors = map[int]error{
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrConflict,
+	http.StatusTooManyRequests:     ErrRateLimited,
+	http.StatusInsufficientStorage: ErrQuotaExceeded,
+}
+
+// Error codes the API returns; see Error.Code.
+const (
+	CodeInvalidRequest        = "INVALID_REQUEST"
+	CodeFileTooLarge          = "FILE_TOO_LARGE"
+	CodeUnauthenticated       = "UNAUTHENTICATED"
+	CodeInvalidCredentials    = "INVALID_CREDENTIALS"
+	CodeTwoFactorRequired     = "TWO_FACTOR_REQUIRED"
+	CodeForbidden             = "FORBIDDEN"
+	CodeAccountSuspended      = "ACCOUNT_SUSPENDED"
+	CodePasswordResetRequired = "PASSWORD_RESET_REQUIRED"
+	CodeNotFound              = "NOT_FOUND"
+	CodeBoxNotFound           = "BOX_NOT_FOUND"
+	CodeFolderNotFound        = "FOLDER_NOT_FOUND"
+	CodeFileNotFound          = "FILE_NOT_FOUND"
+	CodeNameConflict          = "NAME_CONFLICT"
+	CodeAlreadyExists         = "ALREADY_EXISTS"
+	CodeConflict              = "CONFLICT"
+	CodeLimitReached          = "LIMIT_REACHED"
+	CodeQuotaExceeded         = "QUOTA_EXCEEDED"
+	CodeRateLimited           = "RATE_LIMITED"
+	CodeInternal              = "INTERNAL"
+	CodeNotImplemented        = "NOT_IMPLEMENTED"
+
+	// Errors of the single sign-on token endpoint while it is polled.
+	CodeAuthorizationPending = "AUTHORIZATION_PENDING"
+	CodeSlowDown             = "SLOW_DOWN"
+	CodeAccessDenied         = "ACCESS_DENIED"
+	CodeExpiredToken         = "EXPIRED_TOKEN"
+	CodeInvalidGrant         = "INVALID_GRANT"
+)
+
+type sentinel struct{ msg string }
+
+func (s *sentinel) Error() string { return "nimbus: " + s.msg }
+
+// responseError reads a failed response's body into an *Error. The caller
+// still closes the body.
+func responseError(resp *http.Response) *Error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var payload struct {
+		Code      string         `json:"code"`
+		Error     string         `json:"error"`
+		Details   map[string]any `json:"details"`
+		RequestID string         `json:"request_id"`
+	}
+	_ = json.Unmarshal(body, &payload)
+
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Code:       payload.Code,
+		Message:    payload.Error,
+		Details:    payload.Details,
+		RequestID:  resp.Header.Get(RequestIDHeader),
+	}
+	if e.Message == "" {
+		e.Message = resp.Status
+		if text := strings.TrimSpace(string(body)); text != "" {
+			e.Message += " — " + text
+		}
+	}
+	if e.RequestID == "" {
+		e.RequestID = payload.RequestID
+	}
+	return e
+}
@@ -0,0 +1,145 @@
+package nimbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/nimbus/cli/api"
+)
+
+// FileEntry is one file in a listing.
+type FileEntry = api.FileEntry
+
+// ListFiles returns every file in box, in all folders.
+func (c *Client) ListFiles(ctx context.Context, box string) ([]FileEntry, error) {
+	res, err := c.api.ListFilesWithResponse(ctx, &api.ListFilesParams{BoxName: box})
+	if err != nil {
+		return nil, err
+	}
+	return res.JSON200.Files, nil
+}
+
+// DeleteFile deletes the file stored under key.
+func (c *Client) DeleteFile(ctx context.Context, key string) error {
+	_, err := c.api.DeleteFileWithResponse(ctx, key)
+	return err
+}
+
+// RenameFile gives the file stored under key a new display name. Its key
+// doesn't change.
+func (c *Client) RenameFile(ctx context.Context, box, key, newName string) error {
+	_, err := c.api.RenameFileWithResponse(ctx, &api.RenameFileParams{BoxName: box, Key: key, NewName: newName})
+	return err
+}
+
+// MoveFile moves the file stored under key into the folder at targetPath; ""
+// is the box root. Its key doesn't change.
+func (c *Client) MoveFile(ctx context.Context, box, key, targetPath string) error {
+	_, err := c.api.MoveFileWithResponse(ctx, &api.MoveFileParams{BoxName: box, Key: key, TargetPath: &targetPath})
+	return err
+}
+
+// UploadInput describes a file for Upload.
+type UploadInput struct {
+	Box string
+	// Path is the folder inside the box; "" is the root.
+	Path string
+	Name string
+	// Body is read once, straight into storage; it is not buffered.
+	Body io.Reader
+	// Size is the exact number of bytes Body yields.
+	Size int64
+	// ContentType defaults to application/octet-stream.
+	ContentType string
+}
+
+// UploadResult identifies an uploaded file.
+type UploadResult struct {
+	FileID uint
+	Key    string
+}
+
+// Upload stores a file in three steps: it asks the API for a presigned URL,
+// streams Body to storage through it, then confirms the upload so the file
+// shows up in listings. The file's bytes never pass through the API server.
+func (c *Client) Upload(ctx context.Context, in UploadInput) (*UploadResult, error) {
+	contentType := in.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	res, err := c.api.PresignUploadWithResponse(ctx, &api.PresignUploadParams{
+		BoxName:     in.Box,
+		FilePath:    &in.Path,
+		Filename:    in.Name,
+		Size:        in.Size,
+		ContentType: &contentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	presign := res.JSON200
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, presign.UploadURL, in.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = in.Size
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.transfer.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error uploading to storage: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, transferError("upload", resp)
+	}
+
+	if _, err := c.api.ConfirmUploadWithResponse(ctx, presign.FileID); err != nil {
+		return nil, fmt.Errorf("upload succeeded but server confirmation failed: %w", err)
+	}
+	return &UploadResult{FileID: presign.FileID, Key: presign.S3Key}, nil
+}
+
+// Download is a file being read from storage. The caller closes it.
+type Download struct {
+	io.ReadCloser
+	// Size is the file's length in bytes, or -1 if storage didn't say.
+	Size int64
+}
+
+// Download opens the file stored under key (or called key) in box for
+// reading, through a presigned URL.
+func (c *Client) Download(ctx context.Context, box, key string) (*Download, error) {
+	res, err := c.api.PresignDownloadWithResponse(ctx, &api.PresignDownloadParams{BoxName: box, Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, res.JSON200.DownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.transfer.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading from storage: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer func() { _ = resp.Body.Close() }()
+		return nil, transferError("download", resp)
+	}
+	return &Download{ReadCloser: resp.Body, Size: resp.ContentLength}, nil
+}
+
+// transferError describes a failed request to a presigned storage URL.
+func transferError(op string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	msg := fmt.Sprintf("storage %s failed: %s", op, resp.Status)
+	if text := strings.TrimSpace(string(body)); text != "" {
+		msg += " — " + text
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,53 @@
+package nimbus
+
+import (
+	"context"
+	"io"
+
+	"github.com/nimbus/cli/api"
+)
+
+// FolderListing is the contents of one folder: its files and subfolders.
+type FolderListing = api.FolderListing
+
+// ListFolder lists the folder at path in box; "" is the box root.
+func (c *Client) ListFolder(ctx context.Context, box, path string) (*FolderListing, error) {
+	res, err := c.api.ListFolderWithResponse(ctx, &api.ListFolderParams{BoxName: box, Path: &path})
+	if err != nil {
+		return nil, err
+	}
+	return res.JSON200, nil
+}
+
+// CreateFolder creates a folder called name inside the folder at parent and
+// returns its storage prefix.
+func (c *Client) CreateFolder(ctx context.Context, box, parent, name string) (string, error) {
+	res, err := c.api.CreateFolderWithResponse(ctx, &api.CreateFolderParams{BoxName: box, Path: &parent, FolderName: name})
+	if err != nil {
+		return "", err
+	}
+	return res.JSON200.Folder, nil
+}
+
+// DeleteFolder deletes the folder called name inside parent, with everything
+// in it.
+func (c *Client) DeleteFolder(ctx context.Context, box, parent, name string) error {
+	_, err := c.api.DeleteFolderWithResponse(ctx, &api.DeleteFolderParams{BoxName: box, Path: &parent, FolderName: name})
+	return err
+}
+
+// RenameFolder renames the folder called name inside parent to newName.
+func (c *Client) RenameFolder(ctx context.Context, box, parent, name, newName string) error {
+	_, err := c.api.RenameFolderWithResponse(ctx, &api.RenameFolderParams{BoxName: box, Path: &parent, FolderName: name, NewName: newName})
+	return err
+}
+
+// DownloadFolder streams the folder called name inside parent as a zip
+// archive. The caller closes the returned reader.
+func (c *Client) DownloadFolder(ctx context.Context, box, parent, name string) (io.ReadCloser, error) {
+	resp, err := c.api.ClientInterface.DownloadFolder(ctx, &api.DownloadFolderParams{BoxName: box, Path: &parent, FolderName: name})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
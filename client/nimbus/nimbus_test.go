@@ -0,0 +1,339 @@
+package nimbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nimbus/cli/api"
+)
+
+// newTestClient serves handler and returns a Client for it.
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c, err := New(srv.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// --- errors ---
+
+func TestError_Message(t *testing.T) {
+	response := func(status int, requestID, body string) *http.Response {
+		resp := &http.Response{
+			StatusCode: status,
+			Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+		if requestID != "" {
+			resp.Header.Set(RequestIDHeader, requestID)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		resp *http.Response
+		want string
+	}{
+		{response(404, "abc123", `{"error":"file not found","request_id":"abc123"}`), "file not found (request ID abc123)"},
+		{response(403, "", `{"error":"admin role required","request_id":"from-body"}`), "admin role required (request ID from-body)"},
+		{response(502, "abc123", "bad gateway\n"), "502 Bad Gateway — bad gateway (request ID abc123)"},
+		{response(500, "", ""), "500 Internal Server Error"},
+	}
+	for _, tt := range tests {
+		if got := responseError(tt.resp).Error(); got != tt.want {
+			t.Errorf("responseError() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestError_Is(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"code": "BOX_NOT_FOUND", "error": "box not found", "details": map[string]any{"box": "missing"}})
+	})
+
+	err := c.DeleteBox(context.Background(), "missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if errors.Is(err, ErrConflict) {
+		t.Error("a 404 matched ErrConflict")
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "box not found" {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+	if apiErr.Code != CodeBoxNotFound || apiErr.Details["box"] != "missing" {
+		t.Errorf("code = %q, details = %v", apiErr.Code, apiErr.Details)
+	}
+}
+
+// --- token source ---
+
+func TestTokenSource(t *testing.T) {
+	var gotAuth string
+	calls := 0
+	tokens := TokenFunc(func(context.Context) (string, error) {
+		calls++
+		return fmt.Sprintf("token-%d", calls), nil
+	})
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		writeJSON(w, http.StatusOK, map[string][]Box{"boxes": {}})
+	}, WithTokenSource(tokens))
+
+	for _, want := range []string{"Bearer token-1", "Bearer token-2"} {
+		if _, err := c.ListBoxes(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if gotAuth != want {
+			t.Errorf("Authorization = %q, want %q", gotAuth, want)
+		}
+	}
+}
+
+func TestTokenSource_Error(t *testing.T) {
+	sent := false
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent = true
+	}, WithTokenSource(TokenFunc(func(context.Context) (string, error) {
+		return "", errors.New("you are not logged in")
+	})))
+
+	if _, err := c.ListBoxes(context.Background()); err == nil || !strings.Contains(err.Error(), "not logged in") {
+		t.Errorf("expected the token source's error, got %v", err)
+	}
+	if sent {
+		t.Error("request sent without a token")
+	}
+}
+
+// --- retries ---
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		call       func(*Client) error
+		wantCalls  int
+	}{
+		{"GET retried on 503", http.StatusServiceUnavailable, "", func(c *Client) error {
+			_, err := c.ListBoxes(context.Background())
+			return err
+		}, 3},
+		{"POST not retried on 503", http.StatusServiceUnavailable, "", func(c *Client) error {
+			return c.CreateBox(context.Background(), "b")
+		}, 1},
+		{"POST retried on 429", http.StatusTooManyRequests, "0", func(c *Client) error {
+			return c.CreateBox(context.Background(), "b")
+		}, 3},
+		{"long Retry-After not waited out", http.StatusTooManyRequests, "3600", func(c *Client) error {
+			_, err := c.ListBoxes(context.Background())
+			return err
+		}, 1},
+		{"client errors not retried", http.StatusBadRequest, "", func(c *Client) error {
+			_, err := c.ListBoxes(context.Background())
+			return err
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				writeJSON(w, tt.status, map[string]string{"error": "try again"})
+			})
+			if err := tt.call(c); err == nil {
+				t.Fatal("expected an error")
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("server saw %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetry_RecoversAndResendsBody(t *testing.T) {
+	var bodies []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) == 1 {
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "slow down"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+	}, WithRetries(1))
+
+	if _, err := c.API().ChangePasswordWithResponse(context.Background(), api.ChangePasswordRequest{CurrentPassword: "old", NewPassword: "new"}); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+	if len(bodies) != 2 || bodies[0] != bodies[1] || bodies[1] == "" {
+		t.Errorf("bodies sent: %q", bodies)
+	}
+}
+
+// --- pagination ---
+
+func TestAuditEvents_Pages(t *testing.T) {
+	var cursors []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.URL.Query().Get("cursor")
+		cursors = append(cursors, cursor)
+		switch cursor {
+		case "":
+			writeJSON(w, http.StatusOK, map[string]any{"events": []AuditEvent{{ID: 3}, {ID: 2}}, "next_cursor": "c2"})
+		case "c2":
+			writeJSON(w, http.StatusOK, map[string]any{"events": []AuditEvent{{ID: 1}}, "next_cursor": ""})
+		default:
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad cursor"})
+		}
+	})
+
+	var ids []uint
+	for e, err := range c.AuditEvents(context.Background(), nil) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, e.ID)
+	}
+	if fmt.Sprint(ids) != "[3 2 1]" || fmt.Sprint(cursors) != "[ c2]" {
+		t.Errorf("ids = %v, cursors = %q", ids, cursors)
+	}
+
+	// Stopping early fetches no more pages.
+	cursors = nil
+	for range c.AuditEvents(context.Background(), nil) {
+		break
+	}
+	if len(cursors) != 1 {
+		t.Errorf("fetched %d pages for one event", len(cursors))
+	}
+}
+
+func TestUsers_Error(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin role required"})
+	})
+
+	n := 0
+	for _, err := range c.Users(context.Background(), nil) {
+		n++
+		if !errors.Is(err, ErrForbidden) {
+			t.Errorf("expected ErrForbidden, got %v", err)
+		}
+	}
+	if n != 1 {
+		t.Errorf("yielded %d times, want once", n)
+	}
+}
+
+// --- boxes ---
+
+func TestRenameBox(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/v1/api/boxes/rename" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if q := r.URL.Query(); q.Get("box_name") != "Old" || q.Get("new_name") != "New Box" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "box renamed successfully", "box": "New_Box"})
+	})
+
+	got, err := c.RenameBox(context.Background(), "Old", "New Box")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "New_Box" {
+		t.Errorf("got %q, want New_Box", got)
+	}
+}
+
+// --- transfers ---
+
+func TestUploadDownload(t *testing.T) {
+	stored := map[string]string{}
+	var confirmed string
+	var storageAuth string
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/api/files/presign-upload":
+			q := r.URL.Query()
+			key := q.Get("box_name") + "/" + q.Get("filePath") + "/" + q.Get("filename")
+			writeJSON(w, http.StatusOK, map[string]any{"upload_url": srvURL + "/storage/" + key, "s3_key": key, "file_id": 7, "expires_in": "15m0s"})
+		case r.URL.Path == "/v1/api/files/7/confirm":
+			confirmed = "7"
+			writeJSON(w, http.StatusOK, map[string]string{"message": "confirmed", "file": "a.txt"})
+		case r.URL.Path == "/v1/api/files/presign-download":
+			writeJSON(w, http.StatusOK, map[string]string{"download_url": srvURL + "/storage/" + r.URL.Query().Get("key"), "expires_in": "15m0s"})
+		case strings.HasPrefix(r.URL.Path, "/storage/"):
+			storageAuth += r.Header.Get("Authorization")
+			key := strings.TrimPrefix(r.URL.Path, "/storage/")
+			if r.Method == http.MethodPut {
+				b, _ := io.ReadAll(r.Body)
+				stored[key] = string(b)
+				return
+			}
+			body, ok := stored[key]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			_, _ = io.WriteString(w, body)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	srvURL = srv.URL
+	c, err := New(srv.URL, WithTokenSource(StaticToken("jwt")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.Upload(context.Background(), UploadInput{Box: "b", Path: "docs", Name: "a.txt", Body: strings.NewReader("hello"), Size: 5})
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if res.FileID != 7 || res.Key != "b/docs/a.txt" || stored["b/docs/a.txt"] != "hello" || confirmed != "7" {
+		t.Errorf("result = %+v, stored = %v, confirmed = %q", res, stored, confirmed)
+	}
+
+	d, err := c.Download(context.Background(), "b", "b/docs/a.txt")
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	body, _ := io.ReadAll(d)
+	_ = d.Close()
+	if string(body) != "hello" || d.Size != 5 {
+		t.Errorf("downloaded %q (size %d)", body, d.Size)
+	}
+
+	if _, err := c.Download(context.Background(), "b", "missing"); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("expected the storage error, got %v", err)
+	}
+	if storageAuth != "" {
+		t.Errorf("storage requests carried the bearer token: %q", storageAuth)
+	}
+}
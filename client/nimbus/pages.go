@@ -0,0 +1,100 @@
+package nimbus
+
+import (
+	"context"
+	"io"
+	"iter"
+
+	"github.com/nimbus/cli/api"
+)
+
+// AuditEvent is one entry in the audit log.
+type AuditEvent = api.AuditEvent
+
+// UserSummary is one account in the admin user list.
+type UserSummary = api.UserSummary
+
+// AuditEvents iterates over the audit log, newest first, fetching pages as
+// it goes. params filters the events and may be nil; its Limit is the page
+// size and its Cursor where to start. Iteration stops at the first error,
+// which is yielded with a zero event:
+//
+//	for e, err := range c.AuditEvents(ctx, nil) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (c *Client) AuditEvents(ctx context.Context, params *api.ListAuditEventsParams) iter.Seq2[AuditEvent, error] {
+	p := api.ListAuditEventsParams{}
+	if params != nil {
+		p = *params
+	}
+	return paginate(p.Cursor, func(cursor *string) ([]AuditEvent, string, error) {
+		p.Cursor = cursor
+		res, err := c.api.ListAuditEventsWithResponse(ctx, &p)
+		if err != nil {
+			return nil, "", err
+		}
+		return res.JSON200.Events, res.JSON200.NextCursor, nil
+	})
+}
+
+// ExportAuditEvents streams the audit log matching params as JSON lines,
+// oldest first. The caller closes the returned reader.
+func (c *Client) ExportAuditEvents(ctx context.Context, params *api.ListAuditEventsParams) (io.ReadCloser, error) {
+	p := api.ListAuditEventsParams{}
+	if params != nil {
+		p = *params
+	}
+	format := api.Jsonl
+	p.Format = &format
+	resp, err := c.api.ClientInterface.ListAuditEvents(ctx, &p)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Users iterates over the accounts matching params, for admins. It pages
+// like AuditEvents.
+func (c *Client) Users(ctx context.Context, params *api.AdminListUsersParams) iter.Seq2[UserSummary, error] {
+	p := api.AdminListUsersParams{}
+	if params != nil {
+		p = *params
+	}
+	return paginate(p.Cursor, func(cursor *string) ([]UserSummary, string, error) {
+		p.Cursor = cursor
+		res, err := c.api.AdminListUsersWithResponse(ctx, &p)
+		if err != nil {
+			return nil, "", err
+		}
+		return res.JSON200.Users, res.JSON200.NextCursor, nil
+	})
+}
+
+// paginate yields the items of each page fetch returns, starting at start
+// and following next cursors until one is empty. Each iteration starts over
+// from start.
+func paginate[T any](start *string, fetch func(cursor *string) (items []T, next string, err error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		cursor := start
+		for {
+			items, next, err := fetch(cursor)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if next == "" {
+				return
+			}
+			cursor = &next
+		}
+	}
+}
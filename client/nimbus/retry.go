@@ -0,0 +1,116 @@
+package nimbus
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// retryBase is the wait before the first retry; it doubles each time.
+	retryBase = 250 * time.Millisecond
+	// maxRetryWait caps a single wait. A Retry-After longer than this isn't
+	// waited out; the 429 is returned instead.
+	maxRetryWait = 10 * time.Second
+)
+
+// doer sends the generated client's requests, retrying transient failures,
+// and turns what's left into errors: "error contacting server" when there is
+// no response, or an *Error when the status isn't 2xx.
+type doer struct {
+	client  *http.Client
+	retries int
+}
+
+func (d *doer) Do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := d.client.Do(req)
+		wait, retry := d.retryAfter(req, resp, err, attempt)
+		if retry {
+			if resp != nil {
+				_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+				_ = resp.Body.Close()
+			}
+			if req, err = rewind(req); err != nil {
+				return nil, err
+			}
+			select {
+			case <-time.After(wait):
+				continue
+			case <-req.Context().Done():
+				return nil, fmt.Errorf("error contacting server: %w", req.Context().Err())
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("error contacting server: %w", err)
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			defer func() { _ = resp.Body.Close() }()
+			return nil, responseError(resp)
+		}
+		return resp, nil
+	}
+}
+
+// retryAfter decides whether a request's outcome is worth another attempt,
+// and how long to wait first.
+func (d *doer) retryAfter(req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= d.retries || req.Context().Err() != nil {
+		return 0, false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false // the body can't be sent again
+	}
+	wait := backoff(attempt)
+	switch {
+	case err != nil:
+		return wait, idempotent(req.Method)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// The server turned the request away before doing anything, so even a
+		// POST is safe to repeat.
+		if s, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+			wait = time.Duration(s) * time.Second
+		}
+		return wait, wait <= maxRetryWait
+	case resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return wait, idempotent(req.Method)
+	}
+	return 0, false
+}
+
+// backoff is the exponential wait before retry attempt+1, with jitter so
+// clients that failed together don't retry together.
+func backoff(attempt int) time.Duration {
+	d := min(retryBase<<attempt, maxRetryWait)
+	return d/2 + rand.N(d/2+1)
+}
+
+// idempotent reports whether repeating a request with method can't change
+// the outcome.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// rewind returns a copy of req whose body can be sent again.
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.GetBody == nil {
+		return req, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	retry.Body = body
+	return retry, nil
+}
@@ -0,0 +1,204 @@
+package watch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DaemonEnv is set in the environment of the background process started by
+// StartDaemon so the child knows to run in the foreground instead of forking
+// again.
+const DaemonEnv = "NIM_WATCH_DAEMON"
+
+// State describes the running background watcher. It is written to
+// ~/.nimbus/watch.json so "nim watch status" and "nim watch stop" can find the
+// process and report what it is watching.
+type State struct {
+	PID       int       `json:"pid"`
+	Dir       string    `json:"dir"`
+	Box       string    `json:"box"`
+	Dest      string    `json:"dest"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// StateDir returns ~/.nimbus, creating it if needed. The state and log files
+// for "nim watch" both live here.
+func StateDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".nimbus")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// LogPath returns the path of the local watch log.
+func LogPath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "watch.log"), nil
+}
+
+func statePath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "watch.json"), nil
+}
+
+// OpenLog opens the local watch log for appending.
+func OpenLog() (*os.File, error) {
+	p, err := LogPath()
+	if err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+}
+
+// StartDaemon re-executes the current binary with args in a new session,
+// detached from the terminal, with stdout/stderr going to the watch log. It
+// refuses to start a second daemon while one is still alive.
+func StartDaemon(args []string) (int, error) {
+	if st, err := ReadState(); err == nil && processAlive(st.PID) {
+		return 0, fmt.Errorf("a watcher is already running (pid %d) — stop it with 'nim watch stop'", st.PID)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	logFile, err := OpenLog()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = logFile.Close() }()
+
+	c := exec.Command(exe, args...)
+	c.Env = append(os.Environ(), DaemonEnv+"=1")
+	c.Stdin = nil
+	c.Stdout = logFile
+	c.Stderr = logFile
+	detach(c)
+
+	if err := c.Start(); err != nil {
+		return 0, err
+	}
+	pid := c.Process.Pid
+	// The child outlives us; release it so no zombie bookkeeping is kept here.
+	_ = c.Process.Release()
+	return pid, nil
+}
+
+// WriteState records the running watcher. Called by the daemon itself once it
+// has started successfully.
+func WriteState(st State) error {
+	p, err := statePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o600)
+}
+
+// ReadState loads the recorded watcher state.
+func ReadState() (*State, error) {
+	p, err := statePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// ClearState removes the state file. Missing files are not an error.
+func ClearState() error {
+	p, err := statePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Status reports the recorded watcher and whether its process is still alive.
+// A stale state file (process gone) is cleaned up and reported as not running.
+func Status() (*State, bool, error) {
+	st, err := ReadState()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !processAlive(st.PID) {
+		_ = ClearState()
+		return st, false, nil
+	}
+	return st, true, nil
+}
+
+// Stop asks the running watcher to shut down and waits up to timeout for it to
+// exit, so its final batch gets a chance to finish.
+func Stop(timeout time.Duration) (*State, error) {
+	st, running, err := Status()
+	if err != nil {
+		return nil, err
+	}
+	if !running {
+		return nil, errors.New("no watcher is running")
+	}
+	if err := terminate(st.PID); err != nil {
+		return st, fmt.Errorf("failed to stop pid %d: %w", st.PID, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !processAlive(st.PID) {
+			_ = ClearState()
+			return st, nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return st, fmt.Errorf("pid %d did not exit within %s", st.PID, timeout)
+}
+
+// TailLog returns the last n lines of the watch log.
+func TailLog(n int) ([]string, error) {
+	p, err := LogPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package watch
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach starts the child in its own session so it survives the terminal
+// (and the parent shell) closing.
+func detach(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive reports whether pid refers to a running process. Signal 0
+// performs the existence/permission check without delivering anything.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// terminate sends SIGTERM so the watcher can flush its last batch.
+func terminate(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,42 @@
+//go:build windows
+
+package watch
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach starts the child in a new process group without a console window so
+// closing the terminal does not kill it.
+func detach(c *exec.Cmd) {
+	const detachedProcess = 0x00000008
+	c.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess,
+	}
+}
+
+// processAlive reports whether pid refers to a running process. On Windows
+// FindProcess opens a handle and fails when the process no longer exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
+
+// terminate kills the watcher. Windows has no SIGTERM equivalent for a
+// detached console-less process, so the final batch is not flushed.
+func terminate(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
@@ -0,0 +1,55 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nimbus/cli/auth"
+	"github.com/nimbus/cli/nimbus"
+)
+
+// uploadTimeout bounds one file's upload, from presigning to confirming.
+const uploadTimeout = 10 * time.Minute
+
+// BoxUploader uploads files into a box with the SDK, the same way as
+// "nim post". Unlike "nim post" it shows no progress bar. The client's token
+// source is asked for every request, so a daemon picks up a fresh login
+// without being restarted.
+type BoxUploader struct {
+	Client *nimbus.Client
+	Box    string
+}
+
+// Upload implements Uploader.
+func (u *BoxUploader) Upload(ctx context.Context, localPath, destDir string) error {
+	ctx = auth.NewTrace(ctx) // each file is a trace of its own
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		// The API rejects empty uploads; an empty file is usually one that is
+		// about to be written, and its Write event will queue it again.
+		return nil
+	}
+
+	_, err = u.Client.Upload(ctx, nimbus.UploadInput{
+		Box:  u.Box,
+		Path: destDir,
+		Name: filepath.Base(localPath),
+		Body: f,
+		Size: info.Size(),
+	})
+	return err
+}
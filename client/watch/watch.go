@@ -0,0 +1,339 @@
+// Package watch implements the engine behind "nim watch": it follows a local
+// directory tree with fsnotify (inotify on Linux), collapses bursts of change
+// events into batches, and hands each changed file to an Uploader with bounded
+// concurrency and retries.
+//
+// The package knows nothing about Redis or Cobra — the cmd package supplies an
+// Uploader that reads the current session, which keeps the engine testable with
+// a fake uploader and a temp directory.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Defaults used when the corresponding Config field is zero.
+const (
+	DefaultDebounce    = 2 * time.Second
+	DefaultConcurrency = 4
+	DefaultMaxRetries  = 3
+	DefaultRetryDelay  = 2 * time.Second
+)
+
+// Uploader pushes one local file into the remote box. destDir is the
+// slash-separated folder path inside the box ("" for the box root).
+type Uploader interface {
+	Upload(ctx context.Context, localPath, destDir string) error
+}
+
+// UploaderFunc adapts a plain function to the Uploader interface.
+type UploaderFunc func(ctx context.Context, localPath, destDir string) error
+
+// Upload calls f(ctx, localPath, destDir).
+func (f UploaderFunc) Upload(ctx context.Context, localPath, destDir string) error {
+	return f(ctx, localPath, destDir)
+}
+
+// Config controls a Watcher. Only Dir and Uploader are required.
+type Config struct {
+	Dir         string        // local directory to watch (recursively)
+	Dest        string        // folder path inside the box that mirrors Dir
+	Debounce    time.Duration // quiet period before a batch is flushed
+	Concurrency int           // max uploads in flight per batch
+	MaxRetries  int           // attempts after the first failure
+	RetryDelay  time.Duration // base delay, doubled after every failed attempt
+	Uploader    Uploader
+	Logger      *log.Logger
+}
+
+// Watcher follows Config.Dir and uploads changed files until its context is
+// cancelled. Create one with New and start it with Run.
+type Watcher struct {
+	cfg Config
+	fsw *fsnotify.Watcher
+
+	mu      sync.Mutex
+	pending map[string]struct{} // absolute paths changed since the last flush
+}
+
+// New validates cfg, fills in defaults, and returns a Watcher. The fsnotify
+// handle is opened here so a bad directory fails before Run is called.
+func New(cfg Config) (*Watcher, error) {
+	if cfg.Uploader == nil {
+		return nil, fmt.Errorf("watch: an uploader is required")
+	}
+	abs, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("watch: resolve %q: %w", cfg.Dir, err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, fmt.Errorf("watch: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("watch: %s is not a directory", abs)
+	}
+	cfg.Dir = abs
+	cfg.Dest = strings.Trim(cfg.Dest, "/")
+
+	if cfg.Debounce <= 0 {
+		cfg.Debounce = DefaultDebounce
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DefaultRetryDelay
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("watch: %w", err)
+	}
+	w := &Watcher{cfg: cfg, fsw: fsw, pending: make(map[string]struct{})}
+
+	// fsnotify is not recursive, so every existing sub-directory needs its own watch.
+	if err := w.addTree(abs, false); err != nil {
+		_ = fsw.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Run processes events until ctx is cancelled. Changes are collected into a
+// pending set; once no new event has arrived for Config.Debounce the set is
+// flushed as one batch. Batches are uploaded on a separate goroutine so a slow
+// upload never stops the event loop from draining the kernel's inotify queue;
+// events that arrive mid-batch simply land in the next one. A final flush runs
+// on shutdown so nothing that was already seen is dropped.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer func() { _ = w.fsw.Close() }()
+
+	w.cfg.Logger.Printf("[WATCH] Watching %s -> /%s (debounce %s, concurrency %d)",
+		w.cfg.Dir, w.cfg.Dest, w.cfg.Debounce, w.cfg.Concurrency)
+
+	// flushReq is buffered so a timer tick never blocks while a batch is running;
+	// extra ticks coalesce into the single queued request.
+	// Batches run detached from ctx so a stop request lets the batch in flight
+	// finish instead of abandoning half-uploaded files.
+	flushReq := make(chan struct{}, 1)
+	flusherDone := make(chan struct{})
+	go func() {
+		defer close(flusherDone)
+		for range flushReq {
+			w.flush(context.WithoutCancel(ctx))
+		}
+	}()
+	defer func() {
+		close(flushReq)
+		<-flusherDone
+		w.flush(context.WithoutCancel(ctx))
+		w.cfg.Logger.Printf("[WATCH] Stopped")
+	}()
+
+	timer := time.NewTimer(w.cfg.Debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			if w.handle(ev) {
+				timer.Reset(w.cfg.Debounce)
+			}
+
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			w.cfg.Logger.Printf("[WATCH] fsnotify error: %v", err)
+
+		case <-timer.C:
+			select {
+			case flushReq <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// handle records a single fsnotify event and reports whether anything was
+// queued (which restarts the debounce timer).
+func (w *Watcher) handle(ev fsnotify.Event) bool {
+	if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) {
+		return false
+	}
+	if isHidden(ev.Name) {
+		return false
+	}
+
+	info, err := os.Stat(ev.Name)
+	if err != nil {
+		// Already gone (e.g. an editor's temp file) — nothing to upload.
+		return false
+	}
+	if info.IsDir() {
+		// A new directory: watch it and queue whatever was written into it
+		// before the watch was in place.
+		if err := w.addTree(ev.Name, true); err != nil {
+			w.cfg.Logger.Printf("[WATCH] Failed to watch %s: %v", ev.Name, err)
+		}
+		return true
+	}
+	if !info.Mode().IsRegular() {
+		return false
+	}
+
+	w.mu.Lock()
+	w.pending[ev.Name] = struct{}{}
+	w.mu.Unlock()
+	return true
+}
+
+// addTree adds a watch for root and every directory below it. When queueFiles
+// is true the regular files found along the way are queued for upload.
+func (w *Watcher) addTree(root string, queueFiles bool) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != root && isHidden(p) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return w.fsw.Add(p)
+		}
+		if queueFiles && d.Type().IsRegular() {
+			w.mu.Lock()
+			w.pending[p] = struct{}{}
+			w.mu.Unlock()
+		}
+		return nil
+	})
+}
+
+// flush drains the pending set and uploads it with at most Concurrency
+// uploads in flight. It blocks until the whole batch has finished; Run only
+// ever calls it from one goroutine at a time.
+func (w *Watcher) flush(ctx context.Context) {
+	w.mu.Lock()
+	batch := make([]string, 0, len(w.pending))
+	for p := range w.pending {
+		batch = append(batch, p)
+	}
+	w.pending = make(map[string]struct{})
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+	sort.Strings(batch)
+	w.cfg.Logger.Printf("[WATCH] Uploading batch of %d file(s)", len(batch))
+
+	sem := make(chan struct{}, w.cfg.Concurrency)
+	var wg sync.WaitGroup
+	var failed int
+	var failedMu sync.Mutex
+
+	for _, p := range batch {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(p string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := w.uploadWithRetry(ctx, p); err != nil {
+				failedMu.Lock()
+				failed++
+				failedMu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	w.cfg.Logger.Printf("[WATCH] Batch done: %d uploaded, %d failed", len(batch)-failed, failed)
+}
+
+// uploadWithRetry uploads one file, retrying with exponential backoff. Files
+// that disappeared before their turn are skipped without counting as a failure.
+func (w *Watcher) uploadWithRetry(ctx context.Context, p string) error {
+	rel, err := filepath.Rel(w.cfg.Dir, p)
+	if err != nil {
+		return err
+	}
+	destDir := w.destDir(rel)
+
+	delay := w.cfg.RetryDelay
+	for attempt := 0; ; attempt++ {
+		if _, statErr := os.Stat(p); statErr != nil {
+			w.cfg.Logger.Printf("[WATCH] Skipped %s: %v", rel, statErr)
+			return nil
+		}
+
+		err = w.cfg.Uploader.Upload(ctx, p, destDir)
+		if err == nil {
+			w.cfg.Logger.Printf("[WATCH] Uploaded %s", rel)
+			return nil
+		}
+		if attempt >= w.cfg.MaxRetries {
+			w.cfg.Logger.Printf("[WATCH] Failed %s after %d attempt(s): %v", rel, attempt+1, err)
+			return err
+		}
+
+		w.cfg.Logger.Printf("[WATCH] Retry %d/%d for %s in %s: %v", attempt+1, w.cfg.MaxRetries, rel, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// destDir maps a file path relative to the watched root onto the folder path
+// inside the box, e.g. "logs/app.log" with Dest "ci" becomes "ci/logs".
+func (w *Watcher) destDir(rel string) string {
+	dir := filepath.ToSlash(filepath.Dir(rel))
+	if dir == "." {
+		dir = ""
+	}
+	switch {
+	case w.cfg.Dest == "":
+		return dir
+	case dir == "":
+		return w.cfg.Dest
+	default:
+		return w.cfg.Dest + "/" + dir
+	}
+}
+
+// isHidden reports whether the final path element is a dotfile. Editors and
+// tools (".git", ".swp", ".DS_Store") generate lots of churn there that users
+// don't want mirrored into a box.
+func isHidden(p string) bool {
+	return strings.HasPrefix(filepath.Base(p), ".")
+}
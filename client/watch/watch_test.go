@@ -0,0 +1,202 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a fake Uploader that remembers every call and can be told to
+// fail a number of times per file before succeeding.
+type recorder struct {
+	mu       sync.Mutex
+	calls    map[string]int    // localPath -> attempts
+	dests    map[string]string // localPath -> destDir of the last attempt
+	failures int               // failures to return per file before succeeding
+}
+
+func newRecorder(failures int) *recorder {
+	return &recorder{calls: map[string]int{}, dests: map[string]string{}, failures: failures}
+}
+
+func (r *recorder) Upload(_ context.Context, localPath, destDir string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[localPath]++
+	r.dests[localPath] = destDir
+	if r.calls[localPath] <= r.failures {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func (r *recorder) uploaded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for p, n := range r.calls {
+		if n > r.failures {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// startWatcher runs a Watcher on dir until the test ends.
+func startWatcher(t *testing.T, dir string, rec *recorder, dest string) {
+	t.Helper()
+	w, err := New(Config{
+		Dir:        dir,
+		Dest:       dest,
+		Debounce:   100 * time.Millisecond,
+		RetryDelay: 10 * time.Millisecond,
+		MaxRetries: 3,
+		Uploader:   rec,
+		Logger:     log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = w.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitFor polls cond until it is true or the timeout elapses.
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("condition not met before timeout")
+}
+
+func writeFile(t *testing.T, p, content string) {
+	t.Helper()
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", p, err)
+	}
+}
+
+func TestNew_RejectsMissingDir(t *testing.T) {
+	_, err := New(Config{Dir: filepath.Join(t.TempDir(), "nope"), Uploader: newRecorder(0)})
+	if err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+}
+
+func TestNew_RequiresUploader(t *testing.T) {
+	if _, err := New(Config{Dir: t.TempDir()}); err == nil {
+		t.Fatal("expected an error without an uploader")
+	}
+}
+
+func TestWatch_DebouncesBurstIntoOneUploadPerFile(t *testing.T) {
+	dir := t.TempDir()
+	rec := newRecorder(0)
+	startWatcher(t, dir, rec, "")
+
+	p := filepath.Join(dir, "build.log")
+	for i := 0; i < 10; i++ {
+		writeFile(t, p, "line\n")
+	}
+
+	waitFor(t, 3*time.Second, func() bool { return len(rec.uploaded()) == 1 })
+	// Give a second debounce window the chance to (wrongly) fire again.
+	time.Sleep(300 * time.Millisecond)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.calls[p] != 1 {
+		t.Errorf("expected 1 upload for a burst of writes, got %d", rec.calls[p])
+	}
+}
+
+func TestWatch_RetriesFailedUploads(t *testing.T) {
+	dir := t.TempDir()
+	rec := newRecorder(2)
+	startWatcher(t, dir, rec, "")
+
+	p := filepath.Join(dir, "artifact.bin")
+	writeFile(t, p, "data")
+
+	waitFor(t, 3*time.Second, func() bool { return len(rec.uploaded()) == 1 })
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.calls[p] != 3 {
+		t.Errorf("expected 2 failures + 1 success = 3 attempts, got %d", rec.calls[p])
+	}
+}
+
+func TestWatch_NewSubdirectoryIsWatchedAndMappedToDest(t *testing.T) {
+	dir := t.TempDir()
+	rec := newRecorder(0)
+	startWatcher(t, dir, rec, "ci")
+
+	sub := filepath.Join(dir, "logs", "nightly")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(sub, "run.txt")
+	writeFile(t, p, "ok")
+
+	waitFor(t, 3*time.Second, func() bool { return len(rec.uploaded()) == 1 })
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if got := rec.dests[p]; got != "ci/logs/nightly" {
+		t.Errorf("destDir = %q, want %q", got, "ci/logs/nightly")
+	}
+}
+
+func TestWatch_IgnoresHiddenFiles(t *testing.T) {
+	dir := t.TempDir()
+	rec := newRecorder(0)
+	startWatcher(t, dir, rec, "")
+
+	writeFile(t, filepath.Join(dir, ".swp"), "x")
+	visible := filepath.Join(dir, "visible.txt")
+	writeFile(t, visible, "x")
+
+	waitFor(t, 3*time.Second, func() bool { return len(rec.uploaded()) == 1 })
+	if got := rec.uploaded(); got[0] != visible {
+		t.Errorf("uploaded %v, want only %s", got, visible)
+	}
+}
+
+func TestDestDir(t *testing.T) {
+	tests := []struct {
+		dest, rel, want string
+	}{
+		{"", "a.txt", ""},
+		{"", "logs/a.txt", "logs"},
+		{"ci", "a.txt", "ci"},
+		{"ci", "logs/x/a.txt", "ci/logs/x"},
+	}
+	for _, tc := range tests {
+		w := &Watcher{cfg: Config{Dest: tc.dest}}
+		if got := w.destDir(filepath.FromSlash(tc.rel)); got != tc.want {
+			t.Errorf("destDir(dest=%q, rel=%q) = %q, want %q", tc.dest, tc.rel, got, tc.want)
+		}
+	}
+}
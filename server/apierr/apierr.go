@@ -0,0 +1,152 @@
+// Package apierr is the API's error envelope. Every JSON error response has
+// the same shape:
+//
+//	{"code": "BOX_NOT_FOUND", "error": "box not found", "details": {...}, "request_id": "..."}
+//
+// code is stable, for programs to switch on; error is the message for people
+// and may change wording. The message keeps the "error" key responses have
+// always used, so older clients still show it. details is optional extra
+// data specific to the code, e.g. the quota for QUOTA_EXCEEDED.
+//
+// Each code has exactly one HTTP status, so a failure gets the same status
+// whichever handler reports it.
+package apierr
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/middleware/requestid"
+)
+
+// Code identifies what went wrong. Codes are part of the API: add new ones,
+// but never rename or reuse one.
+type Code string
+
+const (
+	InvalidRequest Code = "INVALID_REQUEST" // a parameter or body is missing or malformed
+	FileTooLarge   Code = "FILE_TOO_LARGE"  // an upload over the per-file limit
+
+	Unauthenticated    Code = "UNAUTHENTICATED"     // no credential, or an expired or revoked one
+	InvalidCredentials Code = "INVALID_CREDENTIALS" // a wrong email, password, code or recovery code
+	TwoFactorRequired  Code = "TWO_FACTOR_REQUIRED" // the action needs a current two-factor code
+
+	Forbidden             Code = "FORBIDDEN"               // the credential doesn't allow this
+	AccountSuspended      Code = "ACCOUNT_SUSPENDED"       // an admin suspended the account
+	PasswordResetRequired Code = "PASSWORD_RESET_REQUIRED" // an admin requires a new password first
+
+	NotFound       Code = "NOT_FOUND" // a user, session, token or key
+	BoxNotFound    Code = "BOX_NOT_FOUND"
+	FolderNotFound Code = "FOLDER_NOT_FOUND"
+	FileNotFound   Code = "FILE_NOT_FOUND"
+
+	NameConflict  Code = "NAME_CONFLICT"  // a box, folder or file with that name exists
+	AlreadyExists Code = "ALREADY_EXISTS" // an account, email or key that is already registered
+	Conflict      Code = "CONFLICT"       // the account is already in the requested state
+	LimitReached  Code = "LIMIT_REACHED"  // too many tokens, keys or app passwords
+
+	QuotaExceeded Code = "QUOTA_EXCEEDED"
+	RateLimited   Code = "RATE_LIMITED"
+
+	Internal       Code = "INTERNAL"
+	NotImplemented Code = "NOT_IMPLEMENTED"
+
+	// The device authorization grant (RFC 8628) polls the token endpoint
+	// until sign-in finishes; these are its OAuth errors, whose lowercase
+	// form is the message.
+	AuthorizationPending Code = "AUTHORIZATION_PENDING"
+	SlowDown             Code = "SLOW_DOWN"
+	AccessDenied         Code = "ACCESS_DENIED"
+	ExpiredToken         Code = "EXPIRED_TOKEN"
+	InvalidGrant         Code = "INVALID_GRANT"
+)
+
+var statuses = map[Code]int{
+	InvalidRequest:        http.StatusBadRequest,
+	FileTooLarge:          http.StatusRequestEntityTooLarge,
+	Unauthenticated:       http.StatusUnauthorized,
+	InvalidCredentials:    http.StatusUnauthorized,
+	TwoFactorRequired:     http.StatusUnauthorized,
+	Forbidden:             http.StatusForbidden,
+	AccountSuspended:      http.StatusForbidden,
+	PasswordResetRequired: http.StatusForbidden,
+	NotFound:              http.StatusNotFound,
+	BoxNotFound:           http.StatusNotFound,
+	FolderNotFound:        http.StatusNotFound,
+	FileNotFound:          http.StatusNotFound,
+	NameConflict:          http.StatusConflict,
+	AlreadyExists:         http.StatusConflict,
+	Conflict:              http.StatusConflict,
+	LimitReached:          http.StatusConflict,
+	QuotaExceeded:         http.StatusInsufficientStorage,
+	RateLimited:           http.StatusTooManyRequests,
+	Internal:              http.StatusInternalServerError,
+	NotImplemented:        http.StatusNotImplemented,
+	AuthorizationPending:  http.StatusBadRequest,
+	SlowDown:              http.StatusBadRequest,
+	AccessDenied:          http.StatusBadRequest,
+	ExpiredToken:          http.StatusBadRequest,
+	InvalidGrant:          http.StatusBadRequest,
+}
+
+// Status is the HTTP status responses with code get; 500 for an unknown code.
+func (code Code) Status() int {
+	if status, ok := statuses[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Body is the JSON body of an error response.
+type Body struct {
+	Code      Code           `json:"code"`
+	Message   string         `json:"error"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// Error is an API error returned by code below the handlers, such as
+// validation helpers, for the handler to Respond with as is.
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]any
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// New returns an *Error.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Respond writes the envelope for code with its status.
+func Respond(c *gin.Context, code Code, message string) {
+	RespondDetails(c, code, message, nil)
+}
+
+// RespondDetails is Respond with details.
+func RespondDetails(c *gin.Context, code Code, message string, details map[string]any) {
+	c.JSON(code.Status(), body(c, code, message, details))
+}
+
+// RespondError writes err's envelope when it is an *Error, and INTERNAL with
+// fallback as the message otherwise, so internal error text isn't leaked.
+func RespondError(c *gin.Context, err error, fallback string) {
+	var e *Error
+	if errors.As(err, &e) {
+		RespondDetails(c, e.Code, e.Message, e.Details)
+		return
+	}
+	Respond(c, Internal, fallback)
+}
+
+// Abort is Respond for middleware: it also stops the handler chain.
+func Abort(c *gin.Context, code Code, message string) {
+	c.AbortWithStatusJSON(code.Status(), body(c, code, message, nil))
+}
+
+func body(c *gin.Context, code Code, message string, details map[string]any) Body {
+	return Body{Code: code, Message: message, Details: details, RequestID: requestid.Get(c)}
+}
@@ -0,0 +1,313 @@
+// Package migrate keeps the database schema in step with the code through
+// numbered SQL migrations. Each NNNN_name.up.sql in migrations/ has a
+// NNNN_name.down.sql that undoes it, and the versions applied are recorded in
+// the schema_migrations table.
+//
+// Migrations run from "server migrate up" (see server.RunCommand), never as a
+// side effect of starting the server; the server only checks that none are
+// pending (see Migrator.Check).
+package migrate
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+// FS holds the migrations built into the server.
+var FS, _ = fs.Sub(embedded, "migrations")
+
+// lockID is the Postgres advisory lock held while migrating. Of several
+// instances or deploy jobs migrating at once, one does the work; the others
+// wait for it and then find nothing left to do.
+const lockID = 0x6e696d627573 // "nimbus"
+
+// ErrSchemaBehind is wrapped by the error Check returns when migrations are
+// pending.
+var ErrSchemaBehind = errors.New("database schema is behind this build")
+
+// fileName matches a migration script, e.g. "0002_add_box_key.up.sql".
+var fileName = regexp.MustCompile(`^(\d{4})_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one schema change and the script that reverses it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string { return label(m.Version, m.Name) }
+
+// State is a migration's standing in the database. AppliedAt is nil while it
+// is pending. Unknown marks a version recorded in the database but not known
+// to this build, because a newer build applied it.
+type State struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	Unknown   bool
+}
+
+func (s State) String() string { return label(s.Version, s.Name) }
+
+func label(version int, name string) string {
+	return fmt.Sprintf("%04d_%s", version, name)
+}
+
+// applied is a row of schema_migrations.
+type applied struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// Load reads the migrations in the top directory of fsys, in version order.
+// Versions must run from 1 without gaps, and each needs both scripts.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	type scripts struct {
+		Migration
+		hasUp, hasDown bool
+	}
+	byVersion := map[int]*scripts{}
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("%s: migration files are named NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("reading migrations: %w", err)
+		}
+
+		s := byVersion[version]
+		if s == nil {
+			s = &scripts{Migration: Migration{Version: version, Name: m[2]}}
+			byVersion[version] = s
+		}
+		if s.Name != m[2] {
+			return nil, fmt.Errorf("migration %04d has two names, %s and %s", version, s.Name, m[2])
+		}
+		if m[3] == "up" {
+			s.Up, s.hasUp = string(body), true
+		} else {
+			s.Down, s.hasDown = string(body), true
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for v := 1; v <= len(byVersion); v++ {
+		s, ok := byVersion[v]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("migration %04d is missing", v)
+		case !s.hasUp:
+			return nil, fmt.Errorf("migration %s has no up script", s.Migration)
+		case !s.hasDown:
+			return nil, fmt.Errorf("migration %s has no down script", s.Migration)
+		}
+		migrations = append(migrations, s.Migration)
+	}
+	return migrations, nil
+}
+
+// Migrator applies and rolls back migrations on one database.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New returns a Migrator for db with the migrations in fsys, usually FS.
+func New(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest is the version the migrations bring the schema to.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Status lists every migration this build has, then any version only a newer
+// build knows, in version order.
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	rows, err := appliedRows(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]applied, len(rows))
+	for _, r := range rows {
+		done[r.Version] = r
+	}
+
+	states := make([]State, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := State{Version: mig.Version, Name: mig.Name}
+		if r, ok := done[mig.Version]; ok {
+			s.AppliedAt = &r.AppliedAt
+		}
+		states = append(states, s)
+	}
+	for _, r := range rows {
+		if r.Version > m.Latest() {
+			states = append(states, State{Version: r.Version, Name: r.Name, AppliedAt: &r.AppliedAt, Unknown: true})
+		}
+	}
+	return states, nil
+}
+
+// Check returns an error wrapping ErrSchemaBehind if any migration is
+// pending. A schema ahead of this build passes, so instances of the previous
+// release keep running while a new one rolls out after migrating.
+func (m *Migrator) Check(ctx context.Context) error {
+	states, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, s := range states {
+		if s.AppliedAt == nil {
+			pending = append(pending, s.String())
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d migration(s) pending, from %s; run \"server migrate up\"", ErrSchemaBehind, len(pending), pending[0])
+	}
+	return nil
+}
+
+// Up applies every pending migration in order, each in its own transaction,
+// and returns the ones it applied. It stops at the first that fails, leaving
+// the earlier ones applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		rows, err := appliedRows(conn)
+		if err != nil {
+			return err
+		}
+		have := make(map[int]bool, len(rows))
+		for _, r := range rows {
+			have[r.Version] = true
+		}
+
+		for _, mig := range m.migrations {
+			if have[mig.Version] {
+				continue
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := execScript(ctx, tx, mig.Up); err != nil {
+					return err
+				}
+				return tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+					mig.Version, mig.Name, time.Now().UTC()).Error
+			})
+			if err != nil {
+				return fmt.Errorf("applying %s: %w", mig, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the n most recently applied migrations, newest first, and
+// returns the ones it rolled back.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		rows, err := appliedRows(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(rows) - 1; i >= 0 && len(done) < n; i-- {
+			r := rows[i]
+			if r.Version > m.Latest() {
+				return fmt.Errorf("%s was applied by a newer build; roll it back with that build", label(r.Version, r.Name))
+			}
+			mig := m.migrations[r.Version-1]
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := execScript(ctx, tx, mig.Down); err != nil {
+					return err
+				}
+				return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", mig.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("rolling back %s: %w", mig, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// locked runs fn on a single connection holding the migration lock, once
+// schema_migrations exists. SQLite, used by the tests, has one writer at a
+// time anyway and no advisory locks.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if conn.Dialector.Name() == "postgres" {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", lockID).Error; err != nil {
+				return fmt.Errorf("taking the migration lock: %w", err)
+			}
+			// Unlock even if ctx is done, or the pooled connection keeps the lock.
+			defer conn.WithContext(context.WithoutCancel(ctx)).Exec("SELECT pg_advisory_unlock(?)", lockID)
+		}
+
+		timestamp := "timestamp"
+		if conn.Dialector.Name() == "postgres" {
+			timestamp = "timestamptz"
+		}
+		if err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at ` + timestamp + ` NOT NULL
+		)`).Error; err != nil {
+			return fmt.Errorf("creating schema_migrations: %w", err)
+		}
+		return fn(conn)
+	})
+}
+
+// appliedRows returns schema_migrations in version order, or nothing if the
+// table doesn't exist yet.
+func appliedRows(db *gorm.DB) ([]applied, error) {
+	if !db.Migrator().HasTable("schema_migrations") {
+		return nil, nil
+	}
+	var rows []applied
+	if err := db.Raw("SELECT version, name, applied_at FROM schema_migrations ORDER BY version").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	return rows, nil
+}
+
+// execScript runs a whole migration script. It goes to the driver directly:
+// GORM would read any "?" in the SQL as a placeholder, and both drivers run
+// several statements in one call when there are no arguments.
+func execScript(ctx context.Context, tx *gorm.DB, script string) error {
+	_, err := tx.Statement.ConnPool.ExecContext(ctx, script)
+	return err
+}
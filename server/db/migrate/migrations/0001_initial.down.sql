@@ -0,0 +1,6 @@
+-- Drops the whole schema, and every account, box and file record with it.
+
+DROP TABLE IF EXISTS "files";
+DROP TABLE IF EXISTS "folders";
+DROP TABLE IF EXISTS "boxes";
+DROP TABLE IF EXISTS "users";
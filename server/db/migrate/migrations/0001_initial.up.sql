@@ -0,0 +1,74 @@
+-- The schema AutoMigrate created before migrations: accounts, boxes,
+-- folders and files. Databases it created already match, so every statement
+-- is IF NOT EXISTS and this only records them at version 1; the tables and
+-- columns added since come in the migrations after it, written the same way.
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" bigint,
+    "email" text NOT NULL,
+    "password" text NOT NULL,
+    "pass_key" text NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_users_email" UNIQUE ("email")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "boxes" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "box_id" bigint NOT NULL,
+    "name" text NOT NULL,
+    "size" bigint DEFAULT 0,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_users_boxes" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_boxes_box_id" ON "boxes" ("box_id");
+CREATE INDEX IF NOT EXISTS "idx_boxes_user_id" ON "boxes" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_boxes_deleted_at" ON "boxes" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "folders" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "name" text NOT NULL,
+    "user_id" bigint NOT NULL,
+    "box_id" bigint NOT NULL,
+    "parent_id" bigint,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_folders_sub_folders" FOREIGN KEY ("parent_id") REFERENCES "folders"("id"),
+    CONSTRAINT "fk_boxes_folders" FOREIGN KEY ("box_id") REFERENCES "boxes"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_folders_parent_id" ON "folders" ("parent_id");
+CREATE INDEX IF NOT EXISTS "idx_folders_box_id" ON "folders" ("box_id");
+CREATE INDEX IF NOT EXISTS "idx_folders_user_id" ON "folders" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_folders_deleted_at" ON "folders" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "files" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "name" text NOT NULL,
+    "size" bigint DEFAULT 0,
+    "s3_key" text NOT NULL,
+    "confirmed" boolean NOT NULL DEFAULT false,
+    "user_id" bigint NOT NULL,
+    "box_id" bigint NOT NULL,
+    "folder_id" bigint,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_files_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_folders_files" FOREIGN KEY ("folder_id") REFERENCES "folders"("id"),
+    CONSTRAINT "fk_boxes_files" FOREIGN KEY ("box_id") REFERENCES "boxes"("id") ON DELETE CASCADE,
+    CONSTRAINT "uni_files_s3_key" UNIQUE ("s3_key")
+);
+CREATE INDEX IF NOT EXISTS "idx_files_folder_id" ON "files" ("folder_id");
+CREATE INDEX IF NOT EXISTS "idx_files_box_id" ON "files" ("box_id");
+CREATE INDEX IF NOT EXISTS "idx_files_user_id" ON "files" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_files_deleted_at" ON "files" ("deleted_at");
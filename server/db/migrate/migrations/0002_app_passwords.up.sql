@@ -0,0 +1,18 @@
+-- App passwords, for WebDAV clients that can only send a username and
+-- password.
+
+CREATE TABLE IF NOT EXISTS "app_passwords" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "name" text NOT NULL,
+    "hash" text NOT NULL,
+    "last_used_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_app_passwords_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_app_passwords_hash" ON "app_passwords" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_app_passwords_user_id" ON "app_passwords" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_app_passwords_deleted_at" ON "app_passwords" ("deleted_at");
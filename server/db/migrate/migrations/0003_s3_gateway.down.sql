@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "s3_uploads";
+DROP TABLE IF EXISTS "s3_access_keys";
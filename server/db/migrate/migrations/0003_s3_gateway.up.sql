@@ -0,0 +1,38 @@
+-- Access keys for the S3-compatible gateway, and the multipart uploads in
+-- progress through it.
+
+CREATE TABLE IF NOT EXISTS "s3_access_keys" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "name" text NOT NULL,
+    "access_key_id" text NOT NULL,
+    "secret_key" text NOT NULL,
+    "last_used_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_s3_access_keys_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_s3_access_keys_access_key_id" ON "s3_access_keys" ("access_key_id");
+CREATE INDEX IF NOT EXISTS "idx_s3_access_keys_user_id" ON "s3_access_keys" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_s3_access_keys_deleted_at" ON "s3_access_keys" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "s3_uploads" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "upload_id" text NOT NULL,
+    "user_id" bigint NOT NULL,
+    "box_id" bigint NOT NULL,
+    "path" text NOT NULL,
+    "s3_key" text NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_s3_uploads_box" FOREIGN KEY ("box_id") REFERENCES "boxes"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_s3_uploads_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_s3_uploads_box_id" ON "s3_uploads" ("box_id");
+CREATE INDEX IF NOT EXISTS "idx_s3_uploads_user_id" ON "s3_uploads" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_s3_uploads_upload_id" ON "s3_uploads" ("upload_id");
+CREATE INDEX IF NOT EXISTS "idx_s3_uploads_deleted_at" ON "s3_uploads" ("deleted_at");
@@ -0,0 +1,18 @@
+-- Public keys that sign in to the SFTP server.
+
+CREATE TABLE IF NOT EXISTS "ssh_keys" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "name" text NOT NULL,
+    "public_key" text NOT NULL,
+    "fingerprint" text NOT NULL,
+    "last_used_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_ssh_keys_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_ssh_keys_fingerprint" ON "ssh_keys" ("fingerprint");
+CREATE INDEX IF NOT EXISTS "idx_ssh_keys_user_id" ON "ssh_keys" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_ssh_keys_deleted_at" ON "ssh_keys" ("deleted_at");
@@ -0,0 +1,24 @@
+-- Scoped tokens for CI and automation.
+
+CREATE TABLE IF NOT EXISTS "personal_access_tokens" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "name" text NOT NULL,
+    "hash" text NOT NULL,
+    "hint" text NOT NULL,
+    "scopes" text NOT NULL,
+    "box_id" bigint,
+    "expires_at" timestamptz NOT NULL,
+    "last_used_at" timestamptz,
+    "last_used_ip" text,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_personal_access_tokens_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_personal_access_tokens_box" FOREIGN KEY ("box_id") REFERENCES "boxes"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_personal_access_tokens_box_id" ON "personal_access_tokens" ("box_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_personal_access_tokens_hash" ON "personal_access_tokens" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_personal_access_tokens_user_id" ON "personal_access_tokens" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_personal_access_tokens_deleted_at" ON "personal_access_tokens" ("deleted_at");
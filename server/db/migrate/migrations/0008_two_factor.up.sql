@@ -0,0 +1,39 @@
+-- TOTP two-factor authentication, the recovery codes that stand in for a
+-- lost authenticator, and the challenges a login answers with a code.
+
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_secret" text;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_enabled" boolean NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_last_step" bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "hash" text NOT NULL,
+    "used_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_recovery_codes_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_recovery_codes_hash" ON "recovery_codes" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_recovery_codes_deleted_at" ON "recovery_codes" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "login_challenges" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "hash" text NOT NULL,
+    "device" text,
+    "attempts" bigint NOT NULL DEFAULT 0,
+    "expires_at" timestamptz NOT NULL,
+    "used_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_login_challenges_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_login_challenges_hash" ON "login_challenges" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_login_challenges_user_id" ON "login_challenges" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_login_challenges_deleted_at" ON "login_challenges" ("deleted_at");
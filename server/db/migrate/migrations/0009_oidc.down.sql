@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "device_authorizations";
+DROP TABLE IF EXISTS "o_id_c_auth_requests";
+DROP TABLE IF EXISTS "identities";
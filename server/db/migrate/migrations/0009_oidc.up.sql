@@ -0,0 +1,54 @@
+-- OpenID Connect: linked identities, browser logins in progress and
+-- device-flow authorizations for the CLI.
+
+CREATE TABLE IF NOT EXISTS "identities" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "issuer" text NOT NULL,
+    "subject" text NOT NULL,
+    "email" text,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_identities_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_identity_subject" ON "identities" ("issuer","subject");
+CREATE INDEX IF NOT EXISTS "idx_identities_user_id" ON "identities" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_identities_deleted_at" ON "identities" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "o_id_c_auth_requests" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "state_hash" text NOT NULL,
+    "code_verifier" text NOT NULL,
+    "nonce" text NOT NULL,
+    "device_authorization_id" bigint,
+    "expires_at" timestamptz,
+    "used_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_o_id_c_auth_requests_state_hash" ON "o_id_c_auth_requests" ("state_hash");
+CREATE INDEX IF NOT EXISTS "idx_o_id_c_auth_requests_deleted_at" ON "o_id_c_auth_requests" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "device_authorizations" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "device_code_hash" text NOT NULL,
+    "user_code" text NOT NULL,
+    "device" text,
+    "expires_at" timestamptz NOT NULL,
+    "last_polled_at" timestamptz,
+    "user_id" bigint,
+    "approved_at" timestamptz,
+    "denied_at" timestamptz,
+    "consumed_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_device_authorizations_user_code" ON "device_authorizations" ("user_code");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_device_authorizations_device_code_hash" ON "device_authorizations" ("device_code_hash");
+CREATE INDEX IF NOT EXISTS "idx_device_authorizations_deleted_at" ON "device_authorizations" ("deleted_at");
@@ -0,0 +1,23 @@
+-- The audit log.
+
+CREATE TABLE IF NOT EXISTS "audit_events" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "user_id" bigint,
+    "actor" text,
+    "protocol" text,
+    "action" text NOT NULL,
+    "box" text,
+    "path" text,
+    "result" text NOT NULL,
+    "status" bigint,
+    "ip" text,
+    "user_agent" text,
+    "request_id" text,
+    "detail" text,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_audit_events_request_id" ON "audit_events" ("request_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_action" ON "audit_events" ("action");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_user_id" ON "audit_events" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_events_created_at" ON "audit_events" ("created_at");
@@ -0,0 +1,14 @@
+-- The keys JWTs are signed with, rotated and published as a JWKS.
+
+CREATE TABLE IF NOT EXISTS "signing_keys" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "kid" text NOT NULL,
+    "algorithm" text NOT NULL,
+    "private_key" text NOT NULL,
+    "activates_at" timestamptz NOT NULL,
+    "expires_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_signing_keys_expires_at" ON "signing_keys" ("expires_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_signing_keys_k_id" ON "signing_keys" ("kid");
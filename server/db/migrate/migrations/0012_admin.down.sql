@@ -0,0 +1,8 @@
+-- Every account goes back to being an ordinary, unsuspended user without a
+-- quota.
+
+ALTER TABLE "users" DROP COLUMN IF EXISTS "quota_bytes";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "password_reset_required";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "suspend_reason";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "suspended_at";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "role";
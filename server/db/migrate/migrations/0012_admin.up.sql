@@ -0,0 +1,8 @@
+-- Account administration: roles, suspension, forced password resets and
+-- storage quotas. A quota of 0 is unlimited.
+
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "role" text NOT NULL DEFAULT 'user';
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "suspended_at" timestamptz;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "suspend_reason" text;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "password_reset_required" boolean NOT NULL DEFAULT false;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "quota_bytes" bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,16 @@
+-- Without the column, a box's objects are found by its name again. That only
+-- holds for boxes never renamed and created before this migration, so it
+-- refuses while any other box exists.
+
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM "boxes"
+        WHERE "s3_prefix" <> 'users/nim-user-' || "user_id" || '/boxes/' || "name" || '/'
+    ) THEN
+        RAISE EXCEPTION 'some boxes were renamed or created since 0013_box_s3_prefix; their objects would be lost';
+    END IF;
+END
+$$;
+
+ALTER TABLE "boxes" DROP COLUMN "s3_prefix";
@@ -0,0 +1,7 @@
+-- Boxes keep their objects under a fixed prefix rather than one built from
+-- their name, so they can be renamed. Existing boxes keep the prefix their
+-- objects are already under.
+
+ALTER TABLE "boxes" ADD COLUMN "s3_prefix" text;
+UPDATE "boxes" SET "s3_prefix" = 'users/nim-user-' || "user_id" || '/boxes/' || "name" || '/';
+ALTER TABLE "boxes" ALTER COLUMN "s3_prefix" SET NOT NULL;
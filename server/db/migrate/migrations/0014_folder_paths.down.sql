@@ -0,0 +1,5 @@
+-- Folders renamed to resolve duplicate siblings keep their new names.
+
+DROP INDEX IF EXISTS "idx_folders_box_parent_name";
+DROP INDEX IF EXISTS "idx_folders_box_path";
+ALTER TABLE "folders" DROP COLUMN "path";
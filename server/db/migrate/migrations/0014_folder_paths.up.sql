@@ -0,0 +1,40 @@
+-- Folders store their full path inside the box, so a path resolves, and a
+-- subtree is selected, with one indexed query instead of a walk up or down
+-- the parent_id chain.
+
+ALTER TABLE "folders" ADD COLUMN "path" text;
+
+-- Folders whose parent row was hard-deleted would get no path; they move to
+-- the root of their box, where the next step renames any that clash.
+UPDATE "folders" AS f SET "parent_id" = NULL
+WHERE f."parent_id" IS NOT NULL
+  AND NOT EXISTS (SELECT 1 FROM "folders" AS p WHERE p."id" = f."parent_id");
+
+-- Sibling folders were never required to have distinct names, but paths must
+-- be unique. All but the oldest of each set of live duplicates get their ID
+-- appended to their name; they were unreachable by path before anyway.
+UPDATE "folders" AS f SET "name" = f."name" || '-' || f."id"
+WHERE f."deleted_at" IS NULL AND EXISTS (
+    SELECT 1 FROM "folders" AS o
+    WHERE o."deleted_at" IS NULL
+      AND o."box_id" = f."box_id"
+      AND o."parent_id" IS NOT DISTINCT FROM f."parent_id"
+      AND o."name" = f."name"
+      AND o."id" < f."id"
+);
+
+WITH RECURSIVE "tree" ("id", "path") AS (
+    SELECT "id", "name"::text FROM "folders" WHERE "parent_id" IS NULL
+    UNION ALL
+    SELECT f."id", t."path" || '/' || f."name"
+    FROM "folders" AS f JOIN "tree" AS t ON f."parent_id" = t."id"
+)
+UPDATE "folders" SET "path" = "tree"."path" FROM "tree" WHERE "folders"."id" = "tree"."id";
+
+ALTER TABLE "folders" ALTER COLUMN "path" SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_folders_box_path" ON "folders" ("box_id", "path") WHERE "deleted_at" IS NULL;
+-- Sibling names are unique in their own right, not only through the paths
+-- they produce; COALESCE makes root folders, whose parent_id is NULL, count as
+-- siblings of each other.
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_folders_box_parent_name" ON "folders" ("box_id", COALESCE("parent_id", 0), "name") WHERE "deleted_at" IS NULL;
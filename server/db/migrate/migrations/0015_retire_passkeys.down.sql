@@ -0,0 +1,3 @@
+-- Retired passkeys can't be restored; the column comes back empty.
+
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "pass_key" text NOT NULL DEFAULT '';
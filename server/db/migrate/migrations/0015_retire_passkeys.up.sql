@@ -0,0 +1,6 @@
+-- Accounts registered before recovery codes reset their password with a
+-- 4-character passkey. Passkeys are retired: the column goes, and accounts
+-- that were never issued recovery codes are given their first set, shown
+-- once, the next time they log in.
+
+ALTER TABLE "users" DROP COLUMN IF EXISTS "pass_key";
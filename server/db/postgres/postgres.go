@@ -1,16 +1,17 @@
-// Package postgres handles connecting to PostgreSQL via GORM and keeping the
-// schema up to date with AutoMigrate.
+// Package postgres handles connecting to PostgreSQL via GORM. The schema is
+// managed separately, by the migrations in package migrate.
 package postgres
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
-	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/tracing"
 	"github.com/nimbus/api/utils"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 // Connection-pool defaults. These are conservative for a small RDS instance
@@ -24,19 +25,35 @@ const (
 	connMaxIdleTime = 5 * time.Minute
 )
 
-// Connect reads DATABASE_URL from the environment, opens a GORM connection,
-// and automatically creates/updates all tables to match the current model
-// definitions. Returns the ready-to-use *gorm.DB handle.
+// slowQueryThreshold is how long a query may take before it is logged.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// Connect reads DATABASE_URL from the environment and opens a GORM
+// connection with a bounded pool. It doesn't touch the schema; callers that
+// need it current check with migrate.Migrator.Check.
 func Connect() (*gorm.DB, error) {
 	dsn, err := utils.GetEnv("DATABASE_URL")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get DATABASE_URL from environment: %w", err)
 	}
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// Queries go to the structured log only when they fail or are slow, and
+	// without their parameters, which can hold emails and hashes.
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.NewSlogLogger(slog.Default(), logger.Config{
+			SlowThreshold:             slowQueryThreshold,
+			LogLevel:                  logger.Warn,
+			IgnoreRecordNotFoundError: true,
+			ParameterizedQueries:      true,
+		}),
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := tracing.InstrumentDB(db); err != nil {
+		return nil, fmt.Errorf("failed to trace database queries: %w", err)
+	}
+
 	// Configure the underlying connection pool. Without this GORM uses Go's
 	// database/sql defaults (unlimited open connections), which can exhaust a
 	// small RDS instance under load. Bounding the pool also fails fast instead
@@ -50,19 +67,6 @@ func Connect() (*gorm.DB, error) {
 	sqlDB.SetConnMaxLifetime(connMaxLifetime)
 	sqlDB.SetConnMaxIdleTime(connMaxIdleTime)
 
-	// AutoMigrate compares each model struct to the live schema and adds any
-	// missing columns or tables. It never drops columns, so it's safe to run
-	// on every startup.
-	err = db.AutoMigrate(
-		&models.User{},
-		&models.Box{},
-		&models.Folder{},
-		&models.File{},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to auto-migrate database schema: %w", err)
-	}
-
-	log.Println("Successfully connected to PostgreSQL and migrated schema")
+	slog.Info("Connected to PostgreSQL")
 	return db, nil
 }
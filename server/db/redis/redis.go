@@ -1,6 +1,7 @@
 // Package redis provides an optional Redis connection for the API server. Redis
-// backs the cross-instance rate limiter; when REDIS_ADDR is unset the server
-// falls back to in-memory limiting, so Redis is not required for local dev.
+// backs the cross-instance rate limiter and the list of revoked login
+// sessions; when REDIS_ADDR is unset the server falls back to in-memory
+// limiting and database lookups, so Redis is not required for local dev.
 package redis
 
 import (
@@ -9,6 +10,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -28,6 +30,12 @@ func Connect(ctx context.Context) (*redis.Client, error) {
 		DB:       0,
 	})
 
+	// Commands are traced as part of the request that sent them.
+	if err := redisotel.InstrumentTracing(client); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to trace Redis: %w", err)
+	}
+
 	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	if err := client.Ping(pingCtx).Err(); err != nil {
@@ -7,6 +7,9 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/nimbus/api/logging"
+	"github.com/nimbus/api/metrics"
+	"github.com/nimbus/api/tracing"
 )
 
 // Config bundles the S3 client and the bucket name together so handlers
@@ -18,12 +21,14 @@ type Config struct {
 
 // Connect loads the default AWS credential chain (env vars, ~/.aws/credentials,
 // EC2 instance role, etc.) for the given region and returns a ready-to-use S3
-// client. When running locally with LocalStack the AWS SDK reads S3_ENDPOINT
-// from the environment automatically via the default config loader.
+// client whose calls are measured, logged and traced (see the InstrumentS3
+// functions in metrics, logging and tracing). When running locally with LocalStack the AWS SDK
+// reads S3_ENDPOINT from the environment automatically via the default config
+// loader.
 func Connect(ctx context.Context, region string) (*s3.Client, error) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
 	if err != nil {
 		return nil, err
 	}
-	return s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = false }), nil
+	return s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = false }, metrics.InstrumentS3, logging.InstrumentS3, tracing.InstrumentS3), nil
 }
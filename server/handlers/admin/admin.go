@@ -0,0 +1,402 @@
+// Package admin serves the operator endpoints under /v1/api/admin: finding
+// users and their storage, suspending accounts, forcing password resets,
+// revoking sessions, setting quotas and looking inside any box read-only.
+// The routes need the admin role (see jwt.RequireAdmin) and every request is
+// audited under the admin's account, with the user it targeted in the detail.
+package admin
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+	"gorm.io/gorm"
+)
+
+// DEFAULT_PAGE_SIZE and MAX_PAGE_SIZE bound a page of ListUsers.
+// MAX_SUSPEND_REASON caps the reason stored with a suspension.
+const (
+	DEFAULT_PAGE_SIZE  = 50
+	MAX_PAGE_SIZE      = 500
+	MAX_SUSPEND_REASON = 500
+)
+
+// likeEscaper escapes the LIKE wildcards in a search term.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// UserSummary is how a user is listed: the account and its storage totals.
+type UserSummary struct {
+	ID                    uint       `json:"id"`
+	Email                 string     `json:"email"`
+	Role                  string     `json:"role"`
+	CreatedAt             time.Time  `json:"created_at"`
+	SuspendedAt           *time.Time `json:"suspended_at,omitempty"`
+	SuspendReason         string     `json:"suspend_reason,omitempty"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	TOTPEnabled           bool       `json:"totp_enabled"`
+	QuotaBytes            int64      `json:"quota_bytes"` // 0: no limit
+	UsedBytes             int64      `json:"used_bytes"`
+	Boxes                 int        `json:"boxes"`
+}
+
+// BoxSummary is one of a user's boxes in GetUser.
+type BoxSummary struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// TreeEntry is one folder or file in BoxTree.
+type TreeEntry struct {
+	Path     string    `json:"path"`
+	IsDir    bool      `json:"is_dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// boxTotals is a user's storage, summed over their boxes.
+type boxTotals struct {
+	UserID uint
+	Used   int64
+	Boxes  int
+}
+
+func summarize(u *models.User, t boxTotals) UserSummary {
+	return UserSummary{
+		ID:                    u.ID,
+		Email:                 u.Email,
+		Role:                  u.Role,
+		CreatedAt:             u.CreatedAt,
+		SuspendedAt:           u.SuspendedAt,
+		SuspendReason:         u.SuspendReason,
+		PasswordResetRequired: u.PasswordResetRequired,
+		TOTPEnabled:           u.TOTPEnabled,
+		QuotaBytes:            u.QuotaBytes,
+		UsedBytes:             t.Used,
+		Boxes:                 t.Boxes,
+	}
+}
+
+// totals sums the boxes of each of userIDs.
+func totals(db *gorm.DB, userIDs []uint) (map[uint]boxTotals, error) {
+	var rows []boxTotals
+	if err := db.Model(&models.Box{}).
+		Select("user_id, COALESCE(SUM(size), 0) AS used, COUNT(*) AS boxes").
+		Where("user_id IN ?", userIDs).Group("user_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	byUser := make(map[uint]boxTotals, len(rows))
+	for _, r := range rows {
+		byUser[r.UserID] = r
+	}
+	return byUser, nil
+}
+
+// targetUser loads the user named by the :user route parameter, an ID or an
+// email, and notes it on the audit event. It answers 404 itself when there is
+// no such user.
+func targetUser(c *gin.Context, db *gorm.DB) (*models.User, bool) {
+	ref := c.Param("user")
+	audit.Detail(c, "user: %s", ref)
+	q := db.Where("email = ?", ref)
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		q = db.Where("id = ?", id)
+	}
+	var u models.User
+	if err := q.First(&u).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			slog.ErrorContext(c.Request.Context(), "Admin user lookup failed", "ref", ref, "error", err)
+			apierr.Respond(c, apierr.Internal, "failed to load user")
+			return nil, false
+		}
+		apierr.Respond(c, apierr.NotFound, "user not found")
+		return nil, false
+	}
+	audit.Detail(c, "user: %d %s", u.ID, u.Email)
+	return &u, true
+}
+
+// notSelf answers 400 and returns false when the admin targets their own
+// account, which would lock them out.
+func notSelf(c *gin.Context, u *models.User) bool {
+	if u.ID == jwt.CurrentUser(c).ID {
+		apierr.Respond(c, apierr.InvalidRequest, "you can't do that to your own account")
+		return false
+	}
+	return true
+}
+
+// ListUsers lists accounts with their storage totals, ordered by ID. Query
+// parameters narrow it down:
+//
+//	q          part of the email, or an exact user ID
+//	role       user or admin
+//	suspended  true or false
+//	limit      page size (default 50, at most 500)
+//	cursor     next_cursor from the previous page
+func ListUsers(c *gin.Context, db *gorm.DB) {
+	q := db.Model(&models.User{})
+	if term := strings.TrimSpace(c.Query("q")); term != "" {
+		if id, err := strconv.ParseUint(term, 10, 64); err == nil {
+			q = q.Where("id = ?", id)
+		} else {
+			q = q.Where("LOWER(email) LIKE ? ESCAPE '\\'", "%"+likeEscaper.Replace(strings.ToLower(term))+"%")
+		}
+	}
+	if role := c.Query("role"); role != "" {
+		q = q.Where("role = ?", role)
+	}
+	switch c.Query("suspended") {
+	case "true":
+		q = q.Where("suspended_at IS NOT NULL")
+	case "false":
+		q = q.Where("suspended_at IS NULL")
+	}
+
+	limit := DEFAULT_PAGE_SIZE
+	if s := c.Query("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > MAX_PAGE_SIZE {
+			apierr.Respond(c, apierr.InvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+	}
+	if s := c.Query("cursor"); s != "" {
+		after, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			apierr.Respond(c, apierr.InvalidRequest, "invalid cursor")
+			return
+		}
+		q = q.Where("id > ?", after)
+	}
+
+	var users []models.User
+	if err := q.Order("id").Limit(limit + 1).Find(&users).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Admin user list failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to list users")
+		return
+	}
+	var nextCursor string
+	if len(users) > limit {
+		users = users[:limit]
+		nextCursor = strconv.FormatUint(uint64(users[limit-1].ID), 10)
+	}
+
+	ids := make([]uint, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+	}
+	byUser, err := totals(db, ids)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Admin storage totals failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to list users")
+		return
+	}
+	out := make([]UserSummary, len(users))
+	for i := range users {
+		out[i] = summarize(&users[i], byUser[users[i].ID])
+	}
+	c.JSON(http.StatusOK, gin.H{"users": out, "next_cursor": nextCursor})
+}
+
+// GetUser returns one account with its boxes and how many live sessions it
+// has.
+func GetUser(c *gin.Context, db *gorm.DB) {
+	u, ok := targetUser(c, db)
+	if !ok {
+		return
+	}
+
+	var boxes []BoxSummary
+	if err := db.Model(&models.Box{}).Select("name, size").Where("user_id = ?", u.ID).
+		Order("name").Scan(&boxes).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to load boxes")
+		return
+	}
+	var sessions int64
+	db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", u.ID, time.Now()).Count(&sessions)
+
+	t := boxTotals{Boxes: len(boxes)}
+	for _, b := range boxes {
+		t.Used += b.Size
+	}
+	if boxes == nil {
+		boxes = []BoxSummary{}
+	}
+	c.JSON(http.StatusOK, gin.H{"user": summarize(u, t), "boxes": boxes, "sessions": sessions})
+}
+
+// SuspendUser locks an account: every session is revoked and no credential
+// works until UnsuspendUser. The optional JSON body {"reason": "..."} is kept
+// with the account.
+func SuspendUser(c *gin.Context, db *gorm.DB) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			apierr.Respond(c, apierr.InvalidRequest, "invalid request payload")
+			return
+		}
+	}
+	if len(req.Reason) > MAX_SUSPEND_REASON {
+		apierr.Respond(c, apierr.InvalidRequest, "reason must be 500 characters or fewer")
+		return
+	}
+	u, ok := targetUser(c, db)
+	if !ok || !notSelf(c, u) {
+		return
+	}
+	audit.Detail(c, "user: %d %s, reason: %s", u.ID, u.Email, req.Reason)
+
+	if err := db.Model(u).Updates(map[string]any{"suspended_at": time.Now(), "suspend_reason": req.Reason}).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Suspend failed", "target_user_id", u.ID, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to suspend user")
+		return
+	}
+	jwt.ForgetUser(u.ID)
+	revoked, err := jwt.RevokeAllSessions(c.Request.Context(), db, u.ID, "")
+	if err != nil {
+		// Suspension already rejects the sessions' tokens.
+		slog.ErrorContext(c.Request.Context(), "Session revoke after suspend failed", "target_user_id", u.ID, "error", err)
+	}
+
+	slog.InfoContext(c.Request.Context(), "User suspended", "target_user_id", u.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "user suspended", "sessions_revoked": revoked})
+}
+
+// UnsuspendUser lets a suspended account log in again.
+func UnsuspendUser(c *gin.Context, db *gorm.DB) {
+	u, ok := targetUser(c, db)
+	if !ok {
+		return
+	}
+	if err := db.Model(u).Updates(map[string]any{"suspended_at": nil, "suspend_reason": ""}).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Unsuspend failed", "target_user_id", u.ID, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to unsuspend user")
+		return
+	}
+	jwt.ForgetUser(u.ID)
+
+	slog.InfoContext(c.Request.Context(), "User unsuspended", "target_user_id", u.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "user unsuspended"})
+}
+
+// ForcePasswordReset signs the user out everywhere and refuses their current
+// password until they reset it with a recovery code, for a password that may
+// have leaked. App passwords, access tokens and keys keep working; revoke
+// those separately if they may be affected too.
+func ForcePasswordReset(c *gin.Context, db *gorm.DB) {
+	u, ok := targetUser(c, db)
+	if !ok || !notSelf(c, u) {
+		return
+	}
+	if err := db.Model(u).Update("password_reset_required", true).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Forcing password reset failed", "target_user_id", u.ID, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to require a password reset")
+		return
+	}
+	jwt.ForgetUser(u.ID)
+	revoked, err := jwt.RevokeAllSessions(c.Request.Context(), db, u.ID, "")
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Session revoke after forced password reset failed", "target_user_id", u.ID, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to revoke sessions")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Password reset required", "target_user_id", u.ID)
+	c.JSON(http.StatusOK, gin.H{"message": "password reset required", "sessions_revoked": revoked})
+}
+
+// RevokeUserSessions signs the user out of every device.
+func RevokeUserSessions(c *gin.Context, db *gorm.DB) {
+	u, ok := targetUser(c, db)
+	if !ok {
+		return
+	}
+	revoked, err := jwt.RevokeAllSessions(c.Request.Context(), db, u.ID, "")
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Session revoke failed", "target_user_id", u.ID, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to revoke sessions")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Revoked sessions", "target_user_id", u.ID, "count", revoked)
+	c.JSON(http.StatusOK, gin.H{"message": "sessions revoked", "sessions_revoked": revoked})
+}
+
+// SetQuota sets how many bytes the user may store across all boxes, from the
+// JSON body {"quota_bytes": n}; 0 removes the limit. A quota below current
+// usage is allowed: it blocks new uploads until files are deleted.
+func SetQuota(c *gin.Context, db *gorm.DB) {
+	var req struct {
+		QuotaBytes *int64 `json:"quota_bytes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.QuotaBytes == nil || *req.QuotaBytes < 0 {
+		apierr.Respond(c, apierr.InvalidRequest, "quota_bytes must be 0 (no limit) or a positive number of bytes")
+		return
+	}
+	u, ok := targetUser(c, db)
+	if !ok {
+		return
+	}
+	audit.Detail(c, "user: %d %s, quota_bytes: %d", u.ID, u.Email, *req.QuotaBytes)
+
+	if err := db.Model(u).Update("quota_bytes", *req.QuotaBytes).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Setting quota failed", "target_user_id", u.ID, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to set quota")
+		return
+	}
+	jwt.ForgetUser(u.ID)
+	used, err := storage.Usage(db, u.ID)
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to load usage")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Quota set", "target_user_id", u.ID, "quota_bytes", *req.QuotaBytes)
+	c.JSON(http.StatusOK, gin.H{"message": "quota updated", "quota_bytes": *req.QuotaBytes, "used_bytes": used})
+}
+
+// BoxTree lists every folder and file in one of the user's boxes, by path.
+// It only reads metadata; file contents stay with their owner.
+func BoxTree(c *gin.Context, db *gorm.DB, config s3db.Config) {
+	u, ok := targetUser(c, db)
+	if !ok {
+		return
+	}
+	boxName := c.Param("box")
+	audit.Target(c, boxName, "")
+
+	store := storage.New(db, config)
+	box, err := store.Box(u.ID, boxName)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			apierr.Respond(c, apierr.BoxNotFound, "box not found")
+			return
+		}
+		apierr.Respond(c, apierr.Internal, "failed to load box")
+		return
+	}
+	entries, err := store.Walk(box)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Admin box tree failed", "target_user_id", u.ID, "box", boxName, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to list box")
+		return
+	}
+
+	tree := make([]TreeEntry, len(entries))
+	for i, e := range entries {
+		tree[i] = TreeEntry{Path: e.Path, IsDir: e.IsDir, Size: e.Size, Modified: e.ModTime}
+	}
+	c.JSON(http.StatusOK, gin.H{"box": box.Name, "size": box.Size, "entries": tree})
+}
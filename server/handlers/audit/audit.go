@@ -0,0 +1,140 @@
+// Package audit serves the audit log that middleware/audit records, so users
+// can see what happened to their account and export it to a SIEM.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"gorm.io/gorm"
+)
+
+// DEFAULT_PAGE_SIZE and MAX_PAGE_SIZE bound a page of List. EXPORT_BATCH_SIZE
+// is how many events a JSONL export reads from the database at a time.
+const (
+	DEFAULT_PAGE_SIZE = 50
+	MAX_PAGE_SIZE     = 500
+	EXPORT_BATCH_SIZE = 1000
+)
+
+// likeEscaper escapes the LIKE wildcards in a path prefix.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// List returns the authenticated user's audit events, newest first. Query
+// parameters narrow it down:
+//
+//	action      an action (file.delete), or a family ending in "." (file.)
+//	box         box name
+//	path        a file or folder path, including everything under it
+//	result      success, failure or denied
+//	protocol    api, webdav, s3 or sftp
+//	request_id  the X-Request-ID of one request
+//	since/until RFC 3339 times
+//	limit       page size (default 50, at most 500)
+//	cursor      next_cursor from the previous page
+//
+// With format=jsonl every matching event is streamed as JSON Lines, oldest
+// first, for SIEM ingestion; limit and cursor don't apply.
+func List(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	q, err := filter(c, db.Model(&models.AuditEvent{}).Where("user_id = ?", user.ID))
+	if err != nil {
+		apierr.Respond(c, apierr.InvalidRequest, err.Error())
+		return
+	}
+
+	if c.Query("format") == "jsonl" {
+		export(c, q, user.ID)
+		return
+	}
+
+	limit := DEFAULT_PAGE_SIZE
+	if s := c.Query("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > MAX_PAGE_SIZE {
+			apierr.Respond(c, apierr.InvalidRequest, "limit must be between 1 and 500")
+			return
+		}
+	}
+	if s := c.Query("cursor"); s != "" {
+		before, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			apierr.Respond(c, apierr.InvalidRequest, "invalid cursor")
+			return
+		}
+		q = q.Where("id < ?", before)
+	}
+
+	events := []models.AuditEvent{}
+	if err := q.Order("id DESC").Limit(limit + 1).Find(&events).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Audit log query failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to load audit log")
+		return
+	}
+	var nextCursor string
+	if len(events) > limit {
+		events = events[:limit]
+		nextCursor = strconv.FormatUint(uint64(events[limit-1].ID), 10)
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "next_cursor": nextCursor})
+}
+
+// filter applies List's query parameters to q.
+func filter(c *gin.Context, q *gorm.DB) (*gorm.DB, error) {
+	if action := c.Query("action"); strings.HasSuffix(action, ".") {
+		q = q.Where("action LIKE ? ESCAPE '\\'", likeEscaper.Replace(action)+"%")
+	} else if action != "" {
+		q = q.Where("action = ?", action)
+	}
+	for _, col := range []string{"box", "result", "protocol", "request_id"} {
+		if v := c.Query(col); v != "" {
+			q = q.Where(col+" = ?", v)
+		}
+	}
+	if p := strings.Trim(c.Query("path"), "/"); p != "" {
+		q = q.Where("(path = ? OR path LIKE ? ESCAPE '\\')", p, likeEscaper.Replace(p)+"/%")
+	}
+	for param, op := range map[string]string{"since": ">=", "until": "<"} {
+		if s := c.Query(param); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be an RFC 3339 time, e.g. 2026-01-02T15:04:05Z", param)
+			}
+			q = q.Where("created_at "+op+" ?", t)
+		}
+	}
+	return q, nil
+}
+
+// export streams every event q matches as JSON Lines, oldest first.
+func export(c *gin.Context, q *gorm.DB, userID uint) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="nimbus-audit.jsonl"`)
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	var batch []models.AuditEvent
+	result := q.FindInBatches(&batch, EXPORT_BATCH_SIZE, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if err := enc.Encode(&batch[i]); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if result.Error != nil {
+		// The status line is already sent; a truncated file is all that can
+		// signal the failure.
+		slog.ErrorContext(c.Request.Context(), "Audit log export failed", "error", result.Error)
+	}
+}
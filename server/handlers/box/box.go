@@ -1,5 +1,5 @@
 // Package box contains HTTP handlers for box (top-level storage container)
-// operations: create, delete, list, and verify.
+// operations: create, delete, rename, list, and verify.
 package box
 
 import (
@@ -12,7 +12,9 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
 	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/audit"
 	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/models"
 	"github.com/nimbus/api/utils"
@@ -25,33 +27,27 @@ const (
 )
 
 // CreateBox creates a new box for the authenticated user. It:
-//  1. Authenticates the JWT
-//  2. Validates and sanitizes the box name (strips path traversal, replaces spaces)
-//  3. Checks for duplicate box names under the same user
-//  4. Creates a zero-byte "folder" object in S3 to represent the box prefix
-//  5. Saves the box record to the database
+//  1. Validates and sanitizes the box name (strips path traversal, replaces spaces)
+//  2. Checks for duplicate box names under the same user
+//  3. Saves the box record to the database, which gives it its S3 prefix
+//  4. Creates a zero-byte "folder" object in S3 to represent the prefix
 func CreateBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
-	user, err := jwt.AuthenticateUser(c, db)
+	user := jwt.CurrentUser(c)
 	var existing models.Box
 
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
 	if h.Bucket == "" || h.Client == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "S3 client or bucket not configured"})
+		apierr.Respond(c, apierr.Internal, "S3 client or bucket not configured")
 		return
 	}
 
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "box name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box name is required")
 		return
 	}
 
 	if len(boxName) < MIN_BOX_NAME || len(boxName) > MAX_BOX_NAME {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("box name must be between %d and %d characters", MIN_BOX_NAME, MAX_BOX_NAME)})
+		apierr.RespondDetails(c, apierr.InvalidRequest, fmt.Sprintf("box name must be between %d and %d characters", MIN_BOX_NAME, MAX_BOX_NAME), gin.H{"min": MIN_BOX_NAME, "max": MAX_BOX_NAME})
 		return
 	}
 
@@ -61,39 +57,39 @@ func CreateBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	sanitizedName = strings.ReplaceAll(sanitizedName, " ", "_")
 
 	if err := db.Where("name = ? AND user_id = ?", sanitizedName, user.ID).First(&existing).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "a box with that name already exists"})
+		apierr.Respond(c, apierr.NameConflict, "a box with that name already exists")
 		return
 	}
 
 	boxID, err := utils.GenerateSecureID()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate box ID"})
+		apierr.Respond(c, apierr.Internal, "failed to generate box ID")
 		return
 	}
 
-	// S3 key for the box "folder" — trailing slash marks it as a directory prefix.
-	key := fmt.Sprintf("users/nim-user-%d/boxes/%s/", user.ID, sanitizedName)
+	box := models.Box{
+		Name:   sanitizedName,
+		UserID: user.ID,
+		BoxID:  boxID,
+	}
+	if err := db.Create(&box).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to save box to database")
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
 
 	// Write a zero-byte object so the box prefix is visible in the S3 console.
+	// The trailing slash of the prefix marks it as a directory.
 	_, err = h.Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: &h.Bucket,
-		Key:    &key,
+		Key:    &box.S3Prefix,
 		Body:   strings.NewReader(""),
 	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create box in storage"})
-		return
-	}
-
-	if err := db.Create(&models.Box{
-		Name:   sanitizedName,
-		UserID: user.ID,
-		BoxID:  boxID,
-	}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save box to database"})
+		db.Unscoped().Delete(&box)
+		apierr.Respond(c, apierr.Internal, "failed to create box in storage")
 		return
 	}
 
@@ -104,17 +100,12 @@ func CreateBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
 // database. It lists every object under the box's S3 prefix and deletes them
 // before removing the database record, so no orphaned bytes are left in S3.
 func DeleteBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
-	user, err := jwt.AuthenticateUser(c, db)
+	user := jwt.CurrentUser(c)
 	var box models.Box
 
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "box name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box name is required")
 		return
 	}
 
@@ -122,11 +113,11 @@ func DeleteBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	sanitizedName = strings.ReplaceAll(sanitizedName, " ", "_")
 
 	if err := db.Where("name = ? AND user_id = ?", sanitizedName, user.ID).First(&box).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "box not found"})
+		apierr.Respond(c, apierr.BoxNotFound, "box not found")
 		return
 	}
 
-	prefix := fmt.Sprintf("users/nim-user-%d/boxes/%s/", user.ID, sanitizedName)
+	prefix := box.S3Prefix
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 120*time.Second)
 	defer cancel()
@@ -140,7 +131,7 @@ func DeleteBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
 			ContinuationToken: continuationToken,
 		})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list box contents in storage"})
+			apierr.Respond(c, apierr.Internal, "failed to list box contents in storage")
 			return
 		}
 
@@ -150,7 +141,7 @@ func DeleteBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
 				Bucket: &h.Bucket,
 				Key:    &key,
 			}); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete box contents from storage"})
+				apierr.Respond(c, apierr.Internal, "failed to delete box contents from storage")
 				return
 			}
 		}
@@ -162,25 +153,77 @@ func DeleteBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	}
 
 	if err := db.Delete(&box).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete box from database"})
+		apierr.Respond(c, apierr.Internal, "failed to delete box from database")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "box deleted successfully"})
 }
 
-// ListBoxes returns all boxes owned by the authenticated user.
-func ListBoxes(h s3db.Config, c *gin.Context, db *gorm.DB) {
-	user, err := jwt.AuthenticateUser(c, db)
-	var boxes []models.Box
+// RenameBox gives one of the authenticated user's boxes a new name. Objects
+// are stored under the box's S3 prefix rather than its name, so nothing moves
+// in S3; the box keeps its contents, access tokens limited to it and its
+// WebDAV, SFTP and S3 gateway data, which are reached under the new name.
+func RenameBox(h s3db.Config, c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+	var box models.Box
 
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	boxName := c.Query("box_name")
+	if boxName == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "box name is required")
+		return
+	}
+	newName := c.Query("new_name")
+	if newName == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "new_name is required")
 		return
 	}
+	audit.Detail(c, "new name: %s", newName)
 
-	if err := db.Where("user_id = ?", user.ID).Find(&boxes).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list boxes"})
+	if len(newName) < MIN_BOX_NAME || len(newName) > MAX_BOX_NAME {
+		apierr.RespondDetails(c, apierr.InvalidRequest, fmt.Sprintf("box name must be between %d and %d characters", MIN_BOX_NAME, MAX_BOX_NAME), gin.H{"min": MIN_BOX_NAME, "max": MAX_BOX_NAME})
+		return
+	}
+
+	sanitizedName := filepath.Base(boxName)
+	sanitizedName = strings.ReplaceAll(sanitizedName, " ", "_")
+	sanitizedNew := filepath.Base(newName)
+	sanitizedNew = strings.ReplaceAll(sanitizedNew, " ", "_")
+
+	if err := db.Where("name = ? AND user_id = ?", sanitizedName, user.ID).First(&box).Error; err != nil {
+		apierr.Respond(c, apierr.BoxNotFound, "box not found")
+		return
+	}
+	if sanitizedNew == box.Name {
+		apierr.Respond(c, apierr.InvalidRequest, "the box already has that name")
+		return
+	}
+
+	var existing models.Box
+	if err := db.Where("name = ? AND user_id = ?", sanitizedNew, user.ID).First(&existing).Error; err == nil {
+		apierr.Respond(c, apierr.NameConflict, "a box with that name already exists")
+		return
+	}
+	if err := db.Model(&box).Update("name", sanitizedNew).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to rename box")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "box renamed successfully", "box": sanitizedNew})
+}
+
+// ListBoxes returns all boxes owned by the authenticated user, or only the one
+// box an access token is restricted to.
+func ListBoxes(h s3db.Config, c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+	var boxes []models.Box
+
+	query := db.Where("user_id = ?", user.ID)
+	if t := jwt.AccessToken(c); t != nil && t.BoxID != nil {
+		query = query.Where("id = ?", *t.BoxID)
+	}
+	if err := query.Find(&boxes).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to list boxes")
 		return
 	}
 
@@ -190,17 +233,12 @@ func ListBoxes(h s3db.Config, c *gin.Context, db *gorm.DB) {
 // VerifyBoxExist checks whether a named box exists for the authenticated user.
 // Used by the CLI's "cb" command to validate a box name before setting it as active.
 func VerifyBoxExist(h s3db.Config, c *gin.Context, db *gorm.DB) {
-	user, err := jwt.AuthenticateUser(c, db)
+	user := jwt.CurrentUser(c)
 	var box models.Box
 
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "box name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box name is required")
 		return
 	}
 
@@ -208,7 +246,7 @@ func VerifyBoxExist(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	sanitizedName = strings.ReplaceAll(sanitizedName, " ", "_")
 
 	if err := db.Where("name = ? AND user_id = ?", sanitizedName, user.ID).First(&box).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "box not found"})
+		apierr.Respond(c, apierr.BoxNotFound, "box not found")
 		return
 	}
 
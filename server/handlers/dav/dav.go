@@ -0,0 +1,161 @@
+// Package dav serves boxes over WebDAV so they can be mounted natively by OS
+// file managers (Finder, Explorer, GNOME Files), davfs2 and rclone. The mount
+// root /dav/ lists the user's boxes and /dav/<box>/<path> addresses folders
+// and files inside them.
+//
+// Protocol handling (PROPFIND, LOCK, COPY, If headers, multistatus XML) comes
+// from golang.org/x/net/webdav; this package only supplies a webdav.FileSystem
+// backed by the storage package, so every operation goes through the same
+// Box/Folder/File records and S3 objects as the REST API. Bodies are streamed
+// in both directions — GETs are ranged reads from S3 and PUTs go up in
+// bounded multipart chunks — so file size never turns into server memory.
+package dav
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/logging"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/tracing"
+	"golang.org/x/net/webdav"
+	"gorm.io/gorm"
+)
+
+// Prefix is the URL path the WebDAV tree is mounted under.
+const Prefix = "/dav"
+
+// Methods lists every HTTP method the WebDAV handler answers. Gin only routes
+// methods that are registered explicitly, so the extension methods have to be
+// spelled out.
+var Methods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+// transferTimeout replaces the server-wide 30s read / 300s write timeouts for
+// WebDAV requests, which carry whole files instead of small JSON bodies.
+const transferTimeout = time.Hour
+
+// Handler serves WebDAV requests. It holds the lock state, which has to outlive
+// individual requests, so create one with New and reuse it.
+type Handler struct {
+	db    *gorm.DB
+	store *storage.Store
+
+	mu    sync.Mutex
+	locks map[uint]webdav.LockSystem // per user, so equal paths in different accounts never collide
+}
+
+// New returns a Handler backed by db and the given S3 configuration.
+func New(config s3db.Config, db *gorm.DB) *Handler {
+	return &Handler{
+		db:    db,
+		store: storage.New(db, config),
+		locks: make(map[uint]webdav.LockSystem),
+	}
+}
+
+// Serve authenticates the request with AuthenticateBasic and hands it to the
+// WebDAV protocol handler. Failed authentication gets a Basic challenge so
+// file managers prompt for credentials.
+func (h *Handler) Serve(c *gin.Context) {
+	user, err := jwt.AuthenticateBasic(c.Request, h.db)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "WebDAV auth failed", "ip", c.ClientIP())
+		c.Header("WWW-Authenticate", `Basic realm="Nimbus", charset="UTF-8"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		// Clients send their first request without credentials to get the
+		// challenge; only attempts that carried some are worth recording.
+		if c.GetHeader("Authorization") != "" {
+			email, _, _ := c.Request.BasicAuth()
+			audit.Record(c, h.db, &models.AuditEvent{
+				Action: "auth.login", Protocol: audit.ProtocolWebDAV, Actor: email, Result: audit.ResultDenied,
+			})
+		}
+		return
+	}
+	logging.AddAttrs(c, logging.UserID(user.ID))
+	tracing.SetUser(c.Request.Context(), user.ID)
+
+	req := c.Request
+	if req.Method == http.MethodPut {
+		if req.ContentLength > storage.MaxFileSize {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+		req = req.WithContext(context.WithValue(req.Context(), sizeHintKey{}, req.ContentLength))
+	}
+
+	// Not every ResponseWriter supports deadlines (httptest's doesn't); the
+	// server-wide timeouts simply stay in force then.
+	rc := http.NewResponseController(c.Writer)
+	deadline := time.Now().Add(transferTimeout)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+
+	dav := &webdav.Handler{
+		Prefix:     Prefix,
+		FileSystem: newFileSystem(h.store.WithContext(req.Context()), user),
+		LockSystem: h.lockSystem(user.ID),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !os.IsNotExist(err) {
+				slog.ErrorContext(r.Context(), "WebDAV request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+			}
+		},
+	}
+	dav.ServeHTTP(c.Writer, req)
+
+	if action := auditActions[c.Request.Method]; action != "" {
+		e := &models.AuditEvent{UserID: user.ID, Actor: user.Email, Action: action, Protocol: audit.ProtocolWebDAV}
+		e.Box, e.Path = splitDavPath(c.Request.URL.Path)
+		if dst := c.GetHeader("Destination"); dst != "" {
+			e.Detail = "destination: " + dst
+		}
+		audit.Record(c, h.db, e)
+	}
+}
+
+// auditActions names the audit event for each WebDAV method that reads or
+// changes data. Metadata requests (PROPFIND, OPTIONS, HEAD) and locking are
+// not recorded. DELETE removes a file or a whole folder.
+var auditActions = map[string]string{
+	http.MethodGet:    "file.download",
+	http.MethodPut:    "file.upload",
+	http.MethodDelete: "file.delete",
+	"MKCOL":           "folder.create",
+	"COPY":            "file.copy",
+	"MOVE":            "file.move",
+}
+
+// splitDavPath separates a request path under Prefix into the box name and
+// the path inside the box.
+func splitDavPath(p string) (box, rest string) {
+	p = strings.Trim(strings.TrimPrefix(p, Prefix), "/")
+	box, rest, _ = strings.Cut(p, "/")
+	return box, rest
+}
+
+// lockSystem returns the in-memory lock table for userID. Locks are advisory
+// and per instance, which is what desktop clients need to coordinate their own
+// writes; they are not a cross-instance mutex.
+func (h *Handler) lockSystem(userID uint) webdav.LockSystem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ls, ok := h.locks[userID]
+	if !ok {
+		ls = webdav.NewMemLS()
+		h.locks[userID] = ls
+	}
+	return ls
+}
@@ -0,0 +1,506 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+	"golang.org/x/net/webdav"
+)
+
+// sizeHintKey carries a PUT's Content-Length from Serve to OpenFile, which
+// webdav.FileSystem otherwise has no way to see.
+type sizeHintKey struct{}
+
+var errNotSupported = errors.New("operation not supported on this resource")
+
+// fileSystem is a webdav.FileSystem over one user's boxes. It is created per
+// request: the box and entry caches are only valid for the request's lifetime
+// and are dropped whenever the request changes the tree.
+type fileSystem struct {
+	store *storage.Store
+	user  *models.User
+
+	boxes   map[string]*models.Box
+	entries map[string]node // filled by Readdir so PROPFIND doesn't re-resolve every child
+}
+
+// node is a resolved path: the box it lives in (nil for the mount root) and
+// its entry within that box.
+type node struct {
+	box   *models.Box
+	entry *storage.Entry
+}
+
+func newFileSystem(store *storage.Store, user *models.User) *fileSystem {
+	return &fileSystem{
+		store:   store,
+		user:    user,
+		boxes:   make(map[string]*models.Box),
+		entries: make(map[string]node),
+	}
+}
+
+// split separates a WebDAV path into its box name and the path inside the box.
+func split(name string) (box, rest string, err error) {
+	cleaned, err := storage.CleanPath(name)
+	if err != nil {
+		return "", "", os.ErrPermission
+	}
+	box, rest, _ = strings.Cut(cleaned, "/")
+	return box, rest, nil
+}
+
+// box resolves a box name owned by the current user.
+func (fsys *fileSystem) box(name string) (*models.Box, error) {
+	if b, ok := fsys.boxes[name]; ok {
+		return b, nil
+	}
+	b, err := fsys.store.Box(fsys.user.ID, name)
+	if err != nil {
+		return nil, toOSError(err)
+	}
+	fsys.boxes[name] = b
+	return b, nil
+}
+
+// resolve turns a WebDAV path into a node. The mount root resolves to a
+// directory entry with a nil box.
+func (fsys *fileSystem) resolve(name string) (node, error) {
+	boxName, rest, err := split(name)
+	if err != nil {
+		return node{}, err
+	}
+	if boxName == "" {
+		return node{entry: &storage.Entry{IsDir: true}}, nil
+	}
+	key := path.Join(boxName, rest)
+	if n, ok := fsys.entries[key]; ok {
+		return n, nil
+	}
+
+	b, err := fsys.box(boxName)
+	if err != nil {
+		return node{}, err
+	}
+	e, err := fsys.store.Stat(b, rest)
+	if err != nil {
+		return node{}, toOSError(err)
+	}
+	n := node{box: b, entry: e}
+	fsys.entries[key] = n
+	return n, nil
+}
+
+// changed drops cached lookups after a write.
+func (fsys *fileSystem) changed() {
+	clear(fsys.entries)
+}
+
+// Mkdir implements webdav.FileSystem. Boxes themselves can't be created over
+// WebDAV; use "nim mkbox".
+func (fsys *fileSystem) Mkdir(ctx context.Context, name string, _ os.FileMode) error {
+	boxName, rest, err := split(name)
+	if err != nil {
+		return err
+	}
+	if rest == "" {
+		if boxName != "" {
+			if _, err := fsys.box(boxName); err == nil {
+				return os.ErrExist
+			}
+		}
+		return os.ErrPermission
+	}
+	b, err := fsys.box(boxName)
+	if err != nil {
+		return err
+	}
+	defer fsys.changed()
+	_, err = fsys.store.Mkdir(ctx, b, rest)
+	return toOSError(err)
+}
+
+// OpenFile implements webdav.FileSystem. webdav opens with O_CREATE|O_TRUNC
+// for uploads and plain O_RDONLY (or O_RDWR for property changes) otherwise.
+func (fsys *fileSystem) OpenFile(ctx context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	if flag&(os.O_CREATE|os.O_TRUNC) == 0 {
+		n, err := fsys.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+		if n.entry.IsDir {
+			return &dirFile{fsys: fsys, node: n, name: name}, nil
+		}
+		return &readFile{ctx: ctx, store: fsys.store, node: n}, nil
+	}
+
+	boxName, rest, err := split(name)
+	if err != nil {
+		return nil, err
+	}
+	if rest == "" {
+		return nil, os.ErrPermission
+	}
+	b, err := fsys.box(boxName)
+	if err != nil {
+		return nil, err
+	}
+	// Fail before any body is read when the upload has nowhere to go: a
+	// missing parent is a 409 Conflict, an existing folder a 405.
+	if parent, err := fsys.store.Stat(b, path.Dir(rest)); err != nil {
+		return nil, toOSError(err)
+	} else if !parent.IsDir {
+		return nil, os.ErrNotExist
+	}
+	if existing, err := fsys.store.Stat(b, rest); err == nil && existing.IsDir {
+		return nil, os.ErrPermission
+	}
+
+	sizeHint := int64(-1)
+	if v, ok := ctx.Value(sizeHintKey{}).(int64); ok {
+		sizeHint = v
+	}
+	return &writeFile{ctx: ctx, fsys: fsys, box: b, path: rest, sizeHint: sizeHint}, nil
+}
+
+// RemoveAll implements webdav.FileSystem.
+func (fsys *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	boxName, rest, err := split(name)
+	if err != nil {
+		return err
+	}
+	if rest == "" {
+		return os.ErrPermission
+	}
+	b, err := fsys.box(boxName)
+	if err != nil {
+		return err
+	}
+	defer fsys.changed()
+	return toOSError(fsys.store.Remove(ctx, b, rest))
+}
+
+// Rename implements webdav.FileSystem. Moves between two of the user's boxes
+// are allowed; boxes themselves can't be renamed here.
+func (fsys *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldBox, oldRest, err := split(oldName)
+	if err != nil {
+		return err
+	}
+	newBox, newRest, err := split(newName)
+	if err != nil {
+		return err
+	}
+	if oldRest == "" || newRest == "" {
+		return os.ErrPermission
+	}
+	src, err := fsys.box(oldBox)
+	if err != nil {
+		return err
+	}
+	dst, err := fsys.box(newBox)
+	if err != nil {
+		return err
+	}
+	defer fsys.changed()
+	return toOSError(fsys.store.Move(ctx, src, oldRest, dst, newRest))
+}
+
+// Stat implements webdav.FileSystem.
+func (fsys *fileSystem) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	n, err := fsys.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n.entry}, nil
+}
+
+// toOSError maps storage errors onto the os errors webdav turns into status
+// codes (404/409 for missing, 405/412 for existing, 403 otherwise).
+func toOSError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrNotDir):
+		return os.ErrNotExist
+	case errors.Is(err, storage.ErrExist):
+		return os.ErrExist
+	case errors.Is(err, storage.ErrInvalidPath), errors.Is(err, storage.ErrIsDir),
+		errors.Is(err, storage.ErrCrossUserMove), errors.Is(err, storage.ErrTooLarge),
+		errors.Is(err, storage.ErrQuotaExceeded):
+		return os.ErrPermission
+	}
+	return err
+}
+
+// ── Files ────────────────────────────────────────────────────────────────────
+
+// dirFile is an open folder, box root, or the mount root (node.box == nil).
+type dirFile struct {
+	fsys *fileSystem
+	node node
+	name string
+
+	children []os.FileInfo
+	loaded   bool
+}
+
+func (d *dirFile) Close() error                   { return nil }
+func (d *dirFile) Read([]byte) (int, error)       { return 0, errNotSupported }
+func (d *dirFile) Write([]byte) (int, error)      { return 0, errNotSupported }
+func (d *dirFile) Seek(int64, int) (int64, error) { return 0, errNotSupported }
+func (d *dirFile) Stat() (os.FileInfo, error)     { return fileInfo{d.node.entry}, nil }
+
+// cache records a child's entry so the Stat/OpenFile calls PROPFIND makes for
+// each listed child are answered without another query.
+func (d *dirFile) cache(name string, b *models.Box, e *storage.Entry) {
+	boxName, rest, _ := split(d.name)
+	key := path.Join(boxName, rest, name)
+	d.fsys.entries[key] = node{box: b, entry: e}
+}
+
+// Readdir follows os.File semantics: count <= 0 returns everything, otherwise
+// at most count entries per call and io.EOF once exhausted.
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+	}
+	if count <= 0 {
+		out := d.children
+		d.children = nil
+		return out, nil
+	}
+	if len(d.children) == 0 {
+		return nil, io.EOF
+	}
+	count = min(count, len(d.children))
+	out := d.children[:count]
+	d.children = d.children[count:]
+	return out, nil
+}
+
+func (d *dirFile) load() error {
+	d.loaded = true
+	if d.node.box == nil {
+		boxes, err := d.fsys.store.Boxes(d.fsys.user.ID)
+		if err != nil {
+			return err
+		}
+		for i := range boxes {
+			b := &boxes[i]
+			e := &storage.Entry{Name: b.Name, IsDir: true, ModTime: b.UpdatedAt}
+			d.fsys.boxes[b.Name] = b
+			d.cache(b.Name, b, e)
+			d.children = append(d.children, fileInfo{e})
+		}
+		return nil
+	}
+
+	entries, err := d.fsys.store.ReadDir(d.node.box, d.node.entry.Path)
+	if err != nil {
+		return toOSError(err)
+	}
+	for i := range entries {
+		e := &entries[i]
+		d.cache(e.Name, d.node.box, e)
+		d.children = append(d.children, fileInfo{e})
+	}
+	return nil
+}
+
+// readFile is an open file being read. The S3 body is opened lazily at the
+// current offset, so http.ServeContent's seeks for Range requests turn into
+// ranged GETs instead of reading and discarding bytes.
+type readFile struct {
+	ctx   context.Context
+	store *storage.Store
+	node  node
+
+	off  int64
+	body io.ReadCloser
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.body == nil {
+		body, err := f.store.Open(f.ctx, f.node.entry.File, f.off, -1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	n, err := f.body.Read(p)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.off + offset
+	case io.SeekEnd:
+		abs = f.node.entry.Size + offset
+	default:
+		return 0, os.ErrInvalid
+	}
+	if abs < 0 {
+		return 0, os.ErrInvalid
+	}
+	if abs != f.off && f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+	f.off = abs
+	return abs, nil
+}
+
+func (f *readFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+func (f *readFile) Readdir(int) ([]os.FileInfo, error) { return nil, errNotSupported }
+func (f *readFile) Write([]byte) (int, error)          { return 0, errNotSupported }
+func (f *readFile) Stat() (os.FileInfo, error)         { return fileInfo{f.node.entry}, nil }
+
+// writeFile is a file being uploaded. webdav copies the request body in with
+// io.Copy, which uses ReadFrom, so the body is handed to storage.Put directly.
+// Plain Writes (from any other caller) are fed through a pipe instead. Close
+// waits for the upload and reports its result; a file that was opened and
+// closed without data becomes an empty file, as LOCK on a new path expects.
+type writeFile struct {
+	ctx      context.Context
+	fsys     *fileSystem
+	box      *models.Box
+	path     string
+	sizeHint int64
+
+	written int64
+	entry   *storage.Entry
+	err     error
+	done    bool
+
+	pw     *io.PipeWriter
+	result chan putResult
+}
+
+type putResult struct {
+	entry *storage.Entry
+	err   error
+}
+
+// ReadFrom implements io.ReaderFrom.
+func (f *writeFile) ReadFrom(r io.Reader) (int64, error) {
+	if f.done || f.pw != nil {
+		return io.Copy(struct{ io.Writer }{f}, r)
+	}
+	cr := &countingReader{r: r}
+	f.entry, f.err = f.fsys.store.Put(f.ctx, f.box, f.path, cr, f.sizeHint)
+	f.done = true
+	f.written = cr.n
+	return cr.n, f.err
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	if f.done {
+		return 0, errNotSupported
+	}
+	if f.pw == nil {
+		pr, pw := io.Pipe()
+		f.pw = pw
+		f.result = make(chan putResult, 1)
+		go func() {
+			e, err := f.fsys.store.Put(f.ctx, f.box, f.path, pr, -1)
+			_ = pr.CloseWithError(err)
+			f.result <- putResult{e, err}
+		}()
+	}
+	n, err := f.pw.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+func (f *writeFile) Close() error {
+	defer f.fsys.changed()
+	switch {
+	case f.pw != nil:
+		_ = f.pw.Close()
+		res := <-f.result
+		f.entry, f.err = res.entry, res.err
+		f.pw = nil
+		f.done = true
+	case !f.done:
+		f.entry, f.err = f.fsys.store.Put(f.ctx, f.box, f.path, strings.NewReader(""), 0)
+		f.done = true
+	}
+	return toOSError(f.err)
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	if f.entry != nil {
+		return fileInfo{f.entry}, nil
+	}
+	return fileInfo{&storage.Entry{Name: path.Base(f.path), Path: f.path, Size: f.written, ModTime: time.Now()}}, nil
+}
+
+func (f *writeFile) Read([]byte) (int, error)           { return 0, errNotSupported }
+func (f *writeFile) Seek(int64, int) (int64, error)     { return 0, errNotSupported }
+func (f *writeFile) Readdir(int) ([]os.FileInfo, error) { return nil, errNotSupported }
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ── FileInfo ─────────────────────────────────────────────────────────────────
+
+// fileInfo adapts a storage.Entry to os.FileInfo. It also implements
+// webdav.ContentTyper and webdav.ETager so PROPFIND never has to open (and
+// download from S3) a file just to sniff its type or hash it.
+type fileInfo struct{ e *storage.Entry }
+
+func (fi fileInfo) Name() string       { return fi.e.Name }
+func (fi fileInfo) Size() int64        { return fi.e.Size }
+func (fi fileInfo) ModTime() time.Time { return fi.e.ModTime }
+func (fi fileInfo) IsDir() bool        { return fi.e.IsDir }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.e.IsDir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// ContentType implements webdav.ContentTyper.
+func (fi fileInfo) ContentType(context.Context) (string, error) {
+	return storage.ContentType(fi.e.Name), nil
+}
+
+// ETag implements webdav.ETager, using the same tag as the S3 gateway.
+func (fi fileInfo) ETag(context.Context) (string, error) {
+	if fi.e.File != nil {
+		return storage.ETag(fi.e.File), nil
+	}
+	return fmt.Sprintf(`"%x-%x"`, fi.e.ModTime.UnixNano(), fi.e.Size), nil
+}
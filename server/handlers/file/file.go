@@ -2,16 +2,22 @@ package file
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"path"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
 	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/metrics"
+	"github.com/nimbus/api/middleware/audit"
 	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
 	"github.com/nimbus/api/utils/helpers"
 	"gorm.io/gorm"
 )
@@ -24,33 +30,30 @@ const (
 func PresignDownload(d s3db.Config, c *gin.Context, db *gorm.DB) {
 	startTime := time.Now()
 
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		log.Printf("[PRESIGN-DOWNLOAD] Auth failed from IP: %s", c.ClientIP())
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	if d.Client == nil || d.Bucket == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "S3 not configured"})
+		apierr.Respond(c, apierr.Internal, "S3 not configured")
 		return
 	}
 
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "box_name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box_name is required")
 		return
 	}
 
 	key := c.Query("key")
 	if key == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "key is required")
 		return
 	}
+	audit.Target(c, boxName, key)
 
 	box, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		log.Printf("[PRESIGN-DOWNLOAD] Access denied - user_id: %d, box: %s", user.ID, boxName)
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		slog.WarnContext(c.Request.Context(), "Download access denied", "box", boxName)
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
@@ -61,8 +64,8 @@ func PresignDownload(d s3db.Config, c *gin.Context, db *gorm.DB) {
 	err = db.Where("s3_key = ? AND user_id = ?", key, user.ID).First(&fileModel).Error
 	if err != nil {
 		if err := db.Where("name = ? AND box_id = ? AND user_id = ?", key, box.ID, user.ID).First(&fileModel).Error; err != nil {
-			log.Printf("[PRESIGN-DOWNLOAD] File not found - user_id: %d, key: %s", user.ID, key)
-			c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+			slog.InfoContext(c.Request.Context(), "Download of missing file", "key", key)
+			apierr.Respond(c, apierr.FileNotFound, "file not found")
 			return
 		}
 	}
@@ -73,26 +76,24 @@ func PresignDownload(d s3db.Config, c *gin.Context, db *gorm.DB) {
 
 	url, err := s3db.PresignGetObject(ctx, d.Client, d.Bucket, s3Key, presignExpiry)
 	if err != nil {
-		log.Printf("[PRESIGN-DOWNLOAD] Presign failed - user_id: %d, key: %s, error: %v", user.ID, s3Key, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate download URL"})
+		slog.ErrorContext(c.Request.Context(), "Download presign failed", "key", s3Key, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to generate download URL")
 		return
 	}
 
-	log.Printf("[PRESIGN-DOWNLOAD] Success - user_id: %d, file: %s, duration: %v", user.ID, fileModel.Name, time.Since(startTime))
+	metrics.AddPresigned(metrics.Download, fileModel.Size)
+
+	slog.InfoContext(c.Request.Context(), "Download presigned", "file", fileModel.Name, "duration", time.Since(startTime))
 	c.JSON(http.StatusOK, gin.H{"download_url": url, "expires_in": presignExpiry.String()})
 }
 
 func PresignUpload(h s3db.Config, db *gorm.DB, c *gin.Context) {
 	startTime := time.Now()
 
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		log.Printf("[PRESIGN-UPLOAD] Auth failed from IP: %s", c.ClientIP())
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	if h.Client == nil || h.Bucket == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "S3 not configured"})
+		apierr.Respond(c, apierr.Internal, "S3 not configured")
 		return
 	}
 
@@ -105,9 +106,10 @@ func PresignUpload(h s3db.Config, db *gorm.DB, c *gin.Context) {
 	}
 
 	if filename == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "filename is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "filename is required")
 		return
 	}
+	audit.Target(c, boxName, path.Join(filePath, filename))
 
 	// The client declares the upload size up front. We validate it here and then
 	// bind it into the presigned URL's signature (below), so S3 enforces the exact
@@ -115,25 +117,35 @@ func PresignUpload(h s3db.Config, db *gorm.DB, c *gin.Context) {
 	var fileSize int64
 	fmt.Sscanf(c.Query("size"), "%d", &fileSize)
 	if fileSize <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "a positive file size is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "a positive file size is required")
 		return
 	}
 	if fileSize > maxUploadSize {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file size must be 50MB or less"})
+		apierr.RespondDetails(c, apierr.FileTooLarge, "file size must be 50MB or less", gin.H{"max_bytes": maxUploadSize})
 		return
 	}
 
 	box, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		log.Printf("[PRESIGN-UPLOAD] Access denied - user_id: %d, box: %s", user.ID, boxName)
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		slog.WarnContext(c.Request.Context(), "Upload access denied", "box", boxName)
+		apierr.RespondError(c, err, "failed to load box")
+		return
+	}
+	if err := storage.CheckQuota(db, user.ID, fileSize); err != nil {
+		var quotaErr *storage.QuotaError
+		if errors.As(err, &quotaErr) {
+			apierr.RespondDetails(c, apierr.QuotaExceeded, err.Error(), gin.H{"quota_bytes": quotaErr.QuotaBytes, "used_bytes": quotaErr.UsedBytes})
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "Upload quota check failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to check storage quota")
 		return
 	}
 
-	s3Key, err := helpers.GenerateS3Key(filePath, filename, boxName, user)
+	s3Key, err := helpers.GenerateS3Key(filePath, filename, box)
 	if err != nil {
-		log.Printf("[PRESIGN-UPLOAD] Key generation failed - user_id: %d, error: %v", user.ID, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		slog.ErrorContext(c.Request.Context(), "Upload key generation failed", "error", err)
+		apierr.RespondError(c, err, "failed to generate upload key")
 		return
 	}
 
@@ -145,8 +157,8 @@ func PresignUpload(h s3db.Config, db *gorm.DB, c *gin.Context) {
 		S3Key:  s3Key,
 	}
 	if err := db.Create(fileModel).Error; err != nil {
-		log.Printf("[PRESIGN-UPLOAD] DB save failed - user_id: %d, file: %s, error: %v", user.ID, filename, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file metadata"})
+		slog.ErrorContext(c.Request.Context(), "Upload save failed", "file", filename, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to save file metadata")
 		return
 	}
 
@@ -158,12 +170,14 @@ func PresignUpload(h s3db.Config, db *gorm.DB, c *gin.Context) {
 	url, err := s3db.PresignPutObject(ctx, h.Client, h.Bucket, s3Key, contentType, fileSize, presignExpiry)
 	if err != nil {
 		db.Delete(fileModel)
-		log.Printf("[PRESIGN-UPLOAD] Presign failed - user_id: %d, key: %s, error: %v", user.ID, s3Key, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate upload URL"})
+		slog.ErrorContext(c.Request.Context(), "Upload presign failed", "key", s3Key, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to generate upload URL")
 		return
 	}
 
-	log.Printf("[PRESIGN-UPLOAD] Success - user_id: %d, file: %s, duration: %v", user.ID, filename, time.Since(startTime))
+	metrics.AddPresigned(metrics.Upload, fileSize)
+
+	slog.InfoContext(c.Request.Context(), "Upload presigned", "file", filename, "duration", time.Since(startTime))
 	c.JSON(http.StatusOK, gin.H{
 		"upload_url": url,
 		"s3_key":     s3Key,
@@ -175,20 +189,17 @@ func PresignUpload(h s3db.Config, db *gorm.DB, c *gin.Context) {
 func Delete(d s3db.Config, db *gorm.DB, c *gin.Context) {
 	startTime := time.Now()
 
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		log.Printf("[DELETE] Auth failed from IP: %s", c.ClientIP())
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	keyName := c.Param("name")
 	if keyName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "file name is required")
 		return
 	}
+	audit.Target(c, "", keyName)
 
 	if d.Client == nil || d.Bucket == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "S3 not configured"})
+		apierr.Respond(c, apierr.Internal, "S3 not configured")
 		return
 	}
 
@@ -197,8 +208,12 @@ func Delete(d s3db.Config, db *gorm.DB, c *gin.Context) {
 
 	var fileModel models.File
 	if err := db.Where("s3_key = ? AND user_id = ?", keyName, user.ID).First(&fileModel).Error; err != nil {
-		log.Printf("[DELETE] File not found - user_id: %d, key: %s", user.ID, keyName)
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found in database"})
+		slog.InfoContext(c.Request.Context(), "Delete of missing file", "key", keyName)
+		apierr.Respond(c, apierr.FileNotFound, "file not found in database")
+		return
+	}
+	if !jwt.CanAccessBox(c, fileModel.BoxID) {
+		apierr.Respond(c, apierr.Forbidden, "token is restricted to a different box")
 		return
 	}
 
@@ -206,14 +221,14 @@ func Delete(d s3db.Config, db *gorm.DB, c *gin.Context) {
 		Bucket: &d.Bucket,
 		Key:    &keyName,
 	}); err != nil {
-		log.Printf("[DELETE] S3 delete failed - user_id: %d, key: %s, error: %v", user.ID, keyName, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete file"})
+		slog.ErrorContext(c.Request.Context(), "S3 delete failed", "key", keyName, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to delete file")
 		return
 	}
 
 	if err := db.Delete(&fileModel).Error; err != nil {
-		log.Printf("[DELETE] DB delete failed - user_id: %d, key: %s, error: %v", user.ID, keyName, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete file record"})
+		slog.ErrorContext(c.Request.Context(), "File delete failed", "key", keyName, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to delete file record")
 		return
 	}
 
@@ -223,32 +238,33 @@ func Delete(d s3db.Config, db *gorm.DB, c *gin.Context) {
 			UpdateColumn("size", gorm.Expr("size - ?", fileModel.Size))
 	}
 
-	log.Printf("[DELETE] Success - user_id: %d, file: %s, duration: %v", user.ID, keyName, time.Since(startTime))
+	slog.InfoContext(c.Request.Context(), "File deleted", "key", keyName, "duration", time.Since(startTime))
 	c.JSON(http.StatusOK, gin.H{"message": "file deleted"})
 }
 
 func Confirm(h s3db.Config, db *gorm.DB, c *gin.Context) {
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		log.Printf("[CONFIRM] Auth failed from IP: %s", c.ClientIP())
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	fileID := c.Param("id")
 	if fileID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "file id is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "file id is required")
 		return
 	}
 
 	var fileModel models.File
 	if err := db.Where("id = ? AND user_id = ?", fileID, user.ID).First(&fileModel).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		apierr.Respond(c, apierr.FileNotFound, "file not found")
+		return
+	}
+	audit.Target(c, "", fileModel.S3Key)
+	if !jwt.CanAccessBox(c, fileModel.BoxID) {
+		apierr.Respond(c, apierr.Forbidden, "token is restricted to a different box")
 		return
 	}
 
 	if err := db.Model(&fileModel).Update("confirmed", true).Error; err != nil {
-		log.Printf("[CONFIRM] DB update failed - user_id: %d, file_id: %s, error: %v", user.ID, fileID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm upload"})
+		slog.ErrorContext(c.Request.Context(), "Upload confirm failed", "file_id", fileID, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to confirm upload")
 		return
 	}
 
@@ -256,26 +272,22 @@ func Confirm(h s3db.Config, db *gorm.DB, c *gin.Context) {
 	db.Model(&models.Box{}).Where("id = ?", fileModel.BoxID).
 		UpdateColumn("size", gorm.Expr("size + ?", fileModel.Size))
 
-	log.Printf("[CONFIRM] Success - user_id: %d, file: %s", user.ID, fileModel.Name)
+	slog.InfoContext(c.Request.Context(), "Upload confirmed", "file", fileModel.Name)
 	c.JSON(http.StatusOK, gin.H{"message": "upload confirmed", "file": fileModel.Name})
 }
 
 func List(h s3db.Config, db *gorm.DB, c *gin.Context) {
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		log.Printf("[LIST] Auth failed from IP: %s", c.ClientIP())
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "box_name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box_name is required")
 		return
 	}
 
 	box, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
@@ -298,81 +310,77 @@ func List(h s3db.Config, db *gorm.DB, c *gin.Context) {
 func Rename(h s3db.Config, db *gorm.DB, c *gin.Context) {
 	startTime := time.Now()
 
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		log.Printf("[RENAME] Auth failed from IP: %s", c.ClientIP())
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	boxName := c.Query("box_name")
 	s3Key := c.Query("key")
 	newName := c.Query("new_name")
 
 	if boxName == "" || s3Key == "" || newName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "box_name, key, and new_name are required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box_name, key, and new_name are required")
 		return
 	}
+	audit.Target(c, boxName, s3Key)
+	audit.Detail(c, "new name: %s", newName)
 
-	_, err = helpers.ValidateBoxOwnership(db, boxName, user.ID)
+	_, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
 	var fileModel models.File
 	if err := db.Where("s3_key = ? AND user_id = ?", s3Key, user.ID).First(&fileModel).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		apierr.Respond(c, apierr.FileNotFound, "file not found")
 		return
 	}
 
 	if err := db.Model(&fileModel).Update("name", newName).Error; err != nil {
-		log.Printf("[RENAME] DB update failed - user_id: %d, key: %s, error: %v", user.ID, s3Key, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rename file"})
+		slog.ErrorContext(c.Request.Context(), "File rename failed", "key", s3Key, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to rename file")
 		return
 	}
 
-	log.Printf("[RENAME] Success - user_id: %d, key: %s, new_name: %s, duration: %v", user.ID, s3Key, newName, time.Since(startTime))
+	slog.InfoContext(c.Request.Context(), "File renamed", "key", s3Key, "new_name", newName, "duration", time.Since(startTime))
 	c.JSON(http.StatusOK, gin.H{"message": "file renamed", "name": newName})
 }
 
 func Move(h s3db.Config, db *gorm.DB, c *gin.Context) {
 	startTime := time.Now()
 
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		log.Printf("[MOVE] Auth failed from IP: %s", c.ClientIP())
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	boxName := c.Query("box_name")
 	s3Key := c.Query("key")
 	targetPath := c.Query("target_path")
 
 	if boxName == "" || s3Key == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "box_name and key are required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box_name and key are required")
 		return
 	}
+	audit.Target(c, boxName, s3Key)
+	audit.Detail(c, "target: /%s", targetPath)
 
-	_, err = helpers.ValidateBoxOwnership(db, boxName, user.ID)
+	_, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
 	var fileModel models.File
 	if err := db.Where("s3_key = ? AND user_id = ?", s3Key, user.ID).First(&fileModel).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+		apierr.Respond(c, apierr.FileNotFound, "file not found")
 		return
 	}
 
 	// Resolve destination folder ID from target_path
 	newFolderID := helpers.GetParentFolderID(db, user.ID, boxName, targetPath)
 	if err := db.Model(&fileModel).Update("folder_id", newFolderID).Error; err != nil {
-		log.Printf("[MOVE] DB update failed - user_id: %d, key: %s, error: %v", user.ID, s3Key, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to move file"})
+		slog.ErrorContext(c.Request.Context(), "File move failed", "key", s3Key, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to move file")
 		return
 	}
 
-	log.Printf("[MOVE] Success - user_id: %d, key: %s, target: %s, duration: %v", user.ID, s3Key, targetPath, time.Since(startTime))
+	slog.InfoContext(c.Request.Context(), "File moved", "key", s3Key, "target", targetPath, "duration", time.Since(startTime))
 	c.JSON(http.StatusOK, gin.H{"message": "file moved"})
 }
@@ -3,17 +3,23 @@ package folder
 import (
 	"archive/zip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
 	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/audit"
 	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
 	"github.com/nimbus/api/utils/helpers"
 	"gorm.io/gorm"
 )
@@ -38,45 +44,42 @@ type FileEntry struct {
 type FolderEntry struct {
 	ID        uint   `json:"id"`
 	Name      string `json:"name"`
+	Size      int64  `json:"size"` // bytes of every confirmed file below it
 	CreatedAt string `json:"created_at"`
 }
 
 func Create(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	var err error
-	var user *models.User
-	user, err = jwt.AuthenticateUser(c, db)
-	if err != nil {
-		c.JSON(401, gin.H{"error": "unauthorized"})
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	const MAX_FOLDER_NAME_LENGTH int = 25
 
 	if h.Bucket == "" || h.Client == nil {
-		c.JSON(500, gin.H{"error": "S3 client or bucket not configured"})
+		apierr.Respond(c, apierr.Internal, "S3 client or bucket not configured")
 		return
 	}
 
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(400, gin.H{"error": "box name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box name is required")
 		return
 	}
 
 	foldername := c.Query("folder_name")
 	if foldername == "" {
-		c.JSON(400, gin.H{"error": "folder name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "folder name is required")
 		return
 	}
+	audit.Target(c, boxName, strings.Trim(c.Query("path")+"/"+foldername, "/"))
 
 	if len(foldername) > MAX_FOLDER_NAME_LENGTH {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("folder name must be at most %d characters", MAX_FOLDER_NAME_LENGTH)})
+		apierr.Respond(c, apierr.InvalidRequest, fmt.Sprintf("folder name must be at most %d characters", MAX_FOLDER_NAME_LENGTH))
 		return
 	}
 
-	_, err = helpers.ValidateBoxOwnership(db, boxName, user.ID)
+	box, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		c.JSON(403, gin.H{"error": "box not found or access denied"})
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
@@ -84,17 +87,54 @@ func Create(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	sanitizedName := filepath.Base(foldername)
 	sanitizedName = strings.ReplaceAll(sanitizedName, " ", "_")
 
-	Path := c.Query("path")
+	Path := strings.Trim(c.Query("path"), "/")
 
-	// Build the S3 key with trailing slash to represent a folder
-	var key string
+	// Resolve the parent, and refuse a name already taken inside it
+	var parent *models.Folder
+	folderPath := sanitizedName
+	if Path != "" {
+		parent, err = storage.FolderByPath(db, box.ID, Path)
+		if errors.Is(err, storage.ErrNotFound) {
+			apierr.Respond(c, apierr.FolderNotFound, "parent folder not found")
+			return
+		} else if err != nil {
+			apierr.Respond(c, apierr.Internal, "Failed to create folder in database")
+			return
+		}
+		folderPath = Path + "/" + sanitizedName
+	}
+	if _, err := storage.FolderByPath(db, box.ID, folderPath); err == nil {
+		apierr.Respond(c, apierr.NameConflict, "a folder with that name already exists")
+		return
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		apierr.Respond(c, apierr.Internal, "Failed to create folder in database")
+		return
+	}
 
-	if Path == "" {
-		key = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/", user.ID, boxName, sanitizedName)
-	} else {
-		key = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/%s/", user.ID, boxName, Path, sanitizedName)
+	// The row goes in before the S3 placeholder, so a concurrent create of the
+	// same folder is stopped by the unique path index rather than leaving a
+	// stray object behind.
+	folder := &models.Folder{
+		Name:   sanitizedName,
+		UserID: user.ID,
+		BoxID:  box.ID,
+		Path:   folderPath,
+	}
+	if parent != nil {
+		folder.ParentID = &parent.ID
+	}
+	if err = db.Create(folder).Error; err != nil {
+		if _, lookupErr := storage.FolderByPath(db, box.ID, folderPath); lookupErr == nil {
+			apierr.Respond(c, apierr.NameConflict, "a folder with that name already exists")
+			return
+		}
+		apierr.Respond(c, apierr.Internal, "Failed to create folder in database")
+		return
 	}
 
+	// Build the S3 key with trailing slash to represent a folder
+	key := fmt.Sprintf("%s%s/", box.S3Prefix, folderPath)
+
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
 
@@ -104,19 +144,9 @@ func Create(h s3db.Config, c *gin.Context, db *gorm.DB) {
 		Body:   strings.NewReader(""),
 	})
 	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to create folder"})
+		db.Unscoped().Delete(folder)
+		apierr.Respond(c, apierr.Internal, "Failed to create folder")
 		return
-	} else {
-		err = db.Create(&models.Folder{
-			Name:     sanitizedName,
-			UserID:   user.ID,
-			BoxID:    helpers.GetBoxID(db, boxName, user.ID),
-			ParentID: helpers.GetParentFolderID(db, user.ID, boxName, Path),
-		}).Error
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to create folder in database"})
-			return
-		}
 	}
 
 	c.JSON(200, gin.H{"message": "Folder created successfully", "folder": key})
@@ -124,33 +154,29 @@ func Create(h s3db.Config, c *gin.Context, db *gorm.DB) {
 
 func Download(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	var err error
-	var user *models.User
-	user, err = jwt.AuthenticateUser(c, db)
-	if err != nil {
-		c.JSON(401, gin.H{"error": "unauthorized"})
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	if h.Bucket == "" || h.Client == nil {
-		c.JSON(500, gin.H{"error": "S3 client or bucket not configured"})
+		apierr.Respond(c, apierr.Internal, "S3 client or bucket not configured")
 		return
 	}
 
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(400, gin.H{"error": "box name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box name is required")
 		return
 	}
 
 	foldername := c.Query("folder_name")
 	if foldername == "" {
-		c.JSON(400, gin.H{"error": "folder name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "folder name is required")
 		return
 	}
+	audit.Target(c, boxName, strings.Trim(c.Query("path")+"/"+foldername, "/"))
 
-	_, err = helpers.ValidateBoxOwnership(db, boxName, user.ID)
+	box, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		c.JSON(403, gin.H{"error": "box not found or access denied"})
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
@@ -163,9 +189,9 @@ func Download(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	var key string
 
 	if Path == "" {
-		key = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/", user.ID, boxName, sanitizedName)
+		key = fmt.Sprintf("%s%s/", box.S3Prefix, sanitizedName)
 	} else {
-		key = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/%s/", user.ID, boxName, Path, sanitizedName)
+		key = fmt.Sprintf("%s%s/%s/", box.S3Prefix, Path, sanitizedName)
 	}
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
 	defer cancel()
@@ -180,7 +206,7 @@ func Download(h s3db.Config, c *gin.Context, db *gorm.DB) {
 			ContinuationToken: continuationToken,
 		})
 		if err != nil {
-			c.JSON(500, gin.H{"error": "failed to list folder contents"})
+			apierr.Respond(c, apierr.Internal, "failed to list folder contents")
 			return
 		}
 		for _, obj := range page.Contents {
@@ -193,7 +219,7 @@ func Download(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	}
 
 	if len(allKeys) == 0 {
-		c.JSON(404, gin.H{"error": "folder is empty or not found"})
+		apierr.Respond(c, apierr.FolderNotFound, "folder is empty or not found")
 		return
 	}
 
@@ -236,21 +262,17 @@ func Download(h s3db.Config, c *gin.Context, db *gorm.DB) {
 }
 
 func List(h s3db.Config, c *gin.Context, db *gorm.DB) {
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		c.JSON(401, gin.H{"error": "unauthorized"})
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(400, gin.H{"error": "box_name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box_name is required")
 		return
 	}
 
 	box, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		c.JSON(403, gin.H{"error": "box not found or access denied"})
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
@@ -260,35 +282,18 @@ func List(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	var folderName string
 	var path string
 
-	if pathParam != "" && pathParam != "/" {
-		pathParam = strings.Trim(pathParam, "/")
-		segments := strings.Split(pathParam, "/")
-
-		var currentParentID *uint = nil
-
-		for i, segment := range segments {
-			var folder models.Folder
-			query := db.Where("name = ? AND user_id = ? AND box_id = ?", segment, user.ID, box.ID)
-
-			if currentParentID == nil {
-				query = query.Where("parent_id IS NULL")
-			} else {
-				query = query.Where("parent_id = ?", *currentParentID)
-			}
-
-			if err := query.First(&folder).Error; err != nil {
-				c.JSON(404, gin.H{"error": fmt.Sprintf("folder not found: %s", segment)})
-				return
-			}
-
-			if i == len(segments)-1 {
-				folderID = &folder.ID
-				folderName = folder.Name
-				path = "/" + pathParam
-			} else {
-				currentParentID = &folder.ID
-			}
+	if pathParam = strings.Trim(pathParam, "/"); pathParam != "" {
+		folder, err := storage.FolderByPath(db.Where("user_id = ?", user.ID), box.ID, pathParam)
+		if errors.Is(err, storage.ErrNotFound) {
+			apierr.Respond(c, apierr.FolderNotFound, fmt.Sprintf("folder not found: %s", pathParam))
+			return
+		} else if err != nil {
+			apierr.Respond(c, apierr.Internal, "failed to load folder")
+			return
 		}
+		folderID = &folder.ID
+		folderName = folder.Name
+		path = "/" + folder.Path
 	} else {
 		folderName = boxName
 		path = "/"
@@ -319,11 +324,17 @@ func List(h s3db.Config, c *gin.Context, db *gorm.DB) {
 		}
 	}
 
+	sizes, err := storage.FolderSizes(db, subfolders)
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to compute folder sizes")
+		return
+	}
 	folderEntries := make([]FolderEntry, len(subfolders))
 	for i, f := range subfolders {
 		folderEntries[i] = FolderEntry{
 			ID:        f.ID,
 			Name:      f.Name,
+			Size:      sizes[f.ID],
 			CreatedAt: f.CreatedAt.Format(time.RFC3339),
 		}
 	}
@@ -337,91 +348,90 @@ func List(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	})
 }
 func Move(h s3db.Config, c *gin.Context, db *gorm.DB) {
-	c.JSON(501, gin.H{"error": "folder move is not yet implemented"})
+	apierr.Respond(c, apierr.NotImplemented, "folder move is not yet implemented")
 }
 
 func Upload(h s3db.Config, c *gin.Context) {
-	c.JSON(501, gin.H{"error": "folder upload is not yet implemented"})
+	apierr.Respond(c, apierr.NotImplemented, "folder upload is not yet implemented")
 }
 
 func Rename(h s3db.Config, c *gin.Context, db *gorm.DB) {
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		c.JSON(401, gin.H{"error": "unauthorized"})
-		return
-	}
+	user := jwt.CurrentUser(c)
 
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(400, gin.H{"error": "box_name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box_name is required")
 		return
 	}
 
 	folderName := c.Query("folder_name")
 	if folderName == "" {
-		c.JSON(400, gin.H{"error": "folder_name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "folder_name is required")
 		return
 	}
+	audit.Target(c, boxName, strings.Trim(c.Query("path")+"/"+folderName, "/"))
 
 	newName := c.Query("new_name")
 	if newName == "" {
-		c.JSON(400, gin.H{"error": "new_name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "new_name is required")
 		return
 	}
+	audit.Detail(c, "new name: %s", newName)
 
 	box, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		c.JSON(403, gin.H{"error": "box not found or access denied"})
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
 	pathParam := strings.Trim(c.Query("path"), "/")
 
-	// Resolve the folder in the DB
-	folderID := helpers.GetParentFolderID(db, user.ID, boxName, func() string {
-		if pathParam == "" {
-			return folderName
-		}
-		return pathParam + "/" + folderName
-	}())
-	if folderID == nil {
-		c.JSON(404, gin.H{"error": "folder not found"})
+	// Resolve the folder and its parent in the DB
+	folderPath := strings.Trim(pathParam+"/"+folderName, "/")
+	target, err := storage.FolderByPath(db.Where("user_id = ?", user.ID), box.ID, folderPath)
+	if errors.Is(err, storage.ErrNotFound) {
+		apierr.Respond(c, apierr.FolderNotFound, "folder not found")
 		return
-	}
-
-	// Check new name isn't already taken under the same parent
-	var existing models.Folder
-	parentID := helpers.GetParentFolderID(db, user.ID, boxName, pathParam)
-	q := db.Where("name = ? AND user_id = ? AND box_id = ?", newName, user.ID, box.ID)
-	if parentID == nil {
-		q = q.Where("parent_id IS NULL")
-	} else {
-		q = q.Where("parent_id = ?", *parentID)
-	}
-	if q.First(&existing).Error == nil {
-		c.JSON(409, gin.H{"error": "a folder with that name already exists"})
+	} else if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to load folder")
 		return
 	}
+	var parent *models.Folder
+	if pathParam != "" {
+		if parent, err = storage.FolderByPath(db, box.ID, pathParam); err != nil {
+			apierr.Respond(c, apierr.Internal, "failed to load parent folder")
+			return
+		}
+	}
 
 	sanitizedNew := filepath.Base(newName)
 	sanitizedNew = strings.ReplaceAll(sanitizedNew, " ", "_")
 
-	// Rename in S3: copy all objects under old prefix to new prefix, then delete old
+	// Check new name isn't already taken under the same parent
+	if _, err := storage.FolderByPath(db, box.ID, strings.Trim(pathParam+"/"+sanitizedNew, "/")); err == nil {
+		apierr.Respond(c, apierr.NameConflict, "a folder with that name already exists")
+		return
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		apierr.Respond(c, apierr.Internal, "failed to rename folder in database")
+		return
+	}
+
+	// Rename in S3: copy all objects under old prefix to new prefix; the
+	// originals are deleted once the database points at the copies.
+	var oldPrefix, newPrefix string
+	var oldKeys, copiedNewKeys []string
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
 	if h.Client != nil && h.Bucket != "" {
-		var oldPrefix, newPrefix string
 		if pathParam == "" {
-			oldPrefix = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/", user.ID, box.Name, folderName)
-			newPrefix = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/", user.ID, box.Name, sanitizedNew)
+			oldPrefix = fmt.Sprintf("%s%s/", box.S3Prefix, folderName)
+			newPrefix = fmt.Sprintf("%s%s/", box.S3Prefix, sanitizedNew)
 		} else {
-			oldPrefix = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/%s/", user.ID, box.Name, pathParam, folderName)
-			newPrefix = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/%s/", user.ID, box.Name, pathParam, sanitizedNew)
+			oldPrefix = fmt.Sprintf("%s%s/%s/", box.S3Prefix, pathParam, folderName)
+			newPrefix = fmt.Sprintf("%s%s/%s/", box.S3Prefix, pathParam, sanitizedNew)
 		}
 
-		ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
-		defer cancel()
-
 		// Paginate through all objects under the old prefix.
-		var oldKeys []string
 		var ct *string
 		for {
 			page, err := h.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
@@ -430,7 +440,7 @@ func Rename(h s3db.Config, c *gin.Context, db *gorm.DB) {
 				ContinuationToken: ct,
 			})
 			if err != nil {
-				c.JSON(500, gin.H{"error": "failed to list folder contents in S3"})
+				apierr.Respond(c, apierr.Internal, "failed to list folder contents in S3")
 				return
 			}
 			for _, obj := range page.Contents {
@@ -443,8 +453,7 @@ func Rename(h s3db.Config, c *gin.Context, db *gorm.DB) {
 		}
 
 		// Copy each object to the new prefix, tracking what was successfully copied
-		// so we can roll back if a subsequent delete fails.
-		var copiedNewKeys []string
+		// so we can roll back if a later step fails.
 		for _, oldKey := range oldKeys {
 			newKey := newPrefix + strings.TrimPrefix(oldKey, oldPrefix)
 			copySource := h.Bucket + "/" + oldKey
@@ -454,76 +463,88 @@ func Rename(h s3db.Config, c *gin.Context, db *gorm.DB) {
 				CopySource: &copySource,
 				Key:        &newKey,
 			}); err != nil {
-				// Roll back any copies already written. Best-effort: a failed
-				// rollback delete leaves an orphaned object but shouldn't mask the
-				// original error, so the delete error is intentionally ignored.
-				for _, k := range copiedNewKeys {
-					_, _ = h.Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &h.Bucket, Key: &k})
-				}
-				c.JSON(500, gin.H{"error": "failed to copy S3 objects during rename"})
+				deleteKeys(ctx, h, copiedNewKeys)
+				apierr.Respond(c, apierr.Internal, "failed to copy S3 objects during rename")
 				return
 			}
 			copiedNewKeys = append(copiedNewKeys, newKey)
 		}
+	}
 
-		// All copies succeeded — delete the originals.
-		for _, oldKey := range oldKeys {
-			if _, err := h.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-				Bucket: &h.Bucket,
-				Key:    &oldKey,
-			}); err != nil {
-				// Non-fatal: the new keys already exist and the DB will be updated.
-				// Log and continue so one stale original doesn't abort the rename.
-				fmt.Printf("[RENAME] warning: failed to delete old S3 key %s: %v\n", oldKey, err)
-			}
+	// Rename in DB, along with the paths of everything below it, and point the
+	// files at their copies.
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := storage.MoveFolder(tx, target, box, parent, sanitizedNew); err != nil {
+			return err
+		}
+		if len(copiedNewKeys) == 0 {
+			return nil
 		}
+		return tx.Model(&models.File{}).
+			Where("box_id = ? AND substr(s3_key, 1, ?) = ?", box.ID, utf8.RuneCountInString(oldPrefix), oldPrefix).
+			Update("s3_key", gorm.Expr("? || substr(s3_key, ?)", newPrefix, utf8.RuneCountInString(oldPrefix)+1)).Error
+	})
+	if err != nil {
+		deleteKeys(ctx, h, copiedNewKeys)
+		apierr.Respond(c, apierr.Internal, "failed to rename folder in database")
+		return
 	}
 
-	// Rename in DB
-	if err := db.Model(&models.Folder{}).Where("id = ?", *folderID).Update("name", sanitizedNew).Error; err != nil {
-		c.JSON(500, gin.H{"error": "failed to rename folder in database"})
-		return
+	// The database now points at the copies — delete the originals.
+	for _, oldKey := range oldKeys {
+		if _, err := h.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: &h.Bucket,
+			Key:    &oldKey,
+		}); err != nil {
+			// Non-fatal: the new keys already exist and the DB is updated.
+			// Log and continue so one stale original doesn't abort the rename.
+			slog.WarnContext(c.Request.Context(), "Failed to delete old S3 key after folder rename", "key", oldKey, "error", err)
+		}
 	}
 
 	c.JSON(200, gin.H{"message": "folder renamed successfully", "new_name": sanitizedNew})
 }
 
-func Delete(h s3db.Config, c *gin.Context, db *gorm.DB) {
-	user, err := jwt.AuthenticateUser(c, db)
-	if err != nil {
-		c.JSON(401, gin.H{"error": "unauthorized"})
-		return
+// deleteKeys removes the objects a failed rename had already copied.
+// Best-effort: a failed delete leaves an orphaned object but shouldn't mask
+// the original error, so delete errors are intentionally ignored.
+func deleteKeys(ctx context.Context, h s3db.Config, keys []string) {
+	for _, k := range keys {
+		_, _ = h.Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &h.Bucket, Key: &k})
 	}
+}
+
+func Delete(h s3db.Config, c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
 
 	boxName := c.Query("box_name")
 	if boxName == "" {
-		c.JSON(400, gin.H{"error": "box_name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "box_name is required")
 		return
 	}
 
 	folderName := c.Query("folder_name")
 	if folderName == "" {
-		c.JSON(400, gin.H{"error": "folder_name is required"})
+		apierr.Respond(c, apierr.InvalidRequest, "folder_name is required")
 		return
 	}
+	audit.Target(c, boxName, strings.Trim(c.Query("path")+"/"+folderName, "/"))
 
 	box, err := helpers.ValidateBoxOwnership(db, boxName, user.ID)
 	if err != nil {
-		c.JSON(403, gin.H{"error": "box not found or access denied"})
+		apierr.RespondError(c, err, "failed to load box")
 		return
 	}
 
 	pathParam := strings.Trim(c.Query("path"), "/")
 
 	// Resolve the target folder in the DB
-	folderID := helpers.GetParentFolderID(db, user.ID, boxName, func() string {
-		if pathParam == "" {
-			return folderName
-		}
-		return pathParam + "/" + folderName
-	}())
-	if folderID == nil {
-		c.JSON(404, gin.H{"error": "folder not found"})
+	folderPath := strings.Trim(pathParam+"/"+folderName, "/")
+	if _, err := storage.FolderByPath(db.Where("user_id = ?", user.ID), box.ID, folderPath); errors.Is(err, storage.ErrNotFound) {
+		apierr.Respond(c, apierr.FolderNotFound, "folder not found")
+		return
+	} else if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to load folder")
 		return
 	}
 
@@ -533,9 +554,9 @@ func Delete(h s3db.Config, c *gin.Context, db *gorm.DB) {
 	// Build the S3 prefix for this folder
 	var s3Prefix string
 	if pathParam == "" {
-		s3Prefix = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/", user.ID, box.Name, folderName)
+		s3Prefix = fmt.Sprintf("%s%s/", box.S3Prefix, folderName)
 	} else {
-		s3Prefix = fmt.Sprintf("users/nim-user-%d/boxes/%s/%s/%s/", user.ID, box.Name, pathParam, folderName)
+		s3Prefix = fmt.Sprintf("%s%s/%s/", box.S3Prefix, pathParam, folderName)
 	}
 
 	// Delete all S3 objects under the prefix (skip if S3 not configured)
@@ -545,7 +566,7 @@ func Delete(h s3db.Config, c *gin.Context, db *gorm.DB) {
 			Prefix: &s3Prefix,
 		})
 		if err != nil {
-			c.JSON(500, gin.H{"error": "failed to list folder contents in S3"})
+			apierr.Respond(c, apierr.Internal, "failed to list folder contents in S3")
 			return
 		}
 		for _, obj := range list.Contents {
@@ -559,26 +580,11 @@ func Delete(h s3db.Config, c *gin.Context, db *gorm.DB) {
 		}
 	}
 
-	// Recursively delete all DB records under this folder (files + subfolders)
-	if err := deleteFolderTree(db, *folderID); err != nil {
-		c.JSON(500, gin.H{"error": "failed to delete folder records from database"})
+	// Delete every DB record under this folder (files + subfolders) at once
+	if _, _, err := storage.DeleteFolder(db, box, folderPath); err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to delete folder records from database")
 		return
 	}
 
 	c.JSON(200, gin.H{"message": "folder deleted successfully"})
 }
-
-// deleteFolderTree recursively deletes a folder and all its contents from the DB.
-func deleteFolderTree(db *gorm.DB, folderID uint) error {
-	var subfolders []models.Folder
-	db.Where("parent_id = ?", folderID).Find(&subfolders)
-	for _, sub := range subfolders {
-		if err := deleteFolderTree(db, sub.ID); err != nil {
-			return err
-		}
-	}
-	if err := db.Where("folder_id = ?", folderID).Delete(&models.File{}).Error; err != nil {
-		return err
-	}
-	return db.Delete(&models.Folder{}, folderID).Error
-}
@@ -0,0 +1,257 @@
+// Package s3gw serves Nimbus boxes through an S3-compatible API so tools that
+// only speak S3 (aws-cli, boto3, restic, rclone) can use them. Each box the
+// caller owns is a bucket and object keys are slash paths inside it, so
+// "s3://photos/2024/beach.jpg" is the file beach.jpg in folder 2024 of the box
+// photos. Folders are created implicitly by uploads, the way S3 prefixes are.
+//
+// Requests are path-style ("/<bucket>/<key>") and signed with AWS Signature
+// Version 4 using a per-user access key (see models.S3AccessKey); header
+// signatures, presigned URLs and aws-chunked streaming uploads are accepted.
+// Every operation goes through the storage package, so the Box/Folder/File
+// records, box sizes and the per-file size cap stay exactly as the REST API
+// and WebDAV leave them.
+package s3gw
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/logging"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/tracing"
+	"gorm.io/gorm"
+)
+
+// transferTimeout replaces the server-wide read/write timeouts for gateway
+// requests, which carry whole objects instead of small JSON bodies.
+const transferTimeout = time.Hour
+
+// region is reported to clients that ask where a bucket lives. Signatures for
+// any region are accepted; this only has to be a valid one.
+const region = "us-east-1"
+
+// Handler serves S3 API requests.
+type Handler struct {
+	db    *gorm.DB
+	store *storage.Store
+}
+
+// New returns a Handler backed by db and the given S3 configuration.
+func New(config s3db.Config, db *gorm.DB) *Handler {
+	return &Handler{db: db, store: storage.New(db, config)}
+}
+
+// request carries what every operation needs about the call in flight.
+type request struct {
+	w      http.ResponseWriter
+	r      *http.Request
+	user   *models.User
+	body   io.Reader      // payload, verified against the signature as it is read
+	store  *storage.Store // the handler's store, scoped to r's context
+	bucket string
+	key    string
+
+	// events are audit events the operation recorded itself, one per key
+	// of a batch delete. Other operations get one event from Serve.
+	events []*models.AuditEvent
+}
+
+// Serve authenticates the request and dispatches it to the matching S3
+// operation.
+func (h *Handler) Serve(c *gin.Context) {
+	w, r := c.Writer, c.Request
+	requestID := newRequestID()
+	w.Header().Set("X-Amz-Request-Id", requestID)
+	w.Header().Set("Server", "Nimbus")
+
+	user, body, err := h.authenticate(r)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "S3 gateway auth failed", "ip", c.ClientIP(), "error", err)
+		writeError(w, r, toAPIError(err), requestID)
+		audit.Record(c, h.db, &models.AuditEvent{
+			Action: "auth.login", Protocol: audit.ProtocolS3, Result: audit.ResultDenied, RequestID: requestID,
+		})
+		return
+	}
+	logging.AddAttrs(c, logging.UserID(user.ID))
+	tracing.SetUser(c.Request.Context(), user.ID)
+	r = c.Request
+
+	// Not every ResponseWriter supports deadlines (httptest's doesn't); the
+	// server-wide timeouts simply stay in force then.
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(transferTimeout)
+	_ = rc.SetReadDeadline(deadline)
+	_ = rc.SetWriteDeadline(deadline)
+
+	req := &request{w: w, r: r, user: user, body: body, store: h.store.WithContext(r.Context())}
+	req.bucket, req.key, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	switch {
+	case req.bucket == "":
+		err = h.serviceOp(req)
+	case req.key == "":
+		err = h.bucketOp(req)
+	default:
+		err = h.objectOp(req)
+	}
+	if err != nil {
+		apiErr := toAPIError(err)
+		if apiErr == errInternal {
+			slog.ErrorContext(r.Context(), "S3 gateway request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		}
+		writeError(w, r, apiErr, requestID)
+	}
+
+	if len(req.events) == 0 {
+		if action := auditAction(r, req.bucket, req.key); action != "" {
+			req.events = append(req.events, &models.AuditEvent{Action: action, Box: req.bucket, Path: req.key})
+		}
+	}
+	for _, e := range req.events {
+		e.UserID, e.Actor = user.ID, user.Email
+		e.Protocol, e.RequestID = audit.ProtocolS3, requestID
+		audit.Record(c, h.db, e)
+	}
+}
+
+// auditAction names the audit event for an S3 request, or returns "" for the
+// ones that only read metadata (listings, HEAD) or are one step of a multipart
+// upload, whose completion is recorded instead.
+func auditAction(r *http.Request, bucket, key string) string {
+	if bucket == "" || key == "" {
+		return ""
+	}
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		return "file.download"
+	case http.MethodPut:
+		if q.Has("uploadId") {
+			return ""
+		}
+		if strings.HasSuffix(key, "/") {
+			return "folder.create"
+		}
+		return "file.upload"
+	case http.MethodPost:
+		if q.Has("uploadId") {
+			return "file.upload"
+		}
+	case http.MethodDelete:
+		if !q.Has("uploadId") {
+			return "file.delete"
+		}
+	}
+	return ""
+}
+
+// toAPIError maps storage and payload errors onto S3 error codes. Anything
+// unrecognised is an internal error.
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, storage.ErrNotFound):
+		return errNoSuchKey
+	case errors.Is(err, storage.ErrNoSuchUpload):
+		return errNoSuchUpload
+	case errors.Is(err, storage.ErrTooLarge):
+		return errEntityTooLarge
+	case errors.Is(err, storage.ErrQuotaExceeded):
+		return errQuotaExceeded
+	case errors.Is(err, storage.ErrInvalidPath):
+		return errInvalidKey
+	case errors.Is(err, storage.ErrExist), errors.Is(err, storage.ErrIsDir), errors.Is(err, storage.ErrNotDir):
+		return errKeyConflict
+	case errors.Is(err, errMalformedChunk):
+		return errIncompleteBody
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errIncompleteBody
+	default:
+		return errInternal
+	}
+}
+
+// serviceOp handles requests to "/", which only supports ListBuckets.
+func (h *Handler) serviceOp(req *request) error {
+	if req.r.Method != http.MethodGet {
+		return errMethodNotAllowed
+	}
+	boxes, err := req.store.Boxes(req.user.ID)
+	if err != nil {
+		return err
+	}
+	res := listBucketsResult{
+		Xmlns: s3Namespace,
+		Owner: owner{ID: strconv.FormatUint(uint64(req.user.ID), 10), DisplayName: req.user.Email},
+	}
+	for _, b := range boxes {
+		res.Buckets = append(res.Buckets, bucketEntry{Name: b.Name, CreationDate: s3Time(b.CreatedAt)})
+	}
+	writeXML(req.w, http.StatusOK, res)
+	return nil
+}
+
+// bucketOp handles requests addressed to a bucket with no key.
+func (h *Handler) bucketOp(req *request) error {
+	box, err := req.store.Box(req.user.ID, req.bucket)
+	if errors.Is(err, storage.ErrNotFound) {
+		if req.r.Method == http.MethodPut {
+			return errCreateBucket
+		}
+		return errNoSuchBucket
+	}
+	if err != nil {
+		return err
+	}
+
+	q := req.r.URL.Query()
+	switch req.r.Method {
+	case http.MethodHead:
+		req.w.Header().Set("X-Amz-Bucket-Region", region)
+		req.w.WriteHeader(http.StatusOK)
+		return nil
+	case http.MethodGet:
+		switch {
+		case q.Has("location"):
+			writeXML(req.w, http.StatusOK, locationResult{Xmlns: s3Namespace})
+			return nil
+		case q.Get("list-type") == "2":
+			return h.listObjects(req, box, true)
+		case onlyListParams(q):
+			return h.listObjects(req, box, false)
+		}
+		return errNotImplemented
+	case http.MethodPost:
+		if q.Has("delete") {
+			return h.deleteObjects(req, box)
+		}
+		return errNotImplemented
+	case http.MethodPut:
+		return errBucketOwned
+	case http.MethodDelete:
+		return errNotImplemented
+	}
+	return errMethodNotAllowed
+}
+
+// newRequestID returns a random ID for the X-Amz-Request-Id header, which
+// clients print alongside errors.
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return strings.ToUpper(hex.EncodeToString(b[:]))
+}
@@ -0,0 +1,174 @@
+package s3gw
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+)
+
+// maxListKeys is the page size limit S3 itself applies.
+const maxListKeys = 1000
+
+// listParams are the query parameters ListObjects (V1) understands. A GET on
+// a bucket with anything else is a sub-resource request (?acl, ?versioning,
+// ...) that the gateway doesn't implement.
+var listParams = map[string]bool{
+	"prefix": true, "delimiter": true, "marker": true, "max-keys": true, "encoding-type": true,
+}
+
+func onlyListParams(q url.Values) bool {
+	for k := range q {
+		if !listParams[k] && !presignParam(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// listItem is one line of a listing: an object, or a common prefix that
+// stands in for everything below it when a delimiter is used.
+type listItem struct {
+	name  string
+	entry *storage.Entry // nil for common prefixes
+}
+
+// listObjects answers ListObjects and ListObjectsV2. The box is walked in full
+// and filtered in memory, which keeps the S3 key space (flat, sorted, with
+// implicit prefixes) independent of how folders are stored.
+func (h *Handler) listObjects(req *request, box *models.Box, v2 bool) error {
+	q := req.r.URL.Query()
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	maxKeys := maxListKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+	encodingType := q.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		return errInvalidArgument
+	}
+
+	res := listObjectsResult{
+		Xmlns:        s3Namespace,
+		Name:         box.Name,
+		Prefix:       prefix,
+		MaxKeys:      maxKeys,
+		Delimiter:    delimiter,
+		EncodingType: encodingType,
+	}
+
+	// Everything listed must sort after "after": the marker (V1), or the
+	// continuation token or start-after key (V2).
+	var after string
+	if v2 {
+		res.StartAfter = q.Get("start-after")
+		after = res.StartAfter
+		if token := q.Get("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				return errInvalidArgument
+			}
+			res.ContinuationToken = token
+			after = string(decoded)
+		}
+	} else {
+		marker := q.Get("marker")
+		res.Marker = &marker
+		after = marker
+	}
+
+	entries, err := req.store.Walk(box)
+	if err != nil {
+		return err
+	}
+	items := listItems(entries, prefix, delimiter)
+	start := sort.Search(len(items), func(i int) bool { return items[i].name > after })
+	items = items[start:]
+	if len(items) > maxKeys {
+		items = items[:maxKeys]
+		res.IsTruncated = true
+		last := items[len(items)-1].name
+		if v2 {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else {
+			res.NextMarker = encodeKey(last, encodingType)
+		}
+	}
+
+	for _, it := range items {
+		if it.entry == nil {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: encodeKey(it.name, encodingType)})
+			continue
+		}
+		res.Contents = append(res.Contents, objectEntry{
+			Key:          encodeKey(it.name, encodingType),
+			LastModified: s3Time(it.entry.ModTime),
+			ETag:         storage.ETag(it.entry.File),
+			Size:         it.entry.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	if v2 {
+		count := len(items)
+		res.KeyCount = &count
+	}
+	res.Prefix = encodeKey(prefix, encodingType)
+	res.Delimiter = encodeKey(delimiter, encodingType)
+	res.StartAfter = encodeKey(res.StartAfter, encodingType)
+
+	writeXML(req.w, http.StatusOK, res)
+	return nil
+}
+
+// listItems turns a walked box into the sorted S3 view of it under prefix.
+// Files are objects keyed by their path. Folders are not objects, but with a
+// delimiter they surface as common prefixes, so empty folders stay visible to
+// clients that browse level by level.
+func listItems(entries []storage.Entry, prefix, delimiter string) []listItem {
+	var items []listItem
+	seen := make(map[string]bool)
+	for i := range entries {
+		e := &entries[i]
+		key := e.Path
+		if e.IsDir {
+			key += "/"
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			rest := key[len(prefix):]
+			if idx := strings.Index(rest, delimiter); idx >= 0 {
+				cp := prefix + rest[:idx+len(delimiter)]
+				if !seen[cp] {
+					seen[cp] = true
+					items = append(items, listItem{name: cp})
+				}
+				continue
+			}
+		}
+		if !e.IsDir {
+			items = append(items, listItem{name: key, entry: e})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].name < items[j].name })
+	return items
+}
+
+// encodeKey applies encoding-type=url, which clients request so keys with
+// control characters survive the XML round trip.
+func encodeKey(key, encodingType string) string {
+	if encodingType != "url" {
+		return key
+	}
+	return uriEncode(key, false)
+}
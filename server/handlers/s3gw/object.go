@@ -0,0 +1,471 @@
+package s3gw
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+)
+
+const (
+	// maxXMLBody bounds request documents (CompleteMultipartUpload, Delete).
+	maxXMLBody = 1 << 20
+	// maxDeleteKeys is how many keys one DeleteObjects call may name, as in S3.
+	maxDeleteKeys = 1000
+	// maxPartNumber is the highest part number S3 accepts.
+	maxPartNumber = 10000
+	// emptyETag is the MD5 of no bytes, reported for folder markers.
+	emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+)
+
+// objectOp handles requests addressed to a key inside a bucket.
+func (h *Handler) objectOp(req *request) error {
+	box, err := req.store.Box(req.user.ID, req.bucket)
+	if errors.Is(err, storage.ErrNotFound) {
+		return errNoSuchBucket
+	}
+	if err != nil {
+		return err
+	}
+	p, isDir, err := objectPath(req.key)
+	if err != nil {
+		return err
+	}
+
+	q := req.r.URL.Query()
+	switch req.r.Method {
+	case http.MethodGet, http.MethodHead:
+		if q.Has("uploadId") {
+			return errNotImplemented // ListParts
+		}
+		return h.getObject(req, box, p, isDir)
+	case http.MethodPut:
+		if q.Has("uploadId") {
+			return h.uploadPart(req, box, p)
+		}
+		return h.putObject(req, box, p, isDir)
+	case http.MethodPost:
+		switch {
+		case q.Has("uploads"):
+			return h.createMultipartUpload(req, box, p, isDir)
+		case q.Has("uploadId"):
+			return h.completeMultipartUpload(req, box, p)
+		}
+		return errNotImplemented
+	case http.MethodDelete:
+		if q.Has("uploadId") {
+			return h.abortMultipartUpload(req, box, p)
+		}
+		if err := h.removeKey(req, box, p, isDir); err != nil {
+			return err
+		}
+		req.w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return errMethodNotAllowed
+}
+
+// objectPath converts an object key to a path inside the box. A trailing
+// slash marks a folder, as in the S3 console. Keys that only resolve after
+// normalisation ("a//b", "./a") are rejected so each file has exactly one key.
+func objectPath(key string) (p string, isDir bool, err error) {
+	trimmed := strings.TrimSuffix(key, "/")
+	p, err = storage.CleanPath(trimmed)
+	if err != nil || p != trimmed || p == "" {
+		return "", false, errInvalidKey
+	}
+	return p, trimmed != key, nil
+}
+
+// getObject serves GetObject and HeadObject, including single byte ranges.
+func (h *Handler) getObject(req *request, box *models.Box, p string, isDir bool) error {
+	e, err := req.store.Stat(box, p)
+	if err != nil {
+		return err
+	}
+	hdr := req.w.Header()
+	if isDir || e.IsDir {
+		if !isDir || !e.IsDir {
+			return errNoSuchKey
+		}
+		hdr.Set("Content-Type", "application/x-directory")
+		hdr.Set("Content-Length", "0")
+		hdr.Set("ETag", emptyETag)
+		hdr.Set("Last-Modified", e.ModTime.UTC().Format(http.TimeFormat))
+		req.w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	offset, length := int64(0), e.Size
+	status := http.StatusOK
+	if rng := req.r.Header.Get("Range"); rng != "" {
+		start, n, ok, err := parseRange(rng, e.Size)
+		if err != nil {
+			hdr.Set("Content-Range", fmt.Sprintf("bytes */%d", e.Size))
+			return err
+		}
+		if ok {
+			offset, length = start, n
+			status = http.StatusPartialContent
+			hdr.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, e.Size))
+		}
+	}
+
+	hdr.Set("Content-Type", storage.ContentType(e.Name))
+	hdr.Set("Content-Length", strconv.FormatInt(length, 10))
+	hdr.Set("ETag", storage.ETag(e.File))
+	hdr.Set("Last-Modified", e.ModTime.UTC().Format(http.TimeFormat))
+	hdr.Set("Accept-Ranges", "bytes")
+	if req.r.Method == http.MethodHead {
+		req.w.WriteHeader(status)
+		return nil
+	}
+
+	body, err := req.store.Open(req.r.Context(), e.File, offset, length)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = body.Close() }()
+	req.w.WriteHeader(status)
+	// Headers are gone by now; a failed copy can only cut the body short,
+	// which the client detects from Content-Length.
+	_, _ = io.Copy(req.w, body)
+	return nil
+}
+
+// parseRange parses a single "bytes=" range against size. ok is false for
+// headers that should be ignored (multiple ranges, other units), which means
+// serving the whole object as RFC 9110 allows.
+func parseRange(header string, size int64) (offset, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	if first == "" {
+		// Suffix range: the last N bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, errInvalidRange
+		}
+		n = min(n, size)
+		return size - n, n, true, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, errInvalidRange
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, errInvalidRange
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true, nil
+}
+
+// putObject stores the body as the file at p, creating missing parent
+// folders the way S3 creates prefixes implicitly. A zero-byte PUT to a key
+// ending in "/" creates a folder instead.
+func (h *Handler) putObject(req *request, box *models.Box, p string, isDir bool) error {
+	if req.r.Header.Get("X-Amz-Copy-Source") != "" {
+		return errNotImplemented // CopyObject
+	}
+	size, err := contentLength(req.r)
+	if err != nil {
+		return err
+	}
+	ctx := req.r.Context()
+
+	if isDir {
+		if size > 0 {
+			return errInvalidArgument
+		}
+		if _, err := req.store.MkdirAll(ctx, box, p); err != nil {
+			return err
+		}
+		req.w.Header().Set("ETag", emptyETag)
+		req.w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	if size > storage.MaxFileSize {
+		return errEntityTooLarge
+	}
+	dir, _ := path.Split(p)
+	if _, err := req.store.MkdirAll(ctx, box, dir); err != nil {
+		return err
+	}
+	e, err := req.store.Put(ctx, box, p, &exactReader{r: req.body, left: size}, size)
+	if err != nil {
+		return err
+	}
+	req.w.Header().Set("ETag", storage.ETag(e.File))
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// contentLength returns the payload size of an upload. For aws-chunked
+// bodies that is the decoded length, not the length of the framing.
+func contentLength(r *http.Request) (int64, error) {
+	if v := r.Header.Get("X-Amz-Decoded-Content-Length"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return 0, errInvalidArgument
+		}
+		return n, nil
+	}
+	if r.ContentLength < 0 {
+		return 0, errMissingContentLength
+	}
+	return r.ContentLength, nil
+}
+
+// exactReader yields exactly left bytes from r and fails if r ends early or
+// runs on past them. After the last byte it still reads r to EOF, so wrapped
+// readers get to check their digests or signatures.
+type exactReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (e *exactReader) Read(p []byte) (int, error) {
+	if e.left == 0 {
+		var extra [1]byte
+		n, err := e.r.Read(extra[:])
+		switch {
+		case n > 0:
+			return 0, errIncompleteBody
+		case err == nil:
+			return 0, nil
+		}
+		return 0, err
+	}
+	if int64(len(p)) > e.left {
+		p = p[:e.left]
+	}
+	n, err := e.r.Read(p)
+	e.left -= int64(n)
+	if errors.Is(err, io.EOF) {
+		if e.left > 0 {
+			return n, errIncompleteBody
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// removeKey deletes the file at p. A folder is only removed through its
+// marker key ("p/") and only when empty, matching S3, where deleting a prefix
+// marker never deletes the objects under it. Missing keys are not an error.
+func (h *Handler) removeKey(req *request, box *models.Box, p string, isDir bool) error {
+	e, err := req.store.Stat(box, p)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if isDir != e.IsDir {
+		return nil
+	}
+	if e.IsDir {
+		children, err := req.store.ReadDir(box, p)
+		if err != nil || len(children) > 0 {
+			return err
+		}
+	}
+	return req.store.Remove(req.r.Context(), box, p)
+}
+
+// deleteObjects handles the batch delete used by "aws s3 rm --recursive",
+// rclone and restic.
+func (h *Handler) deleteObjects(req *request, box *models.Box) error {
+	var doc deleteRequest
+	if err := decodeXML(req.body, &doc); err != nil {
+		return err
+	}
+	if len(doc.Objects) == 0 || len(doc.Objects) > maxDeleteKeys {
+		return errMalformedXML
+	}
+
+	res := deleteResult{Xmlns: s3Namespace}
+	for _, obj := range doc.Objects {
+		p, isDir, err := objectPath(obj.Key)
+		if err == nil {
+			err = h.removeKey(req, box, p, isDir)
+		}
+		e := &models.AuditEvent{Action: "file.delete", Box: req.bucket, Path: obj.Key, Result: audit.ResultSuccess}
+		req.events = append(req.events, e)
+		if err != nil {
+			apiErr := toAPIError(err)
+			res.Errors = append(res.Errors, deleteErrorEntry{Key: obj.Key, Code: apiErr.code, Message: apiErr.message})
+			e.Result, e.Detail = audit.ResultFailure, apiErr.code
+			continue
+		}
+		if !doc.Quiet {
+			res.Deleted = append(res.Deleted, deletedEntry{Key: obj.Key})
+		}
+	}
+	writeXML(req.w, http.StatusOK, res)
+	return nil
+}
+
+// createMultipartUpload starts a multipart upload of p.
+func (h *Handler) createMultipartUpload(req *request, box *models.Box, p string, isDir bool) error {
+	if isDir {
+		return errInvalidKey
+	}
+	up, err := req.store.StartUpload(req.r.Context(), box, p)
+	if err != nil {
+		return err
+	}
+	writeXML(req.w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   box.Name,
+		Key:      req.key,
+		UploadID: up.UploadID,
+	})
+	return nil
+}
+
+// upload loads the multipart upload named in the query and checks that it was
+// started for this bucket and key.
+func (h *Handler) upload(req *request, box *models.Box, p string) (*models.S3Upload, *models.Box, error) {
+	up, upBox, err := req.store.Upload(req.user.ID, req.r.URL.Query().Get("uploadId"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if upBox.ID != box.ID || up.Path != p {
+		return nil, nil, errNoSuchUpload
+	}
+	return up, upBox, nil
+}
+
+// uploadPart streams one part of a multipart upload to the backing bucket.
+func (h *Handler) uploadPart(req *request, box *models.Box, p string) error {
+	if req.r.Header.Get("X-Amz-Copy-Source") != "" {
+		return errNotImplemented // UploadPartCopy
+	}
+	partNumber, err := strconv.Atoi(req.r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return errInvalidArgument
+	}
+	size, err := contentLength(req.r)
+	if err != nil {
+		return err
+	}
+	if size > storage.MaxFileSize {
+		return errEntityTooLarge
+	}
+	up, _, err := h.upload(req, box, p)
+	if err != nil {
+		return err
+	}
+
+	etag, err := req.store.PutPart(req.r.Context(), up, int32(partNumber), &exactReader{r: req.body, left: size}, size)
+	if err != nil {
+		return backendError(err)
+	}
+	req.w.Header().Set("ETag", etag)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// completeMultipartUpload assembles the uploaded parts into the file at p.
+func (h *Handler) completeMultipartUpload(req *request, box *models.Box, p string) error {
+	up, upBox, err := h.upload(req, box, p)
+	if err != nil {
+		return err
+	}
+	var doc completeMultipartUpload
+	if err := decodeXML(req.body, &doc); err != nil {
+		return err
+	}
+	if len(doc.Parts) == 0 {
+		return errMalformedXML
+	}
+	parts := make([]types.CompletedPart, len(doc.Parts))
+	for i, part := range doc.Parts {
+		if i > 0 && part.PartNumber <= doc.Parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+		parts[i] = types.CompletedPart{PartNumber: aws.Int32(part.PartNumber), ETag: aws.String(part.ETag)}
+	}
+
+	e, err := req.store.CompleteUpload(req.r.Context(), up, upBox, parts)
+	if err != nil {
+		return backendError(err)
+	}
+	writeXML(req.w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: "/" + box.Name + "/" + req.key,
+		Bucket:   box.Name,
+		Key:      req.key,
+		ETag:     storage.ETag(e.File),
+	})
+	return nil
+}
+
+// abortMultipartUpload discards a multipart upload and its parts.
+func (h *Handler) abortMultipartUpload(req *request, box *models.Box, p string) error {
+	up, _, err := h.upload(req, box, p)
+	if err != nil {
+		return err
+	}
+	if err := req.store.AbortUpload(req.r.Context(), up); err != nil {
+		return err
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// backendError passes through the multipart errors the backing bucket reports
+// for client mistakes (a wrong part ETag, a too-small part) so the client sees
+// the real cause instead of an internal error.
+func backendError(err error) error {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		switch ae.ErrorCode() {
+		case "InvalidPart":
+			return errInvalidPart
+		case "InvalidPartOrder":
+			return errInvalidPartOrder
+		case "NoSuchUpload":
+			return errNoSuchUpload
+		case "EntityTooSmall":
+			return &apiError{http.StatusBadRequest, "EntityTooSmall", ae.ErrorMessage()}
+		}
+	}
+	return err
+}
+
+// decodeXML reads a bounded XML request document into v.
+func decodeXML(body io.Reader, v any) error {
+	data, err := io.ReadAll(io.LimitReader(body, maxXMLBody+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxXMLBody {
+		return errMalformedXML
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return errMalformedXML
+	}
+	return nil
+}
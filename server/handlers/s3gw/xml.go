@@ -0,0 +1,202 @@
+package s3gw
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// s3Namespace is the XML namespace every S3 response document is in.
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// apiError is an S3 error response. Handlers return these instead of writing
+// responses directly so every failure carries a code S3 clients recognise.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string { return e.code + ": " + e.message }
+
+var (
+	errAccessDenied          = &apiError{http.StatusForbidden, "AccessDenied", "Access Denied."}
+	errInvalidAccessKeyID    = &apiError{http.StatusForbidden, "InvalidAccessKeyId", "The access key ID you provided does not exist in our records."}
+	errSignatureMismatch     = &apiError{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."}
+	errRequestTimeTooSkewed  = &apiError{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large."}
+	errExpiredRequest        = &apiError{http.StatusForbidden, "AccessDenied", "Request has expired."}
+	errMalformedAuth         = &apiError{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed."}
+	errUnsupportedAuth       = &apiError{http.StatusBadRequest, "InvalidRequest", "Only AWS4-HMAC-SHA256 request signing is supported."}
+	errMissingContentSHA256  = &apiError{http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256."}
+	errContentSHA256Mismatch = &apiError{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed."}
+	errBadDigest             = &apiError{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received."}
+	errInvalidDigest         = &apiError{http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified is not valid."}
+	errIncompleteBody        = &apiError{http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header."}
+	errMissingContentLength  = &apiError{http.StatusLengthRequired, "MissingContentLength", "You must provide the Content-Length HTTP header."}
+	errEntityTooLarge        = &apiError{http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size."}
+	errQuotaExceeded         = &apiError{http.StatusForbidden, "QuotaExceeded", "Your upload would exceed your storage quota."}
+	errNoSuchBucket          = &apiError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist."}
+	errNoSuchKey             = &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	errNoSuchUpload          = &apiError{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist."}
+	errInvalidPart           = &apiError{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found."}
+	errInvalidPartOrder      = &apiError{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order."}
+	errInvalidRange          = &apiError{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable."}
+	errInvalidArgument       = &apiError{http.StatusBadRequest, "InvalidArgument", "Invalid argument."}
+	errInvalidKey            = &apiError{http.StatusBadRequest, "InvalidArgument", "Object keys may not contain empty, '.' or '..' segments."}
+	errKeyConflict           = &apiError{http.StatusConflict, "OperationAborted", "The key conflicts with an existing folder or file."}
+	errMalformedXML          = &apiError{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema."}
+	errBucketOwned           = &apiError{http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it."}
+	errMethodNotAllowed      = &apiError{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource."}
+	errNotImplemented        = &apiError{http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented."}
+	errCreateBucket          = &apiError{http.StatusNotImplemented, "NotImplemented", "Buckets are Nimbus boxes; create one with 'nim mkbox'."}
+	errInternal              = &apiError{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."}
+)
+
+// errorResponse is the body of an S3 error.
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestID string `xml:"RequestId"`
+}
+
+// writeError sends e as an S3 error document. HEAD responses carry no body,
+// so clients only see the status there.
+func writeError(w http.ResponseWriter, r *http.Request, e *apiError, requestID string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.status)
+		return
+	}
+	writeXML(w, e.status, errorResponse{
+		Code:      e.code,
+		Message:   e.message,
+		Resource:  r.URL.Path,
+		RequestID: requestID,
+	})
+}
+
+// writeXML encodes v as the response body with the XML declaration S3 uses.
+func writeXML(w http.ResponseWriter, status int, v any) {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		slog.Error("S3 gateway XML encode failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(out)
+}
+
+// s3Time formats t the way S3 does inside XML documents.
+func s3Time(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+type owner struct {
+	ID          string
+	DisplayName string
+}
+
+type bucketEntry struct {
+	Name         string
+	CreationDate string
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type locationResult struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Value   string   `xml:",chardata"`
+}
+
+type objectEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+// listObjectsResult serves both ListObjects (V1 fields) and ListObjectsV2
+// (V2 fields); empty optional fields are omitted so each version only sees
+// its own.
+type listObjectsResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Marker                *string        `xml:"Marker,omitempty"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	KeyCount              *int           `xml:"KeyCount,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []objectEntry  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadID string `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int32
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+type deleteRequest struct {
+	Quiet   bool
+	Objects []struct {
+		Key string
+	} `xml:"Object"`
+}
+
+type deletedEntry struct {
+	Key string
+}
+
+type deleteErrorEntry struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+type deleteResult struct {
+	XMLName xml.Name           `xml:"DeleteResult"`
+	Xmlns   string             `xml:"xmlns,attr"`
+	Deleted []deletedEntry     `xml:"Deleted"`
+	Errors  []deleteErrorEntry `xml:"Error"`
+}
@@ -0,0 +1,81 @@
+package sftpd
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"golang.org/x/crypto/ssh"
+)
+
+// errRateLimited refuses a password attempt over the login rate limit.
+var errRateLimited = errors.New("too many attempts, try again later")
+
+// sshKeyTouchInterval limits how often an SSH key's LastUsedAt is written.
+const sshKeyTouchInterval = time.Minute
+
+// passwordCallback accepts the account email as the SSH username and either
+// an app password or the account password (the latter only without
+// two-factor authentication; see jwt.AuthenticatePassword).
+func (s *Server) passwordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	// Throttle before the password hash is compared: each comparison costs
+	// an Argon2id run, so unlimited attempts would be both guessing and a
+	// cheap way to exhaust memory.
+	if !s.limiter.Allow(ratelimit.IPAndEmail(remoteIP(conn.RemoteAddr()), conn.User())...) {
+		slog.Warn("SFTP password auth rate limited", "component", "sftp", "email", conn.User(), "ip", conn.RemoteAddr().String())
+		s.recordAuthFailure(conn, "password (rate limited)")
+		return nil, errRateLimited
+	}
+	user, err := jwt.AuthenticatePassword(s.db, conn.User(), string(password))
+	if err != nil {
+		slog.Warn("SFTP password auth failed", "component", "sftp", "email", conn.User(), "ip", conn.RemoteAddr().String())
+		s.recordAuthFailure(conn, "password")
+		return nil, err
+	}
+	return permissions(user), nil
+}
+
+// publicKeyCallback accepts a key the user has registered with POST
+// /v1/api/auth/ssh-keys. The SSH username must still be the account email so
+// a key can't be used to log in under a different name.
+func (s *Server) publicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	var sk models.SSHKey
+	if err := s.db.Where("fingerprint = ?", ssh.FingerprintSHA256(key)).First(&sk).Error; err != nil {
+		return nil, jwt.ErrInvalidCredentials
+	}
+	var user models.User
+	if err := s.db.First(&user, sk.UserID).Error; err != nil || user.Email != conn.User() || user.Suspended() {
+		slog.Warn("SFTP key auth failed", "component", "sftp", "email", conn.User(), "ip", conn.RemoteAddr().String())
+		s.recordAuthFailure(conn, "public key")
+		return nil, jwt.ErrInvalidCredentials
+	}
+
+	// The callback also runs for keys the client only offers without proving
+	// possession, so LastUsedAt can be touched by an offer alone. It is a
+	// usage hint, not an audit record.
+	if sk.LastUsedAt == nil || time.Since(*sk.LastUsedAt) > sshKeyTouchInterval {
+		s.db.Model(&sk).UpdateColumn("last_used_at", time.Now())
+	}
+	return permissions(&user), nil
+}
+
+// recordAuthFailure audits a rejected login. Keys the server doesn't know at
+// all aren't recorded: clients offer every key they have, so those are not
+// attempts on an account.
+func (s *Server) recordAuthFailure(conn ssh.ConnMetadata, method string) {
+	audit.Write(s.db, &models.AuditEvent{
+		Actor: conn.User(), Action: "auth.login", Protocol: audit.ProtocolSFTP, Result: audit.ResultDenied,
+		IP: remoteIP(conn.RemoteAddr()), UserAgent: string(conn.ClientVersion()), Detail: method,
+	})
+}
+
+func permissions(user *models.User) *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{userIDExtension: strconv.FormatUint(uint64(user.ID), 10)},
+	}
+}
@@ -0,0 +1,252 @@
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+)
+
+// readWindow is how far a read may land before or after the current stream
+// position and still be served from it. pkg/sftp hands reads and writes to
+// several workers, so requests for consecutive chunks arrive slightly out of
+// order; the window absorbs that without reopening the S3 object.
+const readWindow = 1 << 20
+
+// maxPendingWrite bounds the out-of-order write data held in memory while an
+// earlier chunk is still in flight.
+const maxPendingWrite = 8 << 20
+
+var errNonSequentialWrite = errors.New("writes must be sequential: random-access writes are not supported")
+
+// readFile serves ReadAt from a single ranged S3 stream, keeping the last
+// readWindow bytes so slightly reordered requests don't restart it. A seek
+// outside the window reopens the stream at the new offset.
+type readFile struct {
+	ctx   context.Context
+	store *storage.Store
+	file  *models.File
+
+	mu   sync.Mutex
+	body io.ReadCloser
+	pos  int64  // offset of the next byte body will return
+	buf  []byte // the bytes just before pos
+}
+
+func (f *readFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if off >= f.file.Size {
+		return 0, io.EOF
+	}
+	start := f.pos - int64(len(f.buf))
+	if f.body == nil || off < start || off > f.pos+readWindow {
+		if err := f.reopen(off); err != nil {
+			return 0, err
+		}
+		start = off
+	}
+
+	end := min(off+int64(len(p)), f.file.Size)
+	if end > f.pos {
+		chunk := make([]byte, end-f.pos)
+		n, err := io.ReadFull(f.body, chunk)
+		f.buf = append(f.buf, chunk[:n]...)
+		f.pos += int64(n)
+		if err != nil {
+			// The object is shorter than recorded or the stream broke; drop it
+			// so the next read starts over.
+			f.closeBody()
+			if n == 0 || f.pos <= off {
+				return 0, io.ErrUnexpectedEOF
+			}
+		}
+	}
+
+	n := copy(p, f.buf[off-start:])
+	if excess := len(f.buf) - readWindow; excess > 0 {
+		f.buf = append(f.buf[:0], f.buf[excess:]...)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *readFile) reopen(off int64) error {
+	f.closeBody()
+	body, err := f.store.Open(f.ctx, f.file, off, -1)
+	if err != nil {
+		return err
+	}
+	f.body = body
+	f.pos = off
+	f.buf = f.buf[:0]
+	return nil
+}
+
+func (f *readFile) closeBody() {
+	if f.body != nil {
+		_ = f.body.Close()
+		f.body = nil
+	}
+}
+
+// Close implements io.Closer; pkg/sftp calls it when the handle is closed.
+func (f *readFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closeBody()
+	f.buf = nil
+	return nil
+}
+
+// writeFile streams WriteAt calls into storage.Put. Chunks that arrive ahead
+// of the next expected offset are held until the gap is filled; the upload is
+// committed on Close, so readers keep seeing the old file until the new one is
+// complete. A handle closed without any writes creates an empty file, unless it
+// was opened without O_TRUNC on an existing file (keep), which is left alone.
+type writeFile struct {
+	ctx   context.Context
+	store *storage.Store
+	box   *models.Box
+	path  string
+	keep  bool
+
+	// onClose is told the outcome of the upload when the handle is closed.
+	onClose func(error)
+
+	mu           sync.Mutex
+	off          int64 // next offset to feed into the upload
+	pending      map[int64][]byte
+	pendingBytes int64
+	pw           *io.PipeWriter
+	result       chan error
+	err          error
+}
+
+func (f *writeFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return 0, f.err
+	}
+	if off < f.off {
+		f.fail(errNonSequentialWrite)
+		return 0, f.err
+	}
+	if f.pw == nil {
+		f.start()
+	}
+	if off > f.off {
+		if f.pendingBytes+int64(len(p)) > maxPendingWrite {
+			f.fail(errNonSequentialWrite)
+			return 0, f.err
+		}
+		if f.pending == nil {
+			f.pending = make(map[int64][]byte)
+		}
+		f.pending[off] = append([]byte(nil), p...)
+		f.pendingBytes += int64(len(p))
+		return len(p), nil
+	}
+
+	if err := f.feed(p); err != nil {
+		return 0, err
+	}
+	for {
+		next, ok := f.pending[f.off]
+		if !ok {
+			break
+		}
+		delete(f.pending, f.off)
+		f.pendingBytes -= int64(len(next))
+		if err := f.feed(next); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start begins the upload; it reads from a pipe that WriteAt feeds in order.
+func (f *writeFile) start() {
+	pr, pw := io.Pipe()
+	f.pw = pw
+	f.result = make(chan error, 1)
+	go func() {
+		_, err := f.store.Put(f.ctx, f.box, f.path, pr, -1)
+		_ = pr.CloseWithError(err)
+		f.result <- err
+	}()
+}
+
+func (f *writeFile) feed(p []byte) error {
+	if _, err := f.pw.Write(p); err != nil {
+		f.fail(err)
+		return f.err
+	}
+	f.off += int64(len(p))
+	return nil
+}
+
+// fail records err and aborts the upload so nothing gets committed.
+func (f *writeFile) fail(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+	if f.pw != nil {
+		_ = f.pw.CloseWithError(f.err)
+	}
+}
+
+// TransferError implements sftp.TransferError. It is called when the
+// connection drops with the handle still open, and aborts the upload.
+func (f *writeFile) TransferError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fail(err)
+}
+
+// Close implements io.Closer. It finishes the upload and reports whether the
+// file was stored.
+func (f *writeFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.pw == nil {
+		if f.err != nil {
+			f.report()
+		}
+		if f.err != nil || f.keep {
+			return f.err
+		}
+		f.start()
+	}
+	if len(f.pending) > 0 {
+		// A chunk never arrived, so the file would have a hole in it.
+		f.fail(io.ErrUnexpectedEOF)
+	}
+	if f.err == nil {
+		_ = f.pw.Close()
+	}
+	err := <-f.result
+	f.result <- err // Close may be called again after a TransferError
+	if f.err == nil {
+		f.err = toSFTPError(err)
+	}
+	f.report()
+	return f.err
+}
+
+// report passes the upload's outcome to onClose, once.
+func (f *writeFile) report() {
+	if f.onClose != nil {
+		f.onClose(f.err)
+		f.onClose = nil
+	}
+}
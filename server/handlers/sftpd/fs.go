@@ -0,0 +1,323 @@
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+	"github.com/pkg/sftp"
+)
+
+// handlers implements the pkg/sftp request handlers over one user's boxes.
+// Unlike the WebDAV file system it keeps no lookup cache: SFTP sessions are
+// long-lived and another client may change the tree at any time.
+type handlers struct {
+	ctx   context.Context
+	store *storage.Store
+	user  *models.User
+	ip    string // client address, for the audit log
+}
+
+func newHandlers(ctx context.Context, store *storage.Store, user *models.User, ip string) sftp.Handlers {
+	h := &handlers{ctx: ctx, store: store, user: user, ip: ip}
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+// record writes an audit event for action on the SFTP path name.
+func (h *handlers) record(action, name string, err error, detail string) {
+	e := &models.AuditEvent{
+		UserID: h.user.ID, Actor: h.user.Email, Action: action, Protocol: audit.ProtocolSFTP,
+		Result: audit.ResultSuccess, IP: h.ip, Detail: detail,
+	}
+	if cleaned, cerr := storage.CleanPath(name); cerr == nil {
+		e.Box, e.Path, _ = strings.Cut(cleaned, "/")
+	} else {
+		e.Path = name
+	}
+	if err != nil {
+		e.Result = audit.ResultFailure
+		if errors.Is(err, sftp.ErrSSHFxPermissionDenied) {
+			e.Result = audit.ResultDenied
+		}
+		if e.Detail == "" {
+			e.Detail = err.Error()
+		}
+	}
+	audit.Write(h.store.DB, e)
+}
+
+// fileCmdActions names the audit event for each Filecmd method. Setstat
+// changes nothing and isn't recorded.
+var fileCmdActions = map[string]string{
+	"Mkdir":  "folder.create",
+	"Rmdir":  "folder.delete",
+	"Remove": "file.delete",
+	"Rename": "file.move",
+}
+
+// split separates an SFTP path into its box name and the path inside the box.
+func split(name string) (box, rest string, err error) {
+	cleaned, err := storage.CleanPath(name)
+	if err != nil {
+		return "", "", sftp.ErrSSHFxPermissionDenied
+	}
+	box, rest, _ = strings.Cut(cleaned, "/")
+	return box, rest, nil
+}
+
+// resolve looks up name. The root "/" has a nil box and a directory entry.
+func (h *handlers) resolve(name string) (*models.Box, *storage.Entry, error) {
+	boxName, rest, err := split(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if boxName == "" {
+		return nil, &storage.Entry{Name: "/", IsDir: true}, nil
+	}
+	b, err := h.store.Box(h.user.ID, boxName)
+	if err != nil {
+		return nil, nil, toSFTPError(err)
+	}
+	e, err := h.store.Stat(b, rest)
+	if err != nil {
+		return nil, nil, toSFTPError(err)
+	}
+	return b, e, nil
+}
+
+// boxPath resolves the box of name and returns it with the path inside it.
+// The root and the boxes themselves can't be written to over SFTP; boxes are
+// created and deleted with "nim mkbox" and "nim rmbox".
+func (h *handlers) boxPath(name string) (*models.Box, string, error) {
+	boxName, rest, err := split(name)
+	if err != nil {
+		return nil, "", err
+	}
+	if rest == "" {
+		return nil, "", sftp.ErrSSHFxPermissionDenied
+	}
+	b, err := h.store.Box(h.user.ID, boxName)
+	if err != nil {
+		return nil, "", toSFTPError(err)
+	}
+	return b, rest, nil
+}
+
+// Fileread implements sftp.FileReader.
+func (h *handlers) Fileread(r *sftp.Request) (_ io.ReaderAt, err error) {
+	defer func() { h.record("file.download", r.Filepath, err, "") }()
+	_, e, err := h.resolve(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	if e.IsDir {
+		return nil, sftp.ErrSSHFxFailure
+	}
+	return &readFile{ctx: h.ctx, store: h.store, file: e.File}, nil
+}
+
+// Filewrite implements sftp.FileWriter. The whole file is replaced on close;
+// appending and writing into the middle of an existing file aren't possible
+// because objects are stored whole.
+func (h *handlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	flags := r.Pflags()
+	if flags.Append {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	b, p, err := h.boxPath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	// Fail at open rather than after the upload when the file has nowhere to go.
+	if parent, err := h.store.Stat(b, path.Dir(p)); err != nil {
+		return nil, toSFTPError(err)
+	} else if !parent.IsDir {
+		return nil, os.ErrNotExist
+	}
+	existing, err := h.store.Stat(b, p)
+	switch {
+	case err == nil && existing.IsDir:
+		return nil, sftp.ErrSSHFxFailure
+	case err == nil && flags.Excl:
+		return nil, os.ErrExist
+	case err != nil && !errors.Is(err, storage.ErrNotFound):
+		return nil, toSFTPError(err)
+	}
+	return &writeFile{
+		ctx: h.ctx, store: h.store, box: b, path: p, keep: err == nil && !flags.Trunc,
+		onClose: func(err error) { h.record("file.upload", r.Filepath, err, "") },
+	}, nil
+}
+
+// Filecmd implements sftp.FileCmder.
+func (h *handlers) Filecmd(r *sftp.Request) error {
+	err := h.filecmd(r)
+	if action := fileCmdActions[r.Method]; action != "" {
+		var detail string
+		if r.Method == "Rename" {
+			detail = "target: " + r.Target
+		}
+		h.record(action, r.Filepath, err, detail)
+	}
+	return err
+}
+
+func (h *handlers) filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Mkdir":
+		b, p, err := h.boxPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		_, err = h.store.Mkdir(h.ctx, b, p)
+		return toSFTPError(err)
+
+	case "Rmdir", "Remove":
+		b, p, err := h.boxPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		e, err := h.store.Stat(b, p)
+		if err != nil {
+			return toSFTPError(err)
+		}
+		// Keep POSIX semantics: rm only removes files, rmdir only empty folders.
+		if r.Method == "Remove" && e.IsDir {
+			return sftp.ErrSSHFxFailure
+		}
+		if r.Method == "Rmdir" {
+			if !e.IsDir {
+				return sftp.ErrSSHFxFailure
+			}
+			children, err := h.store.ReadDir(b, p)
+			if err != nil {
+				return toSFTPError(err)
+			}
+			if len(children) > 0 {
+				return errors.New("directory not empty")
+			}
+		}
+		return toSFTPError(h.store.Remove(h.ctx, b, p))
+
+	case "Rename":
+		src, srcPath, err := h.boxPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		dst, dstPath, err := h.boxPath(r.Target)
+		if err != nil {
+			return err
+		}
+		return toSFTPError(h.store.Move(h.ctx, src, srcPath, dst, dstPath))
+
+	case "Setstat":
+		// Clients set times and permissions after an upload; there is nowhere
+		// to keep them, so those are accepted and ignored. Truncation would
+		// silently change data, so it is refused.
+		if r.AttrFlags().Size {
+			return sftp.ErrSSHFxOpUnsupported
+		}
+		_, _, err := h.resolve(r.Filepath)
+		return err
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// Filelist implements sftp.FileLister.
+func (h *handlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		b, e, err := h.resolve(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsDir {
+			return nil, sftp.ErrSSHFxFailure
+		}
+		if b == nil {
+			return h.listBoxes()
+		}
+		entries, err := h.store.ReadDir(b, e.Path)
+		if err != nil {
+			return nil, toSFTPError(err)
+		}
+		infos := make(listerAt, len(entries))
+		for i := range entries {
+			infos[i] = fileInfo{&entries[i]}
+		}
+		return infos, nil
+
+	case "Stat":
+		_, e, err := h.resolve(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{fileInfo{e}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// listBoxes lists the user's boxes as the entries of "/".
+func (h *handlers) listBoxes() (sftp.ListerAt, error) {
+	boxes, err := h.store.Boxes(h.user.ID)
+	if err != nil {
+		return nil, err
+	}
+	infos := make(listerAt, len(boxes))
+	for i, b := range boxes {
+		infos[i] = fileInfo{&storage.Entry{Name: b.Name, IsDir: true, ModTime: b.UpdatedAt}}
+	}
+	return infos, nil
+}
+
+// toSFTPError maps storage errors onto SFTP status codes. Anything else is
+// sent as a generic failure carrying the error text.
+func toSFTPError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, storage.ErrNotDir):
+		return os.ErrNotExist
+	case errors.Is(err, storage.ErrInvalidPath), errors.Is(err, storage.ErrCrossUserMove),
+		errors.Is(err, storage.ErrQuotaExceeded):
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return err
+}
+
+// listerAt serves a fixed slice of entries to sftp.ListerAt callers.
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fileInfo adapts a storage.Entry to os.FileInfo.
+type fileInfo struct{ e *storage.Entry }
+
+func (fi fileInfo) Name() string       { return fi.e.Name }
+func (fi fileInfo) Size() int64        { return fi.e.Size }
+func (fi fileInfo) ModTime() time.Time { return fi.e.ModTime }
+func (fi fileInfo) IsDir() bool        { return fi.e.IsDir }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.e.IsDir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
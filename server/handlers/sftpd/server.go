@@ -0,0 +1,308 @@
+// Package sftpd serves boxes over SFTP for partners and legacy integrations
+// that can only push files with an SSH client. Each of the user's boxes is a
+// top-level directory ("/<box>/<path>"); reads, writes, renames, mkdir and rm
+// all go through the storage package, so they touch the same Box, Folder and
+// File records and S3 objects as the REST API and WebDAV.
+//
+// The SSH transport comes from golang.org/x/crypto/ssh and the SFTP protocol
+// from github.com/pkg/sftp; this package only handles authentication and
+// supplies the request handlers. Only the "sftp" subsystem is offered: there
+// is no shell, exec or port forwarding.
+package sftpd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// ErrServerClosed is returned by Serve after Shutdown or Close.
+var ErrServerClosed = errors.New("sftp: server closed")
+
+// handshakeTimeout bounds the SSH handshake, including authentication, so a
+// client that connects and goes quiet doesn't hold a goroutine forever.
+const handshakeTimeout = 30 * time.Second
+
+// userIDExtension carries the authenticated user's ID from the auth callbacks
+// to the connection handler.
+const userIDExtension = "nimbus-user-id"
+
+// Server accepts SSH connections and serves the SFTP subsystem on them.
+// Create one with New, then call Serve with a listener.
+type Server struct {
+	db      *gorm.DB
+	store   *storage.Store
+	config  *ssh.ServerConfig
+	limiter *ratelimit.Limiter
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a Server backed by db and the given S3 configuration. Password
+// attempts are throttled by limiter, which should be the one guarding /login
+// so both protocols share a budget. At least one host key is required; see
+// LoadHostKeys and GenerateHostKey.
+func New(config s3db.Config, db *gorm.DB, limiter *ratelimit.Limiter, hostKeys ...ssh.Signer) *Server {
+	s := &Server{
+		db:        db,
+		store:     storage.New(db, config),
+		limiter:   limiter,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback:  s.passwordCallback,
+		PublicKeyCallback: s.publicKeyCallback,
+		ServerVersion:     "SSH-2.0-Nimbus",
+	}
+	for _, key := range hostKeys {
+		s.config.AddHostKey(key)
+	}
+	return s
+}
+
+// LoadHostKeys reads PEM-encoded private host keys (as written by ssh-keygen)
+// from the given files.
+func LoadHostKeys(paths ...string) ([]ssh.Signer, error) {
+	keys := make([]ssh.Signer, 0, len(paths))
+	for _, p := range paths {
+		pem, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SFTP host key: %w", err)
+		}
+		key, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SFTP host key %s: %w", p, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// GenerateHostKey returns a fresh ed25519 host key. It changes on every
+// restart, so clients will warn about it; it is only meant for local
+// development and tests.
+func GenerateHostKey() (ssh.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(priv)
+}
+
+// Serve accepts connections on ln until it fails or the server is shut down,
+// in which case it returns ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, ln)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nc) {
+			_ = nc.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(nc)
+			s.handleConn(nc)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and waits for open sessions to end
+// until ctx expires, then closes whatever is left.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		_ = ln.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		_ = s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close stops the listeners and drops every open connection immediately.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for ln := range s.listeners {
+		_ = ln.Close()
+	}
+	for nc := range s.conns {
+		_ = nc.Close()
+	}
+	return nil
+}
+
+func (s *Server) track(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[nc] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(nc net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, nc)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// handleConn runs the SSH handshake on nc and serves its session channels.
+func (s *Server) handleConn(nc net.Conn) {
+	defer nc.Close()
+
+	_ = nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	sconn, chans, reqs, err := ssh.NewServerConn(nc, s.config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	_ = nc.SetDeadline(time.Time{})
+
+	user, err := s.connUser(sconn)
+	if err != nil {
+		slog.Error("Failed to load SFTP user", "component", "sftp", "email", sconn.User(), "error", err)
+		return
+	}
+	slog.Info("SFTP connected", "component", "sftp", "user_id", user.ID, "ip", sconn.RemoteAddr().String())
+	ip := remoteIP(sconn.RemoteAddr())
+	audit.Write(s.db, &models.AuditEvent{
+		UserID: user.ID, Actor: user.Email, Action: "auth.login", Protocol: audit.ProtocolSFTP,
+		Result: audit.ResultSuccess, IP: ip, UserAgent: string(sconn.ClientVersion()),
+	})
+
+	go ssh.DiscardRequests(reqs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "only sftp sessions are supported")
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(ctx, user, ip, ch, chReqs)
+	}
+	slog.Info("SFTP disconnected", "component", "sftp", "user_id", user.ID)
+}
+
+// session waits for the client to ask for the sftp subsystem and serves it
+// until the client closes the channel. Any other request (shell, exec, pty)
+// is refused.
+func (s *Server) session(ctx context.Context, user *models.User, ip string, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	started := false
+	for req := range reqs {
+		ok := !started && req.Type == "subsystem" && subsystemName(req.Payload) == "sftp"
+		_ = req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+		started = true
+		go ssh.DiscardRequests(reqs)
+
+		rs := sftp.NewRequestServer(ch, newHandlers(ctx, s.store, user, ip))
+		if err := rs.Serve(); err != nil && !errors.Is(err, os.ErrClosed) {
+			slog.Warn("SFTP session ended with error", "component", "sftp", "user_id", user.ID, "error", err)
+		}
+		_ = rs.Close()
+		return
+	}
+}
+
+// subsystemName decodes the SSH string in a "subsystem" request payload.
+func subsystemName(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	n := binary.BigEndian.Uint32(payload)
+	if uint64(n) > uint64(len(payload)-4) {
+		return ""
+	}
+	return string(payload[4 : 4+n])
+}
+
+// connUser loads the user the auth callbacks accepted.
+func (s *Server) connUser(sconn *ssh.ServerConn) (*models.User, error) {
+	if sconn.Permissions == nil {
+		return nil, errors.New("connection has no permissions")
+	}
+	id, err := strconv.ParseUint(sconn.Permissions.Extensions[userIDExtension], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := s.db.First(&user, uint(id)).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// remoteIP returns the host part of a connection's remote address.
+func remoteIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
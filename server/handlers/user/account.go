@@ -0,0 +1,253 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+// REAUTH_WINDOW is how recently an account without a password (single
+// sign-on only) must have signed in to change or delete the account.
+// ACCOUNT_PURGE_TIMEOUT bounds the background deletion of a closed account.
+const (
+	REAUTH_WINDOW         = 5 * time.Minute
+	ACCOUNT_PURGE_TIMEOUT = 30 * time.Minute
+)
+
+// ChangePasswordRequest is the JSON body expected by POST /account/password.
+// CurrentPassword is empty for accounts that only use single sign-on, which
+// this gives a password. Code is required when two-factor authentication is
+// on.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	Code            string `json:"code"`
+}
+
+// ChangeEmailRequest is the JSON body expected by POST /account/email.
+type ChangeEmailRequest struct {
+	Password string `json:"password"`
+	NewEmail string `json:"new_email"`
+	Code     string `json:"code"`
+}
+
+// DeleteAccountRequest is the JSON body expected by DELETE /account.
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// reauthenticate checks that the person making a sensitive change is the
+// account holder and not just someone holding a token: the password (or, for
+// accounts without one, a sign-in within REAUTH_WINDOW) and a two-factor code
+// when enabled. It writes the error response and returns false on failure.
+func reauthenticate(c *gin.Context, db *gorm.DB, user *models.User, password, code string) bool {
+	if user.Password != "" {
+		if password == "" || len(password) > MAX_PASSWORD_LENGTH || !utils.VerifyPasswordHash(password, user.Password) {
+			slog.WarnContext(c.Request.Context(), "Re-authentication failed, bad password", "ip", c.ClientIP())
+			apierr.Respond(c, apierr.InvalidCredentials, "invalid password")
+			return false
+		}
+	} else {
+		var n int64
+		db.Model(&models.Session{}).
+			Where("user_id = ? AND session_id = ? AND created_at > ?", user.ID, jwt.SessionID(c), time.Now().Add(-REAUTH_WINDOW)).
+			Count(&n)
+		if n == 0 {
+			apierr.Respond(c, apierr.Unauthenticated, "sign in again with single sign-on, then retry within 5 minutes")
+			return false
+		}
+	}
+	if user.TOTPEnabled && !verifySecondFactor(db, user, code) {
+		apierr.Respond(c, apierr.TwoFactorRequired, "a current two-factor code is required")
+		return false
+	}
+	return true
+}
+
+// renewLogin starts a new session for user on device and returns its tokens.
+func renewLogin(c *gin.Context, db *gorm.DB, user *models.User, device string) (string, string, error) {
+	session, err := startSession(c, db, user, device)
+	if err != nil {
+		return "", "", err
+	}
+	return issueTokens(db, user, session)
+}
+
+// GetAccount returns the authenticated user's account details.
+func GetAccount(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var issuers []string
+	if err := db.Model(&models.Identity{}).Where("user_id = ?", user.ID).Pluck("issuer", &issuers).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to load account")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":      user.ID,
+		"email":        user.Email,
+		"created_at":   user.CreatedAt,
+		"has_password": user.Password != "",
+		"totp_enabled": user.TOTPEnabled,
+		"sso_issuers":  issuers,
+	})
+}
+
+// ChangePassword replaces the authenticated user's password. Every other
+// session is signed out; the one making the request stays signed in.
+func ChangePassword(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.NewPassword == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "new password is required")
+		return
+	}
+	if len(req.NewPassword) < MIN_PASSWORD_LENGTH || len(req.NewPassword) > MAX_PASSWORD_LENGTH {
+		apierr.Respond(c, apierr.InvalidRequest, passwordLengthMessage)
+		return
+	}
+	minLength, number, upper, lower, special := isValidPassword(req.NewPassword)
+	if !minLength || !number || !upper || !lower || !special {
+		apierr.Respond(c, apierr.InvalidRequest, "Password must be at least 8 characters and include at least one number, one uppercase letter, one lowercase letter, and one special character")
+		return
+	}
+	if !reauthenticate(c, db, user, req.CurrentPassword, req.Code) {
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		apierr.Respond(c, apierr.InvalidRequest, "New password must be different from the current password")
+		return
+	}
+
+	hashedPassword, err := utils.PasswordHash(req.NewPassword)
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to change password")
+		return
+	}
+	if err := db.Model(user).Updates(map[string]any{"password": hashedPassword, "password_reset_required": false}).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to change password")
+		return
+	}
+	jwt.ForgetUser(user.ID)
+
+	n, err := jwt.RevokeAllSessions(c.Request.Context(), db, user.ID, jwt.SessionID(c))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to revoke sessions after password change", "error", err)
+	}
+	slog.InfoContext(c.Request.Context(), "Password changed", "ip", c.ClientIP())
+	c.JSON(http.StatusOK, gin.H{"message": "password changed", "sessions_revoked": n})
+}
+
+// ChangeEmail moves the authenticated user to a new email address. Access
+// tokens carry the email, so every session is signed out; a request made
+// with a login session gets tokens for a new one in the response.
+func ChangeEmail(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.NewEmail == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "new email is required")
+		return
+	}
+	if len(req.NewEmail) > MAX_EMAIL_LENGTH || !isEmailValid(req.NewEmail) {
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid email format")
+		return
+	}
+	if req.NewEmail == user.Email {
+		apierr.Respond(c, apierr.InvalidRequest, "that is already your email")
+		return
+	}
+	if !reauthenticate(c, db, user, req.Password, req.Code) {
+		return
+	}
+
+	// Closed accounts keep their email until they are purged.
+	var taken int64
+	db.Unscoped().Model(&models.User{}).Where("email = ?", req.NewEmail).Count(&taken)
+	if taken > 0 {
+		apierr.Respond(c, apierr.AlreadyExists, "email is already in use")
+		return
+	}
+	if err := db.Model(user).Update("email", req.NewEmail).Error; err != nil {
+		apierr.Respond(c, apierr.AlreadyExists, "email is already in use")
+		return
+	}
+	jwt.ForgetUser(user.ID)
+
+	var device string
+	sessionID := jwt.SessionID(c)
+	if sessionID != "" {
+		var current models.Session
+		db.Where("session_id = ?", sessionID).First(&current)
+		device = current.Device
+	}
+	if _, err := jwt.RevokeAllSessions(c.Request.Context(), db, user.ID, ""); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to revoke sessions after email change", "error", err)
+	}
+	slog.InfoContext(c.Request.Context(), "Email changed", "ip", c.ClientIP())
+
+	resp := gin.H{"message": "email changed", "email": user.Email}
+	if sessionID != "" {
+		// If this fails the change still stands; the client logs in again.
+		if token, refreshToken, err := renewLogin(c, db, user, device); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to issue tokens after email change", "error", err)
+		} else {
+			resp["token"] = token
+			resp["refresh_token"] = refreshToken
+			resp["expires_in"] = int(jwt.ACCESS_TOKEN_TTL.Seconds())
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteAccount closes the authenticated user's account. The account stops
+// working at once — it is soft-deleted and every session is revoked — and
+// its boxes, files, S3 objects and credentials are removed in the background
+// (see storage.DeleteUser). A deletion cut short is finished at the next
+// server start.
+func DeleteAccount(c *gin.Context, db *gorm.DB, config s3db.Config) {
+	user := jwt.CurrentUser(c)
+
+	var req DeleteAccountRequest
+	_ = c.ShouldBindJSON(&req)
+	if !reauthenticate(c, db, user, req.Password, req.Code) {
+		return
+	}
+
+	if _, err := jwt.RevokeAllSessions(c.Request.Context(), db, user.ID, ""); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to revoke sessions before deletion", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to delete account")
+		return
+	}
+	if err := db.Delete(user).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to delete account")
+		return
+	}
+	jwt.ForgetUser(user.ID)
+	slog.InfoContext(c.Request.Context(), "Account closed", "ip", c.ClientIP())
+
+	// The purge outlives the request, so it keeps the request's log and trace
+	// attributes but not its cancellation.
+	go func(userID uint) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), ACCOUNT_PURGE_TIMEOUT)
+		defer cancel()
+		if err := storage.New(db.WithContext(ctx), config).DeleteUser(ctx, userID); err != nil {
+			slog.ErrorContext(ctx, "Account purge failed, will retry at next start", "error", err)
+			return
+		}
+		slog.InfoContext(ctx, "Account purged")
+	}(user.ID)
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "account deleted; your files are being removed"})
+}
@@ -0,0 +1,114 @@
+package user
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	MAX_APP_PASSWORD_NAME = 64
+	MAX_APP_PASSWORDS     = 25
+)
+
+// CreateAppPasswordRequest is the JSON body expected by POST /app-passwords.
+type CreateAppPasswordRequest struct {
+	Name string `json:"name"`
+}
+
+// AppPasswordEntry is how an app password is listed. The secret itself is
+// never returned after creation.
+type AppPasswordEntry struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreateAppPassword issues a new app password for the authenticated user. The
+// plain secret is in this response only; the database keeps its SHA-256.
+func CreateAppPassword(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req CreateAppPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "name is required")
+		return
+	}
+	if len(req.Name) > MAX_APP_PASSWORD_NAME {
+		apierr.Respond(c, apierr.InvalidRequest, "name must be at most 64 characters")
+		return
+	}
+
+	var count int64
+	db.Model(&models.AppPassword{}).Where("user_id = ?", user.ID).Count(&count)
+	if count >= MAX_APP_PASSWORDS {
+		apierr.RespondDetails(c, apierr.LimitReached, "app password limit reached, revoke an unused one first", gin.H{"limit": MAX_APP_PASSWORDS})
+		return
+	}
+
+	secret, err := utils.GenerateAppPassword()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to generate app password")
+		return
+	}
+	ap := models.AppPassword{UserID: user.ID, Name: req.Name, Hash: utils.HashToken(secret)}
+	if err := db.Create(&ap).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "App password save failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to save app password")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "App password created", "app_password_id", ap.ID)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "app password created, it will not be shown again",
+		"id":       ap.ID,
+		"name":     ap.Name,
+		"password": secret,
+	})
+}
+
+// ListAppPasswords returns the authenticated user's app passwords, newest first.
+func ListAppPasswords(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var rows []models.AppPassword
+	if err := db.Where("user_id = ?", user.ID).Order("id DESC").Find(&rows).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to list app passwords")
+		return
+	}
+
+	entries := make([]AppPasswordEntry, len(rows))
+	for i, ap := range rows {
+		entries[i] = AppPasswordEntry{ID: ap.ID, Name: ap.Name, CreatedAt: ap.CreatedAt, LastUsedAt: ap.LastUsedAt}
+	}
+	c.JSON(http.StatusOK, gin.H{"app_passwords": entries})
+}
+
+// RevokeAppPassword deletes one of the authenticated user's app passwords.
+// Clients using it are rejected from their next request on.
+func RevokeAppPassword(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	// Hard delete: the unique hash index would otherwise keep a revoked secret's
+	// row around forever for no benefit.
+	result := db.Unscoped().Where("id = ? AND user_id = ?", c.Param("id"), user.ID).Delete(&models.AppPassword{})
+	if result.Error != nil {
+		apierr.Respond(c, apierr.Internal, "failed to revoke app password")
+		return
+	}
+	if result.RowsAffected == 0 {
+		apierr.Respond(c, apierr.NotFound, "app password not found")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "App password revoked", "app_password_id", c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"message": "app password revoked"})
+}
@@ -3,13 +3,15 @@ package user
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"unicode"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/audit"
 	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/models"
 	"github.com/nimbus/api/utils"
@@ -18,23 +20,25 @@ import (
 
 // ── Constants ────────────────────────────────────────────────────────────────
 
-// MAX_PASSWORD_LENGTH is 72 because bcrypt silently truncates passwords longer
-// than 72 bytes — we reject them upfront so users aren't surprised.
+// MAX_PASSWORD_LENGTH leaves room for long passphrases; Argon2id has no
+// length limit of its own, but there's no reason to hash megabytes.
 const (
 	MAX_EMAIL_LENGTH    = 254
-	MAX_PASSWORD_LENGTH = 72
+	MAX_PASSWORD_LENGTH = 1024
 	MIN_PASSWORD_LENGTH = 8
-	PASSKEY_LENGTH      = 4
-
-	dummyHash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
 )
 
+// passwordLengthMessage is the error for a new password outside the length
+// limits, built from them so it can't fall out of step.
+var passwordLengthMessage = fmt.Sprintf("Password must be between %d and %d characters long", MIN_PASSWORD_LENGTH, MAX_PASSWORD_LENGTH)
+
 // ── Types ────────────────────────────────────────────────────────────────────
 
 // LoginRequest is the JSON body expected by the /login endpoint.
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Device   string `json:"device"` // optional label shown in the session list
 }
 
 // RegisterRequest is the JSON body expected by the /register endpoint.
@@ -42,16 +46,16 @@ type LoginRequest struct {
 type RegisterRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
-	PassKey  string `json:"passkey"`
 }
 
 // ResetPasswordRequest is the JSON body expected by the /reset-password endpoint.
-// The passkey (set at registration) acts as the proof-of-identity that authorizes
-// replacing a forgotten password — there is no email/SMS channel in the system.
+// A recovery code (issued at registration) acts as the proof-of-identity that
+// authorizes replacing a forgotten password — there is no email/SMS channel in
+// the system.
 type ResetPasswordRequest struct {
-	Email       string `json:"email"`
-	PassKey     string `json:"passkey"`
-	NewPassword string `json:"new_password"`
+	Email        string `json:"email"`
+	RecoveryCode string `json:"recovery_code"`
+	NewPassword  string `json:"new_password"`
 }
 
 // ── HTML ─────────────────────────────────────────────────────────────────────
@@ -90,6 +94,10 @@ const registerPage = `<!DOCTYPE html>
     .msg { margin-top: 16px; font-size: 0.9rem; text-align: center; min-height: 22px; }
     .msg.err { color: #ff6b6b; }
     .msg.ok  { color: #6bcb77; }
+    pre {
+      background: #12141e; border: 1px solid #2e3148; border-radius: 6px;
+      padding: 14px; margin-bottom: 18px; font-size: 0.95rem; line-height: 1.6; text-align: center;
+    }
   </style>
 </head>
 <body>
@@ -103,11 +111,14 @@ const registerPage = `<!DOCTYPE html>
       <input type="password" name="password" placeholder="Min 8 chars, upper, lower, number, symbol" required />
       <label>Confirm Password</label>
       <input type="password" name="confirm" placeholder="Repeat password" required />
-      <label>Passkey <span style="color:#666">(exactly 4 characters)</span></label>
-      <input type="text" name="passkey" maxlength="4" placeholder="e.g. 1234" required />
       <button type="submit">Create Account</button>
       <div class="msg" id="msg"></div>
     </form>
+    <div id="codes" hidden>
+      <p class="sub">Save these recovery codes. Each one can reset your password (or stand in for your authenticator app) once. They won't be shown again.</p>
+      <pre id="codelist"></pre>
+      <button id="download" type="button">Download codes</button>
+    </div>
   </div>
   <script>
     document.getElementById("form").addEventListener("submit", async e => {
@@ -118,16 +129,13 @@ const registerPage = `<!DOCTYPE html>
       if (data.password !== data.confirm) {
         msg.className = "msg err"; msg.textContent = "Passwords do not match."; return;
       }
-      if (data.passkey.length !== 4) {
-        msg.className = "msg err"; msg.textContent = "Passkey must be exactly 4 characters."; return;
-      }
       msg.className = "msg"; msg.textContent = "Creating account...";
       btn.disabled = true;
       try {
         const res = await fetch("/v1/api/auth/users/register", {
           method: "POST",
           headers: { "Content-Type": "application/json" },
-          body: JSON.stringify({ email: data.email, password: data.password, passkey: data.passkey })
+          body: JSON.stringify({ email: data.email, password: data.password })
         });
         const json = await res.json();
         if (!res.ok) {
@@ -136,7 +144,17 @@ const registerPage = `<!DOCTYPE html>
           btn.disabled = false;
         } else {
           msg.className = "msg ok";
-          msg.textContent = "Account created! You can close this tab and run: nim login";
+          msg.textContent = "Account created! Save your recovery codes, then run: nim login";
+          e.target.hidden = true;
+          const text = json.recovery_codes.join("\n") + "\n";
+          document.getElementById("codelist").textContent = text;
+          document.getElementById("codes").hidden = false;
+          document.getElementById("download").onclick = () => {
+            const a = document.createElement("a");
+            a.href = URL.createObjectURL(new Blob([text], { type: "text/plain" }));
+            a.download = "nimbus-recovery-codes.txt";
+            a.click();
+          };
         }
       } catch {
         msg.className = "msg err";
@@ -179,6 +197,42 @@ func isEmailValid(e string) bool {
 	return emailRegex.MatchString(e)
 }
 
+// newUser builds an unsaved account for email with a random 8-digit ID
+// (retrying on the rare collision) and its default "Home-Box". passwordHash
+// may be empty for accounts that only sign in through single sign-on.
+func newUser(db *gorm.DB, email, passwordHash string) (*models.User, error) {
+	userID, err := utils.GenerateUserID()
+	if err != nil {
+		return nil, err
+	}
+
+	var existingUserByID models.User
+	for {
+		if err := db.First(&existingUserByID, userID).Error; err != nil {
+			break
+		}
+		userID, err = utils.GenerateUserID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	boxID, err := utils.GenerateSecureID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.User{
+		ID:       userID,
+		Email:    email,
+		Password: passwordHash,
+		Boxes: []models.Box{{
+			Name:  "Home-Box",
+			BoxID: boxID,
+		}},
+	}, nil
+}
+
 // ── Handlers ─────────────────────────────────────────────────────────────────
 
 // ServeRegisterPage returns the HTML registration form.
@@ -187,35 +241,39 @@ func ServeRegisterPage(c *gin.Context) {
 	c.String(http.StatusOK, registerPage)
 }
 
-// Login validates credentials and returns a signed JWT on success.
-// If the email doesn't exist we still run bcrypt on a dummy hash so the
+// Login validates credentials and returns a short-lived JWT plus a refresh
+// token (see Refresh) on success. Users with two-factor authentication get a
+// challenge instead, which LoginTOTP exchanges for the tokens.
+// If the email doesn't exist we still verify against a dummy hash so the
 // response time is the same as a real password mismatch — prevents email enumeration.
+// A bcrypt hash (or one with outdated Argon2id parameters) is upgraded once
+// the password has been verified.
 func Login(c *gin.Context, db *gorm.DB) {
 	var user models.User
 	var loginRequest LoginRequest
 
 	if err := c.ShouldBindJSON(&loginRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid request payload")
 		return
 	}
 
 	if loginRequest.Email == "" || loginRequest.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password are required"})
+		apierr.Respond(c, apierr.InvalidRequest, "Email and password are required")
 		return
 	}
 
 	if len(loginRequest.Email) > MAX_EMAIL_LENGTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid input")
 		return
 	}
 
 	if len(loginRequest.Password) > MAX_PASSWORD_LENGTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid input")
 		return
 	}
 
 	if !isEmailValid(loginRequest.Email) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid email format")
 		return
 	}
 
@@ -223,233 +281,266 @@ func Login(c *gin.Context, db *gorm.DB) {
 	var isValid bool
 
 	if err != nil {
-		utils.VerifyPasswordHash(loginRequest.Password, dummyHash)
+		utils.VerifyDummyPassword(loginRequest.Password)
 		isValid = false
+		audit.Actor(c, nil, loginRequest.Email)
 	} else {
 		isValid = utils.VerifyPasswordHash(loginRequest.Password, user.Password)
+		audit.Actor(c, &user, "")
 	}
 
 	if !isValid {
-		log.Printf("Failed login attempt for email: %s from IP: %s", loginRequest.Email, c.ClientIP())
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		slog.WarnContext(c.Request.Context(), "Failed login attempt", "email", loginRequest.Email, "ip", c.ClientIP())
+		apierr.Respond(c, apierr.InvalidCredentials, "Invalid email or password")
+		return
+	}
+
+	if rejectSuspended(c, &user) {
+		return
+	}
+	if user.PasswordResetRequired {
+		apierr.Respond(c, apierr.PasswordResetRequired, "Password reset required: reset it with a recovery code (\"nim login\", then 'r')")
+		return
+	}
+	jwt.UpgradePasswordHash(db, &user, loginRequest.Password)
+
+	if user.TOTPEnabled {
+		startLoginChallenge(c, db, &user, loginRequest.Device)
 		return
 	}
+	completeLogin(c, db, &user, loginRequest.Device)
+}
 
-	token, err := jwt.CreateToken(user.Email, fmt.Sprintf("%d", user.ID))
+// completeLogin starts a session for a user who has passed every login step
+// and responds with their tokens.
+func completeLogin(c *gin.Context, db *gorm.DB, user *models.User, device string) {
+	if rejectSuspended(c, user) {
+		return
+	}
+	session, err := startSession(c, db, user, device)
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to generate token")
+		return
+	}
+	token, refreshToken, err := issueTokens(db, user, session)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		apierr.Respond(c, apierr.Internal, "Failed to generate token")
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "Login successful", "token": token, "user_id": user.ID, "email": user.Email, "box": user.Boxes})
+	resp := gin.H{
+		"message":       "Login successful",
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(jwt.ACCESS_TOKEN_TTL.Seconds()),
+		"user_id":       user.ID,
+		"email":         user.Email,
+		"box":           user.Boxes,
+	}
+	if codes, err := issueFirstRecoveryCodes(db, user); err != nil {
+		// The login itself succeeded; the codes are offered again next time.
+		slog.ErrorContext(c.Request.Context(), "Failed to issue first recovery codes", "user_id", user.ID, "error", err)
+	} else if codes != nil {
+		resp["recovery_codes"] = codes
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// rejectSuspended answers 403 and returns true if an admin has suspended
+// user's account, which can't start a session until it is unsuspended.
+func rejectSuspended(c *gin.Context, user *models.User) bool {
+	if !user.Suspended() {
+		return false
+	}
+	slog.WarnContext(c.Request.Context(), "Login attempt for suspended account", "user_id", user.ID, "ip", c.ClientIP())
+	apierr.Respond(c, apierr.AccountSuspended, jwt.ErrAccountSuspended.Error())
+	return true
 }
 
 // Register creates a new user account:
 //  1. Validate and sanitize all input fields
 //  2. Check for duplicate email
-//  3. Hash the password and passkey with bcrypt
+//  3. Hash the password with Argon2id
 //  4. Generate a random 8-digit user ID (retrying on the rare collision)
 //  5. Create the user record along with their default "Home-Box"
+//  6. Issue the recovery codes, returned only in this response
 func Register(c *gin.Context, db *gorm.DB, s3Client *s3.Client) {
 	var req RegisterRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid input")
 		return
 	}
 
-	if req.Email == "" || req.Password == "" || req.PassKey == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email, password, and passkey are required"})
+	if req.Email == "" || req.Password == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "Email and password are required")
 		return
 	}
 
 	if !isEmailValid(req.Email) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid email format")
 		return
 	}
 
 	if len(req.Email) > MAX_EMAIL_LENGTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email exceeds maximum allowed length"})
+		apierr.Respond(c, apierr.InvalidRequest, "Email exceeds maximum allowed length")
 		return
 	}
 
 	if len(req.Password) < MIN_PASSWORD_LENGTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters long"})
+		apierr.Respond(c, apierr.InvalidRequest, fmt.Sprintf("Password must be at least %d characters long", MIN_PASSWORD_LENGTH))
 		return
 	}
 
 	if len(req.Password) > MAX_PASSWORD_LENGTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password exceeds maximum allowed length"})
-		return
-	}
-
-	if len(req.PassKey) != PASSKEY_LENGTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey must be exactly 4 characters long"})
+		apierr.Respond(c, apierr.InvalidRequest, "Password exceeds maximum allowed length")
 		return
 	}
 
 	minLength, number, upper, lower, special := isValidPassword(req.Password)
 	if !minLength || !number || !upper || !lower || !special {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Password must be at least 8 characters and include at least one number, one uppercase letter, one lowercase letter, and one special character",
-		})
+		apierr.Respond(c, apierr.InvalidRequest, "Password must be at least 8 characters and include at least one number, one uppercase letter, one lowercase letter, and one special character")
 		return
 	}
 
 	var existingUser models.User
 	if err := db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		apierr.Respond(c, apierr.AlreadyExists, "User already exists")
 		return
 	}
 
 	hashedPassword, err := utils.PasswordHash(req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process registration"})
-		return
-	}
-
-	hashedPassKey, err := utils.PasswordHash(req.PassKey)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process registration"})
+		apierr.Respond(c, apierr.Internal, "Failed to process registration")
 		return
 	}
 
-	var user models.User
-	user.Email = req.Email
-	user.Password = hashedPassword
-	user.PassKey = hashedPassKey
-
-	userID, err := utils.GenerateUserID()
+	user, err := newUser(db, req.Email, hashedPassword)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process registration"})
+		apierr.Respond(c, apierr.Internal, "Failed to process registration")
 		return
 	}
 
-	var existingUserByID models.User
-	for {
-		if err := db.First(&existingUserByID, userID).Error; err != nil {
-			break
+	var recoveryCodes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
 		}
-		userID, err = utils.GenerateUserID()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process registration"})
-			return
-		}
-	}
-	user.ID = userID
-
-	boxID, err := utils.GenerateSecureID()
+		recoveryCodes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process registration"})
-		return
-	}
-
-	user.Boxes = []models.Box{{
-		Name:  "Home-Box",
-		BoxID: boxID,
-	}}
-
-	if err := db.Create(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
+		apierr.Respond(c, apierr.Internal, "Failed to register user")
 		return
 	}
 
-	if err := db.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user bucket"})
+	if err := db.Save(user).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to update user bucket")
 		return
 	}
 
+	audit.Actor(c, user, "")
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "User registered successfully",
-		"email":   user.Email,
-		"user_id": user.ID,
-		"box":     user.Boxes[0].Name,
+		"message":        "User registered successfully",
+		"email":          user.Email,
+		"user_id":        user.ID,
+		"box":            user.Boxes[0].Name,
+		"recovery_codes": recoveryCodes,
 	})
 }
 
-// ResetPassword lets a user replace a forgotten password by proving they know
-// their passkey (the 4-character secret chosen at registration).
+// ResetPassword lets a user replace a forgotten password by presenting one of
+// their recovery codes, which is then spent.
 //
 // Security properties, mirroring Login:
-//   - Enumeration-safe: an unknown email still runs bcrypt against a dummy hash so
-//     the response timing matches a real (but wrong) passkey, and the same generic
-//     error is returned in both cases.
+//   - Enumeration-safe: an unknown email does the same work as a known one
+//     and gets the same generic error as a wrong code.
 //   - No JWT is issued on success — the user must log in normally afterward.
 //   - The new password must satisfy the same complexity rules as registration and
-//     must differ from the current password.
+//     must differ from the current password. A code isn't spent on a request
+//     rejected for that reason.
 //
-// Brute-force resistance additionally relies on the rate limiter applied to this
-// route (see middleware/ratelimit) plus bcrypt's cost-14 hashing.
+// Brute-force resistance comes from the 80-bit codes themselves, plus the rate
+// limiter applied to this route (see middleware/ratelimit).
 func ResetPassword(c *gin.Context, db *gorm.DB) {
 	var req ResetPasswordRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request payload"})
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid request payload")
 		return
 	}
 
-	if req.Email == "" || req.PassKey == "" || req.NewPassword == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email, passkey, and new password are required"})
+	if req.Email == "" || req.RecoveryCode == "" || req.NewPassword == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "Email, recovery code, and new password are required")
 		return
 	}
 
 	if len(req.Email) > MAX_EMAIL_LENGTH || !isEmailValid(req.Email) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email format"})
-		return
-	}
-
-	if len(req.PassKey) != PASSKEY_LENGTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Passkey must be exactly 4 characters long"})
+		apierr.Respond(c, apierr.InvalidRequest, "Invalid email format")
 		return
 	}
 
 	if len(req.NewPassword) < MIN_PASSWORD_LENGTH || len(req.NewPassword) > MAX_PASSWORD_LENGTH {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be between 8 and 72 characters long"})
+		apierr.Respond(c, apierr.InvalidRequest, passwordLengthMessage)
 		return
 	}
 
 	minLength, number, upper, lower, special := isValidPassword(req.NewPassword)
 	if !minLength || !number || !upper || !lower || !special {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Password must be at least 8 characters and include at least one number, one uppercase letter, one lowercase letter, and one special character",
-		})
+		apierr.Respond(c, apierr.InvalidRequest, "Password must be at least 8 characters and include at least one number, one uppercase letter, one lowercase letter, and one special character")
 		return
 	}
 
 	var user models.User
 	err := db.Where("email = ?", req.Email).First(&user).Error
-
-	var passKeyValid bool
-	if err != nil {
-		// Unknown email: burn the same amount of time so timing can't reveal it.
-		utils.VerifyPasswordHash(req.PassKey, dummyHash)
-		passKeyValid = false
+	if err == nil {
+		audit.Actor(c, &user, "")
 	} else {
-		passKeyValid = utils.VerifyPasswordHash(req.PassKey, user.PassKey)
+		audit.Actor(c, nil, req.Email)
 	}
 
-	if !passKeyValid {
-		log.Printf("Failed password reset attempt for email: %s from IP: %s", req.Email, c.ClientIP())
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or passkey"})
+	// Unknown emails look the code up under user ID 0, which never has codes,
+	// so both cases cost the same query.
+	code, err := findRecoveryCode(db, user.ID, req.RecoveryCode)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed password reset attempt", "email", req.Email, "ip", c.ClientIP())
+		apierr.Respond(c, apierr.InvalidCredentials, "Invalid email or recovery code")
 		return
 	}
 
 	// Don't allow "resetting" to the same password.
 	if utils.VerifyPasswordHash(req.NewPassword, user.Password) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must be different from the current password"})
+		apierr.Respond(c, apierr.InvalidRequest, "New password must be different from the current password")
 		return
 	}
 
 	hashedPassword, err := utils.PasswordHash(req.NewPassword)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password reset"})
+		apierr.Respond(c, apierr.Internal, "Failed to process password reset")
 		return
 	}
 
-	if err := db.Model(&user).Update("password", hashedPassword).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+	if !spendRecoveryCode(db, code) {
+		// Another reset spent the same code a moment ago.
+		apierr.Respond(c, apierr.InvalidCredentials, "Invalid email or recovery code")
 		return
 	}
 
-	log.Printf("Password reset successful for email: %s from IP: %s", req.Email, c.ClientIP())
-	c.JSON(http.StatusOK, gin.H{"message": "Password reset successful"})
+	err = db.Model(&user).Updates(map[string]any{"password": hashedPassword, "password_reset_required": false}).Error
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to update password")
+		return
+	}
+	jwt.ForgetUser(user.ID)
+
+	// Whoever knew the old password may be signed in somewhere; end every session.
+	if _, err := jwt.RevokeAllSessions(c.Request.Context(), db, user.ID, ""); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to revoke sessions after password reset", "user_id", user.ID, "error", err)
+	}
+
+	slog.InfoContext(c.Request.Context(), "Password reset", "email", req.Email, "ip", c.ClientIP())
+	resp := gin.H{"message": "Password reset successful"}
+	if remaining, err := countRecoveryCodes(db, user.ID); err == nil {
+		resp["recovery_codes_remaining"] = remaining
+	}
+	c.JSON(http.StatusOK, resp)
 }
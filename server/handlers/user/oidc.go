@@ -0,0 +1,559 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/oidc"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+// OIDC_AUTH_TTL is how long a sign-in may spend at the identity provider.
+// DEVICE_CODE_TTL is how long a CLI login waits for the browser step, and
+// DEVICE_POLL_INTERVAL how often the CLI may ask whether it has finished.
+const (
+	OIDC_AUTH_TTL        = 10 * time.Minute
+	DEVICE_CODE_TTL      = 10 * time.Minute
+	DEVICE_POLL_INTERVAL = 5 * time.Second
+)
+
+// deviceCSRFCookie holds the browser's secret for the device confirmation
+// form. It is SameSite=Strict, so a form posted from another site arrives
+// without it and can't approve a login.
+const deviceCSRFCookie = "nimbus_device_csrf"
+
+// oidcProvider is the configured identity provider, or nil when single
+// sign-on is off.
+var oidcProvider *oidc.Provider
+
+// UseOIDCProvider turns on single sign-on through p. Without it the /oidc
+// endpoints respond 404.
+func UseOIDCProvider(p *oidc.Provider) {
+	oidcProvider = p
+}
+
+// errUnverifiedEmail rejects identity provider accounts without a verified
+// email address: the address is what links them to a Nimbus account.
+var errUnverifiedEmail = errors.New("identity provider account has no verified email")
+
+// DeviceAuthorizationRequest is the JSON body for POST /oidc/device.
+type DeviceAuthorizationRequest struct {
+	Device string `json:"device"` // label for the session, e.g. the hostname
+}
+
+// DeviceTokenRequest is the JSON body for POST /oidc/device/token.
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// ── HTML ─────────────────────────────────────────────────────────────────────
+
+// oidcPage is shown in the browser during device sign-in. It is a template
+// because it echoes the user code and device label back.
+var oidcPage = template.Must(template.New("oidc").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <title>Nimbus — Sign in</title>
+  <style>
+    * { box-sizing: border-box; margin: 0; padding: 0; }
+    body {
+      font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif;
+      background: #0f1117; color: #e0e0e0;
+      display: flex; justify-content: center; align-items: center; min-height: 100vh;
+    }
+    .card {
+      background: #1a1d27; border: 1px solid #2e3148;
+      border-radius: 10px; padding: 40px; width: 420px;
+    }
+    h1 { font-size: 1.5rem; margin-bottom: 4px; color: #5b9bd5; }
+    p.sub { font-size: 0.85rem; color: #888; margin-bottom: 28px; line-height: 1.5; }
+    label { display: block; font-size: 0.8rem; color: #4ec9b0; margin-bottom: 6px; }
+    input {
+      width: 100%; padding: 10px 12px; background: #12141e;
+      border: 1px solid #2e3148; border-radius: 6px; text-transform: uppercase;
+      color: #e0e0e0; font-size: 0.95rem; margin-bottom: 18px; outline: none;
+    }
+    input:focus { border-color: #5b9bd5; }
+    button {
+      width: 100%; padding: 11px; background: #5b9bd5; color: #fff;
+      border: none; border-radius: 6px; font-size: 1rem; cursor: pointer; margin-top: 4px;
+    }
+    button:hover { background: #4a87c0; }
+    .msg { margin-bottom: 16px; font-size: 0.9rem; color: #ff6b6b; }
+  </style>
+</head>
+<body>
+  <div class="card">
+    <h1>☁ Nimbus CLI</h1>
+    <p class="sub">{{.Message}}</p>
+    {{if .Error}}<div class="msg">{{.Error}}</div>{{end}}
+    {{if .AskCode}}
+    <form method="get">
+      <label>Code shown by nim login --sso</label>
+      <input name="user_code" placeholder="XXXX-XXXX" autocomplete="off" required autofocus />
+      <button type="submit">Continue</button>
+    </form>
+    {{else if .Confirm}}
+    <form method="post">
+      <input type="hidden" name="user_code" value="{{.UserCode}}" />
+      <input type="hidden" name="csrf" value="{{.CSRF}}" />
+      <button type="submit">Sign in</button>
+    </form>
+    {{end}}
+  </div>
+</body>
+</html>`))
+
+// oidcPageData fills oidcPage. AskCode shows the user code form; Confirm
+// shows the button that continues to the identity provider, posting UserCode
+// with the CSRF token from deviceCSRFToken.
+type oidcPageData struct {
+	Message  string
+	Error    string
+	AskCode  bool
+	Confirm  bool
+	UserCode string
+	CSRF     string
+}
+
+func renderOIDCPage(c *gin.Context, status int, data oidcPageData) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := oidcPage.Execute(c.Writer, data); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to render single sign-on page", "error", err)
+	}
+}
+
+// ── Helpers ──────────────────────────────────────────────────────────────────
+
+// requireOIDC responds 404 and returns false when single sign-on is off.
+func requireOIDC(c *gin.Context) bool {
+	if oidcProvider == nil {
+		apierr.Respond(c, apierr.NotFound, "Single sign-on is not configured")
+		return false
+	}
+	return true
+}
+
+// verificationURI is where the CLI sends the user: the verify endpoint on the
+// host the identity provider redirects back to.
+func verificationURI() string {
+	u, err := url.Parse(oidcProvider.RedirectURL())
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/v1/api/auth/oidc/verify"
+}
+
+// startOIDCAuth sends the browser to the identity provider. deviceID is set
+// when the sign-in approves a CLI login.
+func startOIDCAuth(c *gin.Context, db *gorm.DB, deviceID *uint) {
+	state, err := utils.GenerateTokenID()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start sign-in")
+		return
+	}
+	nonce, err := utils.GenerateTokenID()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start sign-in")
+		return
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start sign-in")
+		return
+	}
+
+	req := models.OIDCAuthRequest{
+		StateHash:             utils.HashToken(state),
+		CodeVerifier:          verifier,
+		Nonce:                 nonce,
+		DeviceAuthorizationID: deviceID,
+		ExpiresAt:             time.Now().Add(OIDC_AUTH_TTL),
+	}
+	if err := db.Create(&req).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start sign-in")
+		return
+	}
+	c.Redirect(http.StatusFound, oidcProvider.AuthCodeURL(state, nonce, oidc.CodeChallengeS256(verifier)))
+}
+
+// consumeAuthRequest finds the pending sign-in for state and marks it used,
+// so a callback URL can't be replayed.
+func consumeAuthRequest(db *gorm.DB, state string) (*models.OIDCAuthRequest, bool) {
+	var req models.OIDCAuthRequest
+	if state == "" || db.Where("state_hash = ?", utils.HashToken(state)).First(&req).Error != nil {
+		return nil, false
+	}
+	now := time.Now()
+	result := db.Model(&models.OIDCAuthRequest{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", req.ID, now).
+		Update("used_at", now)
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, false
+	}
+	return &req, true
+}
+
+// resolveOIDCUser returns the account for an identity provider user. A known
+// (issuer, subject) pair wins; otherwise the verified email links an existing
+// account or creates a new one without a password.
+func resolveOIDCUser(db *gorm.DB, issuer string, claims *oidc.Claims) (*models.User, error) {
+	var identity models.Identity
+	err := db.Where("issuer = ? AND subject = ?", issuer, claims.Subject).First(&identity).Error
+	if err == nil {
+		var user models.User
+		if err := db.Preload("Boxes").First(&user, identity.UserID).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !claims.EmailVerified || len(claims.Email) > MAX_EMAIL_LENGTH || !isEmailValid(claims.Email) {
+		return nil, errUnverifiedEmail
+	}
+
+	var userID uint
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existing models.User
+		err := tx.Where("email = ?", claims.Email).First(&existing).Error
+		switch {
+		case err == nil:
+			userID = existing.ID
+			slog.Info("Linked single sign-on identity to existing account", "issuer", issuer, "user_id", userID)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user, err := newUser(tx, claims.Email, "")
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+			userID = user.ID
+			slog.Info("Created account for single sign-on identity", "issuer", issuer, "user_id", userID)
+		default:
+			return err
+		}
+		return tx.Create(&models.Identity{
+			UserID:  userID,
+			Issuer:  issuer,
+			Subject: claims.Subject,
+			Email:   claims.Email,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := db.Preload("Boxes").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ── Handlers ─────────────────────────────────────────────────────────────────
+
+// OIDCLogin starts a browser sign-in through the identity provider. The
+// callback responds with tokens, as Login does.
+func OIDCLogin(c *gin.Context, db *gorm.DB) {
+	if !requireOIDC(c) {
+		return
+	}
+	startOIDCAuth(c, db, nil)
+}
+
+// StartDeviceAuthorization begins a CLI sign-in (RFC 8628). The CLI shows the
+// user code and verification URI, then polls DeviceToken with the device code
+// while the user signs in with a browser.
+func StartDeviceAuthorization(c *gin.Context, db *gorm.DB) {
+	if !requireOIDC(c) {
+		return
+	}
+	var req DeviceAuthorizationRequest
+	// The body is optional.
+	_ = c.ShouldBindJSON(&req)
+
+	deviceCode, err := utils.GenerateDeviceCode()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start sign-in")
+		return
+	}
+	userCode, err := utils.GenerateUserCode()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start sign-in")
+		return
+	}
+
+	da := models.DeviceAuthorization{
+		DeviceCodeHash: utils.HashToken(deviceCode),
+		UserCode:       userCode,
+		Device:         req.Device,
+		ExpiresAt:      time.Now().Add(DEVICE_CODE_TTL),
+	}
+	if err := db.Create(&da).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start sign-in")
+		return
+	}
+
+	uri := verificationURI()
+	c.JSON(http.StatusOK, gin.H{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          uri,
+		"verification_uri_complete": uri + "?user_code=" + url.QueryEscape(userCode),
+		"expires_in":                int(DEVICE_CODE_TTL.Seconds()),
+		"interval":                  int(DEVICE_POLL_INTERVAL.Seconds()),
+	})
+}
+
+// VerifyDevice is the page the CLI sends the user to. It asks for the user
+// code if it wasn't in the link, then asks the user to confirm the login is
+// theirs, so a code sent by someone else can't sign their CLI in. Nothing in
+// the link can skip that step: only ConfirmDevice, posted from this page,
+// continues to the identity provider.
+func VerifyDevice(c *gin.Context, db *gorm.DB) {
+	if !requireOIDC(c) {
+		return
+	}
+	code := c.Query("user_code")
+	if code == "" {
+		renderOIDCPage(c, http.StatusOK, oidcPageData{
+			Message: "Enter the code shown in your terminal.",
+			AskCode: true,
+		})
+		return
+	}
+	da, ok := pendingDevice(c, db, code)
+	if !ok {
+		return
+	}
+
+	secret, err := c.Cookie(deviceCSRFCookie)
+	if err != nil || len(secret) < 32 {
+		if secret, err = utils.GenerateTokenID(); err != nil {
+			apierr.Respond(c, apierr.Internal, "Failed to start sign-in")
+			return
+		}
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     deviceCSRFCookie,
+		Value:    secret,
+		Path:     "/v1/api/auth/oidc/verify",
+		MaxAge:   int(DEVICE_CODE_TTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(verificationURI(), "https:"),
+		SameSite: http.SameSiteStrictMode,
+	})
+	c.Header("Cache-Control", "no-store")
+
+	device := da.Device
+	if device == "" {
+		device = "an unnamed device"
+	}
+	renderOIDCPage(c, http.StatusOK, oidcPageData{
+		Message:  "Sign in to Nimbus on " + device + "? Only continue if you ran nim login --sso yourself and the code matches your terminal.",
+		Confirm:  true,
+		UserCode: da.UserCode,
+		CSRF:     deviceCSRFToken(secret, da.UserCode),
+	})
+}
+
+// ConfirmDevice is the confirmation form's POST. It continues to the identity
+// provider only when the form's CSRF token matches the browser's cookie and
+// the user code it was issued for.
+func ConfirmDevice(c *gin.Context, db *gorm.DB) {
+	if !requireOIDC(c) {
+		return
+	}
+	code := utils.NormalizeUserCode(c.PostForm("user_code"))
+	secret, err := c.Cookie(deviceCSRFCookie)
+	if err != nil || len(secret) < 32 || code == "" ||
+		!hmac.Equal([]byte(c.PostForm("csrf")), []byte(deviceCSRFToken(secret, code))) {
+		slog.WarnContext(c.Request.Context(), "Rejected device confirmation without a valid CSRF token", "ip", c.ClientIP())
+		renderOIDCPage(c, http.StatusForbidden, oidcPageData{
+			Message: "This confirmation didn't come from the Nimbus sign-in page. Open the link from your terminal and confirm there.",
+		})
+		return
+	}
+	da, ok := pendingDevice(c, db, code)
+	if !ok {
+		return
+	}
+	startOIDCAuth(c, db, &da.ID)
+}
+
+// pendingDevice loads the device login waiting for approval under code, or
+// shows the code form again with an error and returns false.
+func pendingDevice(c *gin.Context, db *gorm.DB, code string) (*models.DeviceAuthorization, bool) {
+	var da models.DeviceAuthorization
+	err := db.Where("user_code = ? AND approved_at IS NULL AND denied_at IS NULL AND expires_at > ?",
+		utils.NormalizeUserCode(code), time.Now()).First(&da).Error
+	if err != nil {
+		renderOIDCPage(c, http.StatusOK, oidcPageData{
+			Message: "Enter the code shown in your terminal.",
+			Error:   "That code is invalid or has expired.",
+			AskCode: true,
+		})
+		return nil, false
+	}
+	return &da, true
+}
+
+// deviceCSRFToken ties the confirmation form to the browser's cookie secret
+// and to one user code.
+func deviceCSRFToken(secret, userCode string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userCode))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// OIDCCallback is where the identity provider sends the browser back. It
+// checks the state, exchanges the code (with the PKCE verifier) for an ID
+// token, verifies it, and finds or creates the account. Device sign-ins are
+// then approved for the waiting CLI; browser sign-ins get tokens directly.
+// Nimbus two-factor authentication is not asked for: the identity provider
+// is responsible for how its users sign in.
+func OIDCCallback(c *gin.Context, db *gorm.DB) {
+	if !requireOIDC(c) {
+		return
+	}
+	req, ok := consumeAuthRequest(db, c.Query("state"))
+	if !ok {
+		renderOIDCPage(c, http.StatusBadRequest, oidcPageData{
+			Message: "This sign-in link is invalid or has expired. Start again from your terminal.",
+		})
+		return
+	}
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		if req.DeviceAuthorizationID != nil {
+			db.Model(&models.DeviceAuthorization{}).
+				Where("id = ? AND approved_at IS NULL", *req.DeviceAuthorizationID).
+				Update("denied_at", time.Now())
+		}
+		renderOIDCPage(c, http.StatusUnauthorized, oidcPageData{
+			Message: "Sign-in was cancelled at the identity provider (" + idpErr + ").",
+		})
+		return
+	}
+
+	rawIDToken, err := oidcProvider.Exchange(c.Request.Context(), c.Query("code"), req.CodeVerifier)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Single sign-on code exchange failed", "error", err)
+		renderOIDCPage(c, http.StatusUnauthorized, oidcPageData{Message: "Sign-in failed. Start again from your terminal."})
+		return
+	}
+	claims, err := oidcProvider.Verify(c.Request.Context(), rawIDToken, req.Nonce)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Rejected single sign-on ID token", "error", err)
+		renderOIDCPage(c, http.StatusUnauthorized, oidcPageData{Message: "Sign-in failed. Start again from your terminal."})
+		return
+	}
+
+	user, err := resolveOIDCUser(db, oidcProvider.Issuer(), claims)
+	if errors.Is(err, errUnverifiedEmail) {
+		renderOIDCPage(c, http.StatusForbidden, oidcPageData{
+			Message: "Your identity provider account has no verified email address, so it can't be used to sign in to Nimbus.",
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to resolve single sign-on account", "error", err)
+		renderOIDCPage(c, http.StatusInternalServerError, oidcPageData{Message: "Sign-in failed. Please try again."})
+		return
+	}
+	audit.Actor(c, user, "")
+	audit.Detail(c, "issuer: %s", oidcProvider.Issuer())
+
+	if req.DeviceAuthorizationID == nil {
+		completeLogin(c, db, user, c.Request.UserAgent())
+		return
+	}
+
+	now := time.Now()
+	result := db.Model(&models.DeviceAuthorization{}).
+		Where("id = ? AND approved_at IS NULL AND denied_at IS NULL AND expires_at > ?", *req.DeviceAuthorizationID, now).
+		Updates(map[string]any{"user_id": user.ID, "approved_at": now})
+	if result.Error != nil || result.RowsAffected != 1 {
+		renderOIDCPage(c, http.StatusBadRequest, oidcPageData{
+			Message: "This login request has expired. Run nim login --sso again.",
+		})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Approved device sign-in", "user_id", user.ID)
+	renderOIDCPage(c, http.StatusOK, oidcPageData{
+		Message: "Signed in as " + user.Email + ". You can close this window and return to your terminal.",
+	})
+}
+
+// DeviceToken is polled by the CLI during a device sign-in. Until the user
+// has finished in the browser it answers with an RFC 8628 error code
+// (authorization_pending, slow_down, access_denied or expired_token); then
+// it responds with tokens, as Login does, exactly once.
+func DeviceToken(c *gin.Context, db *gorm.DB) {
+	if !requireOIDC(c) {
+		return
+	}
+	var req DeviceTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.DeviceCode == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "invalid_request")
+		return
+	}
+
+	var da models.DeviceAuthorization
+	if err := db.Where("device_code_hash = ?", utils.HashToken(req.DeviceCode)).First(&da).Error; err != nil || da.ConsumedAt != nil {
+		apierr.Respond(c, apierr.InvalidGrant, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case da.DeniedAt != nil:
+		apierr.Respond(c, apierr.AccessDenied, "access_denied")
+		return
+	case now.After(da.ExpiresAt):
+		apierr.Respond(c, apierr.ExpiredToken, "expired_token")
+		return
+	case da.ApprovedAt == nil:
+		db.Model(&models.DeviceAuthorization{}).Where("id = ?", da.ID).Update("last_polled_at", now)
+		if da.LastPolledAt != nil && now.Sub(*da.LastPolledAt) < DEVICE_POLL_INTERVAL {
+			apierr.Respond(c, apierr.SlowDown, "slow_down")
+			return
+		}
+		apierr.Respond(c, apierr.AuthorizationPending, "authorization_pending")
+		return
+	}
+
+	result := db.Model(&models.DeviceAuthorization{}).
+		Where("id = ? AND consumed_at IS NULL", da.ID).
+		Update("consumed_at", now)
+	if result.Error != nil || result.RowsAffected != 1 {
+		apierr.Respond(c, apierr.InvalidGrant, "invalid_grant")
+		return
+	}
+
+	var user models.User
+	if err := db.Preload("Boxes").First(&user, *da.UserID).Error; err != nil {
+		apierr.Respond(c, apierr.InvalidGrant, "invalid_grant")
+		return
+	}
+	completeLogin(c, db, &user, da.Device)
+}
@@ -0,0 +1,161 @@
+package user
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+// RECOVERY_CODE_COUNT is how many recovery codes an account holds. Each one
+// resets the password or stands in for the authenticator app, once.
+const RECOVERY_CODE_COUNT = 10
+
+// RegenerateRecoveryCodesRequest is the JSON body expected by
+// POST /recovery-codes. Code is required when two-factor authentication is on.
+type RegenerateRecoveryCodesRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// findRecoveryCode returns userID's unused recovery code matching code.
+func findRecoveryCode(db *gorm.DB, userID uint, code string) (*models.RecoveryCode, error) {
+	var rc models.RecoveryCode
+	err := db.Where("user_id = ? AND hash = ? AND used_at IS NULL", userID, utils.HashToken(utils.NormalizeRecoveryCode(code))).
+		First(&rc).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// spendRecoveryCode marks rc used, reporting false if another request spent
+// it first.
+func spendRecoveryCode(db *gorm.DB, rc *models.RecoveryCode) bool {
+	result := db.Model(&models.RecoveryCode{}).Where("id = ? AND used_at IS NULL", rc.ID).Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// consumeRecoveryCode reports whether code is one of user's unused recovery
+// codes, and spends it if so.
+func consumeRecoveryCode(db *gorm.DB, user *models.User, code string) bool {
+	rc, err := findRecoveryCode(db, user.ID, code)
+	if err != nil {
+		return false
+	}
+	return spendRecoveryCode(db, rc)
+}
+
+// countRecoveryCodes returns how many unused recovery codes userID has left.
+func countRecoveryCodes(db *gorm.DB, userID uint) (int64, error) {
+	var n int64
+	err := db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&n).Error
+	return n, err
+}
+
+// replaceRecoveryCodes issues a fresh set of recovery codes for userID,
+// invalidating any earlier set, and returns them for showing once.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, RECOVERY_CODE_COUNT)
+	rows := make([]models.RecoveryCode, RECOVERY_CODE_COUNT)
+	for i := range codes {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = models.RecoveryCode{UserID: userID, Hash: utils.HashToken(utils.NormalizeRecoveryCode(code))}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// issueFirstRecoveryCodes gives user their first set of recovery codes if
+// they have a password but were never issued any, as with accounts registered
+// before codes existed, whose passkeys 0015_retire_passkeys removed. It
+// returns nil when there's nothing to issue.
+func issueFirstRecoveryCodes(db *gorm.DB, user *models.User) ([]string, error) {
+	if user.Password == "" {
+		return nil, nil
+	}
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var n int64
+		if err := tx.Unscoped().Model(&models.RecoveryCode{}).Where("user_id = ?", user.ID).Count(&n).Error; err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// GetRecoveryCodes reports how many of the authenticated user's recovery codes
+// are left and whether regenerating them needs a two-factor code. The codes themselves are never
+// shown again.
+func GetRecoveryCodes(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	remaining, err := countRecoveryCodes(db, user.ID)
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to count recovery codes")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"remaining":    remaining,
+		"totp_enabled": user.TOTPEnabled,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the authenticated user's recovery codes
+// with a new set, returned this once. It needs the account password, and a
+// code from the authenticator app when two-factor authentication is on, so a
+// stolen session can't mint codes for itself.
+func RegenerateRecoveryCodes(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req RegenerateRecoveryCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Password == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "password is required")
+		return
+	}
+	if len(req.Password) > MAX_PASSWORD_LENGTH || !utils.VerifyPasswordHash(req.Password, user.Password) {
+		slog.WarnContext(c.Request.Context(), "Recovery code regeneration rejected, bad password", "ip", c.ClientIP())
+		apierr.Respond(c, apierr.InvalidCredentials, "invalid password")
+		return
+	}
+	if user.TOTPEnabled && !consumeTOTP(db, user, req.Code) {
+		apierr.Respond(c, apierr.TwoFactorRequired, "a current two-factor code is required")
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Recovery code regeneration failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to generate recovery codes")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Recovery codes regenerated")
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
@@ -0,0 +1,125 @@
+package user
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+// REFRESH_TOKEN_TTL is how long a refresh token can be exchanged. Each refresh
+// issues a new one, so a CLI used at least once a month stays logged in.
+const REFRESH_TOKEN_TTL = 30 * 24 * time.Hour
+
+// RefreshRequest is the JSON body expected by POST /refresh.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// issueTokens creates an access token and a refresh token for user, both
+// belonging to session.
+func issueTokens(db *gorm.DB, user *models.User, session *models.Session) (access, refresh string, err error) {
+	access, err = jwt.CreateToken(user.Email, fmt.Sprintf("%d", user.ID), session.SessionID)
+	if err != nil {
+		return "", "", err
+	}
+	refresh, err = utils.GenerateRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	rt := models.RefreshToken{
+		UserID:    user.ID,
+		Hash:      utils.HashToken(refresh),
+		SessionID: session.SessionID,
+		ExpiresAt: time.Now().Add(REFRESH_TOKEN_TTL),
+	}
+	if err := db.Create(&rt).Error; err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token.
+// The presented token can't be used again; if it is, someone else has a copy,
+// so its session is revoked and the user must log in.
+func Refresh(c *gin.Context, db *gorm.DB) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "refresh_token is required")
+		return
+	}
+
+	var rt models.RefreshToken
+	if err := db.Where("hash = ?", utils.HashToken(req.RefreshToken)).First(&rt).Error; err != nil {
+		slog.WarnContext(c.Request.Context(), "Unknown refresh token", "ip", c.ClientIP())
+		apierr.Respond(c, apierr.Unauthenticated, "invalid refresh token")
+		return
+	}
+	if rt.RevokedAt != nil {
+		apierr.Respond(c, apierr.Unauthenticated, "refresh token revoked, please log in again")
+		return
+	}
+	if time.Now().After(rt.ExpiresAt) {
+		apierr.Respond(c, apierr.Unauthenticated, "refresh token expired, please log in again")
+		return
+	}
+
+	// Mark it used only if nobody beat us to it, so two concurrent refreshes
+	// with the same token can't both succeed.
+	now := time.Now()
+	result := db.Model(&models.RefreshToken{}).Where("id = ? AND used_at IS NULL", rt.ID).Update("used_at", now)
+	if result.Error != nil {
+		apierr.Respond(c, apierr.Internal, "failed to refresh token")
+		return
+	}
+	if result.RowsAffected == 0 {
+		if _, err := jwt.RevokeSession(c.Request.Context(), db, rt.UserID, rt.SessionID); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Session revoke after refresh token reuse failed", "user_id", rt.UserID, "error", err)
+		}
+		slog.WarnContext(c.Request.Context(), "Refresh token reuse detected, session revoked", "user_id", rt.UserID, "ip", c.ClientIP())
+		apierr.Respond(c, apierr.Unauthenticated, "refresh token already used, please log in again")
+		return
+	}
+
+	var session models.Session
+	if err := db.Where("session_id = ? AND revoked_at IS NULL", rt.SessionID).First(&session).Error; err != nil {
+		apierr.Respond(c, apierr.Unauthenticated, "refresh token revoked, please log in again")
+		return
+	}
+	var user models.User
+	if err := db.First(&user, rt.UserID).Error; err != nil {
+		apierr.Respond(c, apierr.Unauthenticated, "invalid refresh token")
+		return
+	}
+	audit.Actor(c, &user, "")
+	if user.Suspended() {
+		apierr.Respond(c, apierr.AccountSuspended, jwt.ErrAccountSuspended.Error())
+		return
+	}
+
+	access, refresh, err := issueTokens(db, &user, &session)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Refresh failed", "user_id", user.ID, "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to refresh token")
+		return
+	}
+	db.Model(&session).UpdateColumns(map[string]any{
+		"last_seen_at": now,
+		"ip":           c.ClientIP(),
+		"expires_at":   now.Add(REFRESH_TOKEN_TTL),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         access,
+		"refresh_token": refresh,
+		"expires_in":    int(jwt.ACCESS_TOKEN_TTL.Seconds()),
+	})
+}
@@ -0,0 +1,115 @@
+package user
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	MAX_S3_KEY_NAME = 64
+	MAX_S3_KEYS     = 10
+)
+
+// CreateS3KeyRequest is the JSON body expected by POST /s3-keys.
+type CreateS3KeyRequest struct {
+	Name string `json:"name"`
+}
+
+// S3KeyEntry is how an S3 access key is listed. The secret is never returned
+// after creation.
+type S3KeyEntry struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	AccessKeyID string     `json:"access_key_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
+
+// CreateS3Key issues a new access key for the S3 gateway. The secret is in
+// this response only.
+func CreateS3Key(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req CreateS3KeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "name is required")
+		return
+	}
+	if len(req.Name) > MAX_S3_KEY_NAME {
+		apierr.Respond(c, apierr.InvalidRequest, "name must be at most 64 characters")
+		return
+	}
+
+	var count int64
+	db.Model(&models.S3AccessKey{}).Where("user_id = ?", user.ID).Count(&count)
+	if count >= MAX_S3_KEYS {
+		apierr.RespondDetails(c, apierr.LimitReached, "access key limit reached, revoke an unused one first", gin.H{"limit": MAX_S3_KEYS})
+		return
+	}
+
+	id, secret, err := utils.GenerateS3AccessKey()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to generate access key")
+		return
+	}
+	key := models.S3AccessKey{UserID: user.ID, Name: req.Name, AccessKeyID: id, SecretKey: secret}
+	if err := db.Create(&key).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "S3 key save failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to save access key")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "S3 key created", "access_key_id", id)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":           "access key created, the secret will not be shown again",
+		"id":                key.ID,
+		"name":              key.Name,
+		"access_key_id":     id,
+		"secret_access_key": secret,
+	})
+}
+
+// ListS3Keys returns the authenticated user's S3 access keys, newest first.
+func ListS3Keys(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var rows []models.S3AccessKey
+	if err := db.Where("user_id = ?", user.ID).Order("id DESC").Find(&rows).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to list access keys")
+		return
+	}
+
+	entries := make([]S3KeyEntry, len(rows))
+	for i, k := range rows {
+		entries[i] = S3KeyEntry{ID: k.ID, Name: k.Name, AccessKeyID: k.AccessKeyID, CreatedAt: k.CreatedAt, LastUsedAt: k.LastUsedAt}
+	}
+	c.JSON(http.StatusOK, gin.H{"s3_keys": entries})
+}
+
+// RevokeS3Key deletes one of the authenticated user's S3 access keys.
+// Requests signed with it fail from then on.
+func RevokeS3Key(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	// Hard delete so the secret doesn't linger in a soft-deleted row.
+	result := db.Unscoped().Where("id = ? AND user_id = ?", c.Param("id"), user.ID).Delete(&models.S3AccessKey{})
+	if result.Error != nil {
+		apierr.Respond(c, apierr.Internal, "failed to revoke access key")
+		return
+	}
+	if result.RowsAffected == 0 {
+		apierr.Respond(c, apierr.NotFound, "access key not found")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "S3 key revoked", "s3_key_id", c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"message": "access key revoked"})
+}
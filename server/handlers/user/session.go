@@ -0,0 +1,137 @@
+package user
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+// MAX_DEVICE_NAME caps the device label stored for a session.
+const MAX_DEVICE_NAME = 128
+
+// SessionEntry is how a login session is listed.
+type SessionEntry struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"` // the session making the request
+}
+
+// startSession records a new login for user. device is the name the client
+// sent, falling back to its User-Agent.
+func startSession(c *gin.Context, db *gorm.DB, user *models.User, device string) (*models.Session, error) {
+	sessionID, err := utils.GenerateTokenID()
+	if err != nil {
+		return nil, err
+	}
+	if device == "" {
+		device = c.Request.UserAgent()
+	}
+	if len(device) > MAX_DEVICE_NAME {
+		device = device[:MAX_DEVICE_NAME]
+	}
+	now := time.Now()
+	session := models.Session{
+		UserID:     user.ID,
+		SessionID:  sessionID,
+		Device:     device,
+		IP:         c.ClientIP(),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(REFRESH_TOKEN_TTL),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListSessions returns the authenticated user's active login sessions, most
+// recently used first.
+func ListSessions(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var rows []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("last_seen_at DESC").Find(&rows).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to list sessions")
+		return
+	}
+
+	current := jwt.SessionID(c)
+	entries := make([]SessionEntry, len(rows))
+	for i, s := range rows {
+		entries[i] = SessionEntry{
+			ID:         s.SessionID,
+			Device:     s.Device,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.SessionID == current,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": entries})
+}
+
+// RevokeSession signs one of the authenticated user's sessions out. Its
+// tokens stop working immediately.
+func RevokeSession(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	found, err := jwt.RevokeSession(c.Request.Context(), db, user.ID, c.Param("id"))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Session revoke failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to revoke session")
+		return
+	}
+	if !found {
+		apierr.Respond(c, apierr.NotFound, "session not found")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Session revoked", "session_id", c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// RevokeAllSessions signs the authenticated user out everywhere, including
+// the session making the request.
+func RevokeAllSessions(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	n, err := jwt.RevokeAllSessions(c.Request.Context(), db, user.ID, "")
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Revoking all sessions failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to revoke sessions")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Revoked all sessions", "count", n)
+	c.JSON(http.StatusOK, gin.H{"message": "all sessions revoked", "revoked": n})
+}
+
+// Logout ends the session the request was made with.
+func Logout(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	sessionID := jwt.SessionID(c)
+	if sessionID == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "personal access tokens have no session, revoke the token instead")
+		return
+	}
+	if _, err := jwt.RevokeSession(c.Request.Context(), db, user.ID, sessionID); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Logout failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to log out")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Logged out", "session_id", sessionID)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
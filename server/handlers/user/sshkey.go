@@ -0,0 +1,135 @@
+package user
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+const (
+	MAX_SSH_KEY_NAME = 64
+	MAX_SSH_KEYS     = 25
+)
+
+// CreateSSHKeyRequest is the JSON body expected by POST /ssh-keys. PublicKey
+// is one line in authorized_keys format, e.g. the contents of id_ed25519.pub.
+type CreateSSHKeyRequest struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"public_key"`
+}
+
+// SSHKeyEntry is how an SSH key is listed.
+type SSHKeyEntry struct {
+	ID          uint       `json:"id"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Fingerprint string     `json:"fingerprint"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+}
+
+// CreateSSHKey registers a public key for SFTP logins by the authenticated
+// user. A key can only belong to one account.
+func CreateSSHKey(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req CreateSSHKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" || req.PublicKey == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "name and public_key are required")
+		return
+	}
+	if len(req.Name) > MAX_SSH_KEY_NAME {
+		apierr.Respond(c, apierr.InvalidRequest, "name must be at most 64 characters")
+		return
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		apierr.Respond(c, apierr.InvalidRequest, "public_key is not a valid SSH public key")
+		return
+	}
+
+	var count int64
+	db.Model(&models.SSHKey{}).Where("user_id = ?", user.ID).Count(&count)
+	if count >= MAX_SSH_KEYS {
+		apierr.RespondDetails(c, apierr.LimitReached, "SSH key limit reached, remove an unused one first", gin.H{"limit": MAX_SSH_KEYS})
+		return
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	var existing models.SSHKey
+	if err := db.Where("fingerprint = ?", fingerprint).First(&existing).Error; err == nil {
+		apierr.Respond(c, apierr.AlreadyExists, "this SSH key is already registered")
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		apierr.Respond(c, apierr.Internal, "failed to save SSH key")
+		return
+	}
+
+	sk := models.SSHKey{
+		UserID:      user.ID,
+		Name:        req.Name,
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		Fingerprint: fingerprint,
+	}
+	if err := db.Create(&sk).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "SSH key save failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to save SSH key")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "SSH key added", "ssh_key_id", sk.ID, "fingerprint", fingerprint)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "SSH key added",
+		"id":          sk.ID,
+		"name":        sk.Name,
+		"type":        key.Type(),
+		"fingerprint": fingerprint,
+	})
+}
+
+// ListSSHKeys returns the authenticated user's SSH keys, newest first.
+func ListSSHKeys(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var rows []models.SSHKey
+	if err := db.Where("user_id = ?", user.ID).Order("id DESC").Find(&rows).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to list SSH keys")
+		return
+	}
+
+	entries := make([]SSHKeyEntry, len(rows))
+	for i, k := range rows {
+		keyType, _, _ := strings.Cut(k.PublicKey, " ")
+		entries[i] = SSHKeyEntry{ID: k.ID, Name: k.Name, Type: keyType, Fingerprint: k.Fingerprint, CreatedAt: k.CreatedAt, LastUsedAt: k.LastUsedAt}
+	}
+	c.JSON(http.StatusOK, gin.H{"ssh_keys": entries})
+}
+
+// RemoveSSHKey deletes one of the authenticated user's SSH keys. New SFTP
+// logins with it fail from then on.
+func RemoveSSHKey(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	// Hard delete so the unique fingerprint can be registered again later.
+	result := db.Unscoped().Where("id = ? AND user_id = ?", c.Param("id"), user.ID).Delete(&models.SSHKey{})
+	if result.Error != nil {
+		apierr.Respond(c, apierr.Internal, "failed to remove SSH key")
+		return
+	}
+	if result.RowsAffected == 0 {
+		apierr.Respond(c, apierr.NotFound, "SSH key not found")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "SSH key removed", "ssh_key_id", c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"message": "SSH key removed"})
+}
@@ -0,0 +1,189 @@
+package user
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	MAX_ACCESS_TOKEN_NAME     = 64
+	MAX_ACCESS_TOKENS         = 25
+	DEFAULT_TOKEN_TTL_DAYS    = 30
+	MAX_ACCESS_TOKEN_TTL_DAYS = 365
+)
+
+// CreateAccessTokenRequest is the JSON body expected by POST /tokens.
+// ExpiresInDays defaults to 30; BoxName, when set, limits the token to that box.
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+	BoxName       string   `json:"box_name"`
+}
+
+// AccessTokenEntry is how a personal access token is listed. The token itself
+// is never returned after creation.
+type AccessTokenEntry struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	BoxName    string     `json:"box_name,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+}
+
+// CreateAccessToken issues a personal access token for the authenticated user.
+// The plain token is in this response only; the database keeps its SHA-256.
+func CreateAccessToken(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req CreateAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Name == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "name is required")
+		return
+	}
+	if len(req.Name) > MAX_ACCESS_TOKEN_NAME {
+		apierr.Respond(c, apierr.InvalidRequest, "name must be at most 64 characters")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		apierr.Respond(c, apierr.InvalidRequest, "at least one scope is required (read, write, delete, admin)")
+		return
+	}
+	var scopes []string
+	for _, s := range req.Scopes {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !slices.Contains(jwt.Scopes, s) {
+			apierr.Respond(c, apierr.InvalidRequest, fmt.Sprintf("unknown scope %q (use read, write, delete, admin)", s))
+			return
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = DEFAULT_TOKEN_TTL_DAYS
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > MAX_ACCESS_TOKEN_TTL_DAYS {
+		apierr.Respond(c, apierr.InvalidRequest, "expires_in_days must be between 1 and 365")
+		return
+	}
+
+	var boxID *uint
+	if req.BoxName != "" {
+		// An admin token can mint other credentials, which would escape the
+		// box restriction, so the two don't mix.
+		if slices.Contains(scopes, jwt.ScopeAdmin) {
+			apierr.Respond(c, apierr.InvalidRequest, "a box-restricted token cannot have the admin scope")
+			return
+		}
+		var box models.Box
+		if err := db.Where("name = ? AND user_id = ?", req.BoxName, user.ID).First(&box).Error; err != nil {
+			apierr.Respond(c, apierr.BoxNotFound, "box not found")
+			return
+		}
+		boxID = &box.ID
+	}
+
+	var count int64
+	db.Model(&models.PersonalAccessToken{}).Where("user_id = ?", user.ID).Count(&count)
+	if count >= MAX_ACCESS_TOKENS {
+		apierr.RespondDetails(c, apierr.LimitReached, "token limit reached, revoke an unused one first", gin.H{"limit": MAX_ACCESS_TOKENS})
+		return
+	}
+
+	secret, err := utils.GeneratePersonalAccessToken()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to generate token")
+		return
+	}
+	t := models.PersonalAccessToken{
+		UserID:    user.ID,
+		Name:      req.Name,
+		Hash:      utils.HashToken(secret),
+		Hint:      secret[len(secret)-4:],
+		Scopes:    strings.Join(scopes, ","),
+		BoxID:     boxID,
+		ExpiresAt: time.Now().AddDate(0, 0, req.ExpiresInDays),
+	}
+	if err := db.Create(&t).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Access token save failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to save token")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Access token created", "token_id", t.ID, "scopes", t.Scopes)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "token created, it will not be shown again",
+		"id":         t.ID,
+		"name":       t.Name,
+		"token":      secret,
+		"scopes":     scopes,
+		"box_name":   req.BoxName,
+		"expires_at": t.ExpiresAt,
+	})
+}
+
+// ListAccessTokens returns the authenticated user's personal access tokens,
+// newest first, including expired ones until they are revoked.
+func ListAccessTokens(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var rows []models.PersonalAccessToken
+	if err := db.Preload("Box").Where("user_id = ?", user.ID).Order("id DESC").Find(&rows).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to list tokens")
+		return
+	}
+
+	entries := make([]AccessTokenEntry, len(rows))
+	for i, t := range rows {
+		entries[i] = AccessTokenEntry{
+			ID:         t.ID,
+			Name:       t.Name,
+			Hint:       t.Hint,
+			Scopes:     strings.Split(t.Scopes, ","),
+			CreatedAt:  t.CreatedAt,
+			ExpiresAt:  t.ExpiresAt,
+			LastUsedAt: t.LastUsedAt,
+			LastUsedIP: t.LastUsedIP,
+		}
+		if t.Box != nil {
+			entries[i].BoxName = t.Box.Name
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": entries})
+}
+
+// RevokeAccessToken deletes one of the authenticated user's personal access
+// tokens. Requests using it fail from then on.
+func RevokeAccessToken(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	// Hard delete so the hash doesn't linger in a soft-deleted row.
+	result := db.Unscoped().Where("id = ? AND user_id = ?", c.Param("id"), user.ID).Delete(&models.PersonalAccessToken{})
+	if result.Error != nil {
+		apierr.Respond(c, apierr.Internal, "failed to revoke token")
+		return
+	}
+	if result.RowsAffected == 0 {
+		apierr.Respond(c, apierr.NotFound, "token not found")
+		return
+	}
+
+	slog.InfoContext(c.Request.Context(), "Access token revoked", "token_id", c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"message": "token revoked"})
+}
@@ -0,0 +1,252 @@
+package user
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+// TOTP_ISSUER names the account in authenticator apps.
+// LOGIN_CHALLENGE_TTL is how long the code step of a login may take, and
+// MAX_CHALLENGE_ATTEMPTS how many codes can be tried against one challenge
+// before the password has to be entered again.
+const (
+	TOTP_ISSUER            = "Nimbus"
+	LOGIN_CHALLENGE_TTL    = 5 * time.Minute
+	MAX_CHALLENGE_ATTEMPTS = 5
+)
+
+// TOTPCodeRequest is the JSON body for the enrollment and disable endpoints.
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// LoginTOTPRequest is the JSON body expected by POST /users/login/totp. Code
+// is the authenticator app's current code or an unused recovery code.
+type LoginTOTPRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// startLoginChallenge responds to a correct password from a user with
+// two-factor authentication: no tokens yet, just a challenge for LoginTOTP.
+func startLoginChallenge(c *gin.Context, db *gorm.DB, user *models.User, device string) {
+	challenge, err := utils.GenerateLoginChallenge()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start login")
+		return
+	}
+	lc := models.LoginChallenge{
+		UserID:    user.ID,
+		Hash:      utils.HashToken(challenge),
+		Device:    device,
+		ExpiresAt: time.Now().Add(LOGIN_CHALLENGE_TTL),
+	}
+	if err := db.Create(&lc).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to start login")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Two-factor code required",
+		"mfa_required": true,
+		"challenge":    challenge,
+		"expires_in":   int(LOGIN_CHALLENGE_TTL.Seconds()),
+	})
+}
+
+// consumeTOTP reports whether code is a current code from user's
+// authenticator. An accepted code's time step is recorded, so neither it nor
+// an older one works again.
+func consumeTOTP(db *gorm.DB, user *models.User, code string) bool {
+	step, ok := utils.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+	result := db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// verifySecondFactor accepts either an authenticator code or a recovery code.
+func verifySecondFactor(db *gorm.DB, user *models.User, code string) bool {
+	if len(code) == utils.TOTPDigits {
+		return consumeTOTP(db, user, code)
+	}
+	return consumeRecoveryCode(db, user, code)
+}
+
+// LoginTOTP completes a two-factor login: it exchanges the challenge from
+// Login plus a code for the usual tokens. A challenge works once and allows
+// MAX_CHALLENGE_ATTEMPTS codes.
+func LoginTOTP(c *gin.Context, db *gorm.DB) {
+	var req LoginTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Challenge == "" || req.Code == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "challenge and code are required")
+		return
+	}
+
+	var lc models.LoginChallenge
+	if err := db.Where("hash = ?", utils.HashToken(req.Challenge)).First(&lc).Error; err != nil ||
+		lc.UsedAt != nil || time.Now().After(lc.ExpiresAt) {
+		apierr.Respond(c, apierr.Unauthenticated, "Login expired, please log in again")
+		return
+	}
+
+	// Count the attempt before checking the code, so parallel guesses can't
+	// exceed the limit.
+	result := db.Model(&models.LoginChallenge{}).
+		Where("id = ? AND attempts < ?", lc.ID, MAX_CHALLENGE_ATTEMPTS).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		apierr.Respond(c, apierr.Internal, "Failed to verify code")
+		return
+	}
+	if result.RowsAffected == 0 {
+		apierr.Respond(c, apierr.Unauthenticated, "Too many attempts, please log in again")
+		return
+	}
+
+	var user models.User
+	if err := db.Preload("Boxes").First(&user, lc.UserID).Error; err != nil || !user.TOTPEnabled {
+		apierr.Respond(c, apierr.Unauthenticated, "Login expired, please log in again")
+		return
+	}
+	audit.Actor(c, &user, "")
+
+	if !verifySecondFactor(db, &user, req.Code) {
+		slog.WarnContext(c.Request.Context(), "Failed two-factor attempt", "user_id", user.ID, "ip", c.ClientIP())
+		apierr.Respond(c, apierr.InvalidCredentials, "Invalid code")
+		return
+	}
+
+	result = db.Model(&models.LoginChallenge{}).Where("id = ? AND used_at IS NULL", lc.ID).Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		apierr.Respond(c, apierr.Unauthenticated, "Login expired, please log in again")
+		return
+	}
+
+	completeLogin(c, db, &user, lc.Device)
+}
+
+// EnrollTOTP starts turning on two-factor authentication: it generates a
+// secret and returns it with the otpauth URI for the authenticator app. The
+// second factor isn't required until ConfirmTOTP proves the app has it.
+func EnrollTOTP(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+	if user.TOTPEnabled {
+		apierr.Respond(c, apierr.Conflict, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to start enrollment")
+		return
+	}
+	if err := db.Model(user).Updates(map[string]any{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		apierr.Respond(c, apierr.Internal, "failed to start enrollment")
+		return
+	}
+	jwt.ForgetUser(user.ID)
+
+	slog.InfoContext(c.Request.Context(), "TOTP enrollment started")
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    utils.TOTPURI(TOTP_ISSUER, user.Email, secret),
+	})
+}
+
+// ConfirmTOTP finishes enrollment with a code from the authenticator app and
+// turns two-factor authentication on. The account's recovery codes stand in
+// for the app if it is lost; an account with none left gets a new set now,
+// shown only this once.
+func ConfirmTOTP(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "code is required")
+		return
+	}
+	if user.TOTPEnabled {
+		apierr.Respond(c, apierr.Conflict, "two-factor authentication is already enabled")
+		return
+	}
+	if user.TOTPSecret == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "start enrollment first")
+		return
+	}
+	if !consumeTOTP(db, user, req.Code) {
+		apierr.Respond(c, apierr.InvalidRequest, "invalid code, check the time on your device")
+		return
+	}
+
+	// Without an unused code, losing the app would lock the account out.
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		remaining, err := countRecoveryCodes(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if remaining == 0 {
+			if codes, err = replaceRecoveryCodes(tx, user.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(user).Update("totp_enabled", true).Error
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "TOTP confirm failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to enable two-factor authentication")
+		return
+	}
+	jwt.ForgetUser(user.ID)
+
+	slog.InfoContext(c.Request.Context(), "TOTP enabled")
+	resp := gin.H{"message": "two-factor authentication enabled"}
+	if codes != nil {
+		resp["recovery_codes"] = codes
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DisableTOTP turns two-factor authentication off. It needs a current code or
+// a recovery code, so a stolen session alone can't remove the second factor.
+func DisableTOTP(c *gin.Context, db *gorm.DB) {
+	user := jwt.CurrentUser(c)
+
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		apierr.Respond(c, apierr.InvalidRequest, "code is required")
+		return
+	}
+	if !user.TOTPEnabled {
+		apierr.Respond(c, apierr.InvalidRequest, "two-factor authentication is not enabled")
+		return
+	}
+	if !verifySecondFactor(db, user, req.Code) {
+		slog.WarnContext(c.Request.Context(), "TOTP disable rejected, bad code", "ip", c.ClientIP())
+		apierr.Respond(c, apierr.InvalidCredentials, "invalid code")
+		return
+	}
+
+	// Recovery codes stay: they also authorize password resets.
+	if err := db.Model(user).Updates(map[string]any{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "TOTP disable failed", "error", err)
+		apierr.Respond(c, apierr.Internal, "failed to disable two-factor authentication")
+		return
+	}
+	jwt.ForgetUser(user.ID)
+
+	slog.InfoContext(c.Request.Context(), "TOTP disabled")
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
@@ -0,0 +1,140 @@
+// Package logging sets up the server's structured logs: one JSON object per
+// line from log/slog, at a configurable level. Records logged with a
+// request's context carry that request's ID, route and user, so every line a
+// request writes (in handlers, the storage layer or the S3 client) can be
+// found with its request ID.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/middleware/requestid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ParseLevel reads a LOG_LEVEL value: debug, info, warn or error (any case);
+// "" is info.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+// Setup makes a JSON logger writing to w at level the default for slog and
+// for the standard log package, whose lines become info records.
+func Setup(w io.Writer, level slog.Level) {
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(contextHandler{h}))
+}
+
+type attrsKey struct{}
+
+// With returns a copy of ctx whose log records include attrs, after any it
+// already carried.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	prev, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	all := make([]slog.Attr, 0, len(prev)+len(attrs))
+	return context.WithValue(ctx, attrsKey{}, append(append(all, prev...), attrs...))
+}
+
+// AddAttrs adds attrs to the log records of the rest of c's request.
+func AddAttrs(c *gin.Context, attrs ...slog.Attr) {
+	c.Request = c.Request.WithContext(With(c.Request.Context(), attrs...))
+}
+
+// UserID is the attribute naming the user a request authenticated as.
+func UserID(id uint) slog.Attr {
+	return slog.Uint64("user_id", uint64(id))
+}
+
+// contextHandler adds the attributes With stored in a record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Middleware tags the request's context with its request ID, route and, when
+// it is part of a trace, trace ID, then writes one access log record when it
+// completes: info, or error for a 5xx. It must come after requestid.Middleware
+// and tracing.Middleware. Authentication adds the user.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		AddAttrs(c, slog.String("request_id", requestid.Get(c)), slog.String("route", c.FullPath()))
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			AddAttrs(c, slog.String("trace_id", sc.TraceID().String()))
+		}
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", c.Writer.Status()),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("ip", c.ClientIP()),
+		)
+	}
+}
+
+// InstrumentS3 is an s3.Options function that logs every S3 call with the
+// context it was made with: at debug level normally, and as a warning when it
+// fails. S3's own request ID is included for AWS support cases.
+func InstrumentS3(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("NimbusLogging",
+			func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+				start := time.Now()
+				out, md, err := next.HandleDeserialize(ctx, in)
+
+				level := slog.LevelDebug
+				if err != nil {
+					level = slog.LevelWarn
+				}
+				if !slog.Default().Enabled(ctx, level) {
+					return out, md, err
+				}
+				attrs := []slog.Attr{
+					slog.String("operation", awsmiddleware.GetOperationName(ctx)),
+					slog.Duration("duration", time.Since(start)),
+				}
+				if id, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+					attrs = append(attrs, slog.String("s3_request_id", id))
+				}
+				if err != nil {
+					attrs = append(attrs, slog.Any("error", err))
+				}
+				slog.LogAttrs(ctx, level, "s3 call", attrs...)
+				return out, md, err
+			}), middleware.Before)
+	})
+}
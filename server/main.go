@@ -1,12 +1,25 @@
 // main.go is the entry point for the Nimbus API server.
-// It simply calls InitServer(), which wires everything up and starts listening.
+// With no arguments it calls InitServer(), which wires everything up and
+// starts listening; with arguments it runs an admin command such as
+// "keys rotate" (see server.RunCommand).
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/nimbus/api/server-init"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := server.RunCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	err := server.InitServer()
 	if err != nil {
 		panic(err)
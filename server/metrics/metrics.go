@@ -0,0 +1,181 @@
+// Package metrics exposes the API's Prometheus metrics: requests per route
+// template and status, S3 calls per operation, the database connection pool,
+// the auth rate limiter's decisions, bytes handed out in presigned URLs and
+// uploads still waiting to be confirmed. Everything is registered on Registry
+// and served by Handler.
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+const namespace = "nimbus"
+
+// Directions of a presigned transfer, for AddPresigned.
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Registry holds every Nimbus metric, plus the Go runtime and process ones.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "http", Name: "requests_total",
+		Help: "HTTP requests handled, by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "http", Name: "request_duration_seconds",
+		Help:    "Time to handle an HTTP request, by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	s3Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "s3", Name: "request_duration_seconds",
+		Help:    "Time for one attempt of an S3 call, by operation.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"operation"})
+
+	s3Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "s3", Name: "errors_total",
+		Help: "S3 call attempts that failed, by operation.",
+	}, []string{"operation"})
+
+	rateLimit = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "ratelimit", Name: "requests_total",
+		Help: "Requests checked by the rate limiter, by result (allowed or denied).",
+	}, []string{"result"})
+
+	presignedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "presign", Name: "bytes_total",
+		Help: "Bytes of the files presigned URLs were issued for, by direction.",
+	}, []string{"direction"})
+
+	pendingUploads = prometheus.NewDesc(prometheus.BuildFQName(namespace, "uploads", "pending"),
+		"Files with a presigned upload URL that haven't been confirmed yet.", nil, nil)
+)
+
+// metricsDB is the database UseDB reports on.
+var metricsDB atomic.Pointer[gorm.DB]
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, s3Duration, s3Errors, rateLimit, presignedBytes,
+		dbCollector{},
+	)
+}
+
+// UseDB reports db's connection pool and pending uploads from now on.
+func UseDB(db *gorm.DB) {
+	metricsDB.Store(db)
+}
+
+// dbCollector reads the database at scrape time. It describes no metrics up
+// front (an unchecked collector) because the pool is only known once UseDB
+// has been called.
+type dbCollector struct{}
+
+func (dbCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (dbCollector) Collect(ch chan<- prometheus.Metric) {
+	db := metricsDB.Load()
+	if db == nil {
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		collectors.NewDBStatsCollector(sqlDB, namespace).Collect(ch)
+	}
+	var pending int64
+	if err := db.Model(&models.File{}).Where("confirmed = ?", false).Count(&pending).Error; err == nil {
+		ch <- prometheus.MustNewConstMetric(pendingUploads, prometheus.GaugeValue, float64(pending))
+	}
+}
+
+// Middleware counts and times every request under its route template, so
+// "/v1/api/files/:key" is one series however many keys are requested.
+// Requests no route matched share the route "unmatched" and no method, which
+// keeps scanners from creating new series.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		method, route := c.Request.Method, c.FullPath()
+		if route == "" {
+			method, route = "", "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(method, route, status).Inc()
+		httpDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// InstrumentS3 is an s3.Options function that times every S3 call and counts
+// its failures. Each retry is measured as its own attempt; presigning makes no
+// call and isn't measured.
+func InstrumentS3(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("NimbusMetrics",
+			func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+				start := time.Now()
+				out, md, err := next.HandleDeserialize(ctx, in)
+				op := awsmiddleware.GetOperationName(ctx)
+				s3Duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+				if err != nil {
+					s3Errors.WithLabelValues(op).Inc()
+				}
+				return out, md, err
+			}), middleware.Before)
+	})
+}
+
+// RateLimited records one rate limiter decision.
+func RateLimited(allowed bool) {
+	result := "allowed"
+	if !allowed {
+		result = "denied"
+	}
+	rateLimit.WithLabelValues(result).Inc()
+}
+
+// AddPresigned records a presigned URL issued for a file of size bytes;
+// direction is Upload or Download.
+func AddPresigned(direction string, size int64) {
+	presignedBytes.WithLabelValues(direction).Add(float64(size))
+}
+
+// Handler serves Registry in the Prometheus text format. When token is set the
+// scraper must send it as "Authorization: Bearer <token>".
+func Handler(token string) gin.HandlerFunc {
+	h := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	return func(c *gin.Context) {
+		if token != "" {
+			got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				apierr.Abort(c, apierr.Unauthenticated, "invalid metrics token")
+				return
+			}
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
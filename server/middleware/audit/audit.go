@@ -0,0 +1,155 @@
+// Package audit records every data and auth operation in the append-only
+// audit_events table, so "who deleted this and when" has an answer that
+// outlives the process logs.
+//
+// REST routes get an event per request from Action, which runs the handler
+// and then records the outcome (requests rejected by jwt.Authenticate or
+// jwt.RequireAdmin get an auth.denied event from Denied instead); handlers
+// add what only they know — the box and path they acted on, the account a
+// login was for — with Target, Detail and Actor. Protocol handlers that
+// serve many operations on one route (WebDAV, the S3 gateway, SFTP) name each
+// event themselves with Record or Write.
+package audit
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/middleware/requestid"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/tracing"
+	"gorm.io/gorm"
+)
+
+// Results an event can have.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure" // bad input, missing target or a server error
+	ResultDenied  = "denied"  // authentication or authorization failed
+)
+
+// Protocols an event can arrive over.
+const (
+	ProtocolAPI    = "api"
+	ProtocolWebDAV = "webdav"
+	ProtocolS3     = "s3"
+	ProtocolSFTP   = "sftp"
+
+	// ProtocolConsole is the server's own command line (see server.RunCommand).
+	ProtocolConsole = "console"
+)
+
+const eventKey = "nimbus.audit_event"
+
+// Action returns middleware that records one event named action for every
+// request to the route, once the handler has responded. The box defaults to
+// the box_name query parameter, and the detail to the :id route parameter
+// (the session, token or key a request revokes).
+func Action(db *gorm.DB, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		e := &models.AuditEvent{Action: action, Protocol: ProtocolAPI, Box: c.Query("box_name")}
+		if id := c.Param("id"); id != "" {
+			e.Detail = "id: " + id
+		}
+		c.Set(eventKey, e)
+		c.Next()
+		Record(c, db, e)
+	}
+}
+
+// Denied returns middleware that records an auth.denied event for requests
+// that jwt.Authenticate or jwt.RequireAdmin turns away, which never reach the
+// route's Action. It must come before them.
+func Denied(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if event(c) == nil && c.IsAborted() && ResultFor(c.Writer.Status()) == ResultDenied {
+			Record(c, db, &models.AuditEvent{
+				Action: "auth.denied",
+				Detail: c.Request.Method + " " + c.Request.URL.Path,
+			})
+		}
+	}
+}
+
+// Target sets the box and path the request acted on.
+func Target(c *gin.Context, box, path string) {
+	if e := event(c); e != nil {
+		e.Box, e.Path = box, path
+	}
+}
+
+// Detail adds free-form context to the request's event, such as a rename's
+// new name.
+func Detail(c *gin.Context, format string, args ...any) {
+	if e := event(c); e != nil {
+		e.Detail = fmt.Sprintf(format, args...)
+	}
+}
+
+// Actor names the account the request was for, on routes without
+// jwt.Authenticate (login, registration, password reset). user may be
+// nil when only the email is known.
+func Actor(c *gin.Context, user *models.User, email string) {
+	if e := event(c); e != nil {
+		if user != nil {
+			e.UserID, email = user.ID, user.Email
+		}
+		e.Actor = email
+	}
+}
+
+// Record fills in e from the request — caller, IP, user agent, request ID and
+// the response status — and writes it.
+func Record(c *gin.Context, db *gorm.DB, e *models.AuditEvent) {
+	if e.UserID == 0 {
+		if u := jwt.CurrentUser(c); u != nil {
+			e.UserID, e.Actor = u.ID, u.Email
+		}
+	}
+	if e.Protocol == "" {
+		e.Protocol = ProtocolAPI
+	}
+	e.Status = c.Writer.Status()
+	if e.Result == "" {
+		e.Result = ResultFor(e.Status)
+	}
+	e.IP = c.ClientIP()
+	e.UserAgent = c.Request.UserAgent()
+	if e.RequestID == "" {
+		e.RequestID = requestid.Get(c)
+	}
+	tracing.SetBox(c.Request.Context(), e.Box)
+	Write(db.WithContext(c.Request.Context()), e)
+}
+
+// ResultFor maps an HTTP status onto an event result.
+func ResultFor(status int) string {
+	switch {
+	case status < http.StatusBadRequest:
+		return ResultSuccess
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ResultDenied
+	default:
+		return ResultFailure
+	}
+}
+
+// Write appends e to the audit log. A failed write is logged rather than
+// returned: the operation has already happened and its response is on the
+// way.
+func Write(db *gorm.DB, e *models.AuditEvent) {
+	if err := db.Create(e).Error; err != nil {
+		slog.Error("Failed to record audit event", "action", e.Action, "request_id", e.RequestID, "error", err)
+	}
+}
+
+func event(c *gin.Context) *models.AuditEvent {
+	if v, ok := c.Get(eventKey); ok {
+		return v.(*models.AuditEvent)
+	}
+	return nil
+}
@@ -6,11 +6,14 @@
 //
 // It targets JSON/API request bodies (login, register, password reset, etc.).
 // File uploads are not affected because they go directly to S3 via presigned
-// URLs and never pass their bytes through this server.
+// URLs and never pass their bytes through this server. The one exception is the
+// WebDAV mount, whose PUTs stream file data through the API; MiddlewareExcept
+// exempts it, and the dav handler enforces the upload size cap itself.
 package bodylimit
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -35,3 +38,20 @@ func Middleware(maxBytes int64) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// MiddlewareExcept is Middleware for every path except those under one of the
+// given prefixes (matched on whole path segments, so "/dav" covers "/dav" and
+// "/dav/x" but not "/davx"). Exempt routes must bound their own bodies.
+func MiddlewareExcept(maxBytes int64, prefixes ...string) gin.HandlerFunc {
+	limit := Middleware(maxBytes)
+	return func(c *gin.Context) {
+		p := c.Request.URL.Path
+		for _, prefix := range prefixes {
+			if p == prefix || strings.HasPrefix(p, prefix+"/") {
+				c.Next()
+				return
+			}
+		}
+		limit(c)
+	}
+}
@@ -2,6 +2,7 @@ package bodylimit
 
 import (
 	"bytes"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -61,3 +62,31 @@ func TestBodyLimit_DefaultWhenNonPositive(t *testing.T) {
 	w := post(r, []byte(`{"data":"hello"}`))
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func TestBodyLimitExcept_SkipsExemptPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(MiddlewareExcept(64, "/dav"))
+	readAll := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	r.PUT("/dav/*path", readAll)
+	r.PUT("/davx", readAll)
+	r.PUT("/x", readAll)
+
+	big := strings.Repeat("A", 500)
+	for path, want := range map[string]int{
+		"/dav/box/file.bin": http.StatusOK,
+		"/davx":             http.StatusRequestEntityTooLarge,
+		"/x":                http.StatusRequestEntityTooLarge,
+	} {
+		req, _ := http.NewRequest(http.MethodPut, path, strings.NewReader(big))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code, path)
+	}
+}
@@ -0,0 +1,151 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidCredentials is returned by AuthenticateBasic for any credential
+// problem. Callers answer with a single 401 so the response never reveals
+// whether the email, the token, or the app password was wrong.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// appPasswordTouchInterval limits how often LastUsedAt is written. WebDAV
+// clients authenticate every request, and a write per PROPFIND would be wasted.
+const appPasswordTouchInterval = time.Minute
+
+// AuthenticateBasic identifies the caller of a protocol that can't use the
+// Bearer flow (WebDAV and other mount-style clients). It accepts:
+//   - Basic auth with the account email as the username and either a JWT from
+//     /login or an app password as the password, or
+//   - a plain "Authorization: Bearer <jwt>" header, for scripted clients.
+//
+// Unlike Authenticate it writes no response, because the protocol decides
+// how a 401 looks (WebDAV needs a WWW-Authenticate challenge).
+func AuthenticateBasic(r *http.Request, db *gorm.DB) (*models.User, error) {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return userFromJWT(db, bearer, "")
+	}
+
+	email, secret, ok := r.BasicAuth()
+	if !ok || email == "" || secret == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	// JWTs are three dot-separated segments; app passwords never contain dots.
+	if strings.Count(secret, ".") == 2 {
+		return userFromJWT(db, secret, email)
+	}
+
+	return userFromAppPassword(db, email, secret)
+}
+
+// AuthenticatePassword checks an email and password for protocols that run
+// their own handshake instead of HTTP (SFTP). The password may be an app
+// password or the account password; app passwords are tried first because
+// they cost a hash lookup rather than an Argon2id comparison. Accounts with
+// two-factor authentication only accept app passwords: the protocol has no
+// way to ask for a code, and the password alone must not be enough.
+func AuthenticatePassword(db *gorm.DB, email, password string) (*models.User, error) {
+	if email == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if user, err := userFromAppPassword(db, email, password); err == nil {
+		return user, nil
+	}
+
+	var user models.User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		// Compare anyway so an unknown email takes as long as a wrong password.
+		utils.VerifyDummyPassword(password)
+		return nil, ErrInvalidCredentials
+	}
+	if !utils.VerifyPasswordHash(password, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+	if user.Suspended() {
+		return nil, ErrAccountSuspended
+	}
+	// An admin has asked for a new password; app passwords and keys still work.
+	if user.PasswordResetRequired {
+		return nil, ErrInvalidCredentials
+	}
+	// Checked after the comparison so a refusal looks like a wrong password
+	// and doesn't reveal that the account has two-factor enabled.
+	if user.TOTPEnabled {
+		return nil, ErrInvalidCredentials
+	}
+	UpgradePasswordHash(db, &user, password)
+	return &user, nil
+}
+
+// UpgradePasswordHash re-hashes a password that was just verified, if its
+// hash is bcrypt or uses older Argon2id parameters, so stored hashes move to
+// the current scheme as users log in. A failure only means the old hash is
+// kept until the next login.
+func UpgradePasswordHash(db *gorm.DB, user *models.User, password string) {
+	if !utils.PasswordNeedsRehash(user.Password) {
+		return
+	}
+	hash, err := utils.PasswordHash(password)
+	if err != nil {
+		slog.Error("Failed to upgrade password hash", "user_id", user.ID, "error", err)
+		return
+	}
+	// Matching the old hash keeps a password changed in the meantime.
+	res := db.Model(&models.User{}).Where("id = ? AND password = ?", user.ID, user.Password).Update("password", hash)
+	if res.Error != nil {
+		slog.Error("Failed to upgrade password hash", "user_id", user.ID, "error", res.Error)
+		return
+	}
+	if res.RowsAffected == 1 {
+		user.Password = hash
+		ForgetUser(user.ID)
+	}
+}
+
+// userFromAppPassword loads the user owning the app password secret, which
+// must belong to the account email.
+func userFromAppPassword(db *gorm.DB, email, secret string) (*models.User, error) {
+	var ap models.AppPassword
+	if err := db.Where("hash = ?", utils.HashToken(secret)).First(&ap).Error; err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	user, err := loadUser(db, ap.UserID)
+	if err != nil || user.Email != email {
+		return nil, ErrInvalidCredentials
+	}
+	if user.Suspended() {
+		return nil, ErrAccountSuspended
+	}
+
+	if ap.LastUsedAt == nil || time.Since(*ap.LastUsedAt) > appPasswordTouchInterval {
+		db.Model(&ap).UpdateColumn("last_used_at", time.Now())
+	}
+	return user, nil
+}
+
+// userFromJWT verifies token and loads its user. When email is non-empty the
+// token must belong to that account.
+func userFromJWT(db *gorm.DB, token, email string) (*models.User, error) {
+	claims, err := ParseToken(token)
+	if err != nil || IsSessionRevoked(context.Background(), db, claims.SessionID) {
+		return nil, ErrInvalidCredentials
+	}
+	user, err := loadUser(db, claims.UserID)
+	if err != nil || (email != "" && user.Email != email) {
+		return nil, ErrInvalidCredentials
+	}
+	if user.Suspended() {
+		return nil, ErrAccountSuspended
+	}
+	return user, nil
+}
@@ -1,50 +1,56 @@
 // Package jwt handles creating, verifying, and reading JWT tokens used to
-// authenticate CLI requests to the API.
+// authenticate CLI requests to the API, and the keyset they are signed with.
 package jwt
 
 import (
+	"errors"
 	"fmt"
-	"net/http"
+	"log/slog"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/logging"
 	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/tracing"
 	"github.com/nimbus/api/utils"
 	"gorm.io/gorm"
 )
 
-var secretKey []byte
-
-// MIN_SECRET_BYTES is the minimum accepted JWT_SECRET length. For HS256 the
-// secret is the entire security of authentication, so we require at least 32
-// bytes (256 bits) of key material. Longer secrets are fine and encouraged.
-const MIN_SECRET_BYTES int = 32
-
-func init() {
-	secret, err := utils.GetEnv("JWT_SECRET")
+// ACCESS_TOKEN_TTL is how long a JWT from CreateToken stays valid. It is kept
+// short because the CLI renews it with a refresh token (see user.Refresh).
+const ACCESS_TOKEN_TTL = 15 * time.Minute
+
+// CreateToken issues a signed JWT for the given email and userID, belonging to
+// the login session sessionID (see models.Session). Each token gets a unique
+// "jti" and carries the session as "sid" so it can be revoked with it.
+// The token expires after ACCESS_TOKEN_TTL and is signed with the keyset's
+// active key, named in the "kid" header (see keyset.go).
+func CreateToken(email, userID, sessionID string) (string, error) {
+	kid, method, key, err := currentKeys().signingKey()
 	if err != nil {
-		panic("JWT_SECRET is not set: " + err.Error())
+		return "", err
 	}
-	secretKey = []byte(secret)
-
-	if len(secretKey) < MIN_SECRET_BYTES {
-		panic(fmt.Sprintf("JWT_SECRET must be at least %d bytes long (got %d)", MIN_SECRET_BYTES, len(secretKey)))
+	jti, err := utils.GenerateTokenID()
+	if err != nil {
+		return "", err
 	}
-}
-
-// CreateToken issues a signed JWT for the given email and userID.
-// The token expires after 24 hours and is signed with HS256 using JWT_SECRET.
-func CreateToken(email, userID string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256,
+	token := jwt.NewWithClaims(method,
 		jwt.MapClaims{
 			"user_id": userID,
 			"email":   email,
-			"exp":     time.Now().Add(time.Hour * 24).Unix(),
+			"sid":     sessionID,
+			"jti":     jti,
+			"exp":     time.Now().Add(ACCESS_TOKEN_TTL).Unix(),
 		})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
 
-	tokenString, err := token.SignedString(secretKey)
+	tokenString, err := token.SignedString(key)
 	if err != nil {
 		return "", err
 	}
@@ -52,80 +58,163 @@ func CreateToken(email, userID string) (string, error) {
 	return tokenString, nil
 }
 
-// VerifyToken checks that tokenString is a valid, unexpired JWT signed with
-// JWT_SECRET. It also rejects tokens that weren't signed with HMAC (HS256) to
-// prevent the "alg:none" attack where an attacker strips the signature.
+// VerifyToken checks that tokenString is a valid, unexpired JWT signed by a
+// key in the keyset, with that key's algorithm. Pinning the algorithm to the
+// key prevents the "alg:none" attack where an attacker strips the signature,
+// and algorithm confusion between keys.
 func VerifyToken(tokenString string) error {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Reject any token whose header says it uses a non-HMAC algorithm.
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
+	_, err := parseClaims(tokenString)
+	return err
+}
 
+// Claims are the identity fields of a verified login JWT.
+type Claims struct {
+	UserID    uint
+	Email     string
+	SessionID string // the login session, see models.Session
+}
+
+// ParseToken verifies tokenString like VerifyToken and returns its claims.
+func ParseToken(tokenString string) (*Claims, error) {
+	claims, err := parseClaims(tokenString)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	if !token.Valid {
-		return fmt.Errorf("invalid token")
+	// CreateToken writes the user ID as a decimal string.
+	idClaim, _ := claims["user_id"].(string)
+	userID, err := strconv.ParseUint(idClaim, 10, 64)
+	if err != nil || userID == 0 {
+		return nil, fmt.Errorf("invalid token claims")
 	}
-
-	return nil
+	sid, _ := claims["sid"].(string)
+	if sid == "" {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	email, _ := claims["email"].(string)
+	return &Claims{UserID: uint(userID), Email: email, SessionID: sid}, nil
 }
 
-// GetEmailFromToken decodes the token's claims and returns the email field.
-// Assumes the token has already been verified with VerifyToken.
-func GetEmailFromToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secretKey, nil
-	})
+// parseClaims verifies tokenString and returns its claims.
+func parseClaims(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, keyFor)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if email, ok := claims["email"].(string); ok {
-			return email, nil
-		}
+		return claims, nil
 	}
-	return "", fmt.Errorf("invalid token claims")
+	return nil, fmt.Errorf("invalid token claims")
+}
+
+// Principal is who a request authenticated as: the user, and the credential
+// they used.
+type Principal struct {
+	User        *models.User
+	SessionID   string                      // login session; "" for access tokens
+	AccessToken *models.PersonalAccessToken // nil for login JWTs
+}
+
+const principalKey = "nimbus.principal"
+
+// authError is a rejected credential and the response it gets.
+type authError struct {
+	code    apierr.Code
+	message string
+}
+
+func (e *authError) Error() string { return e.message }
+
+func unauthorized(message string) *authError {
+	return &authError{code: apierr.Unauthenticated, message: message}
+}
+
+// ErrAccountSuspended is returned for every credential of a user an admin has
+// suspended (see models.User.Suspended).
+var ErrAccountSuspended = errors.New("account suspended")
+
+func accountSuspended() *authError {
+	return &authError{code: apierr.AccountSuspended, message: ErrAccountSuspended.Error()}
 }
 
-// AuthenticateUser is a convenience helper used by every protected handler.
-// It reads the "Authorization: Bearer <token>" header, verifies the token,
-// extracts the email, and looks up the full User record in the database.
-// On any failure it writes the appropriate HTTP error response and returns nil.
-func AuthenticateUser(c *gin.Context, db *gorm.DB) (*models.User, error) {
-	authToken := c.GetHeader("Authorization")
-	if authToken == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authorization token"})
-		return nil, fmt.Errorf("missing token")
+// Authenticate is the middleware in front of every protected /v1/api route.
+// It reads the "Authorization: Bearer <token>" header — a login JWT or a
+// personal access token ("nim_pat_...") — and either stores the caller's
+// Principal for the handler (see CurrentUser) or answers 401/403 itself and
+// stops the chain. Login JWTs must belong to a live session; access tokens
+// are checked against their expiry, scopes and box restriction.
+func Authenticate(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := authenticate(c, db)
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "Authentication rejected", "reason", err.message, "ip", c.ClientIP())
+			apierr.Abort(c, err.code, err.message)
+			return
+		}
+		c.Set(principalKey, p)
+		logging.AddAttrs(c, logging.UserID(p.User.ID))
+		tracing.SetUser(c.Request.Context(), p.User.ID)
+		c.Next()
 	}
+}
 
-	// Strip the "Bearer " prefix so we're left with just the raw token string.
-	authToken = strings.TrimPrefix(authToken, "Bearer ")
+func authenticate(c *gin.Context, db *gorm.DB) (*Principal, *authError) {
+	db = db.WithContext(c.Request.Context())
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return nil, unauthorized("missing authorization token")
+	}
+	raw := strings.TrimPrefix(header, "Bearer ")
 
-	if err := VerifyToken(authToken); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-		return nil, err
+	if strings.HasPrefix(raw, utils.PersonalAccessTokenPrefix) {
+		return authenticateAccessToken(c, db, raw)
 	}
 
-	email, err := GetEmailFromToken(authToken)
+	claims, err := ParseToken(raw)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to extract email from token"})
-		return nil, err
+		return nil, unauthorized("invalid token")
+	}
+	if IsSessionRevoked(c.Request.Context(), db, claims.SessionID) {
+		return nil, unauthorized("session revoked, please log in again")
+	}
+	user, err := loadUser(db, claims.UserID)
+	if err != nil {
+		return nil, unauthorized("invalid token")
+	}
+	if user.Suspended() {
+		return nil, accountSuspended()
 	}
 
-	var user models.User
-	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		return nil, err
+	touchSession(db, claims.SessionID, c.ClientIP())
+	return &Principal{User: user, SessionID: claims.SessionID}, nil
+}
+
+// RequireAdmin rejects requests from users without the admin role. It must
+// come after Authenticate.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if u := CurrentUser(c); u == nil || !u.IsAdmin() {
+			slog.WarnContext(c.Request.Context(), "Authentication rejected", "reason", "not an admin", "ip", c.ClientIP())
+			apierr.Abort(c, apierr.Forbidden, "admin role required")
+			return
+		}
+		c.Next()
 	}
+}
 
-	return &user, nil
+// CurrentPrincipal returns who the request authenticated as, or nil on routes
+// without Authenticate.
+func CurrentPrincipal(c *gin.Context) *Principal {
+	if v, ok := c.Get(principalKey); ok {
+		return v.(*Principal)
+	}
+	return nil
+}
+
+// CurrentUser returns the authenticated user, or nil on routes without
+// Authenticate. Handlers behind Authenticate can rely on it being set.
+func CurrentUser(c *gin.Context) *models.User {
+	if p := CurrentPrincipal(c); p != nil {
+		return p.User
+	}
+	return nil
 }
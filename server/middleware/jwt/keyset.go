@@ -0,0 +1,333 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"gorm.io/gorm"
+)
+
+// Algorithms a key in the signing_keys table can use. HS256 is only used with
+// the shared JWT_SECRET.
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+// KEY_RELOAD_INTERVAL is how often WatchKeys rereads the keyset, and
+// KEY_PUBLISH_DELAY how long RotateKeys publishes a new key before it signs
+// anything by default. The delay must comfortably exceed the reload interval
+// so every instance can verify a new key's tokens before any are issued.
+const (
+	KEY_RELOAD_INTERVAL = time.Minute
+	KEY_PUBLISH_DELAY   = 5 * time.Minute
+)
+
+// keyClockSkew is added to how long a rotated-out key keeps verifying, for
+// servers whose clocks disagree.
+const keyClockSkew = time.Minute
+
+const rsaKeyBits = 3072
+
+// MIN_SECRET_BYTES is the minimum accepted JWT_SECRET length. For HS256 the
+// secret is the entire security of authentication, so we require at least 32
+// bytes (256 bits) of key material. Longer secrets are fine and encouraged.
+const MIN_SECRET_BYTES int = 32
+
+var errNoSigningKey = errors.New("no active signing key")
+
+// verifyKey is a key tokens can be verified with, and the one algorithm it
+// accepts.
+type verifyKey struct {
+	alg string
+	key any // ed25519.PublicKey, *rsa.PublicKey, or the JWT_SECRET bytes
+}
+
+// keyset is the keys in use at one moment.
+type keyset struct {
+	signKID    string // "" when signing with JWT_SECRET
+	signMethod jwt.SigningMethod
+	signKey    any
+	verify     map[string]verifyKey // by kid
+	jwks       []map[string]string  // public keys, newest first
+}
+
+var (
+	keysMu sync.RWMutex
+	keys   *keyset
+
+	// legacySecret is JWT_SECRET, or nil. It verifies tokens without a "kid"
+	// (issued before keys moved to the database) and signs when no database
+	// keys are loaded.
+	legacySecret []byte
+)
+
+func init() {
+	if secret, _ := utils.GetEnv("JWT_SECRET"); secret != "" {
+		if len(secret) < MIN_SECRET_BYTES {
+			panic(fmt.Sprintf("JWT_SECRET must be at least %d bytes long (got %d)", MIN_SECRET_BYTES, len(secret)))
+		}
+		legacySecret = []byte(secret)
+	}
+	keys = secretKeyset()
+}
+
+// secretKeyset signs and verifies with JWT_SECRET alone.
+func secretKeyset() *keyset {
+	ks := &keyset{verify: map[string]verifyKey{}}
+	if legacySecret != nil {
+		ks.signMethod, ks.signKey = jwt.SigningMethodHS256, legacySecret
+	}
+	return ks
+}
+
+func currentKeys() *keyset {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return keys
+}
+
+// signingKey returns what CreateToken signs with.
+func (ks *keyset) signingKey() (string, jwt.SigningMethod, any, error) {
+	if ks.signMethod == nil {
+		return "", nil, nil, errNoSigningKey
+	}
+	return ks.signKID, ks.signMethod, ks.signKey, nil
+}
+
+// keyFor is the jwt.Keyfunc for every token Nimbus verifies. A token names
+// its key with "kid" and must use that key's algorithm, so a token can't
+// pick a weaker algorithm (or "none") for a key; tokens without a kid can
+// only be HS256 with JWT_SECRET.
+func keyFor(token *jwt.Token) (any, error) {
+	ks := currentKeys()
+	kid, _ := token.Header["kid"].(string)
+
+	var k verifyKey
+	if kid == "" {
+		if legacySecret == nil {
+			return nil, fmt.Errorf("token has no key ID")
+		}
+		k = verifyKey{alg: jwt.SigningMethodHS256.Alg(), key: legacySecret}
+	} else {
+		var ok bool
+		if k, ok = ks.verify[kid]; !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+	if token.Method.Alg() != k.alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return k.key, nil
+}
+
+// LoadKeys replaces the keyset with the unexpired keys in db. The newest key
+// that has activated signs; JWT_SECRET only signs if there is none. LoadKeys
+// with a nil db goes back to JWT_SECRET alone.
+func LoadKeys(db *gorm.DB) error {
+	if db == nil {
+		keysMu.Lock()
+		keys = secretKeyset()
+		keysMu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	var rows []models.SigningKey
+	if err := db.Where("expires_at IS NULL OR expires_at > ?", now).
+		Order("activates_at DESC").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	ks := secretKeyset()
+	for _, row := range rows {
+		priv, pub, method, err := parseSigningKey(row)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", row.KID, err)
+		}
+		ks.verify[row.KID] = verifyKey{alg: row.Algorithm, key: pub}
+		ks.jwks = append(ks.jwks, publicJWK(row.KID, row.Algorithm, pub))
+		if ks.signKID == "" && !row.ActivatesAt.After(now) {
+			ks.signKID, ks.signMethod, ks.signKey = row.KID, method, priv
+		}
+	}
+	if ks.signMethod == nil {
+		return errNoSigningKey
+	}
+
+	keysMu.Lock()
+	keys = ks
+	keysMu.Unlock()
+	return nil
+}
+
+// WatchKeys reloads the keyset every KEY_RELOAD_INTERVAL until ctx is done,
+// so rotations made by another instance (or "nimbus keys rotate") are picked
+// up without a restart. A failed reload keeps the current keys.
+func WatchKeys(ctx context.Context, db *gorm.DB) {
+	ticker := time.NewTicker(KEY_RELOAD_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := LoadKeys(db); err != nil {
+				slog.Error("Signing key reload failed, keeping the current keys", "component", "keys", "error", err)
+			}
+		}
+	}
+}
+
+// EnsureSigningKey creates a first key using alg if db has no unexpired keys,
+// so a new deployment signs with a database key from its first token.
+func EnsureSigningKey(db *gorm.DB, alg string) error {
+	var n int64
+	if err := db.Model(&models.SigningKey{}).Where("expires_at IS NULL OR expires_at > ?", time.Now()).Count(&n).Error; err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	key, err := newSigningKey(alg, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := db.Create(key).Error; err != nil {
+		return err
+	}
+	slog.Info("Created signing key", "component", "keys", "kid", key.KID, "alg", key.Algorithm)
+	return nil
+}
+
+// RotateOptions controls RotateKeys.
+type RotateOptions struct {
+	Algorithm string        // AlgEdDSA or AlgRS256
+	Delay     time.Duration // how long the new key is published before it signs
+	// Revoke makes the new key sign at once and expires every other key
+	// immediately, logging everyone out: for a key that may have leaked.
+	Revoke bool
+}
+
+// RotateKeys adds a new signing key. It takes over signing after opts.Delay;
+// the keys it replaces keep verifying until the tokens they signed by then
+// have expired, so nobody is logged out.
+func RotateKeys(db *gorm.DB, opts RotateOptions) (*models.SigningKey, error) {
+	now := time.Now()
+	if opts.Revoke {
+		opts.Delay = 0
+	}
+	key, err := newSigningKey(opts.Algorithm, now.Add(opts.Delay))
+	if err != nil {
+		return nil, err
+	}
+
+	oldExpiry := key.ActivatesAt.Add(ACCESS_TOKEN_TTL + keyClockSkew)
+	if opts.Revoke {
+		oldExpiry = now
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.SigningKey{}).
+			Where("expires_at IS NULL OR expires_at > ?", oldExpiry).
+			Update("expires_at", oldExpiry).Error; err != nil {
+			return err
+		}
+		return tx.Create(key).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set (RFC 7517).
+func JWKS() map[string]any {
+	ks := currentKeys()
+	set := ks.jwks
+	if set == nil {
+		set = []map[string]string{}
+	}
+	return map[string]any{"keys": set}
+}
+
+// newSigningKey generates a key for alg that activates at activatesAt.
+func newSigningKey(alg string, activatesAt time.Time) (*models.SigningKey, error) {
+	var priv any
+	var err error
+	switch alg {
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q (use %s or %s)", alg, AlgEdDSA, AlgRS256)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	var kid [8]byte
+	if _, err := rand.Read(kid[:]); err != nil {
+		return nil, err
+	}
+	return &models.SigningKey{
+		KID:         hex.EncodeToString(kid[:]),
+		Algorithm:   alg,
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// parseSigningKey decodes a stored key and checks it matches its algorithm.
+func parseSigningKey(row models.SigningKey) (priv, pub any, method jwt.SigningMethod, err error) {
+	block, _ := pem.Decode([]byte(row.PrivateKey))
+	if block == nil {
+		return nil, nil, nil, fmt.Errorf("private key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		if row.Algorithm == AlgEdDSA {
+			return k, k.Public(), jwt.SigningMethodEdDSA, nil
+		}
+	case *rsa.PrivateKey:
+		if row.Algorithm == AlgRS256 {
+			return k, &k.PublicKey, jwt.SigningMethodRS256, nil
+		}
+	}
+	return nil, nil, nil, fmt.Errorf("key type %T doesn't match algorithm %q", parsed, row.Algorithm)
+}
+
+// publicJWK encodes pub as a JSON Web Key.
+func publicJWK(kid, alg string, pub any) map[string]string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwk := map[string]string{"kid": kid, "alg": alg, "use": "sig"}
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		jwk["kty"], jwk["crv"], jwk["x"] = "OKP", "Ed25519", b64(k)
+	case *rsa.PublicKey:
+		jwk["kty"], jwk["n"], jwk["e"] = "RSA", b64(k.N.Bytes()), b64(big.NewInt(int64(k.E)).Bytes())
+	}
+	return jwk
+}
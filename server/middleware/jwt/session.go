@@ -0,0 +1,130 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/models"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// sessionTouchInterval limits how often a session's LastSeenAt is written.
+const sessionTouchInterval = time.Minute
+
+// revokedSessionPrefix namespaces the revocation list in Redis. An entry only
+// needs to outlive the access tokens already issued for the session, so each
+// expires after ACCESS_TOKEN_TTL.
+const revokedSessionPrefix = "nimbus:revoked-session:"
+
+// revocations is the Redis client holding the revocation list, or nil to
+// check the sessions table on every request.
+var revocations *redis.Client
+
+// UseRevocationStore makes the auth middleware check revoked sessions in Redis
+// instead of the database. Lookups fall back to the database if Redis fails.
+func UseRevocationStore(rdb *redis.Client) {
+	revocations = rdb
+}
+
+// SessionID returns the login session the request authenticated with, or ""
+// for personal access tokens.
+func SessionID(c *gin.Context) string {
+	if p := CurrentPrincipal(c); p != nil {
+		return p.SessionID
+	}
+	return ""
+}
+
+// IsSessionRevoked reports whether sessionID has been revoked. A session that
+// can't be checked (database down with Redis unavailable) counts as revoked.
+func IsSessionRevoked(ctx context.Context, db *gorm.DB, sessionID string) bool {
+	if revocations != nil {
+		n, err := revocations.Exists(ctx, revokedSessionPrefix+sessionID).Result()
+		if err == nil {
+			return n > 0
+		}
+		slog.WarnContext(ctx, "Redis revocation check failed, using database", "error", err)
+	}
+
+	var s models.Session
+	err := db.Select("id").Where("session_id = ? AND revoked_at IS NOT NULL", sessionID).First(&s).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false
+	}
+	return true
+}
+
+// RevokeSession ends one of userID's sessions: its refresh tokens stop working
+// and its access tokens are rejected from the next request on. It reports
+// whether a live session was found.
+func RevokeSession(ctx context.Context, db *gorm.DB, userID uint, sessionID string) (bool, error) {
+	now := time.Now()
+	result := db.Model(&models.Session{}).
+		Where("user_id = ? AND session_id = ? AND revoked_at IS NULL", userID, sessionID).
+		Update("revoked_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, revokeTokens(ctx, db, []string{sessionID}, now)
+}
+
+// RevokeAllSessions revokes every live session of userID except keep, which
+// may be "". It returns how many were revoked.
+func RevokeAllSessions(ctx context.Context, db *gorm.DB, userID uint, keep string) (int, error) {
+	var ids []string
+	query := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if keep != "" {
+		query = query.Where("session_id <> ?", keep)
+	}
+	if err := query.Pluck("session_id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	if err := db.Model(&models.Session{}).Where("session_id IN ?", ids).Update("revoked_at", now).Error; err != nil {
+		return 0, err
+	}
+	return len(ids), revokeTokens(ctx, db, ids, now)
+}
+
+// revokeTokens revokes the refresh tokens of the given sessions and adds the
+// sessions to the Redis revocation list.
+func revokeTokens(ctx context.Context, db *gorm.DB, sessionIDs []string, now time.Time) error {
+	if err := db.Model(&models.RefreshToken{}).
+		Where("session_id IN ? AND revoked_at IS NULL", sessionIDs).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	if revocations == nil {
+		return nil
+	}
+	pipe := revocations.Pipeline()
+	for _, id := range sessionIDs {
+		pipe.Set(ctx, revokedSessionPrefix+id, 1, ACCESS_TOKEN_TTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		// The refresh tokens are revoked regardless, so at worst the
+		// session's current access token works until it expires.
+		slog.ErrorContext(ctx, "Failed to add revoked sessions to Redis", "error", err)
+	}
+	return nil
+}
+
+// touchSession records that sessionID was just used from ip, at most once per
+// sessionTouchInterval unless the IP changed.
+func touchSession(db *gorm.DB, sessionID, ip string) {
+	now := time.Now()
+	db.Model(&models.Session{}).
+		Where("session_id = ? AND (last_seen_at < ? OR ip <> ?)", sessionID, now.Add(-sessionTouchInterval), ip).
+		UpdateColumns(map[string]any{"last_seen_at": now, "ip": ip})
+}
@@ -0,0 +1,87 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nimbus/api/models"
+	"gorm.io/gorm"
+)
+
+// USER_CACHE_TTL is how long the auth middleware reuses a user record once
+// UseUserCache is on. Handlers that change a user call ForgetUser, so the TTL
+// only bounds how stale another server instance can be.
+const USER_CACHE_TTL = 30 * time.Second
+
+// userCacheSweepSize is the entry count at which adding a user first drops
+// the expired ones.
+const userCacheSweepSize = 10000
+
+// userCache holds recently loaded users by ID.
+type userCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[uint]cachedUser
+}
+
+type cachedUser struct {
+	user     models.User
+	loadedAt time.Time
+}
+
+// users is the cache in use, or nil to load the user on every request.
+var users *userCache
+
+// UseUserCache makes authentication keep each user record for ttl instead of
+// loading it from the database on every request. A ttl of 0 turns the cache
+// off.
+func UseUserCache(ttl time.Duration) {
+	if ttl <= 0 {
+		users = nil
+		return
+	}
+	users = &userCache{ttl: ttl, entries: map[uint]cachedUser{}}
+}
+
+// ForgetUser drops userID from the cache. Call it after changing anything on
+// the user's record, or closing the account, so the next request sees it.
+func ForgetUser(userID uint) {
+	if c := users; c != nil {
+		c.mu.Lock()
+		delete(c.entries, userID)
+		c.mu.Unlock()
+	}
+}
+
+// loadUser returns the user with id, from the cache when it is fresh. Every
+// caller gets its own copy, so handlers can modify it.
+func loadUser(db *gorm.DB, id uint) (*models.User, error) {
+	c := users
+	if c != nil {
+		c.mu.Lock()
+		e, ok := c.entries[id]
+		c.mu.Unlock()
+		if ok && time.Since(e.loadedAt) < c.ttl {
+			u := e.user
+			return &u, nil
+		}
+	}
+
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.mu.Lock()
+		if len(c.entries) >= userCacheSweepSize {
+			for k, e := range c.entries {
+				if time.Since(e.loadedAt) >= c.ttl {
+					delete(c.entries, k)
+				}
+			}
+		}
+		c.entries[id] = cachedUser{user: user, loadedAt: time.Now()}
+		c.mu.Unlock()
+	}
+	return &user, nil
+}
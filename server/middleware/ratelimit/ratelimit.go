@@ -1,7 +1,7 @@
 // Package ratelimit provides a per-key rate limiter for Gin routes. It is used
-// to slow brute-force attempts against the authentication endpoints (login and
-// password reset), where an attacker might otherwise guess the short
-// 4-character passkey.
+// to slow brute-force attempts against the authentication endpoints (login,
+// the two-factor code step and password reset), where an attacker might
+// otherwise guess a password or a six-digit code.
 //
 // The limiter is a fixed-window counter keyed by a caller-supplied string
 // (typically client IP + email). Each key is allowed `limit` attempts per
@@ -18,11 +18,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
-	"net/http"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
+	"github.com/nimbus/api/metrics"
 )
 
 // store is the pluggable backend for the fixed-window counter. allow records one
@@ -122,26 +123,31 @@ func (l *Limiter) Middleware(keysFunc func(c *gin.Context) []string) gin.Handler
 			keys = []string{c.ClientIP()}
 		}
 
-		// Record an attempt against every bucket, and block if any is exceeded.
-		// We evaluate all keys (not short-circuiting) so each bucket counts this
-		// attempt, which is the conservative choice for abuse tracking.
-		blocked := false
-		for _, k := range keys {
-			if !l.backend.allow(k) {
-				blocked = true
-			}
-		}
-		if blocked {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "Too many attempts. Please try again later.",
-			})
-			c.Abort()
+		if !l.Allow(keys...) {
+			apierr.Abort(c, apierr.RateLimited, "Too many attempts. Please try again later.")
 			return
 		}
 		c.Next()
 	}
 }
 
+// Allow records an attempt against every key and reports whether none of them
+// is over its limit. It is the check Middleware makes, for callers outside Gin
+// such as the SFTP server.
+func (l *Limiter) Allow(keys ...string) bool {
+	// Record an attempt against every bucket, and block if any is exceeded.
+	// We evaluate all keys (not short-circuiting) so each bucket counts this
+	// attempt, which is the conservative choice for abuse tracking.
+	blocked := false
+	for _, k := range keys {
+		if !l.backend.allow(k) {
+			blocked = true
+		}
+	}
+	metrics.RateLimited(!blocked)
+	return !blocked
+}
+
 // IPAndEmailKeys derives independent rate-limit buckets from the client IP and,
 // when present, the email in the JSON body. The body is peeked without being
 // consumed — it is restored so the downstream handler can still bind it.
@@ -149,7 +155,29 @@ func (l *Limiter) Middleware(keysFunc func(c *gin.Context) []string) gin.Handler
 // Returning both keys means the limiter throttles per source IP AND per targeted
 // account. When the body has no email, only the IP bucket is used.
 func IPAndEmailKeys(c *gin.Context) []string {
-	keys := []string{"ip:" + c.ClientIP()}
+	return ipAndBodyKeys(c, "ip:", "email")
+}
+
+// IPAndEmail returns the keys IPAndEmailKeys derives for a login from ip as
+// email, for protocols outside Gin (SFTP). Using the same keys means password
+// guesses over every protocol share one budget per IP and per account.
+func IPAndEmail(ip, email string) []string {
+	return []string{"ip:" + ip, "email:" + email}
+}
+
+// IPAndChallengeKeys is IPAndEmailKeys for the second step of a two-factor
+// login, where the body carries the login challenge instead of an email. The
+// challenge bucket caps guesses at one user's code however many IPs are used.
+// Its IP bucket is separate from the login one, so a mistyped code doesn't
+// count against logging in again.
+func IPAndChallengeKeys(c *gin.Context) []string {
+	return ipAndBodyKeys(c, "mfa-ip:", "challenge")
+}
+
+// ipAndBodyKeys returns the client IP key, under ipPrefix, plus a key for the
+// JSON body's field when it is a non-empty string.
+func ipAndBodyKeys(c *gin.Context, ipPrefix, field string) []string {
+	keys := []string{ipPrefix + c.ClientIP()}
 
 	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
@@ -158,11 +186,11 @@ func IPAndEmailKeys(c *gin.Context) []string {
 	// Restore the body for the actual handler.
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
 
-	var payload struct {
-		Email string `json:"email"`
-	}
-	if err := json.Unmarshal(body, &payload); err == nil && payload.Email != "" {
-		keys = append(keys, "email:"+payload.Email)
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err == nil {
+		if v, ok := payload[field].(string); ok && v != "" {
+			keys = append(keys, field+":"+v)
+		}
 	}
 	return keys
 }
@@ -86,6 +86,29 @@ func TestIPAndEmailKeys_ThrottlesPerIPAcrossAccounts(t *testing.T) {
 	assert.Equal(t, http.StatusTooManyRequests, post(r, "11.0.0.1", map[string]string{"email": "b@example.com"}).Code)
 }
 
+func TestIPAndEmail_SharesLoginBuckets(t *testing.T) {
+	l := New(1, time.Minute)
+	r := testRouter(l, IPAndEmailKeys)
+
+	// An SFTP attempt on an account uses up the budget /login has for it.
+	assert.True(t, l.Allow(IPAndEmail("13.0.0.1", "victim@example.com")...))
+	assert.Equal(t, http.StatusTooManyRequests, post(r, "13.0.0.2", map[string]string{"email": "victim@example.com"}).Code)
+	assert.False(t, l.Allow(IPAndEmail("13.0.0.2", "other@example.com")...), "the IP bucket is shared too")
+}
+
+func TestIPAndChallengeKeys_SeparateFromLoginBuckets(t *testing.T) {
+	l := New(1, time.Minute)
+	login := testRouter(l, IPAndEmailKeys)
+	mfa := testRouter(l, IPAndChallengeKeys)
+
+	challenge := map[string]string{"challenge": "nim_mfa_abc"}
+	assert.Equal(t, http.StatusOK, post(login, "12.0.0.1", map[string]string{"email": "a@example.com"}).Code)
+	// The login used up the IP's login bucket, not its code bucket.
+	assert.Equal(t, http.StatusOK, post(mfa, "12.0.0.1", challenge).Code)
+	// One challenge is throttled whatever IP the guesses come from.
+	assert.Equal(t, http.StatusTooManyRequests, post(mfa, "12.0.0.2", challenge).Code)
+}
+
 func TestIPAndEmailKeys_PreservesBodyForHandler(t *testing.T) {
 	l := New(5, time.Minute)
 	gin.SetMode(gin.TestMode)
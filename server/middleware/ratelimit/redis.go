@@ -2,7 +2,7 @@ package ratelimit
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -49,7 +49,7 @@ func (s *redisStore) allow(key string) bool {
 	pipe.Expire(ctx, redisKey, s.window)
 	if _, err := pipe.Exec(ctx); err != nil {
 		// Fail open: don't let a Redis blip take down auth.
-		log.Printf("[ratelimit] redis error, allowing request: %v", err)
+		slog.ErrorContext(ctx, "Rate limiter Redis error, allowing request", "error", err)
 		return true
 	}
 
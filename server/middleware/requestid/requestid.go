@@ -0,0 +1,121 @@
+// Package requestid gives every request an ID that ties together its log
+// lines, audit events and error responses. A well-formed X-Request-ID from the
+// client or the load balancer is kept; anything else is replaced.
+package requestid
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header is the request and response header carrying the ID.
+const Header = "X-Request-ID"
+
+const contextKey = "nimbus.request_id"
+
+// valid bounds what a client may choose as its own ID, so it can't smuggle
+// log-breaking or oversized values into the audit log.
+var valid = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Middleware assigns the request its ID and echoes it in the response. JSON
+// error responses (status 400 and up) also get it as a "request_id" field, so
+// it reaches users whose client only shows the body.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(Header)
+		if !valid.MatchString(id) {
+			id = New()
+		}
+		c.Set(contextKey, id)
+		c.Header(Header, id)
+
+		w := &errorWriter{ResponseWriter: c.Writer, id: id}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		w.flush()
+	}
+}
+
+// errorWriter holds back the body of a JSON error response until the handler
+// is done, so flush can add the request ID to it. Every other response is
+// written straight through.
+type errorWriter struct {
+	gin.ResponseWriter
+	id      string
+	decided bool
+	held    *bytes.Buffer // nil unless the body is being held back
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if w.hold() {
+		return w.held.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorWriter) WriteString(s string) (int, error) {
+	if w.hold() {
+		return w.held.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *errorWriter) Size() int {
+	if w.held != nil {
+		return w.held.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+// hold decides at the first write whether the body is held back. A response
+// with a Content-Length can't grow, so it is left alone.
+func (w *errorWriter) hold() bool {
+	if !w.decided {
+		w.decided = true
+		h := w.Header()
+		if w.Status() >= 400 && strings.HasPrefix(h.Get("Content-Type"), "application/json") && h.Get("Content-Length") == "" {
+			w.held = new(bytes.Buffer)
+		}
+	}
+	return w.held != nil
+}
+
+// flush writes a held-back body, with "request_id" added when it is a JSON
+// object that doesn't have one.
+func (w *errorWriter) flush() {
+	if w.held == nil {
+		return
+	}
+	body := w.held.Bytes()
+	var fields map[string]any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if dec.Decode(&fields) == nil && fields != nil {
+		if _, ok := fields["request_id"]; !ok {
+			fields["request_id"] = w.id
+			if b, err := json.Marshal(fields); err == nil {
+				body = b
+			}
+		}
+	}
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// Get returns the request's ID, or "" when Middleware isn't installed.
+func Get(c *gin.Context) string {
+	return c.GetString(contextKey)
+}
+
+// New returns a random request ID.
+func New() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AppPassword is a revocable, per-device credential for clients that can only
+// send a username and password — WebDAV mounts in file managers, davfs2 and
+// rclone. Only the SHA-256 of the secret is stored; the plain value is shown
+// once when it is created. Each device gets its own so losing a laptop means
+// revoking one app password instead of changing the account password.
+type AppPassword struct {
+	gorm.Model
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`                 // user-chosen label, e.g. "work laptop"
+	Hash       string     `gorm:"uniqueIndex;not null" json:"-"`        // hex SHA-256 of the secret
+	LastUsedAt *time.Time `json:"last_used_at"`                         // nil until first use
+	User       User       `gorm:"constraint:OnDelete:CASCADE" json:"-"` // removed with the account
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrAuditAppendOnly is returned by any attempt to change or delete an
+// AuditEvent through GORM.
+var ErrAuditAppendOnly = errors.New("audit events are append-only")
+
+// AuditEvent is one entry in the audit log: who did what to which box, folder
+// or file, from where, and whether it worked. Events are only ever inserted;
+// they outlive the account that caused them, so the actor's email is copied
+// in rather than joined.
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"time"`
+	UserID    uint      `gorm:"index" json:"user_id,omitempty"` // 0 when the caller wasn't identified
+	Actor     string    `json:"actor,omitempty"`                // account email, or the one a failed login tried
+	Protocol  string    `json:"protocol"`                       // api, webdav, s3 or sftp
+	Action    string    `gorm:"index;not null" json:"action"`   // e.g. file.delete, auth.login
+	Box       string    `json:"box,omitempty"`
+	Path      string    `json:"path,omitempty"`         // folder path, file name or S3 key inside Box
+	Result    string    `gorm:"not null" json:"result"` // success, failure or denied
+	Status    int       `json:"status,omitempty"`       // HTTP status, when there is one
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `gorm:"index" json:"request_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"` // e.g. a rename's new name
+}
+
+// BeforeUpdate keeps the log append-only.
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+// BeforeDelete keeps the log append-only.
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
@@ -1,17 +1,34 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
 
 // Box is the top-level storage container for a user — think of it like a
 // drive or a project root. Every user gets a "Home-Box" on registration and
 // can create more. Boxes own Folders and Files; deleting a box cascades to
 // everything inside it.
+//
+// Objects live under S3Prefix, which never changes, so renaming a box moves
+// nothing in S3. New boxes are keyed by BoxID; boxes from before renaming
+// existed keep the prefix named after their original name.
 type Box struct {
 	gorm.Model          // CreatedAt, UpdatedAt, DeletedAt
 	UserID     uint     `gorm:"not null;index" json:"user_id"` // which user owns this box
 	BoxID      uint     `gorm:"not null;index" json:"box_id"`  // cryptographically random ID (not the PK)
 	Name       string   `gorm:"not null" json:"name"`          // human-readable name, unique per user
 	Size       int64    `gorm:"default:0" json:"size"`         // total bytes stored (updated on upload/delete)
+	S3Prefix   string   `gorm:"not null" json:"-"`             // key prefix of its objects, ending in "/"
 	Folders    []Folder `gorm:"foreignKey:BoxID;constraint:OnDelete:CASCADE" json:"folders,omitempty"`
 	Files      []File   `gorm:"foreignKey:BoxID;constraint:OnDelete:CASCADE" json:"files,omitempty"`
 }
+
+// BeforeCreate gives a new box its S3 prefix.
+func (b *Box) BeforeCreate(tx *gorm.DB) error {
+	if b.S3Prefix == "" {
+		b.S3Prefix = fmt.Sprintf("users/nim-user-%d/boxes/%d/", b.UserID, b.BoxID)
+	}
+	return nil
+}
@@ -1,18 +1,52 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"errors"
 
-// Folder represents a directory inside a box. Folders are stored in a
-// self-referencing tree: ParentID points to the parent folder, or is NULL
-// for root-level folders that sit directly inside the box.
+	"gorm.io/gorm"
+)
+
+// Folder represents a directory inside a box. Folders form a tree: ParentID
+// points to the parent folder, or is NULL for root-level folders that sit
+// directly inside the box.
+//
+// Path materializes the tree. It is the folder's full slash path inside its
+// box, e.g. "documents/projects", so resolving a path or selecting a whole
+// subtree is one indexed query instead of a walk. Path is unique per box among
+// live folders, and so is a name within its parent (idx_folders_box_parent_name;
+// the migration builds it over COALESCE(parent_id, 0) so root folders count
+// as siblings, which a tag can't express). Moving or renaming a folder
+// rewrites the Path of everything below it (see storage.MoveFolder).
 // The same tree structure is mirrored in S3 via key prefixes ending in "/".
 type Folder struct {
 	gorm.Model          // CreatedAt, UpdatedAt, DeletedAt
 	ID         uint     `gorm:"primaryKey" json:"id"`
-	Name       string   `gorm:"not null" json:"name"`
+	Name       string   `gorm:"not null;uniqueIndex:idx_folders_box_parent_name,priority:3,where:deleted_at IS NULL" json:"name"`
 	UserID     uint     `gorm:"not null;index" json:"user_id"`
-	BoxID      uint     `gorm:"not null;index" json:"box_id"`
-	ParentID   *uint    `gorm:"index" json:"parent_id"` // nil = root folder inside its box
+	BoxID      uint     `gorm:"not null;index;uniqueIndex:idx_folders_box_path,priority:1,where:deleted_at IS NULL;uniqueIndex:idx_folders_box_parent_name,priority:1,where:deleted_at IS NULL" json:"box_id"`
+	ParentID   *uint    `gorm:"index;uniqueIndex:idx_folders_box_parent_name,priority:2,where:deleted_at IS NULL" json:"parent_id"` // nil = root folder inside its box
+	Path       string   `gorm:"not null;uniqueIndex:idx_folders_box_path,priority:2,where:deleted_at IS NULL" json:"path"`
 	Files      []File   `gorm:"foreignKey:FolderID" json:"files"`
 	SubFolders []Folder `gorm:"foreignKey:ParentID" json:"sub_folders"` // nested sub-directories
 }
+
+// BeforeCreate fills in Path from the parent folder when the caller hasn't.
+func (f *Folder) BeforeCreate(tx *gorm.DB) error {
+	if f.Path != "" {
+		return nil
+	}
+	if f.ParentID == nil {
+		f.Path = f.Name
+		return nil
+	}
+	var parent Folder
+	res := tx.Session(&gorm.Session{NewDB: true}).Select("path").Where("id = ?", *f.ParentID).Limit(1).Find(&parent)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errors.New("parent folder not found")
+	}
+	f.Path = parent.Path + "/" + f.Name
+	return nil
+}
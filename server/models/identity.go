@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Identity links a Nimbus account to an account at an OpenID Connect issuer.
+// The issuer's subject is the stable key; the email is kept for display.
+type Identity struct {
+	gorm.Model
+	UserID  uint   `gorm:"not null;index"`
+	Issuer  string `gorm:"not null;uniqueIndex:idx_identity_subject"`
+	Subject string `gorm:"not null;uniqueIndex:idx_identity_subject"`
+	Email   string
+	User    User `gorm:"constraint:OnDelete:CASCADE"` // removed with the account
+}
+
+// OIDCAuthRequest is a sign-in sent to the issuer and not yet returned. The
+// state in the callback finds it (only its SHA-256 is stored); the PKCE
+// verifier and nonce then check the code and ID token belong to it.
+type OIDCAuthRequest struct {
+	gorm.Model
+	StateHash             string `gorm:"uniqueIndex;not null"`
+	CodeVerifier          string `gorm:"not null"`
+	Nonce                 string `gorm:"not null"`
+	DeviceAuthorizationID *uint  // set when the sign-in approves a CLI login
+	ExpiresAt             time.Time
+	UsedAt                *time.Time
+}
+
+// DeviceAuthorization is a CLI login waiting for the user to sign in through
+// a browser, possibly on another machine (RFC 8628). The CLI polls with the
+// device code (only its SHA-256 is stored); the user types the short user
+// code into the browser.
+type DeviceAuthorization struct {
+	gorm.Model
+	DeviceCodeHash string     `gorm:"uniqueIndex;not null"`
+	UserCode       string     `gorm:"uniqueIndex;not null"`
+	Device         string     // device label for the session, as for password logins
+	ExpiresAt      time.Time  `gorm:"not null"`
+	LastPolledAt   *time.Time // for telling clients that poll too fast to slow down
+	UserID         *uint      // set once a user has signed in to approve it
+	ApprovedAt     *time.Time
+	DeniedAt       *time.Time // set when the user cancelled at the issuer
+	ConsumedAt     *time.Time // set when the CLI has collected its tokens
+}
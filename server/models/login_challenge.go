@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginChallenge is the half-finished login of a user with two-factor
+// authentication: the password was right, and a code must follow before any
+// token is issued. The client holds the challenge; only its SHA-256 is stored.
+type LoginChallenge struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index"`
+	Hash      string     `gorm:"uniqueIndex;not null"` // hex SHA-256 of the challenge
+	Device    string     // device label from the login request, for the session
+	Attempts  int        `gorm:"not null;default:0"` // codes tried against this challenge
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // set once the login completes
+	User      User       `gorm:"constraint:OnDelete:CASCADE"` // removed with the account
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PersonalAccessToken is a long-lived bearer credential for CI and scripts,
+// sent in the same Authorization header as a login JWT. Only the SHA-256 of
+// the token is stored. Scopes limit what it may do, and BoxID, when set,
+// limits it to a single box.
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`                 // user-chosen label, e.g. "github-actions"
+	Hash       string     `gorm:"uniqueIndex;not null" json:"-"`        // hex SHA-256 of the token
+	Hint       string     `gorm:"not null" json:"hint"`                 // last four characters, to tell tokens apart
+	Scopes     string     `gorm:"not null" json:"scopes"`               // comma-separated: read, write, delete, admin
+	BoxID      *uint      `gorm:"index" json:"box_id"`                  // Box.ID the token is limited to; nil = all boxes
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`           // tokens always expire
+	LastUsedAt *time.Time `json:"last_used_at"`                         // nil until first use
+	LastUsedIP string     `json:"last_used_ip"`                         // client IP of the last use
+	User       User       `gorm:"constraint:OnDelete:CASCADE" json:"-"` // removed with the account
+	Box        *Box       `gorm:"constraint:OnDelete:CASCADE" json:"-"` // removed with the box it is limited to
+}
+
+// HasScope reports whether the token grants scope. admin implies every scope.
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	scopes := strings.Split(t.Scopes, ",")
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, "admin")
+}
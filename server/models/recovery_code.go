@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a single-use code that stands in for the authenticator app
+// when it is lost. A set is issued when two-factor authentication is turned
+// on; only the SHA-256 of each normalised code is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID uint       `gorm:"not null;index"`
+	Hash   string     `gorm:"not null;index"` // hex SHA-256 of the normalised code
+	UsedAt *time.Time // set when the code is spent
+	User   User       `gorm:"constraint:OnDelete:CASCADE"` // removed with the account
+}
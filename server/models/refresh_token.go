@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RefreshToken lets the CLI get a new short-lived access token without asking
+// for the password again. Tokens rotate: each refresh marks the presented one
+// used and issues its successor in the same session. A used token coming back
+// means it was copied, so the whole session is revoked. Only the SHA-256 of
+// the token is stored.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index"`
+	Hash      string     `gorm:"uniqueIndex;not null"` // hex SHA-256 of the token
+	SessionID string     `gorm:"not null;index"`       // the login (Session.SessionID) this token belongs to
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // set when exchanged for a new pair
+	RevokedAt *time.Time // set when its session is revoked
+	User      User       `gorm:"constraint:OnDelete:CASCADE"` // removed with the account
+}
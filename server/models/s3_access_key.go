@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// S3AccessKey is a per-user credential for the S3-compatible gateway. SigV4
+// signs every request with an HMAC keyed by the secret, so unlike an
+// AppPassword the server has to keep the secret itself rather than a hash of
+// it. It is never serialised back to the client after creation.
+type S3AccessKey struct {
+	gorm.Model
+	UserID      uint       `gorm:"not null;index" json:"-"`
+	Name        string     `gorm:"not null" json:"name"`                      // user-chosen label, e.g. "restic backups"
+	AccessKeyID string     `gorm:"uniqueIndex;not null" json:"access_key_id"` // public half, sent in every request
+	SecretKey   string     `gorm:"not null" json:"-"`                         // signing secret
+	LastUsedAt  *time.Time `json:"last_used_at"`                              // nil until first use
+	User        User       `gorm:"constraint:OnDelete:CASCADE" json:"-"`      // removed with the account
+}
+
+// S3Upload tracks a multipart upload started through the S3 gateway. Parts go
+// straight to the backing bucket under S3Key; the File row is only created
+// when the client completes the upload, so an abandoned upload never shows up
+// in a box.
+type S3Upload struct {
+	gorm.Model
+	UploadID string `gorm:"uniqueIndex;not null"` // backing-bucket upload ID, also handed to the client
+	UserID   uint   `gorm:"not null;index"`
+	BoxID    uint   `gorm:"not null;index"`
+	Path     string `gorm:"not null"` // destination path inside the box
+	S3Key    string `gorm:"not null"` // destination object key
+	Box      Box    `gorm:"constraint:OnDelete:CASCADE"`
+	User     User   `gorm:"constraint:OnDelete:CASCADE"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is one login: it is created by /login, carried in every JWT it
+// issues as the "sid" claim and kept alive by refreshes. Revoking it ends the
+// login everywhere — its refresh tokens stop working and its outstanding
+// access tokens are rejected by the auth middleware.
+type Session struct {
+	gorm.Model
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	SessionID  string     `gorm:"uniqueIndex;not null" json:"id"`       // public identifier, the JWT "sid" claim
+	Device     string     `json:"device"`                               // client-supplied name or User-Agent
+	IP         string     `json:"ip"`                                   // client IP last seen
+	LastSeenAt time.Time  `json:"last_seen_at"`                         // updated at most once a minute
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`           // when the newest refresh token expires
+	RevokedAt  *time.Time `json:"-"`                                    // set on logout, password reset or token reuse
+	User       User       `gorm:"constraint:OnDelete:CASCADE" json:"-"` // removed with the account
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// SigningKey is one key of the JWT keyset. The newest key whose ActivatesAt
+// has passed signs new access tokens; every key that hasn't expired verifies
+// them and is published at /.well-known/jwks.json. A rotated-in key is
+// published before it activates, so other instances and JWKS consumers know
+// it by the time tokens signed with it turn up, and a rotated-out key keeps
+// verifying until the tokens it signed have expired.
+type SigningKey struct {
+	ID          uint       `gorm:"primaryKey"`
+	CreatedAt   time.Time  `json:"created_at"`
+	KID         string     `gorm:"column:kid;uniqueIndex;not null" json:"kid"` // the JWT "kid" header
+	Algorithm   string     `gorm:"not null" json:"alg"`                        // EdDSA or RS256
+	PrivateKey  string     `gorm:"not null" json:"-"`                          // PKCS #8, PEM-encoded
+	ActivatesAt time.Time  `gorm:"not null" json:"activates_at"`               // starts signing
+	ExpiresAt   *time.Time `gorm:"index" json:"expires_at"`                    // stops verifying; set when rotated out
+}
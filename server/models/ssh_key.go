@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SSHKey is a public key a user has uploaded for the SFTP server. Keys are
+// matched by their SHA-256 fingerprint, which is unique across all accounts so
+// a key always identifies exactly one user.
+type SSHKey struct {
+	gorm.Model
+	UserID      uint       `gorm:"not null;index" json:"-"`
+	Name        string     `gorm:"not null" json:"name"`                    // user-chosen label, e.g. "partner-acme"
+	PublicKey   string     `gorm:"not null" json:"public_key"`              // authorized_keys form, without the comment
+	Fingerprint string     `gorm:"uniqueIndex;not null" json:"fingerprint"` // "SHA256:..." as printed by ssh-keygen -l
+	LastUsedAt  *time.Time `json:"last_used_at"`                            // nil until first use
+	User        User       `gorm:"constraint:OnDelete:CASCADE" json:"-"`    // removed with the account
+}
@@ -1,11 +1,19 @@
 // Package models defines the GORM database models for Nimbus.
-// Each struct maps to a table; GORM creates/updates the table via AutoMigrate.
+// Each struct maps to a table, which the migrations in package migrate create.
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Roles a user can have. Admins can use the /v1/api/admin endpoints.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User represents a registered Nimbus account.
 // ID is set manually during registration (random 8-digit number) rather than
 // relying on database auto-increment, which is why autoIncrement is false.
@@ -14,8 +22,34 @@ import (
 type User struct {
 	ID         uint   `gorm:"primaryKey;autoIncrement:false" json:"id"`
 	Email      string `gorm:"unique;not null" json:"email"`
-	Password   string `gorm:"not null" json:"-"` // stored as bcrypt hash
-	PassKey    string `gorm:"not null" json:"-"` // stored as bcrypt hash
+	Password   string `gorm:"not null" json:"-"` // Argon2id in PHC format; bcrypt until the next login for older accounts
 	Boxes      []Box  `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE" json:"boxes,omitempty"`
 	gorm.Model        // adds CreatedAt, UpdatedAt, DeletedAt
+
+	// Two-factor authentication. TOTPSecret is set when enrollment starts and
+	// TOTPEnabled once the user proves their app has it. TOTPLastStep is the
+	// time step of the last accepted code, so a code can't be used twice.
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `gorm:"not null;default:false" json:"totp_enabled"`
+	TOTPLastStep int64  `gorm:"not null;default:0" json:"-"`
+
+	// Administration. A suspended account can't log in or use any credential
+	// until it is unsuspended. PasswordResetRequired blocks password logins
+	// until the password is reset with a recovery code. QuotaBytes caps the
+	// bytes stored across all boxes; 0 means no limit.
+	Role                  string     `gorm:"not null;default:user" json:"role"`
+	SuspendedAt           *time.Time `json:"suspended_at,omitempty"`
+	SuspendReason         string     `json:"suspend_reason,omitempty"`
+	PasswordResetRequired bool       `gorm:"not null;default:false" json:"password_reset_required"`
+	QuotaBytes            int64      `gorm:"not null;default:0" json:"quota_bytes"`
+}
+
+// IsAdmin reports whether u has the admin role.
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Suspended reports whether an admin has suspended u's account.
+func (u *User) Suspended() bool {
+	return u.SuspendedAt != nil
 }
@@ -0,0 +1,26 @@
+// Package openapi holds the OpenAPI 3 description of the /v1/api routes. The
+// spec is the contract the CLI's generated client (client/api) is built from;
+// tests/openapi_test.go fails when a route is added, removed or answers with a
+// body the spec doesn't describe, so change the two together.
+package openapi
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:generate go run swaggerui_gen.go
+
+// Spec is openapi.yaml as served at /openapi.yaml.
+//
+//go:embed openapi.yaml
+var Spec []byte
+
+//go:embed swaggerui
+var swaggerUI embed.FS
+
+// SwaggerUI holds the Swagger UI assets served under /docs: a pinned
+// swagger-ui-dist release fetched by swaggerui_gen.go, and docs.js, which
+// starts it. They're served from the API's own origin rather than a CDN, so
+// no third party can change the script that runs there.
+var SwaggerUI, _ = fs.Sub(swaggerUI, "swaggerui")
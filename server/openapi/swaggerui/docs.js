@@ -0,0 +1,3 @@
+// Starts Swagger UI on the /docs page. It lives in its own file so the page's
+// Content-Security-Policy can refuse inline script.
+window.ui = SwaggerUIBundle({ url: "/openapi.yaml", dom_id: "#swagger-ui" });
@@ -0,0 +1,101 @@
+//go:build ignore
+
+// swaggerui_gen.go fetches the pinned swagger-ui-dist release from npm into
+// swaggerui/, checking the tarball against the integrity hash npm publishes
+// for it. Published npm versions can't be replaced, so the pinned version
+// always yields the same files; they're committed, and a version bump shows
+// up in review as a diff of them. Run it with "go generate ./openapi".
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const version = "5.17.14"
+
+// files are the parts of the package /docs needs, plus its licence.
+var files = []string{"swagger-ui.css", "swagger-ui-bundle.js", "LICENSE"}
+
+func main() {
+	var meta struct {
+		Dist struct {
+			Tarball   string `json:"tarball"`
+			Integrity string `json:"integrity"`
+		} `json:"dist"`
+	}
+	body, err := get("https://registry.npmjs.org/swagger-ui-dist/" + version)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(body, &meta); err != nil {
+		log.Fatalf("registry metadata: %v", err)
+	}
+
+	tgz, err := get(meta.Dist.Tarball)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sum := sha512.Sum512(tgz)
+	if got := "sha512-" + base64.StdEncoding.EncodeToString(sum[:]); got != meta.Dist.Integrity {
+		log.Fatalf("tarball integrity mismatch: got %s, registry says %s", got, meta.Dist.Integrity)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		log.Fatal(err)
+	}
+	want := make(map[string]bool, len(files))
+	for _, f := range files {
+		want["package/"+f] = true
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !want[hdr.Name] {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join("swaggerui", filepath.Base(hdr.Name)), data, 0o644); err != nil {
+			log.Fatal(err)
+		}
+		delete(want, hdr.Name)
+	}
+	if len(want) > 0 {
+		log.Fatalf("swagger-ui-dist %s is missing %v", version, want)
+	}
+	if err := os.WriteFile(filepath.Join("swaggerui", "VERSION"), []byte(version+"\n"), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func get(url string) ([]byte, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/handlers/admin"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+// InitAdminRoutes registers the operator endpoints under /v1/api/admin. They
+// need an account with the admin role (granted with "server users promote")
+// and, with a personal access token, the admin scope. Every request is
+// audited, reads included, and :user is a user ID or email.
+func InitAdminRoutes(r *gin.Engine, config s3db.Config, db *gorm.DB) {
+	route := r.Group("v1/api/admin", jwt.RequireScope(jwt.ScopeAdmin), audit.Denied(db), jwt.Authenticate(db), jwt.RequireAdmin())
+	{
+		route.GET("/users", audit.Action(db, "admin.users_list"), func(c *gin.Context) {
+			admin.ListUsers(c, requestDB(c, db))
+		})
+		route.GET("/users/:user", audit.Action(db, "admin.user_view"), func(c *gin.Context) {
+			admin.GetUser(c, requestDB(c, db))
+		})
+		route.POST("/users/:user/suspend", audit.Action(db, "admin.user_suspend"), func(c *gin.Context) {
+			admin.SuspendUser(c, requestDB(c, db))
+		})
+		route.POST("/users/:user/unsuspend", audit.Action(db, "admin.user_unsuspend"), func(c *gin.Context) {
+			admin.UnsuspendUser(c, requestDB(c, db))
+		})
+		route.POST("/users/:user/reset-password", audit.Action(db, "admin.user_force_reset"), func(c *gin.Context) {
+			admin.ForcePasswordReset(c, requestDB(c, db))
+		})
+		route.DELETE("/users/:user/sessions", audit.Action(db, "admin.user_revoke_sessions"), func(c *gin.Context) {
+			admin.RevokeUserSessions(c, requestDB(c, db))
+		})
+		route.PUT("/users/:user/quota", audit.Action(db, "admin.user_quota"), func(c *gin.Context) {
+			admin.SetQuota(c, requestDB(c, db))
+		})
+		route.GET("/users/:user/boxes/:box", audit.Action(db, "admin.box_view"), func(c *gin.Context) {
+			admin.BoxTree(c, requestDB(c, db), config)
+		})
+	}
+}
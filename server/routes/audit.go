@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/handlers/audit"
+	auditlog "github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"gorm.io/gorm"
+)
+
+// InitAuditRoutes registers the audit log endpoint under /v1/api. Reading it
+// needs the admin scope with a personal access token, like the credential
+// routes, since it reveals where the account is used from.
+func InitAuditRoutes(r *gin.Engine, db *gorm.DB) {
+	route := r.Group("v1/api", jwt.RequireScope(jwt.ScopeAdmin), auditlog.Denied(db), jwt.Authenticate(db))
+	{
+		route.GET("/audit", func(c *gin.Context) {
+			audit.List(c, requestDB(c, db))
+		})
+	}
+}
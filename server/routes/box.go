@@ -4,22 +4,27 @@ import (
 	"github.com/gin-gonic/gin"
 	s3db "github.com/nimbus/api/db/s3"
 	"github.com/nimbus/api/handlers/box"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
 	"gorm.io/gorm"
 )
 
 // InitBoxRoutes registers the box management endpoints under /v1/api.
-// All three routes require a valid JWT (enforced inside each handler).
+// All routes require a valid JWT or access token (see jwt.Authenticate).
 func InitBoxRoutes(r *gin.Engine, config s3db.Config, db *gorm.DB) {
-	route := r.Group("v1/api")
+	route := r.Group("v1/api", audit.Denied(db), jwt.Authenticate(db))
 	{
 		route.GET("/boxes", func(c *gin.Context) {
-			box.ListBoxes(config, c, db)
+			box.ListBoxes(config, c, requestDB(c, db))
 		})
-		route.POST("/boxes", func(c *gin.Context) {
-			box.CreateBox(config, c, db)
+		route.POST("/boxes", audit.Action(db, "box.create"), func(c *gin.Context) {
+			box.CreateBox(config, c, requestDB(c, db))
 		})
-		route.DELETE("/boxes", func(c *gin.Context) {
-			box.DeleteBox(config, c, db)
+		route.DELETE("/boxes", audit.Action(db, "box.delete"), func(c *gin.Context) {
+			box.DeleteBox(config, c, requestDB(c, db))
+		})
+		route.PATCH("/boxes/rename", audit.Action(db, "box.rename"), func(c *gin.Context) {
+			box.RenameBox(config, c, requestDB(c, db))
 		})
 	}
 }
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/handlers/dav"
+	"gorm.io/gorm"
+)
+
+// InitDavRoutes mounts the WebDAV tree at /dav/ for every WebDAV method.
+// Authentication is HTTP Basic (email + JWT or app password), handled inside
+// the dav handler so failures carry the challenge file managers expect.
+func InitDavRoutes(r *gin.Engine, config s3db.Config, db *gorm.DB) {
+	handler := dav.New(config, db)
+	route := r.Group(dav.Prefix)
+	for _, method := range dav.Methods {
+		route.Handle(method, "/*path", func(c *gin.Context) {
+			handler.Serve(c)
+		})
+	}
+}
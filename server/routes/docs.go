@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/openapi"
+)
+
+// docsPage renders the spec with Swagger UI. Its assets come from the
+// embedded openapi.SwaggerUI, and the Content-Security-Policy set with it
+// keeps the page from loading script from anywhere else.
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Nimbus API</title>
+<link rel="stylesheet" href="/docs/assets/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="/docs/assets/swagger-ui-bundle.js"></script>
+<script src="/docs/assets/docs.js"></script>
+</body>
+</html>
+`
+
+const docsCSP = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; object-src 'none'; frame-ancestors 'none'"
+
+// InitDocsRoutes serves the OpenAPI spec at /openapi.yaml and an interactive
+// page to read and try it at /docs.
+func InitDocsRoutes(r *gin.Engine) {
+	r.GET("/openapi.yaml", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Data(http.StatusOK, "application/yaml", openapi.Spec)
+	})
+	r.GET("/docs", func(c *gin.Context) {
+		if _, err := fs.Stat(openapi.SwaggerUI, "swagger-ui-bundle.js"); err != nil {
+			c.String(http.StatusServiceUnavailable, "Swagger UI isn't bundled in this build; run \"go generate ./openapi\". The spec is at /openapi.yaml.\n")
+			return
+		}
+		c.Header("Content-Security-Policy", docsCSP)
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+	})
+	r.GET("/docs/assets/:file", func(c *gin.Context) {
+		name := c.Param("file")
+		data, err := fs.ReadFile(openapi.SwaggerUI, name)
+		if err != nil {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Header("Cache-Control", "public, max-age=3600")
+		c.Header("X-Content-Type-Options", "nosniff")
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		c.Data(http.StatusOK, contentType, data)
+	})
+}
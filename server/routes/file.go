@@ -4,6 +4,8 @@ import (
 	"github.com/gin-gonic/gin"
 	s3db "github.com/nimbus/api/db/s3"
 	"github.com/nimbus/api/handlers/file"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
 	"gorm.io/gorm"
 )
 
@@ -11,28 +13,28 @@ import (
 // The presign endpoints return short-lived S3 URLs; the CLI then talks
 // directly to S3 for the actual data transfer (no bytes flow through this server).
 func InitFileRoutes(r *gin.Engine, config s3db.Config, db *gorm.DB) {
-	route := r.Group("v1/api")
+	route := r.Group("v1/api", audit.Denied(db), jwt.Authenticate(db))
 	{
 		route.GET("/files", func(c *gin.Context) {
-			file.List(config, db, c)
+			file.List(config, requestDB(c, db), c)
 		})
-		route.GET("/files/presign-download", func(c *gin.Context) {
-			file.PresignDownload(config, c, db)
+		route.GET("/files/presign-download", audit.Action(db, "file.download"), func(c *gin.Context) {
+			file.PresignDownload(config, c, requestDB(c, db))
 		})
-		route.POST("/files/presign-upload", func(c *gin.Context) {
-			file.PresignUpload(config, db, c)
+		route.POST("/files/presign-upload", audit.Action(db, "file.upload"), func(c *gin.Context) {
+			file.PresignUpload(config, requestDB(c, db), c)
 		})
-		route.POST("/files/:id/confirm", func(c *gin.Context) {
-			file.Confirm(config, db, c)
+		route.POST("/files/:id/confirm", audit.Action(db, "file.confirm"), func(c *gin.Context) {
+			file.Confirm(config, requestDB(c, db), c)
 		})
-		route.DELETE("/files/:name", func(c *gin.Context) {
-			file.Delete(config, db, c)
+		route.DELETE("/files/:name", audit.Action(db, "file.delete"), func(c *gin.Context) {
+			file.Delete(config, requestDB(c, db), c)
 		})
-		route.PATCH("/files/rename", func(c *gin.Context) {
-			file.Rename(config, db, c)
+		route.PATCH("/files/rename", audit.Action(db, "file.rename"), func(c *gin.Context) {
+			file.Rename(config, requestDB(c, db), c)
 		})
-		route.PATCH("/files/move", func(c *gin.Context) {
-			file.Move(config, db, c)
+		route.PATCH("/files/move", audit.Action(db, "file.move"), func(c *gin.Context) {
+			file.Move(config, requestDB(c, db), c)
 		})
 	}
 }
@@ -4,30 +4,32 @@ import (
 	"github.com/gin-gonic/gin"
 	s3db "github.com/nimbus/api/db/s3"
 	"github.com/nimbus/api/handlers/folder"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
 	"gorm.io/gorm"
 )
 
 // InitFolderRoutes registers the folder management endpoints under /v1/api.
 func InitFolderRoutes(r *gin.Engine, config s3db.Config, db *gorm.DB) {
-	route := r.Group("v1/api")
+	route := r.Group("v1/api", audit.Denied(db), jwt.Authenticate(db))
 	{
 		route.GET("/folders", func(c *gin.Context) {
-			folder.List(config, c, db)
+			folder.List(config, c, requestDB(c, db))
 		})
-		route.GET("/folders/download", func(c *gin.Context) {
-			folder.Download(config, c, db)
+		route.GET("/folders/download", audit.Action(db, "folder.download"), func(c *gin.Context) {
+			folder.Download(config, c, requestDB(c, db))
 		})
-		route.POST("/folders", func(c *gin.Context) {
-			folder.Create(config, c, db)
+		route.POST("/folders", audit.Action(db, "folder.create"), func(c *gin.Context) {
+			folder.Create(config, c, requestDB(c, db))
 		})
-		route.POST("/folders/upload", func(c *gin.Context) {
+		route.POST("/folders/upload", audit.Action(db, "folder.upload"), func(c *gin.Context) {
 			folder.Upload(config, c)
 		})
-		route.DELETE("/folders", func(c *gin.Context) {
-			folder.Delete(config, c, db)
+		route.DELETE("/folders", audit.Action(db, "folder.delete"), func(c *gin.Context) {
+			folder.Delete(config, c, requestDB(c, db))
 		})
-		route.PATCH("/folders/rename", func(c *gin.Context) {
-			folder.Rename(config, c, db)
+		route.PATCH("/folders/rename", audit.Action(db, "folder.rename"), func(c *gin.Context) {
+			folder.Rename(config, c, requestDB(c, db))
 		})
 	}
 }
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/middleware/jwt"
+)
+
+// InitKeyRoutes publishes the public keys access tokens are verified with, so
+// other services can check Nimbus tokens without sharing a secret. Consumers
+// may cache the set for a few minutes: a rotated key is published well before
+// it signs anything (see jwt.KEY_PUBLISH_DELAY).
+func InitKeyRoutes(r *gin.Engine) {
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, jwt.JWKS())
+	})
+}
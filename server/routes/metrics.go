@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/metrics"
+)
+
+// InitMetricsRoutes serves the Prometheus metrics at /metrics. With a token,
+// scrapers must send it as "Authorization: Bearer <token>".
+func InitMetricsRoutes(r *gin.Engine, token string) {
+	r.GET("/metrics", metrics.Handler(token))
+}
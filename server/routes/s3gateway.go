@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/handlers/s3gw"
+	"gorm.io/gorm"
+)
+
+// InitS3GatewayRoutes serves the S3-compatible API from the root of r. S3
+// clients address buckets as "/<bucket>/<key>", so the gateway needs a router
+// (and listener) of its own rather than a prefix on the main API. Requests are
+// authenticated with SigV4 access keys inside the handler.
+func InitS3GatewayRoutes(r *gin.Engine, config s3db.Config, db *gorm.DB) {
+	handler := s3gw.New(config, db)
+	r.Any("/*path", func(c *gin.Context) {
+		handler.Serve(c)
+	})
+}
@@ -3,30 +3,152 @@
 package routes
 
 import (
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
 	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/middleware/ratelimit"
 	"gorm.io/gorm"
 )
 
-// InitUserRoutes registers the authentication endpoints under /v1/api/auth/.
-// authLimiter throttles credential-guessing on login and password reset (keyed
-// by client IP + email); it is built in the bootstrap so it can be Redis-backed
-// (shared across instances) or in-memory depending on configuration.
-func InitUserRoutes(r *gin.Engine, db *gorm.DB, s3Client *s3.Client, authLimiter *ratelimit.Limiter) {
+// InitUserRoutes registers the authentication endpoints under /v1/api/auth/,
+// including single sign-on, account management, two-factor login and
+// enrollment, recovery codes, login sessions, personal access tokens,
+// app-password management for WebDAV clients, access keys for the S3 gateway
+// and public keys for the SFTP server. config is needed to delete a closed
+// account's files.
+// authLimiter throttles credential-guessing on login, the two-factor code step
+// and password reset (keyed by client IP + email or challenge); it is built in
+// the bootstrap so it can be Redis-backed (shared across instances) or
+// in-memory depending on configuration.
+func InitUserRoutes(r *gin.Engine, config s3db.Config, db *gorm.DB, authLimiter *ratelimit.Limiter) {
 	r.GET("/register", user.ServeRegisterPage)
 
 	route := r.Group("v1/api/auth/")
 	{
-		route.POST("/users/register", func(c *gin.Context) {
-			user.Register(c, db, s3Client)
+		route.POST("/users/register", audit.Action(db, "auth.register"), func(c *gin.Context) {
+			user.Register(c, requestDB(c, db), config.Client)
 		})
-		route.POST("/users/login", authLimiter.Middleware(ratelimit.IPAndEmailKeys), func(c *gin.Context) {
-			user.Login(c, db)
+		route.POST("/users/login", audit.Action(db, "auth.login"), authLimiter.Middleware(ratelimit.IPAndEmailKeys), func(c *gin.Context) {
+			user.Login(c, requestDB(c, db))
 		})
-		route.POST("/users/reset-password", authLimiter.Middleware(ratelimit.IPAndEmailKeys), func(c *gin.Context) {
-			user.ResetPassword(c, db)
+		route.POST("/users/login/totp", audit.Action(db, "auth.login_totp"), authLimiter.Middleware(ratelimit.IPAndChallengeKeys), func(c *gin.Context) {
+			user.LoginTOTP(c, requestDB(c, db))
 		})
+		route.POST("/refresh", audit.Action(db, "auth.refresh"), func(c *gin.Context) {
+			user.Refresh(c, requestDB(c, db))
+		})
+		route.POST("/users/reset-password", audit.Action(db, "auth.reset_password"), authLimiter.Middleware(ratelimit.IPAndEmailKeys), func(c *gin.Context) {
+			user.ResetPassword(c, requestDB(c, db))
+		})
+		// Single sign-on; every route responds 404 unless OIDC_ISSUER is set.
+		route.GET("/oidc/login", func(c *gin.Context) {
+			user.OIDCLogin(c, requestDB(c, db))
+		})
+		route.GET("/oidc/callback", audit.Action(db, "auth.sso_login"), func(c *gin.Context) {
+			user.OIDCCallback(c, requestDB(c, db))
+		})
+		route.POST("/oidc/device", func(c *gin.Context) {
+			user.StartDeviceAuthorization(c, requestDB(c, db))
+		})
+		route.GET("/oidc/verify", func(c *gin.Context) {
+			user.VerifyDevice(c, requestDB(c, db))
+		})
+		route.POST("/oidc/verify", func(c *gin.Context) {
+			user.ConfirmDevice(c, requestDB(c, db))
+		})
+		route.POST("/oidc/device/token", func(c *gin.Context) {
+			user.DeviceToken(c, requestDB(c, db))
+		})
+		authed := route.Group("", audit.Denied(db), jwt.Authenticate(db))
+		{
+			authed.POST("/users/logout", audit.Action(db, "auth.logout"), func(c *gin.Context) {
+				user.Logout(c, requestDB(c, db))
+			})
+		}
+		// Credential management needs the admin scope when called with a
+		// personal access token, so a leaked CI token can't mint new ones.
+		creds := route.Group("", jwt.RequireScope(jwt.ScopeAdmin), audit.Denied(db), jwt.Authenticate(db))
+		{
+			creds.GET("/account", func(c *gin.Context) {
+				user.GetAccount(c, requestDB(c, db))
+			})
+			creds.POST("/account/password", audit.Action(db, "account.change_password"), func(c *gin.Context) {
+				user.ChangePassword(c, requestDB(c, db))
+			})
+			creds.POST("/account/email", audit.Action(db, "account.change_email"), func(c *gin.Context) {
+				user.ChangeEmail(c, requestDB(c, db))
+			})
+			creds.DELETE("/account", audit.Action(db, "account.delete"), func(c *gin.Context) {
+				user.DeleteAccount(c, requestDB(c, db), config)
+			})
+			creds.POST("/totp", audit.Action(db, "totp.enroll"), func(c *gin.Context) {
+				user.EnrollTOTP(c, requestDB(c, db))
+			})
+			creds.POST("/totp/confirm", audit.Action(db, "totp.enable"), func(c *gin.Context) {
+				user.ConfirmTOTP(c, requestDB(c, db))
+			})
+			creds.POST("/totp/disable", audit.Action(db, "totp.disable"), func(c *gin.Context) {
+				user.DisableTOTP(c, requestDB(c, db))
+			})
+			creds.GET("/recovery-codes", func(c *gin.Context) {
+				user.GetRecoveryCodes(c, requestDB(c, db))
+			})
+			creds.POST("/recovery-codes", audit.Action(db, "recovery_codes.regenerate"), func(c *gin.Context) {
+				user.RegenerateRecoveryCodes(c, requestDB(c, db))
+			})
+			creds.GET("/sessions", func(c *gin.Context) {
+				user.ListSessions(c, requestDB(c, db))
+			})
+			creds.DELETE("/sessions", audit.Action(db, "session.revoke_all"), func(c *gin.Context) {
+				user.RevokeAllSessions(c, requestDB(c, db))
+			})
+			creds.DELETE("/sessions/:id", audit.Action(db, "session.revoke"), func(c *gin.Context) {
+				user.RevokeSession(c, requestDB(c, db))
+			})
+			creds.GET("/tokens", func(c *gin.Context) {
+				user.ListAccessTokens(c, requestDB(c, db))
+			})
+			creds.POST("/tokens", audit.Action(db, "token.create"), func(c *gin.Context) {
+				user.CreateAccessToken(c, requestDB(c, db))
+			})
+			creds.DELETE("/tokens/:id", audit.Action(db, "token.revoke"), func(c *gin.Context) {
+				user.RevokeAccessToken(c, requestDB(c, db))
+			})
+			creds.GET("/app-passwords", func(c *gin.Context) {
+				user.ListAppPasswords(c, requestDB(c, db))
+			})
+			creds.POST("/app-passwords", audit.Action(db, "app_password.create"), func(c *gin.Context) {
+				user.CreateAppPassword(c, requestDB(c, db))
+			})
+			creds.DELETE("/app-passwords/:id", audit.Action(db, "app_password.revoke"), func(c *gin.Context) {
+				user.RevokeAppPassword(c, requestDB(c, db))
+			})
+			creds.GET("/s3-keys", func(c *gin.Context) {
+				user.ListS3Keys(c, requestDB(c, db))
+			})
+			creds.POST("/s3-keys", audit.Action(db, "s3_key.create"), func(c *gin.Context) {
+				user.CreateS3Key(c, requestDB(c, db))
+			})
+			creds.DELETE("/s3-keys/:id", audit.Action(db, "s3_key.revoke"), func(c *gin.Context) {
+				user.RevokeS3Key(c, requestDB(c, db))
+			})
+			creds.GET("/ssh-keys", func(c *gin.Context) {
+				user.ListSSHKeys(c, requestDB(c, db))
+			})
+			creds.POST("/ssh-keys", audit.Action(db, "ssh_key.add"), func(c *gin.Context) {
+				user.CreateSSHKey(c, requestDB(c, db))
+			})
+			creds.DELETE("/ssh-keys/:id", audit.Action(db, "ssh_key.remove"), func(c *gin.Context) {
+				user.RemoveSSHKey(c, requestDB(c, db))
+			})
+		}
 	}
 }
+
+// requestDB scopes db to c's request, so the handler's queries are cancelled
+// with it and traced and logged as part of it.
+func requestDB(c *gin.Context, db *gorm.DB) *gorm.DB {
+	return db.WithContext(c.Request.Context())
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nimbus/api/db/migrate"
+	"github.com/nimbus/api/db/postgres"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"gorm.io/gorm"
+)
+
+const commandUsage = `usage: server [command]
+
+With no command the server starts. Commands:
+
+  keys list                 list the JWT signing keys
+  keys rotate [flags]       add a new signing key; run "keys rotate -h" for flags
+  users promote <email>     give an account the admin role
+  users demote <email>      take the admin role away
+  migrate up                apply every pending schema migration
+  migrate down [n]          roll back the last n migrations (default 1)
+  migrate status            list the migrations and which are applied
+`
+
+// RunCommand runs an admin command against the database in DATABASE_URL,
+// such as "keys rotate", and writes its output to stdout.
+func RunCommand(args []string) error {
+	if len(args) >= 2 && args[0] == "keys" {
+		switch args[1] {
+		case "list":
+			return listKeys(os.Stdout)
+		case "rotate":
+			return rotateKeys(os.Stdout, args[2:])
+		}
+	}
+	if len(args) == 3 && args[0] == "users" {
+		switch args[1] {
+		case "promote":
+			return setRole(os.Stdout, args[2], models.RoleAdmin)
+		case "demote":
+			return setRole(os.Stdout, args[2], models.RoleUser)
+		}
+	}
+	if len(args) >= 2 && args[0] == "migrate" {
+		switch {
+		case args[1] == "up" && len(args) == 2:
+			return migrateUp(os.Stdout)
+		case args[1] == "down" && len(args) <= 3:
+			return migrateDown(os.Stdout, args[2:])
+		case args[1] == "status" && len(args) == 2:
+			return migrateStatus(os.Stdout)
+		}
+	}
+	fmt.Fprint(os.Stderr, commandUsage)
+	return fmt.Errorf("unknown command %q", strings.Join(args, " "))
+}
+
+// listKeys prints the signing keys that still verify tokens, newest first.
+func listKeys(out io.Writer) error {
+	db, err := postgres.Connect()
+	if err != nil {
+		return err
+	}
+	var rows []models.SigningKey
+	if err := db.Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Order("activates_at DESC").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tSTATE\tACTIVATES\tEXPIRES")
+	signing := false
+	for _, k := range rows {
+		state := "verifying"
+		switch {
+		case k.ActivatesAt.After(time.Now()):
+			state = "published"
+		case !signing:
+			state, signing = "signing", true
+		}
+		expires := "-"
+		if k.ExpiresAt != nil {
+			expires = k.ExpiresAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.KID, k.Algorithm, state, k.ActivatesAt.Local().Format(time.DateTime), expires)
+	}
+	return w.Flush()
+}
+
+// rotateKeys adds a new signing key; see jwt.RotateKeys.
+func rotateKeys(out io.Writer, args []string) error {
+	fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	alg := fs.String("alg", jwt.AlgEdDSA, "algorithm of the new key: EdDSA or RS256")
+	delay := fs.Duration("delay", jwt.KEY_PUBLISH_DELAY, "how long to publish the new key before it signs tokens")
+	revoke := fs.Bool("revoke", false, "sign with the new key now and stop accepting every older key (logs everyone out)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*revoke && *delay < jwt.KEY_RELOAD_INTERVAL {
+		return fmt.Errorf("-delay must be at least %s so every instance loads the key before it signs", jwt.KEY_RELOAD_INTERVAL)
+	}
+
+	db, err := postgres.Connect()
+	if err != nil {
+		return err
+	}
+	key, err := jwt.RotateKeys(db, jwt.RotateOptions{Algorithm: *alg, Delay: *delay, Revoke: *revoke})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Added %s key %s.\n", key.Algorithm, key.KID)
+	if *revoke {
+		fmt.Fprintf(out, "It signs once each instance reloads its keys (within %s); every older token is rejected.\n", jwt.KEY_RELOAD_INTERVAL)
+	} else {
+		fmt.Fprintf(out, "It is published now and signs from %s; older keys keep verifying until their tokens expire.\n",
+			key.ActivatesAt.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+// setRole gives the account with email the role, and audits the change.
+// Promotion happens here rather than through the API so there is always a
+// way to create the first admin, and no admin can be created by a leaked
+// admin token alone.
+func setRole(out io.Writer, email, role string) error {
+	db, err := postgres.Connect()
+	if err != nil {
+		return err
+	}
+	var u models.User
+	if err := db.Where("email = ?", email).First(&u).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("no account with email %s", email)
+		}
+		return err
+	}
+	if u.Role == role {
+		fmt.Fprintf(out, "%s already has the %s role.\n", email, role)
+		return nil
+	}
+	if err := db.Model(&u).Update("role", role).Error; err != nil {
+		return err
+	}
+	audit.Write(db, &models.AuditEvent{
+		UserID: u.ID, Actor: u.Email, Protocol: audit.ProtocolConsole, Action: "admin.user_role",
+		Result: audit.ResultSuccess, Detail: "role: " + role,
+	})
+	fmt.Fprintf(out, "%s now has the %s role.\n", email, role)
+	return nil
+}
+
+// migrator connects to the database for a migrate command.
+func migrator() (*migrate.Migrator, error) {
+	db, err := postgres.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, migrate.FS)
+}
+
+// migrateUp applies the pending migrations; see migrate.Migrator.Up.
+func migrateUp(out io.Writer) error {
+	m, err := migrator()
+	if err != nil {
+		return err
+	}
+	done, err := m.Up(context.Background())
+	for _, mig := range done {
+		fmt.Fprintf(out, "Applied %s.\n", mig)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Fprintf(out, "The schema is up to date at version %d.\n", m.Latest())
+	}
+	return nil
+}
+
+// migrateDown rolls back the last n migrations, one unless args gives n.
+func migrateDown(out io.Writer, args []string) error {
+	n := 1
+	if len(args) == 1 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			return fmt.Errorf("migrate down: %q is not a number of migrations", args[0])
+		}
+	}
+	m, err := migrator()
+	if err != nil {
+		return err
+	}
+	done, err := m.Down(context.Background(), n)
+	for _, mig := range done {
+		fmt.Fprintf(out, "Rolled back %s.\n", mig)
+	}
+	if err != nil {
+		return err
+	}
+	if len(done) == 0 {
+		fmt.Fprintln(out, "No migrations are applied.")
+	}
+	return nil
+}
+
+// migrateStatus prints each migration and when it was applied.
+func migrateStatus(out io.Writer) error {
+	m, err := migrator()
+	if err != nil {
+		return err
+	}
+	states, err := m.Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tAPPLIED")
+	for _, s := range states {
+		when := "pending"
+		switch {
+		case s.Unknown:
+			when = s.AppliedAt.Local().Format(time.DateTime) + " (from a newer build)"
+		case s.AppliedAt != nil:
+			when = s.AppliedAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", s, when)
+	}
+	return w.Flush()
+}
@@ -1,11 +1,14 @@
 // Package server handles startup, configuration, and graceful shutdown of the
-// Nimbus API. InitServer is the single entry point called from main.
+// Nimbus API, and its admin commands. main calls InitServer to serve, or
+// RunCommand for a command such as "keys rotate".
 package server
 
 import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,13 +20,25 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 
+	"github.com/nimbus/api/db/migrate"
 	"github.com/nimbus/api/db/postgres"
 	redisdb "github.com/nimbus/api/db/redis"
 	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/handlers/dav"
+	"github.com/nimbus/api/handlers/sftpd"
+	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/logging"
+	"github.com/nimbus/api/metrics"
 	"github.com/nimbus/api/middleware/bodylimit"
+	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/middleware/requestid"
+	"github.com/nimbus/api/oidc"
 	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/tracing"
 	"github.com/nimbus/api/utils"
+	"golang.org/x/crypto/ssh"
 	"gorm.io/gorm"
 )
 
@@ -67,32 +82,66 @@ func corsConfig(origins []string, allowCredentials bool) cors.Config {
 
 // InitServer bootstraps the entire API:
 //  1. Reads required environment variables
-//  2. Creates the Gin router with logging, recovery, and CORS middleware
-//  3. Connects to S3 and PostgreSQL
+//  2. Sets up structured logging and tracing, and creates the Gin router with
+//     recovery, request ID, tracing, access log, metrics and CORS middleware
+//  3. Connects to S3 and PostgreSQL, checks the schema is migrated, and loads
+//     the JWT signing keys
 //  4. Registers all route groups
-//  5. Starts the HTTP server in a background goroutine
+//  5. Starts the HTTP server (and the optional S3 gateway and SFTP server) in
+//     background goroutines
 //  6. Waits for SIGINT/SIGTERM, then shuts down cleanly within 10 seconds
 func InitServer() error {
+	// Logs are JSON lines on stdout; LOG_LEVEL is debug, info (the default),
+	// warn or error.
+	logLevel, _ := utils.GetEnv("LOG_LEVEL")
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		return fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	logging.Setup(os.Stdout, level)
+
+	// localDev relaxes proxy trust and CORS for local development (see below)
+	// and writes traces to stdout when no OTLP endpoint is configured.
+	localDev, _ := utils.GetEnv("LOCAL_DEV")
+	shutdownTracing, err := tracing.Setup(context.Background(), localDev == "true")
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
 	bucket, err := utils.GetEnv("S3_BUCKET")
 	if err != nil {
 		return err
 	}
 
-	// gin.New() gives us a blank router — we add Logger and Recovery manually
-	// so we keep full control over middleware order.
+	// ARGON2_PARAMS raises (or lowers) the cost of new password hashes, e.g.
+	// "m=131072,t=4,p=4"; existing hashes are upgraded as users log in.
+	if params, _ := utils.GetEnv("ARGON2_PARAMS"); params != "" {
+		p, err := utils.ParseArgon2Params(params)
+		if err != nil {
+			return fmt.Errorf("ARGON2_PARAMS: %w", err)
+		}
+		utils.SetArgon2Params(p)
+	}
+
+	// gin.New() gives us a blank router — we add Recovery and logging manually
+	// so we keep full control over middleware order. The access log needs the
+	// request ID, so it comes after requestid.
 	r := gin.New()
-	r.Use(gin.Logger())
 	r.Use(gin.Recovery())
+	r.Use(requestid.Middleware())
+	r.Use(tracing.Middleware())
+	r.Use(logging.Middleware())
+	r.Use(metrics.Middleware())
 
 	// Cap request body size so a client can't force the server to buffer an
 	// arbitrarily large body. File uploads bypass this (they go straight to S3
-	// via presigned URLs), so a small JSON-sized limit is safe for every route.
-	r.Use(bodylimit.Middleware(bodylimit.DefaultMaxBytes))
+	// via presigned URLs), so a small JSON-sized limit is safe for every route
+	// except WebDAV, which streams file bodies and enforces its own cap.
+	r.Use(bodylimit.MiddlewareExcept(bodylimit.DefaultMaxBytes, dav.Prefix))
 
 	// LOCAL_DEV relaxes proxy trust and CORS for local development. In every other
 	// environment the server is expected to run behind the ALB with an explicit
 	// origin allowlist.
-	localDev, _ := utils.GetEnv("LOCAL_DEV")
 
 	// Trust ALB and private RFC-1918 ranges so X-Forwarded-For gives real client IPs.
 	// In LOCAL_DEV mode trust all proxies since there's no ALB in docker-compose.
@@ -109,7 +158,7 @@ func InitServer() error {
 	if enabled {
 		r.Use(cors.New(corsConfig(origins, allowCredentials)))
 	} else {
-		log.Println("CORS: no CORS_ORIGINS set outside LOCAL_DEV — cross-origin requests are denied (no CORS middleware registered)")
+		slog.Info("CORS: no CORS_ORIGINS set outside LOCAL_DEV — cross-origin requests are denied (no CORS middleware registered)")
 	}
 
 	ctx := context.Background()
@@ -137,7 +186,8 @@ func InitServer() error {
 		Bucket: bucket,
 	}
 
-	// Connect to PostgreSQL and auto-migrate all models.
+	// Connect to PostgreSQL, and refuse to serve on a schema older than this
+	// build expects; "server migrate up" brings it up to date.
 	DB, err = postgres.Connect()
 	if err != nil {
 		return err
@@ -145,11 +195,40 @@ func InitServer() error {
 	if DB == nil {
 		return fmt.Errorf("failed to connect to PostgreSQL")
 	}
+	migrator, err := migrate.New(DB, migrate.FS)
+	if err != nil {
+		return err
+	}
+	if err := migrator.Check(ctx); err != nil {
+		return err
+	}
+	metrics.UseDB(DB)
+
+	// Access tokens are signed with the keyset in the signing_keys table,
+	// reread every minute so a rotation reaches every instance. A new
+	// deployment gets its first key now, using JWT_SIGNING_ALG.
+	signingAlg, _ := utils.GetEnv("JWT_SIGNING_ALG")
+	if signingAlg == "" {
+		signingAlg = jwt.AlgEdDSA
+	}
+	if err := jwt.EnsureSigningKey(DB, signingAlg); err != nil {
+		return fmt.Errorf("failed to create a JWT signing key: %w", err)
+	}
+	if err := jwt.LoadKeys(DB); err != nil {
+		return fmt.Errorf("failed to load JWT signing keys: %w", err)
+	}
+	go jwt.WatchKeys(ctx, DB)
+
+	// Finish deleting any account closed while a previous instance was
+	// shutting down.
+	go storage.New(DB, config).PurgeDeletedUsers(ctx)
 
 	// Build the auth rate limiter: 5 attempts / 15 min per key. Use Redis when
 	// REDIS_ADDR is configured so the limit is shared across all instances behind
 	// the load balancer and survives restarts; otherwise fall back to a
 	// per-process in-memory limiter (fine for single-instance / local dev).
+	// Revoked sessions are looked up in the same Redis, or in the database
+	// without it.
 	redisClient, err := redisdb.Connect(ctx)
 	if err != nil {
 		return err
@@ -157,17 +236,63 @@ func InitServer() error {
 	var authLimiter *ratelimit.Limiter
 	if redisClient != nil {
 		authLimiter = ratelimit.NewWithRedis(redisClient, 5, 15*time.Minute)
-		log.Println("Rate limiter: using Redis (shared across instances)")
+		slog.Info("Rate limiter: using Redis (shared across instances)")
+		jwt.UseRevocationStore(redisClient)
 	} else {
 		authLimiter = ratelimit.New(5, 15*time.Minute)
-		log.Println("Rate limiter: using in-memory store (REDIS_ADDR not set)")
+		slog.Info("Rate limiter: using in-memory store (REDIS_ADDR not set)")
 	}
 
-	// Register all route groups (files, boxes, folders, users).
+	// Authenticated requests reuse the caller's user record for a few seconds
+	// rather than loading it every time; see jwt.ForgetUser.
+	jwt.UseUserCache(jwt.USER_CACHE_TTL)
+
+	// Single sign-on is on when OIDC_ISSUER is set. Discovery runs now so a
+	// misconfigured issuer stops startup instead of failing the first login.
+	if issuer, _ := utils.GetEnv("OIDC_ISSUER"); issuer != "" {
+		clientID, err := utils.GetEnv("OIDC_CLIENT_ID")
+		if err != nil {
+			return err
+		}
+		redirectURL, err := utils.GetEnv("OIDC_REDIRECT_URL")
+		if err != nil {
+			return err
+		}
+		clientSecret, _ := utils.GetEnv("OIDC_CLIENT_SECRET") // optional for public clients
+		provider, err := oidc.NewProvider(ctx, oidc.Config{
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+		})
+		if err != nil {
+			return err
+		}
+		user.UseOIDCProvider(provider)
+		slog.Info("Single sign-on enabled", "issuer", issuer)
+	}
+
+	// Register all route groups (files, boxes, folders, users, WebDAV, audit log,
+	// admin, API docs).
 	routes.InitFileRoutes(r, config, DB)
 	routes.InitBoxRoutes(r, config, DB)
 	routes.InitFolderRoutes(r, config, DB)
-	routes.InitUserRoutes(r, DB, S3, authLimiter)
+	routes.InitUserRoutes(r, config, DB, authLimiter)
+	routes.InitDavRoutes(r, config, DB)
+	routes.InitAuditRoutes(r, DB)
+	routes.InitAdminRoutes(r, config, DB)
+	routes.InitKeyRoutes(r)
+	routes.InitDocsRoutes(r)
+
+	// /metrics is for Prometheus. It reveals traffic and internals, so outside
+	// LOCAL_DEV it is only served when METRICS_TOKEN is set, and scrapers must
+	// send that token as a bearer token.
+	metricsToken, _ := utils.GetEnv("METRICS_TOKEN")
+	if metricsToken != "" || localDev == "true" {
+		routes.InitMetricsRoutes(r, metricsToken)
+	} else {
+		slog.Info("Metrics: METRICS_TOKEN not set — /metrics is disabled")
+	}
 
 	// /health checks both the database and S3 so the ALB only routes traffic to
 	// a fully operational instance. Returns 503 if either dependency is down.
@@ -194,8 +319,58 @@ func InitServer() error {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
 	})
 
+	// The S3-compatible gateway addresses buckets from the root of the URL
+	// ("/<box>/<key>"), so it can't share the API's router and gets its own
+	// listener. It stays off unless S3_GATEWAY_ADDR is set (e.g. ":9000").
+	var gatewaySrv *http.Server
+	if gatewayAddr, _ := utils.GetEnv("S3_GATEWAY_ADDR"); gatewayAddr != "" {
+		gw := gin.New()
+		gw.Use(gin.Recovery())
+		gw.Use(requestid.Middleware())
+		gw.Use(tracing.Middleware())
+		gw.Use(logging.Middleware())
+		gw.Use(metrics.Middleware())
+		routes.InitS3GatewayRoutes(gw, config, DB)
+		gatewaySrv = &http.Server{
+			Addr:         gatewayAddr,
+			Handler:      gw,
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 300 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+		go func() {
+			if err := gatewaySrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("S3 gateway error: %v", err)
+			}
+		}()
+		slog.Info("S3 gateway listening", "addr", gatewayAddr)
+	}
+
+	// SFTP for partners whose tooling can only push files over SSH. Off unless
+	// SFTP_ADDR is set (e.g. ":2022"). SFTP_HOST_KEYS is a comma-separated list
+	// of private key files; LOCAL_DEV may omit it and gets a throwaway key.
+	var sftpSrv *sftpd.Server
+	if sftpAddr, _ := utils.GetEnv("SFTP_ADDR"); sftpAddr != "" {
+		hostKeys, err := sftpHostKeys(localDev == "true")
+		if err != nil {
+			return err
+		}
+		ln, err := net.Listen("tcp", sftpAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for SFTP: %w", err)
+		}
+		sftpSrv = sftpd.New(config, DB, authLimiter, hostKeys...)
+		go func() {
+			if err := sftpSrv.Serve(ln); err != nil && err != sftpd.ErrServerClosed {
+				log.Fatalf("SFTP server error: %v", err)
+			}
+		}()
+		slog.Info("SFTP listening", "addr", sftpAddr)
+	}
+
 	// Configure HTTP server timeouts.
 	// WriteTimeout is generous (300s) to accommodate large file presign operations.
+	// WebDAV and S3 gateway transfers extend both deadlines per request.
 	srv := &http.Server{
 		Addr:         ":8080",
 		Handler:      r,
@@ -215,14 +390,29 @@ func InitServer() error {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
 
 	// Give in-flight requests up to 10 seconds to finish before we close.
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		slog.Error("Server forced to shutdown", "error", err)
+	}
+	if gatewaySrv != nil {
+		if err := gatewaySrv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("S3 gateway forced to shutdown", "error", err)
+		}
+	}
+	if sftpSrv != nil {
+		if err := sftpSrv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("SFTP server forced to shutdown", "error", err)
+		}
+	}
+
+	// Send the last spans before the process exits.
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Tracing shutdown failed", "error", err)
 	}
 
 	// Close the database connection pool cleanly.
@@ -230,6 +420,25 @@ func InitServer() error {
 		_ = sqlDB.Close()
 	}
 
-	log.Println("Server exited cleanly")
+	slog.Info("Server exited cleanly")
 	return nil
 }
+
+// sftpHostKeys loads the SFTP host keys named in SFTP_HOST_KEYS. Without it a
+// key is generated in LOCAL_DEV; anywhere else that would change the server's
+// identity on every deploy, so it is an error.
+func sftpHostKeys(localDev bool) ([]ssh.Signer, error) {
+	paths, _ := utils.GetEnv("SFTP_HOST_KEYS")
+	if paths != "" {
+		return sftpd.LoadHostKeys(strings.Split(paths, ",")...)
+	}
+	if !localDev {
+		return nil, fmt.Errorf("SFTP_HOST_KEYS must be set when SFTP_ADDR is")
+	}
+	slog.Warn("SFTP: SFTP_HOST_KEYS not set — using a temporary host key (LOCAL_DEV only)")
+	key, err := sftpd.GenerateHostKey()
+	if err != nil {
+		return nil, err
+	}
+	return []ssh.Signer{key}, nil
+}
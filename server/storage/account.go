@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/nimbus/api/models"
+	"gorm.io/gorm"
+)
+
+// userPrefix is the S3 prefix every object of userID lives under.
+func userPrefix(userID uint) string {
+	return fmt.Sprintf("users/nim-user-%d/", userID)
+}
+
+// DeleteUser permanently removes an account: unfinished multipart uploads,
+// every S3 object under the user's prefix, then the database rows of the
+// user, their boxes, folders, files and credentials. S3 is cleared first so a
+// failure leaves the rows in place for PurgeDeletedUsers to try again. The
+// account's audit events are kept.
+func (s *Store) DeleteUser(ctx context.Context, userID uint) error {
+	if s.hasS3() {
+		var uploads []models.S3Upload
+		if err := s.DB.Where("user_id = ?", userID).Find(&uploads).Error; err != nil {
+			return err
+		}
+		for _, up := range uploads {
+			s.abortUpload(ctx, up.S3Key, up.UploadID)
+		}
+		if err := s.deletePrefix(ctx, userPrefix(userID)); err != nil {
+			return err
+		}
+	}
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		// Children before parents, so this works with or without the
+		// foreign-key cascades.
+		for _, m := range []any{
+			&models.File{}, &models.Folder{}, &models.S3Upload{}, &models.Box{},
+			&models.RefreshToken{}, &models.Session{}, &models.LoginChallenge{}, &models.RecoveryCode{},
+			&models.PersonalAccessToken{}, &models.AppPassword{}, &models.S3AccessKey{}, &models.SSHKey{},
+			&models.Identity{}, &models.DeviceAuthorization{},
+		} {
+			if err := tx.Unscoped().Where("user_id = ?", userID).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&models.User{}, userID).Error
+	})
+}
+
+// PurgeDeletedUsers finishes deleting every closed account (soft-deleted
+// users) — ones whose deletion failed, or was cut short by a restart.
+func (s *Store) PurgeDeletedUsers(ctx context.Context) {
+	var ids []uint
+	if err := s.DB.Unscoped().Model(&models.User{}).Where("deleted_at IS NOT NULL").Pluck("id", &ids).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to list closed accounts", "error", err)
+		return
+	}
+	for _, id := range ids {
+		if err := s.DeleteUser(ctx, id); err != nil {
+			slog.ErrorContext(ctx, "Account purge failed", "user_id", id, "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Account purged", "user_id", id)
+	}
+}
+
+// deletePrefix removes every S3 object whose key starts with prefix.
+func (s *Store) deletePrefix(ctx context.Context, prefix string) error {
+	var continuationToken *string
+	for {
+		out, err := s.S3.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:            &s.S3.Bucket,
+			Prefix:            &prefix,
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return err
+		}
+		for _, obj := range out.Contents {
+			if _, err := s.S3.Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.S3.Bucket, Key: obj.Key}); err != nil {
+				return err
+			}
+		}
+		if out.IsTruncated == nil || !*out.IsTruncated || out.NextContinuationToken == nil {
+			return nil
+		}
+		continuationToken = out.NextContinuationToken
+	}
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/nimbus/api/models"
+	"gorm.io/gorm"
+)
+
+// StartUpload begins a client-driven multipart upload of p. The parts are
+// written straight to the backing bucket; nothing appears in the box until
+// CompleteUpload, which also creates any missing parent folders.
+func (s *Store) StartUpload(ctx context.Context, box *models.Box, p string) (*models.S3Upload, error) {
+	if !s.hasS3() {
+		return nil, ErrNoStorage
+	}
+	p, err := CleanPath(p)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := split(p)
+	if !validName(name) {
+		return nil, ErrInvalidPath
+	}
+	if e, err := s.Stat(box, p); err == nil && e.IsDir {
+		return nil, ErrIsDir
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	key, err := s.newKey(box, dir, name)
+	if err != nil {
+		return nil, err
+	}
+	contentType := ContentType(name)
+	out, err := s.S3.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      &s.S3.Bucket,
+		Key:         &key,
+		ContentType: &contentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	up := &models.S3Upload{
+		UploadID: aws.ToString(out.UploadId),
+		UserID:   box.UserID,
+		BoxID:    box.ID,
+		Path:     p,
+		S3Key:    key,
+	}
+	if err := s.DB.Create(up).Error; err != nil {
+		s.abortUpload(context.WithoutCancel(ctx), key, up.UploadID)
+		return nil, err
+	}
+	return up, nil
+}
+
+// Upload loads an in-progress multipart upload owned by userID, together with
+// the box it targets.
+func (s *Store) Upload(userID uint, uploadID string) (*models.S3Upload, *models.Box, error) {
+	var up models.S3Upload
+	res := s.DB.Where("upload_id = ? AND user_id = ?", uploadID, userID).Limit(1).Find(&up)
+	if res.Error != nil {
+		return nil, nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil, ErrNoSuchUpload
+	}
+	var box models.Box
+	if err := s.DB.First(&box, up.BoxID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrNoSuchUpload
+		}
+		return nil, nil, err
+	}
+	return &up, &box, nil
+}
+
+// PutPart streams one part of up to the backing bucket and returns its ETag,
+// which the client hands back to CompleteUpload. size must be the exact part
+// length: S3 needs it up front to accept a body it can't seek.
+func (s *Store) PutPart(ctx context.Context, up *models.S3Upload, partNumber int32, body io.Reader, size int64) (string, error) {
+	if size > MaxFileSize {
+		return "", ErrTooLarge
+	}
+	out, err := s.S3.Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        &s.S3.Bucket,
+		Key:           &up.S3Key,
+		UploadId:      &up.UploadID,
+		PartNumber:    aws.Int32(partNumber),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	}, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
+}
+
+// CompleteUpload assembles the listed parts and records the result as a file,
+// replacing any file already at the upload's path. The size cap is checked on
+// the assembled object, since parts are accepted individually.
+func (s *Store) CompleteUpload(ctx context.Context, up *models.S3Upload, box *models.Box, parts []types.CompletedPart) (*Entry, error) {
+	if _, err := s.S3.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.S3.Bucket,
+		Key:             &up.S3Key,
+		UploadId:        &up.UploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return nil, err
+	}
+	s.DB.Unscoped().Delete(up)
+
+	head, err := s.S3.Client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.S3.Bucket, Key: &up.S3Key})
+	if err != nil {
+		s.deleteObject(context.WithoutCancel(ctx), up.S3Key)
+		return nil, err
+	}
+	size := aws.ToInt64(head.ContentLength)
+	if size > MaxFileSize {
+		s.deleteObject(context.WithoutCancel(ctx), up.S3Key)
+		return nil, ErrTooLarge
+	}
+
+	dir, _ := split(up.Path)
+	if _, err := s.MkdirAll(ctx, box, dir); err != nil {
+		s.deleteObject(context.WithoutCancel(ctx), up.S3Key)
+		return nil, err
+	}
+	return s.commit(ctx, box, up.Path, up.S3Key, size)
+}
+
+// AbortUpload discards up and any parts already stored.
+func (s *Store) AbortUpload(ctx context.Context, up *models.S3Upload) error {
+	s.abortUpload(ctx, up.S3Key, up.UploadID)
+	return s.DB.Unscoped().Delete(up).Error
+}
+
+// abortUpload tells the backing bucket to drop an upload's parts. Failures
+// are only logged; a bucket lifecycle rule cleans up what is left behind.
+func (s *Store) abortUpload(ctx context.Context, key, uploadID string) {
+	if _, err := s.S3.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &s.S3.Bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	}); err != nil {
+		slog.WarnContext(ctx, "S3 multipart abort failed", "key", key, "error", err)
+	}
+}
@@ -0,0 +1,453 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils/helpers"
+	"gorm.io/gorm"
+)
+
+// Mkdir creates the folder p. Its parent must already exist and nothing may
+// occupy p yet. A zero-byte placeholder object is written to S3 as well, the
+// same way folder.Create does, so the folder shows up in the S3 console and in
+// prefix-based downloads. The row goes in first, so a concurrent Mkdir of the
+// same path is stopped by the unique path index before it writes anything to
+// S3, and the row is removed again if the placeholder can't be written.
+func (s *Store) Mkdir(ctx context.Context, box *models.Box, p string) (*Entry, error) {
+	p, err := CleanPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		return nil, ErrExist
+	}
+	dir, name := split(p)
+	if !validName(name) {
+		return nil, ErrInvalidPath
+	}
+
+	parent, err := s.Stat(box, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsDir {
+		return nil, ErrNotDir
+	}
+	if _, err := s.Stat(box, p); err == nil {
+		return nil, ErrExist
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	folder := &models.Folder{
+		Name:     name,
+		UserID:   box.UserID,
+		BoxID:    box.ID,
+		ParentID: parent.FolderID(),
+		Path:     p,
+	}
+	if err := s.DB.Create(folder).Error; err != nil {
+		if _, statErr := s.Stat(box, p); statErr == nil {
+			return nil, ErrExist
+		}
+		return nil, err
+	}
+
+	if err := s.putPlaceholder(ctx, box, p); err != nil {
+		s.DB.Unscoped().Delete(folder)
+		return nil, fmt.Errorf("create folder placeholder: %w", err)
+	}
+	return folderEntry(folder, p), nil
+}
+
+// MkdirAll creates p and any missing folders above it, like os.MkdirAll.
+// Folders that already exist are left alone; a file in the way is ErrNotDir.
+func (s *Store) MkdirAll(ctx context.Context, box *models.Box, p string) (*Entry, error) {
+	p, err := CleanPath(p)
+	if err != nil {
+		return nil, err
+	}
+	e, err := s.Stat(box, "")
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		return e, nil
+	}
+	for _, seg := range strings.Split(p, "/") {
+		next := path.Join(e.Path, seg)
+		e, err = s.Stat(box, next)
+		if errors.Is(err, ErrNotFound) {
+			e, err = s.Mkdir(ctx, box, next)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsDir {
+			return nil, ErrNotDir
+		}
+	}
+	return e, nil
+}
+
+// Put streams body into a new S3 object and records it as the file p, which is
+// confirmed immediately because the bytes went through the server. An existing
+// file at p is replaced only once the new object is safely stored. sizeHint is
+// the declared length (-1 when unknown) and is only used to reject oversized
+// uploads, or ones over the owner's quota, before any data is read.
+func (s *Store) Put(ctx context.Context, box *models.Box, p string, body io.Reader, sizeHint int64) (*Entry, error) {
+	if !s.hasS3() {
+		return nil, ErrNoStorage
+	}
+	if sizeHint > MaxFileSize {
+		return nil, ErrTooLarge
+	}
+	p, err := CleanPath(p)
+	if err != nil {
+		return nil, err
+	}
+	dir, name := split(p)
+	if !validName(name) {
+		return nil, ErrInvalidPath
+	}
+
+	parent, err := s.Stat(box, dir)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsDir {
+		return nil, ErrNotDir
+	}
+	add := sizeHint
+	if e, err := s.Stat(box, p); err == nil && e.IsDir {
+		return nil, ErrIsDir
+	} else if err == nil {
+		add -= e.Size
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if err := CheckQuota(s.DB, box.UserID, add); err != nil {
+		return nil, err
+	}
+
+	key, err := s.newKey(box, dir, name)
+	if err != nil {
+		return nil, err
+	}
+	size, err := s.upload(ctx, key, ContentType(name), &capReader{r: body, left: MaxFileSize})
+	if err != nil {
+		return nil, err
+	}
+	return s.commit(ctx, box, p, key, size)
+}
+
+// commit records the object already stored under key as the file p, replacing
+// whatever file was there, and keeps the box size in step within the owner's
+// quota. The parent folder and any existing file are looked up again here
+// rather than trusted from before the upload, which may have taken minutes.
+// If the database update fails the new object is deleted; on success the
+// replaced one is.
+func (s *Store) commit(ctx context.Context, box *models.Box, p, key string, size int64) (*Entry, error) {
+	cleanup := context.WithoutCancel(ctx)
+	dir, name := split(p)
+	parent, err := s.Stat(box, dir)
+	if err == nil && !parent.IsDir {
+		err = ErrNotDir
+	}
+	var existing *Entry
+	if err == nil {
+		existing, err = s.Stat(box, p)
+		switch {
+		case err == nil && existing.IsDir:
+			err = ErrIsDir
+		case errors.Is(err, ErrNotFound):
+			existing, err = nil, nil
+		}
+	}
+	if err == nil {
+		// Checked again now the size is known: sizeHint may have been missing.
+		delta := size
+		if existing != nil {
+			delta -= existing.File.Size
+		}
+		err = CheckQuota(s.DB, box.UserID, delta)
+	}
+	if err != nil {
+		s.deleteObject(cleanup, key)
+		return nil, err
+	}
+
+	file := &models.File{
+		Name:      name,
+		Size:      size,
+		S3Key:     key,
+		Confirmed: true,
+		UserID:    box.UserID,
+		BoxID:     box.ID,
+		FolderID:  parent.FolderID(),
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+		delta := size
+		if existing != nil {
+			if err := tx.Delete(existing.File).Error; err != nil {
+				return err
+			}
+			delta -= existing.File.Size
+		}
+		return tx.Model(&models.Box{}).Where("id = ?", box.ID).
+			UpdateColumn("size", gorm.Expr("size + ?", delta)).Error
+	})
+	if err != nil {
+		s.deleteObject(cleanup, key)
+		return nil, err
+	}
+	if existing != nil {
+		s.deleteObject(cleanup, existing.File.S3Key)
+	}
+	return fileEntry(file, p), nil
+}
+
+// Open returns up to length bytes of file's data starting at offset, or
+// everything from offset on when length is negative. Reading past the end
+// yields an empty reader rather than S3's 416 error.
+func (s *Store) Open(ctx context.Context, file *models.File, offset, length int64) (io.ReadCloser, error) {
+	if !s.hasS3() {
+		return nil, ErrNoStorage
+	}
+	if offset >= file.Size || length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	input := &s3.GetObjectInput{Bucket: &s.S3.Bucket, Key: &file.S3Key}
+	switch {
+	case length > 0:
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	out, err := s.S3.Client.GetObject(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// Remove deletes the file or folder at p. Folders are removed recursively with
+// everything inside them. The box root itself can't be removed here; deleting a
+// box stays a REST-only operation.
+func (s *Store) Remove(ctx context.Context, box *models.Box, p string) error {
+	e, err := s.Stat(box, p)
+	if err != nil {
+		return err
+	}
+	if e.Path == "" {
+		return ErrInvalidPath
+	}
+
+	var folders []models.Folder
+	var files []models.File
+	if e.IsDir {
+		if folders, files, err = DeleteFolder(s.DB, box, e.Path); err != nil {
+			return err
+		}
+	} else {
+		files = []models.File{*e.File}
+		err = s.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(e.File).Error; err != nil {
+				return err
+			}
+			if !e.File.Confirmed {
+				return nil
+			}
+			return tx.Model(&models.Box{}).Where("id = ?", box.ID).
+				UpdateColumn("size", gorm.Expr("size - ?", e.File.Size)).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The records are gone, so the objects are unreachable either way; a failed
+	// S3 delete only leaves an orphan behind and isn't reported to the caller.
+	for _, f := range files {
+		s.deleteObject(ctx, f.S3Key)
+	}
+	for _, f := range folders {
+		s.deleteObject(ctx, box.S3Prefix+f.Path+"/")
+	}
+	return nil
+}
+
+// Move renames and/or re-parents the file or folder at srcPath in srcBox to
+// dstPath in dstBox. Files keep their S3 objects, whose keys are opaque; a
+// folder's placeholder objects, which are named after its path, are moved
+// along with its rows. Both boxes must belong to the same user, the
+// destination's parent must exist, and nothing may occupy the destination yet.
+func (s *Store) Move(ctx context.Context, srcBox *models.Box, srcPath string, dstBox *models.Box, dstPath string) error {
+	if srcBox.UserID != dstBox.UserID {
+		return ErrCrossUserMove
+	}
+	src, err := s.Stat(srcBox, srcPath)
+	if err != nil {
+		return err
+	}
+	dstPath, err = CleanPath(dstPath)
+	if err != nil {
+		return err
+	}
+	if src.Path == "" || dstPath == "" {
+		return ErrInvalidPath
+	}
+	if srcBox.ID == dstBox.ID && src.IsDir && (dstPath == src.Path || strings.HasPrefix(dstPath, src.Path+"/")) {
+		// Moving a folder into itself would detach the subtree from the box.
+		return ErrInvalidPath
+	}
+
+	dir, name := split(dstPath)
+	if !validName(name) {
+		return ErrInvalidPath
+	}
+	parent, err := s.Stat(dstBox, dir)
+	if err != nil {
+		return err
+	}
+	if !parent.IsDir {
+		return ErrNotDir
+	}
+	if _, err := s.Stat(dstBox, dstPath); err == nil {
+		return ErrExist
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if src.IsDir {
+		oldPath := src.Path
+		var folders []models.Folder
+		if s.hasS3() {
+			if folders, err = Subtree(s.DB, srcBox.ID, oldPath); err != nil {
+				return err
+			}
+		}
+		if err := MoveFolder(s.DB, src.Folder, dstBox, parent.Folder, name); err != nil {
+			return err
+		}
+		// The placeholders follow the rows; the database is already right, so
+		// a failure here is only logged.
+		for _, f := range folders {
+			newPath := dstPath + strings.TrimPrefix(f.Path, oldPath)
+			if err := s.putPlaceholder(ctx, dstBox, newPath); err != nil {
+				slog.WarnContext(ctx, "S3 folder placeholder write failed", "path", newPath, "error", err)
+			}
+			s.deleteObject(ctx, srcBox.S3Prefix+f.Path+"/")
+		}
+		return nil
+	}
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(src.File).Updates(map[string]any{
+			"name":      name,
+			"folder_id": parent.FolderID(),
+			"box_id":    dstBox.ID,
+		}).Error; err != nil {
+			return err
+		}
+		if srcBox.ID != dstBox.ID && src.File.Confirmed {
+			return moveSize(tx, srcBox.ID, dstBox.ID, src.File.Size)
+		}
+		return nil
+	})
+}
+
+// moveSize shifts n bytes of accounted usage from one box to another.
+func moveSize(tx *gorm.DB, fromBoxID, toBoxID uint, n int64) error {
+	if err := tx.Model(&models.Box{}).Where("id = ?", fromBoxID).
+		UpdateColumn("size", gorm.Expr("size - ?", n)).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Box{}).Where("id = ?", toBoxID).
+		UpdateColumn("size", gorm.Expr("size + ?", n)).Error
+}
+
+// newKey builds an S3 key for a new upload with helpers.GenerateS3Key. That
+// key is only unique to the second, and File.S3Key is unique even across
+// soft-deleted rows, so a quick overwrite of the same file gets a numeric
+// suffix instead of failing on the constraint. Keys reserved by unfinished
+// multipart uploads count as taken too.
+func (s *Store) newKey(box *models.Box, dir, name string) (string, error) {
+	base, err := helpers.GenerateS3Key(dir, name, box)
+	if err != nil {
+		return "", ErrInvalidPath
+	}
+	key := base
+	for i := 1; ; i++ {
+		var files, uploads int64
+		if err := s.DB.Unscoped().Model(&models.File{}).Where("s3_key = ?", key).Count(&files).Error; err != nil {
+			return "", err
+		}
+		if err := s.DB.Model(&models.S3Upload{}).Where("s3_key = ?", key).Count(&uploads).Error; err != nil {
+			return "", err
+		}
+		if files+uploads == 0 {
+			return key, nil
+		}
+		key = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+// putPlaceholder writes the zero-byte object that stands for folder p in S3.
+// Without S3 there is nothing to write.
+func (s *Store) putPlaceholder(ctx context.Context, box *models.Box, p string) error {
+	if !s.hasS3() {
+		return nil
+	}
+	key := box.S3Prefix + p + "/"
+	_, err := s.S3.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &s.S3.Bucket,
+		Key:    &key,
+		Body:   strings.NewReader(""),
+	})
+	return err
+}
+
+// deleteObject removes key from S3, logging instead of failing: callers use it
+// for clean-up after the database is already consistent.
+func (s *Store) deleteObject(ctx context.Context, key string) {
+	if !s.hasS3() {
+		return
+	}
+	if _, err := s.S3.Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.S3.Bucket, Key: &key}); err != nil {
+		slog.WarnContext(ctx, "S3 delete failed", "key", key, "error", err)
+	}
+}
+
+func (s *Store) hasS3() bool {
+	return s.S3.Client != nil && s.S3.Bucket != ""
+}
+
+// ETag returns the entity tag front-ends report for file. Every upload
+// creates a new File row, so the row ID changes whenever the content does. It
+// is deliberately not an MD5: the dash tells S3 clients not to compare it with
+// a checksum of their own.
+func ETag(file *models.File) string {
+	return fmt.Sprintf(`"%x-%x"`, file.ID, file.Size)
+}
+
+// ContentType guesses a MIME type from the file extension, so front-ends can
+// report one without reading the object.
+func ContentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
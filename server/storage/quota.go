@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"github.com/nimbus/api/models"
+	"gorm.io/gorm"
+)
+
+// Usage returns the bytes userID stores across all their boxes. Like box
+// sizes, it counts only confirmed files.
+func Usage(db *gorm.DB, userID uint) (int64, error) {
+	var used int64
+	err := db.Model(&models.Box{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size), 0)").Scan(&used).Error
+	return used, err
+}
+
+// QuotaError is the error CheckQuota returns when a write won't fit. It
+// matches ErrQuotaExceeded with errors.Is.
+type QuotaError struct {
+	QuotaBytes int64
+	UsedBytes  int64
+}
+
+func (e *QuotaError) Error() string { return ErrQuotaExceeded.Error() }
+
+func (e *QuotaError) Unwrap() error { return ErrQuotaExceeded }
+
+// CheckQuota returns a *QuotaError if storing add more bytes would take
+// userID past the quota an admin set (see models.User.QuotaBytes). Accounts
+// without a quota, and changes that don't add bytes, always pass.
+func CheckQuota(db *gorm.DB, userID uint, add int64) error {
+	if add <= 0 {
+		return nil
+	}
+	var quota int64
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Select("quota_bytes").Scan(&quota).Error; err != nil {
+		return err
+	}
+	if quota <= 0 {
+		return nil
+	}
+	used, err := Usage(db, userID)
+	if err != nil {
+		return err
+	}
+	if used+add > quota {
+		return &QuotaError{QuotaBytes: quota, UsedBytes: used}
+	}
+	return nil
+}
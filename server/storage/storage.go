@@ -0,0 +1,282 @@
+// Package storage exposes a user's boxes as a path-addressed file tree. It maps
+// slash-separated paths such as "reports/2024/q1.pdf" onto the Box, Folder and
+// File models and the S3 objects behind them, so the protocol front-ends
+// (handlers/dav for WebDAV, handlers/sftpd for SFTP and handlers/s3gw for the
+// S3 gateway) share one implementation of lookup, upload, delete and move
+// instead of each re-deriving it from the REST handlers, which use the folder
+// tree helpers here too.
+//
+// Every method is scoped to a *models.Box the caller has already resolved for
+// the authenticated user, which keeps ownership checks in one place: once a box
+// has been loaded with Box(userID, name), nothing in this package can reach
+// outside it.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/models"
+	"gorm.io/gorm"
+)
+
+// MaxFileSize mirrors the 50 MB cap enforced by the presigned upload flow so a
+// file can't be larger just because it arrived through a different protocol.
+const MaxFileSize int64 = 50 * 1024 * 1024
+
+// Errors returned by Store. Front-ends translate them into their own status
+// codes (e.g. 404/409/412 for WebDAV).
+var (
+	ErrNotFound      = errors.New("no such file or folder")
+	ErrExist         = errors.New("a file or folder with that name already exists")
+	ErrNotDir        = errors.New("not a folder")
+	ErrIsDir         = errors.New("is a folder")
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrTooLarge      = fmt.Errorf("file size must be %dMB or less", MaxFileSize/(1024*1024))
+	ErrNoStorage     = errors.New("S3 client or bucket not configured")
+	ErrCrossUserMove = errors.New("cannot move between different users' boxes")
+	ErrNoSuchUpload  = errors.New("no such multipart upload")
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+// Store resolves paths inside boxes and performs file operations against the
+// database and S3. The zero value is not usable; both fields must be set
+// (S3 may be left empty in tests that never touch object data).
+type Store struct {
+	DB *gorm.DB
+	S3 s3db.Config
+}
+
+// New returns a Store backed by db and the given S3 configuration.
+func New(db *gorm.DB, config s3db.Config) *Store {
+	return &Store{DB: db, S3: config}
+}
+
+// WithContext returns a copy of s whose queries run with ctx, so they stop
+// when it is cancelled and are traced as part of its request.
+func (s *Store) WithContext(ctx context.Context) *Store {
+	return &Store{DB: s.DB.WithContext(ctx), S3: s.S3}
+}
+
+// Entry describes one node in a box: the box root, a folder, or a file.
+// Exactly one of Folder and File is set for anything below the root.
+type Entry struct {
+	Name    string
+	Path    string // slash path inside the box, "" for the root
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	Folder  *models.Folder
+	File    *models.File
+}
+
+// FolderID returns the folder the entry represents, or nil for the box root.
+// It is only meaningful for directories.
+func (e *Entry) FolderID() *uint {
+	if e.Folder == nil {
+		return nil
+	}
+	return &e.Folder.ID
+}
+
+// CleanPath normalises a user-supplied path to the form used throughout this
+// package: no leading or trailing slash, no "." or empty segments. It rejects
+// paths that try to climb out of the box with "..".
+func CleanPath(p string) (string, error) {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", ErrInvalidPath
+		}
+	}
+	cleaned := strings.Trim(path.Clean("/"+p), "/")
+	return cleaned, nil
+}
+
+// split returns the parent directory and final element of a cleaned path.
+func split(p string) (dir, name string) {
+	dir, name = path.Split(p)
+	return strings.TrimSuffix(dir, "/"), name
+}
+
+// validName rejects names that can't be stored as a single path segment.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// Boxes returns every box owned by userID, ordered by name.
+func (s *Store) Boxes(userID uint) ([]models.Box, error) {
+	var boxes []models.Box
+	if err := s.DB.Where("user_id = ?", userID).Order("name").Find(&boxes).Error; err != nil {
+		return nil, err
+	}
+	return boxes, nil
+}
+
+// Box loads the box called name owned by userID.
+func (s *Store) Box(userID uint, name string) (*models.Box, error) {
+	var box models.Box
+	if err := s.DB.Where("name = ? AND user_id = ?", name, userID).First(&box).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &box, nil
+}
+
+// Stat resolves p inside box. The box root ("" or "/") is reported as a
+// directory named after the box.
+func (s *Store) Stat(box *models.Box, p string) (*Entry, error) {
+	p, err := CleanPath(p)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		return &Entry{Name: box.Name, IsDir: true, ModTime: box.UpdatedAt}, nil
+	}
+
+	// Folders win over files with the same name; the REST API never creates
+	// both, and a directory listing can only show one of them.
+	if folder, err := FolderByPath(s.DB, box.ID, p); err == nil {
+		return folderEntry(folder, p), nil
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	dir, name := split(p)
+	file, err := s.childFile(box, dir, name)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return fileEntry(file, p), nil
+}
+
+// ReadDir lists the folders and confirmed files directly inside p, folders
+// first, each group ordered by name.
+func (s *Store) ReadDir(box *models.Box, p string) ([]Entry, error) {
+	dir, err := s.Stat(box, p)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir {
+		return nil, ErrNotDir
+	}
+
+	var folders []models.Folder
+	if err := scopeParent(s.DB.Where("box_id = ?", box.ID), "parent_id", dir.FolderID()).
+		Order("name").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	var files []models.File
+	if err := scopeParent(s.DB.Where("box_id = ? AND confirmed = ?", box.ID, true), "folder_id", dir.FolderID()).
+		Order("name, id DESC").Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(folders)+len(files))
+	seen := make(map[string]bool, len(folders)+len(files))
+	for i := range folders {
+		if seen[folders[i].Name] {
+			continue
+		}
+		seen[folders[i].Name] = true
+		entries = append(entries, *folderEntry(&folders[i], path.Join(dir.Path, folders[i].Name)))
+	}
+	// Duplicate names can exist from repeated REST uploads; the newest one
+	// (highest ID, first after the sort) is the one Stat resolves to.
+	for i := range files {
+		if seen[files[i].Name] {
+			continue
+		}
+		seen[files[i].Name] = true
+		entries = append(entries, *fileEntry(&files[i], path.Join(dir.Path, files[i].Name)))
+	}
+	return entries, nil
+}
+
+// Walk returns every folder and confirmed file in box with its full path,
+// ordered by path. It loads the whole box in two queries, which is what
+// flat, prefix-based listings (the S3 gateway) need instead of one ReadDir
+// per folder.
+func (s *Store) Walk(box *models.Box) ([]Entry, error) {
+	var folders []models.Folder
+	if err := s.DB.Where("box_id = ?", box.ID).Order("path").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	var files []models.File
+	if err := s.DB.Where("box_id = ? AND confirmed = ?", box.ID, true).Order("name, id DESC").Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	paths := make(map[uint]string, len(folders))
+	entries := make([]Entry, 0, len(folders)+len(files))
+	seen := make(map[string]bool, len(folders)+len(files))
+	for i := range folders {
+		paths[folders[i].ID] = folders[i].Path
+		seen[folders[i].Path] = true
+		entries = append(entries, *folderEntry(&folders[i], folders[i].Path))
+	}
+	// Same tie-breaking as ReadDir: folders first, then the newest file.
+	for i := range files {
+		p := files[i].Name
+		if files[i].FolderID != nil {
+			dir, ok := paths[*files[i].FolderID]
+			if !ok {
+				continue // folder outside the box
+			}
+			p = dir + "/" + p
+		}
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		entries = append(entries, *fileEntry(&files[i], p))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// childFile finds the newest confirmed file called name directly in the
+// folder at dir, resolving the folder in the same query.
+func (s *Store) childFile(box *models.Box, dir, name string) (*models.File, error) {
+	var file models.File
+	q := s.DB.Where("name = ? AND box_id = ? AND confirmed = ?", name, box.ID, true)
+	if dir == "" {
+		q = q.Where("folder_id IS NULL")
+	} else {
+		q = q.Where("folder_id = (?)", s.DB.Model(&models.Folder{}).Select("id").Where("box_id = ? AND path = ?", box.ID, dir))
+	}
+	res := q.Order("id DESC").Limit(1).Find(&file)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &file, nil
+}
+
+// scopeParent adds "<column> IS NULL" or "<column> = ?" depending on whether
+// id points at the box root.
+func scopeParent(q *gorm.DB, column string, id *uint) *gorm.DB {
+	if id == nil {
+		return q.Where(column + " IS NULL")
+	}
+	return q.Where(column+" = ?", *id)
+}
+
+func folderEntry(f *models.Folder, p string) *Entry {
+	return &Entry{Name: f.Name, Path: p, IsDir: true, ModTime: f.UpdatedAt, Folder: f}
+}
+
+func fileEntry(f *models.File, p string) *Entry {
+	return &Entry{Name: f.Name, Path: p, Size: f.Size, ModTime: f.UpdatedAt, File: f}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"unicode/utf8"
+
+	"github.com/nimbus/api/models"
+	"gorm.io/gorm"
+)
+
+// The folder tree is stored with materialized paths (models.Folder.Path), so
+// each operation below is a fixed number of statements however deep the tree
+// is. A subtree is selected by path prefix; substr is used rather than LIKE
+// because LIKE treats "%" and "_" in folder names as wildcards and, in SQLite,
+// ignores case.
+
+// inSubtree scopes a folders query to the folder at p in boxID and every
+// folder below it.
+func inSubtree(boxID uint, p string) func(*gorm.DB) *gorm.DB {
+	prefix := p + "/"
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("folders.box_id = ? AND (folders.path = ? OR substr(folders.path, 1, ?) = ?)",
+			boxID, p, utf8.RuneCountInString(prefix), prefix)
+	}
+}
+
+// subtreeIDs is a subquery selecting the IDs of the folder at p in boxID and
+// every folder below it.
+func subtreeIDs(db *gorm.DB, boxID uint, p string) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).Model(&models.Folder{}).Select("folders.id").Scopes(inSubtree(boxID, p))
+}
+
+// FolderByPath loads the folder at p, a cleaned path other than the root,
+// inside boxID.
+func FolderByPath(db *gorm.DB, boxID uint, p string) (*models.Folder, error) {
+	var folder models.Folder
+	// Find rather than First: a miss is routine (every Stat of a file probes
+	// for a folder first) and shouldn't be logged as a GORM error.
+	res := db.Where("box_id = ? AND path = ?", boxID, p).Limit(1).Find(&folder)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &folder, nil
+}
+
+// Subtree returns the folder at p in boxID and every folder below it, ordered
+// by path, so each folder comes before its contents.
+func Subtree(db *gorm.DB, boxID uint, p string) ([]models.Folder, error) {
+	var folders []models.Folder
+	if err := db.Scopes(inSubtree(boxID, p)).Order("path").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// FolderSizes returns the bytes of confirmed files anywhere below each of
+// folders, keyed by folder ID. Folders holding nothing are left out.
+func FolderSizes(db *gorm.DB, folders []models.Folder) (map[uint]int64, error) {
+	sizes := make(map[uint]int64, len(folders))
+	if len(folders) == 0 {
+		return sizes, nil
+	}
+	ids := make([]uint, len(folders))
+	for i, f := range folders {
+		ids[i] = f.ID
+	}
+
+	var rows []struct {
+		ID   uint
+		Size int64
+	}
+	err := db.Table("folders AS top").
+		Select("top.id AS id, COALESCE(SUM(files.size), 0) AS size").
+		Joins("JOIN folders AS sub ON sub.box_id = top.box_id AND sub.deleted_at IS NULL AND "+
+			"(sub.path = top.path OR substr(sub.path, 1, length(top.path) + 1) = top.path || '/')").
+		Joins("JOIN files ON files.folder_id = sub.id AND files.confirmed = ? AND files.deleted_at IS NULL", true).
+		Where("top.id IN ?", ids).
+		Group("top.id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		sizes[r.ID] = r.Size
+	}
+	return sizes, nil
+}
+
+// DeleteFolder soft-deletes the folder at p in box with every folder and file
+// below it, and takes the confirmed files' bytes off the box's size, in one
+// transaction. It returns what it deleted so the caller can remove the S3
+// objects and folder placeholders.
+func DeleteFolder(db *gorm.DB, box *models.Box, p string) ([]models.Folder, []models.File, error) {
+	var folders []models.Folder
+	var files []models.File
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if folders, err = Subtree(tx, box.ID, p); err != nil {
+			return err
+		}
+		if len(folders) == 0 {
+			return ErrNotFound
+		}
+		if err := tx.Where("folder_id IN (?)", subtreeIDs(tx, box.ID, p)).Find(&files).Error; err != nil {
+			return err
+		}
+
+		var freed int64
+		for _, f := range files {
+			if f.Confirmed {
+				freed += f.Size
+			}
+		}
+		if err := tx.Where("folder_id IN (?)", subtreeIDs(tx, box.ID, p)).Delete(&models.File{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Scopes(inSubtree(box.ID, p)).Delete(&models.Folder{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Box{}).Where("id = ?", box.ID).
+			UpdateColumn("size", gorm.Expr("size - ?", freed)).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return folders, files, nil
+}
+
+// MoveFolder renames f to name and re-parents it under parent (nil for the
+// root of dst), rewriting the path of every folder below it in one statement.
+// When dst isn't f's box, the folders and their files move to dst and the
+// files' bytes are moved between the boxes' sizes. The caller checks that
+// nothing occupies the destination and that f isn't moved into itself.
+func MoveFolder(db *gorm.DB, f *models.Folder, dst *models.Box, parent *models.Folder, name string) error {
+	newPath := name
+	var parentID *uint
+	if parent != nil {
+		newPath = parent.Path + "/" + name
+		parentID = &parent.ID
+	}
+	srcBoxID, oldPath := f.BoxID, f.Path
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if srcBoxID != dst.ID {
+			var moved int64
+			if err := tx.Model(&models.File{}).
+				Where("folder_id IN (?) AND confirmed = ?", subtreeIDs(tx, srcBoxID, oldPath), true).
+				Select("COALESCE(SUM(size), 0)").Scan(&moved).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.File{}).Where("folder_id IN (?)", subtreeIDs(tx, srcBoxID, oldPath)).
+				Update("box_id", dst.ID).Error; err != nil {
+				return err
+			}
+			if err := moveSize(tx, srcBoxID, dst.ID, moved); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.Folder{}).Where("id = ?", f.ID).Updates(map[string]any{
+			"name":      name,
+			"parent_id": parentID,
+		}).Error; err != nil {
+			return err
+		}
+		// The old prefix is replaced by the new one on the folder and all its
+		// descendants at once; substr counts characters, as does RuneCount.
+		return tx.Model(&models.Folder{}).Scopes(inSubtree(srcBoxID, oldPath)).Updates(map[string]any{
+			"box_id": dst.ID,
+			"path":   gorm.Expr("? || substr(path, ?)", newPath, utf8.RuneCountInString(oldPath)+1),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	f.Name, f.ParentID, f.BoxID, f.Path = name, parentID, dst.ID, newPath
+	return nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// partSize is S3's minimum multipart part size. It is also the most an upload
+// ever holds in memory: bodies are read one part at a time and each part is
+// sent before the next is read.
+const partSize = 5 * 1024 * 1024
+
+// capReader fails with ErrTooLarge once more than left bytes have been read,
+// so an oversized body of unknown length is cut off mid-stream.
+type capReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.left < 0 {
+		return 0, ErrTooLarge
+	}
+	// Read one byte past the cap so exactly-MaxFileSize bodies still succeed.
+	if int64(len(p)) > c.left+1 {
+		p = p[:c.left+1]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if c.left < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// upload streams body to key without knowing its length in advance. Bodies that
+// fit in one part go up with a single PutObject; anything larger becomes a
+// multipart upload, which is aborted if reading or any part fails so no
+// half-written object is left behind. It returns the number of bytes stored.
+func (s *Store) upload(ctx context.Context, key, contentType string, body io.Reader) (int64, error) {
+	buf := make([]byte, partSize)
+	n, err := io.ReadFull(body, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		_, err := s.S3.Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        &s.S3.Bucket,
+			Key:           &key,
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+			ContentType:   &contentType,
+		})
+		return int64(n), err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	created, err := s.S3.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      &s.S3.Bucket,
+		Key:         &key,
+		ContentType: &contentType,
+	})
+	if err != nil {
+		return 0, err
+	}
+	abort := func(cause error) (int64, error) {
+		_, _ = s.S3.Client.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   &s.S3.Bucket,
+			Key:      &key,
+			UploadId: created.UploadId,
+		})
+		return 0, cause
+	}
+
+	var parts []types.CompletedPart
+	var total int64
+	for partNumber := int32(1); n > 0; partNumber++ {
+		out, err := s.S3.Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        &s.S3.Bucket,
+			Key:           &key,
+			UploadId:      created.UploadId,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(int64(n)),
+		})
+		if err != nil {
+			return abort(err)
+		}
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(partNumber)})
+		total += int64(n)
+
+		n, err = io.ReadFull(body, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return abort(err)
+		}
+	}
+
+	if _, err := s.S3.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.S3.Bucket,
+		Key:             &key,
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return abort(err)
+	}
+	return total, nil
+}
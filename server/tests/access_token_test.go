@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// accessTokenRouter wires the real auth, box and file routes so scope and box
+// checks run exactly as in production. S3 is left unconfigured; the requests
+// used here never reach it.
+func accessTokenRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.InitUserRoutes(r, s3db.Config{}, db, ratelimit.New(100, time.Minute))
+	routes.InitBoxRoutes(r, s3db.Config{}, db)
+	routes.InitFileRoutes(r, s3db.Config{}, db)
+	return r
+}
+
+// createAccessToken issues a token through the API using u's login JWT and
+// returns it as an Authorization header value.
+func createAccessToken(t *testing.T, r *gin.Engine, u *models.User, body map[string]any) string {
+	t.Helper()
+	w, out := appPasswordRequest(r, "POST", "/v1/api/auth/tokens", authHeader(t, u), body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create token: %d %s", w.Code, w.Body.String())
+	}
+	return "Bearer " + out["token"].(string)
+}
+
+func TestAccessToken_CreateStoresOnlyHash(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	w, body := appPasswordRequest(accessTokenRouter(db), "POST", "/v1/api/auth/tokens", authHeader(t, u),
+		map[string]any{"name": "ci", "scopes": []string{"read", "write"}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	secret, _ := body["token"].(string)
+	assert.Contains(t, secret, utils.PersonalAccessTokenPrefix)
+
+	var pat models.PersonalAccessToken
+	assert.NoError(t, db.Where("user_id = ?", u.ID).First(&pat).Error)
+	assert.Equal(t, utils.HashToken(secret), pat.Hash)
+	assert.Equal(t, "read,write", pat.Scopes)
+	assert.Equal(t, secret[len(secret)-4:], pat.Hint)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 30), pat.ExpiresAt, time.Minute)
+}
+
+func TestAccessToken_CreateValidation(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+
+	cases := []map[string]any{
+		{"name": "ci"},
+		{"name": "ci", "scopes": []string{"superuser"}},
+		{"name": "ci", "scopes": []string{"read"}, "expires_in_days": 400},
+		{"name": "ci", "scopes": []string{"admin"}, "box_name": "Test-Box"},
+	}
+	for _, body := range cases {
+		w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/tokens", authHeader(t, u), body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "%v", body)
+	}
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/tokens", authHeader(t, u),
+		map[string]any{"name": "ci", "scopes": []string{"read"}, "box_name": "Nope"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAccessToken_ListHidesTokens(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	tok := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read"}, "box_name": "Test-Box"})
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/auth/tokens", authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), tok[len("Bearer "):])
+
+	list, _ := body["tokens"].([]any)
+	if assert.Len(t, list, 1) {
+		entry := list[0].(map[string]any)
+		assert.Equal(t, "ci", entry["name"])
+		assert.Equal(t, "Test-Box", entry["box_name"])
+		assert.Equal(t, tok[len(tok)-4:], entry["hint"])
+	}
+}
+
+func TestAccessToken_AuthenticatesAndRecordsUse(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	tok := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read"}})
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/boxes", tok, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, body["boxes"], 1)
+
+	var pat models.PersonalAccessToken
+	assert.NoError(t, db.Where("user_id = ?", u.ID).First(&pat).Error)
+	assert.NotNil(t, pat.LastUsedAt)
+	assert.NotEmpty(t, pat.LastUsedIP)
+}
+
+func TestAccessToken_ScopeFollowsMethod(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	tok := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read"}})
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/files/presign-upload?box_name=Test-Box&filePath=a.txt", tok, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "token is missing the write scope", body["error"])
+
+	w, body = appPasswordRequest(r, "DELETE", "/v1/api/files/a.txt?box_name=Test-Box", tok, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "token is missing the delete scope", body["error"])
+}
+
+func TestAccessToken_CredentialRoutesNeedAdmin(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	tok := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read", "write", "delete"}})
+
+	for _, path := range []string{"/v1/api/auth/tokens", "/v1/api/auth/app-passwords", "/v1/api/auth/s3-keys", "/v1/api/auth/ssh-keys"} {
+		w, _ := appPasswordRequest(r, "GET", path, tok, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+	}
+
+	admin := createAccessToken(t, r, u, map[string]any{"name": "admin", "scopes": []string{"admin"}})
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/tokens", admin, map[string]any{"name": "child", "scopes": []string{"read"}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestAccessToken_ExpiredAndRevoked(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	expired := createAccessToken(t, r, u, map[string]any{"name": "old", "scopes": []string{"read"}})
+	db.Model(&models.PersonalAccessToken{}).Where("name = ?", "old").Update("expires_at", time.Now().Add(-time.Hour))
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/files?box_name=Test-Box", expired, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "token expired", body["error"])
+
+	tok := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read"}})
+	var pat models.PersonalAccessToken
+	db.Where("name = ?", "ci").First(&pat)
+	w, _ = appPasswordRequest(r, "DELETE", fmt.Sprintf("/v1/api/auth/tokens/%d", pat.ID), authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/boxes", tok, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var count int64
+	db.Unscoped().Model(&models.PersonalAccessToken{}).Where("id = ?", pat.ID).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestAccessToken_BoxRestriction(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Box-A", "Box-B")
+	r := accessTokenRouter(db)
+	tok := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read", "write"}, "box_name": "Box-A"})
+
+	w, _ := appPasswordRequest(r, "GET", "/v1/api/files?box_name=Box-A", tok, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/files?box_name=Box-B", tok, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "token is restricted to a different box", body["error"])
+
+	w, body = appPasswordRequest(r, "GET", "/v1/api/boxes", tok, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if boxes, _ := body["boxes"].([]any); assert.Len(t, boxes, 1) {
+		assert.Equal(t, "Box-A", boxes[0].(map[string]any)["name"])
+	}
+
+	// Confirm addresses the file by ID, so the handler checks the box itself.
+	f := models.File{Name: "a.txt", S3Key: "k", UserID: u.ID, BoxID: u.Boxes[1].ID}
+	db.Create(&f)
+	w, _ = appPasswordRequest(r, "POST", fmt.Sprintf("/v1/api/files/%d/confirm", f.ID), tok, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
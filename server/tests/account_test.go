@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// setupAccountTest returns a database with every table an account owns, the
+// seeded session@example.com user, and the user routes backed by a fake S3.
+func setupAccountTest(t *testing.T) (*gorm.DB, *gin.Engine, *fakeS3, s3db.Config) {
+	t.Helper()
+	db := setupLoginDB(t)
+	// Account deletion runs in the background; keep it on the test's database.
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.PersonalAccessToken{}, &models.AppPassword{}, &models.S3AccessKey{}, &models.S3Upload{},
+		&models.SSHKey{}, &models.Identity{}, &models.DeviceAuthorization{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	seedLoginUser(t, db, "session@example.com", "Test123!@#")
+
+	fake, config := newFakeS3(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.InitUserRoutes(r, config, db, ratelimit.New(100, time.Minute))
+	routes.InitFileRoutes(r, config, db)
+	return db, r, fake, config
+}
+
+func TestAccount_Get(t *testing.T) {
+	_, r, _, _ := setupAccountTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/auth/account", auth, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "session@example.com", body["email"])
+	assert.Equal(t, true, body["has_password"])
+	assert.Equal(t, false, body["totp_enabled"])
+}
+
+func TestAccount_ChangePassword(t *testing.T) {
+	_, r, _, _ := setupAccountTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	other, _ := sessionLogin(t, r, "desktop")
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/account/password", auth,
+		map[string]string{"current_password": "wrong", "new_password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, short := appPasswordRequest(r, "POST", "/v1/api/auth/account/password", auth,
+		map[string]string{"current_password": "Test123!@#", "new_password": "weak"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "Password must be between 8 and 1024 characters long", short["error"])
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/account/password", auth,
+		map[string]string{"current_password": "Test123!@#", "new_password": "Test123!@#"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/account/password", auth,
+		map[string]string{"current_password": "Test123!@#", "new_password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), body["sessions_revoked"])
+
+	// This session stays signed in; every other one is signed out.
+	assert.True(t, tokenWorks(r, auth))
+	assert.False(t, tokenWorks(r, other))
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Test123!@#"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAccount_ChangePasswordNeedsTOTPWhenEnabled(t *testing.T) {
+	_, r, _, _ := setupAccountTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	secret := enableTOTP(t, r, auth)
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/account/password", auth,
+		map[string]string{"current_password": "Test123!@#", "new_password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/account/password", auth,
+		map[string]string{"current_password": "Test123!@#", "new_password": "Newpass123!@#", "code": totpCode(t, secret, 1)})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAccount_ChangeEmail(t *testing.T) {
+	db, r, _, _ := setupAccountTest(t)
+	seedLoginUser(t, db, "taken@example.com", "Test123!@#")
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/account/email", auth,
+		map[string]string{"password": "Test123!@#", "new_email": "not-an-email"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/account/email", auth,
+		map[string]string{"password": "wrong", "new_email": "new@example.com"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/account/email", auth,
+		map[string]string{"password": "Test123!@#", "new_email": "taken@example.com"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/account/email", auth,
+		map[string]string{"password": "Test123!@#", "new_email": "new@example.com"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "new@example.com", body["email"])
+
+	// The old tokens carry the old email and are revoked; the new ones work
+	// and keep the device name.
+	assert.False(t, tokenWorks(r, auth))
+	newAuth := "Bearer " + body["token"].(string)
+	assert.True(t, tokenWorks(r, newAuth))
+	var s models.Session
+	db.Where("revoked_at IS NULL").First(&s)
+	assert.Equal(t, "laptop", s.Device)
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "new@example.com", "password": "Test123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAccount_PasswordlessAccountNeedsRecentSignIn(t *testing.T) {
+	db, r, _, _ := setupAccountTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	// Make the account look like one created by single sign-on.
+	db.Model(&models.User{}).Where("email = ?", "session@example.com").Update("password", "")
+
+	db.Model(&models.Session{}).Where("1 = 1").Update("created_at", time.Now().Add(-time.Hour))
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/account/password", auth,
+		map[string]string{"new_password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	db.Model(&models.Session{}).Where("1 = 1").Update("created_at", time.Now())
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/account/password", auth,
+		map[string]string{"new_password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAccount_Delete(t *testing.T) {
+	db, r, fake, config := setupAccountTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	var u models.User
+	db.Preload("Boxes").Where("email = ?", "session@example.com").First(&u)
+	store := storage.New(db, config)
+	ctx := context.Background()
+	if _, err := store.MkdirAll(ctx, &u.Boxes[0], "docs"); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if _, err := store.Put(ctx, &u.Boxes[0], "docs/a.txt", strings.NewReader("hello"), 5); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err := store.StartUpload(ctx, &u.Boxes[0], "big.bin"); err != nil {
+		t.Fatalf("start upload: %v", err)
+	}
+	db.Create(&models.PersonalAccessToken{UserID: u.ID, Name: "ci", Hash: utils.HashToken("x"), Hint: "xxxx", Scopes: "read", ExpiresAt: time.Now().Add(time.Hour)})
+
+	// Someone else's files are untouched.
+	other := createDavUser(t, db, "theirs")
+	if _, err := store.Put(ctx, &other.Boxes[0], "keep.txt", strings.NewReader("mine"), 4); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	w, _ := appPasswordRequest(r, "DELETE", "/v1/api/auth/account", auth, map[string]string{"password": "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, _ = appPasswordRequest(r, "DELETE", "/v1/api/auth/account", auth, map[string]string{"password": "Test123!@#"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	// Closed at once...
+	assert.False(t, tokenWorks(r, auth))
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Test123!@#"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// ...and purged in the background.
+	assert.Eventually(t, func() bool {
+		var n int64
+		db.Unscoped().Model(&models.User{}).Where("id = ?", u.ID).Count(&n)
+		return n == 0
+	}, 5*time.Second, 20*time.Millisecond)
+
+	for _, m := range []any{&models.Box{}, &models.File{}, &models.Folder{}, &models.S3Upload{}, &models.Session{},
+		&models.RefreshToken{}, &models.RecoveryCode{}, &models.PersonalAccessToken{}} {
+		var n int64
+		db.Unscoped().Model(m).Where("user_id = ?", u.ID).Count(&n)
+		assert.Zero(t, n, "%T rows left behind", m)
+	}
+	for _, k := range fake.keys() {
+		assert.NotContains(t, k, fmt.Sprintf("nim-user-%d/", u.ID))
+	}
+	assert.Len(t, fake.keys(), 1, "the other user's file remains")
+	fake.mu.Lock()
+	assert.Empty(t, fake.uploads, "unfinished uploads are aborted")
+	fake.mu.Unlock()
+}
+
+func TestAccount_PurgeDeletedUsersResumes(t *testing.T) {
+	db, _, fake, config := setupAccountTest(t)
+
+	var u models.User
+	db.Preload("Boxes").Where("email = ?", "session@example.com").First(&u)
+	store := storage.New(db, config)
+	if _, err := store.Put(context.Background(), &u.Boxes[0], "a.txt", strings.NewReader("hello"), 5); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	// Closed, but the server stopped before the purge ran.
+	db.Delete(&u)
+
+	store.PurgeDeletedUsers(context.Background())
+
+	var n int64
+	db.Unscoped().Model(&models.User{}).Count(&n)
+	assert.Zero(t, n)
+	assert.Empty(t, fake.keys())
+}
@@ -0,0 +1,305 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// adminRouter serves the admin routes alongside the user, box and file ones,
+// and returns an admin and their Authorization header.
+func adminRouter(t *testing.T, db *gorm.DB, config s3db.Config) (*gin.Engine, *models.User, string) {
+	t.Helper()
+	r := accessTokenRouter(db)
+	routes.InitAdminRoutes(r, config, db)
+	admin := createDavUser(t, db, "Admin-Box")
+	require.NoError(t, db.Model(admin).Update("role", models.RoleAdmin).Error)
+	return r, admin, authHeader(t, admin)
+}
+
+// startSessions records n live login sessions for u.
+func startSessions(t *testing.T, db *gorm.DB, u *models.User, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		require.NoError(t, db.Create(&models.Session{
+			UserID: u.ID, SessionID: fmt.Sprintf("s-%d-%d", u.ID, i),
+			LastSeenAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour),
+		}).Error)
+	}
+}
+
+func TestAdmin_RequiresAdminRole(t *testing.T) {
+	db := setupDavDB(t)
+	r, admin, _ := adminRouter(t, db, s3db.Config{})
+	u := createDavUser(t, db, "Test-Box")
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/admin/users", authHeader(t, u), nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "admin role required", body["error"])
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/admin/users", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// An admin's token without the admin scope isn't enough either.
+	pat := createAccessToken(t, r, admin, map[string]any{"name": "ci", "scopes": []string{"read", "write", "delete"}})
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/admin/users", pat, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	pat = createAccessToken(t, r, admin, map[string]any{"name": "ops", "scopes": []string{"admin"}})
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/admin/users", pat, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Refusals are audited like any other denied request.
+	var denied []models.AuditEvent
+	require.NoError(t, db.Where("action = ?", "auth.denied").Order("id").Find(&denied).Error)
+	require.Len(t, denied, 3)
+	assert.Equal(t, "GET /v1/api/admin/users", denied[0].Detail)
+}
+
+func TestAdmin_ListUsersWithStorage(t *testing.T) {
+	db := setupDavDB(t)
+	r, _, auth := adminRouter(t, db, s3db.Config{})
+	alice := createDavUser(t, db, "Photos", "Docs")
+	db.Model(&models.Box{}).Where("user_id = ? AND name = ?", alice.ID, "Photos").Update("size", 3000)
+	db.Model(&models.Box{}).Where("user_id = ? AND name = ?", alice.ID, "Docs").Update("size", 500)
+	bob := createDavUser(t, db, "Test-Box")
+	db.Model(bob).Update("suspended_at", time.Now())
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/admin/users", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, body["users"], 3)
+
+	w, body = appPasswordRequest(r, "GET", "/v1/api/admin/users?q="+alice.Email[:12], auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, body["users"], 1)
+	got := body["users"].([]any)[0].(map[string]any)
+	assert.Equal(t, alice.Email, got["email"])
+	assert.Equal(t, float64(3500), got["used_bytes"])
+	assert.Equal(t, float64(2), got["boxes"])
+	assert.Equal(t, "user", got["role"])
+
+	w, body = appPasswordRequest(r, "GET", fmt.Sprintf("/v1/api/admin/users?q=%d", bob.ID), auth, nil)
+	require.Len(t, body["users"], 1)
+	w, body = appPasswordRequest(r, "GET", "/v1/api/admin/users?suspended=true", auth, nil)
+	require.Len(t, body["users"], 1)
+	assert.Equal(t, bob.Email, body["users"].([]any)[0].(map[string]any)["email"])
+	w, body = appPasswordRequest(r, "GET", "/v1/api/admin/users?role=admin", auth, nil)
+	require.Len(t, body["users"], 1)
+
+	// Pages follow next_cursor.
+	var emails []string
+	cursor := ""
+	for {
+		w, body = appPasswordRequest(r, "GET", "/v1/api/admin/users?limit=2&cursor="+cursor, auth, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		for _, u := range body["users"].([]any) {
+			emails = append(emails, u.(map[string]any)["email"].(string))
+		}
+		if cursor, _ = body["next_cursor"].(string); cursor == "" {
+			break
+		}
+	}
+	assert.Len(t, emails, 3)
+
+	// One user, by email or ID.
+	w, body = appPasswordRequest(r, "GET", "/v1/api/admin/users/"+alice.Email, auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, body["boxes"], 2)
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/admin/users/nobody@example.com", auth, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdmin_SuspendBlocksEveryCredential(t *testing.T) {
+	db := setupDavDB(t)
+	r, admin, auth := adminRouter(t, db, s3db.Config{})
+	u := createDavUser(t, db, "Test-Box")
+	startSessions(t, db, u, 2)
+	userAuth := authHeader(t, u)
+	pat := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read"}})
+
+	w, body := appPasswordRequest(r, "POST", fmt.Sprintf("/v1/api/admin/users/%d/suspend", u.ID), auth, map[string]string{"reason": "spam"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, float64(2), body["sessions_revoked"])
+
+	for _, cred := range []string{userAuth, pat} {
+		w, body = appPasswordRequest(r, "GET", "/v1/api/boxes", cred, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, "account suspended", body["error"])
+	}
+	var stored models.User
+	db.First(&stored, u.ID)
+	assert.Equal(t, "spam", stored.SuspendReason)
+
+	// Admins can't lock themselves out.
+	w, _ = appPasswordRequest(r, "POST", fmt.Sprintf("/v1/api/admin/users/%d/suspend", admin.ID), auth, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/admin/users/"+u.Email+"/unsuspend", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/boxes", pat, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdmin_SuspendedUserCantLogIn(t *testing.T) {
+	db := setupLoginDB(t)
+	u := seedLoginUser(t, db, "suspended@example.com", "Test123!@#")
+	db.Model(u).Update("suspended_at", time.Now())
+
+	w, body := appPasswordRequest(loginRouter(db), "POST", "/login", "", map[string]string{"email": u.Email, "password": "Test123!@#"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "account suspended", body["error"])
+
+	// A wrong password still gets the usual answer, so suspension isn't
+	// revealed to someone who doesn't know the password.
+	w, _ = appPasswordRequest(loginRouter(db), "POST", "/login", "", map[string]string{"email": u.Email, "password": "Wrong123!@#"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAdmin_ForcePasswordReset(t *testing.T) {
+	db := setupLoginDB(t)
+	require.NoError(t, db.AutoMigrate(&models.AppPassword{}, &models.PersonalAccessToken{}))
+	r, _, auth := adminRouter(t, db, s3db.Config{})
+	u := seedLoginUser(t, db, "reset@example.com", "Test123!@#")
+	startSessions(t, db, u, 1)
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/admin/users/reset@example.com/reset-password", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, float64(1), body["sessions_revoked"])
+
+	w, body = appPasswordRequest(loginRouter(db), "POST", "/login", "", map[string]string{"email": u.Email, "password": "Test123!@#"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, body["error"], "Password reset required")
+
+	// Account-password logins to SFTP are refused too.
+	_, err := jwt.AuthenticatePassword(db, u.Email, "Test123!@#")
+	assert.Error(t, err)
+
+	// Resetting the password clears the requirement.
+	hash, _ := utils.PasswordHash("NewPass123!@#")
+	require.NoError(t, db.Model(u).Updates(map[string]any{"password": hash, "password_reset_required": false}).Error)
+	w, _ = appPasswordRequest(loginRouter(db), "POST", "/login", "", map[string]string{"email": u.Email, "password": "NewPass123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdmin_RevokeSessions(t *testing.T) {
+	db := setupDavDB(t)
+	r, _, auth := adminRouter(t, db, s3db.Config{})
+	u := createDavUser(t, db, "Test-Box")
+	startSessions(t, db, u, 3)
+
+	w, body := appPasswordRequest(r, "DELETE", fmt.Sprintf("/v1/api/admin/users/%d/sessions", u.ID), auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(3), body["sessions_revoked"])
+	var live int64
+	db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", u.ID).Count(&live)
+	assert.Zero(t, live)
+}
+
+func TestAdmin_QuotaLimitsUploads(t *testing.T) {
+	db := setupDavDB(t)
+	_, cfg := newFakeS3(t)
+	r, _, auth := adminRouter(t, db, cfg)
+	u := createDavUser(t, db, "Test-Box")
+	var box models.Box
+	require.NoError(t, db.Where("user_id = ?", u.ID).First(&box).Error)
+	store := storage.New(db, cfg)
+	ctx := context.Background()
+
+	for _, bad := range []any{map[string]any{"quota_bytes": -1}, map[string]any{}} {
+		w, _ := appPasswordRequest(r, "PUT", "/v1/api/admin/users/"+u.Email+"/quota", auth, bad)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	w, body := appPasswordRequest(r, "PUT", "/v1/api/admin/users/"+u.Email+"/quota", auth, map[string]any{"quota_bytes": 100})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, float64(0), body["used_bytes"])
+
+	_, err := store.Put(ctx, &box, "a.txt", strings.NewReader(strings.Repeat("a", 60)), 60)
+	require.NoError(t, err)
+	// Declared size over the quota: refused before any upload.
+	_, err = store.Put(ctx, &box, "b.txt", strings.NewReader(strings.Repeat("b", 60)), 60)
+	assert.ErrorIs(t, err, storage.ErrQuotaExceeded)
+	// Unknown size: refused once the object's size is known.
+	_, err = store.Put(ctx, &box, "b.txt", strings.NewReader(strings.Repeat("b", 60)), -1)
+	assert.ErrorIs(t, err, storage.ErrQuotaExceeded)
+	// Replacing a file only counts the difference.
+	_, err = store.Put(ctx, &box, "a.txt", strings.NewReader(strings.Repeat("a", 90)), 90)
+	assert.NoError(t, err)
+
+	// 0 lifts the limit.
+	appPasswordRequest(r, "PUT", "/v1/api/admin/users/"+u.Email+"/quota", auth, map[string]any{"quota_bytes": 0})
+	_, err = store.Put(ctx, &box, "b.txt", strings.NewReader(strings.Repeat("b", 60)), 60)
+	assert.NoError(t, err)
+
+	w, body = appPasswordRequest(r, "GET", "/v1/api/admin/users/"+u.Email, auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(150), body["user"].(map[string]any)["used_bytes"])
+}
+
+func TestAdmin_BoxTreeIsReadOnly(t *testing.T) {
+	db := setupDavDB(t)
+	_, cfg := newFakeS3(t)
+	r, _, auth := adminRouter(t, db, cfg)
+	u := createDavUser(t, db, "Test-Box")
+	var box models.Box
+	require.NoError(t, db.Where("user_id = ?", u.ID).First(&box).Error)
+	store := storage.New(db, cfg)
+	_, err := store.Mkdir(context.Background(), &box, "docs")
+	require.NoError(t, err)
+	_, err = store.Put(context.Background(), &box, "docs/plan.txt", strings.NewReader("hello"), 5)
+	require.NoError(t, err)
+
+	w, body := appPasswordRequest(r, "GET", fmt.Sprintf("/v1/api/admin/users/%d/boxes/Test-Box", u.ID), auth, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	entries := body["entries"].([]any)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "docs", entries[0].(map[string]any)["path"])
+	assert.Equal(t, true, entries[0].(map[string]any)["is_dir"])
+	assert.Equal(t, "docs/plan.txt", entries[1].(map[string]any)["path"])
+	assert.Equal(t, float64(5), entries[1].(map[string]any)["size"])
+	_, hasKey := entries[1].(map[string]any)["s3_key"]
+	assert.False(t, hasKey)
+
+	w, _ = appPasswordRequest(r, "GET", fmt.Sprintf("/v1/api/admin/users/%d/boxes/Nope", u.ID), auth, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	for _, method := range []string{"PUT", "POST", "DELETE"} {
+		w, _ = appPasswordRequest(r, method, fmt.Sprintf("/v1/api/admin/users/%d/boxes/Test-Box", u.ID), auth, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+	}
+}
+
+func TestAdmin_ActionsAreAudited(t *testing.T) {
+	db := setupDavDB(t)
+	r, admin, auth := adminRouter(t, db, s3db.Config{})
+	u := createDavUser(t, db, "Test-Box")
+
+	appPasswordRequest(r, "GET", "/v1/api/admin/users", auth, nil)
+	appPasswordRequest(r, "POST", "/v1/api/admin/users/"+u.Email+"/suspend", auth, map[string]string{"reason": "abuse report"})
+	appPasswordRequest(r, "PUT", "/v1/api/admin/users/"+u.Email+"/quota", auth, map[string]any{"quota_bytes": 1024})
+	appPasswordRequest(r, "GET", "/v1/api/admin/users/nobody@example.com", auth, nil)
+
+	var events []models.AuditEvent
+	require.NoError(t, db.Where("action LIKE ?", "admin.%").Order("id").Find(&events).Error)
+	require.Len(t, events, 4)
+	for _, e := range events {
+		assert.Equal(t, admin.ID, e.UserID)
+	}
+	assert.Equal(t, "admin.users_list", events[0].Action)
+	assert.Equal(t, "admin.user_suspend", events[1].Action)
+	assert.Equal(t, fmt.Sprintf("user: %d %s, reason: abuse report", u.ID, u.Email), events[1].Detail)
+	assert.Equal(t, "success", events[1].Result)
+	assert.Equal(t, fmt.Sprintf("user: %d %s, quota_bytes: 1024", u.ID, u.Email), events[2].Detail)
+	assert.Equal(t, "user: nobody@example.com", events[3].Detail)
+	assert.Equal(t, "failure", events[3].Result)
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func appPasswordRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(jwt.Authenticate(db))
+	r.GET("/app-passwords", func(c *gin.Context) { user.ListAppPasswords(c, db) })
+	r.POST("/app-passwords", func(c *gin.Context) { user.CreateAppPassword(c, db) })
+	r.DELETE("/app-passwords/:id", func(c *gin.Context) { user.RevokeAppPassword(c, db) })
+	return r
+}
+
+func appPasswordRequest(r *gin.Engine, method, path, auth string, body any) (*httptest.ResponseRecorder, map[string]any) {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var out map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &out)
+	return w, out
+}
+
+func TestAppPassword_Unauthorized(t *testing.T) {
+	db := setupDavDB(t)
+	w, _ := appPasswordRequest(appPasswordRouter(db), "POST", "/app-passwords", "", map[string]string{"name": "laptop"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAppPassword_CreateRequiresName(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	w, body := appPasswordRequest(appPasswordRouter(db), "POST", "/app-passwords", authHeader(t, u), map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "name is required", body["error"])
+}
+
+func TestAppPassword_CreateStoresOnlyHash(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	w, body := appPasswordRequest(appPasswordRouter(db), "POST", "/app-passwords", authHeader(t, u), map[string]string{"name": "laptop"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	secret, _ := body["password"].(string)
+	assert.NotEmpty(t, secret)
+
+	var ap models.AppPassword
+	assert.NoError(t, db.Where("user_id = ?", u.ID).First(&ap).Error)
+	assert.Equal(t, "laptop", ap.Name)
+	assert.Equal(t, utils.HashToken(secret), ap.Hash)
+	assert.NotContains(t, ap.Hash, secret)
+}
+
+func TestAppPassword_ListHidesSecrets(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := appPasswordRouter(db)
+	_, created := appPasswordRequest(r, "POST", "/app-passwords", authHeader(t, u), map[string]string{"name": "laptop"})
+
+	w, _ := appPasswordRequest(r, "GET", "/app-passwords", authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "laptop")
+	assert.NotContains(t, w.Body.String(), created["password"].(string))
+	assert.NotContains(t, w.Body.String(), "hash")
+}
+
+func TestAppPassword_Revoke(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := appPasswordRouter(db)
+	_, created := appPasswordRequest(r, "POST", "/app-passwords", authHeader(t, u), map[string]string{"name": "laptop"})
+	id := fmt.Sprintf("%.0f", created["id"].(float64))
+
+	w, _ := appPasswordRequest(r, "DELETE", "/app-passwords/"+id, authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var count int64
+	db.Unscoped().Model(&models.AppPassword{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	w, body := appPasswordRequest(r, "DELETE", "/app-passwords/"+id, authHeader(t, u), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "app password not found", body["error"])
+}
+
+func TestAppPassword_CannotRevokeOtherUsers(t *testing.T) {
+	db := setupDavDB(t)
+	owner := createDavUser(t, db, "Test-Box")
+	other := createDavUser(t, db, "Other-Box")
+	r := appPasswordRouter(db)
+	_, created := appPasswordRequest(r, "POST", "/app-passwords", authHeader(t, owner), map[string]string{"name": "laptop"})
+	id := fmt.Sprintf("%.0f", created["id"].(float64))
+
+	w, _ := appPasswordRequest(r, "DELETE", "/app-passwords/"+id, authHeader(t, other), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	var count int64
+	db.Model(&models.AppPassword{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,350 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/middleware/requestid"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// --- helpers ---
+
+// setupAuditTest returns the seeded session@example.com user's database and
+// a router with request IDs, the user, file, box and audit routes.
+func setupAuditTest(t *testing.T) (*gorm.DB, *gin.Engine) {
+	t.Helper()
+	db := setupLoginDB(t)
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.PersonalAccessToken{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	seedLoginUser(t, db, "session@example.com", "Test123!@#")
+
+	_, config := newFakeS3(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestid.Middleware())
+	routes.InitUserRoutes(r, config, db, ratelimit.New(100, time.Minute))
+	routes.InitFileRoutes(r, config, db)
+	routes.InitBoxRoutes(r, config, db)
+	routes.InitAuditRoutes(r, db)
+	return db, r
+}
+
+// auditEvents returns the events GET /v1/api/audit answers with for query.
+func auditEvents(t *testing.T, r *gin.Engine, auth, query string) ([]models.AuditEvent, string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/v1/api/audit?"+query, nil)
+	req.Header.Set("Authorization", auth)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("audit: %d %s", w.Code, w.Body.String())
+	}
+	var out struct {
+		Events     []models.AuditEvent `json:"events"`
+		NextCursor string              `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return out.Events, out.NextCursor
+}
+
+func seedAuditEvents(t *testing.T, db *gorm.DB, userID uint, events ...models.AuditEvent) {
+	t.Helper()
+	for _, e := range events {
+		e.UserID = userID
+		if e.Result == "" {
+			e.Result = "success"
+		}
+		if err := db.Create(&e).Error; err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+}
+
+func sessionUser(t *testing.T, db *gorm.DB) models.User {
+	t.Helper()
+	var u models.User
+	db.Where("email = ?", "session@example.com").First(&u)
+	return u
+}
+
+// --- recording ---
+
+func TestAudit_RecordsOperationsWithRequestContext(t *testing.T) {
+	db, r := setupAuditTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	req := httptest.NewRequest("POST", "/v1/api/files/presign-upload?box_name=Home-Box&filePath=docs&filename=a.txt&size=5", nil)
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("User-Agent", "nim-test/1.0")
+	req.Header.Set(requestid.Header, "trace-123")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "trace-123", w.Header().Get(requestid.Header))
+
+	w, _ = appPasswordRequest(r, "DELETE", "/v1/api/boxes?box_name=Nope", auth, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	events, _ := auditEvents(t, r, auth, "")
+	if !assert.Len(t, events, 3) {
+		return
+	}
+	// Newest first.
+	assert.Equal(t, "box.delete", events[0].Action)
+	assert.Equal(t, "failure", events[0].Result)
+	assert.Equal(t, http.StatusNotFound, events[0].Status)
+	assert.Equal(t, "Nope", events[0].Box)
+	assert.NotEmpty(t, events[0].RequestID, "a request ID is assigned when the client sends none")
+
+	up := events[1]
+	assert.Equal(t, "file.upload", up.Action)
+	assert.Equal(t, "success", up.Result)
+	assert.Equal(t, "session@example.com", up.Actor)
+	assert.Equal(t, sessionUser(t, db).ID, up.UserID)
+	assert.Equal(t, "api", up.Protocol)
+	assert.Equal(t, "Home-Box", up.Box)
+	assert.Equal(t, "docs/a.txt", up.Path)
+	assert.Equal(t, "nim-test/1.0", up.UserAgent)
+	assert.Equal(t, "trace-123", up.RequestID)
+	assert.NotEmpty(t, up.IP)
+
+	assert.Equal(t, "auth.login", events[2].Action)
+}
+
+func TestAudit_FailedLoginsAreRecorded(t *testing.T) {
+	db, r := setupAuditTest(t)
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "nobody@example.com", "password": "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A failed attempt on an account shows up in that account's log...
+	auth, _ := sessionLogin(t, r, "laptop")
+	events, _ := auditEvents(t, r, auth, "action=auth.login&result=denied")
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "session@example.com", events[0].Actor)
+	}
+
+	// ...and one on an unknown email is kept with the email that was tried.
+	var e models.AuditEvent
+	assert.NoError(t, db.Where("actor = ?", "nobody@example.com").First(&e).Error)
+	assert.Zero(t, e.UserID)
+	assert.Equal(t, "denied", e.Result)
+}
+
+func TestAudit_EventsAreAppendOnly(t *testing.T) {
+	db, _ := setupAuditTest(t)
+	e := models.AuditEvent{UserID: 1, Action: "file.delete", Result: "success"}
+	assert.NoError(t, db.Create(&e).Error)
+
+	assert.ErrorIs(t, db.Model(&e).Update("action", "file.upload").Error, models.ErrAuditAppendOnly)
+	assert.ErrorIs(t, db.Delete(&e).Error, models.ErrAuditAppendOnly)
+	var n int64
+	db.Model(&models.AuditEvent{}).Where("action = ?", "file.delete").Count(&n)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestAudit_WebDAVOperationsAreRecorded(t *testing.T) {
+	db := setupDavDB(t)
+	_, config := newFakeS3(t)
+	u := createDavUser(t, db, "Dav-Box")
+	d := davClient{r: davRouter(db, config), user: u.Email, password: davToken(t, u)}
+
+	assert.Equal(t, http.StatusCreated, d.do("MKCOL", "/dav/Dav-Box/docs", "").Code)
+	assert.Equal(t, http.StatusCreated, d.do("PUT", "/dav/Dav-Box/docs/a.txt", "hello").Code)
+	assert.Equal(t, http.StatusNoContent, d.do("DELETE", "/dav/Dav-Box/docs/a.txt", "").Code)
+	d.do("PROPFIND", "/dav/Dav-Box/", "", "Depth", "1")
+	(davClient{r: d.r, user: u.Email, password: "wrong"}).do("GET", "/dav/Dav-Box/", "")
+
+	var events []models.AuditEvent
+	db.Order("id").Find(&events)
+	var got []string
+	for _, e := range events {
+		assert.Equal(t, "webdav", e.Protocol)
+		got = append(got, fmt.Sprintf("%s %s %s/%s", e.Action, e.Result, e.Box, e.Path))
+	}
+	assert.Equal(t, []string{
+		"folder.create success Dav-Box/docs",
+		"file.upload success Dav-Box/docs/a.txt",
+		"file.delete success Dav-Box/docs/a.txt",
+		"auth.login denied /",
+	}, got)
+	assert.Equal(t, u.ID, events[0].UserID)
+	assert.Equal(t, u.Email, events[3].Actor)
+}
+
+func TestAudit_S3BatchDeleteRecordsEachKey(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "x.txt", "x")
+	f.put(t, "y/z.txt", "z")
+	_, err := f.client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+		Bucket: aws.String("Test-Box"),
+		Delete: &types.Delete{Objects: []types.ObjectIdentifier{{Key: aws.String("x.txt")}, {Key: aws.String("y/z.txt")}}},
+	})
+	require.NoError(t, err)
+
+	var events []models.AuditEvent
+	f.db.Where("protocol = ?", "s3").Order("id").Find(&events)
+	var got []string
+	for _, e := range events {
+		assert.Equal(t, f.user.ID, e.UserID)
+		assert.NotEmpty(t, e.RequestID, "the gateway's X-Amz-Request-Id")
+		got = append(got, e.Action+" "+e.Path)
+	}
+	assert.Equal(t, []string{"file.upload x.txt", "file.upload y/z.txt", "file.delete x.txt", "file.delete y/z.txt"}, got)
+}
+
+func TestAudit_SFTPOperationsAreRecorded(t *testing.T) {
+	f := newSFTPFixture(t)
+	c := f.client(t)
+	sftpWrite(t, c, "/Test-Box/a.txt", []byte("hello"))
+	require.NoError(t, c.Rename("/Test-Box/a.txt", "/Test-Box/b.txt"))
+	require.NoError(t, c.Remove("/Test-Box/b.txt"))
+	_, err := f.dial(f.user.Email, ssh.Password("wrong-password"))
+	require.Error(t, err)
+
+	var events []models.AuditEvent
+	f.db.Where("protocol = ?", "sftp").Order("id").Find(&events)
+	var got []string
+	for _, e := range events {
+		got = append(got, fmt.Sprintf("%s %s %s/%s", e.Action, e.Result, e.Box, e.Path))
+	}
+	assert.Equal(t, []string{
+		"auth.login success /",
+		"file.upload success Test-Box/a.txt",
+		"file.move success Test-Box/a.txt",
+		"file.delete success Test-Box/b.txt",
+		"auth.login denied /",
+	}, got)
+}
+
+// --- querying ---
+
+func TestAudit_Filters(t *testing.T) {
+	db, r := setupAuditTest(t)
+	u := sessionUser(t, db)
+	old := time.Now().Add(-48 * time.Hour)
+	seedAuditEvents(t, db, u.ID,
+		models.AuditEvent{Action: "file.delete", Box: "Home-Box", Path: "docs/a.txt", CreatedAt: old},
+		models.AuditEvent{Action: "file.upload", Box: "Home-Box", Path: "docs/sub/b.txt"},
+		models.AuditEvent{Action: "file.upload", Box: "Home-Box", Path: "docs2/c.txt", Result: "failure"},
+		models.AuditEvent{Action: "folder.delete", Box: "Work", Path: "docs", Protocol: "sftp"},
+	)
+	seedAuditEvents(t, db, u.ID+1, models.AuditEvent{Action: "file.delete", Box: "Home-Box", Path: "docs/a.txt"})
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	count := func(query string) int {
+		events, _ := auditEvents(t, r, auth, query)
+		return len(events)
+	}
+	assert.Equal(t, 5, count(""), "the four seeded events and the login; never another user's")
+	assert.Equal(t, 3, count("action=file."))
+	assert.Equal(t, 1, count("action=file.delete"))
+	assert.Equal(t, 3, count("box=Home-Box"))
+	assert.Equal(t, 3, count("path=docs"), "docs, and everything under docs/ but not docs2/")
+	assert.Equal(t, 1, count("path=docs/sub/b.txt"))
+	assert.Equal(t, 1, count("result=failure"))
+	assert.Equal(t, 1, count("protocol=sftp"))
+	assert.Equal(t, 4, count("since="+time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)))
+	assert.Equal(t, 1, count("until="+time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)))
+
+	w, _ := appPasswordRequest(r, "GET", "/v1/api/audit?since=yesterday", auth, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/audit?limit=1000", auth, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAudit_Pagination(t *testing.T) {
+	db, r := setupAuditTest(t)
+	u := sessionUser(t, db)
+	for i := 0; i < 4; i++ {
+		seedAuditEvents(t, db, u.ID, models.AuditEvent{Action: "file.upload", Path: fmt.Sprintf("%d.txt", i)})
+	}
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	var paths []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not end")
+		}
+		events, next := auditEvents(t, r, auth, "action=file.upload&limit=3&cursor="+cursor)
+		for _, e := range events {
+			paths = append(paths, e.Path)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, []string{"3.txt", "2.txt", "1.txt", "0.txt"}, paths)
+}
+
+func TestAudit_ExportJSONL(t *testing.T) {
+	db, r := setupAuditTest(t)
+	u := sessionUser(t, db)
+	seedAuditEvents(t, db, u.ID,
+		models.AuditEvent{Action: "file.upload", Path: "a.txt"},
+		models.AuditEvent{Action: "file.delete", Path: "a.txt"},
+	)
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	req := httptest.NewRequest("GET", "/v1/api/audit?format=jsonl&action=file.", nil)
+	req.Header.Set("Authorization", auth)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var actions []string
+	sc := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	for sc.Scan() {
+		var e map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q is not JSON: %v", sc.Text(), err)
+		}
+		actions = append(actions, e["action"].(string))
+	}
+	assert.Equal(t, []string{"file.upload", "file.delete"}, actions, "oldest first")
+}
+
+func TestAudit_AccessTokensNeedAdminScope(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	routes.InitAuditRoutes(r, db)
+
+	read := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read"}})
+	w, _ := appPasswordRequest(r, "GET", "/v1/api/audit", read, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	admin := createAccessToken(t, r, u, map[string]any{"name": "siem", "scopes": []string{"admin"}})
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/audit", admin, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
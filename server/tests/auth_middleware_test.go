@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// principalRouter answers GET /whoami with the principal Authenticate stored.
+func principalRouter(t *testing.T) (*gin.Engine, *models.User) {
+	t.Helper()
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	api := r.Group("", jwt.Authenticate(db))
+	api.GET("/whoami", func(c *gin.Context) {
+		p := jwt.CurrentPrincipal(c)
+		resp := gin.H{"user_id": p.User.ID, "session": p.SessionID}
+		if p.AccessToken != nil {
+			resp["token"] = p.AccessToken.Name
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+	return r, u
+}
+
+// signClaims signs arbitrary claims with the server's secret.
+func signClaims(t *testing.T, claims jwtlib.MapClaims) string {
+	t.Helper()
+	tok, err := jwtlib.NewWithClaims(jwtlib.SigningMethodHS256, claims).SignedString([]byte(os.Getenv("JWT_SECRET")))
+	require.NoError(t, err)
+	return "Bearer " + tok
+}
+
+func TestAuthMiddleware_PrincipalCarriesCredential(t *testing.T) {
+	r, u := principalRouter(t)
+
+	w, body := appPasswordRequest(r, "GET", "/whoami", authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(u.ID), body["user_id"])
+	assert.Equal(t, "test-session", body["session"])
+	assert.Nil(t, body["token"])
+
+	pat := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read"}})
+	w, body = appPasswordRequest(r, "GET", "/whoami", pat, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(u.ID), body["user_id"])
+	assert.Equal(t, "", body["session"])
+	assert.Equal(t, "ci", body["token"])
+}
+
+func TestAuthMiddleware_IdentifiesUserByIDClaim(t *testing.T) {
+	r, u := principalRouter(t)
+
+	// The email claim is informational; the user comes from user_id.
+	tok := signClaims(t, jwtlib.MapClaims{
+		"user_id": fmt.Sprintf("%d", u.ID), "email": "someone-else@example.com",
+		"sid": "test-session", "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	w, body := appPasswordRequest(r, "GET", "/whoami", tok, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(u.ID), body["user_id"])
+
+	for name, claims := range map[string]jwtlib.MapClaims{
+		"no user_id":      {"email": u.Email, "sid": "test-session"},
+		"numeric user_id": {"user_id": float64(u.ID), "sid": "test-session"},
+		"unknown user":    {"user_id": "12345", "sid": "test-session"},
+		"no session":      {"user_id": fmt.Sprintf("%d", u.ID)},
+	} {
+		claims["exp"] = time.Now().Add(time.Minute).Unix()
+		w, body := appPasswordRequest(r, "GET", "/whoami", signClaims(t, claims), nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+		assert.Equal(t, "invalid token", body["error"], name)
+	}
+}
+
+func TestAuthMiddleware_RejectsWithOneResponse(t *testing.T) {
+	db := setupDavDB(t)
+	r := accessTokenRouter(db)
+	routesUnderTest := []string{"/v1/api/boxes", "/v1/api/files?box_name=Test-Box", "/v1/api/auth/sessions"}
+
+	for _, path := range routesUnderTest {
+		for auth, want := range map[string]string{
+			"":                   "missing authorization token",
+			"Bearer not-a-token": "invalid token",
+		} {
+			req := httptest.NewRequest("GET", path, nil)
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+
+			// The whole body must be one JSON object: handlers no longer
+			// add a second error after the middleware's.
+			var out map[string]string
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &out), "%s: %s", path, w.Body.String())
+			assert.Equal(t, want, out["error"], path)
+		}
+	}
+}
+
+func TestAuthMiddleware_UserCache(t *testing.T) {
+	jwt.UseUserCache(time.Minute)
+	t.Cleanup(func() { jwt.UseUserCache(0) })
+
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	auth := authHeader(t, u)
+
+	w, _ := appPasswordRequest(r, "GET", "/v1/api/boxes", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// Cached: the record isn't read again within the TTL...
+	require.NoError(t, db.Unscoped().Delete(&models.User{}, u.ID).Error)
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/boxes", auth, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// ...until it is forgotten.
+	jwt.ForgetUser(u.ID)
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/boxes", auth, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthMiddleware_CachedUserSeesAccountChanges(t *testing.T) {
+	jwt.UseUserCache(time.Minute)
+	t.Cleanup(func() { jwt.UseUserCache(0) })
+
+	db := setupDavDB(t)
+	require.NoError(t, db.AutoMigrate(&models.RecoveryCode{}))
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	pat := createAccessToken(t, r, u, map[string]any{"name": "admin", "scopes": []string{"admin"}})
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/totp", pat, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	secret := body["secret"].(string)
+
+	// Enrolling forgets the cached user, so confirming sees the new secret
+	// rather than "start enrollment first".
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/totp/confirm", pat, map[string]string{"code": totpCode(t, secret, 0)})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// And confirming does too: enabled means enrolling again conflicts.
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/totp", pat, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAuthMiddleware_DeniedRequestsAreAudited(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := accessTokenRouter(db)
+	pat := createAccessToken(t, r, u, map[string]any{"name": "ci", "scopes": []string{"read"}})
+
+	w, _ := appPasswordRequest(r, "DELETE", "/v1/api/files/a.txt?box_name=Test-Box", "Bearer nope", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w, _ = appPasswordRequest(r, "DELETE", "/v1/api/files/a.txt?box_name=Test-Box", pat, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var events []models.AuditEvent
+	require.NoError(t, db.Where("action = ?", "auth.denied").Order("id").Find(&events).Error)
+	require.Len(t, events, 2)
+	for _, e := range events {
+		assert.Equal(t, "denied", e.Result)
+		assert.Equal(t, "DELETE /v1/api/files/a.txt", e.Detail)
+	}
+	assert.Equal(t, http.StatusUnauthorized, events[0].Status)
+	assert.Equal(t, http.StatusForbidden, events[1].Status)
+
+	// The handler's own event isn't written for a request that never reached it.
+	var n int64
+	db.Model(&models.AuditEvent{}).Where("action = ?", "file.delete").Count(&n)
+	assert.Zero(t, n)
+}
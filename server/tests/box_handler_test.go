@@ -1,18 +1,26 @@
 package tests
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	s3db "github.com/nimbus/api/db/s3"
 	boxhandler "github.com/nimbus/api/handlers/box"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/middleware/ratelimit"
 	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/storage"
 	"github.com/nimbus/api/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -23,7 +31,7 @@ func setupBoxHandlerDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("failed to open test db: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.Session{}); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 	return db
@@ -38,7 +46,6 @@ func createBoxHandlerUser(t *testing.T, db *gorm.DB) *models.User {
 		ID:       userID,
 		Email:    fmt.Sprintf("boxtest-%d@example.com", userID),
 		Password: hash,
-		PassKey:  "1234",
 		Boxes:    []models.Box{{Name: "Home-Box", BoxID: boxID}},
 	}
 	if err := db.Create(u).Error; err != nil {
@@ -50,6 +57,7 @@ func createBoxHandlerUser(t *testing.T, db *gorm.DB) *models.User {
 func boxListRouter(db *gorm.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
+	r.Use(jwt.Authenticate(db))
 	r.GET("/boxes", func(c *gin.Context) {
 		boxhandler.ListBoxes(s3db.Config{}, c, db)
 	})
@@ -59,6 +67,7 @@ func boxListRouter(db *gorm.DB) *gin.Engine {
 func boxVerifyRouter(db *gorm.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
+	r.Use(jwt.Authenticate(db))
 	r.GET("/boxes/verify", func(c *gin.Context) {
 		boxhandler.VerifyBoxExist(s3db.Config{}, c, db)
 	})
@@ -105,8 +114,8 @@ func TestListBoxes_DoesNotReturnOtherUsersBoxes(t *testing.T) {
 	hash, _ := utils.PasswordHash("Test123!@#")
 	u2 := &models.User{
 		ID: userID2, Email: fmt.Sprintf("other-%d@example.com", userID2),
-		Password: hash, PassKey: "1234",
-		Boxes: []models.Box{{Name: "Secret-Box", BoxID: boxID2}},
+		Password: hash,
+		Boxes:    []models.Box{{Name: "Secret-Box", BoxID: boxID2}},
 	}
 	db.Create(u2)
 
@@ -216,8 +225,8 @@ func TestVerifyBox_OtherUsersBox(t *testing.T) {
 	hash, _ := utils.PasswordHash("Test123!@#")
 	u2 := &models.User{
 		ID: userID2, Email: fmt.Sprintf("other2-%d@example.com", userID2),
-		Password: hash, PassKey: "1234",
-		Boxes: []models.Box{{Name: "Private-Box", BoxID: boxID2}},
+		Password: hash,
+		Boxes:    []models.Box{{Name: "Private-Box", BoxID: boxID2}},
 	}
 	db.Create(u2)
 
@@ -259,7 +268,7 @@ func TestBoxModel_SameNameDifferentUsers(t *testing.T) {
 	hash, _ := utils.PasswordHash("Test123!@#")
 	u2 := &models.User{
 		ID: userID2, Email: fmt.Sprintf("shared-%d@example.com", userID2),
-		Password: hash, PassKey: "1234",
+		Password: hash,
 	}
 	db.Create(u2)
 
@@ -271,3 +280,58 @@ func TestBoxModel_SameNameDifferentUsers(t *testing.T) {
 	assert.NoError(t, db.Create(&b1).Error)
 	assert.NoError(t, db.Create(&b2).Error, "same box name is allowed for different users")
 }
+
+// --- RenameBox ---
+
+func TestRenameBox(t *testing.T) {
+	db := setupDavDB(t)
+	fake, cfg := newFakeS3(t)
+	r := envelopeRouter(db, cfg, ratelimit.New(100, time.Minute))
+	routes.InitBoxRoutes(r, cfg, db)
+	u := createDavUser(t, db, "Test-Box", "Other-Box")
+	auth := authHeader(t, u)
+
+	box := u.Boxes[0]
+	assert.Equal(t, fmt.Sprintf("users/nim-user-%d/boxes/%d/", u.ID, box.BoxID), box.S3Prefix)
+	entry, err := storage.New(db, cfg).Put(context.Background(), &box, "a.txt", strings.NewReader("hello"), 5)
+	require.NoError(t, err)
+
+	w, body := appPasswordRequest(r, "PATCH", "/v1/api/boxes/rename?box_name=Test-Box&new_name=Other-Box", auth, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "NAME_CONFLICT", body["code"])
+
+	w, body = appPasswordRequest(r, "PATCH", "/v1/api/boxes/rename?box_name=Nope&new_name=Renamed", auth, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "BOX_NOT_FOUND", body["code"])
+
+	w, _ = appPasswordRequest(r, "PATCH", "/v1/api/boxes/rename?box_name=Test-Box&new_name="+strings.Repeat("x", 101), auth, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, body = appPasswordRequest(r, "PATCH", "/v1/api/boxes/rename?box_name=Test-Box&new_name=My%20Box", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "My_Box", body["box"])
+
+	// Nothing moved in S3: the file is still where it was, and found under
+	// the new name.
+	var renamed models.Box
+	require.NoError(t, db.First(&renamed, box.ID).Error)
+	assert.Equal(t, "My_Box", renamed.Name)
+	assert.Equal(t, box.S3Prefix, renamed.S3Prefix)
+	data, ok := fake.object(entry.File.S3Key)
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(data))
+
+	w, body = appPasswordRequest(r, "GET", "/v1/api/files?box_name=My_Box", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, body["files"], 1)
+
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/files?box_name=Test-Box", auth, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// New uploads go under the same prefix.
+	w, body = appPasswordRequest(r, "POST", "/v1/api/files/presign-upload?box_name=My_Box&filename=b.txt&size=5", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code, body)
+	var f models.File
+	require.NoError(t, db.Where("name = ?", "b.txt").First(&f).Error)
+	assert.True(t, strings.HasPrefix(f.S3Key, box.S3Prefix), f.S3Key)
+}
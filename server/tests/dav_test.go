@@ -0,0 +1,361 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// --- helpers ---
+
+func setupDavDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	// One connection: every new :memory: connection would be a fresh, empty DB.
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.AppPassword{},
+		&models.S3AccessKey{}, &models.S3Upload{}, &models.SSHKey{}, &models.PersonalAccessToken{},
+		&models.Session{}, &models.RefreshToken{}, &models.AuditEvent{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return db
+}
+
+// createDavUser creates a user with one box. The password hash is a dummy:
+// WebDAV never accepts the account password.
+func createDavUser(t *testing.T, db *gorm.DB, boxNames ...string) *models.User {
+	t.Helper()
+	userID, _ := utils.GenerateUserID()
+	u := &models.User{
+		ID:       userID,
+		Email:    fmt.Sprintf("davtest-%d@example.com", userID),
+		Password: "x",
+	}
+	for _, name := range boxNames {
+		boxID, _ := utils.GenerateSecureID()
+		u.Boxes = append(u.Boxes, models.Box{Name: name, BoxID: boxID})
+	}
+	if err := db.Create(u).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return u
+}
+
+func davRouter(db *gorm.DB, config s3db.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.InitDavRoutes(r, config, db)
+	return r
+}
+
+// davToken returns a JWT for u, to be sent as the Basic auth password.
+func davToken(t *testing.T, u *models.User) string {
+	t.Helper()
+	token, err := jwt.CreateToken(u.Email, fmt.Sprintf("%d", u.ID), "test-session")
+	if err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	return token
+}
+
+type davClient struct {
+	r              *gin.Engine
+	user, password string
+}
+
+func (d davClient) do(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if d.user != "" {
+		req.SetBasicAuth(d.user, d.password)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	d.r.ServeHTTP(w, req)
+	return w
+}
+
+func boxSize(t *testing.T, db *gorm.DB, userID uint, name string) int64 {
+	t.Helper()
+	var box models.Box
+	if err := db.Where("user_id = ? AND name = ?", userID, name).First(&box).Error; err != nil {
+		t.Fatalf("box %s not found: %v", name, err)
+	}
+	return box.Size
+}
+
+// --- authentication ---
+
+func TestDav_RequiresAuth(t *testing.T) {
+	db := setupDavDB(t)
+	createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, s3db.Config{})}
+
+	w := c.do("PROPFIND", "/dav/", "", "Depth", "1")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+}
+
+func TestDav_AcceptsJWTAsBasicPassword(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box", "Photos")
+	c := davClient{r: davRouter(db, s3db.Config{}), user: u.Email, password: davToken(t, u)}
+
+	w := c.do("PROPFIND", "/dav/", "", "Depth", "1")
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "/dav/Test-Box/")
+	assert.Contains(t, w.Body.String(), "/dav/Photos/")
+}
+
+func TestDav_RejectsJWTForDifferentEmail(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, s3db.Config{}), user: "someone-else@example.com", password: davToken(t, u)}
+
+	w := c.do("PROPFIND", "/dav/", "", "Depth", "1")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestDav_AcceptsAppPassword(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	secret, _ := utils.GenerateAppPassword()
+	ap := models.AppPassword{UserID: u.ID, Name: "laptop", Hash: utils.HashToken(secret)}
+	db.Create(&ap)
+	r := davRouter(db, s3db.Config{})
+
+	w := davClient{r: r, user: u.Email, password: secret}.do("PROPFIND", "/dav/Test-Box/", "", "Depth", "0")
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+
+	db.First(&ap, ap.ID)
+	assert.NotNil(t, ap.LastUsedAt, "last_used_at should be recorded")
+
+	w = davClient{r: r, user: u.Email, password: secret + "x"}.do("PROPFIND", "/dav/Test-Box/", "", "Depth", "0")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = davClient{r: r, user: "other@example.com", password: secret}.do("PROPFIND", "/dav/Test-Box/", "", "Depth", "0")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestDav_CannotSeeOtherUsersBoxes(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	createDavUser(t, db, "Secret-Box")
+	c := davClient{r: davRouter(db, s3db.Config{}), user: u.Email, password: davToken(t, u)}
+
+	w := c.do("PROPFIND", "/dav/Secret-Box/", "", "Depth", "0")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// --- file operations ---
+
+func TestDav_MkcolPutGet(t *testing.T) {
+	db := setupDavDB(t)
+	fake, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, cfg), user: u.Email, password: davToken(t, u)}
+
+	assert.Equal(t, http.StatusCreated, c.do("MKCOL", "/dav/Test-Box/docs", "").Code)
+	assert.Equal(t, http.StatusCreated, c.do("PUT", "/dav/Test-Box/docs/a.txt", "hello world").Code)
+
+	w := c.do("GET", "/dav/Test-Box/docs/a.txt", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello world", w.Body.String())
+
+	w = c.do("GET", "/dav/Test-Box/docs/a.txt", "", "Range", "bytes=6-")
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "world", w.Body.String())
+
+	w = c.do("PROPFIND", "/dav/Test-Box/docs/", "", "Depth", "1")
+	assert.Equal(t, http.StatusMultiStatus, w.Code)
+	assert.Contains(t, w.Body.String(), "/dav/Test-Box/docs/a.txt")
+	assert.Contains(t, w.Body.String(), "text/plain")
+
+	var f models.File
+	assert.NoError(t, db.Where("name = ?", "a.txt").First(&f).Error)
+	assert.True(t, f.Confirmed)
+	assert.NotNil(t, f.FolderID)
+	assert.Equal(t, int64(11), boxSize(t, db, u.ID, "Test-Box"))
+	data, ok := fake.object(f.S3Key)
+	assert.True(t, ok)
+	assert.Equal(t, "hello world", string(data))
+}
+
+func TestDav_PutLargeFileUsesMultipart(t *testing.T) {
+	db := setupDavDB(t)
+	fake, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, cfg), user: u.Email, password: davToken(t, u)}
+
+	body := strings.Repeat("0123456789", 600*1024) // ~6 MB, more than one 5 MB part
+	assert.Equal(t, http.StatusCreated, c.do("PUT", "/dav/Test-Box/big.bin", body).Code)
+
+	var f models.File
+	assert.NoError(t, db.Where("name = ?", "big.bin").First(&f).Error)
+	assert.Equal(t, int64(len(body)), f.Size)
+	data, _ := fake.object(f.S3Key)
+	assert.Equal(t, len(body), len(data))
+}
+
+func TestDav_PutOverwriteReplacesFile(t *testing.T) {
+	db := setupDavDB(t)
+	fake, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, cfg), user: u.Email, password: davToken(t, u)}
+
+	assert.Equal(t, http.StatusCreated, c.do("PUT", "/dav/Test-Box/a.txt", "first version").Code)
+	assert.Equal(t, http.StatusCreated, c.do("PUT", "/dav/Test-Box/a.txt", "second").Code)
+
+	var count int64
+	db.Model(&models.File{}).Where("name = ?", "a.txt").Count(&count)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, int64(len("second")), boxSize(t, db, u.ID, "Test-Box"))
+	assert.Len(t, fake.keys(), 1, "the replaced object should be deleted")
+	assert.Equal(t, "second", c.do("GET", "/dav/Test-Box/a.txt", "").Body.String())
+}
+
+func TestDav_PutIntoMissingFolderConflicts(t *testing.T) {
+	db := setupDavDB(t)
+	_, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, cfg), user: u.Email, password: davToken(t, u)}
+
+	assert.Equal(t, http.StatusConflict, c.do("PUT", "/dav/Test-Box/nope/a.txt", "x").Code)
+}
+
+func TestDav_PutTooLarge(t *testing.T) {
+	db := setupDavDB(t)
+	_, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := davRouter(db, cfg)
+
+	req := httptest.NewRequest("PUT", "/dav/Test-Box/huge.bin", strings.NewReader("x"))
+	req.ContentLength = storage.MaxFileSize + 1
+	req.SetBasicAuth(u.Email, davToken(t, u))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestDav_MoveAndCopy(t *testing.T) {
+	db := setupDavDB(t)
+	_, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, cfg), user: u.Email, password: davToken(t, u)}
+
+	c.do("MKCOL", "/dav/Test-Box/docs", "")
+	c.do("PUT", "/dav/Test-Box/a.txt", "abc")
+
+	w := c.do("MOVE", "/dav/Test-Box/a.txt", "", "Destination", "http://example.com/dav/Test-Box/docs/b.txt")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, http.StatusNotFound, c.do("GET", "/dav/Test-Box/a.txt", "").Code)
+	assert.Equal(t, "abc", c.do("GET", "/dav/Test-Box/docs/b.txt", "").Body.String())
+
+	w = c.do("COPY", "/dav/Test-Box/docs/b.txt", "", "Destination", "http://example.com/dav/Test-Box/c.txt")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "abc", c.do("GET", "/dav/Test-Box/c.txt", "").Body.String())
+	assert.Equal(t, int64(6), boxSize(t, db, u.ID, "Test-Box"))
+
+	// Without Overwrite: F an existing destination is a precondition failure.
+	w = c.do("MOVE", "/dav/Test-Box/c.txt", "", "Destination", "http://example.com/dav/Test-Box/docs/b.txt", "Overwrite", "F")
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+func TestDav_MoveFolderAcrossBoxes(t *testing.T) {
+	db := setupDavDB(t)
+	fake, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box", "Archive")
+	c := davClient{r: davRouter(db, cfg), user: u.Email, password: davToken(t, u)}
+
+	c.do("MKCOL", "/dav/Test-Box/docs", "")
+	c.do("MKCOL", "/dav/Test-Box/docs/sub", "")
+	c.do("PUT", "/dav/Test-Box/docs/sub/x.txt", "12345")
+
+	w := c.do("MOVE", "/dav/Test-Box/docs", "", "Destination", "http://example.com/dav/Archive/docs")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "12345", c.do("GET", "/dav/Archive/docs/sub/x.txt", "").Body.String())
+	assert.Equal(t, int64(0), boxSize(t, db, u.ID, "Test-Box"))
+	assert.Equal(t, int64(5), boxSize(t, db, u.ID, "Archive"))
+
+	// The folder placeholders moved with the folders.
+	home, archive := u.Boxes[0].S3Prefix, u.Boxes[1].S3Prefix
+	for _, p := range []string{"docs/", "docs/sub/"} {
+		_, ok := fake.object(archive + p)
+		assert.True(t, ok, "placeholder %s in Archive", p)
+		_, ok = fake.object(home + p)
+		assert.False(t, ok, "placeholder %s left in Test-Box", p)
+	}
+}
+
+func TestDav_MkcolS3FailureLeavesNoFolder(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, newFailingS3(t)), user: u.Email, password: davToken(t, u)}
+
+	assert.GreaterOrEqual(t, c.do("MKCOL", "/dav/Test-Box/docs", "").Code, 400)
+	var folders int64
+	db.Unscoped().Model(&models.Folder{}).Count(&folders)
+	assert.Zero(t, folders)
+}
+
+func TestDav_DeleteFolderIsRecursive(t *testing.T) {
+	db := setupDavDB(t)
+	fake, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, cfg), user: u.Email, password: davToken(t, u)}
+
+	c.do("MKCOL", "/dav/Test-Box/docs", "")
+	c.do("MKCOL", "/dav/Test-Box/docs/sub", "")
+	c.do("PUT", "/dav/Test-Box/docs/sub/x.txt", "12345")
+
+	assert.Equal(t, http.StatusNoContent, c.do("DELETE", "/dav/Test-Box/docs", "").Code)
+
+	var folders, files int64
+	db.Model(&models.Folder{}).Count(&folders)
+	db.Model(&models.File{}).Count(&files)
+	assert.Equal(t, int64(0), folders)
+	assert.Equal(t, int64(0), files)
+	assert.Equal(t, int64(0), boxSize(t, db, u.ID, "Test-Box"))
+	assert.Empty(t, fake.keys(), "objects and folder placeholders should be deleted")
+}
+
+func TestDav_LockBlocksOtherWriters(t *testing.T) {
+	db := setupDavDB(t)
+	_, cfg := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	c := davClient{r: davRouter(db, cfg), user: u.Email, password: davToken(t, u)}
+
+	lockBody := `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	w := c.do("LOCK", "/dav/Test-Box/locked.txt", lockBody, "Timeout", "Second-60")
+	assert.Equal(t, http.StatusCreated, w.Code, "LOCK on a new path creates an empty file")
+	token := w.Header().Get("Lock-Token")
+	assert.NotEmpty(t, token)
+
+	assert.Equal(t, http.StatusLocked, c.do("PUT", "/dav/Test-Box/locked.txt", "data").Code)
+	assert.Equal(t, http.StatusCreated, c.do("PUT", "/dav/Test-Box/locked.txt", "data", "If", "("+token+")").Code)
+	assert.Equal(t, http.StatusNoContent, c.do("UNLOCK", "/dav/Test-Box/locked.txt", "", "Lock-Token", token).Code)
+	assert.Equal(t, http.StatusCreated, c.do("PUT", "/dav/Test-Box/locked.txt", "after").Code)
+
+	body, _ := io.ReadAll(c.do("GET", "/dav/Test-Box/locked.txt", "").Body)
+	assert.Equal(t, "after", string(body))
+}
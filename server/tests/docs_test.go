@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/openapi"
+	"github.com/nimbus/api/routes"
+	"github.com/stretchr/testify/assert"
+)
+
+func docsGet(r *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestDocs_ServesSwaggerUIFromOwnOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.InitDocsRoutes(r)
+
+	assert.Equal(t, http.StatusOK, docsGet(r, "/openapi.yaml").Code)
+
+	w := docsGet(r, "/docs/assets/docs.js")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "javascript")
+	assert.Contains(t, w.Body.String(), "SwaggerUIBundle")
+	assert.Equal(t, http.StatusNotFound, docsGet(r, "/docs/assets/nope.js").Code)
+
+	w = docsGet(r, "/docs")
+	if _, err := fs.Stat(openapi.SwaggerUI, "swagger-ui-bundle.js"); err != nil {
+		// A build without the generated assets says so rather than
+		// falling back to a CDN.
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		return
+	}
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "script-src 'self'")
+	assert.NotContains(t, w.Body.String(), "https://")
+	assert.Equal(t, http.StatusOK, docsGet(r, "/docs/assets/swagger-ui-bundle.js").Code)
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/middleware/requestid"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func envelopeRouter(db *gorm.DB, config s3db.Config, limiter *ratelimit.Limiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestid.Middleware())
+	routes.InitUserRoutes(r, config, db, limiter)
+	routes.InitFileRoutes(r, config, db)
+	routes.InitFolderRoutes(r, config, db)
+	return r
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	db := setupDavDB(t)
+	_, cfg := newFakeS3(t)
+	r := envelopeRouter(db, cfg, ratelimit.New(100, time.Minute))
+	u := createDavUser(t, db, "Test-Box")
+	auth := authHeader(t, u)
+
+	// A missing box is 404 BOX_NOT_FOUND from every handler, and the body
+	// carries the request's ID.
+	for _, path := range []string{
+		"/v1/api/folders?box_name=Nope&folder_name=docs",
+		"/v1/api/files/presign-upload?box_name=Nope&filename=a.txt&size=5",
+	} {
+		w, body := appPasswordRequest(r, "POST", path, auth, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		assert.Equal(t, "BOX_NOT_FOUND", body["code"], path)
+		assert.Equal(t, "box not found", body["error"], path)
+		assert.Equal(t, w.Header().Get(requestid.Header), body["request_id"], path)
+	}
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/files?box_name=Test-Box", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "UNAUTHENTICATED", body["code"])
+
+	w, body = appPasswordRequest(r, "POST", "/v1/api/files/presign-upload?box_name=Test-Box&filename=a.txt&size=999999999", auth, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "FILE_TOO_LARGE", body["code"])
+	assert.Equal(t, float64(50<<20), body["details"].(map[string]any)["max_bytes"])
+
+	require.NoError(t, db.Model(u).Update("quota_bytes", 10).Error)
+	w, body = appPasswordRequest(r, "POST", "/v1/api/files/presign-upload?box_name=Test-Box&filename=a.txt&size=20", auth, nil)
+	assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	assert.Equal(t, "QUOTA_EXCEEDED", body["code"])
+	assert.Equal(t, map[string]any{"quota_bytes": float64(10), "used_bytes": float64(0)}, body["details"])
+}
+
+func TestErrorEnvelope_RateLimited(t *testing.T) {
+	db := setupDavDB(t)
+	require.NoError(t, db.AutoMigrate(&models.RecoveryCode{}, &models.LoginChallenge{}))
+	r := envelopeRouter(db, s3db.Config{}, ratelimit.New(1, time.Minute))
+	login := map[string]string{"email": "nobody@example.com", "password": "Wrong-passw0rd"}
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "", login)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "INVALID_CREDENTIALS", body["code"])
+
+	w, body = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "", login)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "RATE_LIMITED", body["code"])
+	assert.NotEmpty(t, body["request_id"])
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/oidc"
+	"github.com/nimbus/api/utils"
+)
+
+// fakeOIDCRedirect is the callback URL the fake issuer sends browsers back to.
+const fakeOIDCRedirect = "http://nimbus.test/v1/api/auth/oidc/callback"
+
+// fakeOIDC is a tiny OpenID Connect issuer: discovery, JWKS, an authorize
+// endpoint that signs the next user straight in, and a token endpoint that
+// checks PKCE. The exported fields pick who signs in and how the ID token is
+// built.
+type fakeOIDC struct {
+	URL string
+
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Deny          bool            // authorize answers error=access_denied
+	Nonce         string          // replaces the requested nonce when set
+	SignWith      *rsa.PrivateKey // signs with a key that isn't published when set
+
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]fakeOIDCGrant
+}
+
+// fakeOIDCGrant is what the authorize step remembers for the token step.
+type fakeOIDCGrant struct {
+	challenge, nonce string
+}
+
+// newFakeOIDC starts the issuer and turns single sign-on on through it for
+// the rest of the test.
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa key: %v", err)
+	}
+	f := &fakeOIDC{
+		Subject:       "user-1",
+		Email:         "sso@example.com",
+		EmailVerified: true,
+		key:           key,
+		codes:         map[string]fakeOIDCGrant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.discovery)
+	mux.HandleFunc("/jwks", f.jwks)
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	f.URL = srv.URL
+
+	p, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:      srv.URL,
+		ClientID:    "nimbus",
+		RedirectURL: fakeOIDCRedirect,
+	})
+	if err != nil {
+		t.Fatalf("provider: %v", err)
+	}
+	user.UseOIDCProvider(p)
+	t.Cleanup(func() { user.UseOIDCProvider(nil) })
+	return f
+}
+
+func (f *fakeOIDC) discovery(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 f.URL,
+		"authorization_endpoint": f.URL + "/authorize",
+		"token_endpoint":         f.URL + "/token",
+		"jwks_uri":               f.URL + "/jwks",
+	})
+}
+
+func (f *fakeOIDC) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := f.key.PublicKey
+	_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+func (f *fakeOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	back := url.Values{"state": {q.Get("state")}}
+	if f.Deny {
+		back.Set("error", "access_denied")
+	} else {
+		code, _ := utils.GenerateTokenID()
+		f.mu.Lock()
+		f.codes[code] = fakeOIDCGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+		f.mu.Unlock()
+		back.Set("code", code)
+	}
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+back.Encode(), http.StatusFound)
+}
+
+func (f *fakeOIDC) token(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	f.mu.Lock()
+	grant, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := grant.nonce
+	if f.Nonce != "" {
+		nonce = f.Nonce
+	}
+	tok := jwtlib.NewWithClaims(jwtlib.SigningMethodRS256, jwtlib.MapClaims{
+		"iss":            f.URL,
+		"aud":            "nimbus",
+		"sub":            f.Subject,
+		"email":          f.Email,
+		"email_verified": f.EmailVerified,
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	tok.Header["kid"] = "k1"
+	key := f.key
+	if f.SignWith != nil {
+		key = f.SignWith
+	}
+	signed, _ := tok.SignedString(key)
+	_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
@@ -0,0 +1,239 @@
+package tests
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/logging"
+	"github.com/nimbus/api/metrics"
+	"github.com/nimbus/api/tracing"
+)
+
+// fakeS3 is a tiny in-memory S3 that understands the calls the storage package
+// makes: PutObject, GetObject (with single byte ranges), HeadObject,
+// DeleteObject, ListObjectsV2 and the multipart calls. It lets tests exercise real SDK requests without LocalStack.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte // uploadId -> part number -> data
+	nextID  int
+}
+
+// newFakeS3 starts a fake S3 server for the test and returns it together with
+// an s3db.Config whose client talks to it.
+func newFakeS3(t *testing.T) (*fakeS3, s3db.Config) {
+	t.Helper()
+	f := &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	client := s3.New(s3.Options{
+		Region:                     "us-east-1",
+		BaseEndpoint:               aws.String(srv.URL),
+		UsePathStyle:               true,
+		Credentials:                credentials.NewStaticCredentialsProvider("test", "test", ""),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+	}, metrics.InstrumentS3, logging.InstrumentS3, tracing.InstrumentS3)
+	// Sanity check that the client and server agree on addressing.
+	if _, err := client.ListBuckets(context.Background(), &s3.ListBucketsInput{}); err != nil {
+		t.Fatalf("fake S3 not reachable: %v", err)
+	}
+	return f, s3db.Config{Client: client, Bucket: "test-bucket"}
+}
+
+// newFailingS3 returns an s3db.Config whose client talks to a server that
+// refuses every request, for testing what a failed S3 call leaves behind.
+func newFailingS3(t *testing.T) s3db.Config {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+	})
+	return s3db.Config{Client: client, Bucket: "test-bucket"}
+}
+
+// object returns a stored object's bytes.
+func (f *fakeS3) object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.objects[key]
+	return b, ok
+}
+
+// keys lists stored object keys, sorted.
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/" {
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = io.WriteString(w, `<ListAllMyBucketsResult><Buckets></Buckets></ListAllMyBucketsResult>`)
+		return
+	}
+	// Path-style: /<bucket>/<key>
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = map[int][]byte{}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Key      string
+			UploadId string
+		}{Key: key, UploadId: id})
+
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		parts, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		body, _ := io.ReadAll(r.Body)
+		parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, n))
+
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		parts, ok := f.uploads[q.Get("uploadId")]
+		if !ok {
+			http.Error(w, "NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		var nums []int
+		for n := range parts {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		var data []byte
+		for _, n := range nums {
+			data = append(data, parts[n]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, q.Get("uploadId"))
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Key     string
+			ETag    string
+		}{Key: key, ETag: `"complete"`})
+
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(f.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		_, srcKey, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+		data, ok := f.objects[srcKey]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		f.objects[key] = append([]byte(nil), data...)
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CopyObjectResult"`
+			ETag    string
+		}{ETag: `"etag"`})
+
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		w.Header().Set("ETag", `"etag"`)
+
+	case r.Method == http.MethodGet && key == "" && q.Get("list-type") == "2":
+		// ListObjectsV2, unpaginated.
+		type content struct{ Key string }
+		var contents []content
+		for k := range f.objects {
+			if strings.HasPrefix(k, q.Get("prefix")) {
+				contents = append(contents, content{Key: k})
+			}
+		}
+		sort.Slice(contents, func(i, j int) bool { return contents[i].Key < contents[j].Key })
+		writeXML(w, struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Prefix      string
+			KeyCount    int
+			IsTruncated bool
+			Contents    []content
+		}{Prefix: q.Get("prefix"), KeyCount: len(contents), Contents: contents})
+
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		if first, last, ok := strings.Cut(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), "-"); ok {
+			start, _ := strconv.Atoi(first)
+			end := len(data) - 1
+			if last != "" {
+				end, _ = strconv.Atoi(last)
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(data[start : end+1])
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+
+	case r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	s3db "github.com/nimbus/api/db/s3"
 	filehandler "github.com/nimbus/api/handlers/file"
+	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/models"
 	"github.com/nimbus/api/utils"
 	"github.com/stretchr/testify/assert"
@@ -23,7 +24,7 @@ func setupFileHandlerDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("failed to open test db: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.Session{}); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 	return db
@@ -38,7 +39,6 @@ func createFileHandlerUser(t *testing.T, db *gorm.DB) (*models.User, *models.Box
 		ID:       userID,
 		Email:    fmt.Sprintf("filehandler-%d@example.com", userID),
 		Password: hash,
-		PassKey:  "1234",
 		Boxes:    []models.Box{{Name: "Test-Box", BoxID: boxID}},
 	}
 	if err := db.Create(u).Error; err != nil {
@@ -50,6 +50,7 @@ func createFileHandlerUser(t *testing.T, db *gorm.DB) (*models.User, *models.Box
 func fileListRouter(db *gorm.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
+	r.Use(jwt.Authenticate(db))
 	r.GET("/files", func(c *gin.Context) {
 		filehandler.List(s3db.Config{}, db, c)
 	})
@@ -59,6 +60,7 @@ func fileListRouter(db *gorm.DB) *gin.Engine {
 func fileRenameRouter(db *gorm.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
+	r.Use(jwt.Authenticate(db))
 	r.PATCH("/files/rename", func(c *gin.Context) {
 		filehandler.Rename(s3db.Config{}, db, c)
 	})
@@ -68,6 +70,7 @@ func fileRenameRouter(db *gorm.DB) *gin.Engine {
 func fileMoveRouter(db *gorm.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
+	r.Use(jwt.Authenticate(db))
 	r.PATCH("/files/move", func(c *gin.Context) {
 		filehandler.Move(s3db.Config{}, db, c)
 	})
@@ -113,7 +116,8 @@ func TestListFiles_BoxNotOwned(t *testing.T) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"BOX_NOT_FOUND"`)
 }
 
 func TestListFiles_EmptyBox(t *testing.T) {
@@ -163,8 +167,8 @@ func TestListFiles_OnlyShowsOwnFiles(t *testing.T) {
 	hash, _ := utils.PasswordHash("Test123!@#")
 	u2 := &models.User{
 		ID: userID2, Email: fmt.Sprintf("other-%d@example.com", userID2),
-		Password: hash, PassKey: "1234",
-		Boxes: []models.Box{{Name: "Test-Box", BoxID: boxID2}},
+		Password: hash,
+		Boxes:    []models.Box{{Name: "Test-Box", BoxID: boxID2}},
 	}
 	db.Create(u2)
 
@@ -226,7 +230,8 @@ func TestRenameFile_BoxNotOwned(t *testing.T) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"BOX_NOT_FOUND"`)
 }
 
 func TestRenameFile_FileNotFound(t *testing.T) {
@@ -310,7 +315,8 @@ func TestMoveFile_BoxNotOwned(t *testing.T) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"BOX_NOT_FOUND"`)
 }
 
 func TestMoveFile_FileNotFound(t *testing.T) {
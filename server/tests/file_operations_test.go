@@ -15,7 +15,7 @@ func setupFileTestDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.Session{}); err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
 	return db
@@ -30,7 +30,6 @@ func createFileTestUser(t *testing.T, db *gorm.DB) (*models.User, *models.Box) {
 		ID:       userID,
 		Email:    "filetest@example.com",
 		Password: hash,
-		PassKey:  "1234",
 		Boxes: []models.Box{
 			{Name: "Test-Box", BoxID: boxID},
 		},
@@ -213,7 +212,7 @@ func TestBoxOwnership_WrongUser(t *testing.T) {
 	userID2, _ := utils.GenerateUserID()
 	hash, _ := utils.PasswordHash("Test123!@#")
 	u2 := &models.User{
-		ID: userID2, Email: "other@example.com", Password: hash, PassKey: "5678",
+		ID: userID2, Email: "other@example.com", Password: hash,
 		Boxes: []models.Box{{Name: "Other-Box", BoxID: boxID2}},
 	}
 	db.Create(u2)
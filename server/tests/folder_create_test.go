@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/handlers/folder"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func folderCreateRouter(db *gorm.DB, cfg s3db.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(jwt.Authenticate(db))
+	r.POST("/folders", func(c *gin.Context) {
+		folder.Create(cfg, c, db)
+	})
+	return r
+}
+
+func doCreateFolder(r *gin.Engine, auth, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/folders?box_name=Test-Box&"+query, nil)
+	req.Header.Set("Authorization", auth)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestFolderCreate_StoresRowAndPlaceholder(t *testing.T) {
+	db := setupFolderDeleteDB(t)
+	u, b := createFolderDeleteUser(t, db)
+	fake, cfg := newFakeS3(t)
+	r := folderCreateRouter(db, cfg)
+	auth := authHeader(t, u)
+
+	w := doCreateFolder(r, auth, "folder_name=docs")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doCreateFolder(r, auth, "folder_name=work&path=docs")
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, ok := fake.object(b.S3Prefix + "docs/work/")
+	assert.True(t, ok)
+
+	w = doCreateFolder(r, auth, "folder_name=work&path=docs")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = doCreateFolder(r, auth, "folder_name=x&path=missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestFolderCreate_S3FailureLeavesNoRow(t *testing.T) {
+	db := setupFolderDeleteDB(t)
+	u, _ := createFolderDeleteUser(t, db)
+	r := folderCreateRouter(db, newFailingS3(t))
+
+	w := doCreateFolder(r, authHeader(t, u), "folder_name=docs")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// The row is removed again, so a retry isn't refused as a conflict.
+	var count int64
+	db.Unscoped().Model(&models.Folder{}).Where("user_id = ?", u.ID).Count(&count)
+	assert.Zero(t, count)
+}
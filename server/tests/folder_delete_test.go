@@ -26,7 +26,7 @@ func setupFolderDeleteDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("failed to open test db: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.Session{}); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 	return db
@@ -41,7 +41,6 @@ func createFolderDeleteUser(t *testing.T, db *gorm.DB) (*models.User, *models.Bo
 		ID:       userID,
 		Email:    fmt.Sprintf("foldertest-%d@example.com", userID),
 		Password: hash,
-		PassKey:  "1234",
 		Boxes:    []models.Box{{Name: "Test-Box", BoxID: boxID}},
 	}
 	if err := db.Create(u).Error; err != nil {
@@ -54,7 +53,7 @@ func createFolderDeleteUser(t *testing.T, db *gorm.DB) (*models.User, *models.Bo
 func authHeader(t *testing.T, u *models.User) string {
 	t.Helper()
 	t.Setenv("JWT_SECRET", "test-secret-key-0123456789-abcdefghij")
-	token, err := jwt.CreateToken(u.Email, fmt.Sprintf("%d", u.ID))
+	token, err := jwt.CreateToken(u.Email, fmt.Sprintf("%d", u.ID), "test-session")
 	if err != nil {
 		t.Fatalf("failed to create token: %v", err)
 	}
@@ -66,6 +65,7 @@ func authHeader(t *testing.T, u *models.User) string {
 func folderDeleteRouter(db *gorm.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
+	r.Use(jwt.Authenticate(db))
 	r.DELETE("/folders", func(c *gin.Context) {
 		folder.Delete(s3db.Config{}, c, db)
 	})
@@ -204,7 +204,8 @@ func TestDeleteFolder_BoxNotOwned(t *testing.T) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"BOX_NOT_FOUND"`)
 }
 
 func TestDeleteFolder_FolderNotFound(t *testing.T) {
@@ -276,3 +277,29 @@ func TestDeleteFolder_WithPath(t *testing.T) {
 	assert.Equal(t, int64(0), childCount, "child folder should be deleted")
 	assert.Equal(t, int64(1), parentCount, "parent folder should still exist")
 }
+
+func TestDeleteFolder_FreesBoxSize(t *testing.T) {
+	db := setupFolderDeleteDB(t)
+	u, b := createFolderDeleteUser(t, db)
+
+	parent := models.Folder{Name: "parent", UserID: u.ID, BoxID: b.ID}
+	db.Create(&parent)
+	child := models.Folder{Name: "child", UserID: u.ID, BoxID: b.ID, ParentID: &parent.ID}
+	db.Create(&child)
+	db.Create(&models.File{UserID: u.ID, BoxID: b.ID, FolderID: &child.ID, Name: "a.bin", Size: 300, S3Key: "del-size-a.bin", Confirmed: true})
+	db.Create(&models.File{UserID: u.ID, BoxID: b.ID, Name: "b.bin", Size: 50, S3Key: "del-size-b.bin", Confirmed: true})
+	db.Model(b).Update("size", 350)
+
+	r := folderDeleteRouter(db)
+	req, _ := http.NewRequest(http.MethodDelete, "/folders?box_name=Test-Box&folder_name=parent", nil)
+	req.Header.Set("Authorization", authHeader(t, u))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Only the bytes below the folder are taken off the box
+	var box models.Box
+	db.First(&box, b.ID)
+	assert.Equal(t, int64(50), box.Size)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/gin-gonic/gin"
 	s3db "github.com/nimbus/api/db/s3"
 	folderhandler "github.com/nimbus/api/handlers/folder"
+	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/models"
 	"github.com/nimbus/api/utils"
 	"github.com/stretchr/testify/assert"
@@ -23,7 +24,7 @@ func setupFolderListDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("failed to open test db: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.Session{}); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 	return db
@@ -38,7 +39,6 @@ func createFolderListUser(t *testing.T, db *gorm.DB) (*models.User, *models.Box)
 		ID:       userID,
 		Email:    fmt.Sprintf("folderlist-%d@example.com", userID),
 		Password: hash,
-		PassKey:  "1234",
 		Boxes:    []models.Box{{Name: "Test-Box", BoxID: boxID}},
 	}
 	if err := db.Create(u).Error; err != nil {
@@ -50,6 +50,7 @@ func createFolderListUser(t *testing.T, db *gorm.DB) (*models.User, *models.Box)
 func folderListRouter(db *gorm.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
+	r.Use(jwt.Authenticate(db))
 	r.GET("/folders", func(c *gin.Context) {
 		folderhandler.List(s3db.Config{}, c, db)
 	})
@@ -97,7 +98,8 @@ func TestFolderList_BoxNotOwned(t *testing.T) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"BOX_NOT_FOUND"`)
 }
 
 func TestFolderList_PathFolderNotFound(t *testing.T) {
@@ -262,7 +264,6 @@ func TestFolderList_OnlyShowsOwnContents(t *testing.T) {
 		ID:       userID2,
 		Email:    fmt.Sprintf("other-fl-%d@example.com", userID2),
 		Password: hash,
-		PassKey:  "1234",
 		Boxes:    []models.Box{{Name: "Test-Box", BoxID: boxID2}},
 	}
 	db.Create(u2)
@@ -10,8 +10,10 @@ import (
 	"github.com/gin-gonic/gin"
 	s3db "github.com/nimbus/api/db/s3"
 	"github.com/nimbus/api/handlers/folder"
+	"github.com/nimbus/api/middleware/jwt"
 	"github.com/nimbus/api/models"
 	"github.com/nimbus/api/utils"
+	"github.com/nimbus/api/utils/helpers"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -23,7 +25,7 @@ func setupFolderRenameDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("failed to open test db: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.Session{}); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 	return db
@@ -38,7 +40,6 @@ func createFolderRenameUser(t *testing.T, db *gorm.DB) (*models.User, *models.Bo
 		ID:       userID,
 		Email:    fmt.Sprintf("renametest-%d@example.com", userID),
 		Password: hash,
-		PassKey:  "1234",
 		Boxes:    []models.Box{{Name: "Test-Box", BoxID: boxID}},
 	}
 	if err := db.Create(u).Error; err != nil {
@@ -50,6 +51,7 @@ func createFolderRenameUser(t *testing.T, db *gorm.DB) (*models.User, *models.Bo
 func folderRenameRouter(db *gorm.DB) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
+	r.Use(jwt.Authenticate(db))
 	r.PATCH("/folders/rename", func(c *gin.Context) {
 		folder.Rename(s3db.Config{}, c, db)
 	})
@@ -125,7 +127,8 @@ func TestRenameFolder_BoxNotOwned(t *testing.T) {
 	w := httptest.NewRecorder()
 	r.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"BOX_NOT_FOUND"`)
 }
 
 func TestRenameFolder_FolderNotFound(t *testing.T) {
@@ -230,3 +233,74 @@ func TestRenameFolder_WithPath(t *testing.T) {
 	assert.Equal(t, "renamed", updatedChild.Name)
 	assert.Equal(t, "parent", updatedParent.Name)
 }
+
+func TestRenameFolder_MovesDescendantPaths(t *testing.T) {
+	db := setupFolderRenameDB(t)
+	u, b := createFolderRenameUser(t, db)
+	r := folderRenameRouter(db)
+
+	docs := models.Folder{Name: "docs", UserID: u.ID, BoxID: b.ID}
+	db.Create(&docs)
+	work := models.Folder{Name: "work", UserID: u.ID, BoxID: b.ID, ParentID: &docs.ID}
+	db.Create(&work)
+	deep := models.Folder{Name: "2024", UserID: u.ID, BoxID: b.ID, ParentID: &work.ID}
+	db.Create(&deep)
+
+	req, _ := http.NewRequest(http.MethodPatch, "/folders/rename?box_name=Test-Box&path=docs&folder_name=work&new_name=office", nil)
+	req.Header.Set("Authorization", authHeader(t, u))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Everything below the renamed folder resolves under its new name
+	var updatedDeep models.Folder
+	db.First(&updatedDeep, deep.ID)
+	assert.Equal(t, "docs/office/2024", updatedDeep.Path)
+	id := helpers.GetParentFolderID(db, u.ID, "Test-Box", "docs/office/2024")
+	if assert.NotNil(t, id) {
+		assert.Equal(t, deep.ID, *id)
+	}
+	assert.Nil(t, helpers.GetParentFolderID(db, u.ID, "Test-Box", "docs/work/2024"))
+}
+
+func TestRenameFolder_FilesFollowTheirObjects(t *testing.T) {
+	db := setupFolderRenameDB(t)
+	u, b := createFolderRenameUser(t, db)
+	fake, cfg := newFakeS3(t)
+	r := gin.New()
+	r.Use(jwt.Authenticate(db))
+	r.PATCH("/folders/rename", func(c *gin.Context) {
+		folder.Rename(cfg, c, db)
+	})
+
+	docs := models.Folder{Name: "docs", UserID: u.ID, BoxID: b.ID}
+	db.Create(&docs)
+	oldKey := b.S3Prefix + "docs/a.txt"
+	file := models.File{Name: "a.txt", UserID: u.ID, BoxID: b.ID, FolderID: &docs.ID, S3Key: oldKey, Size: 5, Confirmed: true}
+	db.Create(&file)
+	other := models.File{Name: "b.txt", UserID: u.ID, BoxID: b.ID, S3Key: b.S3Prefix + "docs2/b.txt", Size: 5, Confirmed: true}
+	db.Create(&other)
+	for _, k := range []string{b.S3Prefix + "docs/", oldKey, other.S3Key} {
+		fake.objects[k] = []byte("hello")
+	}
+
+	req, _ := http.NewRequest(http.MethodPatch, "/folders/rename?box_name=Test-Box&folder_name=docs&new_name=papers", nil)
+	req.Header.Set("Authorization", authHeader(t, u))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The file's key points at the copy, and the original is gone.
+	db.First(&file, file.ID)
+	assert.Equal(t, b.S3Prefix+"papers/a.txt", file.S3Key)
+	data, ok := fake.object(file.S3Key)
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(data))
+	_, ok = fake.object(oldKey)
+	assert.False(t, ok)
+
+	// A sibling whose name merely starts the same is left alone.
+	db.First(&other, other.ID)
+	assert.Equal(t, b.S3Prefix+"docs2/b.txt", other.S3Key)
+}
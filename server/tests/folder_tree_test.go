@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/utils/helpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// mkFolder creates a folder under parent (nil for the box root).
+func mkFolder(t *testing.T, db *gorm.DB, box *models.Box, parent *models.Folder, name string) *models.Folder {
+	t.Helper()
+	f := &models.Folder{Name: name, UserID: box.UserID, BoxID: box.ID}
+	if parent != nil {
+		f.ParentID = &parent.ID
+	}
+	require.NoError(t, db.Create(f).Error)
+	return f
+}
+
+func folderPaths(t *testing.T, db *gorm.DB, boxID uint) []string {
+	t.Helper()
+	var paths []string
+	require.NoError(t, db.Model(&models.Folder{}).Where("box_id = ?", boxID).Order("path").Pluck("path", &paths).Error)
+	return paths
+}
+
+func TestFolderTree_PathsAndUniqueness(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Home-Box")
+	box := &u.Boxes[0]
+
+	docs := mkFolder(t, db, box, nil, "docs")
+	work := mkFolder(t, db, box, docs, "work")
+	deep := mkFolder(t, db, box, work, "2024")
+	assert.Equal(t, "docs/work/2024", deep.Path)
+
+	assert.Error(t, db.Create(&models.Folder{Name: "work", UserID: u.ID, BoxID: box.ID, ParentID: &docs.ID}).Error,
+		"sibling names are unique")
+	assert.Error(t, db.Create(&models.Folder{Name: "docs", UserID: u.ID, BoxID: box.ID}).Error)
+	assert.True(t, db.Migrator().HasIndex(&models.Folder{}, "idx_folders_box_parent_name"))
+
+	// A deleted folder's name can be used again.
+	require.NoError(t, db.Delete(work).Error)
+	mkFolder(t, db, box, docs, "work")
+
+	got := helpers.GetParentFolderID(db, u.ID, "Home-Box", "/docs/work/")
+	require.NotNil(t, got)
+	assert.NotEqual(t, work.ID, *got)
+	assert.Nil(t, helpers.GetParentFolderID(db, u.ID, "Other-Box", "docs"))
+	assert.Nil(t, helpers.GetParentFolderID(db, u.ID+1, "Home-Box", "docs"))
+}
+
+func TestFolderTree_SubtreeMatchesWholeSegments(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Home-Box")
+	box := &u.Boxes[0]
+
+	// Neither a longer name, a LIKE wildcard nor a different case is inside
+	// "a_b".
+	ab := mkFolder(t, db, box, nil, "a_b")
+	mkFolder(t, db, box, ab, "c")
+	mkFolder(t, db, box, nil, "a_bc")
+	mkFolder(t, db, box, nil, "axb")
+	mkFolder(t, db, box, nil, "A_B")
+
+	tree, err := storage.Subtree(db, box.ID, "a_b")
+	require.NoError(t, err)
+	require.Len(t, tree, 2)
+	assert.Equal(t, "a_b", tree[0].Path)
+	assert.Equal(t, "a_b/c", tree[1].Path)
+}
+
+func TestFolderTree_MoveRewritesDescendants(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Home-Box", "Archive")
+	home, archive := &u.Boxes[0], &u.Boxes[1]
+
+	docs := mkFolder(t, db, home, nil, "docs")
+	work := mkFolder(t, db, home, docs, "wörk")
+	deep := mkFolder(t, db, home, work, "2024")
+	mkFolder(t, db, home, nil, "docs2")
+	require.NoError(t, db.Create(&models.File{Name: "a.txt", UserID: u.ID, BoxID: home.ID, FolderID: &deep.ID,
+		Size: 10, S3Key: "tree-move-a", Confirmed: true}).Error)
+	require.NoError(t, db.Model(home).Update("size", 10).Error)
+
+	// Rename in place.
+	require.NoError(t, storage.MoveFolder(db, work, home, docs, "büro"))
+	assert.Equal(t, []string{"docs", "docs/büro", "docs/büro/2024", "docs2"}, folderPaths(t, db, home.ID))
+
+	// Re-parent under a sibling.
+	docs2, err := storage.FolderByPath(db, home.ID, "docs2")
+	require.NoError(t, err)
+	require.NoError(t, storage.MoveFolder(db, work, home, docs2, "büro"))
+	assert.Equal(t, []string{"docs", "docs2", "docs2/büro", "docs2/büro/2024"}, folderPaths(t, db, home.ID))
+	moved, err := storage.FolderByPath(db, home.ID, "docs2/büro")
+	require.NoError(t, err)
+	assert.Equal(t, docs2.ID, *moved.ParentID)
+
+	// Across boxes, taking the files and their bytes along.
+	require.NoError(t, storage.MoveFolder(db, work, archive, nil, "old"))
+	assert.Equal(t, []string{"docs", "docs2"}, folderPaths(t, db, home.ID))
+	assert.Equal(t, []string{"old", "old/2024"}, folderPaths(t, db, archive.ID))
+	var file models.File
+	require.NoError(t, db.Where("s3_key = ?", "tree-move-a").First(&file).Error)
+	assert.Equal(t, archive.ID, file.BoxID)
+	assert.Equal(t, int64(0), boxSize(t, db, u.ID, "Home-Box"))
+	assert.Equal(t, int64(10), boxSize(t, db, u.ID, "Archive"))
+}
+
+func TestFolderTree_SizesAndDelete(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Home-Box")
+	box := &u.Boxes[0]
+
+	docs := mkFolder(t, db, box, nil, "docs")
+	work := mkFolder(t, db, box, docs, "work")
+	empty := mkFolder(t, db, box, nil, "empty")
+	for i, f := range []models.File{
+		{Name: "a", FolderID: &docs.ID, Size: 100, Confirmed: true},
+		{Name: "b", FolderID: &work.ID, Size: 20, Confirmed: true},
+		{Name: "c", FolderID: &work.ID, Size: 3, Confirmed: false},
+		{Name: "d", Size: 4000, Confirmed: true},
+	} {
+		f.UserID, f.BoxID, f.S3Key = u.ID, box.ID, "tree-size-"+string(rune('a'+i))
+		require.NoError(t, db.Create(&f).Error)
+	}
+	require.NoError(t, db.Model(box).Update("size", 4120).Error)
+
+	sizes, err := storage.FolderSizes(db, []models.Folder{*docs, *work, *empty})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint]int64{docs.ID: 120, work.ID: 20}, sizes)
+
+	folders, files, err := storage.DeleteFolder(db, box, "docs")
+	require.NoError(t, err)
+	assert.Len(t, folders, 2)
+	assert.Len(t, files, 3)
+	assert.Equal(t, []string{"empty"}, folderPaths(t, db, box.ID))
+	var left int64
+	db.Model(&models.File{}).Where("box_id = ?", box.ID).Count(&left)
+	assert.Equal(t, int64(1), left)
+	assert.Equal(t, int64(4000), boxSize(t, db, u.ID, "Home-Box"))
+
+	_, _, err = storage.DeleteFolder(db, box, "docs")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
@@ -1,10 +1,12 @@
 package tests
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/nimbus/api/utils"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // TestPasswordHash_Success tests that password hashing works correctly
@@ -16,7 +18,7 @@ func TestPasswordHash_Success(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, hash)
 	assert.NotEqual(t, password, hash, "Hash should not equal plain password")
-	assert.Greater(t, len(hash), 50, "Bcrypt hash should be at least 50 characters")
+	assert.Greater(t, len(hash), 50, "Hash should be at least 50 characters")
 }
 
 // TestPasswordHash_EmptyPassword tests hashing an empty password
@@ -95,6 +97,64 @@ func TestCheckPasswordHash_CaseSensitive(t *testing.T) {
 	assert.False(t, result, "Password check should be case-sensitive")
 }
 
+// TestPasswordHash_Argon2idPHCFormat tests that new hashes are Argon2id in PHC format
+func TestPasswordHash_Argon2idPHCFormat(t *testing.T) {
+	hash, err := utils.PasswordHash("MySecurePassword123!")
+	assert.NoError(t, err)
+
+	parts := strings.Split(hash, "$")
+	assert.Len(t, parts, 6)
+	assert.Equal(t, "argon2id", parts[1])
+	assert.Equal(t, "v=19", parts[2])
+	assert.Equal(t, utils.DefaultArgon2Params.String(), parts[3])
+	assert.False(t, utils.PasswordNeedsRehash(hash))
+}
+
+// TestCheckPasswordHash_LegacyBcrypt tests that bcrypt hashes still verify and are marked for rehash
+func TestCheckPasswordHash_LegacyBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("LegacyPassword123!"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	assert.True(t, utils.VerifyPasswordHash("LegacyPassword123!", string(hash)))
+	assert.False(t, utils.VerifyPasswordHash("WrongPassword123!", string(hash)))
+	assert.True(t, utils.PasswordNeedsRehash(string(hash)))
+}
+
+// TestCheckPasswordHash_LongPassphrase tests that passphrases past bcrypt's 72-byte limit are fully compared
+func TestCheckPasswordHash_LongPassphrase(t *testing.T) {
+	passphrase := strings.Repeat("correct horse battery staple ", 10)
+
+	hash, err := utils.PasswordHash(passphrase)
+	assert.NoError(t, err)
+
+	assert.True(t, utils.VerifyPasswordHash(passphrase, hash))
+	assert.False(t, utils.VerifyPasswordHash(passphrase[:len(passphrase)-1]+"!", hash), "Every byte should count")
+}
+
+// TestPasswordNeedsRehash_ParamsChanged tests that hashes with other parameters verify but are marked for rehash
+func TestPasswordNeedsRehash_ParamsChanged(t *testing.T) {
+	utils.SetArgon2Params(utils.Argon2Params{Memory: 1024, Time: 1, Threads: 1})
+	hash, err := utils.PasswordHash("Password123!")
+	utils.SetArgon2Params(utils.DefaultArgon2Params)
+	assert.NoError(t, err)
+
+	assert.Contains(t, hash, "$m=1024,t=1,p=1$")
+	assert.True(t, utils.VerifyPasswordHash("Password123!", hash))
+	assert.True(t, utils.PasswordNeedsRehash(hash))
+}
+
+// TestParseArgon2Params tests parsing PHC parameter strings
+func TestParseArgon2Params(t *testing.T) {
+	p, err := utils.ParseArgon2Params("m=131072,t=4,p=4")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.Argon2Params{Memory: 131072, Time: 4, Threads: 4}, p)
+
+	for _, bad := range []string{"", "m=65536", "m=65536,t=3,p=2,x=1", "t=3,m=65536,p=2", "m=65536,t=0,p=2", "m=4,t=3,p=2", "m=65536,t=3,p=0"} {
+		_, err := utils.ParseArgon2Params(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
 // TestGenerateSecureID_Success tests secure ID generation
 func TestGenerateSecureID_Success(t *testing.T) {
 	id, err := utils.GenerateSecureID()
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	jwtlib "github.com/golang-jwt/jwt/v5"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupKeysDB returns a database for signing keys. The keyset is global, so
+// each test goes back to JWT_SECRET alone when it ends.
+func setupKeysDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.SigningKey{}))
+	t.Cleanup(func() { _ = jwt.LoadKeys(nil) })
+	return db
+}
+
+// tokenHeader returns the decoded header of a JWT without verifying it.
+func tokenHeader(t *testing.T, token string) map[string]any {
+	t.Helper()
+	parsed, _, err := jwtlib.NewParser().ParseUnverified(token, jwtlib.MapClaims{})
+	require.NoError(t, err)
+	return parsed.Header
+}
+
+func newToken(t *testing.T) string {
+	t.Helper()
+	tok, err := jwt.CreateToken("keys@example.com", "12345678", "test-session")
+	require.NoError(t, err)
+	return tok
+}
+
+func jwksKIDs() []string {
+	var kids []string
+	for _, k := range jwt.JWKS()["keys"].([]map[string]string) {
+		kids = append(kids, k["kid"])
+	}
+	return kids
+}
+
+func TestKeys_SignsWithDatabaseKey(t *testing.T) {
+	db := setupKeysDB(t)
+	legacy := newToken(t)
+	assert.Nil(t, tokenHeader(t, legacy)["kid"])
+
+	require.NoError(t, jwt.EnsureSigningKey(db, jwt.AlgEdDSA))
+	require.NoError(t, jwt.EnsureSigningKey(db, jwt.AlgEdDSA)) // no second key
+	require.NoError(t, jwt.LoadKeys(db))
+	var key models.SigningKey
+	require.NoError(t, db.Where("kid IS NOT NULL").First(&key).Error)
+	var n int64
+	db.Model(&models.SigningKey{}).Count(&n)
+	assert.Equal(t, int64(1), n)
+
+	tok := newToken(t)
+	header := tokenHeader(t, tok)
+	assert.Equal(t, "EdDSA", header["alg"])
+	assert.Equal(t, key.KID, header["kid"])
+	assert.NoError(t, jwt.VerifyToken(tok))
+
+	// Tokens issued with JWT_SECRET before the switch keep working.
+	assert.NoError(t, jwt.VerifyToken(legacy))
+}
+
+func TestKeys_JWKSVerifiesTokens(t *testing.T) {
+	db := setupKeysDB(t)
+	require.NoError(t, jwt.EnsureSigningKey(db, jwt.AlgEdDSA))
+	require.NoError(t, jwt.LoadKeys(db))
+
+	r := gin.New()
+	routes.InitKeyRoutes(r)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Cache-Control"), "max-age")
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 1)
+	jwk := set.Keys[0]
+	assert.Equal(t, "OKP", jwk["kty"])
+	assert.Equal(t, "Ed25519", jwk["crv"])
+	assert.Equal(t, "sig", jwk["use"])
+	assert.Empty(t, jwk["d"], "private key must not be published")
+
+	// Another service can verify a token with nothing but the JWKS.
+	x, err := base64.RawURLEncoding.DecodeString(jwk["x"])
+	require.NoError(t, err)
+	parsed, err := jwtlib.Parse(newToken(t), func(tok *jwtlib.Token) (any, error) {
+		return ed25519.PublicKey(x), nil
+	}, jwtlib.WithValidMethods([]string{"EdDSA"}))
+	require.NoError(t, err)
+	assert.Equal(t, jwk["kid"], parsed.Header["kid"])
+}
+
+func TestKeys_RotationKeepsOldTokensValid(t *testing.T) {
+	db := setupKeysDB(t)
+	require.NoError(t, jwt.EnsureSigningKey(db, jwt.AlgEdDSA))
+	require.NoError(t, jwt.LoadKeys(db))
+	oldTok := newToken(t)
+	oldKID := tokenHeader(t, oldTok)["kid"]
+
+	// A delayed key is published straight away but doesn't sign yet.
+	pending, err := jwt.RotateKeys(db, jwt.RotateOptions{Algorithm: jwt.AlgRS256, Delay: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, jwt.LoadKeys(db))
+	assert.ElementsMatch(t, []any{oldKID, pending.KID}, toAny(jwksKIDs()))
+	assert.Equal(t, oldKID, tokenHeader(t, newToken(t))["kid"])
+
+	// The old key will expire once its tokens can have: activation + token TTL.
+	var old models.SigningKey
+	require.NoError(t, db.Where("kid = ?", oldKID).First(&old).Error)
+	require.NotNil(t, old.ExpiresAt)
+	assert.WithinDuration(t, pending.ActivatesAt.Add(jwt.ACCESS_TOKEN_TTL), *old.ExpiresAt, 2*time.Minute)
+
+	// Once the new key activates it signs, and the old key's tokens still verify.
+	require.NoError(t, db.Model(pending).Update("activates_at", time.Now()).Error)
+	require.NoError(t, jwt.LoadKeys(db))
+	newTok := newToken(t)
+	assert.Equal(t, pending.KID, tokenHeader(t, newTok)["kid"])
+	assert.Equal(t, "RS256", tokenHeader(t, newTok)["alg"])
+	assert.NoError(t, jwt.VerifyToken(newTok))
+	assert.NoError(t, jwt.VerifyToken(oldTok))
+
+	require.NoError(t, db.Model(&old).Update("expires_at", time.Now().Add(-time.Second)).Error)
+	require.NoError(t, jwt.LoadKeys(db))
+	assert.Error(t, jwt.VerifyToken(oldTok))
+	assert.NoError(t, jwt.VerifyToken(newTok))
+	assert.Equal(t, []string{pending.KID}, jwksKIDs())
+}
+
+func TestKeys_RevokeRejectsOldTokens(t *testing.T) {
+	db := setupKeysDB(t)
+	require.NoError(t, jwt.EnsureSigningKey(db, jwt.AlgEdDSA))
+	require.NoError(t, jwt.LoadKeys(db))
+	oldTok := newToken(t)
+
+	key, err := jwt.RotateKeys(db, jwt.RotateOptions{Algorithm: jwt.AlgEdDSA, Delay: time.Hour, Revoke: true})
+	require.NoError(t, err)
+	require.NoError(t, jwt.LoadKeys(db))
+
+	assert.Error(t, jwt.VerifyToken(oldTok))
+	newTok := newToken(t)
+	assert.Equal(t, key.KID, tokenHeader(t, newTok)["kid"])
+	assert.NoError(t, jwt.VerifyToken(newTok))
+	assert.Equal(t, []string{key.KID}, jwksKIDs())
+}
+
+func TestKeys_RejectsForgedHeaders(t *testing.T) {
+	db := setupKeysDB(t)
+	require.NoError(t, jwt.EnsureSigningKey(db, jwt.AlgEdDSA))
+	require.NoError(t, jwt.LoadKeys(db))
+	kid := tokenHeader(t, newToken(t))["kid"]
+
+	claims := jwtlib.MapClaims{"user_id": "12345678", "sid": "s", "exp": time.Now().Add(time.Minute).Unix()}
+
+	// HS256 under an EdDSA key's kid: the algorithm must match the key.
+	hs := jwtlib.NewWithClaims(jwtlib.SigningMethodHS256, claims)
+	hs.Header["kid"] = kid
+	forged, err := hs.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	require.NoError(t, err)
+	assert.Error(t, jwt.VerifyToken(forged))
+
+	// Unknown kid.
+	hs.Header["kid"] = "not-a-key"
+	forged, _ = hs.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	assert.Error(t, jwt.VerifyToken(forged))
+
+	// alg "none".
+	none := jwtlib.NewWithClaims(jwtlib.SigningMethodNone, claims)
+	none.Header["kid"] = kid
+	forged, err = none.SignedString(jwtlib.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	assert.Error(t, jwt.VerifyToken(forged))
+}
+
+func TestKeys_RejectsUnknownAlgorithm(t *testing.T) {
+	db := setupKeysDB(t)
+	_, err := jwt.RotateKeys(db, jwt.RotateOptions{Algorithm: "HS256"})
+	assert.ErrorContains(t, err, "unsupported signing algorithm")
+	assert.ErrorContains(t, jwt.EnsureSigningKey(db, "ES256"), "unsupported signing algorithm")
+}
+
+func toAny(s []string) []any {
+	out := make([]any, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+	return out
+}
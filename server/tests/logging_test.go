@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/logging"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/middleware/requestid"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// captureLogs makes the default logger write JSON at level into the returned
+// buffer until the test ends.
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	logging.Setup(&buf, level)
+	return &buf
+}
+
+// logRecords decodes every JSON line in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	sc := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for sc.Scan() {
+		var rec map[string]any
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &rec), sc.Text())
+		records = append(records, rec)
+	}
+	return records
+}
+
+// findRecord returns the first record with message msg.
+func findRecord(records []map[string]any, msg string) map[string]any {
+	for _, rec := range records {
+		if rec["msg"] == msg {
+			return rec
+		}
+	}
+	return nil
+}
+
+// loggingRouter serves the user, box and file routes against config behind
+// the production request ID and logging middleware.
+func loggingRouter(db *gorm.DB, config s3db.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestid.Middleware())
+	r.Use(logging.Middleware())
+	routes.InitUserRoutes(r, config, db, ratelimit.New(100, time.Minute))
+	routes.InitBoxRoutes(r, config, db)
+	routes.InitFileRoutes(r, config, db)
+	return r
+}
+
+func TestLogging_RecordsCarryRequestContext(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	_, cfg := newFakeS3(t)
+	buf := captureLogs(t, slog.LevelDebug)
+	r := loggingRouter(db, cfg)
+	var box models.Box
+	require.NoError(t, db.Where("user_id = ?", u.ID).First(&box).Error)
+	require.NoError(t, db.Create(&models.File{Name: "a.txt", S3Key: "log-test-key", UserID: u.ID, BoxID: box.ID, Confirmed: true}).Error)
+
+	req := httptest.NewRequest("DELETE", "/v1/api/files/log-test-key", nil)
+	req.Header.Set("Authorization", authHeader(t, u))
+	req.Header.Set(requestid.Header, "trace-log-1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	records := logRecords(t, buf)
+	for _, msg := range []string{"s3 call", "File deleted", "request"} {
+		rec := findRecord(records, msg)
+		require.NotNil(t, rec, "no %q record in:\n%s", msg, buf.String())
+		assert.Equal(t, "trace-log-1", rec["request_id"], msg)
+		assert.Equal(t, "/v1/api/files/:name", rec["route"], msg)
+		assert.EqualValues(t, u.ID, rec["user_id"], msg)
+	}
+	assert.Equal(t, "DeleteObject", findRecord(records, "s3 call")["operation"])
+	access := findRecord(records, "request")
+	assert.Equal(t, "INFO", access["level"])
+	assert.EqualValues(t, http.StatusOK, access["status"])
+	assert.Equal(t, "DELETE", access["method"])
+}
+
+func TestLogging_ErrorResponsesCarryRequestID(t *testing.T) {
+	captureLogs(t, slog.LevelInfo)
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := loggingRouter(db, s3db.Config{})
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/boxes", "", nil)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	id := w.Header().Get(requestid.Header)
+	require.NotEmpty(t, id)
+	assert.Equal(t, id, body["request_id"])
+	assert.Equal(t, "missing authorization token", body["error"])
+
+	// Successful responses are left alone.
+	w, body = appPasswordRequest(r, "GET", "/v1/api/boxes", authHeader(t, u), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, body, "request_id")
+
+	// So are error bodies that aren't JSON.
+	w, _ = appPasswordRequest(r, "GET", "/nowhere", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "404 page not found", w.Body.String())
+}
+
+func TestLogging_Level(t *testing.T) {
+	for in, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "error": slog.LevelError} {
+		got, err := logging.ParseLevel(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := logging.ParseLevel("verbose")
+	assert.Error(t, err)
+
+	buf := captureLogs(t, slog.LevelWarn)
+	r := loggingRouter(setupDavDB(t), s3db.Config{})
+	appPasswordRequest(r, "GET", "/v1/api/boxes", "", nil)
+	records := logRecords(t, buf)
+	assert.Nil(t, findRecord(records, "request"), "info records are dropped at warn")
+	rejected := findRecord(records, "Authentication rejected")
+	require.NotNil(t, rejected, buf.String())
+	assert.Equal(t, "WARN", rejected["level"])
+	assert.NotEmpty(t, rejected["request_id"])
+}
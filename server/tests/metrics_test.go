@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/metrics"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const metricsToken = "scrape-token"
+
+// metricsRouter serves the user, box and file routes against config, measured
+// by metrics.Middleware, plus /metrics behind metricsToken.
+func metricsRouter(db *gorm.DB, config s3db.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(metrics.Middleware())
+	routes.InitUserRoutes(r, config, db, ratelimit.New(100, time.Minute))
+	routes.InitBoxRoutes(r, config, db)
+	routes.InitFileRoutes(r, config, db)
+	routes.InitMetricsRoutes(r, metricsToken)
+	return r
+}
+
+// scrape returns every sample /metrics serves, keyed by its series as written
+// in the exposition format, e.g. `nimbus_s3_errors_total{operation="GetObject"}`.
+// Metrics are process-wide, so tests compare samples before and after.
+func scrape(t *testing.T, r *gin.Engine) map[string]float64 {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+metricsToken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	samples := map[string]float64{}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		require.NoError(t, err, line)
+		samples[line[:i]] = v
+	}
+	return samples
+}
+
+func TestMetrics_RequiresToken(t *testing.T) {
+	r := metricsRouter(setupDavDB(t), s3db.Config{})
+	for _, auth := range []string{"", "Bearer wrong", metricsToken} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, auth)
+	}
+	assert.Contains(t, scrape(t, r), "go_goroutines")
+}
+
+func TestMetrics_CountsRequestsByRouteTemplate(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	r := metricsRouter(db, s3db.Config{})
+	auth := authHeader(t, u)
+
+	ok := `nimbus_http_requests_total{method="GET",route="/v1/api/boxes",status="200"}`
+	denied := `nimbus_http_requests_total{method="GET",route="/v1/api/boxes",status="401"}`
+	unmatched := `nimbus_http_requests_total{method="",route="unmatched",status="404"}`
+	latency := `nimbus_http_request_duration_seconds_count{method="GET",route="/v1/api/boxes",status="200"}`
+	before := scrape(t, r)
+
+	for i := 0; i < 3; i++ {
+		appPasswordRequest(r, "GET", "/v1/api/boxes", auth, nil)
+	}
+	appPasswordRequest(r, "GET", "/v1/api/boxes", "", nil)
+	appPasswordRequest(r, "GET", "/wp-login.php", "", nil)
+	appPasswordRequest(r, "BREW", "/coffee", "", nil)
+
+	after := scrape(t, r)
+	assert.Equal(t, 3.0, after[ok]-before[ok])
+	assert.Equal(t, 3.0, after[latency]-before[latency])
+	assert.Equal(t, 1.0, after[denied]-before[denied])
+	assert.Equal(t, 2.0, after[unmatched]-before[unmatched])
+	for series := range after {
+		assert.NotContains(t, series, "wp-login", "paths no route matched must not become labels")
+		assert.NotContains(t, series, "BREW")
+	}
+}
+
+func TestMetrics_S3CallsByOperation(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	_, cfg := newFakeS3(t)
+	r := metricsRouter(db, cfg)
+	var box models.Box
+	require.NoError(t, db.Where("user_id = ?", u.ID).First(&box).Error)
+	store := storage.New(db, cfg)
+
+	put := `nimbus_s3_request_duration_seconds_count{operation="PutObject"}`
+	getErrors := `nimbus_s3_errors_total{operation="GetObject"}`
+	before := scrape(t, r)
+
+	_, err := store.Put(context.Background(), &box, "a.txt", strings.NewReader("hello"), 5)
+	require.NoError(t, err)
+	_, err = cfg.Client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: &cfg.Bucket, Key: aws.String("missing")})
+	require.Error(t, err)
+
+	after := scrape(t, r)
+	assert.Equal(t, 1.0, after[put]-before[put])
+	assert.Equal(t, 1.0, after[getErrors]-before[getErrors])
+}
+
+func TestMetrics_PresignedBytesAndPendingUploads(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	_, cfg := newFakeS3(t)
+	r := metricsRouter(db, cfg)
+	metrics.UseDB(db)
+	t.Cleanup(func() { metrics.UseDB(nil) })
+	auth := authHeader(t, u)
+
+	uploaded := `nimbus_presign_bytes_total{direction="upload"}`
+	downloaded := `nimbus_presign_bytes_total{direction="download"}`
+	before := scrape(t, r)
+	assert.Equal(t, 0.0, before["nimbus_uploads_pending"])
+	assert.Contains(t, before, `go_sql_open_connections{db_name="nimbus"}`)
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/files/presign-upload?box_name=Test-Box&filename=a.txt&size=1500", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w, _ = appPasswordRequest(r, "GET", "/v1/api/files/presign-download?box_name=Test-Box&key=a.txt", auth, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	after := scrape(t, r)
+	assert.Equal(t, 1500.0, after[uploaded]-before[uploaded])
+	assert.Equal(t, 1500.0, after[downloaded]-before[downloaded])
+	assert.Equal(t, 1.0, after["nimbus_uploads_pending"])
+}
+
+func TestMetrics_RateLimiterDecisions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/limited", ratelimit.New(1, time.Minute).Middleware(nil), func(c *gin.Context) { c.Status(http.StatusOK) })
+	routes.InitMetricsRoutes(r, metricsToken)
+
+	allowed := `nimbus_ratelimit_requests_total{result="allowed"}`
+	denied := `nimbus_ratelimit_requests_total{result="denied"}`
+	before := scrape(t, r)
+	for i := 0; i < 3; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/limited", nil))
+	}
+	after := scrape(t, r)
+	assert.Equal(t, 1.0, after[allowed]-before[allowed])
+	assert.Equal(t, 2.0, after[denied]-before[denied])
+}
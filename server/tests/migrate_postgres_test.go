@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nimbus/api/db/migrate"
+	"github.com/nimbus/api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// The models as they were before migrations, when the server created the
+// schema with AutoMigrate. Deployed databases look like this, so it's where
+// the built-in migrations have to start from.
+type baselineUser struct {
+	ID       uint          `gorm:"primaryKey;autoIncrement:false"`
+	Email    string        `gorm:"unique;not null"`
+	Password string        `gorm:"not null"`
+	PassKey  string        `gorm:"not null"`
+	Boxes    []baselineBox `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	gorm.Model
+}
+
+type baselineBox struct {
+	gorm.Model
+	UserID  uint             `gorm:"not null;index"`
+	BoxID   uint             `gorm:"not null;index"`
+	Name    string           `gorm:"not null"`
+	Size    int64            `gorm:"default:0"`
+	Folders []baselineFolder `gorm:"foreignKey:BoxID;constraint:OnDelete:CASCADE"`
+	Files   []baselineFile   `gorm:"foreignKey:BoxID;constraint:OnDelete:CASCADE"`
+}
+
+type baselineFolder struct {
+	gorm.Model
+	ID         uint             `gorm:"primaryKey"`
+	Name       string           `gorm:"not null"`
+	UserID     uint             `gorm:"not null;index"`
+	BoxID      uint             `gorm:"not null;index"`
+	ParentID   *uint            `gorm:"index"`
+	Files      []baselineFile   `gorm:"foreignKey:FolderID"`
+	SubFolders []baselineFolder `gorm:"foreignKey:ParentID"`
+}
+
+type baselineFile struct {
+	gorm.Model
+	Name      string          `gorm:"not null"`
+	Size      int64           `gorm:"default:0"`
+	S3Key     string          `gorm:"unique;not null"`
+	Confirmed bool            `gorm:"not null;default:false"`
+	UserID    uint            `gorm:"not null;index"`
+	BoxID     uint            `gorm:"not null;index"`
+	FolderID  *uint           `gorm:"index"`
+	User      baselineUser    `gorm:"constraint:OnDelete:CASCADE"`
+	Box       baselineBox     `gorm:"constraint:OnDelete:CASCADE"`
+	Folder    *baselineFolder `gorm:"constraint:OnDelete:SET NULL"`
+}
+
+func (baselineUser) TableName() string   { return "users" }
+func (baselineBox) TableName() string    { return "boxes" }
+func (baselineFolder) TableName() string { return "folders" }
+func (baselineFile) TableName() string   { return "files" }
+
+// setupPostgresDB connects to TEST_DATABASE_URL, skipping the test when it
+// isn't set, and confines the test to a schema of its own that is dropped
+// afterwards. The pool is held to one connection so the search_path set on it
+// applies to every query.
+func setupPostgresDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	name := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	require.NoError(t, db.Exec(`CREATE SCHEMA "`+name+`"`).Error)
+	t.Cleanup(func() { db.Exec(`DROP SCHEMA "` + name + `" CASCADE`) })
+	require.NoError(t, db.Exec(`SET search_path TO "`+name+`"`).Error)
+	return db
+}
+
+func TestMigrate_UpFromAutoMigrateBaseline(t *testing.T) {
+	db := setupPostgresDB(t)
+	ctx := context.Background()
+	require.NoError(t, db.AutoMigrate(&baselineUser{}, &baselineBox{}, &baselineFolder{}, &baselineFile{}))
+
+	// An account from before the series, with a passkey and a nested folder.
+	user := baselineUser{ID: 42, Email: "old@example.com", Password: "hash", PassKey: "passkey-hash"}
+	require.NoError(t, db.Create(&user).Error)
+	box := baselineBox{UserID: user.ID, BoxID: 7, Name: "Home-Box"}
+	require.NoError(t, db.Create(&box).Error)
+	docs := baselineFolder{Name: "docs", UserID: user.ID, BoxID: box.ID}
+	require.NoError(t, db.Create(&docs).Error)
+	work := baselineFolder{Name: "work", UserID: user.ID, BoxID: box.ID, ParentID: &docs.ID}
+	require.NoError(t, db.Create(&work).Error)
+
+	m, err := migrate.New(db, migrate.FS)
+	require.NoError(t, err)
+	assert.ErrorIs(t, m.Check(ctx), migrate.ErrSchemaBehind)
+	done, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, done, m.Latest())
+	require.NoError(t, m.Check(ctx))
+
+	// Every column of every model is there, not only the ones 0001 lists.
+	for _, model := range []any{
+		&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.AppPassword{},
+		&models.S3AccessKey{}, &models.S3Upload{}, &models.SSHKey{}, &models.PersonalAccessToken{},
+		&models.Session{}, &models.RefreshToken{}, &models.RecoveryCode{}, &models.LoginChallenge{},
+		&models.Identity{}, &models.OIDCAuthRequest{}, &models.DeviceAuthorization{}, &models.AuditEvent{},
+		&models.SigningKey{},
+	} {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		require.NoError(t, err)
+		for _, name := range s.DBNames {
+			assert.True(t, db.Migrator().HasColumn(s.Table, name), "no column %s.%s", s.Table, name)
+		}
+	}
+	assert.False(t, db.Migrator().HasColumn("users", "pass_key"))
+	assert.True(t, db.Migrator().HasIndex("folders", "idx_folders_box_parent_name"))
+	assert.True(t, db.Migrator().HasIndex("folders", "idx_folders_box_path"))
+
+	// The existing rows were carried over and filled in.
+	var u models.User
+	require.NoError(t, db.First(&u, user.ID).Error)
+	assert.Equal(t, "user", u.Role)
+	assert.False(t, u.TOTPEnabled)
+	var b models.Box
+	require.NoError(t, db.First(&b, box.ID).Error)
+	assert.Equal(t, "users/nim-user-42/boxes/Home-Box/", b.S3Prefix)
+	var f models.Folder
+	require.NoError(t, db.First(&f, work.ID).Error)
+	assert.Equal(t, "docs/work", f.Path)
+
+	// The down scripts take it back to the baseline, and up again works.
+	_, err = m.Down(ctx, m.Latest()-1)
+	require.NoError(t, err)
+	assert.False(t, db.Migrator().HasTable("app_passwords"))
+	assert.False(t, db.Migrator().HasColumn("users", "totp_enabled"))
+	assert.True(t, db.Migrator().HasColumn("users", "pass_key"))
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.NoError(t, m.Check(ctx))
+}
@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"context"
+	"io/fs"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/nimbus/api/db/migrate"
+	"github.com/nimbus/api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+func setupEmptyDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	return db
+}
+
+var widgetMigrations = fstest.MapFS{
+	"0001_widgets.up.sql":         {Data: []byte("CREATE TABLE widgets (id integer PRIMARY KEY, name text NOT NULL);")},
+	"0001_widgets.down.sql":       {Data: []byte("DROP TABLE widgets;")},
+	"0002_widget_colour.up.sql":   {Data: []byte("ALTER TABLE widgets ADD COLUMN colour text;\nUPDATE widgets SET colour = 'grey';")},
+	"0002_widget_colour.down.sql": {Data: []byte("ALTER TABLE widgets DROP COLUMN colour;")},
+}
+
+func TestMigrate_Load(t *testing.T) {
+	migrations, err := migrate.Load(widgetMigrations)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, "0001_widgets", migrations[0].String())
+	assert.Equal(t, "0002_widget_colour", migrations[1].String())
+	assert.Contains(t, migrations[1].Up, "ADD COLUMN colour")
+
+	for name, files := range map[string]fstest.MapFS{
+		"no down script": {"0001_a.up.sql": {}},
+		"no up script":   {"0001_a.down.sql": {}},
+		"gap":            {"0001_a.up.sql": {}, "0001_a.down.sql": {}, "0003_c.up.sql": {}, "0003_c.down.sql": {}},
+		"two names":      {"0001_a.up.sql": {}, "0001_b.down.sql": {}},
+		"bad file name":  {"1_a.up.sql": {}, "1_a.down.sql": {}},
+	} {
+		_, err := migrate.Load(files)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestMigrate_UpStatusDown(t *testing.T) {
+	db := setupEmptyDB(t)
+	ctx := context.Background()
+	m, err := migrate.New(db, widgetMigrations)
+	require.NoError(t, err)
+
+	// A database that was never migrated is behind, and nothing is created
+	// just by checking.
+	assert.ErrorIs(t, m.Check(ctx), migrate.ErrSchemaBehind)
+	assert.False(t, db.Migrator().HasTable("schema_migrations"))
+
+	require.NoError(t, db.Exec("CREATE TABLE unrelated (id integer)").Error)
+	done, err := m.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, done, 2)
+	assert.NoError(t, m.Check(ctx))
+	assert.True(t, db.Migrator().HasColumn("widgets", "colour"))
+
+	states, err := m.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+	for _, s := range states {
+		assert.NotNil(t, s.AppliedAt, s.String())
+	}
+
+	done, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, done, "a second run has nothing to do")
+
+	done, err = m.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, done, 1)
+	assert.Equal(t, 2, done[0].Version)
+	assert.False(t, db.Migrator().HasColumn("widgets", "colour"))
+	assert.ErrorIs(t, m.Check(ctx), migrate.ErrSchemaBehind)
+
+	states, err = m.Status(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, states[0].AppliedAt)
+	assert.Nil(t, states[1].AppliedAt)
+
+	done, err = m.Down(ctx, 5)
+	require.NoError(t, err)
+	assert.Len(t, done, 1, "only what is applied is rolled back")
+	assert.False(t, db.Migrator().HasTable("widgets"))
+	assert.True(t, db.Migrator().HasTable("unrelated"))
+}
+
+func TestMigrate_FailedMigrationRollsBack(t *testing.T) {
+	db := setupEmptyDB(t)
+	ctx := context.Background()
+	files := fstest.MapFS{
+		"0001_widgets.up.sql":   widgetMigrations["0001_widgets.up.sql"],
+		"0001_widgets.down.sql": widgetMigrations["0001_widgets.down.sql"],
+		"0002_broken.up.sql":    {Data: []byte("CREATE TABLE gadgets (id integer);\nINSERT INTO missing VALUES (1);")},
+		"0002_broken.down.sql":  {Data: []byte("DROP TABLE gadgets;")},
+	}
+	m, err := migrate.New(db, files)
+	require.NoError(t, err)
+
+	done, err := m.Up(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "0002_broken")
+	assert.Len(t, done, 1, "migrations before the failure stay applied")
+	assert.True(t, db.Migrator().HasTable("widgets"))
+	assert.False(t, db.Migrator().HasTable("gadgets"), "the failed migration's changes are rolled back")
+
+	states, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, states[1].AppliedAt)
+}
+
+func TestMigrate_SchemaFromNewerBuild(t *testing.T) {
+	db := setupEmptyDB(t)
+	ctx := context.Background()
+	newer, err := migrate.New(db, widgetMigrations)
+	require.NoError(t, err)
+	_, err = newer.Up(ctx)
+	require.NoError(t, err)
+
+	// An older build, knowing only the first migration, still starts, but
+	// won't roll back what it doesn't know.
+	older, err := migrate.New(db, fstest.MapFS{
+		"0001_widgets.up.sql":   widgetMigrations["0001_widgets.up.sql"],
+		"0001_widgets.down.sql": widgetMigrations["0001_widgets.down.sql"],
+	})
+	require.NoError(t, err)
+	assert.NoError(t, older.Check(ctx))
+
+	states, err := older.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, states, 2)
+	assert.True(t, states[1].Unknown)
+	assert.Equal(t, "0002_widget_colour", states[1].String())
+
+	_, err = older.Down(ctx, 1)
+	assert.ErrorContains(t, err, "newer build")
+	assert.True(t, db.Migrator().HasColumn("widgets", "colour"))
+}
+
+// TestMigrate_CoversModels checks the built-in migrations create a column for
+// every field of every model, so a model change can't ship without one.
+func TestMigrate_CoversModels(t *testing.T) {
+	migrations, err := migrate.Load(migrate.FS)
+	require.NoError(t, err)
+	var up strings.Builder
+	for _, m := range migrations {
+		up.WriteString(m.Up)
+	}
+	sql := up.String()
+
+	entries, err := fs.ReadDir(migrate.FS, ".")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2*len(migrations))
+
+	for _, model := range []any{
+		&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.AppPassword{},
+		&models.S3AccessKey{}, &models.S3Upload{}, &models.SSHKey{}, &models.PersonalAccessToken{},
+		&models.Session{}, &models.RefreshToken{}, &models.RecoveryCode{}, &models.LoginChallenge{},
+		&models.Identity{}, &models.OIDCAuthRequest{}, &models.DeviceAuthorization{}, &models.AuditEvent{},
+		&models.SigningKey{},
+	} {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		require.NoError(t, err)
+		table := regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS "` + s.Table + `" \((.*?)\n\);`).FindStringSubmatch(sql)
+		if !assert.NotNil(t, table, "no CREATE TABLE for %s", s.Table) {
+			continue
+		}
+		for _, name := range s.DBNames {
+			added := regexp.MustCompile(`ALTER TABLE "` + s.Table + `" ADD COLUMN (IF NOT EXISTS )?"` + name + `"`).MatchString(sql)
+			assert.True(t, strings.Contains(table[1], `"`+name+`" `) || added, "no column %s.%s", s.Table, name)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// setupOIDCTest returns a database with the single sign-on tables, the full
+// auth router and a fake issuer.
+func setupOIDCTest(t *testing.T) (*gorm.DB, *gin.Engine, *fakeOIDC) {
+	t.Helper()
+	db := setupLoginDB(t)
+	if err := db.AutoMigrate(&models.Identity{}, &models.OIDCAuthRequest{}, &models.DeviceAuthorization{}); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	idp := newFakeOIDC(t)
+	return db, sessionRouter(db), idp
+}
+
+// ssoGet requests path from r the way a browser would.
+func ssoGet(r *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+// ssoStart requests a path that redirects to the issuer, follows that
+// redirect through the fake issuer and returns the callback path it sends the
+// browser back to.
+func ssoStart(t *testing.T, r *gin.Engine, path string) string {
+	t.Helper()
+	w := ssoGet(r, path)
+	if w.Code != http.StatusFound {
+		t.Fatalf("GET %s: %d %s", path, w.Code, w.Body.String())
+	}
+	return ssoFollow(t, w)
+}
+
+// ssoFollow follows w's redirect to the issuer through the fake issuer and
+// returns the callback path it sends the browser back to.
+func ssoFollow(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	back, _ := url.Parse(resp.Header.Get("Location"))
+	return back.RequestURI()
+}
+
+// ssoSignIn runs a whole browser sign-in from path and returns the callback
+// response.
+func ssoSignIn(t *testing.T, r *gin.Engine, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	return ssoGet(r, ssoStart(t, r, path))
+}
+
+var csrfField = regexp.MustCompile(`name="csrf" value="([0-9a-f]+)"`)
+
+// deviceConfirm opens the verification page for userCode the way a browser
+// would and posts its confirmation form, returning the response to the post.
+func deviceConfirm(t *testing.T, r *gin.Engine, userCode string) *httptest.ResponseRecorder {
+	t.Helper()
+	page := ssoGet(r, "/v1/api/auth/oidc/verify?user_code="+url.QueryEscape(userCode))
+	m := csrfField.FindStringSubmatch(page.Body.String())
+	if m == nil {
+		t.Fatalf("no confirmation form: %d %s", page.Code, page.Body.String())
+	}
+	form := url.Values{"user_code": {userCode}, "csrf": {m[1]}}
+	return devicePost(r, form, page.Result().Cookies()...)
+}
+
+// devicePost posts form to the verification page with cookies.
+func devicePost(r *gin.Engine, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/api/auth/oidc/verify", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, ck := range cookies {
+		req.AddCookie(ck)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// deviceSignIn confirms userCode and runs the sign-in at the issuer,
+// returning the callback response.
+func deviceSignIn(t *testing.T, r *gin.Engine, userCode string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := deviceConfirm(t, r, userCode)
+	if w.Code != http.StatusFound {
+		t.Fatalf("confirm %s: %d %s", userCode, w.Code, w.Body.String())
+	}
+	return ssoGet(r, ssoFollow(t, w))
+}
+
+func TestOIDC_NotConfigured(t *testing.T) {
+	db := setupLoginDB(t)
+	r := sessionRouter(db)
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/oidc/device", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Single sign-on is not configured", body["error"])
+	assert.Equal(t, http.StatusNotFound, ssoGet(r, "/v1/api/auth/oidc/login").Code)
+}
+
+func TestOIDC_SignInCreatesAccount(t *testing.T) {
+	db, r, idp := setupOIDCTest(t)
+
+	w := ssoSignIn(t, r, "/v1/api/auth/oidc/login")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"token"`)
+
+	var u models.User
+	assert.NoError(t, db.Preload("Boxes").Where("email = ?", "sso@example.com").First(&u).Error)
+	assert.Empty(t, u.Password, "SSO accounts have no password")
+	assert.Len(t, u.Boxes, 1)
+	var identity models.Identity
+	db.First(&identity)
+	assert.Equal(t, u.ID, identity.UserID)
+	assert.Equal(t, idp.URL, identity.Issuer)
+
+	// The subject, not the email, identifies the user next time.
+	idp.Email = "renamed@example.com"
+	w = ssoSignIn(t, r, "/v1/api/auth/oidc/login")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var n int64
+	db.Model(&models.User{}).Count(&n)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestOIDC_LinksVerifiedEmail(t *testing.T) {
+	db, r, idp := setupOIDCTest(t)
+	existing := seedLoginUser(t, db, "linked@example.com", "Test123!@#")
+	idp.Email = "linked@example.com"
+
+	w := ssoSignIn(t, r, "/v1/api/auth/oidc/login")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var identity models.Identity
+	db.First(&identity)
+	assert.Equal(t, existing.ID, identity.UserID)
+	var n int64
+	db.Model(&models.User{}).Count(&n)
+	assert.Equal(t, int64(1), n)
+
+	// The password still works alongside SSO.
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "linked@example.com", "password": "Test123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestOIDC_RejectsUnverifiedEmail(t *testing.T) {
+	db, r, idp := setupOIDCTest(t)
+	seedLoginUser(t, db, "victim@example.com", "Test123!@#")
+	idp.Email = "victim@example.com"
+	idp.EmailVerified = false
+
+	w := ssoSignIn(t, r, "/v1/api/auth/oidc/login")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var n int64
+	db.Model(&models.Identity{}).Count(&n)
+	assert.Zero(t, n, "an unverified email must not take over an account")
+}
+
+func TestOIDC_CallbackIsSingleUse(t *testing.T) {
+	_, r, _ := setupOIDCTest(t)
+
+	callback := ssoStart(t, r, "/v1/api/auth/oidc/login")
+	assert.Equal(t, http.StatusOK, ssoGet(r, callback).Code)
+	assert.Equal(t, http.StatusBadRequest, ssoGet(r, callback).Code)
+
+	assert.Equal(t, http.StatusBadRequest, ssoGet(r, "/v1/api/auth/oidc/callback?state=forged&code=x").Code)
+}
+
+func TestOIDC_RejectsBadIDTokens(t *testing.T) {
+	db, r, idp := setupOIDCTest(t)
+
+	idp.Nonce = "someone-elses-nonce"
+	assert.Equal(t, http.StatusUnauthorized, ssoSignIn(t, r, "/v1/api/auth/oidc/login").Code)
+
+	idp.Nonce = ""
+	idp.SignWith, _ = rsa.GenerateKey(rand.Reader, 2048)
+	assert.Equal(t, http.StatusUnauthorized, ssoSignIn(t, r, "/v1/api/auth/oidc/login").Code)
+
+	var n int64
+	db.Model(&models.User{}).Count(&n)
+	assert.Zero(t, n)
+}
+
+func TestOIDC_ChecksPKCEVerifier(t *testing.T) {
+	db, r, _ := setupOIDCTest(t)
+
+	callback := ssoStart(t, r, "/v1/api/auth/oidc/login")
+	// A stolen code is useless without the verifier that matches the challenge.
+	db.Model(&models.OIDCAuthRequest{}).Where("1 = 1").Update("code_verifier", "not-the-verifier")
+	assert.Equal(t, http.StatusUnauthorized, ssoGet(r, callback).Code)
+}
+
+// startDeviceLogin begins a CLI sign-in and returns the response body.
+func startDeviceLogin(t *testing.T, r *gin.Engine) map[string]any {
+	t.Helper()
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/oidc/device", "", map[string]string{"device": "build-laptop"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("device authorization: %d %s", w.Code, w.Body.String())
+	}
+	return body
+}
+
+// pollDevice asks for the tokens of a device sign-in.
+func pollDevice(r *gin.Engine, deviceCode string) (*httptest.ResponseRecorder, map[string]any) {
+	return appPasswordRequest(r, "POST", "/v1/api/auth/oidc/device/token", "", map[string]string{"device_code": deviceCode})
+}
+
+func TestOIDC_DeviceFlow(t *testing.T) {
+	db, r, _ := setupOIDCTest(t)
+
+	start := startDeviceLogin(t, r)
+	deviceCode := start["device_code"].(string)
+	userCode := start["user_code"].(string)
+	assert.Regexp(t, `^[A-Z]{4}-[A-Z]{4}$`, userCode)
+	assert.Equal(t, "http://nimbus.test/v1/api/auth/oidc/verify", start["verification_uri"])
+
+	_, body := pollDevice(r, deviceCode)
+	assert.Equal(t, "authorization_pending", body["error"])
+	_, body = pollDevice(r, deviceCode)
+	assert.Equal(t, "slow_down", body["error"])
+
+	// Without a code the page asks for one; with one it asks for confirmation
+	// and names the device.
+	assert.Contains(t, ssoGet(r, "/v1/api/auth/oidc/verify").Body.String(), `name="user_code"`)
+	w := ssoGet(r, "/v1/api/auth/oidc/verify?user_code="+url.QueryEscape(userCode))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "build-laptop")
+
+	// Lower case and no dash, as someone might type it.
+	typed := strings.ToLower(userCode[:4] + userCode[5:])
+	w = deviceSignIn(t, r, typed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "return to your terminal")
+
+	db.Model(&models.DeviceAuthorization{}).Where("1 = 1").Update("last_polled_at", time.Now().Add(-time.Minute))
+	w, body = pollDevice(r, deviceCode)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, body["token"])
+	assert.NotEmpty(t, body["refresh_token"])
+	assert.Equal(t, "sso@example.com", body["email"])
+
+	var s models.Session
+	db.First(&s)
+	assert.Equal(t, "build-laptop", s.Device)
+
+	// The tokens are handed out once.
+	_, body = pollDevice(r, deviceCode)
+	assert.Equal(t, "invalid_grant", body["error"])
+}
+
+func TestOIDC_DeviceConfirmNeedsThePage(t *testing.T) {
+	_, r, _ := setupOIDCTest(t)
+	userCode := startDeviceLogin(t, r)["user_code"].(string)
+
+	// A link can't skip the confirmation step.
+	w := ssoGet(r, "/v1/api/auth/oidc/verify?confirm=1&user_code="+url.QueryEscape(userCode))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `method="post"`)
+
+	// Nor can a form posted from another site: it has no cookie, or a token
+	// it guessed or took from its own visit to the page.
+	page := ssoGet(r, "/v1/api/auth/oidc/verify?user_code="+url.QueryEscape(userCode))
+	token := csrfField.FindStringSubmatch(page.Body.String())[1]
+	form := url.Values{"user_code": {userCode}, "csrf": {token}}
+	assert.Equal(t, http.StatusForbidden, devicePost(r, form).Code)
+	other := &http.Cookie{Name: "nimbus_device_csrf", Value: strings.Repeat("0", 32)}
+	assert.Equal(t, http.StatusForbidden, devicePost(r, form, other).Code)
+
+	// The token only works for the code it was issued with.
+	second := startDeviceLogin(t, r)["user_code"].(string)
+	form.Set("user_code", second)
+	assert.Equal(t, http.StatusForbidden, devicePost(r, form, page.Result().Cookies()...).Code)
+
+	assert.Equal(t, http.StatusFound, deviceConfirm(t, r, userCode).Code)
+}
+
+func TestOIDC_DeviceFlowExpired(t *testing.T) {
+	db, r, _ := setupOIDCTest(t)
+
+	start := startDeviceLogin(t, r)
+	db.Model(&models.DeviceAuthorization{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Second))
+
+	_, body := pollDevice(r, start["device_code"].(string))
+	assert.Equal(t, "expired_token", body["error"])
+	w := ssoGet(r, "/v1/api/auth/oidc/verify?user_code="+url.QueryEscape(start["user_code"].(string)))
+	assert.Contains(t, w.Body.String(), "invalid or has expired")
+}
+
+func TestOIDC_DeviceFlowDenied(t *testing.T) {
+	_, r, idp := setupOIDCTest(t)
+	idp.Deny = true
+
+	start := startDeviceLogin(t, r)
+	w := deviceSignIn(t, r, start["user_code"].(string))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	_, body := pollDevice(r, start["device_code"].(string))
+	assert.Equal(t, "access_denied", body["error"])
+}
+
+func TestOIDC_DeviceTokenUnknownCode(t *testing.T) {
+	_, r, _ := setupOIDCTest(t)
+
+	w, body := pollDevice(r, "nim_dc_unknown")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_grant", body["error"])
+}
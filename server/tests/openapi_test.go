@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/openapi"
+	"github.com/nimbus/api/routes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// specServer is the spec's server URL; requests are addressed to it so the
+// spec's router can match them.
+const specServer = "http://localhost:8080"
+
+// loadSpec parses and validates openapi.yaml.
+func loadSpec(t *testing.T) *openapi3.T {
+	t.Helper()
+	doc, err := openapi3.NewLoader().LoadFromData(openapi.Spec)
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()), "openapi.yaml is not a valid OpenAPI 3 document")
+	return doc
+}
+
+// apiRouter serves every /v1/api route group the way InitServer does.
+func apiRouter(db *gorm.DB, config s3db.Config) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.InitFileRoutes(r, config, db)
+	routes.InitBoxRoutes(r, config, db)
+	routes.InitFolderRoutes(r, config, db)
+	routes.InitUserRoutes(r, config, db, ratelimit.New(100, time.Minute))
+	routes.InitAuditRoutes(r, db)
+	routes.InitAdminRoutes(r, config, db)
+	return r
+}
+
+// ginParam matches a gin path parameter, :name or *name.
+var ginParam = regexp.MustCompile(`[:*](\w+)`)
+
+func TestOpenAPI_SpecMatchesRoutes(t *testing.T) {
+	doc := loadSpec(t)
+	r := apiRouter(setupDavDB(t), s3db.Config{})
+
+	var served, documented []string
+	for _, route := range r.Routes() {
+		if strings.HasPrefix(route.Path, "/v1/api/") {
+			served = append(served, route.Method+" "+ginParam.ReplaceAllString(route.Path, "{$1}"))
+		}
+	}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	sort.Strings(served)
+	sort.Strings(documented)
+	assert.Equal(t, served, documented, "every /v1/api route is in openapi.yaml, and nothing else is")
+}
+
+// specClient makes requests against a router and fails the test when a
+// response's status or body isn't what the spec says the operation returns.
+type specClient struct {
+	t      *testing.T
+	r      *gin.Engine
+	router routers.Router
+	auth   string
+}
+
+func (c *specClient) do(method, path string, body any) (int, map[string]any) {
+	c.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(c.t, json.NewEncoder(&buf).Encode(body))
+	}
+	req := httptest.NewRequest(method, specServer+path, bytes.NewReader(buf.Bytes()))
+	req.Header.Set("Content-Type", "application/json")
+	if c.auth != "" {
+		req.Header.Set("Authorization", c.auth)
+	}
+	w := httptest.NewRecorder()
+	c.r.ServeHTTP(w, req)
+
+	route, pathParams, err := c.router.FindRoute(req)
+	require.NoError(c.t, err, "%s %s is not in openapi.yaml", method, path)
+	err = openapi3filter.ValidateResponse(context.Background(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request: req, PathParams: pathParams, Route: route,
+		},
+		Status:  w.Code,
+		Header:  w.Header(),
+		Body:    io.NopCloser(bytes.NewReader(w.Body.Bytes())),
+		Options: &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	assert.NoError(c.t, err, "%s %s answered %d %s", method, path, w.Code, w.Body.String())
+
+	var out map[string]any
+	_ = json.Unmarshal(w.Body.Bytes(), &out)
+	return w.Code, out
+}
+
+func TestOpenAPI_ResponsesMatchSpec(t *testing.T) {
+	doc := loadSpec(t)
+	specRouter, err := legacy.NewRouter(doc)
+	require.NoError(t, err)
+	db := setupDavDB(t)
+	require.NoError(t, db.AutoMigrate(&models.RecoveryCode{}, &models.LoginChallenge{}))
+	f, cfg := newFakeS3(t)
+	c := &specClient{t: t, r: apiRouter(db, cfg), router: specRouter}
+
+	// Sign up and in.
+	status, body := c.do("POST", "/v1/api/auth/users/register", map[string]string{"email": "spec@example.com", "password": "Correct-h0rse"})
+	require.Equal(t, http.StatusCreated, status, body)
+	status, body = c.do("POST", "/v1/api/auth/users/login", map[string]string{"email": "spec@example.com", "password": "wrong-password"})
+	require.Equal(t, http.StatusUnauthorized, status)
+	status, body = c.do("POST", "/v1/api/auth/users/login", map[string]string{"email": "spec@example.com", "password": "Correct-h0rse"})
+	require.Equal(t, http.StatusOK, status, body)
+	c.auth = "Bearer " + body["token"].(string)
+	c.do("POST", "/v1/api/auth/refresh", map[string]string{"refresh_token": body["refresh_token"].(string)})
+
+	// Boxes, folders and files.
+	c.do("POST", "/v1/api/boxes?box_name=Spec-Box", nil)
+	c.do("POST", "/v1/api/boxes?box_name=Spec-Box", nil)
+	c.do("GET", "/v1/api/boxes", nil)
+	c.do("POST", "/v1/api/folders?box_name=Spec-Box&folder_name=docs", nil)
+	c.do("POST", "/v1/api/folders?box_name=Spec-Box&folder_name=docs", nil)
+	c.do("POST", "/v1/api/folders?box_name=Spec-Box&folder_name=drafts&path=missing", nil)
+	c.do("GET", "/v1/api/folders?box_name=Spec-Box", nil)
+	c.do("GET", "/v1/api/folders?box_name=Spec-Box&path=missing", nil)
+
+	status, body = c.do("POST", "/v1/api/files/presign-upload?box_name=Spec-Box&filename=a.txt&filePath=docs&size=5", nil)
+	require.Equal(t, http.StatusOK, status, body)
+	put, err := http.NewRequest("PUT", body["upload_url"].(string), strings.NewReader("hello"))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(put)
+	require.NoError(t, err)
+	resp.Body.Close()
+	c.do("POST", fmt.Sprintf("/v1/api/files/%.0f/confirm", body["file_id"].(float64)), nil)
+	key := body["s3_key"].(string)
+	_, stored := f.object(key)
+	require.True(t, stored)
+
+	c.do("GET", "/v1/api/files?box_name=Spec-Box", nil)
+	c.do("PATCH", "/v1/api/files/move?box_name=Spec-Box&key="+url.QueryEscape(key)+"&target_path=docs", nil)
+	status, body = c.do("GET", "/v1/api/folders?box_name=Spec-Box", nil)
+	require.Equal(t, http.StatusOK, status, body)
+	assert.EqualValues(t, 5, body["folders"].([]any)[0].(map[string]any)["size"])
+	c.do("GET", "/v1/api/files/presign-download?box_name=Spec-Box&key=a.txt", nil)
+	c.do("GET", "/v1/api/files/presign-download?box_name=Spec-Box&key=missing.txt", nil)
+	c.do("GET", "/v1/api/folders/download?box_name=Spec-Box&folder_name=docs", nil)
+	c.do("PATCH", "/v1/api/files/rename?box_name=Spec-Box&key="+url.QueryEscape(key)+"&new_name=b.txt", nil)
+	c.do("PATCH", "/v1/api/folders/rename?box_name=Spec-Box&folder_name=docs&new_name=papers", nil)
+	c.do("POST", "/v1/api/folders/upload", nil)
+	c.do("PATCH", "/v1/api/boxes/rename?box_name=Spec-Box&new_name=Home-Box", nil)
+	c.do("PATCH", "/v1/api/boxes/rename?box_name=Home-Box&new_name=Spec-Home", nil)
+
+	// The account and its credentials.
+	c.do("GET", "/v1/api/auth/account", nil)
+	c.do("GET", "/v1/api/auth/sessions", nil)
+	c.do("GET", "/v1/api/auth/recovery-codes", nil)
+	status, body = c.do("POST", "/v1/api/auth/tokens", map[string]any{"name": "ci", "scopes": []string{"read"}})
+	require.Equal(t, http.StatusCreated, status, body)
+	c.do("GET", "/v1/api/auth/tokens", nil)
+	c.do("DELETE", fmt.Sprintf("/v1/api/auth/tokens/%.0f", body["id"].(float64)), nil)
+	c.do("POST", "/v1/api/auth/app-passwords", map[string]string{"name": "laptop"})
+	c.do("GET", "/v1/api/auth/app-passwords", nil)
+	c.do("POST", "/v1/api/auth/s3-keys", map[string]string{"name": "backup"})
+	c.do("GET", "/v1/api/auth/s3-keys", nil)
+	c.do("GET", "/v1/api/auth/ssh-keys", nil)
+	c.do("DELETE", "/v1/api/auth/ssh-keys/999", nil)
+	c.do("POST", "/v1/api/auth/totp", nil)
+	c.do("GET", "/v1/api/audit", nil)
+
+	// Administration.
+	c.do("GET", "/v1/api/admin/users", nil)
+	var u models.User
+	require.NoError(t, db.Where("email = ?", "spec@example.com").First(&u).Error)
+	require.NoError(t, db.Model(&u).Update("role", models.RoleAdmin).Error)
+	c.do("GET", "/v1/api/admin/users", nil)
+	c.do("GET", "/v1/api/admin/users/spec@example.com", nil)
+	c.do("GET", "/v1/api/admin/users/spec@example.com/boxes/Spec-Box", nil)
+	c.do("PUT", "/v1/api/admin/users/spec@example.com/quota", map[string]int64{"quota_bytes": 1 << 30})
+
+	// Tearing down.
+	c.do("DELETE", "/v1/api/files/no-such-key", nil)
+	c.do("DELETE", "/v1/api/folders?box_name=Spec-Box&folder_name=papers", nil)
+	c.do("DELETE", "/v1/api/boxes?box_name=Spec-Box", nil)
+	c.do("POST", "/v1/api/auth/users/logout", nil)
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryCodes_IssuedAtRegistration(t *testing.T) {
+	db := setupLoginDB(t)
+	r := sessionRouter(db)
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/users/register", "",
+		map[string]string{"email": "new@example.com", "password": "Test123!@#"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	codes, _ := body["recovery_codes"].([]any)
+	assert.Len(t, codes, user.RECOVERY_CODE_COUNT)
+
+	w, body = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "new@example.com", "password": "Test123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, body, "recovery_codes", "the account already has its codes")
+	auth := "Bearer " + body["token"].(string)
+
+	w, body = appPasswordRequest(r, "GET", "/v1/api/auth/recovery-codes", auth, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(user.RECOVERY_CODE_COUNT), body["remaining"])
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/users/reset-password", "",
+		map[string]string{"email": "new@example.com", "recovery_code": codes[0].(string), "new_password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRecoveryCodes_IssuedAtFirstLoginWithoutAny(t *testing.T) {
+	db, r := setupSessionTest(t)
+
+	// The seeded account predates recovery codes, like one whose passkey
+	// was retired; its first login hands it a set, once.
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Test123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	codes, _ := body["recovery_codes"].([]any)
+	assert.Len(t, codes, user.RECOVERY_CODE_COUNT)
+
+	_, body = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Test123!@#"})
+	assert.NotContains(t, body, "recovery_codes")
+
+	// Having spent every code isn't the same as never having had any.
+	db.Model(&models.RecoveryCode{}).Where("used_at IS NULL").Update("used_at", time.Now())
+	_, body = appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Test123!@#"})
+	assert.NotContains(t, body, "recovery_codes")
+}
+
+func TestRecoveryCodes_RegenerateReplacesSet(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/recovery-codes", auth, map[string]string{"password": "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/recovery-codes", auth, map[string]string{"password": "Test123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	first, _ := body["recovery_codes"].([]any)
+	assert.Len(t, first, user.RECOVERY_CODE_COUNT)
+
+	_, body = appPasswordRequest(r, "POST", "/v1/api/auth/recovery-codes", auth, map[string]string{"password": "Test123!@#"})
+	second, _ := body["recovery_codes"].([]any)
+	assert.NotEqual(t, first, second)
+
+	var n int64
+	db.Model(&models.RecoveryCode{}).Count(&n)
+	assert.Equal(t, int64(user.RECOVERY_CODE_COUNT), n, "the earlier set is gone")
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/users/reset-password", "",
+		map[string]string{"email": "session@example.com", "recovery_code": first[0].(string), "new_password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRecoveryCodes_RegenerateNeedsTOTPWhenEnabled(t *testing.T) {
+	_, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	secret := enableTOTP(t, r, auth)
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/recovery-codes", auth, map[string]string{"password": "Test123!@#"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/recovery-codes", auth,
+		map[string]string{"password": "Test123!@#", "code": totpCode(t, secret, 1)})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func refreshRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/login", func(c *gin.Context) { user.Login(c, db) })
+	r.POST("/refresh", func(c *gin.Context) { user.Refresh(c, db) })
+	return r
+}
+
+// loginForTokens logs the seeded user in and returns the access and refresh tokens.
+func loginForTokens(t *testing.T, r *gin.Engine) (string, string) {
+	t.Helper()
+	w, body := appPasswordRequest(r, "POST", "/login", "", map[string]string{"email": "refresh@example.com", "password": "Test123!@#"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	return body["token"].(string), body["refresh_token"].(string)
+}
+
+func refresh(r *gin.Engine, token string) (int, map[string]any) {
+	w, body := appPasswordRequest(r, "POST", "/refresh", "", map[string]string{"refresh_token": token})
+	return w.Code, body
+}
+
+func TestRefresh_LoginIssuesShortLivedPair(t *testing.T) {
+	db := setupLoginDB(t)
+	seedLoginUser(t, db, "refresh@example.com", "Test123!@#")
+	r := refreshRouter(db)
+
+	w, body := appPasswordRequest(r, "POST", "/login", "", map[string]string{"email": "refresh@example.com", "password": "Test123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(jwt.ACCESS_TOKEN_TTL.Seconds()), body["expires_in"])
+
+	rt, _ := body["refresh_token"].(string)
+	assert.Contains(t, rt, utils.RefreshTokenPrefix)
+
+	var stored models.RefreshToken
+	assert.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, utils.HashToken(rt), stored.Hash)
+	assert.WithinDuration(t, time.Now().Add(user.REFRESH_TOKEN_TTL), stored.ExpiresAt, time.Minute)
+}
+
+func TestRefresh_RotatesToken(t *testing.T) {
+	db := setupLoginDB(t)
+	seedLoginUser(t, db, "refresh@example.com", "Test123!@#")
+	r := refreshRouter(db)
+	_, rt := loginForTokens(t, r)
+
+	code, body := refresh(r, rt)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, jwt.VerifyToken(body["token"].(string)))
+
+	next := body["refresh_token"].(string)
+	assert.NotEqual(t, rt, next)
+
+	code, _ = refresh(r, next)
+	assert.Equal(t, http.StatusOK, code)
+
+	var tokens []models.RefreshToken
+	db.Order("id").Find(&tokens)
+	if assert.Len(t, tokens, 3) {
+		assert.Equal(t, tokens[0].SessionID, tokens[2].SessionID)
+		assert.NotNil(t, tokens[0].UsedAt)
+		assert.Nil(t, tokens[2].UsedAt)
+	}
+}
+
+func TestRefresh_ReuseRevokesSession(t *testing.T) {
+	db := setupLoginDB(t)
+	seedLoginUser(t, db, "refresh@example.com", "Test123!@#")
+	r := refreshRouter(db)
+	_, stolen := loginForTokens(t, r)
+	_, other := loginForTokens(t, r)
+
+	code, body := refresh(r, stolen)
+	assert.Equal(t, http.StatusOK, code)
+	current := body["refresh_token"].(string)
+
+	// The old token comes back: whoever holds it isn't the legitimate client.
+	code, body = refresh(r, stolen)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "refresh token already used, please log in again", body["error"])
+
+	code, _ = refresh(r, current)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// A separate login is a different session and keeps working.
+	code, _ = refresh(r, other)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestRefresh_RejectsExpiredAndUnknown(t *testing.T) {
+	db := setupLoginDB(t)
+	seedLoginUser(t, db, "refresh@example.com", "Test123!@#")
+	r := refreshRouter(db)
+	_, rt := loginForTokens(t, r)
+	db.Model(&models.RefreshToken{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Minute))
+
+	code, body := refresh(r, rt)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "refresh token expired, please log in again", body["error"])
+
+	code, _ = refresh(r, utils.RefreshTokenPrefix+"nope")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = refresh(r, "")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,634 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/storage"
+	"github.com/nimbus/api/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// --- helpers ---
+
+// startS3Gateway serves the gateway for db on a test server backed by config
+// and returns its URL.
+func startS3Gateway(t *testing.T, db *gorm.DB, config s3db.Config) string {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.InitS3GatewayRoutes(r, config, db)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// createS3Key issues an access key for u directly in the database.
+func createS3Key(t *testing.T, db *gorm.DB, u *models.User) aws.Credentials {
+	t.Helper()
+	id, secret, err := utils.GenerateS3AccessKey()
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&models.S3AccessKey{UserID: u.ID, Name: "test", AccessKeyID: id, SecretKey: secret}).Error)
+	return aws.Credentials{AccessKeyID: id, SecretAccessKey: secret}
+}
+
+// gatewayClient returns an SDK client that talks to the gateway at url.
+func gatewayClient(url string, creds aws.Credentials) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(url),
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, ""),
+	})
+}
+
+// s3Fixture is a user with one box ("Test-Box"), a fake backing bucket and a
+// gateway client signed with the user's access key.
+type s3Fixture struct {
+	db     *gorm.DB
+	fake   *fakeS3
+	user   *models.User
+	creds  aws.Credentials
+	url    string
+	client *s3.Client
+}
+
+func newS3Fixture(t *testing.T) *s3Fixture {
+	t.Helper()
+	db := setupDavDB(t)
+	fake, config := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box")
+	creds := createS3Key(t, db, u)
+	url := startS3Gateway(t, db, config)
+	return &s3Fixture{db: db, fake: fake, user: u, creds: creds, url: url, client: gatewayClient(url, creds)}
+}
+
+func (f *s3Fixture) put(t *testing.T, key, body string) {
+	t.Helper()
+	_, err := f.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(body),
+	})
+	require.NoError(t, err)
+}
+
+func (f *s3Fixture) get(t *testing.T, key string) string {
+	t.Helper()
+	out, err := f.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String(key),
+	})
+	require.NoError(t, err)
+	defer func() { _ = out.Body.Close() }()
+	data, err := io.ReadAll(out.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// apiErrorCode returns the S3 error code carried by err, or "".
+func apiErrorCode(err error) string {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorCode()
+	}
+	return ""
+}
+
+// --- authentication ---
+
+func TestS3Gateway_RejectsAnonymousRequests(t *testing.T) {
+	f := newS3Fixture(t)
+	resp, err := http.Get(f.url + "/Test-Box/")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(body), "<Code>AccessDenied</Code>")
+}
+
+func TestS3Gateway_RejectsWrongSecret(t *testing.T) {
+	f := newS3Fixture(t)
+	bad := gatewayClient(f.url, aws.Credentials{AccessKeyID: f.creds.AccessKeyID, SecretAccessKey: "wrong"})
+	_, err := bad.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+	assert.Equal(t, "SignatureDoesNotMatch", apiErrorCode(err))
+}
+
+func TestS3Gateway_RejectsUnknownKey(t *testing.T) {
+	f := newS3Fixture(t)
+	bad := gatewayClient(f.url, aws.Credentials{AccessKeyID: "NIMUNKNOWNKEY000000", SecretAccessKey: "x"})
+	_, err := bad.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+	assert.Equal(t, "InvalidAccessKeyId", apiErrorCode(err))
+}
+
+func TestS3Gateway_RevokedKeyStopsWorking(t *testing.T) {
+	f := newS3Fixture(t)
+	_, err := f.client.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+	require.NoError(t, err)
+
+	f.db.Unscoped().Where("access_key_id = ?", f.creds.AccessKeyID).Delete(&models.S3AccessKey{})
+	_, err = f.client.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+	assert.Equal(t, "InvalidAccessKeyId", apiErrorCode(err))
+}
+
+func TestS3Gateway_PresignedGet(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "notes.txt", "presigned body")
+
+	presigned, err := s3.NewPresignClient(f.client).PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String("notes.txt"),
+	}, s3.WithPresignExpires(time.Minute))
+	require.NoError(t, err)
+
+	resp, err := http.Get(presigned.URL)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "presigned body", string(body))
+
+	// Tampering with the key invalidates the signature.
+	resp2, err := http.Get(strings.Replace(presigned.URL, "notes.txt", "other.txt", 1))
+	require.NoError(t, err)
+	defer func() { _ = resp2.Body.Close() }()
+	assert.Equal(t, http.StatusForbidden, resp2.StatusCode)
+}
+
+// --- buckets ---
+
+func TestS3Gateway_ListBucketsShowsOnlyOwnBoxes(t *testing.T) {
+	f := newS3Fixture(t)
+	createDavUser(t, f.db, "Someone-Elses-Box")
+
+	out, err := f.client.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+	require.NoError(t, err)
+	var names []string
+	for _, b := range out.Buckets {
+		names = append(names, aws.ToString(b.Name))
+	}
+	assert.Equal(t, []string{"Test-Box"}, names)
+
+	_, err = f.client.HeadBucket(context.Background(), &s3.HeadBucketInput{Bucket: aws.String("Someone-Elses-Box")})
+	assert.Error(t, err)
+	_, err = f.client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String("Someone-Elses-Box"), Key: aws.String("x")})
+	assert.Equal(t, "NoSuchBucket", apiErrorCode(err))
+}
+
+// --- objects ---
+
+func TestS3Gateway_PutGetHeadDelete(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "docs/2024/report.txt", "hello gateway")
+
+	// Parent folders are created implicitly and the file is a normal record.
+	var file models.File
+	require.NoError(t, f.db.Where("name = ?", "report.txt").First(&file).Error)
+	assert.True(t, file.Confirmed)
+	assert.Equal(t, int64(13), file.Size)
+	var folders int64
+	f.db.Model(&models.Folder{}).Count(&folders)
+	assert.Equal(t, int64(2), folders)
+	var box models.Box
+	f.db.Where("name = ?", "Test-Box").First(&box)
+	assert.Equal(t, int64(13), box.Size)
+
+	assert.Equal(t, "hello gateway", f.get(t, "docs/2024/report.txt"))
+
+	head, err := f.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String("docs/2024/report.txt"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(13), aws.ToInt64(head.ContentLength))
+	assert.Equal(t, "text/plain; charset=utf-8", aws.ToString(head.ContentType))
+	assert.Equal(t, storage.ETag(&file), aws.ToString(head.ETag))
+
+	_, err = f.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String("docs/2024/report.txt"),
+	})
+	require.NoError(t, err)
+	_, err = f.client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String("Test-Box"), Key: aws.String("docs/2024/report.txt")})
+	assert.Equal(t, "NoSuchKey", apiErrorCode(err))
+	_, stored := f.fake.object(file.S3Key)
+	assert.False(t, stored, "S3 object should be deleted")
+	f.db.Where("name = ?", "Test-Box").First(&box)
+	assert.Equal(t, int64(0), box.Size)
+}
+
+func TestS3Gateway_GetRange(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "alphabet.txt", "abcdefghij")
+
+	out, err := f.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String("alphabet.txt"),
+		Range:  aws.String("bytes=2-4"),
+	})
+	require.NoError(t, err)
+	defer func() { _ = out.Body.Close() }()
+	data, _ := io.ReadAll(out.Body)
+	assert.Equal(t, "cde", string(data))
+	assert.Equal(t, "bytes 2-4/10", aws.ToString(out.ContentRange))
+}
+
+func TestS3Gateway_PutOverwriteReplacesFile(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "a.txt", "first")
+	f.put(t, "a.txt", "second!")
+
+	assert.Equal(t, "second!", f.get(t, "a.txt"))
+	var count int64
+	f.db.Model(&models.File{}).Where("name = ?", "a.txt").Count(&count)
+	assert.Equal(t, int64(1), count)
+	var box models.Box
+	f.db.Where("name = ?", "Test-Box").First(&box)
+	assert.Equal(t, int64(7), box.Size)
+}
+
+func TestS3Gateway_PutTooLarge(t *testing.T) {
+	f := newS3Fixture(t)
+	// The declared size alone is enough to refuse the upload before any of
+	// the body is read.
+	req, _ := http.NewRequest(http.MethodPut, f.url+"/Test-Box/big.bin", strings.NewReader("0\r\n\r\n"))
+	req.Header.Set("Content-Encoding", "aws-chunked")
+	req.Header.Set("X-Amz-Decoded-Content-Length", fmt.Sprint(storage.MaxFileSize+1))
+	req.Header.Set("X-Amz-Content-Sha256", "STREAMING-UNSIGNED-PAYLOAD-TRAILER")
+	require.NoError(t, v4.NewSigner().SignHTTP(context.Background(), f.creds, req, "STREAMING-UNSIGNED-PAYLOAD-TRAILER", "s3", "us-east-1", time.Now()))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), "EntityTooLarge")
+
+	var count int64
+	f.db.Model(&models.File{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestS3Gateway_RejectsPayloadHashMismatch(t *testing.T) {
+	f := newS3Fixture(t)
+	body := "actual body"
+	req, _ := http.NewRequest(http.MethodPut, f.url+"/Test-Box/x.txt", strings.NewReader(body))
+	claimed := hex.EncodeToString(make([]byte, 32))
+	req.Header.Set("X-Amz-Content-Sha256", claimed)
+	require.NoError(t, v4.NewSigner().SignHTTP(context.Background(), f.creds, req, claimed, "s3", "us-east-1", time.Now()))
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	out, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(out), "XAmzContentSHA256Mismatch")
+	var count int64
+	f.db.Model(&models.File{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+// signedChunkedPut builds a PUT with a STREAMING-AWS4-HMAC-SHA256-PAYLOAD
+// body: each chunk is signed with the SDK's stream signer, which computes the
+// same chained signature aws-chunked uploads use.
+func signedChunkedPut(t *testing.T, url string, creds aws.Credentials, chunks [][]byte, tamper bool) *http.Request {
+	t.Helper()
+	var total int
+	for _, c := range chunks {
+		total += len(c)
+	}
+	now := time.Now().UTC()
+	req, _ := http.NewRequest(http.MethodPut, url, nil)
+	req.Header.Set("Content-Encoding", "aws-chunked")
+	req.Header.Set("X-Amz-Decoded-Content-Length", fmt.Sprint(total))
+	req.Header.Set("X-Amz-Content-Sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+	require.NoError(t, v4.NewSigner().SignHTTP(context.Background(), creds, req, "STREAMING-AWS4-HMAC-SHA256-PAYLOAD", "s3", "us-east-1", now))
+
+	auth := req.Header.Get("Authorization")
+	seed, _ := hex.DecodeString(auth[strings.LastIndex(auth, "=")+1:])
+	signer := v4.NewStreamSigner(creds, "s3", "us-east-1", seed)
+
+	var body bytes.Buffer
+	for _, c := range append(chunks, nil) {
+		sig, err := signer.GetSignature(context.Background(), nil, c, now)
+		require.NoError(t, err)
+		data := c
+		if tamper && len(c) > 0 {
+			data = bytes.ToUpper(c)
+		}
+		fmt.Fprintf(&body, "%x;chunk-signature=%x\r\n%s\r\n", len(c), sig, data)
+	}
+	req.Body = io.NopCloser(&body)
+	req.ContentLength = int64(body.Len())
+	return req
+}
+
+func TestS3Gateway_StreamingSignedUpload(t *testing.T) {
+	f := newS3Fixture(t)
+	req := signedChunkedPut(t, f.url+"/Test-Box/streamed.txt", f.creds, [][]byte{[]byte("hello "), []byte("chunked world")}, false)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	out, _ := io.ReadAll(resp.Body)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(out))
+
+	assert.Equal(t, "hello chunked world", f.get(t, "streamed.txt"))
+}
+
+func TestS3Gateway_StreamingRejectsTamperedChunk(t *testing.T) {
+	f := newS3Fixture(t)
+	req := signedChunkedPut(t, f.url+"/Test-Box/streamed.txt", f.creds, [][]byte{[]byte("hello "), []byte("chunked world")}, true)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	out, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(out), "SignatureDoesNotMatch")
+
+	var count int64
+	f.db.Model(&models.File{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestS3Gateway_FolderMarkers(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "empty/", "")
+
+	var folder models.Folder
+	require.NoError(t, f.db.Where("name = ?", "empty").First(&folder).Error)
+
+	_, err := f.client.HeadObject(context.Background(), &s3.HeadObjectInput{Bucket: aws.String("Test-Box"), Key: aws.String("empty/")})
+	assert.NoError(t, err)
+
+	// Deleting the marker of an empty folder removes it.
+	_, err = f.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: aws.String("Test-Box"), Key: aws.String("empty/")})
+	require.NoError(t, err)
+	var count int64
+	f.db.Model(&models.Folder{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestS3Gateway_DeleteMarkerKeepsNonEmptyFolder(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "keep/file.txt", "data")
+
+	_, err := f.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: aws.String("Test-Box"), Key: aws.String("keep/")})
+	require.NoError(t, err)
+	assert.Equal(t, "data", f.get(t, "keep/file.txt"))
+}
+
+func TestS3Gateway_RejectsAmbiguousKeys(t *testing.T) {
+	f := newS3Fixture(t)
+	for _, key := range []string{"a//b.txt", "a/../b.txt", "./b.txt"} {
+		_, err := f.client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String("Test-Box"),
+			Key:    aws.String(key),
+			Body:   strings.NewReader("x"),
+		})
+		assert.Equal(t, "InvalidArgument", apiErrorCode(err), key)
+	}
+}
+
+// --- listing ---
+
+func TestS3Gateway_ListObjectsV2(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "a.txt", "1")
+	f.put(t, "dir/b.txt", "22")
+	f.put(t, "dir/sub/c.txt", "333")
+	f.put(t, "empty/", "")
+
+	out, err := f.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("Test-Box")})
+	require.NoError(t, err)
+	var keys []string
+	for _, o := range out.Contents {
+		keys = append(keys, aws.ToString(o.Key))
+	}
+	assert.Equal(t, []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"}, keys)
+
+	out, err = f.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String("Test-Box"),
+		Delimiter: aws.String("/"),
+	})
+	require.NoError(t, err)
+	keys = nil
+	for _, o := range out.Contents {
+		keys = append(keys, aws.ToString(o.Key))
+	}
+	var prefixes []string
+	for _, p := range out.CommonPrefixes {
+		prefixes = append(prefixes, aws.ToString(p.Prefix))
+	}
+	assert.Equal(t, []string{"a.txt"}, keys)
+	assert.Equal(t, []string{"dir/", "empty/"}, prefixes)
+
+	out, err = f.client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String("Test-Box"),
+		Prefix:    aws.String("dir/"),
+		Delimiter: aws.String("/"),
+	})
+	require.NoError(t, err)
+	require.Len(t, out.Contents, 1)
+	assert.Equal(t, "dir/b.txt", aws.ToString(out.Contents[0].Key))
+	assert.Equal(t, int64(2), aws.ToInt64(out.Contents[0].Size))
+	require.Len(t, out.CommonPrefixes, 1)
+	assert.Equal(t, "dir/sub/", aws.ToString(out.CommonPrefixes[0].Prefix))
+}
+
+func TestS3Gateway_ListObjectsV2Pagination(t *testing.T) {
+	f := newS3Fixture(t)
+	for _, k := range []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt"} {
+		f.put(t, k, k)
+	}
+
+	var keys []string
+	pages := 0
+	p := s3.NewListObjectsV2Paginator(f.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String("Test-Box"),
+		MaxKeys: aws.Int32(2),
+	})
+	for p.HasMorePages() {
+		out, err := p.NextPage(context.Background())
+		require.NoError(t, err)
+		pages++
+		for _, o := range out.Contents {
+			keys = append(keys, aws.ToString(o.Key))
+		}
+	}
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt"}, keys)
+}
+
+func TestS3Gateway_DeleteObjects(t *testing.T) {
+	f := newS3Fixture(t)
+	f.put(t, "x.txt", "x")
+	f.put(t, "y/z.txt", "z")
+
+	out, err := f.client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+		Bucket: aws.String("Test-Box"),
+		Delete: &types.Delete{Objects: []types.ObjectIdentifier{
+			{Key: aws.String("x.txt")},
+			{Key: aws.String("y/z.txt")},
+			{Key: aws.String("missing.txt")},
+		}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, out.Deleted, 3)
+	assert.Empty(t, out.Errors)
+
+	var count int64
+	f.db.Model(&models.File{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+// --- multipart ---
+
+func TestS3Gateway_MultipartUpload(t *testing.T) {
+	f := newS3Fixture(t)
+	ctx := context.Background()
+	created, err := f.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String("backups/archive.tar"),
+	})
+	require.NoError(t, err)
+
+	part1 := bytes.Repeat([]byte("a"), 5*1024*1024)
+	part2 := []byte("tail")
+	var completed []types.CompletedPart
+	for i, data := range [][]byte{part1, part2} {
+		out, err := f.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String("Test-Box"),
+			Key:        aws.String("backups/archive.tar"),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			Body:       bytes.NewReader(data),
+		})
+		require.NoError(t, err)
+		completed = append(completed, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(int32(i + 1))})
+	}
+
+	// Nothing is visible until the upload completes.
+	var count int64
+	f.db.Model(&models.File{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+
+	_, err = f.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("Test-Box"),
+		Key:             aws.String("backups/archive.tar"),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	require.NoError(t, err)
+
+	var file models.File
+	require.NoError(t, f.db.Where("name = ?", "archive.tar").First(&file).Error)
+	assert.Equal(t, int64(len(part1)+len(part2)), file.Size)
+	data, _ := f.fake.object(file.S3Key)
+	assert.Equal(t, append(append([]byte{}, part1...), part2...), data)
+	f.db.Model(&models.S3Upload{}).Count(&count)
+	assert.Equal(t, int64(0), count, "upload record should be cleared")
+}
+
+func TestS3Gateway_AbortMultipartUpload(t *testing.T) {
+	f := newS3Fixture(t)
+	ctx := context.Background()
+	created, err := f.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String("abandoned.bin"),
+	})
+	require.NoError(t, err)
+
+	_, err = f.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String("Test-Box"),
+		Key:      aws.String("abandoned.bin"),
+		UploadId: created.UploadId,
+	})
+	require.NoError(t, err)
+
+	_, err = f.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String("Test-Box"),
+		Key:        aws.String("abandoned.bin"),
+		UploadId:   created.UploadId,
+		PartNumber: aws.Int32(1),
+		Body:       strings.NewReader("late"),
+	})
+	assert.Equal(t, "NoSuchUpload", apiErrorCode(err))
+}
+
+func TestS3Gateway_CannotUseOtherUsersUpload(t *testing.T) {
+	f := newS3Fixture(t)
+	ctx := context.Background()
+	created, err := f.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("Test-Box"),
+		Key:    aws.String("mine.bin"),
+	})
+	require.NoError(t, err)
+
+	other := createDavUser(t, f.db, "Test-Box")
+	otherClient := gatewayClient(f.url, createS3Key(t, f.db, other))
+	_, err = otherClient.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String("Test-Box"),
+		Key:      aws.String("mine.bin"),
+		UploadId: created.UploadId,
+	})
+	assert.Equal(t, "NoSuchUpload", apiErrorCode(err))
+}
+
+// --- access key management ---
+
+func TestS3Keys_CreateListRevoke(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(jwt.Authenticate(db))
+	r.GET("/s3-keys", func(c *gin.Context) { user.ListS3Keys(c, db) })
+	r.POST("/s3-keys", func(c *gin.Context) { user.CreateS3Key(c, db) })
+	r.DELETE("/s3-keys/:id", func(c *gin.Context) { user.RevokeS3Key(c, db) })
+
+	w, created := appPasswordRequest(r, "POST", "/s3-keys", authHeader(t, u), map[string]string{"name": "restic"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	secret, _ := created["secret_access_key"].(string)
+	keyID, _ := created["access_key_id"].(string)
+	assert.Len(t, secret, 40)
+	assert.True(t, strings.HasPrefix(keyID, "NIM"))
+
+	w, _ = appPasswordRequest(r, "GET", "/s3-keys", authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), keyID)
+	assert.NotContains(t, w.Body.String(), secret)
+
+	other := createDavUser(t, db, "Other-Box")
+	id := fmt.Sprintf("%.0f", created["id"].(float64))
+	w, _ = appPasswordRequest(r, "DELETE", "/s3-keys/"+id, authHeader(t, other), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = appPasswordRequest(r, "DELETE", "/s3-keys/"+id, authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var count int64
+	db.Unscoped().Model(&models.S3AccessKey{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
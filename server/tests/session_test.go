@@ -0,0 +1,184 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// sessionRouter wires the real auth routes plus the file list, which is used
+// to check whether an access token still works.
+func sessionRouter(db *gorm.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.InitUserRoutes(r, s3db.Config{}, db, ratelimit.New(100, time.Minute))
+	routes.InitFileRoutes(r, s3db.Config{}, db)
+	return r
+}
+
+// sessionLogin logs the seeded user in as device and returns the
+// Authorization header and refresh token.
+func sessionLogin(t *testing.T, r *gin.Engine, device string) (string, string) {
+	t.Helper()
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Test123!@#", "device": device})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	return "Bearer " + body["token"].(string), body["refresh_token"].(string)
+}
+
+// tokenWorks reports whether auth is accepted by an ordinary endpoint.
+func tokenWorks(r *gin.Engine, auth string) bool {
+	w, _ := appPasswordRequest(r, "GET", "/v1/api/files?box_name=Home-Box", auth, nil)
+	return w.Code == http.StatusOK
+}
+
+func setupSessionTest(t *testing.T) (*gorm.DB, *gin.Engine) {
+	t.Helper()
+	db := setupLoginDB(t)
+	seedLoginUser(t, db, "session@example.com", "Test123!@#")
+	return db, sessionRouter(db)
+}
+
+func TestSession_LoginRecordsSession(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	sessionLogin(t, r, "ci-runner")
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/auth/sessions", auth, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	list, _ := body["sessions"].([]any)
+	if assert.Len(t, list, 2) {
+		devices := map[string]bool{}
+		for _, item := range list {
+			s := item.(map[string]any)
+			devices[s["device"].(string)] = s["current"].(bool)
+			assert.NotEmpty(t, s["ip"])
+		}
+		assert.Equal(t, map[string]bool{"laptop": true, "ci-runner": false}, devices)
+	}
+
+	var stored models.Session
+	assert.NoError(t, db.Where("device = ?", "laptop").First(&stored).Error)
+	assert.Len(t, stored.SessionID, 32)
+}
+
+func TestSession_LogoutRevokesTokens(t *testing.T) {
+	_, r := setupSessionTest(t)
+	auth, rt := sessionLogin(t, r, "laptop")
+	other, _ := sessionLogin(t, r, "desktop")
+	assert.True(t, tokenWorks(r, auth))
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/users/logout", auth, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, body := appPasswordRequest(r, "GET", "/v1/api/files?box_name=Home-Box", auth, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "session revoked, please log in again", body["error"])
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/refresh", "", map[string]string{"refresh_token": rt})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	assert.True(t, tokenWorks(r, other), "other sessions are unaffected")
+}
+
+func TestSession_RevokeOne(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	stolen, _ := sessionLogin(t, r, "unknown")
+
+	var s models.Session
+	db.Where("device = ?", "unknown").First(&s)
+	w, _ := appPasswordRequest(r, "DELETE", "/v1/api/auth/sessions/"+s.SessionID, auth, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.False(t, tokenWorks(r, stolen))
+	assert.True(t, tokenWorks(r, auth))
+
+	w, _ = appPasswordRequest(r, "DELETE", "/v1/api/auth/sessions/"+s.SessionID, auth, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	_, body := appPasswordRequest(r, "GET", "/v1/api/auth/sessions", auth, nil)
+	assert.Len(t, body["sessions"], 1)
+}
+
+func TestSession_RevokeAll(t *testing.T) {
+	_, r := setupSessionTest(t)
+	a, _ := sessionLogin(t, r, "laptop")
+	b, _ := sessionLogin(t, r, "desktop")
+
+	w, body := appPasswordRequest(r, "DELETE", "/v1/api/auth/sessions", a, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), body["revoked"])
+
+	assert.False(t, tokenWorks(r, a))
+	assert.False(t, tokenWorks(r, b))
+}
+
+func TestSession_PasswordResetRevokesAll(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, rt := sessionLogin(t, r, "laptop")
+	var u models.User
+	db.Where("email = ?", "session@example.com").First(&u)
+	codes := seedRecoveryCodes(t, db, u.ID)
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/users/reset-password", "",
+		map[string]string{"email": "session@example.com", "recovery_code": codes[0], "new_password": "Newpass123!@#"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.False(t, tokenWorks(r, auth))
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/refresh", "", map[string]string{"refresh_token": rt})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestSession_RevokedJWTRejectedByBasicAuth(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	token := auth[len("Bearer "):]
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("session@example.com", token)
+	_, err := jwt.AuthenticateBasic(req, db)
+	assert.NoError(t, err)
+
+	appPasswordRequest(r, "POST", "/v1/api/auth/users/logout", auth, nil)
+	_, err = jwt.AuthenticateBasic(req, db)
+	assert.ErrorIs(t, err, jwt.ErrInvalidCredentials)
+}
+
+func TestSession_RevocationListInRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	jwt.UseRevocationStore(rdb)
+	t.Cleanup(func() { jwt.UseRevocationStore(nil) })
+
+	db, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	var s models.Session
+	db.First(&s)
+
+	appPasswordRequest(r, "POST", "/v1/api/auth/users/logout", auth, nil)
+	assert.True(t, mr.Exists("nimbus:revoked-session:"+s.SessionID))
+	assert.Equal(t, jwt.ACCESS_TOKEN_TTL, mr.TTL("nimbus:revoked-session:"+s.SessionID))
+
+	// With Redis answering, the database isn't consulted.
+	db.Model(&s).Update("revoked_at", nil)
+	assert.True(t, jwt.IsSessionRevoked(context.Background(), db, s.SessionID))
+
+	// If Redis goes away the database is used instead.
+	mr.Close()
+	assert.False(t, jwt.IsSessionRevoked(context.Background(), db, s.SessionID))
+}
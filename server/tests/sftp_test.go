@@ -0,0 +1,415 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/handlers/sftpd"
+	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/middleware/audit"
+	"github.com/nimbus/api/middleware/jwt"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/utils"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+// --- helpers ---
+
+// sftpFixture is an SFTP server on a loopback port backed by a fake bucket,
+// with one user ("Test-Box" and "Archive") whose account password is
+// "correct-horse".
+type sftpFixture struct {
+	db      *gorm.DB
+	fake    *fakeS3
+	user    *models.User
+	addr    string
+	hostKey ssh.PublicKey
+}
+
+const sftpTestPassword = "correct-horse"
+
+func newSFTPFixture(t *testing.T) *sftpFixture {
+	t.Helper()
+	// Generous enough that tests logging in repeatedly aren't throttled.
+	return newLimitedSFTPFixture(t, ratelimit.New(100, time.Minute))
+}
+
+// newLimitedSFTPFixture is newSFTPFixture with password attempts throttled by
+// limiter.
+func newLimitedSFTPFixture(t *testing.T, limiter *ratelimit.Limiter) *sftpFixture {
+	t.Helper()
+	db := setupDavDB(t)
+	fake, config := newFakeS3(t)
+	u := createDavUser(t, db, "Test-Box", "Archive")
+	// MinCost keeps the test fast; VerifyPasswordHash reads the cost from the hash.
+	hash, err := bcrypt.GenerateFromPassword([]byte(sftpTestPassword), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, db.Model(u).Update("password", string(hash)).Error)
+
+	hostKey, err := sftpd.GenerateHostKey()
+	require.NoError(t, err)
+	srv := sftpd.New(config, db, limiter, hostKey)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return &sftpFixture{db: db, fake: fake, user: u, addr: ln.Addr().String(), hostKey: hostKey.PublicKey()}
+}
+
+// dial opens an SSH connection as email with the given auth method.
+func (f *sftpFixture) dial(email string, auth ssh.AuthMethod) (*ssh.Client, error) {
+	return ssh.Dial("tcp", f.addr, &ssh.ClientConfig{
+		User:            email,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.FixedHostKey(f.hostKey),
+	})
+}
+
+// client returns an SFTP client logged in as the fixture's user.
+func (f *sftpFixture) client(t *testing.T) *sftp.Client {
+	t.Helper()
+	conn, err := f.dial(f.user.Email, ssh.Password(sftpTestPassword))
+	require.NoError(t, err)
+	c, err := sftp.NewClient(conn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.Close()
+		_ = conn.Close()
+	})
+	return c
+}
+
+func sftpWrite(t *testing.T, c *sftp.Client, p string, data []byte) {
+	t.Helper()
+	f, err := c.Create(p)
+	require.NoError(t, err)
+	_, err = f.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func sftpRead(t *testing.T, c *sftp.Client, p string) []byte {
+	t.Helper()
+	f, err := c.Open(p)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func newSSHKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer
+}
+
+// --- authentication ---
+
+func TestSFTP_AccountPassword(t *testing.T) {
+	f := newSFTPFixture(t)
+
+	conn, err := f.dial(f.user.Email, ssh.Password(sftpTestPassword))
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	_, err = f.dial(f.user.Email, ssh.Password("wrong-password"))
+	assert.Error(t, err)
+	_, err = f.dial("nobody@example.com", ssh.Password(sftpTestPassword))
+	assert.Error(t, err)
+}
+
+func TestSFTP_PasswordAttemptsRateLimited(t *testing.T) {
+	f := newLimitedSFTPFixture(t, ratelimit.New(2, time.Minute))
+
+	for i := 0; i < 2; i++ {
+		_, err := f.dial(f.user.Email, ssh.Password("wrong-password"))
+		require.Error(t, err)
+	}
+	// Over the limit even the right password is refused, before it is checked.
+	_, err := f.dial(f.user.Email, ssh.Password(sftpTestPassword))
+	assert.Error(t, err)
+
+	var denied []models.AuditEvent
+	require.NoError(t, f.db.Where("protocol = ? AND detail = ?", audit.ProtocolSFTP, "password (rate limited)").Find(&denied).Error)
+	assert.Len(t, denied, 1)
+
+	// Keys aren't throttled: they cost no password hash.
+	key := newSSHKey(t)
+	require.NoError(t, f.db.Create(&models.SSHKey{
+		UserID:      f.user.ID,
+		Name:        "partner",
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.PublicKey()))),
+		Fingerprint: ssh.FingerprintSHA256(key.PublicKey()),
+	}).Error)
+	conn, err := f.dial(f.user.Email, ssh.PublicKeys(key))
+	require.NoError(t, err)
+	_ = conn.Close()
+}
+
+func TestSFTP_TOTPAccountNeedsAppPasswordOrKey(t *testing.T) {
+	f := newSFTPFixture(t)
+	require.NoError(t, f.db.Model(f.user).Updates(map[string]any{"totp_enabled": true, "totp_secret": "JBSWY3DPEHPK3PXP"}).Error)
+	jwt.ForgetUser(f.user.ID)
+
+	_, err := f.dial(f.user.Email, ssh.Password(sftpTestPassword))
+	assert.Error(t, err, "the account password alone skips the second factor")
+
+	secret, err := utils.GenerateAppPassword()
+	require.NoError(t, err)
+	require.NoError(t, f.db.Create(&models.AppPassword{UserID: f.user.ID, Name: "partner", Hash: utils.HashToken(secret)}).Error)
+	conn, err := f.dial(f.user.Email, ssh.Password(secret))
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	key := newSSHKey(t)
+	require.NoError(t, f.db.Create(&models.SSHKey{
+		UserID:      f.user.ID,
+		Name:        "partner",
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.PublicKey()))),
+		Fingerprint: ssh.FingerprintSHA256(key.PublicKey()),
+	}).Error)
+	conn, err = f.dial(f.user.Email, ssh.PublicKeys(key))
+	require.NoError(t, err)
+	_ = conn.Close()
+}
+
+func TestSFTP_AppPassword(t *testing.T) {
+	f := newSFTPFixture(t)
+	secret, err := utils.GenerateAppPassword()
+	require.NoError(t, err)
+	require.NoError(t, f.db.Create(&models.AppPassword{UserID: f.user.ID, Name: "partner", Hash: utils.HashToken(secret)}).Error)
+
+	conn, err := f.dial(f.user.Email, ssh.Password(secret))
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	other := createDavUser(t, f.db, "Other-Box")
+	_, err = f.dial(other.Email, ssh.Password(secret))
+	assert.Error(t, err, "an app password only works for its own account")
+}
+
+func TestSFTP_PublicKey(t *testing.T) {
+	f := newSFTPFixture(t)
+	key := newSSHKey(t)
+
+	_, err := f.dial(f.user.Email, ssh.PublicKeys(key))
+	assert.Error(t, err, "unregistered key")
+
+	require.NoError(t, f.db.Create(&models.SSHKey{
+		UserID:      f.user.ID,
+		Name:        "partner",
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key.PublicKey()))),
+		Fingerprint: ssh.FingerprintSHA256(key.PublicKey()),
+	}).Error)
+
+	conn, err := f.dial(f.user.Email, ssh.PublicKeys(key))
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	other := createDavUser(t, f.db, "Other-Box")
+	_, err = f.dial(other.Email, ssh.PublicKeys(key))
+	assert.Error(t, err, "a key only works for the account that registered it")
+
+	var sk models.SSHKey
+	require.NoError(t, f.db.First(&sk).Error)
+	assert.NotNil(t, sk.LastUsedAt)
+}
+
+// --- file operations ---
+
+func TestSFTP_RootListsOwnBoxes(t *testing.T) {
+	f := newSFTPFixture(t)
+	createDavUser(t, f.db, "Someone-Elses")
+	c := f.client(t)
+
+	infos, err := c.ReadDir("/")
+	require.NoError(t, err)
+	var names []string
+	for _, fi := range infos {
+		assert.True(t, fi.IsDir())
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"Archive", "Test-Box"}, names)
+
+	_, err = c.Stat("/Someone-Elses")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSFTP_WriteAndReadBack(t *testing.T) {
+	f := newSFTPFixture(t)
+	c := f.client(t)
+
+	// Several MB so the client pipelines out-of-order writes and reads and the
+	// upload goes through multipart.
+	data := make([]byte, 6*1024*1024+123)
+	_, _ = rand.Read(data)
+	sftpWrite(t, c, "/Test-Box/blob.bin", data)
+
+	fi, err := c.Stat("/Test-Box/blob.bin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), fi.Size())
+	assert.Equal(t, int64(len(data)), boxSize(t, f.db, f.user.ID, "Test-Box"))
+	assert.True(t, bytes.Equal(data, sftpRead(t, c, "/Test-Box/blob.bin")), "downloaded bytes differ")
+
+	// Seeking around inside the file.
+	file, err := c.Open("/Test-Box/blob.bin")
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+	buf := make([]byte, 100)
+	_, err = file.ReadAt(buf, 5*1024*1024)
+	require.NoError(t, err)
+	assert.Equal(t, data[5*1024*1024:5*1024*1024+100], buf)
+	_, err = file.ReadAt(buf, 10)
+	require.NoError(t, err)
+	assert.Equal(t, data[10:110], buf)
+}
+
+func TestSFTP_OverwriteReplacesFile(t *testing.T) {
+	f := newSFTPFixture(t)
+	c := f.client(t)
+
+	sftpWrite(t, c, "/Test-Box/notes.txt", []byte("first version"))
+	sftpWrite(t, c, "/Test-Box/notes.txt", []byte("v2"))
+
+	assert.Equal(t, "v2", string(sftpRead(t, c, "/Test-Box/notes.txt")))
+	assert.Equal(t, int64(2), boxSize(t, f.db, f.user.ID, "Test-Box"))
+	assert.Len(t, f.fake.keys(), 1, "the replaced object is deleted")
+}
+
+func TestSFTP_EmptyFile(t *testing.T) {
+	f := newSFTPFixture(t)
+	c := f.client(t)
+
+	file, err := c.Create("/Test-Box/empty")
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	fi, err := c.Stat("/Test-Box/empty")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), fi.Size())
+}
+
+func TestSFTP_WriteIntoMissingFolderFails(t *testing.T) {
+	f := newSFTPFixture(t)
+	c := f.client(t)
+
+	_, err := c.Create("/Test-Box/missing/file.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = c.Create("/No-Such-Box/file.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSFTP_MkdirRenameRemove(t *testing.T) {
+	f := newSFTPFixture(t)
+	c := f.client(t)
+
+	require.NoError(t, c.Mkdir("/Test-Box/inbox"))
+	sftpWrite(t, c, "/Test-Box/inbox/report.csv", []byte("a,b,c"))
+
+	infos, err := c.ReadDir("/Test-Box/inbox")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "report.csv", infos[0].Name())
+
+	assert.Error(t, c.RemoveDirectory("/Test-Box/inbox"), "rmdir refuses a non-empty folder")
+	assert.Error(t, c.Remove("/Test-Box/inbox"), "rm refuses a folder")
+
+	// Rename across boxes moves the bytes' accounting along with the file.
+	require.NoError(t, c.Rename("/Test-Box/inbox/report.csv", "/Archive/report-2024.csv"))
+	assert.Equal(t, "a,b,c", string(sftpRead(t, c, "/Archive/report-2024.csv")))
+	assert.Equal(t, int64(0), boxSize(t, f.db, f.user.ID, "Test-Box"))
+	assert.Equal(t, int64(5), boxSize(t, f.db, f.user.ID, "Archive"))
+
+	require.NoError(t, c.RemoveDirectory("/Test-Box/inbox"))
+	require.NoError(t, c.Remove("/Archive/report-2024.csv"))
+	_, err = c.Stat("/Archive/report-2024.csv")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Equal(t, int64(0), boxSize(t, f.db, f.user.ID, "Archive"))
+}
+
+func TestSFTP_CannotChangeBoxes(t *testing.T) {
+	f := newSFTPFixture(t)
+	c := f.client(t)
+
+	assert.Error(t, c.Mkdir("/New-Box"))
+	assert.Error(t, c.RemoveDirectory("/Archive"))
+	assert.Error(t, c.Rename("/Archive", "/Renamed"))
+	_, err := c.Create("/top-level.txt")
+	assert.Error(t, err)
+}
+
+func TestSFTP_CannotReachOtherUsersBoxes(t *testing.T) {
+	f := newSFTPFixture(t)
+	createDavUser(t, f.db, "Secret-Box")
+	c := f.client(t)
+
+	_, err := c.ReadDir("/Test-Box/../../Secret-Box")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = c.Create("/Secret-Box/planted.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// --- SSH key management ---
+
+func TestSSHKeys_CreateListRemove(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(jwt.Authenticate(db))
+	r.GET("/ssh-keys", func(c *gin.Context) { user.ListSSHKeys(c, db) })
+	r.POST("/ssh-keys", func(c *gin.Context) { user.CreateSSHKey(c, db) })
+	r.DELETE("/ssh-keys/:id", func(c *gin.Context) { user.RemoveSSHKey(c, db) })
+
+	pub := string(ssh.MarshalAuthorizedKey(newSSHKey(t).PublicKey()))
+
+	w, _ := appPasswordRequest(r, "POST", "/ssh-keys", authHeader(t, u), map[string]string{"name": "bad", "public_key": "not a key"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, created := appPasswordRequest(r, "POST", "/ssh-keys", authHeader(t, u), map[string]string{"name": "partner", "public_key": pub + " comment@host"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "ssh-ed25519", created["type"])
+	fingerprint, _ := created["fingerprint"].(string)
+	assert.True(t, strings.HasPrefix(fingerprint, "SHA256:"))
+
+	other := createDavUser(t, db, "Other-Box")
+	w, _ = appPasswordRequest(r, "POST", "/ssh-keys", authHeader(t, other), map[string]string{"name": "stolen", "public_key": pub})
+	assert.Equal(t, http.StatusConflict, w.Code, "a key can only belong to one account")
+
+	w, _ = appPasswordRequest(r, "GET", "/ssh-keys", authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), fingerprint)
+
+	id := fmt.Sprintf("%.0f", created["id"].(float64))
+	w, _ = appPasswordRequest(r, "DELETE", "/ssh-keys/"+id, authHeader(t, other), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = appPasswordRequest(r, "DELETE", "/ssh-keys/"+id, authHeader(t, u), nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var count int64
+	db.Unscoped().Model(&models.SSHKey{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
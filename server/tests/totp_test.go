@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/handlers/user"
+	"github.com/nimbus/api/middleware/ratelimit"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// totpCode returns the authenticator code for secret, offset periods from now.
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("totp code: %v", err)
+	}
+	return code
+}
+
+// enableTOTP turns on two-factor authentication for the seeded session user
+// and returns the secret. The confirmation spends the current time step, so
+// later logins use the next one.
+func enableTOTP(t *testing.T, r *gin.Engine, auth string) string {
+	t.Helper()
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/totp", auth, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("enroll: %d %s", w.Code, w.Body.String())
+	}
+	secret := body["secret"].(string)
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/totp/confirm", auth, map[string]string{"code": totpCode(t, secret, 0)})
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm: %d %s", w.Code, w.Body.String())
+	}
+	return secret
+}
+
+// firstLogin logs the seeded session user in for the first time and returns
+// the session and the recovery codes the account is issued with it, as the
+// seeded account has none yet.
+func firstLogin(t *testing.T, r *gin.Engine) (string, []string) {
+	t.Helper()
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Test123!@#"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	var codes []string
+	for _, c := range body["recovery_codes"].([]any) {
+		codes = append(codes, c.(string))
+	}
+	return "Bearer " + body["token"].(string), codes
+}
+
+// loginChallenge logs the seeded user in and returns the two-factor challenge.
+func loginChallenge(t *testing.T, r *gin.Engine) string {
+	t.Helper()
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/users/login", "",
+		map[string]string{"email": "session@example.com", "password": "Test123!@#", "device": "laptop"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body.String())
+	}
+	assert.Equal(t, true, body["mfa_required"])
+	assert.Nil(t, body["token"], "no token before the second factor")
+	return body["challenge"].(string)
+}
+
+func loginTOTP(r *gin.Engine, challenge, code string) (int, map[string]any) {
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/users/login/totp", "",
+		map[string]string{"challenge": challenge, "code": code})
+	return w.Code, body
+}
+
+func TestTOTP_CodeMatchesRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 key "12345678901234567890", last six digits.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for unix, want := range map[int64]string{59: "287082", 1111111109: "081804", 2000000000: "279037"} {
+		got, err := utils.TOTPCode(secret, unix/30)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, "T=%d", unix)
+	}
+
+	step, ok := utils.ValidateTOTP(secret, "287082", time.Unix(59+30, 0))
+	assert.True(t, ok, "the previous period is still accepted")
+	assert.Equal(t, int64(1), step)
+	_, ok = utils.ValidateTOTP(secret, "287082", time.Unix(59+90, 0))
+	assert.False(t, ok)
+}
+
+func TestTOTP_EnrollmentNeedsConfirmation(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+
+	w, body := appPasswordRequest(r, "POST", "/v1/api/auth/totp", auth, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	secret := body["secret"].(string)
+	assert.True(t, strings.HasPrefix(body["uri"].(string), "otpauth://totp/Nimbus:session@example.com?"))
+	assert.Contains(t, body["uri"], "secret="+secret)
+
+	// Until confirmed, logging in still works with just the password.
+	sessionLogin(t, r, "desktop")
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/totp/confirm", auth, map[string]string{"code": "000000"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The first login issued recovery codes; with all of them spent, the
+	// confirmation hands out a new set.
+	db.Model(&models.RecoveryCode{}).Where("used_at IS NULL").Update("used_at", time.Now())
+	w, body = appPasswordRequest(r, "POST", "/v1/api/auth/totp/confirm", auth, map[string]string{"code": totpCode(t, secret, 0)})
+	assert.Equal(t, http.StatusOK, w.Code)
+	codes, _ := body["recovery_codes"].([]any)
+	assert.Len(t, codes, user.RECOVERY_CODE_COUNT)
+
+	var stored []models.RecoveryCode
+	db.Find(&stored)
+	assert.Len(t, stored, user.RECOVERY_CODE_COUNT)
+	assert.Equal(t, utils.HashToken(utils.NormalizeRecoveryCode(codes[0].(string))), stored[0].Hash)
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/totp", auth, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestTOTP_LoginRequiresCode(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	secret := enableTOTP(t, r, auth)
+
+	challenge := loginChallenge(t, r)
+	code, body := loginTOTP(r, challenge, "000000")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "Invalid code", body["error"])
+
+	next := totpCode(t, secret, 1)
+	code, body = loginTOTP(r, challenge, next)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, body["token"])
+	assert.NotEmpty(t, body["refresh_token"])
+	assert.True(t, tokenWorks(r, "Bearer "+body["token"].(string)))
+
+	var s models.Session
+	assert.NoError(t, db.Order("id DESC").First(&s).Error)
+	assert.Equal(t, "laptop", s.Device)
+
+	// The challenge is spent, and the code can't be replayed on a new one.
+	code, _ = loginTOTP(r, challenge, next)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = loginTOTP(r, loginChallenge(t, r), next)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTOTP_RecoveryCodeWorksOnce(t *testing.T) {
+	_, r := setupSessionTest(t)
+	auth, codes := firstLogin(t, r)
+	enableTOTP(t, r, auth)
+
+	// Recovery codes are accepted however they're typed.
+	code, _ := loginTOTP(r, loginChallenge(t, r), strings.ToUpper(strings.ReplaceAll(codes[3], "-", " ")))
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = loginTOTP(r, loginChallenge(t, r), codes[3])
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTOTP_ChallengeLimitsAttemptsAndExpires(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, _ := sessionLogin(t, r, "laptop")
+	secret := enableTOTP(t, r, auth)
+
+	challenge := loginChallenge(t, r)
+	for i := 0; i < user.MAX_CHALLENGE_ATTEMPTS; i++ {
+		loginTOTP(r, challenge, "000000")
+	}
+	code, body := loginTOTP(r, challenge, totpCode(t, secret, 1))
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "Too many attempts, please log in again", body["error"])
+
+	challenge = loginChallenge(t, r)
+	db.Model(&models.LoginChallenge{}).Where("1 = 1").Update("expires_at", time.Now().Add(-time.Second))
+	code, _ = loginTOTP(r, challenge, totpCode(t, secret, 1))
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestTOTP_CodeStepIsRateLimited(t *testing.T) {
+	db := setupLoginDB(t)
+	seedLoginUser(t, db, "session@example.com", "Test123!@#")
+	seed := sessionRouter(db)
+	auth, _ := sessionLogin(t, seed, "laptop")
+	enableTOTP(t, seed, auth)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	routes.InitUserRoutes(r, s3db.Config{}, db, ratelimit.New(2, time.Minute))
+
+	challenge := loginChallenge(t, r)
+	loginTOTP(r, challenge, "000000")
+	loginTOTP(r, challenge, "000000")
+	code, _ := loginTOTP(r, challenge, "000000")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+}
+
+func TestTOTP_DisableNeedsCode(t *testing.T) {
+	db, r := setupSessionTest(t)
+	auth, codes := firstLogin(t, r)
+	enableTOTP(t, r, auth)
+
+	w, _ := appPasswordRequest(r, "POST", "/v1/api/auth/totp/disable", auth, map[string]string{"code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, _ = appPasswordRequest(r, "POST", "/v1/api/auth/totp/disable", auth, map[string]string{"code": codes[0]})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var u models.User
+	db.First(&u)
+	assert.False(t, u.TOTPEnabled)
+	assert.Empty(t, u.TOTPSecret)
+	// The recovery codes stay for password resets, minus the one spent.
+	var n int64
+	db.Model(&models.RecoveryCode{}).Where("used_at IS NULL").Count(&n)
+	assert.Equal(t, int64(len(codes)-1), n)
+
+	// Password alone is enough again.
+	sessionLogin(t, r, "desktop")
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	s3db "github.com/nimbus/api/db/s3"
+	"github.com/nimbus/api/middleware/requestid"
+	"github.com/nimbus/api/models"
+	"github.com/nimbus/api/routes"
+	"github.com/nimbus/api/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+)
+
+// callerTraceparent is a W3C traceparent header as the CLI would send it.
+const (
+	callerTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	callerTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+)
+
+// captureSpans records every span ended until the test ends.
+func captureSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	// With no exporter configured Setup only installs the propagator.
+	_, err := tracing.Setup(context.Background(), false)
+	require.NoError(t, err)
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
+
+// findSpan returns the first span named name.
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+// spanAttr returns the value of a span's attribute key.
+func spanAttr(s *tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// tracingRouter serves the box and file routes against config behind the
+// production request ID and tracing middleware, with db's queries traced.
+func tracingRouter(t *testing.T, db *gorm.DB, config s3db.Config) *gin.Engine {
+	t.Helper()
+	require.NoError(t, tracing.InstrumentDB(db))
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(requestid.Middleware())
+	r.Use(tracing.Middleware())
+	r.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	routes.InitBoxRoutes(r, config, db)
+	routes.InitFileRoutes(r, config, db)
+	return r
+}
+
+func TestTracing_RequestJoinsCallersTrace(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	_, cfg := newFakeS3(t)
+	var box models.Box
+	require.NoError(t, db.Where("user_id = ?", u.ID).First(&box).Error)
+	require.NoError(t, db.Create(&models.File{Name: "a.txt", S3Key: "trace-test-key", UserID: u.ID, BoxID: box.ID, Confirmed: true}).Error)
+	exporter := captureSpans(t)
+	r := tracingRouter(t, db, cfg)
+
+	req := httptest.NewRequest("DELETE", "/v1/api/files/trace-test-key", nil)
+	req.Header.Set("Authorization", authHeader(t, u))
+	req.Header.Set("traceparent", callerTraceparent)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	spans := exporter.GetSpans()
+	root := findSpan(spans, "DELETE /v1/api/files/:name")
+	require.NotNil(t, root, "no request span in %v", spans.Snapshots())
+	assert.Equal(t, callerTraceID, root.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", root.Parent.SpanID().String(), "the request span continues the caller's")
+	assert.EqualValues(t, u.ID, spanAttr(root, tracing.UserIDKey).AsInt64())
+
+	s3Span := findSpan(spans, "S3.DeleteObject")
+	require.NotNil(t, s3Span)
+	assert.Equal(t, root.SpanContext.SpanID(), s3Span.Parent.SpanID())
+	var queries int
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("span %q is outside the request's trace", s.Name)
+		}
+		if s.InstrumentationScope.Name == "gorm.io/plugin/opentelemetry" {
+			queries++
+		}
+	}
+	assert.Positive(t, queries, "the handler's queries are traced")
+
+	// Health checks aren't.
+	exporter.Reset()
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, exporter.GetSpans())
+}
+
+func TestTracing_SpanNamesBox(t *testing.T) {
+	db := setupDavDB(t)
+	u := createDavUser(t, db, "Test-Box")
+	_, cfg := newFakeS3(t)
+	var box models.Box
+	require.NoError(t, db.Where("user_id = ?", u.ID).First(&box).Error)
+	require.NoError(t, db.Create(&models.File{Name: "a.txt", S3Key: "trace-box-key", UserID: u.ID, BoxID: box.ID, Confirmed: true}).Error)
+	exporter := captureSpans(t)
+	r := tracingRouter(t, db, cfg)
+
+	w, _ := appPasswordRequest(r, "GET", "/v1/api/files/presign-download?box_name=Test-Box&key=a.txt", authHeader(t, u), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	spans := exporter.GetSpans()
+	root := findSpan(spans, "GET /v1/api/files/presign-download")
+	require.NotNil(t, root, "no request span in %v", spans.Snapshots())
+	assert.Equal(t, "Test-Box", spanAttr(root, tracing.BoxKey).AsString())
+	assert.NotNil(t, findSpan(spans, "S3.GetObject"), "presigning gets a span")
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -12,6 +13,7 @@ import (
 	"github.com/nimbus/api/models"
 	"github.com/nimbus/api/utils"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -22,7 +24,8 @@ func setupLoginDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("failed to open test db: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.Session{}, &models.RefreshToken{},
+		&models.RecoveryCode{}, &models.LoginChallenge{}, &models.AuditEvent{}); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 	return db
@@ -31,14 +34,12 @@ func setupLoginDB(t *testing.T) *gorm.DB {
 func seedLoginUser(t *testing.T, db *gorm.DB, email, password string) *models.User {
 	t.Helper()
 	hash, _ := utils.PasswordHash(password)
-	passKeyHash, _ := utils.PasswordHash("1234")
 	userID, _ := utils.GenerateUserID()
 	boxID, _ := utils.GenerateSecureID()
 	u := &models.User{
 		ID:       userID,
 		Email:    email,
 		Password: hash,
-		PassKey:  passKeyHash,
 		Boxes:    []models.Box{{Name: "Home-Box", BoxID: boxID}},
 	}
 	if err := db.Create(u).Error; err != nil {
@@ -180,3 +181,54 @@ func TestLogin_CaseSensitiveEmail(t *testing.T) {
 	// Email lookup is case-sensitive (raw SQL WHERE clause), so uppercase should not match
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
+
+func TestLogin_UpgradesBcryptHash(t *testing.T) {
+	db := setupLoginDB(t)
+	u := seedLoginUser(t, db, "legacy@example.com", "Test123!@#")
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("Test123!@#"), bcrypt.MinCost)
+	db.Model(u).Update("password", string(legacy))
+	r := loginRouter(db)
+
+	login := func(password string) int {
+		body, _ := json.Marshal(map[string]string{"email": "legacy@example.com", "password": password})
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// A wrong password leaves the hash alone.
+	assert.Equal(t, http.StatusUnauthorized, login("Wrong123!@#"))
+	var stored models.User
+	db.First(&stored, u.ID)
+	assert.Equal(t, string(legacy), stored.Password)
+
+	assert.Equal(t, http.StatusOK, login("Test123!@#"))
+	db.First(&stored, u.ID)
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"), stored.Password)
+	assert.False(t, utils.PasswordNeedsRehash(stored.Password))
+
+	// And the upgraded hash works.
+	assert.Equal(t, http.StatusOK, login("Test123!@#"))
+}
+
+func TestLogin_LongPassphrase(t *testing.T) {
+	db := setupLoginDB(t)
+	passphrase := strings.Repeat("Correct horse battery staple 1! ", 8) // 256 bytes
+	seedLoginUser(t, db, "long@example.com", passphrase)
+	r := loginRouter(db)
+
+	for password, want := range map[string]int{
+		passphrase:                http.StatusOK,
+		passphrase[:72]:           http.StatusUnauthorized,
+		strings.Repeat("a", 1025): http.StatusBadRequest,
+	} {
+		body, _ := json.Marshal(map[string]string{"email": "long@example.com", "password": password})
+		req, _ := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code, len(password))
+	}
+}
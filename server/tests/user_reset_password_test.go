@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -36,45 +37,71 @@ func doReset(r *gin.Engine, payload map[string]string) *httptest.ResponseRecorde
 	return w
 }
 
+// seedRecoveryCodes gives userID a set of recovery codes, as registration
+// does, and returns them.
+func seedRecoveryCodes(t *testing.T, db *gorm.DB, userID uint) []string {
+	t.Helper()
+	var codes []string
+	for range user.RECOVERY_CODE_COUNT {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			t.Fatalf("recovery code: %v", err)
+		}
+		codes = append(codes, code)
+		db.Create(&models.RecoveryCode{UserID: userID, Hash: utils.HashToken(utils.NormalizeRecoveryCode(code))})
+	}
+	return codes
+}
+
 func TestResetPassword_Success(t *testing.T) {
 	db := setupLoginDB(t)
-	// seedLoginUser sets the passkey to "1234".
-	seedLoginUser(t, db, "reset@example.com", "OldPass123!@#")
+	u := seedLoginUser(t, db, "reset@example.com", "OldPass123!@#")
+	codes := seedRecoveryCodes(t, db, u.ID)
 	r := resetRouter(db)
 
 	w := doReset(r, map[string]string{
-		"email":        "reset@example.com",
-		"passkey":      "1234",
-		"new_password": "NewPass456!@#",
+		"email":         "reset@example.com",
+		"recovery_code": codes[0],
+		"new_password":  "NewPass456!@#",
 	})
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	var resp map[string]string
+	var resp map[string]any
 	json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.Equal(t, "Password reset successful", resp["message"])
+	assert.Equal(t, float64(user.RECOVERY_CODE_COUNT-1), resp["recovery_codes_remaining"])
 
 	// The new password hash must now be stored, and the old one must not verify.
 	var updated models.User
 	db.Where("email = ?", "reset@example.com").First(&updated)
 	assert.True(t, utils.VerifyPasswordHash("NewPass456!@#", updated.Password))
 	assert.False(t, utils.VerifyPasswordHash("OldPass123!@#", updated.Password))
+
+	// The code is spent.
+	w = doReset(r, map[string]string{
+		"email":         "reset@example.com",
+		"recovery_code": codes[0],
+		"new_password":  "Newer789!@#",
+	})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
 
-func TestResetPassword_WrongPasskey(t *testing.T) {
+func TestResetPassword_WrongCode(t *testing.T) {
 	db := setupLoginDB(t)
-	seedLoginUser(t, db, "reset2@example.com", "OldPass123!@#")
+	u := seedLoginUser(t, db, "reset2@example.com", "OldPass123!@#")
+	seedRecoveryCodes(t, db, u.ID)
 	r := resetRouter(db)
 
 	w := doReset(r, map[string]string{
-		"email":        "reset2@example.com",
-		"passkey":      "9999",
-		"new_password": "NewPass456!@#",
+		"email":         "reset2@example.com",
+		"recovery_code": "aaaa-bbbb-cccc-dddd",
+		"new_password":  "NewPass456!@#",
 	})
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 	var resp map[string]string
 	json.Unmarshal(w.Body.Bytes(), &resp)
-	assert.Equal(t, "Invalid email or passkey", resp["error"])
+	assert.Equal(t, "Invalid email or recovery code", resp["error"])
 
 	// Password must be unchanged.
 	var unchanged models.User
@@ -86,49 +113,67 @@ func TestResetPassword_UnknownEmail(t *testing.T) {
 	db := setupLoginDB(t)
 	r := resetRouter(db)
 
-	// Same generic error as a wrong passkey — no email enumeration.
 	w := doReset(r, map[string]string{
-		"email":        "ghost@example.com",
-		"passkey":      "1234",
-		"new_password": "NewPass456!@#",
+		"email":         "ghost@example.com",
+		"recovery_code": "aaaa-bbbb-cccc-dddd",
+		"new_password":  "NewPass456!@#",
 	})
 
+	// Same generic error as a wrong code — no email enumeration.
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 	var resp map[string]string
 	json.Unmarshal(w.Body.Bytes(), &resp)
-	assert.Equal(t, "Invalid email or passkey", resp["error"])
+	assert.Equal(t, "Invalid email or recovery code", resp["error"])
 }
 
 func TestResetPassword_WeakNewPassword(t *testing.T) {
 	db := setupLoginDB(t)
-	seedLoginUser(t, db, "reset3@example.com", "OldPass123!@#")
+	u := seedLoginUser(t, db, "reset3@example.com", "OldPass123!@#")
+	codes := seedRecoveryCodes(t, db, u.ID)
 	r := resetRouter(db)
 
 	// Missing uppercase, number, and symbol.
 	w := doReset(r, map[string]string{
-		"email":        "reset3@example.com",
-		"passkey":      "1234",
-		"new_password": "weakpassword",
+		"email":         "reset3@example.com",
+		"recovery_code": codes[0],
+		"new_password":  "weakpassword",
 	})
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Too long: the message states the current limits.
+	w = doReset(r, map[string]string{
+		"email":         "reset3@example.com",
+		"recovery_code": codes[0],
+		"new_password":  "Aa1!" + strings.Repeat("x", user.MAX_PASSWORD_LENGTH),
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var resp map[string]string
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, "Password must be between 8 and 1024 characters long", resp["error"])
 }
 
 func TestResetPassword_SameAsOld(t *testing.T) {
 	db := setupLoginDB(t)
-	seedLoginUser(t, db, "reset4@example.com", "OldPass123!@#")
+	u := seedLoginUser(t, db, "reset4@example.com", "OldPass123!@#")
+	codes := seedRecoveryCodes(t, db, u.ID)
 	r := resetRouter(db)
 
 	w := doReset(r, map[string]string{
-		"email":        "reset4@example.com",
-		"passkey":      "1234",
-		"new_password": "OldPass123!@#",
+		"email":         "reset4@example.com",
+		"recovery_code": codes[0],
+		"new_password":  "OldPass123!@#",
 	})
 
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 	var resp map[string]string
 	json.Unmarshal(w.Body.Bytes(), &resp)
 	assert.Equal(t, "New password must be different from the current password", resp["error"])
+
+	// The rejected request didn't spend the code.
+	var rc models.RecoveryCode
+	db.Where("hash = ?", utils.HashToken(utils.NormalizeRecoveryCode(codes[0]))).First(&rc)
+	assert.Nil(t, rc.UsedAt)
 }
 
 func TestResetPassword_MissingFields(t *testing.T) {
@@ -136,9 +181,9 @@ func TestResetPassword_MissingFields(t *testing.T) {
 	r := resetRouter(db)
 
 	cases := []map[string]string{
-		{"passkey": "1234", "new_password": "NewPass456!@#"},        // no email
-		{"email": "x@example.com", "new_password": "NewPass456!@#"}, // no passkey
-		{"email": "x@example.com", "passkey": "1234"},               // no new password
+		{"recovery_code": "aaaa-bbbb-cccc-dddd", "new_password": "NewPass456!@#"}, // no email
+		{"email": "x@example.com", "new_password": "NewPass456!@#"},               // no recovery code
+		{"email": "x@example.com", "recovery_code": "aaaa-bbbb-cccc-dddd"},        // no new password
 		{}, // nothing
 	}
 	for _, payload := range cases {
@@ -146,17 +191,3 @@ func TestResetPassword_MissingFields(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	}
 }
-
-func TestResetPassword_WrongPasskeyLength(t *testing.T) {
-	db := setupLoginDB(t)
-	seedLoginUser(t, db, "reset5@example.com", "OldPass123!@#")
-	r := resetRouter(db)
-
-	w := doReset(r, map[string]string{
-		"email":        "reset5@example.com",
-		"passkey":      "12345", // 5 chars, must be exactly 4
-		"new_password": "NewPass456!@#",
-	})
-
-	assert.Equal(t, http.StatusBadRequest, w.Code)
-}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -23,7 +24,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	}
 
 	// Auto-migrate the schema
-	err = db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{})
+	err = db.AutoMigrate(&models.User{}, &models.Box{}, &models.Folder{}, &models.File{}, &models.Session{}, &models.RecoveryCode{})
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
@@ -44,7 +45,6 @@ func TestUserRegister_Success(t *testing.T) {
 	reqBody := map[string]string{
 		"email":    "test@example.com",
 		"password": "Test123!@#",
-		"passkey":  "1234",
 	}
 	body, _ := json.Marshal(reqBody)
 
@@ -60,6 +60,7 @@ func TestUserRegister_Success(t *testing.T) {
 	json.Unmarshal(w.Body.Bytes(), &response)
 	assert.Equal(t, "User registered successfully", response["message"])
 	assert.Equal(t, "test@example.com", response["email"])
+	assert.Len(t, response["recovery_codes"], user.RECOVERY_CODE_COUNT)
 }
 
 // TestUserRegister_MissingFields tests registration with missing required fields
@@ -79,18 +80,13 @@ func TestUserRegister_MissingFields(t *testing.T) {
 	}{
 		{
 			name:     "Missing email",
-			reqBody:  map[string]string{"password": "Test123!@#", "passkey": "1234"},
-			expected: "Email, password, and passkey are required",
+			reqBody:  map[string]string{"password": "Test123!@#"},
+			expected: "Email and password are required",
 		},
 		{
 			name:     "Missing password",
-			reqBody:  map[string]string{"email": "test@example.com", "passkey": "1234"},
-			expected: "Email, password, and passkey are required",
-		},
-		{
-			name:     "Missing passkey",
-			reqBody:  map[string]string{"email": "test@example.com", "password": "Test123!@#"},
-			expected: "Email, password, and passkey are required",
+			reqBody:  map[string]string{"email": "test@example.com"},
+			expected: "Email and password are required",
 		},
 	}
 
@@ -135,7 +131,6 @@ func TestUserRegister_InvalidEmail(t *testing.T) {
 			reqBody := map[string]string{
 				"email":    email,
 				"password": "Test123!@#",
-				"passkey":  "1234",
 			}
 			body, _ := json.Marshal(reqBody)
 			req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(body))
@@ -180,7 +175,6 @@ func TestUserRegister_WeakPassword(t *testing.T) {
 			reqBody := map[string]string{
 				"email":    "test@example.com",
 				"password": tc.password,
-				"passkey":  "1234",
 			}
 			body, _ := json.Marshal(reqBody)
 			req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(body))
@@ -207,7 +201,6 @@ func TestUserRegister_DuplicateEmail(t *testing.T) {
 	reqBody := map[string]string{
 		"email":    "duplicate@example.com",
 		"password": "Test123!@#",
-		"passkey":  "1234",
 	}
 
 	// First registration - should succeed
@@ -242,13 +235,12 @@ func TestUserRegister_PasswordTooLong(t *testing.T) {
 		user.Register(c, db, nil)
 	})
 
-	// Create a password longer than 72 characters
-	longPassword := "Test123!@#" + string(make([]byte, 70))
+	// Create a password longer than MAX_PASSWORD_LENGTH
+	longPassword := "Test123!@#" + strings.Repeat("a", user.MAX_PASSWORD_LENGTH)
 
 	reqBody := map[string]string{
 		"email":    "test@example.com",
 		"password": longPassword,
-		"passkey":  "1234",
 	}
 	body, _ := json.Marshal(reqBody)
 	req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(body))
@@ -277,7 +269,6 @@ func TestUserRegister_HomeBoxCreation(t *testing.T) {
 	reqBody := map[string]string{
 		"email":    "test@example.com",
 		"password": "Test123!@#",
-		"passkey":  "1234",
 	}
 	body, _ := json.Marshal(reqBody)
 	req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(body))
@@ -0,0 +1,134 @@
+// Package tracing sends OpenTelemetry traces of the API's work: a span per
+// HTTP request, with child spans for the database queries, S3 calls and Redis
+// commands made while serving it, so a slow request shows where its time went.
+// Requests carrying a W3C traceparent header, like those from the CLI, join
+// the caller's trace.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	otelgorm "gorm.io/plugin/opentelemetry/tracing"
+)
+
+// ServiceName names the API in traces unless OTEL_SERVICE_NAME overrides it.
+const ServiceName = "nimbus-api"
+
+// Span attributes naming who and what a request acted on.
+const (
+	UserIDKey = attribute.Key("nimbus.user_id")
+	BoxKey    = attribute.Key("nimbus.box")
+)
+
+const instrumentation = "github.com/nimbus/api/tracing"
+
+// Setup installs the tracer provider every span is made with. Spans are
+// exported over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set, else written to stdout as JSON
+// when localDev is true, else not recorded at all. The standard OTEL_* variables
+// tune the exporter and sampler. The returned function flushes spans that
+// haven't been exported yet and must be called before exit.
+func Setup(ctx context.Context, localDev bool) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch {
+	case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "":
+		exporter, err = otlptracehttp.New(ctx)
+	case localDev:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return install(ctx, sdktrace.WithBatcher(exporter))
+}
+
+func install(ctx context.Context, opts ...sdktrace.TracerProviderOption) (func(context.Context) error, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Middleware starts a span for each request, named after its route template,
+// continuing the trace in the request's traceparent header when there is one.
+// Health checks and metric scrapes aren't traced.
+func Middleware() gin.HandlerFunc {
+	return otelgin.Middleware(ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		return c.FullPath() != "/health" && c.FullPath() != "/metrics"
+	}))
+}
+
+// SetUser records the user a request authenticated as on its span.
+func SetUser(ctx context.Context, id uint) {
+	trace.SpanFromContext(ctx).SetAttributes(UserIDKey.Int64(int64(id)))
+}
+
+// SetBox records the box a request acted on on its span.
+func SetBox(ctx context.Context, box string) {
+	if box != "" {
+		trace.SpanFromContext(ctx).SetAttributes(BoxKey.String(box))
+	}
+}
+
+// InstrumentDB traces the queries db runs with a request's context as part of
+// that request, without their parameters.
+func InstrumentDB(db *gorm.DB) error {
+	return db.Use(otelgorm.NewPlugin(otelgorm.WithoutMetrics(), otelgorm.WithoutQueryVariables()))
+}
+
+// InstrumentS3 is an s3.Options function that wraps every S3 call in a span
+// named after its operation, e.g. "S3.PutObject", covering any retries.
+// Presigning a URL makes no call but still gets a (short) span.
+func InstrumentS3(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("NimbusTracing",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				op := awsmiddleware.GetOperationName(ctx)
+				ctx, span := otel.Tracer(instrumentation).Start(ctx, "S3."+op,
+					trace.WithSpanKind(trace.SpanKindClient),
+					trace.WithAttributes(
+						attribute.String("rpc.system", "aws-api"),
+						attribute.String("rpc.service", "S3"),
+						attribute.String("rpc.method", op),
+					))
+				defer span.End()
+
+				out, md, err := next.HandleInitialize(ctx, in)
+				if id, ok := awsmiddleware.GetRequestIDMetadata(md); ok {
+					span.SetAttributes(attribute.String("aws.request_id", id))
+				}
+				if err != nil {
+					span.RecordError(err)
+					span.SetStatus(codes.Error, err.Error())
+				}
+				return out, md, err
+			}), middleware.After)
+	})
+}
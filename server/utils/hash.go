@@ -2,29 +2,146 @@ package utils
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// PasswordHash hashes a plain-text password using bcrypt with cost 14.
-// Cost 14 is deliberately slow — it makes brute-force attacks expensive even
-// if an attacker obtains the hash. Always store the result, never the
-// original password.
+// Argon2Params are the Argon2id cost parameters used for new password hashes.
+// Memory is in KiB; Time is the number of passes over it and Threads the
+// parallelism. They're encoded in every hash, so raising them only affects
+// new hashes, and older ones are upgraded on the next login.
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// DefaultArgon2Params are 64 MiB, 3 passes and 2 lanes: about 100ms per hash
+// on a small instance, against roughly a second for bcrypt at cost 14.
+var DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 2}
+
+const (
+	argon2SaltBytes = 16
+	argon2KeyBytes  = 32
+	argon2Prefix    = "$argon2id$"
+)
+
+var (
+	argon2Params = DefaultArgon2Params
+
+	// dummyHash is a hash with the current parameters, verified against when
+	// an account doesn't exist (see VerifyDummyPassword).
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// String returns the parameters in PHC form, e.g. "m=65536,t=3,p=2".
+func (p Argon2Params) String() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Time, p.Threads)
+}
+
+// ParseArgon2Params reads parameters in PHC form ("m=65536,t=3,p=2"), as
+// stored in a hash or given in ARGON2_PARAMS.
+func ParseArgon2Params(s string) (Argon2Params, error) {
+	var p Argon2Params
+	if _, err := fmt.Sscanf(s, "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil || p.String() != s {
+		return p, fmt.Errorf("argon2 parameters %q must look like m=65536,t=3,p=2", s)
+	}
+	if p.Time < 1 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) {
+		return p, fmt.Errorf("argon2 parameters %q are out of range", s)
+	}
+	return p, nil
+}
+
+// SetArgon2Params sets the parameters for new hashes. Call it at startup,
+// before any password is hashed.
+func SetArgon2Params(p Argon2Params) {
+	argon2Params = p
+	dummyHashOnce = sync.Once{}
+}
+
+// PasswordHash hashes a plain-text password with Argon2id and a random salt,
+// encoded in the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// Argon2id is memory-hard, which makes GPU brute-forcing of a stolen hash
+// expensive. Always store the result, never the original password.
 func PasswordHash(pw string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(pw), 14)
-	if err != nil {
+	salt := make([]byte, argon2SaltBytes)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	return string(bytes), nil
+	p := argon2Params
+	key := argon2.IDKey([]byte(pw), salt, p.Time, p.Memory, p.Threads, argon2KeyBytes)
+	b64 := base64.RawStdEncoding.EncodeToString
+	return fmt.Sprintf("%sv=%d$%s$%s$%s", argon2Prefix, argon2.Version, p, b64(salt), b64(key)), nil
 }
 
-// VerifyPasswordHash compares a plain-text password against a bcrypt hash.
-// Returns true only if the password produced the hash. bcrypt's timing is
-// constant regardless of where the strings differ, which prevents timing attacks.
+// VerifyPasswordHash compares a plain-text password against a hash from
+// PasswordHash, or a bcrypt hash from before Argon2id. Returns true only if
+// the password produced the hash. Both comparisons take constant time
+// regardless of where the values differ, which prevents timing attacks.
 func VerifyPasswordHash(pw, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
-	return err == nil
+	if !strings.HasPrefix(hash, argon2Prefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw)) == nil
+	}
+	p, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(pw), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+// PasswordNeedsRehash reports whether hash should be replaced by a fresh
+// PasswordHash: it's bcrypt, or Argon2id with other parameters than the
+// current ones.
+func PasswordNeedsRehash(hash string) bool {
+	p, _, _, err := decodeArgon2Hash(hash)
+	return err != nil || p != argon2Params
+}
+
+// VerifyDummyPassword runs a comparison as slow as VerifyPasswordHash with
+// the current parameters, for when an account doesn't exist, so its absence
+// can't be told from the response time.
+func VerifyDummyPassword(pw string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = PasswordHash("nimbus-dummy-password")
+	})
+	VerifyPasswordHash(pw, dummyHash)
+}
+
+// decodeArgon2Hash splits a PHC-format Argon2id hash into its parameters,
+// salt and key.
+func decodeArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return Argon2Params{}, nil, nil, fmt.Errorf("not an argon2id hash")
+	}
+	p, err := ParseArgon2Params(parts[3])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+	return p, salt, key, nil
 }
 
 // GenerateSecureID generates a cryptographically random uint suitable for use
@@ -55,3 +172,136 @@ func GenerateUserID() (uint, error) {
 	id = (id % 90000000) + 10000000
 	return uint(id), nil
 }
+
+// GenerateAppPassword returns a new random app password: 20 bytes from
+// crypto/rand, base32-encoded and split into dash-separated groups of four so
+// it is easy to copy into a file manager's login dialog
+// (e.g. "abcd-efgh-ijkl-mnop-qrst-uvwx-yz23-4567").
+func GenerateAppPassword() (string, error) {
+	var b [20]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	raw := strings.ToLower(base32.StdEncoding.EncodeToString(b[:]))
+	groups := make([]string, 0, len(raw)/4)
+	for i := 0; i < len(raw); i += 4 {
+		groups = append(groups, raw[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// HashToken returns the hex SHA-256 of a machine-generated secret. Unlike user
+// passwords these secrets carry 100+ bits of entropy, so a fast hash is safe
+// and lets them be checked on every request (WebDAV clients send credentials
+// with each call) without bcrypt's deliberate cost.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateS3AccessKey returns a new access key ID and secret for the S3
+// gateway, shaped like AWS's own (a 20-character uppercase ID starting with
+// "NIM" and a 40-character secret) so tools that sanity-check key formats
+// accept them.
+func GenerateS3AccessKey() (id, secret string, err error) {
+	var idBytes [11]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return "", "", err
+	}
+	var secretBytes [30]byte
+	if _, err := rand.Read(secretBytes[:]); err != nil {
+		return "", "", err
+	}
+	id = "NIM" + base32.StdEncoding.EncodeToString(idBytes[:])[:17]
+	secret = base64.StdEncoding.EncodeToString(secretBytes[:])
+	return id, secret, nil
+}
+
+// PersonalAccessTokenPrefix starts every personal access token, so tokens are
+// recognisable in logs and by secret scanners, and the auth middleware can
+// tell them apart from JWTs without parsing.
+const PersonalAccessTokenPrefix = "nim_pat_"
+
+// GeneratePersonalAccessToken returns a new personal access token: the prefix
+// followed by 32 random bytes, base32-encoded in lower case.
+func GeneratePersonalAccessToken() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b[:])), nil
+}
+
+// RefreshTokenPrefix starts every refresh token, for the same reasons as
+// PersonalAccessTokenPrefix.
+const RefreshTokenPrefix = "nim_rt_"
+
+// GenerateRefreshToken returns a new refresh token: the prefix followed by 32
+// random bytes, base32-encoded in lower case.
+func GenerateRefreshToken() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return RefreshTokenPrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b[:])), nil
+}
+
+// GenerateTokenID returns 16 random bytes as hex, for identifiers that must be
+// unguessable but are not secrets on their own (JWT IDs, session IDs).
+func GenerateTokenID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// DeviceCodePrefix starts every device code a CLI polls with during single
+// sign-on, for the same reasons as PersonalAccessTokenPrefix.
+const DeviceCodePrefix = "nim_dc_"
+
+// GenerateDeviceCode returns a new device code: the prefix followed by 32
+// random bytes, base32-encoded in lower case.
+func GenerateDeviceCode() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return DeviceCodePrefix + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b[:])), nil
+}
+
+// userCodeAlphabet has no vowels (so codes can't spell words) and no
+// characters that are easily confused, as RFC 8628 recommends.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// GenerateUserCode returns a short code for a person to type into a browser,
+// shaped "XXXX-XXXX" (about 34 bits).
+func GenerateUserCode() (string, error) {
+	out := make([]byte, 0, 9)
+	var b [1]byte
+	for len(out) < 9 {
+		if len(out) == 4 {
+			out = append(out, '-')
+			continue
+		}
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", err
+		}
+		// Reject the top of the range so every letter is equally likely.
+		if int(b[0]) >= 256-256%len(userCodeAlphabet) {
+			continue
+		}
+		out = append(out, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+	}
+	return string(out), nil
+}
+
+// NormalizeUserCode puts a user code as typed into the form GenerateUserCode
+// returns.
+func NormalizeUserCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(strings.TrimSpace(code)))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
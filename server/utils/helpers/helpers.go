@@ -4,6 +4,7 @@
 package helpers
 
 import (
+	"errors"
 	"fmt"
 	"path"
 	"path/filepath"
@@ -12,34 +13,38 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nimbus/api/apierr"
 	"github.com/nimbus/api/models"
 	"gorm.io/gorm"
 )
 
 // ValidateBoxOwnership looks up a box by name and owner. It returns the Box
-// record so callers can use its ID without a second query, or an error if the
-// box doesn't exist or belongs to a different user.
+// record so callers can use its ID without a second query, or a BOX_NOT_FOUND
+// *apierr.Error if the box doesn't exist or belongs to a different user.
 func ValidateBoxOwnership(db *gorm.DB, boxName string, userID uint) (*models.Box, error) {
 	var box models.Box
 	if err := db.Where("name = ? AND user_id = ?", boxName, userID).First(&box).Error; err != nil {
-		return nil, fmt.Errorf("box not found or access denied")
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, apierr.New(apierr.BoxNotFound, "box not found")
+		}
+		return nil, fmt.Errorf("looking up box: %w", err)
 	}
 	return &box, nil
 }
 
-// GenerateS3Key builds the full S3 object key for a file being uploaded.
-// The format is:
+// GenerateS3Key builds the full S3 object key for a file being uploaded into
+// box. The format is:
 //
-//	users/nim-user-<userID>/boxes/<boxName>/<filePath>/<filename>_<unix_timestamp>
+//	<box.S3Prefix><filePath>/<filename>_<unix_timestamp>
 //
 // The timestamp suffix prevents collisions when the same filename is uploaded
 // to the same path multiple times.
-func GenerateS3Key(filePath, filename, boxName string, user *models.User) (string, error) {
-	fullFilePathPrefix := fmt.Sprintf("users/nim-user-%d/boxes/%s", user.ID, boxName)
+func GenerateS3Key(filePath, filename string, box *models.Box) (string, error) {
+	fullFilePathPrefix := strings.TrimSuffix(box.S3Prefix, "/")
 	base := filepath.Base(filename)
 	base = strings.ReplaceAll(base, " ", "_")
 	if base == "" {
-		return "", fmt.Errorf("invalid filename")
+		return "", apierr.New(apierr.InvalidRequest, "invalid filename")
 	}
 
 	// Sanitize filePath: clean away any ../ traversal sequences, then confirm
@@ -47,7 +52,7 @@ func GenerateS3Key(filePath, filename, boxName string, user *models.User) (strin
 	// user's own prefix space in S3.
 	cleaned := path.Clean("/" + filePath)
 	if strings.Contains(cleaned, "..") {
-		return "", fmt.Errorf("invalid filePath: path traversal not allowed")
+		return "", apierr.New(apierr.InvalidRequest, "invalid filePath: path traversal not allowed")
 	}
 
 	timestamp := time.Now().Unix()
@@ -95,46 +100,25 @@ func GetBoxID(db *gorm.DB, boxName string, userID uint) uint {
 }
 
 // GetParentFolderID resolves a slash-separated path string to the database ID
-// of the deepest folder in that path. It walks the folder tree one segment at
-// a time, always scoping to the correct box and parent.
+// of the deepest folder in that path. Folders store their full path, so this
+// is a single query scoped to the user's box.
 //
 // Returns nil when path is empty (meaning "the root of the box"), or nil if
-// any segment in the path doesn't exist.
+// the folder doesn't exist.
 //
-// Example: path "documents/projects" walks:
-//  1. Find "documents" at root → get its ID
-//  2. Find "projects" whose parent is "documents" → return its ID
+// Example: path "documents/projects" returns the ID of the "projects" folder
+// inside "documents".
 func GetParentFolderID(db *gorm.DB, userID uint, boxName string, path string) *uint {
-	if path == "" || path == "/" {
+	path = strings.Trim(path, "/")
+	if path == "" {
 		return nil
 	}
 
-	boxID := GetBoxID(db, boxName, userID)
-	if boxID == 0 {
+	var folder models.Folder
+	boxID := db.Session(&gorm.Session{NewDB: true}).Model(&models.Box{}).Select("id").Where("name = ? AND user_id = ?", boxName, userID)
+	res := db.Where("path = ? AND user_id = ? AND box_id = (?)", path, userID, boxID).Limit(1).Find(&folder)
+	if res.Error != nil || res.RowsAffected == 0 {
 		return nil
 	}
-
-	path = strings.Trim(path, "/")
-	segments := strings.Split(path, "/")
-
-	var currentParentID *uint = nil
-
-	for _, segment := range segments {
-		var folder models.Folder
-		query := db.Where("name = ? AND user_id = ? AND box_id = ?", segment, userID, boxID)
-
-		if currentParentID == nil {
-			query = query.Where("parent_id IS NULL")
-		} else {
-			query = query.Where("parent_id = ?", *currentParentID)
-		}
-
-		if err := query.First(&folder).Error; err != nil {
-			return nil
-		}
-
-		currentParentID = &folder.ID
-	}
-
-	return currentParentID
+	return &folder.ID
 }
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). These are the defaults every authenticator app
+// assumes, so the otpauth URI doesn't need to spell them out for it to work.
+const (
+	TOTPPeriod = 30 * time.Second
+	TOTPDigits = 6
+)
+
+// totpSkew is how many periods either side of now a code is still accepted,
+// to allow for clock drift and the time it takes to type the code.
+const totpSkew = 1
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new 160-bit TOTP secret, base32-encoded as
+// authenticator apps expect.
+func GenerateTOTPSecret() (string, error) {
+	var b [20]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b[:]), nil
+}
+
+// TOTPURI returns the otpauth:// URI that authenticator apps import, either
+// from a QR code or pasted by hand.
+func TOTPURI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("digits", fmt.Sprint(TOTPDigits))
+	q.Set("period", fmt.Sprint(int(TOTPPeriod.Seconds())))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + q.Encode()
+}
+
+// TOTPStep returns the time step t falls in.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// TOTPCode returns the code for secret at the given time step.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range TOTPDigits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod), nil
+}
+
+// ValidateTOTP checks code against secret around now and returns the time
+// step it matched. Callers should reject steps at or before the last one
+// accepted, so a code can't be replayed within its window.
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := TOTPStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		want, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCode returns a new single-use recovery code: 10 random
+// bytes, base32-encoded in lower case and split into groups of four
+// (e.g. "abcd-efgh-ijkl-mnop").
+func GenerateRecoveryCode() (string, error) {
+	var b [10]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	raw := strings.ToLower(totpEncoding.EncodeToString(b[:]))
+	return raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16], nil
+}
+
+// NormalizeRecoveryCode puts a recovery code as typed by a user into the form
+// that was hashed: lower case with the dashes and spaces removed.
+func NormalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+// LoginChallengePrefix starts every two-factor login challenge, for the same
+// reasons as PersonalAccessTokenPrefix.
+const LoginChallengePrefix = "nim_mfa_"
+
+// GenerateLoginChallenge returns a new login challenge: the prefix followed by
+// 32 random bytes, base32-encoded in lower case.
+func GenerateLoginChallenge() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return LoginChallengePrefix + strings.ToLower(totpEncoding.EncodeToString(b[:])), nil
+}